@@ -0,0 +1,295 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+
+	"example.com/random_coffee/database"
+	"example.com/random_coffee/pkg/pairing"
+	"github.com/NicoNex/echotron/v3"
+	"github.com/rs/zerolog/log"
+)
+
+// avoidLimit is how many people one user may avoid per group, so nobody can steer their own matches
+const avoidLimit = 3
+
+// avoidSecret keys the hashes avoidances are stored as; without it the feature is off
+func avoidSecret() []byte {
+	return []byte(os.Getenv("AVOID_SECRET"))
+}
+
+func avoidHash(secret []byte, kind string, ids ...int64) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(kind))
+	for _, id := range ids {
+		fmt.Fprintf(mac, ":%d", id)
+	}
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// avoidPairHash identifies an unordered couple within a group
+func avoidPairHash(secret []byte, groupID, a, b int64) string {
+	if a > b {
+		a, b = b, a
+	}
+	return avoidHash(secret, "pair", groupID, a, b)
+}
+
+// avoidOwnerHash identifies who declared an avoidance within a group
+func avoidOwnerHash(secret []byte, groupID, userID int64) string {
+	return avoidHash(secret, "owner", groupID, userID)
+}
+
+// avoidedCouples holds the couples of a pairing run that must not meet, in both orders
+type avoidedCouples map[[2]int64]bool
+
+// loadAvoidedCouples finds which couples of the participants were declared as avoided.
+// The stored hashes are matched by hashing every couple of this week's participants.
+func loadAvoidedCouples(ctx context.Context, db *sql.DB, groupID int64, participants []database.Participant) avoidedCouples {
+	secret := avoidSecret()
+	if len(secret) == 0 {
+		return nil
+	}
+
+	hashes, err := database.GetAvoidedPairHashes(ctx, db, groupID)
+	if err != nil {
+		groupEvent(log.Error(), EventAvoidFailed, groupID).Err(err).Msg("GetAvoidedPairHashes failed, personal exclusions ignored")
+		return nil
+	}
+	if len(hashes) == 0 {
+		return nil
+	}
+	stored := make(map[string]bool, len(hashes))
+	for _, h := range hashes {
+		stored[h] = true
+	}
+
+	avoided := make(avoidedCouples)
+	for i, p := range participants {
+		for _, q := range participants[i+1:] {
+			if stored[avoidPairHash(secret, groupID, p.UserID, q.UserID)] {
+				avoided[[2]int64{p.UserID, q.UserID}] = true
+				avoided[[2]int64{q.UserID, p.UserID}] = true
+			}
+		}
+	}
+	return avoided
+}
+
+// filter drops avoided couples from candidate pairs, so the matcher never proposes them
+func (a avoidedCouples) filter(pairs [][2]database.Participant) [][2]database.Participant {
+	if len(a) == 0 {
+		return pairs
+	}
+	kept := make([][2]database.Participant, 0, len(pairs))
+	for _, pair := range pairs {
+		if !a[[2]int64{pair[0].UserID, pair[1].UserID}] {
+			kept = append(kept, pair)
+		}
+	}
+	return kept
+}
+
+// processors returns the post-processor that also keeps avoided couples out of trios and of swaps
+// made by other processors; none when nothing is avoided
+func (a avoidedCouples) processors() []pairing.PostProcessor {
+	if len(a) == 0 {
+		return nil
+	}
+	couples := make([][2]int64, 0, len(a)/2)
+	for c := range a {
+		if c[0] < c[1] {
+			couples = append(couples, c)
+		}
+	}
+	return []pairing.PostProcessor{privateExclusions{pairing.NewExclusions(couples)}}
+}
+
+// privateExclusions is an Exclusions processor whose notes don't name the users, since run notes are logged
+type privateExclusions struct {
+	exclusions *pairing.Exclusions
+}
+
+func (p privateExclusions) Adjust(ctx context.Context, proposal pairing.Proposal, snapshot pairing.Snapshot) (pairing.Proposal, error) {
+	before := len(proposal.Notes)
+	adjusted, err := p.exclusions.Adjust(ctx, proposal, snapshot)
+	if err != nil {
+		return adjusted, err
+	}
+	for i := before; i < len(adjusted.Notes); i++ {
+		adjusted.Notes[i] = "personal exclusion: meeting changed"
+	}
+	return adjusted, nil
+}
+
+// parseAvoidArgs splits "/avoid [@username] [group_id]" arguments into the username and the group part
+func parseAvoidArgs(args []string) (string, []string) {
+	if len(args) > 0 && strings.HasPrefix(args[0], "@") {
+		return strings.TrimPrefix(args[0], "@"), args[1:]
+	}
+	return "", args
+}
+
+// handleAvoidCommand implements /avoid @username [group_id] in a private chat; without a username it lists
+// the user's own avoidances. Nobody else, admins included, can see who is avoided.
+func handleAvoidCommand(ctx context.Context, db *sql.DB, api echotron.API, message *echotron.Message, args []string, lang string) {
+	chatID := message.Chat.ID
+	userID := message.From.ID
+
+	secret := avoidSecret()
+	if len(secret) == 0 {
+		sendMessage(api, tr(lang, "avoid.disabled"), chatID)
+		return
+	}
+
+	username, groupArgs := parseAvoidArgs(args)
+	groupID, ok := resolveVolunteerGroup(ctx, db, groupArgs)
+	if !ok {
+		sendMessage(api, tr(lang, "volunteer.bad_group")+"\n\n"+tr(lang, "avoid.usage"), chatID)
+		return
+	}
+	ownerHash := avoidOwnerHash(secret, groupID, userID)
+
+	owned, err := database.GetOwnerAvoidances(ctx, db, groupID, ownerHash)
+	if err != nil {
+		userEvent(log.Error(), EventAvoidFailed, groupID, userID).Err(err).Msg("GetOwnerAvoidances failed")
+		sendMessage(api, tr(lang, "avoid.failed"), chatID)
+		return
+	}
+
+	if username == "" {
+		sendMessage(api, formatOwnAvoidances(ctx, db, secret, groupID, userID, owned, lang), chatID)
+		return
+	}
+
+	target, ok := resolveAvoidTarget(ctx, db, api, message, username, lang)
+	if !ok {
+		return
+	}
+
+	pairHash := avoidPairHash(secret, groupID, userID, target.UserID)
+	for _, h := range owned {
+		if h == pairHash {
+			sendMessage(api, tr(lang, "avoid.added"), chatID)
+			return
+		}
+	}
+	if len(owned) >= avoidLimit {
+		sendMessage(api, fmt.Sprintf(tr(lang, "avoid.limit"), avoidLimit), chatID)
+		return
+	}
+
+	if err := database.AddAvoidance(ctx, db, groupID, ownerHash, pairHash); err != nil {
+		userEvent(log.Error(), EventAvoidFailed, groupID, userID).Err(err).Msg("AddAvoidance failed")
+		sendMessage(api, tr(lang, "avoid.failed"), chatID)
+		return
+	}
+	// The other party is deliberately not logged
+	userEvent(log.Info(), EventAvoidChanged, groupID, userID).Str("action", "add").Msg("Personal exclusion declared")
+	sendMessage(api, tr(lang, "avoid.added"), chatID)
+}
+
+// handleUnavoidCommand implements /unavoid @username [group_id] in a private chat
+func handleUnavoidCommand(ctx context.Context, db *sql.DB, api echotron.API, message *echotron.Message, args []string, lang string) {
+	chatID := message.Chat.ID
+	userID := message.From.ID
+
+	secret := avoidSecret()
+	if len(secret) == 0 {
+		sendMessage(api, tr(lang, "avoid.disabled"), chatID)
+		return
+	}
+
+	username, groupArgs := parseAvoidArgs(args)
+	if username == "" {
+		sendMessage(api, tr(lang, "avoid.usage"), chatID)
+		return
+	}
+	groupID, ok := resolveVolunteerGroup(ctx, db, groupArgs)
+	if !ok {
+		sendMessage(api, tr(lang, "volunteer.bad_group")+"\n\n"+tr(lang, "avoid.usage"), chatID)
+		return
+	}
+	target, ok := resolveAvoidTarget(ctx, db, api, message, username, lang)
+	if !ok {
+		return
+	}
+
+	removed, err := database.DeleteAvoidance(ctx, db, groupID, avoidOwnerHash(secret, groupID, userID),
+		avoidPairHash(secret, groupID, userID, target.UserID))
+	if err != nil {
+		userEvent(log.Error(), EventAvoidFailed, groupID, userID).Err(err).Msg("DeleteAvoidance failed")
+		sendMessage(api, tr(lang, "avoid.failed"), chatID)
+		return
+	}
+	if !removed {
+		sendMessage(api, tr(lang, "avoid.not_found"), chatID)
+		return
+	}
+	userEvent(log.Info(), EventAvoidChanged, groupID, userID).Str("action", "remove").Msg("Personal exclusion removed")
+	sendMessage(api, tr(lang, "avoid.removed"), chatID)
+}
+
+// resolveAvoidTarget finds the user to avoid by username, replying when that's not possible
+func resolveAvoidTarget(ctx context.Context, db *sql.DB, api echotron.API, message *echotron.Message, username, lang string) (*database.UserProfile, bool) {
+	target, err := database.GetUserProfileByUsername(ctx, db, username)
+	if err != nil {
+		botEvent(log.Error(), EventAvoidFailed).Err(err).Msg("GetUserProfileByUsername failed")
+		sendMessage(api, tr(lang, "avoid.failed"), message.Chat.ID)
+		return nil, false
+	}
+	if target == nil {
+		sendMessage(api, tr(lang, "avoid.unknown_user"), message.Chat.ID)
+		return nil, false
+	}
+	if target.UserID == message.From.ID {
+		sendMessage(api, tr(lang, "avoid.usage"), message.Chat.ID)
+		return nil, false
+	}
+	return target, true
+}
+
+// formatOwnAvoidances lists whom the user avoids in the group. The hashes are matched against every
+// known user; people the bot no longer knows are only counted.
+func formatOwnAvoidances(ctx context.Context, db *sql.DB, secret []byte, groupID, userID int64, owned []string, lang string) string {
+	if len(owned) == 0 {
+		return tr(lang, "avoid.none") + "\n\n" + tr(lang, "avoid.usage")
+	}
+
+	wanted := make(map[string]bool, len(owned))
+	for _, h := range owned {
+		wanted[h] = true
+	}
+
+	ids, err := database.GetProfileUserIDs(ctx, db)
+	if err != nil {
+		userEvent(log.Error(), EventAvoidFailed, groupID, userID).Err(err).Msg("GetProfileUserIDs failed")
+		return tr(lang, "avoid.failed")
+	}
+	found := make([]int64, 0, len(owned))
+	for _, id := range ids {
+		if id != userID && wanted[avoidPairHash(secret, groupID, userID, id)] {
+			found = append(found, id)
+		}
+	}
+	profiles, err := database.GetUserProfiles(ctx, db, found)
+	if err != nil {
+		userEvent(log.Error(), EventAvoidFailed, groupID, userID).Err(err).Msg("GetUserProfiles failed")
+		return tr(lang, "avoid.failed")
+	}
+
+	text := fmt.Sprintf(tr(lang, "avoid.list"), len(owned), avoidLimit) + "\n"
+	for _, id := range found {
+		text += "• " + getProfileDisplayName(profiles[id]) + "\n"
+	}
+	if unknown := len(owned) - len(found); unknown > 0 {
+		text += fmt.Sprintf(tr(lang, "avoid.list_unknown"), unknown) + "\n"
+	}
+	return text
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"example.com/random_coffee/database"
+	"github.com/NicoNex/echotron/v3"
+)
+
+func TestAvoidanceStoredAsHashesOnly(t *testing.T) {
+	// IDs long enough to be told apart from anything else in a row
+	const me, other = 5550001, 7770002
+	db := openTestDB(t)
+	tg, api := newFakeTelegram(t)
+	seedTestGroup(t, db, testGroupID, "Coffee")
+	ctx := context.Background()
+	t.Setenv("AVOID_SECRET", "test-secret")
+
+	for _, u := range []database.UserProfile{
+		{UserID: me, Username: "me", UpdatedAt: time.Now()},
+		{UserID: other, Username: "other", UpdatedAt: time.Now()},
+	} {
+		if err := database.UpsertUserProfile(ctx, db, u); err != nil {
+			t.Fatalf("UpsertUserProfile: %v", err)
+		}
+	}
+	private := func(handle func(ctx context.Context, db *sql.DB, api echotron.API, message *echotron.Message, args []string, lang string), args ...string) string {
+		t.Helper()
+		handle(ctx, db, api, &echotron.Message{Chat: echotron.Chat{ID: me, Type: "private"}, From: &echotron.User{ID: me}}, args, "ru")
+		sent := tg.sent(me)
+		return sent[len(sent)-1]
+	}
+
+	if reply := private(handleAvoidCommand, "@other"); reply != tr("ru", "avoid.added") {
+		t.Fatalf("/avoid @other: %q", reply)
+	}
+
+	// Every column of every row, as SQLite has it
+	rows, err := db.QueryContext(ctx, `SELECT group_id, owner_hash, pair_hash, created_at FROM avoidance`)
+	if err != nil {
+		t.Fatalf("select avoidance: %v", err)
+	}
+	defer rows.Close()
+	hexHash := regexp.MustCompile(`^[0-9a-f]{64}$`)
+	n := 0
+	for rows.Next() {
+		var groupID int64
+		var owner, pair, createdAt string
+		if err := rows.Scan(&groupID, &owner, &pair, &createdAt); err != nil {
+			t.Fatalf("scan avoidance: %v", err)
+		}
+		n++
+		for _, id := range []int64{me, other} {
+			if strings.Contains(owner+pair+createdAt, strconv.FormatInt(id, 10)) || groupID == id {
+				t.Fatalf("row (%d, %q, %q, %q) holds user %d", groupID, owner, pair, createdAt, id)
+			}
+		}
+		if !hexHash.MatchString(owner) || !hexHash.MatchString(pair) {
+			t.Fatalf("owner %q and pair %q, want HMAC-SHA256 hex digests", owner, pair)
+		}
+		// The hashes are keyed: without the secret they can't be recomputed from a guessed couple
+		secret := []byte("test-secret")
+		if owner != avoidOwnerHash(secret, testGroupID, me) || pair != avoidPairHash(secret, testGroupID, other, me) {
+			t.Fatal("stored hashes don't match the declared couple")
+		}
+		if pair == avoidPairHash(nil, testGroupID, me, other) || owner == avoidOwnerHash(nil, testGroupID, me) {
+			t.Fatal("stored hashes don't depend on the secret")
+		}
+	}
+	if err := rows.Err(); err != nil || n != 1 {
+		t.Fatalf("avoidance has %d rows (%v), want 1", n, err)
+	}
+
+	// Only the owner can read it back
+	if list := private(handleAvoidCommand); !strings.Contains(list, "@other") {
+		t.Fatalf("/avoid list: %q", list)
+	}
+	if reply := private(handleUnavoidCommand, "@other"); reply != tr("ru", "avoid.removed") {
+		t.Fatalf("/unavoid @other: %q", reply)
+	}
+	if count, err := database.CountAvoidances(ctx, db, testGroupID); err != nil || count != 0 {
+		t.Fatalf("CountAvoidances = %d, %v after /unavoid", count, err)
+	}
+}
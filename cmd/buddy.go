@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"example.com/random_coffee/database"
+	"github.com/NicoNex/echotron/v3"
+	"github.com/rs/zerolog/log"
+)
+
+// buddyCohort marks who takes part in the current cycle for the first time and who volunteers to meet them
+type buddyCohort struct {
+	firstTimers map[int64]bool
+	volunteers  map[int64]bool
+}
+
+// isBuddy reports whether the couple matches a first-timer with a volunteer who has been paired before
+func (c buddyCohort) isBuddy(a, b int64) bool {
+	return (c.firstTimers[a] && c.volunteers[b] && !c.firstTimers[b]) ||
+		(c.firstTimers[b] && c.volunteers[a] && !c.firstTimers[a])
+}
+
+// loadBuddyCohort finds first-timers (never paired in the group) among participants and the group's volunteers
+func loadBuddyCohort(ctx context.Context, db *sql.DB, groupID int64, participants []database.Participant) (buddyCohort, error) {
+	cohort := buddyCohort{firstTimers: make(map[int64]bool), volunteers: make(map[int64]bool)}
+
+	paired, err := database.GetPairedUserIDs(ctx, db, groupID)
+	if err != nil {
+		return cohort, fmt.Errorf("failed to get paired users: %w", err)
+	}
+	for _, p := range participants {
+		if !paired[p.UserID] {
+			cohort.firstTimers[p.UserID] = true
+		}
+	}
+
+	volunteers, err := database.GetVolunteers(ctx, db, groupID)
+	if err != nil {
+		return cohort, fmt.Errorf("failed to get volunteers: %w", err)
+	}
+	for _, id := range volunteers {
+		cohort.volunteers[id] = true
+	}
+
+	return cohort, nil
+}
+
+// notifyBuddies tells each volunteer matched with first-timers who their partners are
+func notifyBuddies(ctx context.Context, db *sql.DB, api echotron.API, groupID int64, finalPairs [][]database.Participant, cohort buddyCohort, theme string) {
+	matched := 0
+	for _, pair := range finalPairs {
+		volunteers := make([]database.Participant, 0, len(pair))
+		newcomers := make([]string, 0, len(pair))
+		for _, p := range pair {
+			switch {
+			case cohort.firstTimers[p.UserID]:
+				newcomers = append(newcomers, getDisplayName(p))
+			case cohort.volunteers[p.UserID]:
+				volunteers = append(volunteers, p)
+			}
+		}
+		if len(volunteers) == 0 || len(newcomers) == 0 {
+			continue
+		}
+
+		text := withTheme(fmt.Sprintf("☕️ На этой неделе ты встречаешься с %s.%s\n\n"+
+			"🌱 Это первый Random Coffee твоего собеседника — помоги освоиться!", strings.Join(newcomers, " и "), themePlaceholder),
+			"\n🎨 Тема недели: %s", theme)
+		for _, v := range volunteers {
+			sendGroupDM(ctx, db, api, groupID, v.UserID, text)
+		}
+		matched++
+	}
+
+	if matched > 0 {
+		groupEvent(log.Info(), EventBuddyMatched, groupID).Int("buddy_pairs", matched).Msg("First-timers matched with volunteers")
+	}
+}
+
+// resolveVolunteerGroup picks the group a /volunteer command applies to: the given one,
+// or the only configured group when none is given
+func resolveVolunteerGroup(ctx context.Context, db *sql.DB, args []string) (int64, bool) {
+	if len(args) > 0 {
+		groupID, err := strconv.ParseInt(args[0], 10, 64)
+		if err != nil || !isConfiguredGroup(ctx, db, groupID) {
+			return 0, false
+		}
+		return groupID, true
+	}
+
+	groups := getConfiguredGroups(ctx, db)
+	if len(groups) != 1 {
+		return 0, false
+	}
+	return groups[0], true
+}
+
+// handleVolunteerCommand implements /volunteer on|off [group_id] in a private chat
+func handleVolunteerCommand(ctx context.Context, db *sql.DB, api echotron.API, message *echotron.Message, args []string, lang string) {
+	chatID := message.Chat.ID
+	userID := message.From.ID
+
+	usage := tr(lang, "volunteer.usage")
+	if len(args) == 0 || (args[0] != "on" && args[0] != "off") {
+		sendMessage(api, usage, chatID)
+		return
+	}
+
+	groupID, ok := resolveVolunteerGroup(ctx, db, args[1:])
+	if !ok {
+		sendMessage(api, tr(lang, "volunteer.bad_group")+"\n\n"+usage, chatID)
+		return
+	}
+
+	var err error
+	if args[0] == "on" {
+		err = database.SetVolunteer(ctx, db, groupID, userID)
+	} else {
+		err = database.DeleteVolunteer(ctx, db, groupID, userID)
+	}
+	if err != nil {
+		userEvent(log.Error(), EventVolunteerSaveFailed, groupID, userID).Err(err).Msg("Failed to save volunteer flag")
+		sendMessage(api, tr(lang, "volunteer.save_failed"), chatID)
+		return
+	}
+
+	userEvent(log.Info(), EventVolunteerChanged, groupID, userID).Str("state", args[0]).Msg("Volunteer flag changed")
+	if args[0] == "on" {
+		sendMessage(api, tr(lang, "volunteer.on"), chatID)
+	} else {
+		sendMessage(api, tr(lang, "volunteer.off"), chatID)
+	}
+}
+
+// handleVolunteersCommand lists volunteers of every configured group for admins
+func handleVolunteersCommand(ctx context.Context, db *sql.DB, api echotron.API, message *echotron.Message) {
+	chatID := message.Chat.ID
+	if !isAdmin(message.From.ID) {
+		sendMessage(api, "❌ Доступ запрещен", chatID)
+		return
+	}
+
+	groupIDs := getConfiguredGroups(ctx, db)
+	if len(groupIDs) == 0 {
+		sendMessage(api, "Нет подключенных групп", chatID)
+		return
+	}
+
+	text := "🌱 Волонтеры для новичков:\n"
+	for _, gid := range groupIDs {
+		volunteers, err := database.GetVolunteers(ctx, db, gid)
+		if err != nil {
+			groupEvent(log.Error(), EventVolunteerQueryFailed, gid).Err(err).Msg("GetVolunteers failed")
+			text += fmt.Sprintf("\nГруппа %d: ошибка загрузки\n", gid)
+			continue
+		}
+		if len(volunteers) == 0 {
+			text += fmt.Sprintf("\nГруппа %d: нет волонтеров\n", gid)
+			continue
+		}
+
+		profiles, err := database.GetUserProfiles(ctx, db, volunteers)
+		if err != nil {
+			groupEvent(log.Warn(), EventVolunteerQueryFailed, gid).Err(err).Msg("GetUserProfiles failed")
+		}
+
+		text += fmt.Sprintf("\nГруппа %d (%d):\n", gid, len(volunteers))
+		for _, id := range volunteers {
+			if u, ok := profiles[id]; ok {
+				text += fmt.Sprintf("• %s\n", getProfileDisplayName(u))
+			} else {
+				text += fmt.Sprintf("• %d\n", id)
+			}
+		}
+	}
+	sendLongMessage(api, text, chatID)
+}
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"example.com/random_coffee/database"
+	"example.com/random_coffee/pkg/pairing"
+)
+
+// Offline subcommands for what-if analysis of the matcher. Their files use the snapshot
+// schema: participants are []SnapshotParticipant, proposed meetings are []SnapshotPair.
+//
+//	random_coffee export-match-input --group <id> [--out dir] [--anonymize]
+//	random_coffee match --input participants.json [--history history.json] [--config config.json] [--seed N]
+//	random_coffee snapshot-schema
+
+// MatchHistoryPair is a past meeting in the match input
+type MatchHistoryPair struct {
+	WeekStart string `json:"week_start"`
+	SnapshotPair
+}
+
+// MatchConfig is the policy a what-if match runs with; the zero value behaves like production
+type MatchConfig struct {
+	WeekStart         string      `json:"week_start,omitempty"`          // cycle being matched, the current week by default
+	RepeatWindowWeeks int         `json:"repeat_window_weeks,omitempty"` // only meetings this recent count as met; 0 counts all history
+	NoRepeats         bool        `json:"no_repeats,omitempty"`          // never fall back to repeat meetings
+	Volunteers        []string    `json:"volunteers,omitempty"`
+	Exclusions        [][2]string `json:"exclusions,omitempty"`
+	Avoided           [][2]string `json:"avoided,omitempty"` // couples kept apart with /avoid, exported only anonymized
+}
+
+// MatchResult is what match prints
+type MatchResult struct {
+	SchemaVersion int            `json:"schema_version"`
+	Seed          int64          `json:"seed"`
+	WeekStart     string         `json:"week_start"`
+	Pairs         []SnapshotPair `json:"pairs"`
+	Unpaired      []string       `json:"unpaired"`
+	Repeats       MatchRepeats   `json:"repeats"`
+	Trace         []string       `json:"trace"`
+}
+
+// MatchRepeats counts proposed meetings whose members have met before, per the full history
+type MatchRepeats struct {
+	Meetings int `json:"meetings"` // meetings with at least one repeated couple
+	Couples  int `json:"couples"`
+}
+
+// runCLI runs an offline subcommand named by args and exits; it returns false if args name none
+func runCLI(args []string) bool {
+	if len(args) == 0 {
+		return false
+	}
+
+	var err error
+	switch args[0] {
+	case "match":
+		err = runMatchCommand(args[1:], os.Stdout)
+	case "export-match-input":
+		err = runExportMatchInputCommand(args[1:])
+	case "snapshot-schema":
+		err = runSnapshotSchemaCommand(os.Stdout)
+	default:
+		return false
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", args[0], err)
+		os.Exit(1)
+	}
+	os.Exit(0)
+	return true
+}
+
+func readJSONFile(path string, v any) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return nil
+}
+
+func writeJSONFile(path string, v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+// runMatchCommand runs the matcher and the configured post-processors on files instead of the live database
+func runMatchCommand(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("match", flag.ContinueOnError)
+	inputPath := fs.String("input", "", "participants JSON file")
+	historyPath := fs.String("history", "", "pair history JSON file")
+	configPath := fs.String("config", "", "match policy JSON file")
+	seed := fs.Int64("seed", time.Now().UnixNano(), "random seed; the same seed and input give the same result")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *inputPath == "" {
+		return errors.New("--input is required")
+	}
+
+	var input []SnapshotParticipant
+	if err := readJSONFile(*inputPath, &input); err != nil {
+		return err
+	}
+	history := make([]MatchHistoryPair, 0)
+	if *historyPath != "" {
+		if err := readJSONFile(*historyPath, &history); err != nil {
+			return err
+		}
+	}
+	var cfg MatchConfig
+	if *configPath != "" {
+		if err := readJSONFile(*configPath, &cfg); err != nil {
+			return err
+		}
+	}
+
+	result, err := matchOffline(input, history, cfg, *seed)
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(result)
+}
+
+// matchOffline runs pkg/pairing offline on exported files and renders the result in the snapshot schema
+func matchOffline(input []SnapshotParticipant, history []MatchHistoryPair, cfg MatchConfig, seed int64) (*MatchResult, error) {
+	policy := pairing.Policy{
+		WeekStart:         cfg.WeekStart,
+		RepeatWindowWeeks: cfg.RepeatWindowWeeks,
+		NoRepeats:         cfg.NoRepeats,
+		Volunteers:        cfg.Volunteers,
+		Exclusions:        cfg.Exclusions,
+		Avoided:           cfg.Avoided,
+		UnpairedTolerance: unpairedTolerance(),
+	}
+	if policy.WeekStart == "" {
+		policy.WeekStart = getWeekStart(time.Now())
+	}
+
+	participants := make([]string, 0, len(input))
+	for _, p := range input {
+		participants = append(participants, p.ID)
+	}
+	past := make([]pairing.PastMeeting, 0, len(history))
+	for _, h := range history {
+		past = append(past, pairing.PastMeeting{WeekStart: h.WeekStart, Members: h.Members})
+	}
+
+	run, err := pairing.RunOffline(context.Background(), participants, past, policy, seed)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &MatchResult{
+		SchemaVersion: snapshotSchemaVersion,
+		Seed:          seed,
+		WeekStart:     policy.WeekStart,
+		Pairs:         make([]SnapshotPair, 0, len(run.Meetings)),
+		Unpaired:      run.Unpaired,
+		Repeats:       MatchRepeats{Meetings: run.RepeatMeetings, Couples: run.RepeatCouples},
+		Trace:         run.Trace,
+	}
+	for _, m := range run.Meetings {
+		result.Pairs = append(result.Pairs, SnapshotPair{Members: m})
+	}
+	return result, nil
+}
+
+// matchInput is what export-match-input writes
+type matchInput struct {
+	participants []SnapshotParticipant
+	history      []MatchHistoryPair
+	config       MatchConfig
+	avoidOmitted int // avoided couples left out because the export is not anonymized
+}
+
+// exportMatchInput reads a group's current sign-ups, pair history, volunteers and excluded couples.
+// Couples kept apart with /avoid are private: they are only exported with anonymized IDs.
+func exportMatchInput(ctx context.Context, db *sql.DB, groupID int64, cfg snapshotConfig) (*matchInput, error) {
+	participants, err := database.GetAllParticipants(ctx, db, groupID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read participants: %w", err)
+	}
+	var pairs []database.Pair
+	err = database.ForEachPairChunk(ctx, db, groupID, func(chunk []database.Pair) error {
+		pairs = append(pairs, chunk...)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pair history: %w", err)
+	}
+	volunteers, err := database.GetVolunteers(ctx, db, groupID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read volunteers: %w", err)
+	}
+
+	weekStart := getWeekStart(time.Now())
+	in := &matchInput{
+		// Same rendering as the pairing snapshot, so the files line up with the snapshot schema
+		participants: buildPairingSnapshot(cfg, groupID, weekStart, participants, nil, nil).Participants,
+		history:      make([]MatchHistoryPair, 0, len(pairs)),
+	}
+
+	for _, p := range pairs {
+		// Meetings stored with IgnoreHistory count only in their own week, as in GetAvailablePairs
+		if p.IgnoreHistory && p.WeekStart != weekStart {
+			continue
+		}
+		h := MatchHistoryPair{WeekStart: p.WeekStart}
+		for _, id := range p.Members() {
+			h.Members = append(h.Members, cfg.snapshotUserID(id))
+		}
+		in.history = append(in.history, h)
+	}
+
+	for _, id := range volunteers {
+		in.config.Volunteers = append(in.config.Volunteers, cfg.snapshotUserID(id))
+	}
+	for _, c := range parseExclusions("MATCH_EXCLUSIONS") {
+		in.config.Exclusions = append(in.config.Exclusions, [2]string{cfg.snapshotUserID(c[0]), cfg.snapshotUserID(c[1])})
+	}
+
+	avoided := loadAvoidedCouples(ctx, db, groupID, participants)
+	couples := make([][2]int64, 0, len(avoided)/2)
+	for c := range avoided {
+		if c[0] < c[1] {
+			couples = append(couples, c)
+		}
+	}
+	sort.Slice(couples, func(i, j int) bool {
+		return couples[i][0] < couples[j][0] || (couples[i][0] == couples[j][0] && couples[i][1] < couples[j][1])
+	})
+	if !cfg.Anonymize {
+		in.avoidOmitted = len(couples)
+		return in, nil
+	}
+	for _, c := range couples {
+		in.config.Avoided = append(in.config.Avoided, [2]string{cfg.snapshotUserID(c[0]), cfg.snapshotUserID(c[1])})
+	}
+	return in, nil
+}
+
+// matchExportConfig keys --anonymize with a key of its own, so exported files can't be joined with published snapshots
+func matchExportConfig(anonymize bool) (snapshotConfig, error) {
+	cfg := snapshotConfig{Secret: os.Getenv("SNAPSHOT_SECRET"), Anonymize: anonymize, AnonymizeKey: os.Getenv("MATCH_EXPORT_SECRET")}
+	if !anonymize {
+		return cfg, nil
+	}
+	if cfg.AnonymizeKey == "" {
+		return cfg, errors.New("MATCH_EXPORT_SECRET is not set")
+	}
+	if cfg.AnonymizeKey == os.Getenv("ANONYMIZE_SECRET") {
+		return cfg, errors.New("MATCH_EXPORT_SECRET must differ from ANONYMIZE_SECRET")
+	}
+	return cfg, cfg.validate()
+}
+
+// writeMatchInput writes participants.json, history.json and config.json to dir
+func writeMatchInput(dir string, in *matchInput) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	files := map[string]any{"participants.json": in.participants, "history.json": in.history, "config.json": in.config}
+	for name, v := range files {
+		if err := writeJSONFile(filepath.Join(dir, name), v); err != nil {
+			return fmt.Errorf("failed to write %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// runExportMatchInputCommand writes a group's current sign-ups, pair history and policy as match input files
+func runExportMatchInputCommand(args []string) error {
+	fs := flag.NewFlagSet("export-match-input", flag.ContinueOnError)
+	groupID := fs.Int64("group", 0, "group ID")
+	outDir := fs.String("out", ".", "directory for participants.json, history.json and config.json")
+	anonymize := fs.Bool("anonymize", false, "replace user IDs with keyed hashes (MATCH_EXPORT_SECRET), drop names and include /avoid couples")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *groupID == 0 {
+		return errors.New("--group is required")
+	}
+	dbPath := os.Getenv("DB__URL")
+	if dbPath == "" {
+		return errors.New("DB__URL is not set")
+	}
+	cfg, err := matchExportConfig(*anonymize)
+	if err != nil {
+		return err
+	}
+
+	// Read-only, so an export run next to the live bot never takes a write lock
+	ctx := context.Background()
+	db, err := database.OpenReader(ctx, dbPath)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = db.Close() }()
+
+	in, err := exportMatchInput(ctx, db, *groupID, cfg)
+	if err != nil {
+		return err
+	}
+	if err := writeMatchInput(*outDir, in); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "Exported %d participants and %d past meetings of group %d to %s\n",
+		len(in.participants), len(in.history), *groupID, *outDir)
+	if in.avoidOmitted > 0 {
+		fmt.Fprintf(os.Stderr, "Left out %d couples kept apart with /avoid: they are private, export with --anonymize to include them\n", in.avoidOmitted)
+	}
+	return nil
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"example.com/random_coffee/database"
+	"github.com/google/uuid"
+)
+
+const testAvoidSecret = "avoid-key"
+
+// seedMatchGroup signs up users 1-7 with some history: 1 and 2, 3 and 4 have met, 1 volunteers,
+// 1 and 3 are listed in MATCH_EXCLUSIONS and 2 asked with /avoid not to meet 5
+func seedMatchGroup(t *testing.T, db *sql.DB) {
+	t.Helper()
+	ctx := context.Background()
+	t.Setenv("MATCH_EXCLUSIONS", "1:3")
+	t.Setenv("AVOID_SECRET", testAvoidSecret)
+	seedTestGroup(t, db, testGroupID, "Coffee")
+	signUp(t, db, testGroupID, 1, 2, 3, 4, 5, 6, 7)
+
+	pairs := []database.Pair{
+		{ID: uuid.New(), GroupID: testGroupID, WeekStart: "2026-02-16", User1ID: 1, User2ID: 2, CreatedAt: time.Now()},
+		{ID: uuid.New(), GroupID: testGroupID, WeekStart: "2026-02-23", User1ID: 3, User2ID: 4, CreatedAt: time.Now()},
+		// Kept out of the history: an admin said it should not count
+		{ID: uuid.New(), GroupID: testGroupID, WeekStart: "2026-02-23", User1ID: 6, User2ID: 7, CreatedAt: time.Now(), IgnoreHistory: true},
+	}
+	if err := database.CreatePairs(ctx, db, pairs); err != nil {
+		t.Fatalf("CreatePairs: %v", err)
+	}
+	if err := database.SetVolunteer(ctx, db, testGroupID, 1); err != nil {
+		t.Fatalf("SetVolunteer: %v", err)
+	}
+	secret := []byte(testAvoidSecret)
+	if err := database.AddAvoidance(ctx, db, testGroupID, avoidOwnerHash(secret, testGroupID, 2), avoidPairHash(secret, testGroupID, 2, 5)); err != nil {
+		t.Fatalf("AddAvoidance: %v", err)
+	}
+}
+
+// exportAndMatch writes the group's match input to a temporary directory and runs match on it with the seed
+func exportAndMatch(t *testing.T, db *sql.DB, cfg snapshotConfig, seed string) (*matchInput, []byte) {
+	t.Helper()
+	in, err := exportMatchInput(context.Background(), db, testGroupID, cfg)
+	if err != nil {
+		t.Fatalf("exportMatchInput: %v", err)
+	}
+	dir := t.TempDir()
+	if err := writeMatchInput(dir, in); err != nil {
+		t.Fatalf("writeMatchInput: %v", err)
+	}
+
+	var out bytes.Buffer
+	args := []string{"--input", filepath.Join(dir, "participants.json"), "--history", filepath.Join(dir, "history.json"),
+		"--config", filepath.Join(dir, "config.json"), "--seed", seed}
+	if err := runMatchCommand(args, &out); err != nil {
+		t.Fatalf("match: %v", err)
+	}
+	return in, out.Bytes()
+}
+
+// checkMatchResult decodes the output of match and checks that everyone is placed once and the couples stay apart
+func checkMatchResult(t *testing.T, output []byte, participants int, apart ...[2]string) MatchResult {
+	t.Helper()
+	var result MatchResult
+	if err := json.Unmarshal(output, &result); err != nil {
+		t.Fatalf("match output: %v\n%s", err, output)
+	}
+	placed := make(map[string]int)
+	for _, p := range result.Pairs {
+		members := make(map[string]bool)
+		for _, id := range p.Members {
+			placed[id]++
+			members[id] = true
+		}
+		for _, c := range apart {
+			if members[c[0]] && members[c[1]] {
+				t.Errorf("%s and %s meet: %v", c[0], c[1], p.Members)
+			}
+		}
+	}
+	for _, id := range result.Unpaired {
+		placed[id]++
+	}
+	if len(placed) != participants {
+		t.Errorf("%d of %d participants placed: %v", len(placed), participants, result)
+	}
+	for id, n := range placed {
+		if n != 1 {
+			t.Errorf("%s placed %d times", id, n)
+		}
+	}
+	return result
+}
+
+func TestMatchInputRoundTrip(t *testing.T) {
+	db := openTestDB(t)
+	seedMatchGroup(t, db)
+
+	in, output := exportAndMatch(t, db, snapshotConfig{}, "7")
+	if len(in.participants) != 7 || len(in.history) != 2 {
+		t.Fatalf("exported %d participants and %d meetings, want 7 and 2", len(in.participants), len(in.history))
+	}
+	if len(in.config.Volunteers) != 1 || len(in.config.Exclusions) != 1 {
+		t.Fatalf("config = %+v, want the volunteer and the listed exclusion", in.config)
+	}
+	// /avoid couples are private: a plain export leaves them out and says so
+	if in.config.Avoided != nil || in.avoidOmitted != 1 {
+		t.Fatalf("avoided = %v, omitted %d; want the couple left out", in.config.Avoided, in.avoidOmitted)
+	}
+
+	result := checkMatchResult(t, output, 7, [2]string{"1", "2"}, [2]string{"3", "4"}, [2]string{"1", "3"})
+	if result.Seed != 7 || result.Repeats.Meetings != 0 {
+		t.Fatalf("result = %+v, want seed 7 and no repeats", result)
+	}
+
+	// The same seed on the same export gives the same output, byte for byte
+	for i := 0; i < 3; i++ {
+		if _, again := exportAndMatch(t, db, snapshotConfig{}, "7"); !bytes.Equal(again, output) {
+			t.Fatalf("seed 7 gave\n%s\nthen\n%s", output, again)
+		}
+	}
+}
+
+func TestAnonymizedMatchInput(t *testing.T) {
+	db := openTestDB(t)
+	seedMatchGroup(t, db)
+	t.Setenv("ANONYMIZE_SECRET", "snapshot-key")
+	t.Setenv("SNAPSHOT_SECRET", "")
+
+	t.Setenv("MATCH_EXPORT_SECRET", "")
+	if _, err := matchExportConfig(true); err == nil {
+		t.Fatal("anonymized export without MATCH_EXPORT_SECRET accepted")
+	}
+	t.Setenv("MATCH_EXPORT_SECRET", "snapshot-key")
+	if _, err := matchExportConfig(true); err == nil {
+		t.Fatal("MATCH_EXPORT_SECRET equal to ANONYMIZE_SECRET accepted")
+	}
+
+	t.Setenv("MATCH_EXPORT_SECRET", "export-key")
+	cfg, err := matchExportConfig(true)
+	if err != nil {
+		t.Fatalf("matchExportConfig: %v", err)
+	}
+	in, output := exportAndMatch(t, db, cfg, "7")
+
+	// Exported IDs can't be joined with published snapshots
+	snapshotCfg := snapshotConfig{Anonymize: true, AnonymizeKey: "snapshot-key"}
+	for _, p := range in.participants {
+		if !strings.HasPrefix(p.ID, "anon-") || p.Username != "" || p.FullName != "" {
+			t.Fatalf("participant %+v, want an anonymized ID without names", p)
+		}
+	}
+	if id := cfg.snapshotUserID(2); id == snapshotCfg.snapshotUserID(2) {
+		t.Fatalf("export ID %s is the snapshot ID", id)
+	}
+
+	want := [2]string{cfg.snapshotUserID(2), cfg.snapshotUserID(5)}
+	if len(in.config.Avoided) != 1 || in.config.Avoided[0] != want || in.avoidOmitted != 0 {
+		t.Fatalf("avoided = %v, want %v", in.config.Avoided, want)
+	}
+	checkMatchResult(t, output, 7, want, [2]string{cfg.snapshotUserID(1), cfg.snapshotUserID(3)})
+}
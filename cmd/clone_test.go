@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"example.com/random_coffee/database"
+	"github.com/NicoNex/echotron/v3"
+	"github.com/google/uuid"
+)
+
+func TestFilterPairsByUsers(t *testing.T) {
+	pairs := []database.Pair{
+		{WeekStart: "w1", User1ID: 1, User2ID: 2},
+		{WeekStart: "w2", User1ID: 1, User2ID: 3},
+		{WeekStart: "w3", User1ID: 1, User2ID: 2, User3ID: 3},
+		{WeekStart: "w4", User1ID: 3, User2ID: 1, User3ID: 4},
+	}
+	tests := []struct {
+		name  string
+		users []int64
+		want  [][]int64 // the members of each kept pair
+	}{
+		{"no filter", nil, [][]int64{{1, 2}, {1, 3}, {1, 2, 3}, {3, 1, 4}}},
+		{"both members kept", []int64{1, 2}, [][]int64{{1, 2}, {1, 2}}},
+		{"trio member filtered out", []int64{1, 3}, [][]int64{{1, 3}, {1, 3}, {3, 1}}},
+		{"one member left", []int64{2}, [][]int64{}},
+		{"unknown users", []int64{9}, [][]int64{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := make([][]int64, 0)
+			for _, p := range filterPairsByUsers(pairs, tt.users) {
+				got = append(got, p.Members())
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("filterPairsByUsers(%v) = %v, want %v", tt.users, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCloneGroupDataLeavesActiveCycle(t *testing.T) {
+	const targetID = -200
+	db, tg, api := setupManualPairsGroup(t)
+	ctx := context.Background()
+	seedTestGroup(t, db, targetID, "Coffee 2")
+
+	// The source group has history and an open cycle: a poll and two sign-ups
+	history := []database.Pair{
+		{ID: uuid.New(), GroupID: testGroupID, WeekStart: "2026-04-20", User1ID: 1, User2ID: 2, CreatedAt: time.Now()},
+		{ID: uuid.New(), GroupID: testGroupID, WeekStart: "2026-04-27", User1ID: 1, User2ID: 3, User3ID: 4, CreatedAt: time.Now()},
+		{ID: uuid.New(), GroupID: testGroupID, WeekStart: "2026-04-27", User1ID: 5, User2ID: 6, CreatedAt: time.Now()},
+	}
+	if err := database.CreatePairs(ctx, db, history); err != nil {
+		t.Fatalf("CreatePairs: %v", err)
+	}
+	if err := database.CreatePollMapping(ctx, db, database.PollMapping{PollID: "poll-1", GroupID: testGroupID, MessageID: 10, Kind: database.SignupPoll}); err != nil {
+		t.Fatalf("CreatePollMapping: %v", err)
+	}
+	signUp(t, db, testGroupID, 1, 3)
+
+	clone := func(args string) string {
+		t.Helper()
+		handleCloneGroupData(ctx, db, api, &echotron.Message{Text: "/clone_group_data " + args,
+			Chat: echotron.Chat{ID: testAdminID, Type: "private"}, From: &echotron.User{ID: testAdminID}}, strings.Fields(args))
+		sent := tg.sent(testAdminID)
+		return sent[len(sent)-1]
+	}
+
+	// Without confirm nothing is copied; users 1, 2 and 3 moved, so the trio comes over as a pair
+	if reply := clone("-100 -200 1 2 3"); !strings.Contains(reply, "Будет скопировано пар: 2 из 3") {
+		t.Fatalf("preview = %q", reply)
+	}
+	if pairs, _ := database.GetPairHistory(ctx, db, targetID); len(pairs) != 0 {
+		t.Fatalf("target has %d pairs before the confirmation", len(pairs))
+	}
+
+	if reply := clone("-100 -200 1 2 3 confirm"); !strings.Contains(reply, "Скопировано пар: 2") {
+		t.Fatalf("reply = %q", reply)
+	}
+	copied, err := database.GetPairHistory(ctx, db, targetID)
+	if err != nil || len(copied) != 2 {
+		t.Fatalf("GetPairHistory(target) = %+v, %v; want the two pairs of the moved users", copied, err)
+	}
+	for _, p := range copied {
+		for _, id := range p.Members() {
+			if id == 4 || id == 5 || id == 6 {
+				t.Fatalf("pair %+v copied with user %d, who didn't move", p, id)
+			}
+		}
+	}
+
+	// The open cycle stays with the source group
+	if pm, err := database.GetPollMappingByGroupID(ctx, db, targetID); err != nil || pm != nil {
+		t.Fatalf("target poll mapping = %+v, %v; want none", pm, err)
+	}
+	if n, err := database.CountParticipants(ctx, db, targetID); err != nil || n != 0 {
+		t.Fatalf("target has %d participants (%v), want none", n, err)
+	}
+	if pm, _ := database.GetPollMappingByGroupID(ctx, db, testGroupID); pm == nil || pm.PollID != "poll-1" {
+		t.Fatalf("source poll mapping = %+v, want it kept", pm)
+	}
+	if n, _ := database.CountParticipants(ctx, db, testGroupID); n != 2 {
+		t.Fatalf("source has %d participants, want both kept", n)
+	}
+	if pairs, _ := database.GetPairHistory(ctx, db, testGroupID); len(pairs) != 3 {
+		t.Fatalf("source has %d pairs, want its history untouched", len(pairs))
+	}
+
+	var details string
+	err = db.QueryRowContext(ctx, `SELECT details FROM audit_log WHERE action = 'clone_group_data' AND group_id = ?`, targetID).Scan(&details)
+	if err != nil || !strings.Contains(details, "source=-100 pairs=2") {
+		t.Fatalf("audit details = %q, %v", details, err)
+	}
+
+	// Cloning again copies nothing new
+	if reply := clone("-100 -200 1 2 3 confirm"); !strings.Contains(reply, "Скопировано пар: 0 (уже были в целевой группе: 2)") {
+		t.Fatalf("repeat reply = %q", reply)
+	}
+}
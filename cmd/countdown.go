@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"example.com/random_coffee/database"
+	"github.com/NicoNex/echotron/v3"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// countdownSetting is the group setting that enables the countdown ("on"/"off")
+	countdownSetting = "countdown"
+
+	// countdownTick is how often due countdown edits are looked for
+	countdownTick = 5 * time.Minute
+
+	// countdownFinalStretch is the period before closing when the countdown is edited hourly
+	countdownFinalStretch = 6 * time.Hour
+
+	countdownClosedText = "🔒 Опрос закрыт"
+)
+
+// countdownInterval returns how often the countdown is edited: every 6 hours,
+// then hourly during the final stretch, well within Telegram's edit rate limits
+func countdownInterval(remaining time.Duration) time.Duration {
+	if remaining > countdownFinalStretch {
+		return 6 * time.Hour
+	}
+	return time.Hour
+}
+
+// pluralRu picks the Russian plural form for n: one (1 день), few (2 дня), many (5 дней)
+func pluralRu(n int, one, few, many string) string {
+	n %= 100
+	if n >= 11 && n <= 14 {
+		return many
+	}
+	switch n % 10 {
+	case 1:
+		return one
+	case 2, 3, 4:
+		return few
+	default:
+		return many
+	}
+}
+
+// formatCountdown renders the time left with hour precision
+func formatCountdown(remaining time.Duration) string {
+	if remaining < time.Hour {
+		return "⏳ До закрытия опроса осталось меньше часа"
+	}
+
+	hours := int(remaining.Hours())
+	days := hours / 24
+	hours %= 24
+
+	parts := make([]string, 0, 2)
+	if days > 0 {
+		parts = append(parts, fmt.Sprintf("%d %s", days, pluralRu(days, "день", "дня", "дней")))
+	}
+	if hours > 0 {
+		parts = append(parts, fmt.Sprintf("%d %s", hours, pluralRu(hours, "час", "часа", "часов")))
+	}
+	return "⏳ До закрытия опроса осталось " + strings.Join(parts, " ")
+}
+
+func isCountdownEnabled(ctx context.Context, db *sql.DB, groupID int64) bool {
+	value, _, err := database.GetGroupSetting(ctx, db, groupID, countdownSetting)
+	if err != nil {
+		groupEvent(log.Warn(), EventSettingsReadFailed, groupID).Err(err).Str("key", countdownSetting).Msg("GetGroupSetting failed")
+		return false
+	}
+	return value == "on"
+}
+
+// startCountdown posts the countdown as a reply to a freshly sent poll and persists it,
+// so the updater can keep editing it across restarts
+func startCountdown(ctx context.Context, db *sql.DB, api echotron.API, groupID int64, pollID string, pollMessageID int) {
+	now := time.Now()
+	closesAt := nextPairsTime(ctx, db, groupID, now)
+
+	opts := &echotron.MessageOptions{
+		ReplyParameters: echotron.ReplyParameters{MessageID: pollMessageID},
+	}
+	res, err := api.SendMessage(formatCountdown(closesAt.Sub(now)), groupID, opts)
+	if err != nil || res.Result == nil {
+		groupEvent(log.Warn(), EventCountdownFailed, groupID).Err(err).Msg("Failed to send countdown message")
+		return
+	}
+
+	if err := database.SetPollCountdown(ctx, db, pollID, int64(res.Result.ID), closesAt, now); err != nil {
+		groupEvent(log.Error(), EventCountdownFailed, groupID).Err(err).Str("poll_id", pollID).Msg("SetPollCountdown failed")
+		return
+	}
+
+	groupEvent(log.Info(), EventCountdownStarted, groupID).Str("poll_id", pollID).Time("closes_at", closesAt).Msg("Countdown started")
+}
+
+// updateCountdowns edits every countdown that is due at now, closing those whose time is up
+func updateCountdowns(ctx context.Context, db *sql.DB, api echotron.API, now time.Time) {
+	countdowns, err := database.GetActiveCountdowns(ctx, db)
+	if err != nil {
+		botEvent(log.Error(), EventCountdownFailed).Err(err).Msg("GetActiveCountdowns failed")
+		return
+	}
+
+	for _, pm := range countdowns {
+		remaining := pm.CountdownClosesAt.Sub(now)
+		if remaining <= 0 {
+			finishCountdown(ctx, db, api, &pm)
+			continue
+		}
+
+		if now.Sub(pm.CountdownEditedAt) < countdownInterval(remaining) {
+			continue
+		}
+
+		msg := echotron.NewMessageID(pm.GroupID, int(pm.CountdownMessageID))
+		if _, err := api.EditMessageText(formatCountdown(remaining), msg, nil); err != nil {
+			groupEvent(log.Warn(), EventCountdownFailed, pm.GroupID).Err(err).Msg("Failed to edit countdown message")
+		}
+
+		// Record the attempt even on failure, so a deleted message is not retried every tick
+		if err := database.TouchPollCountdown(ctx, db, pm.PollID, now); err != nil {
+			groupEvent(log.Error(), EventCountdownFailed, pm.GroupID).Err(err).Msg("TouchPollCountdown failed")
+		}
+	}
+}
+
+// finishCountdown shows the closed state and stops further edits; it is a no-op without a countdown
+func finishCountdown(ctx context.Context, db *sql.DB, api echotron.API, pm *database.PollMapping) {
+	if pm == nil || pm.CountdownMessageID == 0 {
+		return
+	}
+
+	msg := echotron.NewMessageID(pm.GroupID, int(pm.CountdownMessageID))
+	if _, err := api.EditMessageText(countdownClosedText, msg, nil); err != nil {
+		groupEvent(log.Warn(), EventCountdownFailed, pm.GroupID).Err(err).Msg("Failed to close countdown message")
+	}
+
+	if err := database.ClearPollCountdown(ctx, db, pm.PollID); err != nil {
+		groupEvent(log.Error(), EventCountdownFailed, pm.GroupID).Err(err).Msg("ClearPollCountdown failed")
+		return
+	}
+	pm.CountdownMessageID = 0
+
+	groupEvent(log.Info(), EventCountdownFinished, pm.GroupID).Str("poll_id", pm.PollID).Msg("Countdown finished")
+}
+
+// newCountdownTicker returns the updater's clock ticks and a function stopping them; tests replace it
+// with a fake clock
+var newCountdownTicker = func() (<-chan time.Time, func()) {
+	ticker := time.NewTicker(countdownTick)
+	return ticker.C, ticker.Stop
+}
+
+func startCountdownUpdater(db *sql.DB, api echotron.API, stopChan chan struct{}) {
+	scheduler.startWorker("countdown", func() {
+		ticks, stop := newCountdownTicker()
+		defer stop()
+
+		for {
+			select {
+			case now := <-ticks:
+				updateCountdowns(context.Background(), db, api, now)
+			case <-stopChan:
+				return
+			}
+		}
+	})
+}
+
+// handleCountdownCommand implements /countdown on|off in a group
+func handleCountdownCommand(ctx context.Context, db *sql.DB, api echotron.API, groupID int64, args []string) {
+	if len(args) != 1 || (args[0] != "on" && args[0] != "off") {
+		state := "выключен"
+		if isCountdownEnabled(ctx, db, groupID) {
+			state = "включен"
+		}
+		sendMessage(api, fmt.Sprintf("Обратный отсчет под опросом %s.\nИспользование: /countdown on|off", state), groupID)
+		return
+	}
+
+	if err := database.SetGroupSetting(ctx, db, groupID, countdownSetting, args[0]); err != nil {
+		groupEvent(log.Error(), EventSettingsSaveFailed, groupID).Err(err).Str("key", countdownSetting).Msg("SetGroupSetting failed")
+		sendMessage(api, "❌ Не удалось сохранить настройку", groupID)
+		return
+	}
+
+	if args[0] == "on" {
+		sendMessage(api, "✅ Обратный отсчет включен, он появится со следующим опросом", groupID)
+		return
+	}
+
+	// Stop the running countdown right away
+	if pm, err := database.GetPollMappingByGroupID(ctx, db, groupID); err == nil {
+		finishCountdown(ctx, db, api, pm)
+	}
+	sendMessage(api, "✅ Обратный отсчет выключен", groupID)
+}
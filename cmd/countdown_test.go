@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"example.com/random_coffee/database"
+	"github.com/NicoNex/echotron/v3"
+)
+
+// countdownStart is when the test countdown was posted; it closes a day later
+var countdownStart = time.Date(2026, 5, 1, 19, 0, 0, 0, time.UTC)
+
+// withFakeCountdownClock runs the countdown updater on ticks sent by the returned function
+func withFakeCountdownClock(t *testing.T, db *sql.DB, api echotron.API) func(at time.Time) {
+	t.Helper()
+	tick := withFakeTicker(t, &newCountdownTicker)
+	startCountdownUpdater(db, api, withTestScheduler(t))
+	return tick
+}
+
+// startTestCountdown opens the test group's poll with a countdown posted at countdownStart
+func startTestCountdown(t *testing.T, db *sql.DB) {
+	t.Helper()
+	ctx := context.Background()
+	pm := database.PollMapping{PollID: "poll-1", GroupID: testGroupID, MessageID: 10, Kind: database.SignupPoll}
+	if err := database.CreatePollMapping(ctx, db, pm); err != nil {
+		t.Fatalf("CreatePollMapping: %v", err)
+	}
+	if err := database.SetPollCountdown(ctx, db, pm.PollID, 11, countdownStart.Add(24*time.Hour), countdownStart); err != nil {
+		t.Fatalf("SetPollCountdown: %v", err)
+	}
+}
+
+func TestCountdownCadence(t *testing.T) {
+	db, tg, api := setupManualPairsGroup(t)
+	startTestCountdown(t, db)
+	tick := withFakeCountdownClock(t, db, api)
+
+	// Every 6 hours while more than 6 are left, then hourly, then closed
+	want := map[time.Duration]string{
+		6 * time.Hour:  formatCountdown(18 * time.Hour),
+		12 * time.Hour: formatCountdown(12 * time.Hour),
+		18 * time.Hour: formatCountdown(6 * time.Hour),
+		19 * time.Hour: formatCountdown(5 * time.Hour),
+		20 * time.Hour: formatCountdown(4 * time.Hour),
+		21 * time.Hour: formatCountdown(3 * time.Hour),
+		22 * time.Hour: formatCountdown(2 * time.Hour),
+		23 * time.Hour: formatCountdown(time.Hour),
+		24 * time.Hour: countdownClosedText,
+	}
+	seen := 0
+	for elapsed := countdownTick; elapsed <= 26*time.Hour; elapsed += countdownTick {
+		tick(countdownStart.Add(elapsed))
+
+		edits := tg.edited(testGroupID)
+		text, due := want[elapsed]
+		switch {
+		case due && (len(edits) != seen+1 || edits[seen] != text):
+			t.Fatalf("after %s edits are %q, want %q", elapsed, edits[seen:], text)
+		case !due && len(edits) != seen:
+			t.Fatalf("after %s edited to %q, want no edit", elapsed, edits[seen:])
+		}
+		seen = len(edits)
+	}
+	if seen != len(want) {
+		t.Fatalf("countdown edited %d times, want %d", seen, len(want))
+	}
+}
+
+func TestCountdownStopsEarly(t *testing.T) {
+	tests := []struct {
+		name  string
+		close func(ctx context.Context, db *sql.DB, api echotron.API)
+	}{
+		{"pairs created", func(ctx context.Context, db *sql.DB, api echotron.API) {
+			CreatePairs(ctx, db, api, testGroupID)
+		}},
+		{"cycle skipped", func(ctx context.Context, db *sql.DB, api echotron.API) {
+			skip := &holidaySkip{quizAt: countdownStart, pairsAt: countdownStart.Add(24 * time.Hour), day: countdownStart, reason: "2026-05-02"}
+			skipHolidayCycle(ctx, db, api, testGroupID, jobCreatePairs, skip)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, tg, api := setupManualPairsGroup(t)
+			ctx := context.Background()
+			startTestCountdown(t, db)
+			signUp(t, db, testGroupID, 1, 2)
+			tick := withFakeCountdownClock(t, db, api)
+
+			tick(countdownStart.Add(6 * time.Hour))
+			tt.close(ctx, db, api)
+			if edits := tg.edited(testGroupID); len(edits) != 2 || edits[1] != countdownClosedText {
+				t.Fatalf("countdown edited to %q, want it closed", edits)
+			}
+
+			// Hourly edits and the closing time pass without touching the closed countdown
+			for elapsed := 7 * time.Hour; elapsed <= 25*time.Hour; elapsed += time.Hour {
+				tick(countdownStart.Add(elapsed))
+			}
+			if n := len(tg.edited(testGroupID)); n != 2 {
+				t.Fatalf("countdown edited %d times after it was closed", n-2)
+			}
+		})
+	}
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"example.com/random_coffee/database"
+	"github.com/NicoNex/echotron/v3"
+	"github.com/rs/zerolog/log"
+)
+
+// Callback data of the dashboard buttons
+const (
+	dashboardPinCallback     = "dashboard_pin"
+	dashboardRefreshCallback = "dashboard_refresh"
+)
+
+// dashboardRefresher keeps users' /dashboard messages up to date. Events only mark a dashboard stale:
+// all events within DASHBOARD_DEBOUNCE_SECONDS of the first one end up in a single edit. Refreshes
+// are background work, so they wait while maintenance holds updates back, and run one at a time
+// on a supervised worker.
+type dashboardRefresher struct {
+	mu      sync.Mutex
+	started bool
+	db      *sql.DB
+	api     echotron.API
+	delay   time.Duration
+	pending map[int64]*time.Timer
+	due     chan int64 // users whose debounce delay is over, for the worker
+}
+
+// dashboardQueueSize bounds the refreshes waiting for the worker; timers wait beyond it
+const dashboardQueueSize = 256
+
+var dashboards = &dashboardRefresher{pending: make(map[int64]*time.Timer), due: make(chan int64, dashboardQueueSize)}
+
+// start lets events trigger refreshes; before it is called (e.g. in CLI commands) schedule does nothing
+func (r *dashboardRefresher) start(db *sql.DB, api echotron.API) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.db, r.api = db, api
+	r.delay = time.Duration(envInt("DASHBOARD_DEBOUNCE_SECONDS", 5)) * time.Second
+	r.started = true
+}
+
+// schedule refreshes the user's dashboard after the debounce delay, unless a refresh is already pending.
+// Users without a dashboard cost one lookup when the refresh fires.
+func (r *dashboardRefresher) schedule(userID int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.started {
+		return
+	}
+	if _, ok := r.pending[userID]; ok {
+		return
+	}
+	r.pending[userID] = time.AfterFunc(r.delay, func() { r.fire(userID) })
+}
+
+func (r *dashboardRefresher) fire(userID int64) {
+	r.mu.Lock()
+	delete(r.pending, userID)
+	r.mu.Unlock()
+
+	// The held updates may change what the dashboard shows, so it waits for them
+	if maintenance.holding() {
+		r.schedule(userID)
+		return
+	}
+	r.due <- userID
+}
+
+// startDashboardRefresher runs the refreshes that are due
+func startDashboardRefresher(stopChan chan struct{}) {
+	r := dashboards
+	scheduler.startWorker("dashboard", func() {
+		for {
+			select {
+			case userID := <-r.due:
+				r.mu.Lock()
+				db, api := r.db, r.api
+				r.mu.Unlock()
+				refreshDashboard(context.Background(), db, api, userID)
+			case <-stopChan:
+				return
+			}
+		}
+	})
+}
+
+// refreshDashboard edits the user's dashboard to show the current state. A dashboard whose message
+// was deleted is sent again instead; a user who blocked the bot loses theirs.
+func refreshDashboard(ctx context.Context, db *sql.DB, api echotron.API, userID int64) {
+	d, err := database.GetDashboard(ctx, db, userID)
+	if err != nil {
+		botEvent(log.Error(), EventDashboardFailed).Err(err).Int64("user_id", userID).Msg("GetDashboard failed")
+		return
+	}
+	if d == nil {
+		return
+	}
+
+	lang := replyLanguage(ctx, db, userID, 0)
+	text := buildDashboard(ctx, db, userID, lang, time.Now())
+	if d.MessageID == 0 {
+		sendDashboard(ctx, db, api, userID, lang, text)
+		return
+	}
+
+	opts := &echotron.MessageTextOptions{ReplyMarkup: echotron.InlineKeyboardMarkup{InlineKeyboard: dashboardKeyboard(lang)}}
+	_, err = api.EditMessageText(text, echotron.NewMessageID(userID, int(d.MessageID)), opts)
+	switch {
+	case err == nil || strings.Contains(err.Error(), "message is not modified"):
+		botEvent(log.Debug(), EventDashboardRefreshed).Int64("user_id", userID).Msg("Dashboard refreshed")
+	case strings.Contains(err.Error(), "message to edit not found"):
+		// Deleted by the user: the next event sends a new one
+		d.MessageID, d.UpdatedAt = 0, time.Now()
+		if err := database.SaveDashboard(ctx, db, *d); err != nil {
+			botEvent(log.Error(), EventDashboardFailed).Err(err).Int64("user_id", userID).Msg("SaveDashboard failed")
+		}
+		botEvent(log.Info(), EventDashboardLost).Int64("user_id", userID).Msg("Dashboard message deleted, will be sent again")
+	case isChatUnavailableError(err):
+		if _, err := database.DeleteDashboard(ctx, db, userID); err != nil {
+			botEvent(log.Error(), EventDashboardFailed).Err(err).Int64("user_id", userID).Msg("DeleteDashboard failed")
+		}
+		botEvent(log.Info(), EventDashboardLost).Err(err).Int64("user_id", userID).Msg("Private chat unavailable, dashboard dropped")
+	default:
+		botEvent(log.Warn(), EventDashboardFailed).Err(err).Int64("user_id", userID).Msg("EditMessageText failed")
+	}
+}
+
+// sendDashboard posts a new dashboard message and makes it the one kept up to date
+func sendDashboard(ctx context.Context, db *sql.DB, api echotron.API, userID int64, lang, text string) bool {
+	opts := &echotron.MessageOptions{ReplyMarkup: echotron.InlineKeyboardMarkup{InlineKeyboard: dashboardKeyboard(lang)}}
+	res, err := api.SendMessage(text, userID, opts)
+	tokenWatcher.sendDone(err)
+	if err != nil || res.Result == nil {
+		botEvent(log.Warn(), EventDashboardFailed).Err(err).Int64("user_id", userID).Msg("Failed to send dashboard")
+		return false
+	}
+
+	d := database.Dashboard{UserID: userID, MessageID: int64(res.Result.ID), UpdatedAt: time.Now()}
+	if err := database.SaveDashboard(ctx, db, d); err != nil {
+		botEvent(log.Error(), EventDashboardFailed).Err(err).Int64("user_id", userID).Msg("SaveDashboard failed")
+		return false
+	}
+	return true
+}
+
+func dashboardKeyboard(lang string) [][]echotron.InlineKeyboardButton {
+	return [][]echotron.InlineKeyboardButton{{
+		{Text: tr(lang, "dashboard.pin_button"), CallbackData: dashboardPinCallback},
+		{Text: tr(lang, "dashboard.refresh_button"), CallbackData: dashboardRefreshCallback},
+	}}
+}
+
+// buildDashboard renders the user's status in every active group they signed up in or were paired in:
+// whether they are in the next pairing, this week's partner and when the next quiz goes out
+func buildDashboard(ctx context.Context, db *sql.DB, userID int64, lang string, now time.Time) string {
+	participations, err := database.GetParticipationsByUser(ctx, db, userID)
+	if err != nil {
+		botEvent(log.Warn(), EventDashboardFailed).Err(err).Int64("user_id", userID).Msg("GetParticipationsByUser failed")
+	}
+	pairs, err := database.GetPairsByUser(ctx, db, userID)
+	if err != nil {
+		botEvent(log.Warn(), EventDashboardFailed).Err(err).Int64("user_id", userID).Msg("GetPairsByUser failed")
+	}
+
+	signedUp := make(map[int64]bool, len(participations))
+	groupIDs := make([]int64, 0, len(participations))
+	for _, p := range participations {
+		signedUp[p.GroupID] = true
+		groupIDs = append(groupIDs, p.GroupID)
+	}
+
+	// Pairs are made on the last day of their week, so they are met during the week after
+	recent := make(map[int64]database.Pair)
+	since := getWeekStart(now.AddDate(0, 0, -7))
+	for _, p := range pairs {
+		groupIDs = append(groupIDs, p.GroupID)
+		if p.WeekStart >= since {
+			recent[p.GroupID] = p
+		}
+	}
+	slices.Sort(groupIDs)
+	groupIDs = slices.Compact(groupIDs)
+
+	var partnerIDs []int64
+	for _, p := range recent {
+		for _, id := range p.Members() {
+			if id != userID {
+				partnerIDs = append(partnerIDs, id)
+			}
+		}
+	}
+	profiles, err := database.GetUserProfiles(ctx, db, partnerIDs)
+	if err != nil {
+		botEvent(log.Warn(), EventDashboardFailed).Err(err).Int64("user_id", userID).Msg("GetUserProfiles failed")
+	}
+
+	var b strings.Builder
+	b.WriteString(tr(lang, "dashboard.title"))
+	shown := 0
+	for _, groupID := range groupIDs {
+		if !isConfiguredGroup(ctx, db, groupID) {
+			continue
+		}
+		shown++
+		fmt.Fprintf(&b, "\n\n👥 %s\n", groupTitle(ctx, db, groupID))
+
+		if signedUp[groupID] {
+			b.WriteString(tr(lang, "dashboard.signed_up"))
+		} else {
+			b.WriteString(tr(lang, "dashboard.not_signed_up"))
+		}
+
+		if p, ok := recent[groupID]; ok {
+			var names []string
+			for _, id := range p.Members() {
+				if id == userID {
+					continue
+				}
+				if profile, ok := profiles[id]; ok {
+					names = append(names, getProfileDisplayName(profile))
+				} else {
+					names = append(names, fmt.Sprintf("id %d", id))
+				}
+			}
+			fmt.Fprintf(&b, "\n"+tr(lang, "dashboard.partner"), strings.Join(names, ", "))
+		}
+
+		s := loadGroupSchedule(ctx, db, groupID)
+		fmt.Fprintf(&b, "\n"+tr(lang, "dashboard.next_quiz"), s.nextQuiz(now).Format("02.01 15:04"), s.timezone)
+	}
+	if shown == 0 {
+		return tr(lang, "dashboard.title") + "\n\n" + tr(lang, "dashboard.empty")
+	}
+
+	if streak := pairStreak(pairs, now); streak > 0 {
+		fmt.Fprintf(&b, "\n\n"+tr(lang, "dashboard.streak"), streak)
+	}
+	return b.String()
+}
+
+// pairStreak counts the weeks in a row, up to this or the last week, in which the user had a pair in any group
+func pairStreak(pairs []database.Pair, now time.Time) int {
+	weeks := make(map[string]bool, len(pairs))
+	for _, p := range pairs {
+		weeks[p.WeekStart] = true
+	}
+
+	week := now
+	if !weeks[getWeekStart(week)] {
+		week = week.AddDate(0, 0, -7)
+	}
+	streak := 0
+	for weeks[getWeekStart(week)] {
+		streak++
+		week = week.AddDate(0, 0, -7)
+	}
+	return streak
+}
+
+// handleDashboardCommand implements /dashboard [off] in a private chat. Each /dashboard sends a fresh
+// message, and only the latest one is kept up to date.
+func handleDashboardCommand(ctx context.Context, db *sql.DB, api echotron.API, message *echotron.Message, args []string, lang string) {
+	userID := message.From.ID
+	switch {
+	case len(args) == 0:
+		if !sendDashboard(ctx, db, api, userID, lang, buildDashboard(ctx, db, userID, lang, time.Now())) {
+			sendMessage(api, tr(lang, "dashboard.failed"), message.Chat.ID)
+			return
+		}
+		botEvent(log.Info(), EventDashboardEnabled).Int64("user_id", userID).Msg("Dashboard sent")
+	case len(args) == 1 && args[0] == "off":
+		removed, err := database.DeleteDashboard(ctx, db, userID)
+		if err != nil {
+			botEvent(log.Error(), EventDashboardFailed).Err(err).Int64("user_id", userID).Msg("DeleteDashboard failed")
+			sendMessage(api, tr(lang, "dashboard.failed"), message.Chat.ID)
+			return
+		}
+		if !removed {
+			sendMessage(api, tr(lang, "dashboard.none"), message.Chat.ID)
+			return
+		}
+		sendMessage(api, tr(lang, "dashboard.off"), message.Chat.ID)
+	default:
+		sendMessage(api, tr(lang, "dashboard.usage"), message.Chat.ID)
+	}
+}
+
+// handleDashboardCallback pins the dashboard in the private chat or refreshes it right away
+func handleDashboardCallback(ctx context.Context, db *sql.DB, api echotron.API, cq *echotron.CallbackQuery, action string) {
+	if cq.From == nil || cq.Message == nil || cq.Message.Chat.Type != "private" {
+		answerCallback(api, cq, "")
+		return
+	}
+	userID := cq.From.ID
+	lang := replyLanguage(ctx, db, userID, 0)
+
+	d, err := database.GetDashboard(ctx, db, userID)
+	if err != nil {
+		botEvent(log.Error(), EventDashboardFailed).Err(err).Int64("user_id", userID).Msg("GetDashboard failed")
+		answerCallback(api, cq, tr(lang, "dashboard.failed"))
+		return
+	}
+	// Buttons of an older dashboard, or of one turned off, do nothing but go away
+	if d == nil || d.MessageID != int64(cq.Message.ID) {
+		answerCallback(api, cq, tr(lang, "dashboard.stale"))
+		editCallbackMessage(api, cq, cq.Message.Text, nil)
+		return
+	}
+
+	switch action {
+	case dashboardPinCallback:
+		if _, err := api.PinChatMessage(userID, cq.Message.ID, &echotron.PinMessageOptions{DisableNotification: true}); err != nil {
+			botEvent(log.Warn(), EventDashboardFailed).Err(err).Int64("user_id", userID).Msg("PinChatMessage failed")
+			answerCallback(api, cq, tr(lang, "dashboard.pin_failed"))
+			return
+		}
+		answerCallback(api, cq, tr(lang, "dashboard.pinned"))
+	case dashboardRefreshCallback:
+		answerCallback(api, cq, "")
+		refreshDashboard(ctx, db, api, userID)
+	}
+}
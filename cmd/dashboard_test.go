@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"example.com/random_coffee/database"
+	"github.com/NicoNex/echotron/v3"
+)
+
+const (
+	testDashboardUser  = int64(1)
+	testDashboardDelay = 20 * time.Millisecond
+)
+
+// withTestDashboards replaces the dashboard refresher with one that debounces for testDashboardDelay
+// and runs its worker on a test scheduler
+func withTestDashboards(t *testing.T, db *sql.DB, api echotron.API) {
+	t.Helper()
+	saved := dashboards
+	t.Cleanup(func() { dashboards = saved })
+	stop := withTestScheduler(t)
+
+	dashboards = &dashboardRefresher{pending: make(map[int64]*time.Timer), due: make(chan int64, dashboardQueueSize)}
+	dashboards.start(db, api)
+	dashboards.delay = testDashboardDelay
+	startDashboardRefresher(stop)
+}
+
+// edited returns the texts the messages in the chat were edited to
+func (f *fakeTelegram) edited(chatID int64) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var texts []string
+	for _, c := range f.calls {
+		if c.method == "editMessageText" && c.params.Get("chat_id") == strconv.FormatInt(chatID, 10) {
+			texts = append(texts, c.params.Get("text"))
+		}
+	}
+	return texts
+}
+
+// vote records the user's answer to the group's sign-up poll
+func vote(ctx context.Context, db *sql.DB, userID int64, joined bool) {
+	user := &echotron.User{ID: userID, Username: fmt.Sprintf("user%d", userID), FirstName: "User"}
+	applySignupAnswer(ctx, db, testGroupID, user, joined, "poll-1")
+}
+
+// nextEdit waits for the dashboard to be edited once more than seen and returns the new text
+func nextEdit(t *testing.T, tg *fakeTelegram, seen int) string {
+	t.Helper()
+	waitFor(t, "the dashboard edit", func() bool { return len(tg.edited(testDashboardUser)) > seen })
+	edits := tg.edited(testDashboardUser)
+	if len(edits) != seen+1 {
+		t.Fatalf("dashboard edited %d times, want one edit for the event", len(edits)-seen)
+	}
+	return edits[seen]
+}
+
+// checkDashboard fails unless the text holds every wanted line and none of the unwanted ones
+func checkDashboard(t *testing.T, step, text string, want, unwanted []string) {
+	t.Helper()
+	for _, w := range want {
+		if !strings.Contains(text, w) {
+			t.Errorf("%s: dashboard misses %q:\n%s", step, w, text)
+		}
+	}
+	for _, u := range unwanted {
+		if strings.Contains(text, u) {
+			t.Errorf("%s: dashboard shows %q:\n%s", step, u, text)
+		}
+	}
+}
+
+func TestDashboardFollowsVotePairAndNextCycle(t *testing.T) {
+	db, tg, api := setupManualPairsGroup(t)
+	ctx := context.Background()
+	withTestDashboards(t, db, api)
+	lang := replyLanguage(ctx, db, testDashboardUser, 0)
+	partner := fmt.Sprintf(tr(lang, "dashboard.partner"), "@user2")
+	streak := fmt.Sprintf(tr(lang, "dashboard.streak"), 1)
+
+	handleDashboardCommand(ctx, db, api, &echotron.Message{
+		Text: "/dashboard",
+		From: &echotron.User{ID: testDashboardUser},
+		Chat: echotron.Chat{ID: testDashboardUser, Type: "private"},
+	}, nil, lang)
+	if sent := tg.sent(testDashboardUser); len(sent) != 1 || !strings.Contains(sent[0], tr(lang, "dashboard.empty")) {
+		t.Fatalf("user got %q, want an empty dashboard", sent)
+	}
+
+	// Vote: both answers within the debounce delay end up in one edit
+	vote(ctx, db, testDashboardUser, false)
+	vote(ctx, db, testDashboardUser, true)
+	text := nextEdit(t, tg, 0)
+	checkDashboard(t, "after the vote", text,
+		[]string{"👥 Coffee", tr(lang, "dashboard.signed_up"), strings.Split(tr(lang, "dashboard.next_quiz"), "%")[0]},
+		[]string{tr(lang, "dashboard.empty"), strings.Split(tr(lang, "dashboard.partner"), "%")[0]})
+
+	// Pair: the sign-up closes and the partner shows up, the first week of a streak
+	vote(ctx, db, 2, true)
+	CreatePairs(ctx, db, api, testGroupID)
+	text = nextEdit(t, tg, 1)
+	checkDashboard(t, "after the pairs", text,
+		[]string{tr(lang, "dashboard.not_signed_up"), partner, streak},
+		[]string{tr(lang, "dashboard.signed_up")})
+
+	// Next cycle: the user deleted the dashboard, so the vote that reaches it only forgets the message
+	tg.reply("editMessageText", func(url.Values) string {
+		return `{"ok":false,"error_code":400,"description":"Bad Request: message to edit not found"}`
+	})
+	vote(ctx, db, testDashboardUser, false)
+	waitFor(t, "the deleted dashboard to be forgotten", func() bool {
+		d, err := database.GetDashboard(ctx, db, testDashboardUser)
+		return err == nil && d != nil && d.MessageID == 0
+	})
+
+	// ...and the next event sends a new one, still showing this week's partner
+	vote(ctx, db, testDashboardUser, true)
+	waitFor(t, "a new dashboard kept up to date", func() bool {
+		d, err := database.GetDashboard(ctx, db, testDashboardUser)
+		return err == nil && d != nil && d.MessageID != 0
+	})
+	if sent := tg.sent(testDashboardUser); len(sent) != 2 {
+		t.Fatalf("user got %d dashboards, want a new one", len(sent))
+	}
+	text = tg.sent(testDashboardUser)[1]
+	checkDashboard(t, "after the next vote", text,
+		[]string{tr(lang, "dashboard.signed_up"), partner, streak},
+		[]string{tr(lang, "dashboard.not_signed_up")})
+}
+
+func TestDashboardWithoutMessageIsNotRefreshed(t *testing.T) {
+	db, tg, api := setupManualPairsGroup(t)
+	ctx := context.Background()
+	withTestDashboards(t, db, api)
+
+	// Events of users who never asked for a dashboard, or turned theirs off, send nothing
+	vote(ctx, db, 3, true)
+	if err := database.SaveDashboard(ctx, db, database.Dashboard{UserID: 4, MessageID: 5, UpdatedAt: time.Now()}); err != nil {
+		t.Fatalf("SaveDashboard: %v", err)
+	}
+	if removed, err := database.DeleteDashboard(ctx, db, 4); err != nil || !removed {
+		t.Fatalf("DeleteDashboard = %v, %v", removed, err)
+	}
+	vote(ctx, db, 4, true)
+
+	time.Sleep(5 * testDashboardDelay)
+	if n := tg.count("editMessageText") + len(tg.sent(3)) + len(tg.sent(4)); n != 0 {
+		t.Fatalf("%d dashboard messages for users without one", n)
+	}
+}
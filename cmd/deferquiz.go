@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"example.com/random_coffee/database"
+	"github.com/NicoNex/echotron/v3"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// quizDeferralSetting is the group setting with a one-off shift of the upcoming quiz, "original|deferred" in RFC 3339
+	quizDeferralSetting = "quiz_deferral"
+
+	maxQuizDeferral = 48 * time.Hour
+)
+
+// quizDeferral moves one quiz occurrence to a later time; the weekly schedule and the pairing stay as they are
+type quizDeferral struct {
+	original time.Time // when the quiz would have run
+	at       time.Time // when it runs instead
+}
+
+// loadQuizDeferral returns the group's pending deferral. One whose time has passed, because the bot was
+// down when it was due, is ignored like any other missed job.
+func loadQuizDeferral(ctx context.Context, db *sql.DB, groupID int64, now time.Time) *quizDeferral {
+	value, found, err := database.GetGroupSetting(ctx, db, groupID, quizDeferralSetting)
+	if err != nil {
+		groupEvent(log.Warn(), EventSettingsReadFailed, groupID).Err(err).Str("key", quizDeferralSetting).Msg("GetGroupSetting failed")
+		return nil
+	}
+	if !found {
+		return nil
+	}
+
+	originalStr, atStr, _ := strings.Cut(value, "|")
+	original, err1 := time.Parse(time.RFC3339, originalStr)
+	at, err2 := time.Parse(time.RFC3339, atStr)
+	if err1 != nil || err2 != nil {
+		groupEvent(log.Warn(), EventQuizDeferralFailed, groupID).Str("value", value).Msg("Unreadable quiz deferral ignored")
+		return nil
+	}
+	if !now.Before(at) {
+		return nil
+	}
+	return &quizDeferral{original: original, at: at}
+}
+
+// clearQuizDeferral drops the group's deferral, when its quiz is due or an admin cancels it
+func clearQuizDeferral(ctx context.Context, db *sql.DB, groupID int64) {
+	if err := database.DeleteGroupSetting(ctx, db, groupID, quizDeferralSetting); err != nil {
+		groupEvent(log.Error(), EventSettingsSaveFailed, groupID).Err(err).Str("key", quizDeferralSetting).Msg("Failed to clear quiz deferral")
+		return
+	}
+	groupEvent(log.Info(), EventQuizDeferralCleared, groupID).Msg("Quiz deferral cleared")
+}
+
+// planQuizDeferral shifts the group's upcoming quiz by hours, counted from its regular time, and checks
+// that the pairing after it still leaves the poll open for the group's minimum notice
+func planQuizDeferral(sched groupSchedule, minNotice time.Duration, hours int, now time.Time) (*quizDeferral, error) {
+	original := sched.quiz.next(now, sched.location)
+	if d := sched.deferral; d != nil {
+		// Deferring again moves the same occurrence, even if its regular time has already passed
+		original = d.original
+	}
+	d := &quizDeferral{original: original, at: original.Add(time.Duration(hours) * time.Hour)}
+
+	if !d.at.After(now) {
+		return nil, fmt.Errorf("опрос в %s был бы уже в прошлом", d.at.Format("02.01 15:04"))
+	}
+	if err := d.check(sched, minNotice); err != nil {
+		return nil, fmt.Errorf("%w. Сдвинь опрос меньше или перенеси и создание пар через /set_schedule pairs, "+
+			"а потом верни его обратно", err)
+	}
+	return d, nil
+}
+
+// check reports whether the deferred poll stays open for the minimum notice before the pairing that
+// follows the replaced quiz
+func (d *quizDeferral) check(sched groupSchedule, minNotice time.Duration) error {
+	pairsAt := sched.pairs.next(d.original, sched.location)
+	if pairsAt.Sub(d.at) < max(minNotice, time.Minute) {
+		return fmt.Errorf("опрос в %s оставит до создания пар (%s) меньше минимального срока %s",
+			d.at.Format("02.01 15:04"), pairsAt.Format("02.01 15:04"), formatHoursMinutes(minNotice))
+	}
+	return nil
+}
+
+// recheckQuizDeferral runs after the group's schedule changed from old to updated. The pending deferral
+// stays only if the weekly quiz it replaces is unchanged and it still fits before the pairing; otherwise
+// it is dropped and the returned note tells the admin why. The note is empty when nothing was dropped.
+func recheckQuizDeferral(ctx context.Context, db *sql.DB, groupID int64, old, updated groupSchedule) string {
+	d := old.deferral
+	if d == nil {
+		return ""
+	}
+
+	reason := "время опроса изменилось"
+	if updated.quiz == old.quiz && updated.timezone == old.timezone {
+		err := d.check(updated, getMinNotice(ctx, db, groupID))
+		if err == nil {
+			return ""
+		}
+		reason = err.Error()
+	}
+
+	clearQuizDeferral(ctx, db, groupID)
+	return fmt.Sprintf("\n\n⏰ Перенос опроса отменен (%s): %s. Если нужно, перенеси его заново через /defer_quiz", d, reason)
+}
+
+// String describes the deferral for /schedule and /status
+func (d *quizDeferral) String() string {
+	return fmt.Sprintf("опрос %s перенесен на %s", d.original.Format("02.01 15:04"), d.at.Format("02.01 15:04"))
+}
+
+// formatQuizDeferrals lists groups whose upcoming quiz is deferred, for /status
+func formatQuizDeferrals(ctx context.Context, db *sql.DB) string {
+	now := time.Now()
+	text := ""
+	for _, groupID := range getConfiguredGroups(ctx, db) {
+		if d := loadQuizDeferral(ctx, db, groupID, now); d != nil {
+			text += fmt.Sprintf("• группа %d: %s\n", groupID, d)
+		}
+	}
+	if text == "" {
+		return ""
+	}
+	return "Перенесенные опросы:\n" + text
+}
+
+// handleDeferQuizCommand implements /defer_quiz <hours>|off in a group, and /defer_quiz <group_id> <hours>|off
+// for bot admins in a private chat. Only the upcoming quiz moves; the pairing time stays.
+func handleDeferQuizCommand(ctx context.Context, db *sql.DB, api echotron.API, message *echotron.Message, args []string) {
+	chatID := message.Chat.ID
+	groupID := chatID
+	usage := fmt.Sprintf("Использование: /defer_quiz <часы> | off\n"+
+		"Сдвигает только ближайший опрос группы (до %d ч.), время создания пар не меняется", int(maxQuizDeferral.Hours()))
+
+	if message.Chat.Type == "private" {
+		if !isAdmin(message.From.ID) {
+			sendMessage(api, "❌ Доступ запрещен", chatID)
+			return
+		}
+		usage = strings.Replace(usage, "/defer_quiz", "/defer_quiz <group_id>", 1)
+		if len(args) != 2 {
+			sendMessage(api, usage, chatID)
+			return
+		}
+		id, err := strconv.ParseInt(args[0], 10, 64)
+		if err != nil || !isConfiguredGroup(ctx, db, id) {
+			sendMessage(api, "❌ Укажи ID группы из списка подключенных групп\n\n"+usage, chatID)
+			return
+		}
+		groupID, args = id, args[1:]
+	}
+
+	sched := loadGroupSchedule(ctx, db, groupID)
+	if len(args) != 1 {
+		text := usage
+		if sched.deferral != nil {
+			text = "⏰ Сейчас " + sched.deferral.String() + "\n\n" + usage
+		}
+		sendMessage(api, text, chatID)
+		return
+	}
+
+	if args[0] == "off" {
+		if sched.deferral == nil {
+			sendMessage(api, "Ближайший опрос и так идет по расписанию", chatID)
+			return
+		}
+		clearQuizDeferral(ctx, db, groupID)
+		rescheduleGroup(groupID)
+		writeAudit(ctx, db, message.From.ID, "defer_quiz", groupID, "off")
+		sendMessage(api, fmt.Sprintf("✅ Опрос вернется к обычному времени: %s", sched.deferral.original.Format("02.01 15:04")), chatID)
+		return
+	}
+
+	hours, err := strconv.Atoi(args[0])
+	if err != nil || hours <= 0 || time.Duration(hours)*time.Hour > maxQuizDeferral {
+		sendMessage(api, fmt.Sprintf("❌ Укажи число часов от 1 до %d\n\n%s", int(maxQuizDeferral.Hours()), usage), chatID)
+		return
+	}
+
+	d, err := planQuizDeferral(sched, getMinNotice(ctx, db, groupID), hours, time.Now())
+	if err != nil {
+		sendMessage(api, "❌ "+err.Error(), chatID)
+		return
+	}
+
+	value := d.original.Format(time.RFC3339) + "|" + d.at.Format(time.RFC3339)
+	if err := database.SetGroupSetting(ctx, db, groupID, quizDeferralSetting, value); err != nil {
+		groupEvent(log.Error(), EventSettingsSaveFailed, groupID).Err(err).Str("key", quizDeferralSetting).Msg("SetGroupSetting failed")
+		sendMessage(api, "❌ Не удалось сохранить настройку", chatID)
+		return
+	}
+	rescheduleGroup(groupID)
+
+	writeAudit(ctx, db, message.From.ID, "defer_quiz", groupID, strconv.Itoa(hours))
+	groupEvent(log.Info(), EventQuizDeferred, groupID).Int64("user_id", message.From.ID).Time("original", d.original).Time("at", d.at).
+		Msg("Upcoming quiz deferred")
+	sendMessage(api, fmt.Sprintf("✅ Ближайший опрос придет %s вместо %s. Создание пар - по расписанию, "+
+		"со следующей недели опрос снова в обычное время", d.at.Format("02.01 15:04"), d.original.Format("02.01 15:04")), chatID)
+}
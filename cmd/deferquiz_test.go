@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"testing"
+	"time"
+
+	"example.com/random_coffee/database"
+	"github.com/NicoNex/echotron/v3"
+)
+
+// setupDeferralGroup registers the test group with a Friday 17:00 quiz, pairs three hours later and a
+// two-hour minimum notice
+func setupDeferralGroup(t *testing.T) (*sql.DB, *fakeTelegram, echotron.API) {
+	t.Helper()
+	db := openTestDB(t)
+	tg, api := newFakeTelegram(t)
+	withAdmins(t, testAdminID)
+	seedTestGroup(t, db, testGroupID, "Coffee")
+	ctx := context.Background()
+
+	sc := database.GroupConfig{GroupID: testGroupID, QuizWeekday: int(time.Friday), QuizHour: 17,
+		PairsWeekday: int(time.Friday), PairsHour: 20, Timezone: "Europe/Berlin"}
+	if err := database.UpsertGroupConfig(ctx, db, sc); err != nil {
+		t.Fatalf("UpsertGroupConfig: %v", err)
+	}
+	if err := database.SetGroupSetting(ctx, db, testGroupID, minNoticeSetting, "2"); err != nil {
+		t.Fatalf("SetGroupSetting: %v", err)
+	}
+	return db, tg, api
+}
+
+// groupCommand sends an admin's command to the test group and returns the bot's reply
+func groupCommand(t *testing.T, db *sql.DB, tg *fakeTelegram, api echotron.API, text string) string {
+	t.Helper()
+	before := len(tg.sent(testGroupID))
+	HandleGroupCommand(context.Background(), db, api, &echotron.Message{Text: text,
+		Chat: echotron.Chat{ID: testGroupID, Type: "supergroup"}, From: &echotron.User{ID: testAdminID}})
+	sent := tg.sent(testGroupID)
+	if len(sent) != before+1 {
+		t.Fatalf("%s: group got %q, want one reply", text, sent[before:])
+	}
+	return sent[before]
+}
+
+func TestDeferQuizGapValidation(t *testing.T) {
+	db, tg, api := setupDeferralGroup(t)
+	ctx := context.Background()
+
+	// 19:00 would leave the poll open for an hour before the 20:00 pairing
+	reply := groupCommand(t, db, tg, api, "/defer_quiz 2")
+	if !strings.Contains(reply, "меньше минимального срока 2 ч") || !strings.Contains(reply, "/set_schedule pairs") {
+		t.Fatalf("reply = %q, want the gap rejected with the pairing advice", reply)
+	}
+	if _, found, _ := database.GetGroupSetting(ctx, db, testGroupID, quizDeferralSetting); found {
+		t.Fatal("rejected deferral stored")
+	}
+
+	if reply := groupCommand(t, db, tg, api, "/defer_quiz 1"); !strings.Contains(reply, "✅") {
+		t.Fatalf("reply = %q, want the deferral accepted", reply)
+	}
+	sched := loadGroupSchedule(ctx, db, testGroupID)
+	if d := sched.deferral; d == nil || d.at.Sub(d.original) != time.Hour || d.original.Weekday() != time.Friday || d.original.In(sched.location).Hour() != 17 {
+		t.Fatalf("deferral = %+v, want Friday's quiz moved by an hour", d)
+	}
+}
+
+func TestQuizDeferralSurvivesRestart(t *testing.T) {
+	db, tg, api := setupDeferralGroup(t)
+	ctx := context.Background()
+	groupCommand(t, db, tg, api, "/defer_quiz 1")
+	want := loadGroupSchedule(ctx, db, testGroupID).deferral
+
+	// The bot starts again on the same database file
+	var seq int
+	var name, path string
+	if err := db.QueryRow(`PRAGMA database_list`).Scan(&seq, &name, &path); err != nil {
+		t.Fatalf("PRAGMA database_list: %v", err)
+	}
+	db.Close()
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatalf("sql.Open: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	sched := loadGroupSchedule(ctx, db, testGroupID)
+	if d := sched.deferral; d == nil || !d.at.Equal(want.at) || !d.original.Equal(want.original) {
+		t.Fatalf("deferral after restart = %+v, want %+v", d, want)
+	}
+	if job, next := nextJob(sched, time.Now()); job != jobSendQuiz || !next.Equal(want.at) {
+		t.Fatalf("next job = %s at %v, want the quiz at %v", job, next, want.at)
+	}
+	if status := formatQuizDeferrals(ctx, db); !strings.Contains(status, "группа -100: "+want.String()) {
+		t.Fatalf("/status deferrals = %q", status)
+	}
+}
+
+func TestDeferredQuizFiresAtShiftedTime(t *testing.T) {
+	withTestScheduler(t)
+	db, tg, api := setupDeferralGroup(t)
+	scheduler.db, scheduler.api = db, api
+	ctx := context.Background()
+	if err := database.SetGroupSetting(ctx, db, testGroupID, signupModeSetting, database.SignupKeyboard); err != nil {
+		t.Fatalf("SetGroupSetting: %v", err)
+	}
+
+	// The weekly quiz is days away; the deferred one is due in a moment
+	now := time.Now()
+	d := quizDeferral{original: now.Add(-time.Hour).Truncate(time.Second), at: now.Add(time.Second).Truncate(time.Second)}
+	value := d.original.Format(time.RFC3339) + "|" + d.at.Format(time.RFC3339)
+	if err := database.SetGroupSetting(ctx, db, testGroupID, quizDeferralSetting, value); err != nil {
+		t.Fatalf("SetGroupSetting: %v", err)
+	}
+	if job, next := nextJob(loadGroupSchedule(ctx, db, testGroupID), now); job != jobSendQuiz || !next.Equal(d.at) {
+		t.Fatalf("next job = %s at %v, want the quiz at the deferred time %v", job, next, d.at)
+	}
+
+	rescheduleGroup(testGroupID)
+	deadline := time.Now().Add(3 * time.Second)
+	for tg.count("sendMessage") == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("deferred quiz not sent")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if sentAt := time.Now(); sentAt.Before(d.at) {
+		t.Fatalf("quiz sent at %v, before the deferred time %v", sentAt, d.at)
+	}
+	if quiz := tg.sent(testGroupID); len(quiz) != 1 || !strings.Contains(quiz[0], "Участвуешь в Random Coffee") {
+		t.Fatalf("group got %q, want the quiz", quiz)
+	}
+
+	// Used up: the next quiz is the weekly one again
+	if _, found, _ := database.GetGroupSetting(ctx, db, testGroupID, quizDeferralSetting); found {
+		t.Fatal("deferral kept after its quiz was sent")
+	}
+	if sched := loadGroupSchedule(ctx, db, testGroupID); sched.nextQuiz(time.Now()) != sched.quiz.next(time.Now(), sched.location) {
+		t.Fatal("next quiz is not back on the weekly schedule")
+	}
+}
+
+func TestScheduleChangeRechecksDeferral(t *testing.T) {
+	db, tg, api := setupDeferralGroup(t)
+	ctx := context.Background()
+	deferral := func() *quizDeferral { return loadGroupSchedule(ctx, db, testGroupID).deferral }
+
+	// The deferred poll opens at 18:00: a later pairing keeps it
+	groupCommand(t, db, tg, api, "/defer_quiz 1")
+	if reply := groupCommand(t, db, tg, api, "/set_schedule pairs fri 21:00"); strings.Contains(reply, "Перенос опроса отменен") || deferral() == nil {
+		t.Fatalf("reply = %q, want the deferral kept", reply)
+	}
+
+	// 19:30 is far enough from the weekly quiz, but not from the deferred one
+	reply := groupCommand(t, db, tg, api, "/set_schedule pairs fri 19:30")
+	if !strings.Contains(reply, "✅ Расписание обновлено") || !strings.Contains(reply, "Перенос опроса отменен") || !strings.Contains(reply, "меньше минимального срока") {
+		t.Fatalf("reply = %q, want the schedule changed and the deferral dropped", reply)
+	}
+	if deferral() != nil {
+		t.Fatal("deferral kept although the poll would be open less than the notice")
+	}
+
+	// A new quiz time replaces the occurrence the deferral was for
+	groupCommand(t, db, tg, api, "/set_schedule pairs fri 21:00")
+	groupCommand(t, db, tg, api, "/defer_quiz 1")
+	if reply := groupCommand(t, db, tg, api, "/set_schedule quiz fri 16:00"); !strings.Contains(reply, "время опроса изменилось") {
+		t.Fatalf("reply = %q, want the deferral dropped", reply)
+	}
+	if deferral() != nil {
+		t.Fatal("deferral kept after the quiz time changed")
+	}
+}
+
+func TestApplyProfileRechecksDeferral(t *testing.T) {
+	db, tg, api := setupDeferralGroup(t)
+	ctx := context.Background()
+	groupCommand(t, db, tg, api, "/defer_quiz 1")
+
+	target := map[string]string{profileQuizKey: "чт 17:00", profilePairsKey: "пт 20:00", profileTimezoneKey: "Europe/Berlin"}
+	note, err := applyProfileSettings(ctx, db, testGroupID, target)
+	if err != nil {
+		t.Fatalf("applyProfileSettings: %v", err)
+	}
+	if !strings.Contains(note, "время опроса изменилось") || loadGroupSchedule(ctx, db, testGroupID).deferral != nil {
+		t.Fatalf("note = %q, want the deferral dropped with the new quiz time", note)
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	// maxDisplayNameLength is how many characters (grapheme clusters) of a name a message shows
+	maxDisplayNameLength = 40
+
+	// maxCombiningMarks is how many combining marks in a row a character keeps; real scripts
+	// and emoji need at most two, "zalgo" names pile up dozens
+	maxCombiningMarks = 2
+
+	zeroWidthJoiner       = '\u200d'
+	leftToRightMark       = '\u200e'
+	firstStrongIsolate    = '\u2068'
+	popDirectionalIsolate = '\u2069'
+)
+
+// isBidiControl reports whether r changes the direction of the text around it. Names must not carry
+// these: an override inside a name can reverse the separator and the name after it.
+func isBidiControl(r rune) bool {
+	switch {
+	case r == '\u061c', r == '\u200e', r == '\u200f': // arabic letter mark, LRM, RLM
+		return true
+	case r >= '\u202a' && r <= '\u202e': // embeddings and overrides
+		return true
+	case r >= '\u2066' && r <= '\u2069': // isolates
+		return true
+	}
+	return false
+}
+
+// isRTL reports whether r is a letter of a right-to-left script
+func isRTL(r rune) bool {
+	return unicode.In(r, unicode.Hebrew, unicode.Arabic, unicode.Syriac, unicode.Thaana, unicode.Nko) && unicode.IsLetter(r)
+}
+
+// isCombiningMark reports whether r is drawn on top of the character before it
+func isCombiningMark(r rune) bool {
+	return unicode.In(r, unicode.Mn, unicode.Me)
+}
+
+// extendsGrapheme reports whether r belongs to the same user-visible character as the rune before it.
+// It approximates Unicode grapheme clusters closely enough for names and emoji: marks, variation
+// selectors, skin tone modifiers, emoji tag sequences and whatever follows a zero-width joiner.
+func extendsGrapheme(prev, r rune) bool {
+	switch {
+	case prev == zeroWidthJoiner, r == zeroWidthJoiner:
+		return true
+	case unicode.In(r, unicode.Mn, unicode.Me, unicode.Mc):
+		return true
+	case r >= 0x1f3fb && r <= 0x1f3ff: // skin tone modifiers
+		return true
+	case r >= 0xe0020 && r <= 0xe007f: // tags of subdivision flags
+		return true
+	case prev == '\r' && r == '\n':
+		return true
+	}
+	return false
+}
+
+// isRegionalIndicator reports whether r is half of a flag emoji
+func isRegionalIndicator(r rune) bool {
+	return r >= 0x1f1e6 && r <= 0x1f1ff
+}
+
+// nextGrapheme returns the length in bytes of the first user-visible character of s
+func nextGrapheme(s string) int {
+	prev, n := utf8.DecodeRuneInString(s)
+	if n == 0 {
+		return 0
+	}
+	// Two regional indicators make one flag, a third starts the next one
+	if r, size := utf8.DecodeRuneInString(s[n:]); isRegionalIndicator(prev) && isRegionalIndicator(r) {
+		prev = r
+		n += size
+	}
+	for n < len(s) {
+		r, size := utf8.DecodeRuneInString(s[n:])
+		if !extendsGrapheme(prev, r) {
+			break
+		}
+		prev = r
+		n += size
+	}
+	return n
+}
+
+// sanitizeDisplayName makes a name safe to put into a fixed-format message: direction controls are
+// dropped, runs of combining marks are cut short, a long name is shortened to maxDisplayNameLength
+// characters with an ellipsis without splitting a character, and a name with right-to-left letters
+// is isolated so the text around it, like the "✖️" between two names, stays where it was written.
+func sanitizeDisplayName(name string) string {
+	var b strings.Builder
+	marks := 0
+	rtl := false
+	for _, r := range name {
+		if isBidiControl(r) {
+			continue
+		}
+		if isCombiningMark(r) {
+			if marks++; marks > maxCombiningMarks {
+				continue
+			}
+		} else {
+			marks = 0
+		}
+		rtl = rtl || isRTL(r)
+		b.WriteRune(r)
+	}
+	clean := strings.TrimSpace(b.String())
+
+	shown, count := 0, 0
+	for shown < len(clean) && count < maxDisplayNameLength {
+		shown += nextGrapheme(clean[shown:])
+		count++
+	}
+	if shown < len(clean) {
+		clean = strings.TrimSpace(clean[:shown]) + "…"
+	}
+
+	if rtl {
+		// The isolate keeps the name's direction inside it; the marks around it hold the neighbouring
+		// text left-to-right on clients that ignore isolates
+		return string(leftToRightMark) + string(firstStrongIsolate) + clean + string(popDirectionalIsolate) + string(leftToRightMark)
+	}
+	return clean
+}
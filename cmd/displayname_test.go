@@ -0,0 +1,97 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+const (
+	family  = "👨\u200d👩\u200d👧" // one emoji of three people joined by ZWJ
+	flagRU  = "🇷🇺"
+	eAcute  = "e\u0301" // e with a combining acute accent
+	isolate = "\u200e\u2068"
+	popped  = "\u2069\u200e"
+)
+
+func TestNextGrapheme(t *testing.T) {
+	tests := []struct {
+		name string
+		s    string
+		want string
+	}{
+		{"empty", "", ""},
+		{"latin", "ab", "a"},
+		{"cyrillic", "жи", "ж"},
+		{"combining mark", eAcute + "x", eAcute},
+		{"zwj sequence", family + "x", family},
+		{"skin tone", "👍🏽x", "👍🏽"},
+		{"flag", flagRU + "🇰🇿", flagRU},
+		{"subdivision flag", "🏴\U000e0067\U000e0062\U000e0073\U000e0063\U000e0074\U000e007f!", "🏴\U000e0067\U000e0062\U000e0073\U000e0063\U000e0074\U000e007f"},
+		{"crlf", "\r\nx", "\r\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.s[:nextGrapheme(tt.s)]; got != tt.want {
+				t.Fatalf("nextGrapheme(%q) takes %q, want %q", tt.s, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSanitizeDisplayName(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"latin", "John Smith", "John Smith"},
+		{"cyrillic", "Анна Иванова", "Анна Иванова"},
+		{"accent kept", "Ren" + eAcute, "Ren" + eAcute},
+		{"emoji kept", "Оля " + family, "Оля " + family},
+		{"spaces trimmed", "  Анна  ", "Анна"},
+		{"arabic isolated", "محمد", isolate + "محمد" + popped},
+		{"hebrew isolated", "דוד כהן", isolate + "דוד כהן" + popped},
+		{"mixed isolated", "Ali علي", isolate + "Ali علي" + popped},
+		{"override stripped", "abc\u202edcba", "abcdcba"},
+		{"embedding stripped", "\u202bname\u202c", "name"},
+		{"isolates and marks stripped", "\u2066a\u2069\u200f\u061cb", "ab"},
+		{"override in rtl name", "\u202eדוד", isolate + "דוד" + popped},
+		{"zalgo capped", "Z" + strings.Repeat("\u0301", 30) + "a" + strings.Repeat("\u0316", 5), "Z\u0301\u0301a\u0316\u0316"},
+		{"40 characters kept", strings.Repeat("я", 40), strings.Repeat("я", 40)},
+		{"41 characters cut", strings.Repeat("я", 41), strings.Repeat("я", 40) + "…"},
+		{"cut keeps a zwj emoji whole", strings.Repeat("a", 39) + family + "b", strings.Repeat("a", 39) + family + "…"},
+		{"cut keeps an accent", strings.Repeat(eAcute, 45), strings.Repeat(eAcute, 40) + "…"},
+		{"cut keeps flags", strings.Repeat(flagRU, 41), strings.Repeat(flagRU, 40) + "…"},
+		{"cut trims the space before the ellipsis", strings.Repeat("a", 39) + " bcd", strings.Repeat("a", 39) + "…"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sanitizeDisplayName(tt.in); got != tt.want {
+				t.Fatalf("sanitizeDisplayName(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSanitizeDisplayNameNeverSplitsACharacter(t *testing.T) {
+	clusters := []string{"a", "я", eAcute, family, flagRU, "👍🏽", "ש"}
+	for start := range clusters {
+		// Names of every length around the limit, cycling through the clusters from a different one each time
+		var name []string
+		for n := 0; n < maxDisplayNameLength+5; n++ {
+			name = append(name, clusters[(start+n)%len(clusters)])
+
+			got := strings.TrimSuffix(strings.TrimPrefix(strings.TrimSuffix(sanitizeDisplayName(strings.Join(name, "")), popped), isolate), "…")
+			shown := 0
+			for i := 0; shown < len(got); i++ {
+				if i == len(name) || !strings.HasPrefix(got[shown:], name[i]) {
+					t.Fatalf("%d characters: %q splits a character", len(name), got)
+				}
+				shown += len(name[i])
+				if i+1 > maxDisplayNameLength {
+					t.Fatalf("%d characters: %q shows more than %d", len(name), got, maxDisplayNameLength)
+				}
+			}
+		}
+	}
+}
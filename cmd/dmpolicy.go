@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"example.com/random_coffee/database"
+	"github.com/NicoNex/echotron/v3"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// dmPolicySetting is the group setting deciding whether group events may DM its members
+	dmPolicySetting = "dm_policy"
+
+	dmPolicyOff    = "off"     // no DMs, whatever users chose
+	dmPolicyOptIn  = "opt-in"  // only users who enabled DMs get them
+	dmPolicyOptOut = "opt-out" // everyone except users who disabled DMs
+	dmPolicyOn     = "on"      // everyone, whatever users chose
+
+	// defaultDMPolicy keeps DMs going to everyone who hasn't turned them off
+	defaultDMPolicy = dmPolicyOptOut
+
+	// dmStartPayload is the /start deep-link payload that enables DMs: t.me/<bot>?start=notify
+	dmStartPayload = "notify"
+)
+
+// dmPreference is a user's own choice about DMs
+type dmPreference int
+
+const (
+	dmPrefUnset dmPreference = iota
+	dmPrefEnabled
+	dmPrefDisabled
+)
+
+func isDMPolicy(value string) bool {
+	switch value {
+	case dmPolicyOff, dmPolicyOptIn, dmPolicyOptOut, dmPolicyOn:
+		return true
+	default:
+		return false
+	}
+}
+
+// shouldNotify decides whether a group-triggered DM may be sent to a user
+func shouldNotify(policy string, pref dmPreference) bool {
+	switch policy {
+	case dmPolicyOff:
+		return false
+	case dmPolicyOn:
+		return true
+	case dmPolicyOptIn:
+		return pref == dmPrefEnabled
+	default:
+		return pref != dmPrefDisabled
+	}
+}
+
+// groupDMPolicy returns the group's DM policy, or the default when unset or unreadable
+func groupDMPolicy(ctx context.Context, db *sql.DB, groupID int64) string {
+	value, found, err := database.GetGroupSetting(ctx, db, groupID, dmPolicySetting)
+	if err != nil {
+		groupEvent(log.Warn(), EventSettingsReadFailed, groupID).Err(err).Str("key", dmPolicySetting).Msg("GetGroupSetting failed")
+		return defaultDMPolicy
+	}
+	if !found || !isDMPolicy(value) {
+		return defaultDMPolicy
+	}
+	return value
+}
+
+// userDMPreference returns the user's choice; an unreadable choice counts as unset
+func userDMPreference(ctx context.Context, db *sql.DB, userID int64) dmPreference {
+	enabled, found, err := database.GetDMPreference(ctx, db, userID)
+	if err != nil {
+		botEvent(log.Warn(), EventDMPreferenceFailed).Err(err).Int64("user_id", userID).Msg("GetDMPreference failed")
+		return dmPrefUnset
+	}
+	switch {
+	case !found:
+		return dmPrefUnset
+	case enabled:
+		return dmPrefEnabled
+	default:
+		return dmPrefDisabled
+	}
+}
+
+// sendGroupDM sends a DM caused by something in the group, unless the group's policy
+// and the user's preference rule it out
+func sendGroupDM(ctx context.Context, db *sql.DB, api echotron.API, groupID, userID int64, text string) {
+	policy := groupDMPolicy(ctx, db, groupID)
+	if !shouldNotify(policy, userDMPreference(ctx, db, userID)) {
+		userEvent(log.Debug(), EventDMSuppressed, groupID, userID).Str("policy", policy).Msg("DM suppressed by policy")
+		return
+	}
+	sendMessage(api, text, userID)
+}
+
+// dmCallToAction tells the group how to change their DM preference; empty when users can't change it
+func dmCallToAction(policy string) string {
+	switch policy {
+	case dmPolicyOptIn:
+		return "\n\n🔔 Хочешь получать личные сообщения о встречах? Напиши боту в личку /notifications on"
+	case dmPolicyOptOut:
+		return "\n\n🔕 Не хочешь личных сообщений от бота? Напиши ему в личку /notifications off"
+	default:
+		return ""
+	}
+}
+
+// handleDMPolicyCommand implements /dm_policy [off|opt-in|opt-out|on] in a group
+func handleDMPolicyCommand(ctx context.Context, db *sql.DB, api echotron.API, message *echotron.Message, args []string) {
+	groupID := message.Chat.ID
+	if len(args) != 1 || !isDMPolicy(args[0]) {
+		sendMessage(api, fmt.Sprintf("Личные сообщения участникам: %s\n"+
+			"Использование: /dm_policy off|opt-in|opt-out|on\n"+
+			"• off - бот не пишет участникам в личку\n"+
+			"• opt-in - только тем, кто включил /notifications on\n"+
+			"• opt-out - всем, кроме отключивших /notifications off\n"+
+			"• on - всем", groupDMPolicy(ctx, db, groupID)), groupID)
+		return
+	}
+
+	if err := database.SetGroupSetting(ctx, db, groupID, dmPolicySetting, args[0]); err != nil {
+		groupEvent(log.Error(), EventSettingsSaveFailed, groupID).Err(err).Str("key", dmPolicySetting).Msg("SetGroupSetting failed")
+		sendMessage(api, "❌ Не удалось сохранить настройку", groupID)
+		return
+	}
+
+	writeAudit(ctx, db, message.From.ID, "dm_policy", groupID, args[0])
+	groupEvent(log.Info(), EventDMPolicyChanged, groupID).Str("policy", args[0]).Msg("DM policy changed")
+	sendMessage(api, fmt.Sprintf("✅ Политика личных сообщений: %s", args[0]), groupID)
+}
+
+// setDMPreference stores the user's choice and confirms it in their language
+func setDMPreference(ctx context.Context, db *sql.DB, api echotron.API, message *echotron.Message, enabled bool, lang string) {
+	chatID := message.Chat.ID
+	if err := database.SetDMPreference(ctx, db, message.From.ID, enabled); err != nil {
+		botEvent(log.Error(), EventDMPreferenceFailed).Err(err).Int64("user_id", message.From.ID).Msg("SetDMPreference failed")
+		sendMessage(api, tr(lang, "notifications.save_failed"), chatID)
+		return
+	}
+
+	botEvent(log.Info(), EventDMPreferenceChanged).Int64("user_id", message.From.ID).Bool("enabled", enabled).Msg("DM preference changed")
+	if enabled {
+		sendMessage(api, tr(lang, "notifications.on"), chatID)
+	} else {
+		sendMessage(api, tr(lang, "notifications.off"), chatID)
+	}
+}
+
+// handleNotificationsCommand implements /notifications on|off in a private chat
+func handleNotificationsCommand(ctx context.Context, db *sql.DB, api echotron.API, message *echotron.Message, args []string, lang string) {
+	if len(args) != 1 || (args[0] != "on" && args[0] != "off") {
+		sendMessage(api, tr(lang, "notifications.usage"), message.Chat.ID)
+		return
+	}
+	setDMPreference(ctx, db, api, message, args[0] == "on", lang)
+}
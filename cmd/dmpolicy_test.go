@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"example.com/random_coffee/database"
+)
+
+func TestDMPolicyMatrix(t *testing.T) {
+	// want is indexed by the user's preference: unset, enabled, disabled
+	tests := []struct {
+		policy string // "" leaves the group setting unset
+		want   [3]bool
+	}{
+		{dmPolicyOff, [3]bool{false, false, false}},
+		{dmPolicyOptIn, [3]bool{false, true, false}},
+		{dmPolicyOptOut, [3]bool{true, true, false}},
+		{dmPolicyOn, [3]bool{true, true, true}},
+		{"", [3]bool{true, true, false}},
+		{"sometimes", [3]bool{true, true, false}},
+	}
+	enabled, disabled := true, false
+	prefs := []struct {
+		name    string
+		enabled *bool // nil leaves the preference unset
+	}{
+		{"unset", nil},
+		{"enabled", &enabled},
+		{"disabled", &disabled},
+	}
+	for _, tt := range tests {
+		for i, pref := range prefs {
+			name := tt.policy
+			if name == "" {
+				name = "default"
+			}
+			t.Run(name+"/"+pref.name, func(t *testing.T) {
+				db, tg, api := setupManualPairsGroup(t)
+				ctx := context.Background()
+				if tt.policy != "" {
+					if err := database.SetGroupSetting(ctx, db, testGroupID, dmPolicySetting, tt.policy); err != nil {
+						t.Fatalf("SetGroupSetting: %v", err)
+					}
+				}
+				if pref.enabled != nil {
+					if err := database.SetDMPreference(ctx, db, 1, *pref.enabled); err != nil {
+						t.Fatalf("SetDMPreference: %v", err)
+					}
+				}
+
+				// User 1 volunteered and meets first-timer user 2
+				pair := []database.Participant{{GroupID: testGroupID, UserID: 1, Username: "user1"}, {GroupID: testGroupID, UserID: 2, Username: "user2"}}
+				cohort := buddyCohort{firstTimers: map[int64]bool{2: true}, volunteers: map[int64]bool{1: true}}
+				notifyBuddies(ctx, db, api, testGroupID, [][]database.Participant{pair}, cohort, "")
+
+				sent := tg.sent(1)
+				if got := len(sent) == 1 && strings.Contains(sent[0], "Это первый Random Coffee"); got != tt.want[i] {
+					t.Fatalf("volunteer got %q, want a DM %v", sent, tt.want[i])
+				}
+				if sent := tg.sent(2); len(sent) != 0 {
+					t.Fatalf("first-timer got %q, want nothing", sent)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"example.com/random_coffee/database"
+	"github.com/NicoNex/echotron/v3"
+	"github.com/rs/zerolog/log"
+)
+
+// chatProbe is what GetChat tells about a registered group
+type chatProbe struct {
+	groupID    int64
+	ok         bool // the chat answers under this ID
+	upgraded   bool // Telegram says the group became a supergroup with another ID
+	inviteLink string
+}
+
+// isChatUpgradedError reports whether Telegram refused a request because the group was upgraded to a supergroup
+func isChatUpgradedError(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "upgraded to a supergroup")
+}
+
+func probeChat(api echotron.API, groupID int64) chatProbe {
+	p := chatProbe{groupID: groupID}
+	res, err := api.GetChat(groupID)
+	switch {
+	case err == nil && res.Result != nil:
+		p.ok = true
+		p.inviteLink = res.Result.InviteLink
+	case isChatUpgradedError(err):
+		p.upgraded = true
+	case err != nil:
+		groupEvent(log.Debug(), EventDuplicateCheckFailed, groupID).Err(err).Msg("GetChat failed")
+	}
+	return p
+}
+
+// groupDuplicate is a stale registration of a chat that is also registered under its current ID
+type groupDuplicate struct {
+	stale   int64
+	current int64
+}
+
+// findDuplicateGroups decides which registrations are the same chat. It is deliberately conservative:
+// a registration is merged only when Telegram told the bot the chat moved to the other ID (the recorded
+// migration), the old ID no longer answers and the new one does. Registrations sharing an invite link
+// are only reported as suspects, and titles are never compared at all. Upgraded groups whose new ID
+// is unknown or not registered are returned as unresolved.
+func findDuplicateGroups(probes []chatProbe, migrations map[int64]int64) (dups []groupDuplicate, suspects [][2]int64, unresolved []int64) {
+	byID := make(map[int64]chatProbe, len(probes))
+	for _, p := range probes {
+		byID[p.groupID] = p
+	}
+
+	for _, p := range probes {
+		if p.ok {
+			continue
+		}
+		current, linked := byID[migrations[p.groupID]]
+		switch {
+		case linked && current.ok && current.groupID != p.groupID:
+			dups = append(dups, groupDuplicate{stale: p.groupID, current: current.groupID})
+		case p.upgraded:
+			unresolved = append(unresolved, p.groupID)
+		}
+	}
+
+	byLink := make(map[string]int64)
+	for _, p := range probes {
+		if !p.ok || p.inviteLink == "" {
+			continue
+		}
+		if other, seen := byLink[p.inviteLink]; seen {
+			suspects = append(suspects, [2]int64{other, p.groupID})
+			continue
+		}
+		byLink[p.inviteLink] = p.groupID
+	}
+	return dups, suspects, unresolved
+}
+
+// checkDuplicateGroups probes every active group, merges registrations that turned out to be the same
+// chat and tells admins about what it could not settle on its own. Run at startup.
+func checkDuplicateGroups(ctx context.Context, db *sql.DB, api echotron.API) {
+	migrations, err := database.GetChatMigrations(ctx, db)
+	if err != nil {
+		botEvent(log.Error(), EventDuplicateCheckFailed).Err(err).Msg("GetChatMigrations failed, duplicate check skipped")
+		return
+	}
+
+	groupIDs := getConfiguredGroups(ctx, db)
+	probes := make([]chatProbe, 0, len(groupIDs))
+	for _, id := range groupIDs {
+		probes = append(probes, probeChat(api, id))
+	}
+
+	dups, suspects, unresolved := findDuplicateGroups(probes, migrations)
+	for _, d := range dups {
+		mergeDuplicateGroup(ctx, db, api, d)
+	}
+	for _, s := range suspects {
+		botEvent(log.Warn(), EventDuplicateSuspected).Int64("group_id", s[0]).Int64("other_group_id", s[1]).Msg("Two groups share an invite link")
+		notifyAdmins(api, fmt.Sprintf("⚠️ У групп %s и %s одна и та же ссылка-приглашение - возможно, это один чат. "+
+			"Автоматически не объединены: проверь и отключи лишнюю командой /unregister в ней",
+			groupLabel(ctx, db, s[0]), groupLabel(ctx, db, s[1])))
+	}
+	for _, id := range unresolved {
+		reportUpgradedGroup(ctx, db, api, id, migrations[id])
+	}
+	botEvent(log.Info(), EventDuplicateChecked).Int("groups", len(probes)).Int("merged", len(dups)).
+		Int("suspected", len(suspects)).Int("unresolved", len(unresolved)).Msg("Duplicate group check done")
+}
+
+// mergeIfDuplicate checks a group before its scheduled quiz and reports whether it was merged into
+// the registration of its new ID, in which case the quiz must not be sent
+func mergeIfDuplicate(ctx context.Context, db *sql.DB, api echotron.API, groupID int64) bool {
+	p := probeChat(api, groupID)
+	if p.ok || !p.upgraded {
+		return false
+	}
+
+	migrations, err := database.GetChatMigrations(ctx, db)
+	if err != nil {
+		groupEvent(log.Error(), EventDuplicateCheckFailed, groupID).Err(err).Msg("GetChatMigrations failed")
+		return false
+	}
+	newID := migrations[groupID]
+	probes := []chatProbe{p}
+	if newID != 0 && isConfiguredGroup(ctx, db, newID) {
+		probes = append(probes, probeChat(api, newID))
+	}
+
+	dups, _, unresolved := findDuplicateGroups(probes, migrations)
+	for _, d := range dups {
+		if mergeDuplicateGroup(ctx, db, api, d) {
+			return true
+		}
+	}
+	for _, id := range unresolved {
+		reportUpgradedGroup(ctx, db, api, id, newID)
+	}
+	return false
+}
+
+// mergeDuplicateGroup copies the stale registration's pair history and schedule into the current one,
+// the way /clone_group_data does, then deactivates the stale one. It reports whether the merge happened;
+// on a failed copy nothing is deactivated.
+func mergeDuplicateGroup(ctx context.Context, db *sql.DB, api echotron.API, d groupDuplicate) bool {
+	history, err := database.GetPairHistory(ctx, db, d.stale)
+	if err != nil {
+		groupEvent(log.Error(), EventDuplicateMergeFailed, d.stale).Err(err).Msg("GetPairHistory failed")
+		return false
+	}
+	copied, err := database.CopyPairs(ctx, db, d.current, history)
+	if err != nil {
+		groupEvent(log.Error(), EventDuplicateMergeFailed, d.current).Err(err).Int64("stale_group_id", d.stale).Msg("CopyPairs failed")
+		return false
+	}
+
+	// The schedule only moves over if the new registration has none of its own
+	if sc, err := database.GetGroupConfig(ctx, db, d.stale); err == nil {
+		if _, err := database.GetGroupConfig(ctx, db, d.current); errors.Is(err, sql.ErrNoRows) {
+			sc.GroupID = d.current
+			if err := database.UpsertGroupConfig(ctx, db, *sc); err != nil {
+				groupEvent(log.Warn(), EventDuplicateMergeFailed, d.current).Err(err).Msg("UpsertGroupConfig failed, schedule not moved")
+			}
+		}
+	}
+
+	if _, err := database.DeactivateGroup(ctx, db, d.stale); err != nil {
+		groupEvent(log.Error(), EventDuplicateMergeFailed, d.stale).Err(err).Msg("DeactivateGroup failed")
+		return false
+	}
+	rescheduleGroup(d.stale)
+	rescheduleGroup(d.current)
+
+	writeAudit(ctx, db, 0, "merge_duplicate_group", d.current, fmt.Sprintf("stale=%d pairs=%d", d.stale, copied))
+	groupEvent(log.Warn(), EventDuplicateMerged, d.current).Int64("stale_group_id", d.stale).Int64("pairs_copied", copied).
+		Msg("Duplicate registration merged and deactivated")
+	notifyAdmins(api, fmt.Sprintf("🔀 Группа %d стала супергруппой %s и была подключена дважды. Старая регистрация "+
+		"отключена, история пар перенесена (пар: %d), опросы теперь уходят только в новую группу",
+		d.stale, groupLabel(ctx, db, d.current), copied))
+	return true
+}
+
+// reportUpgradedGroup tells admins about a registered group Telegram upgraded to a supergroup when there
+// is no registration of the new ID to merge into
+func reportUpgradedGroup(ctx context.Context, db *sql.DB, api echotron.API, groupID, newID int64) {
+	groupEvent(log.Warn(), EventDuplicateSuspected, groupID).Int64("new_group_id", newID).Msg("Group upgraded to a supergroup, new ID not registered")
+	text := fmt.Sprintf("⚠️ Группа %s стала супергруппой, и бот больше не может в нее писать. ", groupLabel(ctx, db, groupID))
+	if newID != 0 {
+		text += fmt.Sprintf("Ее новый ID - %d: выполни /register в группе, и регистрации объединятся автоматически", newID)
+	} else {
+		text += "Выполни /register в новой группе и /unregister в старой, если она еще доступна"
+	}
+	notifyAdmins(api, text)
+}
+
+// groupLabel names a group by title and ID for admin messages
+func groupLabel(ctx context.Context, db *sql.DB, groupID int64) string {
+	if title := groupTitle(ctx, db, groupID); title != fmt.Sprintf("%d", groupID) {
+		return fmt.Sprintf("%s (%d)", title, groupID)
+	}
+	return fmt.Sprintf("%d", groupID)
+}
+
+// handleChatMigrationMessage records the service message Telegram sends in both chats when a group becomes
+// a supergroup, and merges the registrations right away if both IDs are registered by then
+func handleChatMigrationMessage(ctx context.Context, db *sql.DB, api echotron.API, msg *echotron.Message) {
+	oldID, newID := msg.Chat.ID, int64(msg.MigrateToChatID)
+	if msg.MigrateFromChatID != 0 {
+		oldID, newID = int64(msg.MigrateFromChatID), msg.Chat.ID
+	}
+
+	if err := database.RecordChatMigration(ctx, db, oldID, newID); err != nil {
+		groupEvent(log.Error(), EventDuplicateCheckFailed, oldID).Err(err).Int64("new_group_id", newID).Msg("RecordChatMigration failed")
+		return
+	}
+	groupEvent(log.Info(), EventChatMigrated, oldID).Int64("new_group_id", newID).Msg("Group upgraded to a supergroup")
+
+	if isConfiguredGroup(ctx, db, oldID) && isConfiguredGroup(ctx, db, newID) {
+		mergeIfDuplicate(ctx, db, api, oldID)
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"example.com/random_coffee/database"
+	"github.com/NicoNex/echotron/v3"
+	"github.com/google/uuid"
+)
+
+const (
+	legacyGroupID = -987
+	superGroupID  = -100987
+
+	chatUpgraded = `{"ok":false,"error_code":400,"description":"Bad Request: group chat was upgraded to a supergroup chat","parameters":{"migrate_to_chat_id":-100987}}`
+)
+
+// answerGetChat makes getChat answer for the listed chats, with their invite links, and fail as upgraded for the rest
+func answerGetChat(tg *fakeTelegram, links map[int64]string) {
+	tg.reply("getChat", func(params url.Values) string {
+		for id, link := range links {
+			if params.Get("chat_id") == fmt.Sprint(id) {
+				return fmt.Sprintf(`{"ok":true,"result":{"id":%d,"type":"supergroup","title":"Coffee","invite_link":%q}}`, id, link)
+			}
+		}
+		return chatUpgraded
+	})
+}
+
+// setupLegacyGroup registers the pre-upgrade group with a schedule and a week of pairs
+func setupLegacyGroup(t *testing.T) (*sql.DB, *fakeTelegram, echotron.API) {
+	t.Helper()
+	db := openTestDB(t)
+	tg, api := newFakeTelegram(t)
+	withAdmins(t, testAdminID)
+	ctx := context.Background()
+
+	seedTestGroup(t, db, legacyGroupID, "Coffee")
+	sc := database.GroupConfig{GroupID: legacyGroupID, QuizWeekday: int(time.Friday), QuizHour: 17, PairsWeekday: int(time.Sunday), PairsHour: 19, Timezone: "Europe/Moscow"}
+	if err := database.UpsertGroupConfig(ctx, db, sc); err != nil {
+		t.Fatalf("UpsertGroupConfig: %v", err)
+	}
+	p := database.Pair{ID: uuid.New(), GroupID: legacyGroupID, WeekStart: "2026-04-27", User1ID: 1, User2ID: 2, CreatedAt: time.Now()}
+	if err := database.CreatePairs(ctx, db, []database.Pair{p}); err != nil {
+		t.Fatalf("CreatePairs: %v", err)
+	}
+	return db, tg, api
+}
+
+// registerGroup sends /register in the chat
+func registerGroup(db *sql.DB, api echotron.API, groupID int64) {
+	HandleGroupCommand(context.Background(), db, api, &echotron.Message{Text: "/register",
+		Chat: echotron.Chat{ID: groupID, Type: "supergroup", Title: "Coffee"}, From: &echotron.User{ID: testAdminID}})
+}
+
+func TestMigratedRegistrationMerged(t *testing.T) {
+	db, tg, api := setupLegacyGroup(t)
+	ctx := context.Background()
+	if err := database.RecordChatMigration(ctx, db, legacyGroupID, superGroupID); err != nil {
+		t.Fatalf("RecordChatMigration: %v", err)
+	}
+	answerGetChat(tg, map[int64]string{superGroupID: "https://t.me/+coffee"})
+
+	registerGroup(db, api, superGroupID)
+
+	if isConfiguredGroup(ctx, db, legacyGroupID) || !isConfiguredGroup(ctx, db, superGroupID) {
+		t.Fatal("want the old registration deactivated and the new one active")
+	}
+	if pairs, err := database.GetPairHistory(ctx, db, superGroupID); err != nil || len(pairs) != 1 {
+		t.Fatalf("GetPairHistory(new) = %+v, %v; want the history moved over", pairs, err)
+	}
+	if sc, err := database.GetGroupConfig(ctx, db, superGroupID); err != nil || sc.QuizHour != 17 || sc.Timezone != "Europe/Moscow" {
+		t.Fatalf("GetGroupConfig(new) = %+v, %v; want the schedule moved over", sc, err)
+	}
+	if sent := tg.sent(testAdminID); len(sent) != 1 || !strings.Contains(sent[0], "🔀 Группа -987 стала супергруппой") {
+		t.Fatalf("admin got %q, want the merge reported", sent)
+	}
+
+	// Registering again finds nothing left to merge
+	registerGroup(db, api, superGroupID)
+	if pairs, _ := database.GetPairHistory(ctx, db, superGroupID); len(pairs) != 1 || len(tg.sent(testAdminID)) != 1 {
+		t.Fatalf("second /register merged again: %d pairs, admin got %q", len(pairs), tg.sent(testAdminID))
+	}
+}
+
+func TestLookalikeRegistrationsNotMerged(t *testing.T) {
+	tests := []struct {
+		name      string
+		migrated  bool             // the upgrade to superGroupID was recorded
+		answering map[int64]string // chats GetChat answers for, with their invite links
+	}{
+		// Same title, both chats alive, nothing links them
+		{"same title", false, map[int64]string{legacyGroupID: "https://t.me/+a", superGroupID: "https://t.me/+b"}},
+		// The old chat still answers, so the recorded migration isn't trusted on its own
+		{"old chat still answers", true, map[int64]string{legacyGroupID: "https://t.me/+a", superGroupID: "https://t.me/+b"}},
+		// Upgraded, but nothing says the new chat is where it went
+		{"upgraded without a known target", false, map[int64]string{superGroupID: "https://t.me/+b"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, tg, api := setupLegacyGroup(t)
+			ctx := context.Background()
+			if tt.migrated {
+				if err := database.RecordChatMigration(ctx, db, legacyGroupID, superGroupID); err != nil {
+					t.Fatalf("RecordChatMigration: %v", err)
+				}
+			}
+			answerGetChat(tg, tt.answering)
+
+			registerGroup(db, api, superGroupID)
+
+			if !isConfiguredGroup(ctx, db, legacyGroupID) || !isConfiguredGroup(ctx, db, superGroupID) {
+				t.Fatal("a registration was deactivated")
+			}
+			if pairs, _ := database.GetPairHistory(ctx, db, superGroupID); len(pairs) != 0 {
+				t.Fatalf("%d pairs copied to the new registration", len(pairs))
+			}
+			for _, text := range tg.sent(testAdminID) {
+				if strings.Contains(text, "🔀") {
+					t.Fatalf("admin got %q, want no merge", text)
+				}
+			}
+		})
+	}
+}
+
+func TestSharedInviteLinkOnlyReported(t *testing.T) {
+	db, tg, api := setupLegacyGroup(t)
+	ctx := context.Background()
+	seedTestGroup(t, db, superGroupID, "Coffee")
+	answerGetChat(tg, map[int64]string{legacyGroupID: "https://t.me/+coffee", superGroupID: "https://t.me/+coffee"})
+
+	checkDuplicateGroups(ctx, db, api)
+
+	if !isConfiguredGroup(ctx, db, legacyGroupID) || !isConfiguredGroup(ctx, db, superGroupID) {
+		t.Fatal("a registration was deactivated on a shared invite link alone")
+	}
+	if sent := tg.sent(testAdminID); len(sent) != 1 || !strings.Contains(sent[0], "одна и та же ссылка-приглашение") {
+		t.Fatalf("admin got %q, want the suspected duplicate reported", sent)
+	}
+}
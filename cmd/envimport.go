@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+
+	"example.com/random_coffee/database"
+	"github.com/NicoNex/echotron/v3"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	envGroupImportCallback = "env_group_import"
+	envGroupIgnoreCallback = "env_group_ignore"
+)
+
+// importEnvGroups registers the groups listed in GROUP_CHAT_IDS on the first boot and records the list.
+// Later boots only compare the env with that snapshot: admins are asked about each group added to it,
+// so editing the env never brings back a group on its own.
+func importEnvGroups(ctx context.Context, db *sql.DB, api echotron.API) {
+	envIDs := parseCommaSeparatedIDs("GROUP_CHAT_IDS", "group")
+
+	snapshot, err := database.GetEnvSnapshot(ctx, db)
+	if err != nil {
+		botEvent(log.Error(), EventEnvImportFailed).Err(err).Msg("GetEnvSnapshot failed")
+		return
+	}
+	if snapshot == nil {
+		for _, groupID := range envIDs {
+			if err := database.SeedGroup(ctx, db, groupID); err != nil {
+				groupEvent(log.Error(), EventGroupSaveFailed, groupID).Err(err).Msg("SeedGroup failed")
+				return
+			}
+		}
+		if err := database.SaveEnvSnapshot(ctx, db, envIDs); err != nil {
+			botEvent(log.Error(), EventEnvImportFailed).Err(err).Msg("SaveEnvSnapshot failed")
+			return
+		}
+		botEvent(log.Info(), EventEnvImported).Int("count", len(envIDs)).Msg("Groups imported from env")
+		return
+	}
+
+	// Groups dropped from the env leave the snapshot, so putting one back is asked about again
+	kept := make([]int64, 0, len(snapshot.GroupIDs))
+	for _, groupID := range snapshot.GroupIDs {
+		if slices.Contains(envIDs, groupID) {
+			kept = append(kept, groupID)
+		}
+	}
+	if len(kept) != len(snapshot.GroupIDs) {
+		if err := database.SaveEnvSnapshot(ctx, db, kept); err != nil {
+			botEvent(log.Error(), EventEnvImportFailed).Err(err).Msg("SaveEnvSnapshot failed")
+			return
+		}
+	}
+
+	for _, groupID := range envIDs {
+		if slices.Contains(kept, groupID) {
+			continue
+		}
+		_, err := database.GetGroup(ctx, db, groupID)
+		switch {
+		case err == nil:
+			// Already known, e.g. registered with /register: the env and the database agree
+			if _, err := database.AddToEnvSnapshot(ctx, db, groupID); err != nil {
+				groupEvent(log.Error(), EventEnvImportFailed, groupID).Err(err).Msg("AddToEnvSnapshot failed")
+			}
+		case errors.Is(err, sql.ErrNoRows):
+			offerEnvGroup(api, groupID)
+		default:
+			groupEvent(log.Error(), EventGroupQueryFailed, groupID).Err(err).Msg("GetGroup failed")
+		}
+	}
+}
+
+// offerEnvGroup asks admins whether a group found only in the env should be registered.
+// It is asked on every boot until one of them answers.
+func offerEnvGroup(api echotron.API, groupID int64) {
+	text := fmt.Sprintf("⚙️ В ENV есть группа %d, которой нет в базе. Добавить ее в Random Coffee?", groupID)
+	opts := &echotron.MessageOptions{
+		ReplyMarkup: echotron.InlineKeyboardMarkup{InlineKeyboard: [][]echotron.InlineKeyboardButton{{
+			{Text: "Добавить", CallbackData: fmt.Sprintf("%s:%d", envGroupImportCallback, groupID)},
+			{Text: "Игнорировать", CallbackData: fmt.Sprintf("%s:%d", envGroupIgnoreCallback, groupID)},
+		}}},
+	}
+	for adminID := range adminChatIDsMap {
+		if _, err := api.SendMessage(text, adminID, opts); err != nil {
+			botEvent(log.Warn(), EventMessageBlocked).Err(err).Int64("chat_id", adminID).Msg("Failed to send env group offer")
+		}
+	}
+	groupEvent(log.Warn(), EventEnvGroupOffered, groupID).Msg("Group found only in env, admins asked")
+}
+
+// handleEnvGroupCallback imports or ignores a group found only in the env; either way it joins the
+// snapshot, so it isn't asked about again
+func handleEnvGroupCallback(ctx context.Context, db *sql.DB, api echotron.API, cq *echotron.CallbackQuery, action, arg string) {
+	groupID, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil || cq.From == nil {
+		answerCallback(api, cq, "")
+		return
+	}
+	if !isAdmin(cq.From.ID) {
+		answerCallback(api, cq, "❌ Доступ запрещен")
+		return
+	}
+
+	_, err = database.GetGroup(ctx, db, groupID)
+	isNew := errors.Is(err, sql.ErrNoRows)
+	if err != nil && !isNew {
+		groupEvent(log.Error(), EventGroupQueryFailed, groupID).Err(err).Msg("GetGroup failed")
+		answerCallback(api, cq, "❌ Не удалось сохранить ответ")
+		return
+	}
+
+	resolved, err := database.AddToEnvSnapshot(ctx, db, groupID)
+	if err != nil {
+		groupEvent(log.Error(), EventEnvImportFailed, groupID).Err(err).Msg("AddToEnvSnapshot failed")
+		answerCallback(api, cq, "❌ Не удалось сохранить ответ")
+		return
+	}
+	if !resolved {
+		answerCallback(api, cq, "")
+		editCallbackMessage(api, cq, fmt.Sprintf("Группа %d из ENV уже разобрана.", groupID), nil)
+		return
+	}
+
+	if action == envGroupIgnoreCallback {
+		writeAudit(ctx, db, cq.From.ID, "ignore_env_group", groupID, "")
+		userEvent(log.Info(), EventEnvGroupIgnored, groupID, cq.From.ID).Msg("Env group ignored")
+		answerCallback(api, cq, "")
+		editCallbackMessage(api, cq, fmt.Sprintf("Группа %d из ENV не добавлена. Подключить ее можно командой /register в самой группе.", groupID), nil)
+		return
+	}
+
+	if err := database.SeedGroup(ctx, db, groupID); err != nil {
+		groupEvent(log.Error(), EventGroupSaveFailed, groupID).Err(err).Msg("SeedGroup failed")
+		answerCallback(api, cq, "")
+		editCallbackMessage(api, cq, fmt.Sprintf("❌ Не удалось добавить группу %d. Подключи ее командой /register в самой группе.", groupID), nil)
+		return
+	}
+	// Like a group the bot is added to: only a new one gets the default profile
+	if isNew {
+		applyDefaultProfile(ctx, db, groupID)
+	}
+	rescheduleGroup(groupID)
+
+	writeAudit(ctx, db, cq.From.ID, "import_env_group", groupID, "")
+	userEvent(log.Info(), EventEnvGroupImported, groupID, cq.From.ID).Msg("Env group imported")
+	answerCallback(api, cq, "")
+	editCallbackMessage(api, cq, fmt.Sprintf("✅ Группа %d из ENV добавлена в Random Coffee.", groupID), nil)
+}
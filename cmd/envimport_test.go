@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+
+	"example.com/random_coffee/database"
+	"github.com/NicoNex/echotron/v3"
+)
+
+func TestEnvGroupsImport(t *testing.T) {
+	db := openTestDB(t)
+	tg, api := newFakeTelegram(t)
+	withAdmins(t, testAdminID)
+	ctx := context.Background()
+	boot := func(env string) {
+		t.Helper()
+		t.Setenv("GROUP_CHAT_IDS", env)
+		importEnvGroups(ctx, db, api)
+	}
+	snapshot := func() []int64 {
+		t.Helper()
+		s, err := database.GetEnvSnapshot(ctx, db)
+		if err != nil || s == nil {
+			t.Fatalf("GetEnvSnapshot = %+v, %v", s, err)
+		}
+		return s.GroupIDs
+	}
+	press := func(callback string, groupID, userID int64) string {
+		t.Helper()
+		HandleCallbackQuery(ctx, db, api, &echotron.CallbackQuery{ID: "cb", Data: fmt.Sprintf("%s:%d", callback, groupID),
+			From: &echotron.User{ID: userID}, Message: &echotron.Message{ID: 5, Chat: echotron.Chat{ID: userID, Type: "private"}}})
+		return tg.lastCall("editMessageText").Get("text")
+	}
+
+	// The first boot takes the env as it is
+	boot("-100, -200")
+	if !isConfiguredGroup(ctx, db, -100) || !isConfiguredGroup(ctx, db, -200) {
+		t.Fatal("env groups not registered on the first boot")
+	}
+	if got := snapshot(); !slices.Equal(got, []int64{-200, -100}) {
+		t.Fatalf("snapshot = %v, want both env groups", got)
+	}
+
+	// A group switched off since stays off on a boot with the same env, and nobody is asked
+	if _, err := database.DeactivateGroup(ctx, db, -200); err != nil {
+		t.Fatalf("DeactivateGroup: %v", err)
+	}
+	boot("-100,-200")
+	if isConfiguredGroup(ctx, db, -200) || len(tg.sent(testAdminID)) != 0 {
+		t.Fatalf("unchanged env: -200 active %v, admin got %q", isConfiguredGroup(ctx, db, -200), tg.sent(testAdminID))
+	}
+
+	// New env entries are offered, not applied: -300 is unknown, -400 was registered with /register
+	seedTestGroup(t, db, -400, "Tea")
+	boot("-100,-200,-300,-400")
+	if _, err := database.GetGroup(ctx, db, -300); err == nil {
+		t.Fatal("-300 registered on boot without an admin's answer")
+	}
+	sent := tg.sent(testAdminID)
+	if len(sent) != 1 || !strings.Contains(sent[0], "В ENV есть группа -300, которой нет в базе") {
+		t.Fatalf("admin got %q, want the -300 offer only", sent)
+	}
+	offer := tg.lastCall("sendMessage").Get("reply_markup")
+	for _, data := range []string{envGroupImportCallback + ":-300", envGroupIgnoreCallback + ":-300"} {
+		if !strings.Contains(offer, data) {
+			t.Fatalf("offer buttons %s, want %q", offer, data)
+		}
+	}
+	if got := snapshot(); !slices.Equal(got, []int64{-400, -200, -100}) {
+		t.Fatalf("snapshot = %v, want -400 settled without asking", got)
+	}
+
+	// Unanswered, it is asked about again on the next boot
+	boot("-100,-200,-300,-400")
+	if n := len(tg.sent(testAdminID)); n != 2 {
+		t.Fatalf("admin got %d offers after two boots, want 2", n)
+	}
+
+	if text := press(envGroupImportCallback, -300, 7); strings.Contains(text, "добавлена") {
+		t.Fatalf("non-admin import edited the offer to %q", text)
+	}
+	if got := tg.lastCall("answerCallbackQuery").Get("text"); got != "❌ Доступ запрещен" {
+		t.Fatalf("non-admin answered %q", got)
+	}
+	if text := press(envGroupImportCallback, -300, testAdminID); !strings.Contains(text, "✅ Группа -300 из ENV добавлена") {
+		t.Fatalf("import edited the offer to %q", text)
+	}
+	if !isConfiguredGroup(ctx, db, -300) {
+		t.Fatal("-300 not registered after import")
+	}
+	// A second admin answering the same offer changes nothing
+	if text := press(envGroupIgnoreCallback, -300, testAdminID); !strings.Contains(text, "уже разобрана") {
+		t.Fatalf("repeat answer edited the offer to %q", text)
+	}
+	if !isConfiguredGroup(ctx, db, -300) {
+		t.Fatal("-300 deactivated by a repeat answer")
+	}
+
+	// Ignored groups stay out, and the resolved env boots quietly
+	boot("-100,-200,-300,-400,-500")
+	if text := press(envGroupIgnoreCallback, -500, testAdminID); !strings.Contains(text, "Группа -500 из ENV не добавлена") {
+		t.Fatalf("ignore edited the offer to %q", text)
+	}
+	offers := len(tg.sent(testAdminID))
+	boot("-100,-200,-300,-400,-500")
+	if _, err := database.GetGroup(ctx, db, -500); err == nil {
+		t.Fatal("ignored -500 registered")
+	}
+	if n := len(tg.sent(testAdminID)); n != offers {
+		t.Fatalf("resolved env asked again: %q", tg.sent(testAdminID)[offers:])
+	}
+
+	// Dropped from the env and put back, a group is asked about again
+	boot("-100,-200,-300,-400")
+	if got := snapshot(); slices.Contains(got, -500) {
+		t.Fatalf("snapshot = %v, want -500 dropped with the env", got)
+	}
+	boot("-100,-200,-300,-400,-500")
+	if sent := tg.sent(testAdminID); len(sent) != offers+1 || !strings.Contains(sent[offers], "группа -500") {
+		t.Fatalf("admin got %q, want -500 offered again", sent[offers:])
+	}
+}
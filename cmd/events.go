@@ -0,0 +1,253 @@
+package main
+
+import (
+	"github.com/rs/zerolog"
+)
+
+// Event names are stable identifiers for dashboards and alerting.
+// Log messages are free text and may change; event names must not.
+const (
+	EventBotStarting = "bot.starting"
+	EventBotShutdown = "bot.shutdown"
+	EventBotStopped  = "bot.stopped"
+	EventPanic       = "bot.panic"
+
+	EventConfigMissingEnv   = "config.missing_env"
+	EventConfigInvalidID    = "config.invalid_id"
+	EventConfigInvalidValue = "config.invalid_value"
+
+	EventDBOpenFailed       = "db.open_failed"
+	EventDBReaderOpened     = "db.reader_opened"
+	EventDBReaderFailed     = "db.reader_failed"
+	EventDBMigrated         = "db.migrated"
+	EventDBMigrationsFailed = "db.migrations_failed"
+	EventDBCorruptRow       = "db.corrupt_row"
+
+	EventNotifierEnabled = "notifier.enabled"
+
+	EventPollingStarted  = "polling.started"
+	EventPollingFailed   = "polling.failed"
+	EventPollingStopped  = "polling.stopped"
+	EventPollingConflict = "polling.conflict"
+
+	EventTokenRevoked      = "token.revoked"
+	EventTokenSendRejected = "token.send_rejected"
+
+	EventSessionsExpired = "sessions.expired"
+
+	EventUpdateDuplicate   = "update.duplicate"
+	EventUpdateDedupFailed = "update.dedup_failed"
+
+	EventMaintenanceStarted      = "maintenance.started"
+	EventMaintenanceEnded        = "maintenance.ended"
+	EventMaintenanceQueued       = "maintenance.queued"
+	EventMaintenanceQueueFailed  = "maintenance.queue_failed"
+	EventMaintenanceReplayed     = "maintenance.replayed"
+	EventMaintenanceReplayFailed = "maintenance.replay_failed"
+	EventMaintenanceExpired      = "maintenance.command_expired"
+
+	EventSchedulerStarted   = "scheduler.started"
+	EventSchedulerTZFailed  = "scheduler.tz_failed"
+	EventJobScheduled       = "scheduler.job_scheduled"
+	EventJobStarted         = "scheduler.job_started"
+	EventJobStopped         = "scheduler.job_stopped"
+	EventJobPaused          = "scheduler.job_paused"
+	EventScheduleReadFailed = "scheduler.schedule_read_failed"
+	EventScheduleSaveFailed = "scheduler.schedule_save_failed"
+	EventJobBusy            = "scheduler.job_busy"
+	EventJobPanic           = "scheduler.job_panic"
+	EventSchedulerLoopPanic = "scheduler.loop_panic"
+	EventWorkerPanic        = "scheduler.worker_panic"
+
+	EventMessageSendFailed = "message.send_failed"
+	EventMessageBotRemoved = "message.bot_removed"
+	EventMessageBlocked    = "message.blocked"
+
+	EventCommand = "command.received"
+
+	EventPollAnswer        = "poll.answer"
+	EventPollAnswerInvalid = "poll.answer_invalid"
+	EventPollUnknown       = "poll.unknown"
+	EventPollVoteYes       = "poll.vote_yes"
+	EventPollVoteYesFailed = "poll.vote_yes_failed"
+	EventPollVoteNo        = "poll.vote_no"
+	EventPollVoteNoFailed  = "poll.vote_no_failed"
+
+	EventSignupPress    = "signup.button_pressed"
+	EventSignupFallback = "signup.fallback_to_buttons"
+	EventSignupFailed   = "signup.failed"
+
+	EventAvoidChanged = "avoid.changed"
+	EventAvoidFailed  = "avoid.failed"
+
+	EventSignupParked          = "signup.parked"
+	EventSignupParkedBacklog   = "signup.parked_backlog"
+	EventSignupRedelivered     = "signup.redelivered"
+	EventSignupRedeliverFailed = "signup.redeliver_failed"
+
+	EventPollMappingRecovered = "poll.mapping_recovered"
+	EventPollRecoveryFailed   = "poll.recovery_failed"
+
+	EventQuizSent          = "quiz.sent"
+	EventQuizSendFailed    = "quiz.send_failed"
+	EventQuizMappingFailed = "quiz.mapping_failed"
+	EventQuizPinFailed     = "quiz.pin_failed"
+	EventQuizCleanupFailed = "quiz.cleanup_failed"
+	EventQuizLogFailed     = "quiz.log_failed"
+
+	EventPairsCreated           = "pairs.created"
+	EventPairsAlreadyCreated    = "pairs.already_created"
+	EventPairsQueryFailed       = "pairs.query_failed"
+	EventPairsSaveFailed        = "pairs.save_failed"
+	EventPairsHistoryExhausted  = "pairs.history_exhausted"
+	EventPairsAdjusted          = "pairs.adjusted"
+	EventPairsPostProcessFailed = "pairs.post_process_failed"
+	EventPairsQualityFailed     = "pairs.quality_failed"
+	EventPairsPollLookupFailed  = "pairs.poll_lookup_failed"
+	EventPairsUnpinned          = "pairs.unpinned"
+	EventPairsUnpinFailed       = "pairs.unpin_failed"
+	EventPairsCleanupFailed     = "pairs.cleanup_failed"
+
+	EventUnpinBacklogRetried = "unpin.backlog_retried"
+	EventUnpinBacklogAlert   = "unpin.backlog_alert"
+	EventUnpinBacklogFailed  = "unpin.backlog_failed"
+	EventUnpinAll            = "unpin.all"
+	EventUnpinAllFailed      = "unpin.all_failed"
+
+	EventCallbackUnknown = "callback.unknown"
+	EventCallbackFailed  = "callback.failed"
+
+	EventReconciled          = "reconcile.ok"
+	EventReconcileMismatch   = "reconcile.mismatch"
+	EventReconcilePersistent = "reconcile.persistent_mismatch"
+	EventReconcileFailed     = "reconcile.failed"
+
+	EventGroupRegistered  = "group.registered"
+	EventGroupDeactivated = "group.deactivated"
+
+	EventEnvImported      = "env.imported"
+	EventEnvGroupOffered  = "env.group_offered"
+	EventEnvGroupImported = "env.group_imported"
+	EventEnvGroupIgnored  = "env.group_ignored"
+	EventEnvImportFailed  = "env.import_failed"
+
+	EventChatMigrated         = "duplicate.chat_migrated"
+	EventDuplicateChecked     = "duplicate.checked"
+	EventDuplicateCheckFailed = "duplicate.check_failed"
+	EventDuplicateSuspected   = "duplicate.suspected"
+	EventDuplicateMerged      = "duplicate.merged"
+	EventDuplicateMergeFailed = "duplicate.merge_failed"
+	EventGroupSaveFailed      = "group.save_failed"
+	EventGroupQueryFailed     = "group.query_failed"
+
+	EventOverlapHandled     = "overlap.handled"
+	EventOverlapCheckFailed = "overlap.check_failed"
+
+	EventVolunteerChanged     = "volunteer.changed"
+	EventVolunteerSaveFailed  = "volunteer.save_failed"
+	EventVolunteerQueryFailed = "volunteer.query_failed"
+
+	EventBuddyCohortFailed = "buddy.cohort_failed"
+	EventBuddyMatched      = "buddy.matched"
+
+	EventNoticeNotElapsed  = "notice.not_elapsed"
+	EventNoticeCheckFailed = "notice.check_failed"
+
+	EventCountdownStarted  = "countdown.started"
+	EventCountdownFinished = "countdown.finished"
+	EventCountdownFailed   = "countdown.failed"
+
+	EventSlowStartScheduled = "slow_start.scheduled"
+	EventSlowStartSkipped   = "slow_start.skipped"
+	EventSlowStartNudged    = "slow_start.nudged"
+	EventSlowStartFailed    = "slow_start.failed"
+
+	EventSettingsReadFailed = "settings.read_failed"
+	EventSettingsSaveFailed = "settings.save_failed"
+	EventSettingsConflict   = "settings.conflict"
+
+	EventProfileSaved   = "profile.saved"
+	EventProfileApplied = "profile.applied"
+	EventProfileFailed  = "profile.failed"
+
+	EventMediaSet     = "media.set"
+	EventMediaCleared = "media.cleared"
+	EventMediaFailed  = "media.failed"
+
+	EventFunnelRecorded = "funnel.recorded"
+	EventFunnelFailed   = "funnel.failed"
+
+	EventHolidaySkipped = "holiday.skipped"
+	EventHolidayChanged = "holiday.changed"
+	EventHolidayFailed  = "holiday.failed"
+
+	EventQuizDeferred        = "quiz.deferred"
+	EventQuizDeferralCleared = "quiz.deferral_cleared"
+	EventQuizDeferralFailed  = "quiz.deferral_failed"
+
+	EventThemeSet     = "theme.set"
+	EventThemeCleared = "theme.cleared"
+
+	EventDashboardEnabled   = "dashboard.enabled"
+	EventDashboardRefreshed = "dashboard.refreshed"
+	EventDashboardLost      = "dashboard.lost"
+	EventDashboardFailed    = "dashboard.failed"
+
+	EventExperimentStarted  = "experiment.started"
+	EventExperimentFinished = "experiment.finished"
+	EventExperimentFailed   = "experiment.failed"
+
+	EventSnapshotPublished = "snapshot.published"
+	EventSnapshotFailed    = "snapshot.failed"
+
+	EventCloneCompleted = "clone.completed"
+	EventCloneFailed    = "clone.failed"
+
+	EventAuditWriteFailed = "audit.write_failed"
+
+	EventProfileSaveFailed = "profile.save_failed"
+
+	EventLanguageChanged = "language.changed"
+	EventLanguageFailed  = "language.failed"
+
+	EventDMPolicyChanged     = "dm.policy_changed"
+	EventDMPreferenceChanged = "dm.preference_changed"
+	EventDMPreferenceFailed  = "dm.preference_failed"
+	EventDMSuppressed        = "dm.suppressed"
+
+	EventMyDataExported = "my_data.exported"
+	EventMyDataFailed   = "my_data.failed"
+
+	EventStatsQueryFailed = "stats.query_failed"
+	EventHistoryExported  = "history.exported"
+	EventHistoryFailed    = "history.failed"
+	EventHistoryAborted   = "history.aborted"
+
+	EventWeeklyReportSent   = "weekly_report.sent"
+	EventWeeklyReportFailed = "weekly_report.failed"
+	EventOpsCountersFailed  = "ops_counters.failed"
+
+	EventManualPairsChecked = "manual_pairs.checked"
+	EventManualPairsCreated = "manual_pairs.created"
+	EventManualPairsFailed  = "manual_pairs.failed"
+)
+
+// botEvent tags a log entry that is not tied to a particular group
+func botEvent(e *zerolog.Event, name string) *zerolog.Event {
+	return e.Str("event", name)
+}
+
+// groupEvent tags a log entry about a group
+func groupEvent(e *zerolog.Event, name string, groupID int64) *zerolog.Event {
+	return e.Str("event", name).Int64("group_id", groupID)
+}
+
+// userEvent tags a log entry about a user's action in a group
+func userEvent(e *zerolog.Event, name string, groupID, userID int64) *zerolog.Event {
+	return e.Str("event", name).Int64("group_id", groupID).Int64("user_id", userID)
+}
+
+// cycleEvent tags a log entry about a group's weekly cycle
+func cycleEvent(e *zerolog.Event, name string, groupID int64, weekStart string) *zerolog.Event {
+	return e.Str("event", name).Int64("group_id", groupID).Str("cycle", weekStart)
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// eventHelpers are the only functions allowed to set the event field themselves
+var eventHelpers = map[string]bool{"botEvent": true, "groupEvent": true, "userEvent": true, "cycleEvent": true}
+
+// TestEventsLoggedThroughHelpers fails on log calls that set the event field by hand
+// or pass a name that isn't one of the Event constants, so call sites can't drift
+func TestEventsLoggedThroughHelpers(t *testing.T) {
+	files, err := filepath.Glob("*.go")
+	if err != nil {
+		t.Fatalf("Glob: %v", err)
+	}
+	fset := token.NewFileSet()
+	for _, path := range files {
+		if strings.HasSuffix(path, "_test.go") {
+			continue
+		}
+		f, err := parser.ParseFile(fset, path, nil, 0)
+		if err != nil {
+			t.Fatalf("ParseFile(%s): %v", path, err)
+		}
+		for _, decl := range f.Decls {
+			fn, ok := decl.(*ast.FuncDecl)
+			if ok && fn.Recv == nil && eventHelpers[fn.Name.Name] {
+				continue
+			}
+			ast.Inspect(decl, func(n ast.Node) bool {
+				call, ok := n.(*ast.CallExpr)
+				if !ok || len(call.Args) < 2 {
+					return true
+				}
+				switch fun := call.Fun.(type) {
+				case *ast.SelectorExpr:
+					// e.Str("event", ...), e.Interface("event", ...) and the like
+					if lit, ok := call.Args[0].(*ast.BasicLit); ok && lit.Kind == token.STRING {
+						if key, _ := strconv.Unquote(lit.Value); key == "event" {
+							t.Errorf("%s: %s(%q, ...) sets the event by hand, use one of the event helpers", fset.Position(call.Pos()), fun.Sel.Name, key)
+						}
+					}
+				case *ast.Ident:
+					if !eventHelpers[fun.Name] {
+						return true
+					}
+					if name, ok := call.Args[1].(*ast.Ident); !ok || !strings.HasPrefix(name.Name, "Event") {
+						t.Errorf("%s: %s is given a name that isn't an Event constant", fset.Position(call.Pos()), fun.Name)
+					}
+				}
+				return true
+			})
+		}
+	}
+}
@@ -0,0 +1,348 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"example.com/random_coffee/database"
+	"github.com/NicoNex/echotron/v3"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// announcementExperimentKey is the setting an experiment on the pairs announcement varies
+	announcementExperimentKey = "announcement"
+
+	// pairsPlaceholder marks where an announcement template lists the pairs
+	pairsPlaceholder = "{pairs}"
+
+	// defaultAnnouncementTemplate is the announcement every group gets outside experiments
+	defaultAnnouncementTemplate = "🎉 Пары Random Coffee на эту неделю ☕️\n\n" + themePlaceholder + pairsPlaceholder +
+		"💬 Напиши прямо сейчас собеседнику в личку и договорись о месте и времени!"
+
+	maxExperimentCycles = 12
+
+	// experimentVariantSeparator is the line between the two variants in /experiment create
+	experimentVariantSeparator = "---"
+)
+
+// announcementAssignment is the announcement template a group uses this cycle, and the experiment
+// variant it came from; experimentID is zero outside experiments
+type announcementAssignment struct {
+	experimentID int64
+	variant      string
+	template     string
+}
+
+// experimentCycle returns which cycle of the experiment the week is, counting from zero
+func experimentCycle(e *database.Experiment, weekStart string) int {
+	start, err1 := time.Parse("2006-01-02", e.StartWeek)
+	week, err2 := time.Parse("2006-01-02", weekStart)
+	if err1 != nil || err2 != nil {
+		return -1
+	}
+	return int(week.Sub(start).Hours()/24) / 7
+}
+
+// experimentVariant deterministically picks "A" or "B" for a group in a cycle, so rerunning the
+// pairing within the week keeps the variant while groups still alternate between cycles
+func experimentVariant(experimentID, groupID int64, cycle int) string {
+	h := fnv.New32a()
+	fmt.Fprintf(h, "%d:%d:%d", experimentID, groupID, cycle)
+	if h.Sum32()%2 == 0 {
+		return "A"
+	}
+	return "B"
+}
+
+// assignAnnouncement returns the group's announcement for the week: a variant of the running
+// experiment, or the default template for excluded groups and when nothing runs
+func assignAnnouncement(ctx context.Context, db *sql.DB, groupID int64, weekStart string) announcementAssignment {
+	assignment := announcementAssignment{template: defaultAnnouncementTemplate}
+
+	e, err := database.GetRunningExperiment(ctx, db, announcementExperimentKey)
+	if err != nil {
+		groupEvent(log.Error(), EventExperimentFailed, groupID).Err(err).Msg("GetRunningExperiment failed, default announcement used")
+		return assignment
+	}
+	if e == nil || slices.Contains(e.Excluded, groupID) {
+		return assignment
+	}
+	cycle := experimentCycle(e, weekStart)
+	if cycle < 0 || cycle >= e.Cycles {
+		return assignment
+	}
+
+	assignment.experimentID = e.ID
+	assignment.variant = experimentVariant(e.ID, groupID, cycle)
+	assignment.template = e.VariantA
+	if assignment.variant == "B" {
+		assignment.template = e.VariantB
+	}
+	return assignment
+}
+
+// formatPairsAnnouncement fills the template's pairs and theme placeholders. A template without a theme
+// placeholder, such as an experiment variant written before themes existed, gets the theme on top.
+func formatPairsAnnouncement(template, theme string, finalPairs [][]database.Participant) string {
+	var pairs strings.Builder
+	for _, pair := range finalPairs {
+		names := make([]string, 0, len(pair))
+		for _, p := range pair {
+			names = append(names, getDisplayName(p))
+		}
+		fmt.Fprintf(&pairs, "▫️ %s\n\n", strings.Join(names, " ✖️ "))
+	}
+	if !strings.Contains(template, themePlaceholder) {
+		template = themePlaceholder + template
+	}
+	return withTheme(template, "🎨 Тема недели: %s\n\n", theme, pairsPlaceholder, pairs.String())
+}
+
+// finishExpiredExperiment stops the announcement experiment and sends admins the report once the week
+// after its last cycle has run too, since the report compares that next week's sign-up. Groups get
+// the default announcement from the end of the last cycle on either way.
+func finishExpiredExperiment(ctx context.Context, db *sql.DB, api echotron.API) {
+	e, err := database.GetRunningExperiment(ctx, db, announcementExperimentKey)
+	if err != nil || e == nil {
+		return
+	}
+	if experimentCycle(e, getWeekStart(time.Now())) <= e.Cycles {
+		return
+	}
+
+	stopped, err := database.StopExperiment(ctx, db, e.ID, time.Now())
+	if err != nil {
+		botEvent(log.Error(), EventExperimentFailed).Err(err).Int64("experiment_id", e.ID).Msg("StopExperiment failed")
+		return
+	}
+	if !stopped {
+		return
+	}
+	botEvent(log.Info(), EventExperimentFinished).Int64("experiment_id", e.ID).Str("reason", "completed").Msg("Experiment finished")
+	notifyAdmins(api, "🧪 Эксперимент завершен\n\n"+buildExperimentReport(ctx, db, e))
+}
+
+// buildExperimentReport loads the funnels of the experiment's cycles and of the weeks after them,
+// and compares the variants
+func buildExperimentReport(ctx context.Context, db *sql.DB, e *database.Experiment) string {
+	funnels, err := database.GetExperimentFunnels(ctx, db, e.ID)
+	if err != nil {
+		botEvent(log.Error(), EventExperimentFailed).Err(err).Int64("experiment_id", e.ID).Msg("GetExperimentFunnels failed")
+		return fmt.Sprintf("Эксперимент «%s»: не удалось загрузить данные", e.Name)
+	}
+	following, err := database.GetFollowingCycleFunnels(ctx, db, e.ID)
+	if err != nil {
+		botEvent(log.Error(), EventExperimentFailed).Err(err).Int64("experiment_id", e.ID).Msg("GetFollowingCycleFunnels failed")
+		return fmt.Sprintf("Эксперимент «%s»: не удалось загрузить данные", e.Name)
+	}
+	return formatExperimentReport(e, funnels, following)
+}
+
+// experimentTotals sums, for one variant, the cycles that were followed by another one of the same group
+type experimentTotals struct {
+	cycles     int
+	unfollowed int // cycles without a pairing run the week after
+	members    int // chat members of the following cycles that know the chat size
+	joined     int // sign-ups of those same following cycles, to compare with members
+	signedUp   int // sign-ups of the announced cycles
+	returned   int // sign-ups of the following cycles
+}
+
+// formatExperimentReport compares the variants by the sign-up of the week after the announcement: the
+// announcement is sent once the cycle's sign-up is closed, so only the next cycle can show its effect.
+// Totals are summed before dividing, so big groups weigh more.
+func formatExperimentReport(e *database.Experiment, funnels, following []database.CycleFunnel) string {
+	type cycleKey struct {
+		groupID   int64
+		weekStart string
+	}
+	next := make(map[cycleKey]database.CycleFunnel, len(following))
+	for _, f := range following {
+		next[cycleKey{f.GroupID, f.WeekStart}] = f
+	}
+
+	totals := map[string]*experimentTotals{"A": {}, "B": {}}
+	for _, f := range funnels {
+		t, ok := totals[f.Variant]
+		if !ok {
+			continue
+		}
+		t.cycles++
+		week, err := time.Parse("2006-01-02", f.WeekStart)
+		if err != nil {
+			t.unfollowed++
+			continue
+		}
+		n, ok := next[cycleKey{f.GroupID, week.AddDate(0, 0, 7).Format("2006-01-02")}]
+		if !ok {
+			t.unfollowed++
+			continue
+		}
+		t.signedUp += f.SignedUp
+		t.returned += n.SignedUp
+		if n.Members > 0 {
+			t.members += n.Members
+			t.joined += n.SignedUp
+		}
+	}
+
+	text := fmt.Sprintf("Эксперимент «%s» (#%d), анонс пар, с недели %s, циклов: %d\n", e.Name, e.ID, e.StartWeek, e.Cycles)
+	if len(e.Excluded) > 0 {
+		excluded := make([]string, 0, len(e.Excluded))
+		for _, id := range e.Excluded {
+			excluded = append(excluded, strconv.FormatInt(id, 10))
+		}
+		text += "Исключены группы: " + strings.Join(excluded, ", ") + "\n"
+	}
+	text += "Сравнивается запись через неделю после анонса\n"
+	for _, variant := range []string{"A", "B"} {
+		t := totals[variant]
+		text += fmt.Sprintf("\nВариант %s: запусков %d, без следующего цикла %d\n", variant, t.cycles, t.unfollowed)
+		text += fmt.Sprintf("• Записались через неделю от числа участников чата: %s\n", percentOf(t.joined, t.members))
+		text += fmt.Sprintf("• Записались через неделю к записавшимся на цикл с анонсом: %s (%d к %d)\n",
+			percentOf(t.returned, t.signedUp), t.returned, t.signedUp)
+	}
+	return text
+}
+
+// parseExperimentCreate reads "/experiment create <name> <cycles> [exclude=id,id]" with the two
+// variants on the following lines, separated by a "---" line
+func parseExperimentCreate(text string) (database.Experiment, error) {
+	var e database.Experiment
+
+	header, body, _ := strings.Cut(text, "\n")
+	_, args := parseCommand(header)
+	if len(args) < 3 || len(args) > 4 || args[0] != "create" {
+		return e, errors.New("неверный формат")
+	}
+	e.Name = args[1]
+	cycles, err := strconv.Atoi(args[2])
+	if err != nil || cycles < 1 || cycles > maxExperimentCycles {
+		return e, fmt.Errorf("число циклов должно быть от 1 до %d", maxExperimentCycles)
+	}
+	e.Cycles = cycles
+	if len(args) == 4 {
+		list, ok := strings.CutPrefix(args[3], "exclude=")
+		if !ok {
+			return e, errors.New("неверный формат")
+		}
+		for _, part := range strings.Split(list, ",") {
+			id, err := strconv.ParseInt(part, 10, 64)
+			if err != nil {
+				return e, fmt.Errorf("неверный ID группы: %s", part)
+			}
+			e.Excluded = append(e.Excluded, id)
+		}
+	}
+
+	variantA, variantB, found := strings.Cut(body, "\n"+experimentVariantSeparator+"\n")
+	if !found {
+		return e, errors.New("нужны два варианта, разделенные строкой ---")
+	}
+	e.VariantA, e.VariantB = strings.TrimSpace(variantA), strings.TrimSpace(variantB)
+	for _, v := range []string{e.VariantA, e.VariantB} {
+		if strings.Count(v, pairsPlaceholder) != 1 {
+			return e, fmt.Errorf("в каждом варианте должен быть ровно один %s", pairsPlaceholder)
+		}
+	}
+	return e, nil
+}
+
+// handleExperimentCommand implements /experiment create|status|stop in a private chat
+func handleExperimentCommand(ctx context.Context, db *sql.DB, api echotron.API, message *echotron.Message, args []string) {
+	chatID := message.Chat.ID
+	if !isAdmin(message.From.ID) {
+		sendMessage(api, "❌ Доступ запрещен", chatID)
+		return
+	}
+
+	usage := fmt.Sprintf("Использование:\n"+
+		"/experiment create <название> <циклов> [exclude=group_id,group_id]\n<вариант A>\n%s\n<вариант B>\n"+
+		"/experiment status\n/experiment stop\n\n"+
+		"Каждый вариант - текст анонса пар, где %s заменяется списком пар. Каждую неделю группы получают вариант "+
+		"A или B, после последнего цикла админам придет сравнение вариантов. Исключенные группы и все группы после "+
+		"остановки получают обычный анонс.", experimentVariantSeparator, pairsPlaceholder)
+	if len(args) == 0 {
+		sendMessage(api, usage, chatID)
+		return
+	}
+
+	switch args[0] {
+	case "create":
+		e, err := parseExperimentCreate(message.Text)
+		if err != nil {
+			sendMessage(api, "❌ "+err.Error()+"\n\n"+usage, chatID)
+			return
+		}
+		e.SettingKey = announcementExperimentKey
+		e.StartWeek = getWeekStart(time.Now())
+		e.CreatedBy = message.From.ID
+		e.CreatedAt = time.Now()
+
+		id, err := database.CreateExperiment(ctx, db, e)
+		if errors.Is(err, database.ErrExperimentActive) {
+			sendMessage(api, "❌ Эксперимент с анонсом пар уже идет. Останови его: /experiment stop", chatID)
+			return
+		}
+		if err != nil {
+			botEvent(log.Error(), EventExperimentFailed).Err(err).Msg("CreateExperiment failed")
+			sendMessage(api, "❌ Не удалось создать эксперимент", chatID)
+			return
+		}
+
+		writeAudit(ctx, db, message.From.ID, "experiment_create", 0, fmt.Sprintf("%d:%s", id, e.Name))
+		botEvent(log.Info(), EventExperimentStarted).Int64("experiment_id", id).Int64("user_id", message.From.ID).
+			Int("cycles", e.Cycles).Int("excluded", len(e.Excluded)).Msg("Experiment started")
+		sendMessage(api, fmt.Sprintf("🧪 Эксперимент «%s» (#%d) начат: %d нед., начиная с текущей", e.Name, id, e.Cycles), chatID)
+
+	case "status":
+		e, err := database.GetRunningExperiment(ctx, db, announcementExperimentKey)
+		if err != nil {
+			botEvent(log.Error(), EventExperimentFailed).Err(err).Msg("GetRunningExperiment failed")
+			sendMessage(api, "❌ Не удалось загрузить эксперимент", chatID)
+			return
+		}
+		if e == nil {
+			sendMessage(api, "Сейчас нет эксперимента", chatID)
+			return
+		}
+		progress := "Все циклы прошли, ждем записи следующей недели"
+		if cycle := experimentCycle(e, getWeekStart(time.Now())) + 1; cycle <= e.Cycles {
+			progress = fmt.Sprintf("Идет цикл %d из %d", cycle, e.Cycles)
+		}
+		sendLongMessage(api, progress+"\n\n"+buildExperimentReport(ctx, db, e), chatID)
+
+	case "stop":
+		e, err := database.GetRunningExperiment(ctx, db, announcementExperimentKey)
+		if err != nil {
+			botEvent(log.Error(), EventExperimentFailed).Err(err).Msg("GetRunningExperiment failed")
+			sendMessage(api, "❌ Не удалось загрузить эксперимент", chatID)
+			return
+		}
+		if e == nil {
+			sendMessage(api, "Сейчас нет эксперимента", chatID)
+			return
+		}
+		if _, err := database.StopExperiment(ctx, db, e.ID, time.Now()); err != nil {
+			botEvent(log.Error(), EventExperimentFailed).Err(err).Int64("experiment_id", e.ID).Msg("StopExperiment failed")
+			sendMessage(api, "❌ Не удалось остановить эксперимент", chatID)
+			return
+		}
+
+		writeAudit(ctx, db, message.From.ID, "experiment_stop", 0, strconv.FormatInt(e.ID, 10))
+		botEvent(log.Info(), EventExperimentFinished).Int64("experiment_id", e.ID).Str("reason", "stopped").Msg("Experiment finished")
+		sendLongMessage(api, "⏹ Эксперимент остановлен, все группы снова получают обычный анонс\n\n"+
+			buildExperimentReport(ctx, db, e), chatID)
+
+	default:
+		sendMessage(api, usage, chatID)
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"example.com/random_coffee/database"
+)
+
+func TestExperimentVariantIsStable(t *testing.T) {
+	seen := map[string]bool{}
+	for cycle := 0; cycle < 20; cycle++ {
+		v := experimentVariant(7, testGroupID, cycle)
+		if v != experimentVariant(7, testGroupID, cycle) {
+			t.Fatalf("cycle %d got different variants", cycle)
+		}
+		seen[v] = true
+	}
+	if !seen["A"] || !seen["B"] {
+		t.Fatalf("variants over 20 cycles = %v, want the group to alternate", seen)
+	}
+}
+
+func TestFormatExperimentReportComparesNextWeek(t *testing.T) {
+	e := &database.Experiment{ID: 1, Name: "short", StartWeek: "2026-01-05", Cycles: 2}
+	funnels := []database.CycleFunnel{
+		{GroupID: -1, WeekStart: "2026-01-05", Members: 100, SignedUp: 10, Matched: 10, Variant: "A"},
+		{GroupID: -2, WeekStart: "2026-01-05", Members: 50, SignedUp: 10, Matched: 8, Variant: "B"},
+		{GroupID: -1, WeekStart: "2026-01-12", Members: 100, SignedUp: 20, Matched: 20, Variant: "B"},
+		{GroupID: -2, WeekStart: "2026-01-12", Members: 50, SignedUp: 5, Matched: 4, Variant: "A"},
+	}
+	following := []database.CycleFunnel{
+		{GroupID: -1, WeekStart: "2026-01-12", Members: 100, SignedUp: 20},
+		{GroupID: -2, WeekStart: "2026-01-12", Members: 50, SignedUp: 5},
+		{GroupID: -1, WeekStart: "2026-01-19", Members: 100, SignedUp: 30},
+		// Group -2 skipped the week after its A cycle
+	}
+
+	got := formatExperimentReport(e, funnels, following)
+	for _, want := range []string{
+		"Вариант A: запусков 2, без следующего цикла 1\n" +
+			"• Записались через неделю от числа участников чата: 20%\n" +
+			"• Записались через неделю к записавшимся на цикл с анонсом: 200% (20 к 10)",
+		"Вариант B: запусков 2, без следующего цикла 0\n" +
+			"• Записались через неделю от числа участников чата: 23%\n" +
+			"• Записались через неделю к записавшимся на цикл с анонсом: 116% (35 к 30)",
+	} {
+		if !strings.Contains(got, want) {
+			t.Fatalf("report =\n%s\nwant it to contain\n%s", got, want)
+		}
+	}
+}
+
+func TestBuildExperimentReportLoadsFollowingWeek(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+
+	save := func(f database.CycleFunnel) {
+		t.Helper()
+		f.CreatedAt = time.Now()
+		if err := database.SaveCycleFunnel(ctx, db, f); err != nil {
+			t.Fatalf("SaveCycleFunnel: %v", err)
+		}
+	}
+	save(database.CycleFunnel{GroupID: -1, WeekStart: "2026-01-05", Members: 10, SignedUp: 4, ExperimentID: 3, Variant: "A"})
+	save(database.CycleFunnel{GroupID: -1, WeekStart: "2026-01-12", Members: 10, SignedUp: 6})
+	// Another group's week and a later week of the same group are not the follow-up
+	save(database.CycleFunnel{GroupID: -2, WeekStart: "2026-01-12", Members: 10, SignedUp: 9})
+	save(database.CycleFunnel{GroupID: -1, WeekStart: "2026-01-19", Members: 10, SignedUp: 8})
+
+	got := buildExperimentReport(ctx, db, &database.Experiment{ID: 3, Name: "db", StartWeek: "2026-01-05", Cycles: 1})
+	if !strings.Contains(got, "к записавшимся на цикл с анонсом: 150% (6 к 4)") {
+		t.Fatalf("report =\n%s\nwant the sign-up of 2026-01-12 compared", got)
+	}
+}
+
+func TestFinishExpiredExperimentWaitsForFollowingWeek(t *testing.T) {
+	db := openTestDB(t)
+	tg, api := newFakeTelegram(t)
+	withAdmins(t, testAdminID)
+	ctx := context.Background()
+
+	thisWeek, _ := time.Parse("2006-01-02", getWeekStart(time.Now()))
+	start := func(weeksAgo int) int64 {
+		t.Helper()
+		id, err := database.CreateExperiment(ctx, db, database.Experiment{Name: "wait", SettingKey: announcementExperimentKey,
+			VariantA: "A" + pairsPlaceholder, VariantB: "B" + pairsPlaceholder,
+			StartWeek: thisWeek.AddDate(0, 0, -7*weeksAgo).Format("2006-01-02"), Cycles: 1, CreatedAt: time.Now()})
+		if err != nil {
+			t.Fatalf("CreateExperiment: %v", err)
+		}
+		return id
+	}
+
+	// The one cycle ran last week: this week's sign-up is still to be measured
+	id := start(1)
+	if a := assignAnnouncement(ctx, db, testGroupID, getWeekStart(time.Now())); a.experimentID != 0 {
+		t.Fatalf("assignment = %+v, want the default announcement after the last cycle", a)
+	}
+	finishExpiredExperiment(ctx, db, api)
+	if got := tg.sent(testAdminID); len(got) != 0 {
+		t.Fatalf("admins got %q, want the experiment kept running", got)
+	}
+	if _, err := database.StopExperiment(ctx, db, id, time.Now()); err != nil {
+		t.Fatalf("StopExperiment: %v", err)
+	}
+
+	start(2)
+	finishExpiredExperiment(ctx, db, api)
+	if got := tg.sent(testAdminID); len(got) != 1 || !strings.Contains(got[0], "Эксперимент завершен") {
+		t.Fatalf("admins got %q, want the report", got)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"example.com/random_coffee/database"
+	"github.com/NicoNex/echotron/v3"
+	"github.com/rs/zerolog/log"
+)
+
+// chatMemberCount returns the group's size for the funnel, zero when Telegram doesn't tell
+func chatMemberCount(api echotron.API, groupID int64) int {
+	res, err := api.GetChatMemberCount(groupID)
+	if err != nil {
+		groupEvent(log.Warn(), EventFunnelFailed, groupID).Err(err).Msg("GetChatMemberCount failed")
+		return 0
+	}
+	return res.Result
+}
+
+// buildCycleFunnel counts the stages of a pairing run: everyone in a pair or trio counts as matched
+func buildCycleFunnel(groupID int64, weekStart string, members, signedUp int, finalPairs [][]database.Participant) database.CycleFunnel {
+	matched := 0
+	for _, pair := range finalPairs {
+		matched += len(pair)
+	}
+	return database.CycleFunnel{
+		GroupID:   groupID,
+		WeekStart: weekStart,
+		Members:   members,
+		SignedUp:  signedUp,
+		Matched:   matched,
+		Pairs:     len(finalPairs),
+		CreatedAt: time.Now(),
+	}
+}
+
+// recordCycleFunnel stores the funnel of a pairing run, taking the member count from the cycle's poll.
+// The announcement variant is recorded with it, for experiment reports. Failures are logged and never affect the run itself.
+func recordCycleFunnel(ctx context.Context, db *sql.DB, groupID int64, signedUp int, finalPairs [][]database.Participant,
+	announcement announcementAssignment) *database.CycleFunnel {
+	weekStart := getWeekStart(time.Now())
+
+	members := 0
+	pollID := ""
+	if pm, err := database.GetPollMappingByGroupID(ctx, db, groupID); err == nil && pm != nil {
+		pollID = pm.PollID
+		if sp, err := database.GetSentPoll(ctx, db, pm.PollID); err == nil {
+			members = sp.MemberCount
+		}
+	}
+
+	f := buildCycleFunnel(groupID, weekStart, members, signedUp, finalPairs)
+	f.PollID = pollID
+	f.ExperimentID, f.Variant = announcement.experimentID, announcement.variant
+	if err := database.SaveCycleFunnel(ctx, db, f); err != nil {
+		cycleEvent(log.Error(), EventFunnelFailed, groupID, weekStart).Err(err).Msg("SaveCycleFunnel failed")
+		return &f
+	}
+
+	cycleEvent(log.Info(), EventFunnelRecorded, groupID, weekStart).Int("members", f.Members).Int("signed_up", f.SignedUp).
+		Int("matched", f.Matched).Str("variant", f.Variant).Msg("Cycle funnel recorded")
+	return &f
+}
+
+// percentOf renders part as a share of total, or "-" when total is unknown
+func percentOf(part, total int) string {
+	if total <= 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%d%%", part*100/total)
+}
+
+// formatFunnel renders a funnel with each stage's share of the previous one
+func formatFunnel(f database.CycleFunnel) string {
+	text := ""
+	if f.Members > 0 {
+		text = fmt.Sprintf("в чате %d → ", f.Members)
+	}
+	text += fmt.Sprintf("записались %d", f.SignedUp)
+	if f.Members > 0 {
+		text += fmt.Sprintf(" (%s)", percentOf(f.SignedUp, f.Members))
+	}
+	return text + fmt.Sprintf(" → в парах %d (%s)", f.Matched, percentOf(f.Matched, f.SignedUp))
+}
+
+// formatFunnelTotal answers "what share of the chat ends up in a pair" over the given cycles.
+// Cycles without a member count are left out; empty if there are none.
+func formatFunnelTotal(funnels []database.CycleFunnel) string {
+	members, matched, weeks := 0, 0, 0
+	for _, f := range funnels {
+		if f.Members == 0 {
+			continue
+		}
+		members += f.Members
+		matched += f.Matched
+		weeks++
+	}
+	if weeks == 0 {
+		return ""
+	}
+	return fmt.Sprintf("• Попали в пары от числа участников чата: %s (за %d нед.)\n", percentOf(matched, members), weeks)
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"net/url"
+	"testing"
+	"time"
+
+	"example.com/random_coffee/database"
+	"github.com/google/uuid"
+)
+
+func TestCycleFunnelFromFixture(t *testing.T) {
+	db, tg, api := setupManualPairsGroup(t)
+	ctx := context.Background()
+	withFakePolls(t, tg)
+	t.Setenv("OVERLAP_POLICY", overlapSkip)
+	tg.reply("sendPoll", func(url.Values) string {
+		return `{"ok":true,"result":{"message_id":10,"date":0,"chat":{"id":-100,"type":"supergroup"},"poll":{"id":"poll-1"}}}`
+	})
+	tg.reply("getChatMemberCount", func(url.Values) string { return `{"ok":true,"result":40}` })
+
+	// User 6 already meets someone in another group this week, so signs up but isn't matched here
+	p := database.Pair{ID: uuid.New(), GroupID: -200, WeekStart: getWeekStart(time.Now()), User1ID: 6, User2ID: 7, CreatedAt: time.Now()}
+	if err := database.CreatePairs(ctx, db, []database.Pair{p}); err != nil {
+		t.Fatalf("CreatePairs: %v", err)
+	}
+
+	SendQuiz(ctx, db, api, testGroupID)
+	for _, id := range []int64{1, 2, 3, 4, 5, 6} {
+		answerPoll(ctx, db, api, "poll-1", id)
+	}
+	// A vote taken back doesn't count
+	answerPoll(ctx, db, api, "poll-1", 7)
+	HandlePollAnswer(ctx, db, api, pollAnswer(0, 7, false).PollAnswer)
+
+	CreatePairs(ctx, db, api, testGroupID)
+
+	funnels, err := database.GetRecentCycleFunnels(ctx, db, testGroupID, 1)
+	if err != nil || len(funnels) != 1 {
+		t.Fatalf("GetRecentCycleFunnels = %+v, %v", funnels, err)
+	}
+	f := funnels[0]
+	// Members come from the quiz-time snapshot; five matched make a pair and a trio
+	if f.PollID != "poll-1" || f.WeekStart != getWeekStart(time.Now()) || f.Members != 40 || f.SignedUp != 6 || f.Matched != 5 || f.Pairs != 2 {
+		t.Fatalf("funnel = %+v, want 40 members, 6 signed up, 5 matched in 2 pairs", f)
+	}
+	if got, want := formatFunnel(f), "в чате 40 → записались 6 (15%) → в парах 5 (83%)"; got != want {
+		t.Fatalf("formatFunnel = %q, want %q", got, want)
+	}
+}
+
+func TestFunnelTotalSkipsUnknownMemberCounts(t *testing.T) {
+	funnels := []database.CycleFunnel{
+		{Members: 40, SignedUp: 6, Matched: 5},
+		{Members: 0, SignedUp: 9, Matched: 9}, // GetChatMemberCount failed that week
+		{Members: 60, SignedUp: 10, Matched: 10},
+	}
+	if got, want := formatFunnelTotal(funnels), "• Попали в пары от числа участников чата: 15% (за 2 нед.)\n"; got != want {
+		t.Fatalf("formatFunnelTotal = %q, want %q", got, want)
+	}
+	if got := formatFunnelTotal(funnels[1:2]); got != "" {
+		t.Fatalf("formatFunnelTotal without member counts = %q, want nothing", got)
+	}
+	if got, want := formatFunnel(funnels[1]), "записались 9 → в парах 9 (100%)"; got != want {
+		t.Fatalf("formatFunnel = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"example.com/random_coffee/database"
+	"github.com/NicoNex/echotron/v3"
+	"github.com/rs/zerolog/log"
+)
+
+// onBotRemoved is called when a send shows the bot is no longer in a group; set up in main
+var onBotRemoved func(groupID int64)
+
+// isBotRemovedError reports whether a send failed because the bot is no longer a member of the chat
+func isBotRemovedError(err error) bool {
+	errStr := err.Error()
+	return strings.Contains(errStr, "bot was kicked") ||
+		strings.Contains(errStr, "bot is not a member") ||
+		strings.Contains(errStr, "chat not found")
+}
+
+// isPresentStatus reports whether a chat member status means the bot is in the chat
+func isPresentStatus(status string) bool {
+	return status == "member" || status == "administrator" || status == "creator"
+}
+
+// HandleMyChatMember registers a group when the bot is added to it and deactivates it when the bot
+// is removed or kicked. Other status changes, like being promoted to admin, are ignored.
+func HandleMyChatMember(ctx context.Context, db *sql.DB, api echotron.API, update *echotron.ChatMemberUpdated) {
+	chat := update.Chat
+	if chat.Type != "group" && chat.Type != "supergroup" {
+		return
+	}
+
+	wasPresent := isPresentStatus(update.OldChatMember.Status)
+	isPresent := isPresentStatus(update.NewChatMember.Status)
+
+	switch {
+	case !wasPresent && isPresent:
+		_, err := database.GetGroup(ctx, db, chat.ID)
+		isNew := errors.Is(err, sql.ErrNoRows)
+		if err != nil && !isNew {
+			groupEvent(log.Error(), EventGroupQueryFailed, chat.ID).Err(err).Msg("GetGroup failed")
+		}
+
+		if err := database.CreateGroup(ctx, db, database.Group{GroupID: chat.ID, Title: chat.Title}); err != nil {
+			groupEvent(log.Error(), EventGroupSaveFailed, chat.ID).Err(err).Msg("CreateGroup failed")
+			return
+		}
+		// A group coming back keeps its settings; only new ones get the default profile
+		if isNew {
+			applyDefaultProfile(ctx, db, chat.ID)
+		}
+		rescheduleGroup(chat.ID)
+
+		groupEvent(log.Info(), EventGroupRegistered, chat.ID).Str("title", chat.Title).Msg("Bot added, group registered")
+		sendMessage(api, "👋 Привет! Группа подключена к Random Coffee: опрос будет приходить по расписанию.\n"+
+			"Посмотреть расписание - /schedule, отключить группу - /unregister", chat.ID)
+
+	case wasPresent && !isPresent:
+		deactivateRemovedGroup(ctx, db, chat.ID)
+	}
+}
+
+// getConfiguredGroups returns IDs of the active registered groups
+func getConfiguredGroups(ctx context.Context, db *sql.DB) []int64 {
+	groups, err := database.GetActiveGroups(ctx, db)
+	if err != nil {
+		botEvent(log.Error(), EventGroupQueryFailed).Err(err).Msg("GetActiveGroups failed")
+		return []int64{}
+	}
+
+	ids := make([]int64, 0, len(groups))
+	for _, g := range groups {
+		ids = append(ids, g.GroupID)
+	}
+	return ids
+}
+
+func isConfiguredGroup(ctx context.Context, db *sql.DB, groupID int64) bool {
+	g, err := database.GetGroup(ctx, db, groupID)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			groupEvent(log.Error(), EventGroupQueryFailed, groupID).Err(err).Msg("GetGroup failed")
+		}
+		return false
+	}
+	return g.Active
+}
+
+// deactivateRemovedGroup stops the schedule for a group the bot was removed from
+func deactivateRemovedGroup(ctx context.Context, db *sql.DB, groupID int64) {
+	deactivated, err := database.DeactivateGroup(ctx, db, groupID)
+	if err != nil {
+		groupEvent(log.Error(), EventGroupSaveFailed, groupID).Err(err).Msg("DeactivateGroup failed")
+		return
+	}
+	if deactivated {
+		rescheduleGroup(groupID)
+		groupEvent(log.Warn(), EventGroupDeactivated, groupID).Msg("Bot removed from group, group deactivated")
+		countOps(counterGroupDeactivated)
+	}
+}
+
+// handleRegisterCommand implements /register in a group: enrolls it, or reactivates it if it was unregistered
+func handleRegisterCommand(ctx context.Context, db *sql.DB, api echotron.API, message *echotron.Message) {
+	groupID := message.Chat.ID
+
+	existing, err := database.GetGroup(ctx, db, groupID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		groupEvent(log.Error(), EventGroupQueryFailed, groupID).Err(err).Msg("GetGroup failed")
+		sendMessage(api, "❌ Не удалось зарегистрировать группу", groupID)
+		return
+	}
+
+	if err := database.CreateGroup(ctx, db, database.Group{GroupID: groupID, Title: message.Chat.Title}); err != nil {
+		groupEvent(log.Error(), EventGroupSaveFailed, groupID).Err(err).Msg("CreateGroup failed")
+		sendMessage(api, "❌ Не удалось зарегистрировать группу", groupID)
+		return
+	}
+
+	if existing != nil && existing.Active {
+		sendMessage(api, "✅ Группа уже зарегистрирована", groupID)
+		return
+	}
+	// As when the bot is added: a group coming back keeps its settings, only new ones get the default profile
+	if existing == nil {
+		applyDefaultProfile(ctx, db, groupID)
+	}
+
+	rescheduleGroup(groupID)
+
+	writeAudit(ctx, db, message.From.ID, "register_group", groupID, message.Chat.Title)
+	userEvent(log.Info(), EventGroupRegistered, groupID, message.From.ID).Str("title", message.Chat.Title).Msg("Group registered")
+	sendMessage(api, "✅ Группа подключена к Random Coffee: опрос будет приходить по расписанию", groupID)
+
+	mergeMigratedRegistrations(ctx, db, api, groupID)
+}
+
+// mergeMigratedRegistrations merges registrations of the group from before it became a supergroup
+func mergeMigratedRegistrations(ctx context.Context, db *sql.DB, api echotron.API, groupID int64) {
+	migrations, err := database.GetChatMigrations(ctx, db)
+	if err != nil {
+		groupEvent(log.Error(), EventDuplicateCheckFailed, groupID).Err(err).Msg("GetChatMigrations failed")
+		return
+	}
+	for oldID, newID := range migrations {
+		if newID == groupID && isConfiguredGroup(ctx, db, oldID) {
+			mergeIfDuplicate(ctx, db, api, oldID)
+		}
+	}
+}
+
+// handleUnregisterCommand implements /unregister in a group: stops quizzes and pairs, keeping its history
+func handleUnregisterCommand(ctx context.Context, db *sql.DB, api echotron.API, message *echotron.Message) {
+	groupID := message.Chat.ID
+
+	deactivated, err := database.DeactivateGroup(ctx, db, groupID)
+	if err != nil {
+		groupEvent(log.Error(), EventGroupSaveFailed, groupID).Err(err).Msg("DeactivateGroup failed")
+		sendMessage(api, "❌ Не удалось отключить группу", groupID)
+		return
+	}
+	if !deactivated {
+		sendMessage(api, "Группа не подключена к Random Coffee", groupID)
+		return
+	}
+
+	rescheduleGroup(groupID)
+
+	writeAudit(ctx, db, message.From.ID, "unregister_group", groupID, "")
+	userEvent(log.Info(), EventGroupDeactivated, groupID, message.From.ID).Msg("Group unregistered")
+	countOps(counterGroupDeactivated)
+	sendMessage(api, "✅ Группа отключена: опросы больше не будут приходить. История пар сохранена, вернуть - /register", groupID)
+}
+
+const (
+	groupsPageCallback = "groups_page"
+
+	groupsFilterAll      = "all"
+	groupsFilterActive   = "active"
+	groupsFilterInactive = "inactive"
+)
+
+var groupsFilters = []listFilter{
+	{groupsFilterAll, "все"},
+	{groupsFilterActive, "активные"},
+	{groupsFilterInactive, "отключенные"},
+}
+
+// buildGroupsList loads registered groups matching the filter as a paged list
+func buildGroupsList(ctx context.Context, db *sql.DB, filter string) (pagedList, error) {
+	groups, err := database.GetAllGroups(ctx, db)
+	if err != nil {
+		return pagedList{}, err
+	}
+
+	l := pagedList{
+		callback: groupsPageCallback,
+		title:    "Группы",
+		empty:    "Нет групп с таким статусом",
+		filters:  groupsFilters,
+		filter:   filter,
+	}
+	if len(groups) == 0 {
+		l.empty = "Группы не зарегистрированы. Добавь бота в группу, и она подключится автоматически"
+	}
+
+	for _, g := range groups {
+		if (filter == groupsFilterActive && !g.Active) || (filter == groupsFilterInactive && g.Active) {
+			continue
+		}
+		title := g.Title
+		if title == "" {
+			title = "без названия"
+		}
+		state := "✅ активна"
+		if !g.Active {
+			state = "⏸ отключена"
+		}
+		l.lines = append(l.lines, fmt.Sprintf("• %s (%d) — %s", title, g.GroupID, state))
+	}
+	return l, nil
+}
+
+// handleGroupsCommand lists registered groups with their state for admins, a page at a time
+func handleGroupsCommand(ctx context.Context, db *sql.DB, api echotron.API, message *echotron.Message) {
+	chatID := message.Chat.ID
+	if !isAdmin(message.From.ID) {
+		sendMessage(api, "❌ Доступ запрещен", chatID)
+		return
+	}
+
+	l, err := buildGroupsList(ctx, db, groupsFilterAll)
+	if err != nil {
+		botEvent(log.Error(), EventGroupQueryFailed).Err(err).Msg("GetAllGroups failed")
+		sendMessage(api, "❌ Не удалось загрузить список групп", chatID)
+		return
+	}
+	sendPagedList(api, l, chatID)
+}
+
+// handleGroupsPageCallback shows another page or filter of the /groups list in the same message
+func handleGroupsPageCallback(ctx context.Context, db *sql.DB, api echotron.API, cq *echotron.CallbackQuery, arg string) {
+	if cq.From == nil || !isAdmin(cq.From.ID) {
+		answerCallback(api, cq, "❌ Доступ запрещен")
+		return
+	}
+	page, filter, ok := parseListCallback(arg)
+	if !ok {
+		answerCallback(api, cq, "")
+		return
+	}
+	if isStaleListMessage(cq) {
+		answerCallback(api, cq, listStaleText)
+		return
+	}
+
+	l, err := buildGroupsList(ctx, db, filter)
+	if err != nil {
+		botEvent(log.Error(), EventGroupQueryFailed).Err(err).Msg("GetAllGroups failed")
+		answerCallback(api, cq, "❌ Не удалось загрузить список групп")
+		return
+	}
+	answerCallback(api, cq, "")
+	text, keyboard := l.render(page)
+	editCallbackMessage(api, cq, text, keyboard)
+}
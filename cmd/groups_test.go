@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"example.com/random_coffee/database"
+	"github.com/NicoNex/echotron/v3"
+)
+
+// groupsList is the /groups message as the admin sees it after the last send or edit
+type groupsList struct {
+	text    string
+	buttons map[string]string // button text -> callback data
+}
+
+// lastGroupsList reads the list from the last call of the given method
+func lastGroupsList(t *testing.T, tg *fakeTelegram, method string) groupsList {
+	t.Helper()
+	params := tg.lastCall(method)
+	var markup echotron.InlineKeyboardMarkup
+	if err := json.Unmarshal([]byte(params.Get("reply_markup")), &markup); err != nil {
+		t.Fatalf("%s reply_markup %q: %v", method, params.Get("reply_markup"), err)
+	}
+	l := groupsList{text: params.Get("text"), buttons: make(map[string]string)}
+	for _, row := range markup.InlineKeyboard {
+		for _, b := range row {
+			l.buttons[b.Text] = b.CallbackData
+		}
+	}
+	return l
+}
+
+// entries counts the groups listed on the page
+func (l groupsList) entries() int {
+	return strings.Count(l.text, "\n• ")
+}
+
+func TestGroupsPagination(t *testing.T) {
+	db := openTestDB(t)
+	tg, api := newFakeTelegram(t)
+	withAdmins(t, testAdminID)
+	ctx := context.Background()
+	// 18 active groups and 5 switched off
+	for i := 1; i <= 23; i++ {
+		seedTestGroup(t, db, int64(-1000-i), fmt.Sprintf("Coffee %02d", i))
+		if i > 18 {
+			if _, err := database.DeactivateGroup(ctx, db, int64(-1000-i)); err != nil {
+				t.Fatalf("DeactivateGroup: %v", err)
+			}
+		}
+	}
+	sentAt := int(time.Now().Add(-time.Hour).Unix())
+	press := func(data string, date int) string {
+		t.Helper()
+		HandleCallbackQuery(ctx, db, api, &echotron.CallbackQuery{ID: "cb", Data: data, From: &echotron.User{ID: testAdminID},
+			Message: &echotron.Message{ID: 5, Date: date, Chat: echotron.Chat{ID: testAdminID, Type: "private"}}})
+		return tg.lastCall("answerCallbackQuery").Get("text")
+	}
+	check := func(l groupsList, header string, entries int, buttons ...string) {
+		t.Helper()
+		if !strings.HasPrefix(l.text, header) || l.entries() != entries {
+			t.Fatalf("list %q, want %q with %d groups", l.text, header, entries)
+		}
+		for _, b := range buttons {
+			if _, ok := l.buttons[b]; !ok {
+				t.Fatalf("buttons %v, want %q", l.buttons, b)
+			}
+		}
+		if len(l.buttons) != len(buttons) {
+			t.Fatalf("buttons %v, want only %q", l.buttons, buttons)
+		}
+	}
+
+	handleGroupsCommand(ctx, db, api, &echotron.Message{Text: "/groups", Chat: echotron.Chat{ID: testAdminID, Type: "private"},
+		From: &echotron.User{ID: testAdminID}})
+	l := lastGroupsList(t, tg, "sendMessage")
+	check(l, "Группы · все (стр. 1/3, всего 23)", 10, "➡️", "• все", "активные", "отключенные")
+
+	press(l.buttons["➡️"], sentAt)
+	l = lastGroupsList(t, tg, "editMessageText")
+	check(l, "Группы · все (стр. 2/3, всего 23)", 10, "⬅️", "➡️", "• все", "активные", "отключенные")
+
+	// The last page has the remainder and no way further
+	press(l.buttons["➡️"], sentAt)
+	l = lastGroupsList(t, tg, "editMessageText")
+	check(l, "Группы · все (стр. 3/3, всего 23)", 3, "⬅️", "• все", "активные", "отключенные")
+	if strings.Count(l.text, "⏸ отключена") != 3 {
+		t.Fatalf("last page %q, want the switched-off groups at the end", l.text)
+	}
+
+	press(l.buttons["⬅️"], sentAt)
+	check(lastGroupsList(t, tg, "editMessageText"), "Группы · все (стр. 2/3, всего 23)", 10, "⬅️", "➡️", "• все", "активные", "отключенные")
+
+	// A filter starts from its first page
+	press(l.buttons["отключенные"], sentAt)
+	l = lastGroupsList(t, tg, "editMessageText")
+	check(l, "Группы · отключенные (стр. 1/1, всего 5)", 5, "все", "активные", "• отключенные")
+	press(l.buttons["активные"], sentAt)
+	check(lastGroupsList(t, tg, "editMessageText"), "Группы · активные (стр. 1/2, всего 18)", 10, "➡️", "все", "• активные", "отключенные")
+
+	// A page that no longer exists, e.g. after groups were switched off, shows the last one there is
+	press(groupsPageCallback+":2:"+groupsFilterActive, sentAt)
+	check(lastGroupsList(t, tg, "editMessageText"), "Группы · активные (стр. 2/2, всего 18)", 8, "⬅️", "все", "• активные", "отключенные")
+
+	// Buttons of a day-old list only answer, without touching the message
+	edits := tg.count("editMessageText")
+	if answer := press(groupsPageCallback+":1:"+groupsFilterAll, int(time.Now().Add(-25*time.Hour).Unix())); answer != listStaleText {
+		t.Fatalf("stale list answered %q", answer)
+	}
+	if answer := press(groupsPageCallback+":x:"+groupsFilterAll, sentAt); answer != "" {
+		t.Fatalf("malformed page answered %q", answer)
+	}
+	if n := tg.count("editMessageText"); n != edits {
+		t.Fatalf("message edited %d times by stale or malformed presses", n-edits)
+	}
+}
@@ -4,15 +4,19 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"math/rand"
 	"net/http"
 	"net/url"
 	"os"
 	"strconv"
 	"strings"
 	"time"
+	"unicode/utf16"
 
 	"example.com/random_coffee/database"
+	"example.com/random_coffee/pkg/pairing"
 	"github.com/NicoNex/echotron/v3"
 	"github.com/google/uuid"
 	"github.com/rs/zerolog/log"
@@ -20,38 +24,125 @@ import (
 
 var adminChatIDsMap map[int64]bool
 
+// isChatUnavailableError reports whether a send failed because of the chat itself
+// (bot blocked or kicked, chat gone, no rights) rather than a transient problem
+func isChatUnavailableError(err error) bool {
+	errStr := err.Error()
+	return strings.Contains(errStr, "bot was blocked") ||
+		strings.Contains(errStr, "bot was kicked") ||
+		strings.Contains(errStr, "chat not found") ||
+		strings.Contains(errStr, "have no rights")
+}
+
 // sendMessage is a helper that sends a message and logs errors
 func sendMessage(api echotron.API, text string, chatID int64) {
-	if _, err := api.SendMessage(text, chatID, nil); err != nil {
+	_, err := api.SendMessage(text, chatID, nil)
+	tokenWatcher.sendDone(err)
+	if err != nil {
 		// Check if bot was blocked/kicked from chat
-		errStr := err.Error()
-		if strings.Contains(errStr, "bot was blocked") ||
-			strings.Contains(errStr, "bot was kicked") ||
-			strings.Contains(errStr, "chat not found") ||
-			strings.Contains(errStr, "have no rights") {
+		if isChatUnavailableError(err) {
 			// Don't spam with errors - bot was removed from group
 			if chatID < 0 {
-				log.Warn().Err(err).Int64("group_id", chatID).Msg("Bot removed from group or no permissions")
+				groupEvent(log.Warn(), EventMessageBotRemoved, chatID).Err(err).Msg("Bot removed from group or no permissions")
+				countOps(counterBotRemoved)
+				if isBotRemovedError(err) && onBotRemoved != nil {
+					onBotRemoved(chatID)
+				}
 			} else {
-				log.Warn().Err(err).Int64("chat_id", chatID).Msg("Bot blocked by user or chat not found")
+				botEvent(log.Warn(), EventMessageBlocked).Err(err).Int64("chat_id", chatID).Msg("Bot blocked by user or chat not found")
+				countOps(counterDMBlocked)
 			}
 		} else {
 			// Real error
 			if chatID < 0 {
-				log.Error().Err(err).Int64("group_id", chatID).Msg("SendMessage failed")
+				groupEvent(log.Error(), EventMessageSendFailed, chatID).Err(err).Msg("SendMessage failed")
 			} else {
-				log.Error().Err(err).Int64("chat_id", chatID).Msg("SendMessage failed")
+				botEvent(log.Error(), EventMessageSendFailed).Err(err).Int64("chat_id", chatID).Msg("SendMessage failed")
+				countOps(counterDMFailed)
+			}
+		}
+	}
+}
+
+// telegramMessageLimit is a safe message length below Telegram's 4096 character limit
+const telegramMessageLimit = 4000
+
+// utf16Len returns the length of text the way Telegram counts it, in UTF-16 code units
+func utf16Len(text string) int {
+	n := 0
+	for _, r := range text {
+		n += utf16.RuneLen(r)
+	}
+	return n
+}
+
+// splitMessage splits text into chunks no longer than limit UTF-16 code units, breaking at
+// line ends where possible. Blank lines at chunk edges are dropped.
+func splitMessage(text string, limit int) []string {
+	chunks := make([]string, 0, 1)
+	var current strings.Builder
+	currentLen := 0
+
+	flush := func() {
+		if chunk := strings.Trim(current.String(), "\n"); chunk != "" {
+			chunks = append(chunks, chunk)
+		}
+		current.Reset()
+		currentLen = 0
+	}
+
+	for _, line := range strings.SplitAfter(text, "\n") {
+		lineLen := utf16Len(line)
+		if currentLen+lineLen > limit {
+			flush()
+		}
+		// A single line longer than the limit is cut between characters, never inside an emoji sequence
+		for lineLen > limit {
+			cut, cutLen := 0, 0
+			for cut < len(line) {
+				size := nextGrapheme(line[cut:])
+				size16 := utf16Len(line[cut : cut+size])
+				if cutLen+size16 > limit {
+					break
+				}
+				cut += size
+				cutLen += size16
+			}
+			if cut == 0 {
+				break // the limit is below one character
 			}
+			chunks = append(chunks, line[:cut])
+			line = line[cut:]
+			lineLen -= cutLen
 		}
+		current.WriteString(line)
+		currentLen += lineLen
+	}
+	flush()
+	return chunks
+}
+
+// sendLongMessage sends text as several messages if it does not fit into one
+func sendLongMessage(api echotron.API, text string, chatID int64) {
+	for _, chunk := range splitMessage(text, telegramMessageLimit) {
+		sendMessage(api, chunk, chatID)
 	}
 }
 
-// getDisplayName returns username with @ prefix if available, otherwise returns full name
+// getDisplayName returns username with @ prefix if available, otherwise the sanitized full name
 func getDisplayName(p database.Participant) string {
 	if p.Username != "" {
 		return "@" + p.Username
 	}
-	return p.FullName
+	return sanitizeDisplayName(p.FullName)
+}
+
+// getProfileDisplayName is getDisplayName for a stored user profile
+func getProfileDisplayName(u database.UserProfile) string {
+	if u.Username != "" {
+		return "@" + u.Username
+	}
+	return sanitizeDisplayName(u.FullName)
 }
 
 // getWeekStart returns Monday of the current week in YYYY-MM-DD format
@@ -63,6 +154,9 @@ func getWeekStart(t time.Time) string {
 	return t.AddDate(0, 0, -offset).Format("2006-01-02")
 }
 
+// telegramBotURL is where sendPollNonAnonymous sends its request, followed by the token
+var telegramBotURL = "https://api.telegram.org/bot"
+
 // sendPollNonAnonymous sends a non-anonymous poll by manually constructing the request
 // Workaround for echotron bug where IsAnonymous=false is ignored (bool false is zero value)
 func sendPollNonAnonymous(chatID int64, question string, options []echotron.InputPollOption, opts *echotron.PollOptions) (*echotron.APIResponseMessage, error) {
@@ -71,7 +165,7 @@ func sendPollNonAnonymous(chatID int64, question string, options []echotron.Inpu
 		return nil, fmt.Errorf("TELEGRAM__TOKEN not set")
 	}
 
-	baseURL := "https://api.telegram.org/bot" + token + "/sendPoll"
+	baseURL := telegramBotURL + token + "/sendPoll"
 
 	vals := make(url.Values)
 	vals.Set("chat_id", strconv.FormatInt(chatID, 10))
@@ -111,6 +205,16 @@ func sendPollNonAnonymous(chatID int64, question string, options []echotron.Inpu
 	return &result, nil
 }
 
+// parseCommand splits a command message into the command name (without @botname) and its arguments
+func parseCommand(text string) (string, []string) {
+	fields := strings.Fields(text)
+	if len(fields) == 0 {
+		return "", nil
+	}
+	command, _, _ := strings.Cut(fields[0], "@")
+	return command, fields[1:]
+}
+
 func parseCommaSeparatedIDs(envKey, fieldName string) []int64 {
 	value := os.Getenv(envKey)
 	if value == "" {
@@ -121,7 +225,7 @@ func parseCommaSeparatedIDs(envKey, fieldName string) []int64 {
 	for _, part := range strings.Split(value, ",") {
 		id, err := strconv.ParseInt(strings.TrimSpace(part), 10, 64)
 		if err != nil {
-			log.Warn().Err(err).Str("value", part).Str("field", fieldName).Msg("Failed to parse chat ID")
+			botEvent(log.Warn(), EventConfigInvalidID).Err(err).Str("value", part).Str("field", fieldName).Msg("Failed to parse chat ID")
 			continue
 		}
 		ids = append(ids, id)
@@ -140,62 +244,112 @@ func isAdmin(userID int64) bool {
 	return adminChatIDsMap[userID]
 }
 
-func getConfiguredGroups() []int64 {
-	return parseCommaSeparatedIDs("GROUP_CHAT_IDS", "group")
+// notifyAdmins sends a message to every admin
+func notifyAdmins(api echotron.API, text string) {
+	for adminID := range adminChatIDsMap {
+		sendMessage(api, text, adminID)
+	}
 }
 
 // HandlePollAnswer processes poll responses
 func HandlePollAnswer(ctx context.Context, db *sql.DB, api echotron.API, pollAnswer *echotron.PollAnswer) {
 	if pollAnswer.User == nil {
-		log.Warn().Msg("PollAnswer with nil User received")
+		botEvent(log.Warn(), EventPollAnswerInvalid).Str("poll_id", pollAnswer.PollID).Msg("PollAnswer with nil User received")
 		return
 	}
 
 	// Log every poll answer for debugging
-	log.Info().Str("poll_id", pollAnswer.PollID).Int64("user_id", pollAnswer.User.ID).Str("username", pollAnswer.User.Username).
+	botEvent(log.Info(), EventPollAnswer).Str("poll_id", pollAnswer.PollID).Int64("user_id", pollAnswer.User.ID).Str("username", pollAnswer.User.Username).
 		Interface("option_ids", pollAnswer.OptionIDs).Msg("Poll answer received")
 
 	// Try to find the poll in our database
 	groupID, err := database.GetGroupIDByPollID(ctx, db, pollAnswer.PollID)
 	if err != nil {
-		// Poll not found - this is OK, it might be an old poll that was already processed
-		// Don't spam logs with errors for old polls
-		log.Warn().Err(err).Str("poll_id", pollAnswer.PollID).Msg("Poll not found in database")
-		return
+		recoveredGroupID, ok := recoverPollMapping(ctx, db, api, pollAnswer.PollID)
+		if !ok {
+			// Poll not found - this is OK, it might be an old poll that was already processed
+			// Don't spam logs with errors for old polls
+			botEvent(log.Warn(), EventPollUnknown).Err(err).Str("poll_id", pollAnswer.PollID).Msg("Poll not found in database")
+			return
+		}
+		groupID = recoveredGroupID
 	}
 
-	// If cancelled vote or selected "No" (option 1)
-	if len(pollAnswer.OptionIDs) == 0 || pollAnswer.OptionIDs[0] != 0 {
-		// Try to remove participant (ignore if not found)
-		if err := database.DeleteParticipant(ctx, db, groupID, pollAnswer.User.ID); err != nil {
-			log.Warn().Err(err).Int64("user_id", pollAnswer.User.ID).Int64("group_id", groupID).Msg("Failed to delete participant")
+	// A cancelled vote or "No" (option 1) removes the participant
+	joined := len(pollAnswer.OptionIDs) > 0 && pollAnswer.OptionIDs[0] == pollYesOption
+	applySignupAnswer(ctx, db, groupID, pollAnswer.User, joined, pollAnswer.PollID)
+}
+
+// applySignupAnswer adds the user to the group's participants or removes them, whichever way they signed up.
+// An answer the locked database refused is parked and written later. It reports whether the answer was
+// stored or parked.
+func applySignupAnswer(ctx context.Context, db *sql.DB, groupID int64, user *echotron.User, joined bool, pollID string) bool {
+	answeredAt := time.Now()
+	parkedSignups.supersede(groupID, user.ID)
+	err := storeSignupAnswer(ctx, db, groupID, user, joined)
+	if database.IsBusy(err) {
+		parkSignup(groupID, user, joined, pollID, answeredAt, err)
+		return true
+	}
+	parkedSignups.drop(groupID, user.ID, answeredAt)
+	if err != nil {
+		if joined {
+			userEvent(log.Error(), EventPollVoteYesFailed, groupID, user.ID).Err(err).Msg("CreateOrUpdateParticipant failed")
 		} else {
-			log.Info().Int64("user_id", pollAnswer.User.ID).Int64("group_id", groupID).Msg("User removed from participants")
+			userEvent(log.Warn(), EventPollVoteNoFailed, groupID, user.ID).Err(err).Msg("Failed to delete participant")
 		}
-		return
+		return false
+	}
+
+	dashboards.schedule(user.ID)
+
+	if joined {
+		cancelSlowStartIfReached(ctx, db, groupID, pollID)
+	}
+	return true
+}
+
+// storeSignupAnswer writes a sign-up answer: a participant and their profile, or the participant's removal
+func storeSignupAnswer(ctx context.Context, db *sql.DB, groupID int64, user *echotron.User, joined bool) error {
+	if !joined {
+		// Removing someone who never signed up is not an error
+		if err := database.DeleteParticipant(ctx, db, groupID, user.ID); err != nil {
+			return err
+		}
+		userEvent(log.Info(), EventPollVoteNo, groupID, user.ID).Msg("User removed from participants")
+		return nil
 	}
 
-	// Selected "Yes" (option 0) - add participant
-	fullName := pollAnswer.User.FirstName
-	if pollAnswer.User.LastName != "" {
-		fullName += " " + pollAnswer.User.LastName
+	fullName := user.FirstName
+	if user.LastName != "" {
+		fullName += " " + user.LastName
 	}
 
 	p := database.Participant{
 		ID:        uuid.New(),
 		GroupID:   groupID,
-		UserID:    pollAnswer.User.ID,
-		Username:  pollAnswer.User.Username,
+		UserID:    user.ID,
+		Username:  user.Username,
 		FullName:  fullName,
 		CreatedAt: time.Now(),
 	}
 
 	if err := database.CreateOrUpdateParticipant(ctx, db, p); err != nil {
-		log.Error().Err(err).Int64("group_id", groupID).Int64("user_id", pollAnswer.User.ID).Msg("CreateOrUpdateParticipant failed")
-		return
+		return err
 	}
 
-	log.Info().Int64("user_id", pollAnswer.User.ID).Int64("group_id", groupID).Str("username", p.Username).Msg("User added to participants")
+	profile := database.UserProfile{
+		UserID:    p.UserID,
+		Username:  p.Username,
+		FullName:  p.FullName,
+		UpdatedAt: p.CreatedAt,
+	}
+	if err := database.UpsertUserProfile(ctx, db, profile); err != nil {
+		userEvent(log.Warn(), EventProfileSaveFailed, groupID, p.UserID).Err(err).Msg("UpsertUserProfile failed")
+	}
+
+	userEvent(log.Info(), EventPollVoteYes, groupID, user.ID).Str("username", p.Username).Msg("User added to participants")
+	return nil
 }
 
 // HandleGroupCommand processes commands in group chats
@@ -205,165 +359,480 @@ func HandleGroupCommand(ctx context.Context, db *sql.DB, api echotron.API, messa
 	}
 
 	groupID := message.Chat.ID
+	if handleAnnouncementMediaUpload(ctx, db, api, message) {
+		return
+	}
+
+	command, args := parseCommand(message.Text)
 
-	switch message.Text {
+	switch command {
 	case "/create_pairs":
-		log.Info().Int64("group_id", groupID).Msg("Manual create_pairs command")
-		CreatePairs(ctx, db, api, groupID)
+		userEvent(log.Info(), EventCommand, groupID, message.From.ID).Str("command", command).Msg("Manual create_pairs command")
+		handleCreatePairsCommand(ctx, db, api, groupID, args)
 	case "/send_quiz":
-		log.Info().Int64("group_id", groupID).Msg("Manual send_quiz command")
-		SendQuiz(ctx, db, api, groupID)
+		userEvent(log.Info(), EventCommand, groupID, message.From.ID).Str("command", command).Msg("Manual send_quiz command")
+		runManualJob(api, jobSendQuiz, groupID, func() { SendQuiz(ctx, db, api, groupID) })
+	case "/countdown":
+		handleCountdownCommand(ctx, db, api, groupID, args)
+	case "/min_notice":
+		handleMinNoticeCommand(ctx, db, api, groupID, args)
+	case "/schedule", "/set_schedule":
+		handleScheduleCommand(ctx, db, api, message, args)
+	case "/set_timezone":
+		handleScheduleCommand(ctx, db, api, message, append([]string{"tz"}, args...))
+	case "/defer_quiz":
+		handleDeferQuizCommand(ctx, db, api, message, args)
+	case "/set_announcement_media":
+		handleSetAnnouncementMediaCommand(ctx, db, api, message)
+	case "/clear_announcement_media":
+		handleClearAnnouncementMediaCommand(ctx, db, api, message)
+	case "/slow_start":
+		handleSlowStartCommand(ctx, db, api, message.Chat.ID, args)
+	case "/dm_policy":
+		handleDMPolicyCommand(ctx, db, api, message, args)
+	case "/signup_mode":
+		handleSignupModeCommand(ctx, db, api, message, args)
+	case "/set_cycle_theme":
+		handleSetCycleThemeCommand(ctx, db, api, message)
+	case "/language":
+		handleGroupLanguageCommand(ctx, db, api, groupID, args)
+	case "/holidays":
+		handleHolidaysCommand(ctx, db, api, message, args)
+	case "/add_holiday":
+		handleHolidayRangeCommand(ctx, db, api, message, args, true)
+	case "/remove_holiday":
+		handleHolidayRangeCommand(ctx, db, api, message, args, false)
+	case "/save_profile":
+		handleSaveProfileCommand(ctx, db, api, message, args)
+	case "/apply_profile":
+		handleApplyProfileCommand(ctx, db, api, message, args)
+	case "/register":
+		handleRegisterCommand(ctx, db, api, message)
+	case "/unregister":
+		handleUnregisterCommand(ctx, db, api, message)
 	}
 }
 
+// runManualJob runs an admin-triggered job, replying in the group if the same job is already in progress
+func runManualJob(api echotron.API, job string, groupID int64, fn func()) {
+	if startedAt, ok := runGuarded(job, groupID, fn); !ok {
+		groupEvent(log.Warn(), EventJobBusy, groupID).Str("job", job).Time("started_at", startedAt).Msg("Job already running")
+		sendMessage(api, fmt.Sprintf("⏳ Уже выполняется, начато %d секунд назад", int(time.Since(startedAt).Seconds())), groupID)
+	}
+}
+
+// adminHelpText lists admin commands in /start, shown only to admins
+const adminHelpText = "Команды в личке (только для админов):\n" +
+	"/groups - список групп\n" +
+	"/status - состояние бота\n" +
+	"/maintenance on [минуты] | off - приостановить обработку обновлений, не теряя их\n" +
+	"/stats - статистика участия по группам\n" +
+	"/weekly_report - сводка работы бота за прошлую неделю\n" +
+	"/history <group_id> [недель] - история пар в CSV\n" +
+	"/cancel_export - прервать свои выгрузки и подсчет статистики\n" +
+	"/volunteers - волонтеры для новичков\n" +
+	"/snapshots list | resend <id> - снапшоты для аналитики\n" +
+	"/experiment create|status|stop - A/B-эксперимент с текстом анонса пар\n" +
+	"/defer_quiz <group_id> <часы> | off - сдвинуть ближайший опрос группы\n" +
+	"/manual_pairs <group_id> + пары по строкам - объявить пары, составленные вручную\n" +
+	"/clone_group_data <source> <target> [user_id ...] - перенести историю пар в другую группу\n\n" +
+	"Команды в группе (только для админов):\n" +
+	"/register - снова подключить группу после /unregister\n" +
+	"/schedule - расписание группы\n" +
+	"/set_schedule quiz|pairs <день> <ЧЧ:ММ> - изменить время опроса или пар\n" +
+	"/set_timezone <пояс> - часовой пояс группы, например Europe/Berlin\n" +
+	"/defer_quiz <часы> | off - сдвинуть только ближайший опрос, до 48 ч.\n" +
+	"/unregister - отключить группу\n" +
+	"/send_quiz - отправить опрос вручную\n" +
+	"/create_pairs [confirm] - создать пары вручную\n" +
+	"/min_notice <часы> - минимальный срок между опросом и ручным созданием пар\n" +
+	"/countdown on|off - обратный отсчет под опросом\n" +
+	"/set_announcement_media - фото или стикер перед анонсом пар\n" +
+	"/clear_announcement_media - убрать картинку из анонса\n" +
+	"/slow_start off|<часы> [мин. голосов] - напомнить об опросе, если записались немногие\n" +
+	"/dm_policy off|opt-in|opt-out|on - личные сообщения участникам\n" +
+	"/signup_mode poll|buttons|auto - запись через опрос или кнопки\n" +
+	"/set_cycle_theme <тема> | off - тема ближайшего цикла в опросе, анонсе и личных сообщениях\n" +
+	"/language ru|en|off - язык личных ответов участникам, которые не выбрали свой\n" +
+	"/holidays [ru|kz|off] - праздничный календарь, в праздники неделя пропускается\n" +
+	"/add_holiday <с>..<по> - добавить свои даты в календарь\n" +
+	"/remove_holiday <с>..<по> - удалить свои даты\n" +
+	"/save_profile <имя> - сохранить настройки группы как профиль\n" +
+	"/apply_profile <имя> [confirm] - применить профиль к группе"
+
+// adminPrivateCommands are the private commands only bot admins may use
+var adminPrivateCommands = map[string]bool{
+	"/groups": true, "/status": true, "/stats": true, "/weekly_report": true, "/history": true,
+	"/defer_quiz": true, "/manual_pairs": true, "/cancel_export": true, "/clone_group_data": true,
+	"/maintenance": true, "/experiment": true, "/snapshots": true, "/volunteers": true,
+}
+
 // HandlePrivateCommand processes commands in private chats
 func HandlePrivateCommand(ctx context.Context, db *sql.DB, api echotron.API, message *echotron.Message) {
 	if message.From == nil {
 		return
 	}
 
-	switch message.Text {
+	command, args := parseCommand(message.Text)
+	lang := userLanguage(ctx, db, message)
+	if adminPrivateCommands[command] && !isAdmin(message.From.ID) {
+		sendMessage(api, tr(lang, "access.denied"), message.Chat.ID)
+		return
+	}
+
+	switch command {
 	case "/start":
-		text := "👋 Привет! Это Random Coffee Bot.\n\n" +
-			"Бот автоматически создает пары для случайных встреч.\n\n" +
-			"📅 Расписание:\n" +
-			"• Пятница 17:00 - рассылка опроса\n" +
-			"• Воскресенье 19:00 - создание пар\n\n" +
-			"Команды в личке (только для админов):\n" +
-			"/groups - список групп\n\n" +
-			"Команды в группе (только для админов):\n" +
-			"/send_quiz - отправить опрос вручную\n" +
-			"/create_pairs - создать пары вручную"
+		// Deep link t.me/<bot>?start=notify arrives as "/start notify"
+		if len(args) == 1 && args[0] == dmStartPayload {
+			setDMPreference(ctx, db, api, message, true, lang)
+		}
+		text := tr(lang, "start.intro")
+		if isAdmin(message.From.ID) {
+			text += "\n\n" + adminHelpText
+		}
 		sendMessage(api, text, message.Chat.ID)
 
 	case "/groups":
-		if !isAdmin(message.From.ID) {
-			sendMessage(api, "❌ Доступ запрещен", message.Chat.ID)
+		handleGroupsCommand(ctx, db, api, message)
+
+	case "/status":
+		sendMessage(api, buildStatusMessage(ctx, db), message.Chat.ID)
+
+	case "/stats":
+		handleStatsCommand(ctx, db, api, message)
+
+	case "/weekly_report":
+		handleWeeklyReportCommand(ctx, db, api, message)
+
+	case "/history":
+		handleHistoryCommand(ctx, db, api, message, args)
+
+	case "/defer_quiz":
+		handleDeferQuizCommand(ctx, db, api, message, args)
+
+	case "/manual_pairs":
+		handleManualPairsCommand(ctx, db, api, message)
+
+	case "/cancel_export":
+		handleCancelExportCommand(api, message)
+
+	case "/clone_group_data":
+		handleCloneGroupData(ctx, db, api, message, args)
+
+	case "/maintenance":
+		handleMaintenanceCommand(ctx, db, api, message, args)
+
+	case "/experiment":
+		handleExperimentCommand(ctx, db, api, message, args)
+
+	case "/my_data":
+		handleMyData(ctx, db, api, message, lang)
+
+	case "/avoid":
+		handleAvoidCommand(ctx, db, api, message, args, lang)
+
+	case "/unavoid":
+		handleUnavoidCommand(ctx, db, api, message, args, lang)
+
+	case "/snapshots":
+		handleSnapshotsCommand(api, message, args)
+
+	case "/dashboard":
+		handleDashboardCommand(ctx, db, api, message, args, lang)
+
+	case "/language":
+		handleLanguageCommand(ctx, db, api, message, args, lang)
+
+	case "/notifications":
+		handleNotificationsCommand(ctx, db, api, message, args, lang)
+
+	case "/volunteer":
+		handleVolunteerCommand(ctx, db, api, message, args, lang)
+
+	case "/volunteers":
+		handleVolunteersCommand(ctx, db, api, message)
+
+	default:
+		sendMessage(api, tr(lang, "command.unknown"), message.Chat.ID)
+	}
+}
+
+// buildStatusMessage describes what the bot is doing right now
+func buildStatusMessage(ctx context.Context, db *sql.DB) string {
+	text := formatMaintenanceStatus()
+	text += fmt.Sprintf("Активных чатов в памяти: %d\n", sessions.count())
+	text += formatSchedulerHealth()
+	text += formatHolidaySkips()
+	text += formatQuizDeferrals(ctx, db)
+	if parked, oldest := parkedSignups.stats(); parked > 0 || database.BusyEvents() > 0 {
+		text += fmt.Sprintf("Записи в заблокированную базу: %d, ждут повторной записи: %d (самой старой %d мин)\n",
+			database.BusyEvents(), parked, int(oldest.Minutes()))
+	}
+	text += "\n"
+
+	jobs := runningJobs.snapshot()
+	if len(jobs) == 0 {
+		return text + "Сейчас нет выполняющихся задач"
+	}
+
+	text += "Выполняются задачи:\n"
+	for _, j := range jobs {
+		text += fmt.Sprintf("• %s в группе %d — %d с\n", j.Job, j.GroupID, int(time.Since(j.StartedAt).Seconds()))
+	}
+	return text
+}
+
+// handleCloneGroupData copies pair history of one group into another, e.g. when a chat is split in two.
+// Only history is copied: the active cycle (open poll, current participants) stays with the source group.
+func handleCloneGroupData(ctx context.Context, db *sql.DB, api echotron.API, message *echotron.Message, args []string) {
+	chatID := message.Chat.ID
+	if !isAdmin(message.From.ID) {
+		sendMessage(api, "❌ Доступ запрещен", chatID)
+		return
+	}
+
+	confirmed := len(args) > 0 && args[len(args)-1] == "confirm"
+	if confirmed {
+		args = args[:len(args)-1]
+	}
+
+	if len(args) < 2 {
+		sendMessage(api, "Использование: /clone_group_data <source> <target> [user_id ...] [confirm]", chatID)
+		return
+	}
+
+	ids := make([]int64, 0, len(args))
+	for _, arg := range args {
+		id, err := strconv.ParseInt(arg, 10, 64)
+		if err != nil {
+			sendMessage(api, fmt.Sprintf("❌ Некорректный ID: %s", arg), chatID)
 			return
 		}
+		ids = append(ids, id)
+	}
 
-		groupIDs := getConfiguredGroups()
-		if len(groupIDs) == 0 {
-			sendMessage(api, "Группы не настроены", message.Chat.ID)
-			return
+	sourceID, targetID, userIDs := ids[0], ids[1], ids[2:]
+	if sourceID == targetID {
+		sendMessage(api, "❌ Исходная и целевая группы совпадают", chatID)
+		return
+	}
+	if !isConfiguredGroup(ctx, db, targetID) {
+		sendMessage(api, fmt.Sprintf("❌ Группа %d не подключена к боту", targetID), chatID)
+		return
+	}
+
+	history, err := database.GetPairHistory(ctx, db, sourceID)
+	if err != nil {
+		groupEvent(log.Error(), EventCloneFailed, sourceID).Err(err).Msg("GetPairHistory failed")
+		sendMessage(api, "❌ Ошибка при чтении истории пар", chatID)
+		return
+	}
+
+	pairs := filterPairsByUsers(history, userIDs)
+	if len(pairs) == 0 {
+		sendMessage(api, fmt.Sprintf("В группе %d нет подходящей истории пар", sourceID), chatID)
+		return
+	}
+
+	if !confirmed {
+		text := fmt.Sprintf("Будет скопировано пар: %d из %d (группа %d → %d).\n", len(pairs), len(history), sourceID, targetID)
+		if len(userIDs) > 0 {
+			text += fmt.Sprintf("Фильтр по участникам: %d чел.\n", len(userIDs))
 		}
+		text += "Текущий опрос и участники не копируются.\n\n" +
+			"Для подтверждения повтори команду с confirm в конце."
+		sendMessage(api, text, chatID)
+		return
+	}
+
+	copied, err := database.CopyPairs(ctx, db, targetID, pairs)
+	if err != nil {
+		groupEvent(log.Error(), EventCloneFailed, targetID).Err(err).Int64("source_group_id", sourceID).Msg("CopyPairs failed")
+		sendMessage(api, "❌ Ошибка при копировании, изменения отменены", chatID)
+		return
+	}
+
+	writeAudit(ctx, db, message.From.ID, "clone_group_data", targetID, fmt.Sprintf("source=%d pairs=%d users=%v", sourceID, copied, userIDs))
+
+	userEvent(log.Info(), EventCloneCompleted, targetID, message.From.ID).Int64("source_group_id", sourceID).Int64("pairs_count", copied).Msg("Group data cloned")
+	sendMessage(api, fmt.Sprintf("✅ Скопировано пар: %d (уже были в целевой группе: %d)", copied, int64(len(pairs))-copied), chatID)
+}
 
-		text := "Настроенные группы:\n"
-		for _, gid := range groupIDs {
-			text += fmt.Sprintf("• %d\n", gid)
+// writeAudit records an admin action; failures are logged and never block the action
+func writeAudit(ctx context.Context, db *sql.DB, actorID int64, action string, groupID int64, details string) {
+	entry := database.AuditEntry{
+		ID:        uuid.New(),
+		ActorID:   actorID,
+		Action:    action,
+		GroupID:   groupID,
+		Details:   details,
+		CreatedAt: time.Now(),
+	}
+	if err := database.CreateAuditEntry(ctx, db, entry); err != nil {
+		groupEvent(log.Warn(), EventAuditWriteFailed, groupID).Err(err).Msg("CreateAuditEntry failed")
+	}
+}
+
+// filterPairsByUsers keeps pairs where both members are in userIDs; an empty list keeps everything
+func filterPairsByUsers(pairs []database.Pair, userIDs []int64) []database.Pair {
+	if len(userIDs) == 0 {
+		return pairs
+	}
+
+	allowed := make(map[int64]bool, len(userIDs))
+	for _, id := range userIDs {
+		allowed[id] = true
+	}
+
+	// A trio with one member filtered out is kept as a pair of the other two
+	filtered := make([]database.Pair, 0)
+	for _, p := range pairs {
+		members := make([]int64, 0, 3)
+		for _, id := range p.Members() {
+			if allowed[id] {
+				members = append(members, id)
+			}
+		}
+		if len(members) < 2 {
+			continue
 		}
-		sendMessage(api, text, message.Chat.ID)
 
-	default:
-		sendMessage(api, "Неизвестная команда. Используй /start для справки.", message.Chat.ID)
+		p.User1ID, p.User2ID, p.User3ID = members[0], members[1], 0
+		if len(members) > 2 {
+			p.User3ID = members[2]
+		}
+		filtered = append(filtered, p)
 	}
+	return filtered
 }
 
 // SendQuiz sends a poll to the group
 func SendQuiz(ctx context.Context, db *sql.DB, api echotron.API, groupID int64) {
 	// Clean up old poll mapping for this group if exists
 	// This handles the case where a new poll is sent before pairs were created
-	if err := database.DeletePollMapping(ctx, db, groupID); err != nil {
-		log.Warn().Err(err).Int64("group_id", groupID).Msg("Failed to delete old poll mapping")
+	if oldMapping, err := database.GetPollMappingByGroupID(ctx, db, groupID); err == nil {
+		finishCountdown(ctx, db, api, oldMapping)
 	}
-
-	question := "Участвуешь в Random Coffee на этой неделе? ☕️"
-	options := []echotron.InputPollOption{
-		{Text: "Да!"},
-		{Text: "Нет"},
-	}
-
-	// Workaround for echotron bug: IsAnonymous=false is ignored because bool false is zero value
-	// We need to explicitly set is_anonymous=false in the request
-	opts := &echotron.PollOptions{
-		AllowsMultipleAnswers: false,
+	if err := database.DeletePollMapping(ctx, db, groupID); err != nil {
+		groupEvent(log.Warn(), EventQuizCleanupFailed, groupID).Err(err).Msg("Failed to delete old poll mapping")
 	}
 
-	result, err := sendPollNonAnonymous(groupID, question, options, opts)
+	kind, pollID, messageID, err := sendSignupMessage(ctx, db, api, groupID)
 	if err != nil {
-		log.Error().Err(err).Int64("group_id", groupID).Msg("SendPoll failed")
+		groupEvent(log.Error(), EventQuizSendFailed, groupID).Err(err).Str("kind", kind).Msg("Sending sign-up message failed")
+		countOps(counterQuizFailed)
 		return
 	}
 
-	if result.Result == nil || result.Result.Poll == nil {
-		log.Error().Int64("group_id", groupID).Msg("Poll result is nil")
-		return
+	// Logged before the mapping so the poll can be recognized even if the mapping insert fails
+	sent := database.SentPoll{
+		PollID:      pollID,
+		GroupID:     groupID,
+		MessageID:   int64(messageID),
+		SentAt:      time.Now(),
+		MemberCount: chatMemberCount(api, groupID),
+	}
+	if err := database.RecordSentPoll(ctx, db, sent); err != nil {
+		groupEvent(log.Warn(), EventQuizLogFailed, groupID).Err(err).Str("poll_id", sent.PollID).Msg("RecordSentPoll failed")
 	}
-
-	messageID := result.Result.ID
 
 	pm := database.PollMapping{
-		PollID:    result.Result.Poll.ID,
+		PollID:    pollID,
 		GroupID:   groupID,
 		MessageID: int64(messageID),
+		Kind:      kind,
 	}
 
 	if err := database.CreatePollMapping(ctx, db, pm); err != nil {
-		log.Error().Err(err).Str("poll_id", pm.PollID).Msg("CreatePollMapping failed")
+		groupEvent(log.Error(), EventQuizMappingFailed, groupID).Err(err).Str("poll_id", pm.PollID).Msg("CreatePollMapping failed")
 		return
 	}
 
+	// Polls left pinned by failed unpins go first, so they don't pile up above the new one
+	retryUnpinBacklog(ctx, db, api, groupID)
+
 	// Pin the poll message
 	_, err = api.PinChatMessage(groupID, messageID, &echotron.PinMessageOptions{DisableNotification: true})
 	if err != nil {
-		log.Warn().Err(err).Int64("group_id", groupID).Int("message_id", messageID).Msg("PinChatMessage failed (check bot permissions)")
+		groupEvent(log.Warn(), EventQuizPinFailed, groupID).Err(err).Int("message_id", messageID).Msg("PinChatMessage failed (check bot permissions)")
+		countOps(counterPinFailed)
 		// Don't return - poll was sent successfully
 	}
 
-	log.Info().Str("poll_id", pm.PollID).Int64("group_id", groupID).Int("message_id", messageID).Msg("Quiz sent and pinned successfully")
+	if isCountdownEnabled(ctx, db, groupID) {
+		startCountdown(ctx, db, api, groupID, pm.PollID, messageID)
+	}
+	scheduleSlowStartCheck(ctx, db, groupID, pm.PollID, messageID)
+
+	groupEvent(log.Info(), EventQuizSent, groupID).Str("poll_id", pm.PollID).Str("kind", kind).Int("message_id", messageID).Msg("Quiz sent and pinned successfully")
 }
 
-// filterUniquePairs selects pairs where each participant appears only once
-func filterUniquePairs(availablePairs [][2]database.Participant) ([][2]database.Participant, map[int64]bool) {
-	usedUsers := make(map[int64]bool)
-	finalPairs := make([][2]database.Participant, 0)
+// matchPairs runs pairing.Match over the participants: first-timer/volunteer pairs are tried first, and
+// repeatPairs (ordered by previous meeting, oldest first) fill in when history rules out a full matching.
+// It also reports whether any meeting repeats a previous one.
+func matchPairs(participants []database.Participant, availablePairs, repeatPairs [][2]database.Participant,
+	cohort buddyCohort, rng *rand.Rand) ([][]database.Participant, bool) {
+
+	byID := make(map[int64]database.Participant, len(participants))
+	ids := make([]int64, 0, len(participants))
+	for _, p := range participants {
+		byID[p.UserID] = p
+		ids = append(ids, p.UserID)
+	}
 
-	for _, pair := range availablePairs {
-		p1, p2 := pair[0], pair[1]
-		if !usedUsers[p1.UserID] && !usedUsers[p2.UserID] {
-			finalPairs = append(finalPairs, pair)
-			usedUsers[p1.UserID] = true
-			usedUsers[p2.UserID] = true
+	matched, repeated := pairing.Match(ids, participantCouples(availablePairs), participantCouples(repeatPairs), cohort.isBuddy, rng)
+	meetings := make([][]database.Participant, 0, len(matched))
+	for _, m := range matched {
+		meeting := make([]database.Participant, 0, len(m))
+		for _, id := range m {
+			meeting = append(meeting, byID[id])
 		}
+		meetings = append(meetings, meeting)
+	}
+	return meetings, repeated
+}
+
+// participantCouples turns pairs of participants into couples of user IDs
+func participantCouples(pairs [][2]database.Participant) [][2]int64 {
+	couples := make([][2]int64, 0, len(pairs))
+	for _, pair := range pairs {
+		couples = append(couples, [2]int64{pair[0].UserID, pair[1].UserID})
 	}
-	return finalPairs, usedUsers
+	return couples
 }
 
-// savePairsToDatabase saves pairs to database for current week
-func savePairsToDatabase(ctx context.Context, db *sql.DB, finalPairs [][2]database.Participant, groupID int64) error {
+// savePairsToDatabase saves pairs to database for current week; a trio is stored in one row with user3_id
+func savePairsToDatabase(ctx context.Context, db *sql.DB, finalPairs [][]database.Participant, groupID int64) error {
+	return database.CreatePairs(ctx, db, pairRows(finalPairs, groupID))
+}
+
+// pairRows turns pairs and trios of the current week into rows of the pair table
+func pairRows(finalPairs [][]database.Participant, groupID int64) []database.Pair {
 	weekStart := getWeekStart(time.Now())
 	pairs := make([]database.Pair, 0, len(finalPairs))
 
 	for _, fp := range finalPairs {
-		pairs = append(pairs, database.Pair{
+		pair := database.Pair{
 			ID:        uuid.New(),
 			GroupID:   groupID,
 			WeekStart: weekStart,
 			User1ID:   fp[0].UserID,
 			User2ID:   fp[1].UserID,
 			CreatedAt: time.Now(),
-		})
-	}
-
-	return database.CreatePairs(ctx, db, pairs)
-}
-
-// buildPairsMessage creates formatted message with pairs list
-func buildPairsMessage(finalPairs [][2]database.Participant) string {
-	message := "🎉 Пары Random Coffee на эту неделю ☕️\n\n"
-	for _, pair := range finalPairs {
-		p1, p2 := pair[0], pair[1]
-		message += fmt.Sprintf("▫️ %s ✖️ %s\n\n", getDisplayName(p1), getDisplayName(p2))
+		}
+		if len(fp) > 2 {
+			pair.User3ID = fp[2].UserID
+		}
+		pairs = append(pairs, pair)
 	}
-	message += "💬 Напиши прямо сейчас собеседнику в личку и договорись о месте и времени!"
-	return message
+	return pairs
 }
 
 // appendUnpairedMessage adds list of unpaired participants to message
 func appendUnpairedMessage(ctx context.Context, db *sql.DB, message string, groupID int64, usedUsers map[int64]bool) string {
 	allParticipants, err := database.GetAllParticipants(ctx, db, groupID)
 	if err != nil {
-		log.Error().Err(err).Int64("group_id", groupID).Msg("GetAllParticipants failed")
+		groupEvent(log.Error(), EventPairsQueryFailed, groupID).Err(err).Msg("GetAllParticipants failed")
 		return message
 	}
 
@@ -393,88 +862,188 @@ func appendUnpairedMessage(ctx context.Context, db *sql.DB, message string, grou
 
 // CreatePairs generates random pairs
 func CreatePairs(ctx context.Context, db *sql.DB, api echotron.API, groupID int64) {
-	availablePairs, err := database.GetAvailablePairs(ctx, db, groupID)
+	// A week gets one set of pairs, whether matched here or composed by an admin with /manual_pairs
+	if exists, err := database.HasPairsSince(ctx, db, groupID, weekStartTime(time.Now())); err != nil {
+		groupEvent(log.Error(), EventPairsQueryFailed, groupID).Err(err).Msg("HasPairsSince failed")
+		sendMessage(api, "❌ Ошибка при получении доступных пар", groupID)
+		countOps(counterRunFailed)
+		return
+	} else if exists {
+		cycleEvent(log.Info(), EventPairsAlreadyCreated, groupID, getWeekStart(time.Now())).Msg("Pairs already exist this week, run skipped")
+		sendMessage(api, "ℹ️ Пары на эту неделю уже составлены", groupID)
+		return
+	}
+
+	// Votes end here, so the sign-ups read below are the ones the poll is reconciled against
+	pollMapping, stoppedPoll := stopSignups(ctx, db, api, groupID)
+	// Answers parked while the database was locked count for this week
+	redeliverParkedSignups(ctx, db, groupID)
+
+	availablePairs, err := database.GetAvailablePairs(ctx, db, groupID, getWeekStart(time.Now()))
 	if err != nil {
-		log.Error().Err(err).Int64("group_id", groupID).Msg("GetAvailablePairs failed")
+		groupEvent(log.Error(), EventPairsQueryFailed, groupID).Err(err).Msg("GetAvailablePairs failed")
 		sendMessage(api, "❌ Ошибка при получении доступных пар", groupID)
+		countOps(counterRunFailed)
 		return
 	}
 
-	if len(availablePairs) == 0 {
-		sendMessage(api, "❌ Недостаточно участников или нет уникальных пар", groupID)
+	participants, err := database.GetAllParticipants(ctx, db, groupID)
+	if err != nil {
+		groupEvent(log.Error(), EventPairsQueryFailed, groupID).Err(err).Msg("GetAllParticipants failed")
+		sendMessage(api, "❌ Ошибка при получении доступных пар", groupID)
+		countOps(counterRunFailed)
+		return
+	}
+
+	if stoppedPoll != nil {
+		reconcileClosedPoll(ctx, db, api, pollMapping, stoppedPoll, len(participants))
+	}
+
+	if len(participants) < 2 {
+		sendMessage(api, "❌ Недостаточно участников", groupID)
+		countOps(counterRunTooFew)
 		return
 	}
+	signedUp := len(participants)
+
+	// Once everyone has met everyone, the weekly coffee goes on with the oldest pairs repeated
+	if len(availablePairs) == 0 {
+		groupEvent(log.Info(), EventPairsHistoryExhausted, groupID).Int("participants", len(participants)).Msg("No unique pairs left, allowing repeats")
+	}
+
+	// Users already matched this week in another group are handled per OVERLAP_POLICY
+	overlaps := findOverlaps(ctx, db, groupID, participants)
+	var skipped []database.Participant
+	if loadOverlapPolicy() == overlapSkip {
+		participants, skipped = splitOverlapping(participants, overlaps)
+	}
+
+	// Without cohort data pairs are still created, just without the newcomer preference
+	cohort, err := loadBuddyCohort(ctx, db, groupID, participants)
+	if err != nil {
+		groupEvent(log.Warn(), EventBuddyCohortFailed, groupID).Err(err).Msg("loadBuddyCohort failed")
+	}
+
+	// On failure pairs are still matched, only without the repeat fallback
+	repeatPairs, err := database.GetAvailablePairsAllowingRepeats(ctx, db, groupID, getWeekStart(time.Now()))
+	if err != nil {
+		groupEvent(log.Warn(), EventPairsQueryFailed, groupID).Err(err).Msg("GetAvailablePairsAllowingRepeats failed")
+	}
+
+	// Everyone the history leaves out of the candidates has met before; checked again after post-processing
+	met := metCouples(participants, availablePairs)
+
+	// Couples someone privately asked to keep apart are never candidates, not even as repeats
+	avoided := loadAvoidedCouples(ctx, db, groupID, participants)
+	availablePairs = avoided.filter(availablePairs)
+	repeatPairs = avoided.filter(repeatPairs)
+
+	// Listed exclusions are kept out of matching as well, leaving the processors only trios and leftovers to repair
+	listed := listedExclusions(postProcessors)
+	availablePairs = listed.filter(availablePairs)
+	repeatPairs = listed.filter(repeatPairs)
 
-	finalPairs, usedUsers := filterUniquePairs(availablePairs)
+	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+	finalPairs, repeated := matchPairs(participants, availablePairs, repeatPairs, cohort, rng)
+
+	finalPairs, usedUsers, repeated, err := postProcessPairs(ctx, groupID, participants, finalPairs, met, repeated, avoided.processors()...)
+	var qualityErr *pairing.QualityError
+	if errors.As(err, &qualityErr) {
+		abortPairingRun(ctx, db, api, groupID, participants, qualityErr)
+		countOps(counterRunRejected)
+		return
+	}
+	if err != nil {
+		cycleEvent(log.Error(), EventPairsPostProcessFailed, groupID, getWeekStart(time.Now())).Err(err).Msg("Post-processing failed, nothing saved")
+		sendMessage(api, "❌ Не удалось создать пары", groupID)
+		countOps(counterRunFailed)
+		return
+	}
 	if len(finalPairs) == 0 {
-		sendMessage(api, "❌ Не удалось создать уникальные пары", groupID)
+		sendMessage(api, "❌ Не удалось создать пары", groupID)
+		countOps(counterRunFailed)
 		return
 	}
 
 	if err = savePairsToDatabase(ctx, db, finalPairs, groupID); err != nil {
-		log.Error().Err(err).Int64("group_id", groupID).Msg("CreatePairs failed")
+		cycleEvent(log.Error(), EventPairsSaveFailed, groupID, getWeekStart(time.Now())).Err(err).Msg("CreatePairs failed")
 		sendMessage(api, "❌ Ошибка при сохранении пар", groupID)
+		countOps(counterRunFailed)
 		return
 	}
 
-	message := buildPairsMessage(finalPairs)
-	message = appendUnpairedMessage(ctx, db, message, groupID, usedUsers)
+	finishExpiredExperiment(ctx, db, api)
+	announcement := assignAnnouncement(ctx, db, groupID, getWeekStart(time.Now()))
+	funnel := recordCycleFunnel(ctx, db, groupID, signedUp, finalPairs, announcement)
 
-	// Check message length (Telegram limit is 4096 characters)
-	if len(message) > 4000 {
-		// Truncate at a reasonable boundary
-		message = message[:4000] + "\n\n...(обрезано)"
+	// Skipped users are listed separately, not as unpaired
+	for _, p := range skipped {
+		usedUsers[p.UserID] = true
 	}
 
-	sendMessage(api, message, groupID)
+	theme := groupCycleTheme(ctx, db, groupID)
+	message := formatPairsAnnouncement(announcement.template, theme, finalPairs)
+	if repeated {
+		message += "\n\n🔁 Новых сочетаний на всех не хватило, поэтому часть собеседников уже встречалась - " +
+			"подобраны те, кто не виделся дольше всего"
+	}
+	message = appendOverlapMessage(message, finalPairs, skipped, overlaps)
+	message = appendUnpairedMessage(ctx, db, message, groupID, usedUsers)
+	message += dmCallToAction(groupDMPolicy(ctx, db, groupID))
 
-	// Unpin the poll message
-	pollMapping, err := database.GetPollMappingByGroupID(ctx, db, groupID)
-	if err != nil {
-		log.Warn().Err(err).Int64("group_id", groupID).Msg("GetPollMappingByGroupID failed (no active poll)")
-	} else if pollMapping != nil {
-		_, err = api.UnpinChatMessage(groupID, &echotron.UnpinMessageOptions{MessageID: int(pollMapping.MessageID)})
-		if err != nil {
-			log.Warn().Err(err).Int64("group_id", groupID).Int64("message_id", pollMapping.MessageID).Msg("UnpinChatMessage failed (check bot permissions)")
-		} else {
-			log.Info().Int64("group_id", groupID).Int64("message_id", pollMapping.MessageID).Msg("Poll message unpinned")
-		}
+	// A large group's announcement continues in further messages, split between pairs
+	sendAnnouncementMedia(ctx, db, api, groupID)
+	sendLongMessage(api, message, groupID)
+	notifyBuddies(ctx, db, api, groupID, finalPairs, cohort, theme)
+	notifyOverlaps(ctx, db, api, groupID, finalPairs, skipped, overlaps)
 
-		// Delete poll mapping after attempting to unpin (even if unpin failed)
-		if err := database.DeletePollMapping(ctx, db, groupID); err != nil {
-			log.Warn().Err(err).Int64("group_id", groupID).Msg("DeletePollMapping failed")
-		}
-	}
+	// Everyone who signed up, skipped ones included: the sign-ups are cleared below
+	signedUpParticipants := append(participants, skipped...)
+	publishPairingSnapshot(groupID, signedUpParticipants, finalPairs, funnel, theme)
+
+	closeSignups(ctx, db, api, groupID, pollMapping)
+	clearCycleTheme(ctx, db, groupID, theme)
 
-	if err = database.ClearAllParticipants(ctx, db, groupID); err != nil {
-		log.Error().Err(err).Int64("group_id", groupID).Msg("ClearAllParticipants failed")
+	// Everyone who signed up now has a partner or sits this week out
+	for _, p := range signedUpParticipants {
+		dashboards.schedule(p.UserID)
 	}
 
-	log.Info().Int64("group_id", groupID).Int("pairs_count", len(finalPairs)).Msg("Pairs created successfully")
+	cycleEvent(log.Info(), EventPairsCreated, groupID, getWeekStart(time.Now())).Int("pairs_count", len(finalPairs)).Msg("Pairs created successfully")
 }
 
-func SendQuizToAllGroups(ctx context.Context, db *sql.DB, api echotron.API) {
-	groups := getConfiguredGroups()
-	if len(groups) == 0 {
-		log.Warn().Msg("No groups configured in GROUP_CHAT_IDS")
-		return
+// closeSignups ends the week's sign-up once pairs are out: the poll stopped by stopSignups is unpinned,
+// pending nudges dropped and the sign-ups cleared for the next cycle. pm is nil without an open poll.
+func closeSignups(ctx context.Context, db *sql.DB, api echotron.API, groupID int64, pm *database.PollMapping) {
+	if pm != nil {
+		unpinPoll(ctx, db, api, groupID, pm.MessageID)
+
+		finishCountdown(ctx, db, api, pm)
+
+		// Delete poll mapping after attempting to unpin (even if unpin failed)
+		if err := database.DeletePollMapping(ctx, db, groupID); err != nil {
+			groupEvent(log.Warn(), EventPairsCleanupFailed, groupID).Err(err).Msg("DeletePollMapping failed")
+		}
+	}
+	// A nudge about a poll that is already closed would only confuse the group
+	if err := database.DeleteGroupSlowStartChecks(ctx, db, groupID); err != nil {
+		groupEvent(log.Warn(), EventPairsCleanupFailed, groupID).Err(err).Msg("DeleteGroupSlowStartChecks failed")
 	}
 
-	log.Info().Int("groups_count", len(groups)).Msg("Sending quiz to all groups")
-	for _, groupID := range groups {
-		SendQuiz(ctx, db, api, groupID)
+	if err := database.ClearAllParticipants(ctx, db, groupID); err != nil {
+		groupEvent(log.Error(), EventPairsCleanupFailed, groupID).Err(err).Msg("ClearAllParticipants failed")
 	}
 }
 
-func CreatePairsForAllGroups(ctx context.Context, db *sql.DB, api echotron.API) {
-	groups := getConfiguredGroups()
-	if len(groups) == 0 {
-		log.Warn().Msg("No groups configured in GROUP_CHAT_IDS")
-		return
-	}
+// runScheduledJob runs a scheduled job for a group, skipping it if an admin already started the same job manually.
+// The skip is reported to admins rather than queued: run again after the manual one, the job would send
+// a second poll or pair the group twice. A panic in the job is recovered so the group's loop survives it.
+func runScheduledJob(api echotron.API, job string, groupID int64, fn func()) {
+	defer recoverJobPanic(job, groupID)
 
-	log.Info().Int("groups_count", len(groups)).Msg("Creating pairs for all groups")
-	for _, groupID := range groups {
-		CreatePairs(ctx, db, api, groupID)
+	if startedAt, ok := runGuarded(job, groupID, fn); !ok {
+		groupEvent(log.Warn(), EventJobBusy, groupID).Str("job", job).Time("started_at", startedAt).Msg("Job already running, scheduled run skipped")
+		notifyAdmins(api, fmt.Sprintf("⚠️ Плановый запуск %s в группе %d пропущен: эта задача уже выполняется, начата вручную %d секунд назад",
+			job, groupID, int(time.Since(startedAt).Seconds())))
 	}
 }
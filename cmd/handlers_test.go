@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"example.com/random_coffee/database"
+)
+
+// checkChunks fails unless every chunk fits the limit and the chunks, in order, are the text with only
+// the line breaks at the cuts left out
+func checkChunks(t *testing.T, text string, chunks []string, limit int) {
+	t.Helper()
+	rest := text
+	for i, chunk := range chunks {
+		if n := utf16Len(chunk); n > limit {
+			t.Fatalf("chunk %d is %d long, limit %d", i, n, limit)
+		}
+		rest = strings.TrimLeft(rest, "\n")
+		if !strings.HasPrefix(rest, chunk) {
+			t.Fatalf("chunk %d does not continue the text:\n%q", i, chunk)
+		}
+		rest = rest[len(chunk):]
+	}
+	if strings.Trim(rest, "\n") != "" {
+		t.Fatalf("text after the last chunk is lost: %q", rest)
+	}
+}
+
+func TestSplitLongAnnouncement(t *testing.T) {
+	// 200 pairs of long names with emoji and right-to-left letters, far beyond one message
+	pairs := make([][]database.Participant, 0, 200)
+	var lines []string
+	for i := 0; i < 200; i++ {
+		a := database.Participant{UserID: int64(2*i + 1), FullName: fmt.Sprintf("Участник %d %s с очень длинным именем", i, family)}
+		b := database.Participant{UserID: int64(2*i + 2), FullName: fmt.Sprintf("مشارك %d", i)}
+		pairs = append(pairs, []database.Participant{a, b})
+		lines = append(lines, fmt.Sprintf("▫️ %s ✖️ %s", getDisplayName(a), getDisplayName(b)))
+	}
+	text := formatPairsAnnouncement(defaultAnnouncementTemplate, "", pairs)
+
+	chunks := splitMessage(text, telegramMessageLimit)
+	if len(chunks) < 3 {
+		t.Fatalf("%d characters sent in %d messages", utf16Len(text), len(chunks))
+	}
+	checkChunks(t, text, chunks, telegramMessageLimit)
+
+	// Every pair line is whole in exactly one message
+	for _, line := range lines {
+		found := 0
+		for _, chunk := range chunks {
+			for _, l := range strings.Split(chunk, "\n") {
+				if l == line {
+					found++
+				}
+			}
+		}
+		if found != 1 {
+			t.Fatalf("pair line %q found whole in %d messages", line, found)
+		}
+	}
+}
+
+func TestSplitMessageCutsLongLinesBetweenCharacters(t *testing.T) {
+	tests := []struct {
+		name  string
+		text  string
+		limit int
+	}{
+		{"short", "one\ntwo", 100},
+		{"exact", "abcd\nefgh", 5},
+		{"blank lines at the cut", "abcd\n\n\n\nefgh\n", 6},
+		{"long line", strings.Repeat("я", 25), 10},
+		{"long emoji line", strings.Repeat(family, 7), 20},
+		{"long line between short ones", "a\n" + strings.Repeat(flagRU, 9) + "\nb", 8},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chunks := splitMessage(tt.text, tt.limit)
+			checkChunks(t, tt.text, chunks, tt.limit)
+			for _, chunk := range chunks {
+				if chunk == "" || strings.HasPrefix(chunk, "\u200d") || strings.HasSuffix(chunk, "\u200d") || strings.HasPrefix(chunk, "\U0001f1fa") {
+					t.Fatalf("chunk %q is empty or splits an emoji", chunk)
+				}
+			}
+		})
+	}
+}
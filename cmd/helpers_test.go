@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"example.com/random_coffee/database"
+	"github.com/NicoNex/echotron/v3"
+	"github.com/pressly/goose/v3"
+	"github.com/rs/zerolog"
+	_ "modernc.org/sqlite"
+)
+
+func TestMain(m *testing.M) {
+	zerolog.SetGlobalLevel(zerolog.Disabled)
+	// The fake API answers at once; Telegram's limits would only slow the tests down
+	echotron.SetGlobalRequestLimit(0)
+	echotron.SetChatRequestLimit(0)
+	goose.SetLogger(goose.NopLogger())
+	os.Exit(m.Run())
+}
+
+// openTestDB returns a migrated database in a temporary file
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "bot.db"))
+	if err != nil {
+		t.Fatalf("sql.Open: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if err := database.Migrate(db, "../migrations"); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+	return db
+}
+
+// seedTestGroup registers an active group with a title
+func seedTestGroup(t *testing.T, db *sql.DB, groupID int64, title string) {
+	t.Helper()
+	if err := database.CreateGroup(context.Background(), db, database.Group{GroupID: groupID, Title: title, Active: true}); err != nil {
+		t.Fatalf("CreateGroup: %v", err)
+	}
+}
+
+// withAdmins makes the given users admins for the test
+func withAdmins(t *testing.T, ids ...int64) {
+	t.Helper()
+	saved := adminChatIDsMap
+	adminChatIDsMap = make(map[int64]bool)
+	for _, id := range ids {
+		adminChatIDsMap[id] = true
+	}
+	t.Cleanup(func() { adminChatIDsMap = saved })
+}
+
+// withFakeTicker makes the worker started next with *newTicker tick when the returned function is called.
+// Every tick is sent twice, so the call returns only after the first one was handled: a worker does nothing
+// new when the time repeats.
+func withFakeTicker(t *testing.T, newTicker *func() (<-chan time.Time, func())) func(at time.Time) {
+	t.Helper()
+	ticks := make(chan time.Time)
+	saved := *newTicker
+	*newTicker = func() (<-chan time.Time, func()) { return ticks, func() {} }
+	t.Cleanup(func() { *newTicker = saved })
+	return func(at time.Time) {
+		ticks <- at
+		ticks <- at
+	}
+}
+
+// fakeCall is one request the bot made to the fake Telegram API
+type fakeCall struct {
+	method string
+	params url.Values
+}
+
+// fakeTelegram is a Telegram Bot API stand-in that records every call. Methods answer with a
+// message or true unless a test sets its own reply.
+type fakeTelegram struct {
+	url     string // the server's base URL
+	mu      sync.Mutex
+	calls   []fakeCall
+	members map[[2]int64]string // {chat, user} -> getChatMember status
+	replies map[string]func(url.Values) string
+}
+
+// newFakeTelegram starts the fake API and returns a client talking to it
+func newFakeTelegram(t *testing.T) (*fakeTelegram, echotron.API) {
+	t.Helper()
+	f := &fakeTelegram{members: make(map[[2]int64]string), replies: make(map[string]func(url.Values) string)}
+	server := httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(server.Close)
+	f.url = server.URL + "/"
+	return f, echotron.NewLocalAPI(f.url, "test")
+}
+
+func (f *fakeTelegram) serve(w http.ResponseWriter, r *http.Request) {
+	method := path.Base(r.URL.Path)
+	params := r.URL.Query()
+	if r.Method == http.MethodPost {
+		if err := r.ParseMultipartForm(1 << 20); err == nil {
+			for k, v := range r.MultipartForm.Value {
+				params[k] = v
+			}
+			// Uploaded files are recorded with their contents
+			for k, files := range r.MultipartForm.File {
+				for _, fh := range files {
+					if file, err := fh.Open(); err == nil {
+						data, _ := io.ReadAll(file)
+						file.Close()
+						params.Add(k, string(data))
+					}
+				}
+			}
+		} else if err := r.ParseForm(); err == nil {
+			// sendPollNonAnonymous posts a plain form
+			for k, v := range r.PostForm {
+				params[k] = v
+			}
+		}
+	}
+
+	f.mu.Lock()
+	f.calls = append(f.calls, fakeCall{method: method, params: params})
+	reply, custom := f.replies[method]
+	f.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	if custom {
+		fmt.Fprint(w, reply(params))
+		return
+	}
+	fmt.Fprint(w, f.defaultReply(method, params))
+}
+
+func (f *fakeTelegram) defaultReply(method string, params url.Values) string {
+	chatID, _ := strconv.ParseInt(params.Get("chat_id"), 10, 64)
+	switch {
+	case method == "getChatMember":
+		userID, _ := strconv.ParseInt(params.Get("user_id"), 10, 64)
+		f.mu.Lock()
+		status, ok := f.members[[2]int64{chatID, userID}]
+		f.mu.Unlock()
+		if !ok {
+			status = "left"
+		}
+		return fmt.Sprintf(`{"ok":true,"result":{"status":%q,"user":{"id":%d,"first_name":"User%d","username":"user%d"}}}`,
+			status, userID, userID, userID)
+	case strings.HasPrefix(method, "send"), strings.HasPrefix(method, "edit"):
+		return fmt.Sprintf(`{"ok":true,"result":{"message_id":1,"date":0,"chat":{"id":%d,"type":"private"}}}`, chatID)
+	default:
+		return `{"ok":true,"result":true}`
+	}
+}
+
+// setMember makes the user a member of the chat with the given status
+func (f *fakeTelegram) setMember(chatID, userID int64, status string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.members[[2]int64{chatID, userID}] = status
+}
+
+// reply makes the method answer with the given JSON body
+func (f *fakeTelegram) reply(method string, body func(url.Values) string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.replies[method] = body
+}
+
+// sent returns the texts of the messages sent to the chat
+func (f *fakeTelegram) sent(chatID int64) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var texts []string
+	for _, c := range f.calls {
+		if c.method == "sendMessage" && c.params.Get("chat_id") == strconv.FormatInt(chatID, 10) {
+			texts = append(texts, c.params.Get("text"))
+		}
+	}
+	return texts
+}
+
+// count returns how many times the method was called
+func (f *fakeTelegram) count(method string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := 0
+	for _, c := range f.calls {
+		if c.method == method {
+			n++
+		}
+	}
+	return n
+}
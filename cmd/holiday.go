@@ -0,0 +1,401 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"example.com/random_coffee/database"
+	"github.com/NicoNex/echotron/v3"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// holidayCalendarSetting is the group setting with the built-in holiday list the group follows, "ru" or "kz"
+	holidayCalendarSetting = "holiday_calendar"
+
+	// holidayGroupNoticeSetting is "on" when a skipped cycle is also announced in the group, not only to its owner
+	holidayGroupNoticeSetting = "holiday_group_notice"
+
+	// holidaySkipNotifiedSetting holds the quiz date of the last skipped cycle the owner was told about,
+	// so the quiz and pairing steps of one cycle send a single notice
+	holidaySkipNotifiedSetting = "holiday_skip_notified"
+
+	dateLayout = "2006-01-02"
+)
+
+// annualHoliday is a range of days off repeating every year, as MM-DD; from after to means it crosses New Year
+type annualHoliday struct {
+	from, to string
+	name     string
+}
+
+// contains reports whether the MM-DD day falls inside the range
+func (h annualHoliday) contains(day string) bool {
+	if h.from <= h.to {
+		return h.from <= day && day <= h.to
+	}
+	return day >= h.from || day <= h.to
+}
+
+// builtinHolidayCalendars are the fixed-date public holidays shipped with the bot. Holidays on lunar or
+// yearly decreed dates (Kurban Ait, moved days off) are not here: add them with /add_holiday.
+var builtinHolidayCalendars = map[string][]annualHoliday{
+	"ru": {
+		{"12-31", "01-08", "Новогодние каникулы"},
+		{"02-23", "02-23", "День защитника Отечества"},
+		{"03-08", "03-08", "Международный женский день"},
+		{"05-01", "05-01", "Праздник Весны и Труда"},
+		{"05-09", "05-09", "День Победы"},
+		{"06-12", "06-12", "День России"},
+		{"11-04", "11-04", "День народного единства"},
+	},
+	"kz": {
+		{"01-01", "01-02", "Новый год"},
+		{"01-07", "01-07", "Православное Рождество"},
+		{"03-08", "03-08", "Международный женский день"},
+		{"03-21", "03-23", "Наурыз мейрамы"},
+		{"05-01", "05-01", "Праздник единства народа Казахстана"},
+		{"05-07", "05-07", "День защитника Отечества"},
+		{"05-09", "05-09", "День Победы"},
+		{"07-06", "07-06", "День столицы"},
+		{"08-30", "08-30", "День Конституции"},
+		{"10-25", "10-25", "День Республики"},
+		{"12-16", "12-16", "День Независимости"},
+	},
+}
+
+// holidayCalendar is everything a group takes days off for: a built-in list and its own ranges
+type holidayCalendar struct {
+	builtin string
+	custom  []database.Holiday
+}
+
+// holidayOn returns why the day is a holiday, if it is. Overlapping ranges simply all match; the first one names it.
+func (c holidayCalendar) holidayOn(day time.Time) (string, bool) {
+	for _, h := range builtinHolidayCalendars[c.builtin] {
+		if h.contains(day.Format("01-02")) {
+			return h.name, true
+		}
+	}
+	date := day.Format(dateLayout)
+	for _, h := range c.custom {
+		// Full dates compare correctly as strings, so ranges spanning New Year need nothing special
+		if h.StartDate <= date && date <= h.EndDate {
+			return formatHolidayRange(h.StartDate, h.EndDate), true
+		}
+	}
+	return "", false
+}
+
+// loadHolidayCalendar reads the group's calendar with its custom ranges still relevant from the given day on.
+// On errors the parts that could be read are returned, so a broken read never skips a cycle by itself.
+func loadHolidayCalendar(ctx context.Context, db *sql.DB, groupID int64, from time.Time) holidayCalendar {
+	var c holidayCalendar
+
+	builtin, _, err := database.GetGroupSetting(ctx, db, groupID, holidayCalendarSetting)
+	if err != nil {
+		groupEvent(log.Warn(), EventSettingsReadFailed, groupID).Err(err).Str("key", holidayCalendarSetting).Msg("GetGroupSetting failed")
+	}
+	c.builtin = builtin
+
+	c.custom, err = database.GetHolidays(ctx, db, groupID, from.Format(dateLayout))
+	if err != nil {
+		groupEvent(log.Error(), EventHolidayFailed, groupID).Err(err).Msg("GetHolidays failed, custom holidays ignored")
+	}
+	return c
+}
+
+// holidaySkip is a cycle the scheduler won't run because its quiz or pairing falls on a holiday
+type holidaySkip struct {
+	quizAt  time.Time
+	pairsAt time.Time
+	day     time.Time // the holiday the cycle hits
+	reason  string
+}
+
+// cycleTimes returns the quiz and pairing times of the cycle the job at the given time belongs to:
+// a quiz with the pairing after it, or a pairing with the quiz before it
+func cycleTimes(sched groupSchedule, job string, at time.Time) (time.Time, time.Time) {
+	if job == jobSendQuiz {
+		return at, sched.pairs.next(at, sched.location)
+	}
+	return sched.quiz.next(at.AddDate(0, 0, -7), sched.location), at
+}
+
+// decideHolidaySkip skips the whole cycle when either of its steps falls on a holiday: a quiz without
+// the pairing would collect sign-ups for nothing, and a pairing without the quiz has nobody to pair
+func decideHolidaySkip(c holidayCalendar, quizAt, pairsAt time.Time) *holidaySkip {
+	for _, at := range []time.Time{quizAt, pairsAt} {
+		if reason, ok := c.holidayOn(at); ok {
+			return &holidaySkip{quizAt: quizAt, pairsAt: pairsAt, day: at, reason: reason}
+		}
+	}
+	return nil
+}
+
+// checkHolidaySkip tells whether the group's job at the given time is skipped for a holiday
+func checkHolidaySkip(ctx context.Context, db *sql.DB, groupID int64, sched groupSchedule, job string, at time.Time) *holidaySkip {
+	quizAt, pairsAt := cycleTimes(sched, job, at)
+	return decideHolidaySkip(loadHolidayCalendar(ctx, db, groupID, quizAt), quizAt, pairsAt)
+}
+
+func (s *holidaySkip) String() string {
+	return fmt.Sprintf("%s (%s)", s.day.Format("02.01.2006"), s.reason)
+}
+
+// skipHolidayCycle is run instead of a job that falls into a skipped cycle, closing the sign-up at the
+// pairing step. The owner is told once per cycle, and the group too if it asked for it with /holidays notify on.
+func skipHolidayCycle(ctx context.Context, db *sql.DB, api echotron.API, groupID int64, job string, skip *holidaySkip) {
+	groupEvent(log.Info(), EventHolidaySkipped, groupID).Str("job", job).Str("holiday", skip.reason).
+		Time("day", skip.day).Msg("Cycle skipped for a holiday")
+	countOps(counterHolidaySkipped)
+
+	// A holiday added after the quiz went out: its poll and countdown are closed and the sign-ups
+	// dropped, so they don't carry over into the next cycle
+	if job == jobCreatePairs {
+		pm, _ := stopSignups(ctx, db, api, groupID)
+		closeSignups(ctx, db, api, groupID, pm)
+	}
+
+	cycle := skip.quizAt.Format(dateLayout)
+	notified, _, err := database.GetGroupSetting(ctx, db, groupID, holidaySkipNotifiedSetting)
+	if err != nil {
+		groupEvent(log.Warn(), EventSettingsReadFailed, groupID).Err(err).Str("key", holidaySkipNotifiedSetting).Msg("GetGroupSetting failed")
+	}
+	if notified == cycle {
+		return
+	}
+	if err := database.SetGroupSetting(ctx, db, groupID, holidaySkipNotifiedSetting, cycle); err != nil {
+		groupEvent(log.Warn(), EventSettingsSaveFailed, groupID).Err(err).Str("key", holidaySkipNotifiedSetting).Msg("SetGroupSetting failed")
+	}
+
+	text := fmt.Sprintf("🏖 В группе %s Random Coffee на этой неделе не проводится: %s. Опрос и пары возобновятся "+
+		"по расписанию после праздников. Календарь группы - /holidays", groupTitle(ctx, db, groupID), skip)
+	if ownerID := groupOwnerID(api, groupID); ownerID != 0 {
+		_, err := api.SendMessage(text, ownerID, nil)
+		tokenWatcher.sendDone(err)
+		if err != nil {
+			groupEvent(log.Warn(), EventHolidayFailed, groupID).Err(err).Int64("owner_id", ownerID).Msg("Failed to notify owner about a skipped cycle")
+		}
+	}
+
+	if value, _, _ := database.GetGroupSetting(ctx, db, groupID, holidayGroupNoticeSetting); value == "on" {
+		sendMessage(api, fmt.Sprintf("🏖 На этой неделе Random Coffee отдыхает: %s", skip), groupID)
+	}
+}
+
+// formatHolidayRange renders a custom range as it is typed in /add_holiday
+func formatHolidayRange(start, end string) string {
+	if start == end {
+		return start
+	}
+	return start + ".." + end
+}
+
+// parseHolidayRange reads "YYYY-MM-DD..YYYY-MM-DD" or a single date
+func parseHolidayRange(arg string) (string, string, error) {
+	startStr, endStr, found := strings.Cut(arg, "..")
+	if !found {
+		endStr = startStr
+	}
+	start, err := time.Parse(dateLayout, startStr)
+	if err != nil {
+		return "", "", fmt.Errorf("некорректная дата %q, используй формат ГГГГ-ММ-ДД", startStr)
+	}
+	end, err := time.Parse(dateLayout, endStr)
+	if err != nil {
+		return "", "", fmt.Errorf("некорректная дата %q, используй формат ГГГГ-ММ-ДД", endStr)
+	}
+	if end.Before(start) {
+		return "", "", fmt.Errorf("конец диапазона раньше начала")
+	}
+	if end.Sub(start) > 366*24*time.Hour {
+		return "", "", fmt.Errorf("диапазон длиннее года, для паузы используй /unregister")
+	}
+	return startStr, endStr, nil
+}
+
+// nextHolidaySkip returns the group's next skipped cycle within the coming weeks, nil if none
+func nextHolidaySkip(ctx context.Context, db *sql.DB, groupID int64, sched groupSchedule, now time.Time) *holidaySkip {
+	calendar := loadHolidayCalendar(ctx, db, groupID, now.AddDate(0, 0, -7))
+	from := now
+	for range 8 {
+		job, at := nextJob(sched, from)
+		quizAt, pairsAt := cycleTimes(sched, job, at)
+		if skip := decideHolidaySkip(calendar, quizAt, pairsAt); skip != nil {
+			return skip
+		}
+		from = pairsAt
+	}
+	return nil
+}
+
+// formatHolidayCalendar describes the group's calendar for /holidays
+func formatHolidayCalendar(ctx context.Context, db *sql.DB, groupID int64) string {
+	now := time.Now()
+	calendar := loadHolidayCalendar(ctx, db, groupID, now)
+
+	text := "🏖 Праздничный календарь группы\n"
+	switch calendar.builtin {
+	case "":
+		text += "Встроенный календарь: не выбран\n"
+	default:
+		text += fmt.Sprintf("Встроенный календарь: %s\n", strings.ToUpper(calendar.builtin))
+		for _, h := range builtinHolidayCalendars[calendar.builtin] {
+			text += fmt.Sprintf("• %s - %s\n", formatAnnualDays(h), h.name)
+		}
+	}
+
+	if len(calendar.custom) > 0 {
+		text += "\nСвои даты:\n"
+		for _, h := range calendar.custom {
+			text += "• " + formatHolidayRange(h.StartDate, h.EndDate) + "\n"
+		}
+	}
+
+	if value, _, _ := database.GetGroupSetting(ctx, db, groupID, holidayGroupNoticeSetting); value == "on" {
+		text += "\nО пропуске бот пишет владельцу группы и в группу\n"
+	} else {
+		text += "\nО пропуске бот пишет только владельцу группы\n"
+	}
+
+	if skip := nextHolidaySkip(ctx, db, groupID, loadGroupSchedule(ctx, db, groupID), now); skip != nil {
+		text += fmt.Sprintf("Ближайший пропуск: неделя с опросом %s - %s\n", skip.quizAt.Format("02.01"), skip)
+	}
+	return text
+}
+
+// formatAnnualDays renders an annual range as DD.MM or DD.MM-DD.MM
+func formatAnnualDays(h annualHoliday) string {
+	flip := func(md string) string {
+		month, day, _ := strings.Cut(md, "-")
+		return day + "." + month
+	}
+	if h.from == h.to {
+		return flip(h.from)
+	}
+	return flip(h.from) + "-" + flip(h.to)
+}
+
+const holidaysUsage = "Использование:\n" +
+	"/holidays - календарь группы\n" +
+	"/holidays ru|kz|off - встроенный календарь праздников\n" +
+	"/holidays notify on|off - сообщать о пропуске и в группу\n" +
+	"/add_holiday 2025-01-01..2025-01-08 - свои даты (или одна дата)\n" +
+	"/remove_holiday 2025-01-01..2025-01-08 - удалить свои даты\n\n" +
+	"Если опрос или создание пар выпадают на праздник, бот пропускает всю неделю"
+
+// handleHolidaysCommand implements /holidays [ru|kz|off] and /holidays notify on|off in a group
+func handleHolidaysCommand(ctx context.Context, db *sql.DB, api echotron.API, message *echotron.Message, args []string) {
+	groupID := message.Chat.ID
+
+	var err error
+	switch {
+	case len(args) == 0:
+		sendMessage(api, formatHolidayCalendar(ctx, db, groupID)+"\n"+holidaysUsage, groupID)
+		return
+	case len(args) == 1 && args[0] == "off":
+		err = database.DeleteGroupSetting(ctx, db, groupID, holidayCalendarSetting)
+	case len(args) == 1 && builtinHolidayCalendars[args[0]] != nil:
+		err = database.SetGroupSetting(ctx, db, groupID, holidayCalendarSetting, args[0])
+	case len(args) == 2 && args[0] == "notify" && args[1] == "on":
+		err = database.SetGroupSetting(ctx, db, groupID, holidayGroupNoticeSetting, "on")
+	case len(args) == 2 && args[0] == "notify" && args[1] == "off":
+		err = database.DeleteGroupSetting(ctx, db, groupID, holidayGroupNoticeSetting)
+	default:
+		sendMessage(api, holidaysUsage, groupID)
+		return
+	}
+	if err != nil {
+		groupEvent(log.Error(), EventSettingsSaveFailed, groupID).Err(err).Msg("Failed to save holiday settings")
+		sendMessage(api, "❌ Не удалось сохранить настройку", groupID)
+		return
+	}
+
+	rescheduleGroup(groupID)
+	writeAudit(ctx, db, message.From.ID, "holidays", groupID, strings.Join(args, " "))
+	sendMessage(api, "✅ Сохранено\n\n"+formatHolidayCalendar(ctx, db, groupID), groupID)
+}
+
+// handleHolidayRangeCommand implements /add_holiday and /remove_holiday <from>..<to> in a group
+func handleHolidayRangeCommand(ctx context.Context, db *sql.DB, api echotron.API, message *echotron.Message, args []string, add bool) {
+	groupID := message.Chat.ID
+	if len(args) != 1 {
+		sendMessage(api, holidaysUsage, groupID)
+		return
+	}
+	start, end, err := parseHolidayRange(args[0])
+	if err != nil {
+		sendMessage(api, "❌ "+err.Error(), groupID)
+		return
+	}
+
+	action := "holiday_add"
+	if add {
+		err = database.AddHoliday(ctx, db, database.Holiday{
+			GroupID:   groupID,
+			StartDate: start,
+			EndDate:   end,
+			CreatedBy: message.From.ID,
+			CreatedAt: time.Now(),
+		})
+	} else {
+		action = "holiday_remove"
+		var removed bool
+		removed, err = database.DeleteHoliday(ctx, db, groupID, start, end)
+		if err == nil && !removed {
+			sendMessage(api, "Таких дат в календаре группы нет, см. /holidays", groupID)
+			return
+		}
+	}
+	if err != nil {
+		groupEvent(log.Error(), EventHolidayFailed, groupID).Err(err).Str("action", action).Msg("Failed to change holidays")
+		sendMessage(api, "❌ Не удалось сохранить календарь", groupID)
+		return
+	}
+
+	rescheduleGroup(groupID)
+	writeAudit(ctx, db, message.From.ID, action, groupID, formatHolidayRange(start, end))
+	groupEvent(log.Info(), EventHolidayChanged, groupID).Str("action", action).Str("range", formatHolidayRange(start, end)).Msg("Holidays changed")
+	sendMessage(api, "✅ Календарь обновлен\n\n"+formatHolidayCalendar(ctx, db, groupID), groupID)
+}
+
+// setUpcomingSkip remembers whether the group's next job is skipped, for /status
+func (s *groupScheduler) setUpcomingSkip(groupID int64, skip *holidaySkip) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if skip == nil {
+		delete(s.skips, groupID)
+		return
+	}
+	s.skips[groupID] = *skip
+}
+
+// formatHolidaySkips lists groups whose next job is skipped for a holiday, for /status
+func formatHolidaySkips() string {
+	if scheduler == nil {
+		return ""
+	}
+
+	scheduler.mu.Lock()
+	groupIDs := make([]int64, 0, len(scheduler.skips))
+	for id := range scheduler.skips {
+		groupIDs = append(groupIDs, id)
+	}
+	sort.Slice(groupIDs, func(i, j int) bool { return groupIDs[i] < groupIDs[j] })
+	text := ""
+	for _, id := range groupIDs {
+		skip := scheduler.skips[id]
+		text += fmt.Sprintf("• группа %d: %s\n", id, &skip)
+	}
+	scheduler.mu.Unlock()
+
+	if text == "" {
+		return ""
+	}
+	return "Пропуск недели по праздникам:\n" + text
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"testing"
+	"time"
+
+	"example.com/random_coffee/database"
+	"github.com/NicoNex/echotron/v3"
+)
+
+// moscow returns the time in the default schedule's timezone
+func moscow(t *testing.T, year int, month time.Month, day, hour int) time.Time {
+	t.Helper()
+	location, err := time.LoadLocation(defaultTimezone)
+	if err != nil {
+		t.Fatalf("LoadLocation: %v", err)
+	}
+	return time.Date(year, month, day, hour, 0, 0, 0, location)
+}
+
+// holidayCommand runs /add_holiday or /remove_holiday in the test group and returns the reply
+func holidayCommand(t *testing.T, db *sql.DB, tg *fakeTelegram, api echotron.API, add bool, arg string) string {
+	t.Helper()
+	before := len(tg.sent(testGroupID))
+	handleHolidayRangeCommand(context.Background(), db, api, &echotron.Message{
+		From: &echotron.User{ID: testAdminID},
+		Chat: echotron.Chat{ID: testGroupID, Type: "supergroup"},
+	}, []string{arg}, add)
+	sent := tg.sent(testGroupID)
+	if len(sent) != before+1 {
+		t.Fatalf("%s got %d replies", arg, len(sent)-before)
+	}
+	return sent[before]
+}
+
+func TestBuiltinHolidayCalendars(t *testing.T) {
+	tests := []struct {
+		calendar string
+		day      string
+		want     string // the holiday's name, empty for a working day
+	}{
+		{"ru", "2025-12-31", "Новогодние каникулы"},
+		{"ru", "2026-01-01", "Новогодние каникулы"},
+		{"ru", "2026-01-08", "Новогодние каникулы"},
+		{"ru", "2026-01-09", ""},
+		{"ru", "2026-05-09", "День Победы"},
+		{"ru", "2026-03-22", ""},
+		{"kz", "2026-03-22", "Наурыз мейрамы"},
+		{"kz", "2025-12-31", ""},
+		{"kz", "2026-01-02", "Новый год"},
+		{"", "2026-05-01", ""},
+		{"xx", "2026-05-01", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.calendar+" "+tt.day, func(t *testing.T) {
+			day, _ := time.Parse(dateLayout, tt.day)
+			reason, ok := holidayCalendar{builtin: tt.calendar}.holidayOn(day)
+			if reason != tt.want || ok != (tt.want != "") {
+				t.Fatalf("holidayOn = %q, %v; want %q", reason, ok, tt.want)
+			}
+		})
+	}
+}
+
+func TestCustomHolidayRanges(t *testing.T) {
+	db, tg, api := setupManualPairsGroup(t)
+	ctx := context.Background()
+
+	on := func(day string) bool {
+		at, _ := time.Parse(dateLayout, day)
+		_, ok := loadHolidayCalendar(ctx, db, testGroupID, at).holidayOn(at)
+		return ok
+	}
+
+	// Two overlapping ranges, one spanning New Year, and a single day
+	for _, arg := range []string{"2026-05-04..2026-05-10", "2026-05-08..2026-05-12", "2026-12-30..2027-01-03", "2026-06-01"} {
+		if reply := holidayCommand(t, db, tg, api, true, arg); !strings.Contains(reply, "Календарь обновлен") {
+			t.Fatalf("/add_holiday %s: %q", arg, reply)
+		}
+	}
+	for day, want := range map[string]bool{
+		"2026-05-03": false, "2026-05-04": true, "2026-05-09": true, "2026-05-12": true, "2026-05-13": false,
+		"2026-12-31": true, "2027-01-03": true, "2027-01-04": false, "2026-06-01": true, "2026-06-02": false,
+	} {
+		if on(day) != want {
+			t.Errorf("%s is a holiday: %v, want %v", day, !want, want)
+		}
+	}
+
+	// Removing one of the overlapping ranges leaves the other's days off
+	if reply := holidayCommand(t, db, tg, api, false, "2026-05-04..2026-05-10"); !strings.Contains(reply, "Календарь обновлен") {
+		t.Fatalf("/remove_holiday: %q", reply)
+	}
+	if on("2026-05-05") || !on("2026-05-09") {
+		t.Fatalf("after removal 05-05: %v, 05-09: %v; want only the second range left", on("2026-05-05"), on("2026-05-09"))
+	}
+	if reply := holidayCommand(t, db, tg, api, false, "2026-05-04..2026-05-10"); !strings.Contains(reply, "Таких дат") {
+		t.Fatalf("removing a missing range: %q", reply)
+	}
+
+	for _, arg := range []string{"2026-05-10..2026-05-04", "2026-13-01", "2026-01-01..2027-06-01"} {
+		if reply := holidayCommand(t, db, tg, api, true, arg); !strings.HasPrefix(reply, "❌") {
+			t.Fatalf("/add_holiday %s: %q, want it rejected", arg, reply)
+		}
+	}
+}
+
+func TestHolidaySkipAtEitherStep(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	seedTestGroup(t, db, testGroupID, "Coffee")
+	sched := loadGroupSchedule(ctx, db, testGroupID)
+	if err := database.SetGroupSetting(ctx, db, testGroupID, holidayCalendarSetting, "ru"); err != nil {
+		t.Fatalf("SetGroupSetting: %v", err)
+	}
+	if err := database.AddHoliday(ctx, db, database.Holiday{GroupID: testGroupID, StartDate: "2026-05-10", EndDate: "2026-05-10", CreatedAt: time.Now()}); err != nil {
+		t.Fatalf("AddHoliday: %v", err)
+	}
+
+	// The default schedule: quiz on Friday 17:00, pairs on Sunday 19:00, Moscow time
+	tests := []struct {
+		name    string
+		job     string
+		at      time.Time
+		wantDay string // the holiday the cycle hits, empty when it runs
+		quizAt  time.Time
+	}{
+		{"quiz on a built-in holiday", jobSendQuiz, moscow(t, 2026, 5, 1, 17), "2026-05-01", moscow(t, 2026, 5, 1, 17)},
+		{"pairs after a quiz on a holiday", jobCreatePairs, moscow(t, 2026, 5, 3, 19), "2026-05-01", moscow(t, 2026, 5, 1, 17)},
+		{"quiz before pairs on a holiday", jobSendQuiz, moscow(t, 2026, 5, 8, 17), "2026-05-10", moscow(t, 2026, 5, 8, 17)},
+		{"pairs on a custom holiday", jobCreatePairs, moscow(t, 2026, 5, 10, 19), "2026-05-10", moscow(t, 2026, 5, 8, 17)},
+		{"a working week", jobSendQuiz, moscow(t, 2026, 5, 15, 17), "", time.Time{}},
+		{"its pairs", jobCreatePairs, moscow(t, 2026, 5, 17, 19), "", time.Time{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			skip := checkHolidaySkip(ctx, db, testGroupID, sched, tt.job, tt.at)
+			if tt.wantDay == "" {
+				if skip != nil {
+					t.Fatalf("skipped for %s", skip)
+				}
+				return
+			}
+			if skip == nil || skip.day.Format(dateLayout) != tt.wantDay || !skip.quizAt.Equal(tt.quizAt) {
+				t.Fatalf("skip = %+v, want the cycle of %s skipped for %s", skip, tt.quizAt, tt.wantDay)
+			}
+		})
+	}
+}
+
+func TestHolidayFollowsGroupTimezone(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	almaty, err := time.LoadLocation("Asia/Almaty")
+	if err != nil {
+		t.Fatalf("LoadLocation: %v", err)
+	}
+	// Pairs half an hour after midnight in Almaty: still the day before in UTC and in Moscow
+	sched := groupSchedule{quiz: weeklyTime{time.Friday, 17, 0}, pairs: weeklyTime{time.Sunday, 0, 30}, timezone: "Asia/Almaty", location: almaty}
+	pairsAt := time.Date(2026, 5, 10, 0, 30, 0, 0, almaty)
+
+	if err := database.AddHoliday(ctx, db, database.Holiday{GroupID: testGroupID, StartDate: "2026-05-09", EndDate: "2026-05-09", CreatedAt: time.Now()}); err != nil {
+		t.Fatalf("AddHoliday: %v", err)
+	}
+	if skip := checkHolidaySkip(ctx, db, testGroupID, sched, jobCreatePairs, pairsAt); skip != nil {
+		t.Fatalf("skipped for %s, the UTC date of the pairing", skip)
+	}
+
+	if err := database.AddHoliday(ctx, db, database.Holiday{GroupID: testGroupID, StartDate: "2026-05-10", EndDate: "2026-05-10", CreatedAt: time.Now()}); err != nil {
+		t.Fatalf("AddHoliday: %v", err)
+	}
+	if skip := checkHolidaySkip(ctx, db, testGroupID, sched, jobCreatePairs, pairsAt); skip == nil || skip.day.Format(dateLayout) != "2026-05-10" {
+		t.Fatalf("skip = %+v, want the group's local date to count", skip)
+	}
+}
+
+func TestHolidayAfterQuizClosesSignups(t *testing.T) {
+	db, tg, api := setupManualPairsGroup(t)
+	ctx := context.Background()
+	if err := database.SetGroupSetting(ctx, db, testGroupID, holidayGroupNoticeSetting, "on"); err != nil {
+		t.Fatalf("SetGroupSetting: %v", err)
+	}
+
+	// The quiz went out with a countdown and people signed up, then a holiday was added for the pairing day
+	pm := database.PollMapping{PollID: "poll-1", GroupID: testGroupID, MessageID: 10, Kind: database.SignupPoll}
+	if err := database.CreatePollMapping(ctx, db, pm); err != nil {
+		t.Fatalf("CreatePollMapping: %v", err)
+	}
+	if err := database.SetPollCountdown(ctx, db, pm.PollID, 11, time.Now().Add(time.Hour), time.Now()); err != nil {
+		t.Fatalf("SetPollCountdown: %v", err)
+	}
+	signUp(t, db, testGroupID, 1, 2, 3)
+
+	skip := &holidaySkip{quizAt: moscow(t, 2026, 5, 8, 17), pairsAt: moscow(t, 2026, 5, 10, 19), day: moscow(t, 2026, 5, 10, 19), reason: "2026-05-10"}
+	skipHolidayCycle(ctx, db, api, testGroupID, jobCreatePairs, skip)
+
+	if n, err := database.CountParticipants(ctx, db, testGroupID); err != nil || n != 0 {
+		t.Fatalf("CountParticipants = %d, %v; want the sign-ups dropped", n, err)
+	}
+	if pm, err := database.GetPollMappingByGroupID(ctx, db, testGroupID); err != nil || pm != nil {
+		t.Fatalf("GetPollMappingByGroupID = %+v, %v; want the poll closed", pm, err)
+	}
+	if countdowns, err := database.GetActiveCountdowns(ctx, db); err != nil || len(countdowns) != 0 {
+		t.Fatalf("GetActiveCountdowns = %+v, %v; want the countdown finished", countdowns, err)
+	}
+	if tg.count("stopPoll") != 1 || tg.count("unpinChatMessage") != 1 {
+		t.Fatalf("stopPoll %d, unpinChatMessage %d times; want the poll stopped and unpinned", tg.count("stopPoll"), tg.count("unpinChatMessage"))
+	}
+	if edits := tg.edited(testGroupID); len(edits) != 1 || edits[0] != countdownClosedText {
+		t.Fatalf("countdown edited to %q, want it closed", edits)
+	}
+
+	// The skip is announced once per cycle, however many of its steps are skipped
+	skipHolidayCycle(ctx, db, api, testGroupID, jobCreatePairs, skip)
+	notices := 0
+	for _, text := range tg.sent(testGroupID) {
+		if strings.Contains(text, "отдыхает") {
+			notices++
+		}
+	}
+	if notices != 1 {
+		t.Fatalf("group got %d holiday notices, want one", notices)
+	}
+}
@@ -0,0 +1,365 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"example.com/random_coffee/database"
+	"github.com/NicoNex/echotron/v3"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	langRu = "ru"
+	langEn = "en"
+
+	defaultLanguage = langRu
+
+	// languageSetting is the group's reply language for members who have none of their own
+	languageSetting = "language"
+)
+
+// catalog holds replies to users in private chats. Admin-only replies stay in Russian.
+var catalog = map[string]map[string]string{
+	langRu: {
+		"start.intro": "👋 Привет! Это Random Coffee Bot.\n\n" +
+			"Бот автоматически создает пары для случайных встреч.\n\n" +
+			"📅 Расписание по умолчанию (у каждой группы может быть свое, по московскому времени):\n" +
+			"• Пятница 17:00 - рассылка опроса\n" +
+			"• Воскресенье 19:00 - создание пар\n\n" +
+			"/my_data - какие данные о тебе хранит бот\n" +
+			"/volunteer on|off [group_id] - встречаться с новичками группы\n" +
+			"/avoid @username [group_id] - не ставить в пару с этим человеком\n" +
+			"/notifications on|off - личные сообщения о встречах\n" +
+			"/dashboard - сводка о твоих встречах, которую бот сам обновляет\n" +
+			"/language ru|en - язык ответов бота",
+		"command.unknown":           "Неизвестная команда. Используй /start для справки.",
+		"language.usage":            "Использование: /language ru|en",
+		"language.set":              "✅ Теперь я отвечаю на русском",
+		"language.save_failed":      "❌ Не удалось сохранить язык",
+		"notifications.usage":       "Использование: /notifications on|off\n\nЛичные сообщения о встречах: кто твой собеседник-новичок, встречи в нескольких группах.",
+		"notifications.on":          "🔔 Личные сообщения о встречах включены",
+		"notifications.off":         "🔕 Личные сообщения о встречах выключены",
+		"notifications.save_failed": "❌ Не удалось сохранить настройку",
+		"volunteer.usage":           "Использование: /volunteer on|off [group_id]\n\nВолонтеры встречаются с теми, кто участвует в Random Coffee впервые.",
+		"volunteer.bad_group":       "❌ Укажи ID группы из списка подключенных групп",
+		"volunteer.save_failed":     "❌ Не удалось сохранить настройку",
+		"volunteer.on":              "✅ Спасибо! Теперь новичков группы будут чаще ставить в пару с тобой",
+		"volunteer.off":             "✅ Ты больше не волонтер в этой группе",
+		"avoid.usage":               "Использование: /avoid @username [group_id] - не ставить тебя в пару с этим человеком\n/unavoid @username [group_id] - отменить\n/avoid [group_id] - твой список\n\nСписок видишь только ты: админы знают лишь общее число таких исключений в группе.",
+		"avoid.disabled":            "Личные исключения в этом боте не настроены",
+		"avoid.failed":              "❌ Не удалось сохранить, попробуй позже",
+		"avoid.unknown_user":        "❌ Не знаю такого пользователя: бот знает только тех, кто хоть раз записывался на Random Coffee",
+		"avoid.added":               "✅ Вас не поставят в пару. Об этом никто не узнает",
+		"avoid.removed":             "✅ Исключение снято",
+		"avoid.not_found":           "Этого человека нет в твоем списке",
+		"avoid.limit":               "❌ В одной группе можно исключить не больше %d человек. Сначала сними одно из исключений через /unavoid",
+		"avoid.none":                "Твой список исключений в этой группе пуст",
+		"avoid.list":                "Твои исключения в этой группе (%d из %d):",
+		"avoid.list_unknown":        "• и еще %d, кого бот больше не знает",
+		"dashboard.title":           "📋 Твой Random Coffee",
+		"dashboard.empty":           "Ты пока не записывался ни в одной группе. Как только запишешься, здесь появится твой статус",
+		"dashboard.signed_up":       "✅ Записан на следующие пары",
+		"dashboard.not_signed_up":   "➖ Не записан на следующие пары",
+		"dashboard.partner":         "☕️ Пара этой недели: %s",
+		"dashboard.next_quiz":       "📅 Следующий опрос: %s (%s)",
+		"dashboard.streak":          "🔥 Недель подряд со встречами: %d",
+		"dashboard.pin_button":      "📌 Закрепить",
+		"dashboard.refresh_button":  "🔄 Обновить",
+		"dashboard.pinned":          "📌 Закреплено",
+		"dashboard.pin_failed":      "❌ Не удалось закрепить",
+		"dashboard.stale":           "Это старая сводка, отправь /dashboard для новой",
+		"dashboard.usage":           "Использование: /dashboard - сводка, которую бот сам обновляет\n/dashboard off - больше не обновлять",
+		"dashboard.failed":          "❌ Не получилось, попробуй позже",
+		"dashboard.off":             "✅ Сводка больше не обновляется",
+		"dashboard.none":            "Сводки нет, отправь /dashboard, чтобы ее получить",
+		"access.denied":             "❌ Доступ запрещен",
+		"my_data.cooldown":          "⏳ Выгрузку можно запрашивать не чаще раза в %d минут. Попробуй через %d мин.",
+		"my_data.failed":            "❌ Не удалось собрать данные, попробуй позже",
+		"my_data.title":             "🗂 Данные, которые хранит о тебе бот",
+		"my_data.profile":           "👤 Профиль:",
+		"my_data.name":              "• имя: %s",
+		"my_data.no_profile":        "👤 Профиль не сохранен",
+		"my_data.participation":     "✅ Участвуешь в текущем опросе:",
+		"my_data.signup_item":       "• группа %d (с %s)",
+		"my_data.pairs":             "☕️ История пар (%d):",
+		"my_data.pair_item":         "• %s, группа %d: %s",
+		"my_data.no_pairs":          "☕️ История пар пуста",
+		"my_data.unknown_partner":   "неизвестный участник",
+	},
+	langEn: {
+		"start.intro": "👋 Hi! This is Random Coffee Bot.\n\n" +
+			"The bot matches people for random coffee meetings.\n\n" +
+			"📅 Default schedule (each group may have its own, Moscow time):\n" +
+			"• Friday 17:00 - poll\n" +
+			"• Sunday 19:00 - pairs\n\n" +
+			"/my_data - what the bot stores about you\n" +
+			"/volunteer on|off [group_id] - meet newcomers of a group\n" +
+			"/avoid @username [group_id] - never pair with this person\n" +
+			"/notifications on|off - private messages about meetings\n" +
+			"/dashboard - a summary of your meetings the bot keeps up to date\n" +
+			"/language ru|en - reply language",
+		"command.unknown":           "Unknown command. Send /start for help.",
+		"language.usage":            "Usage: /language ru|en",
+		"language.set":              "✅ I'll reply in English now",
+		"language.save_failed":      "❌ Failed to save the language",
+		"notifications.usage":       "Usage: /notifications on|off\n\nPrivate messages about meetings: your newcomer partner, meetings in several groups.",
+		"notifications.on":          "🔔 Private messages about meetings are on",
+		"notifications.off":         "🔕 Private messages about meetings are off",
+		"notifications.save_failed": "❌ Failed to save the setting",
+		"volunteer.usage":           "Usage: /volunteer on|off [group_id]\n\nVolunteers meet people taking part in Random Coffee for the first time.",
+		"volunteer.bad_group":       "❌ Specify a group ID from the list of connected groups",
+		"volunteer.save_failed":     "❌ Failed to save the setting",
+		"volunteer.on":              "✅ Thank you! Newcomers of the group will be matched with you more often",
+		"volunteer.off":             "✅ You are no longer a volunteer in this group",
+		"avoid.usage":               "Usage: /avoid @username [group_id] - never pair you with this person\n/unavoid @username [group_id] - undo\n/avoid [group_id] - your list\n\nOnly you see the list: admins only know how many such exclusions a group has.",
+		"avoid.disabled":            "Personal exclusions are not set up in this bot",
+		"avoid.failed":              "❌ Failed to save, try again later",
+		"avoid.unknown_user":        "❌ Unknown user: the bot only knows people who signed up for Random Coffee at least once",
+		"avoid.added":               "✅ You won't be paired. Nobody will know about it",
+		"avoid.removed":             "✅ Exclusion removed",
+		"avoid.not_found":           "This person is not on your list",
+		"avoid.limit":               "❌ You can exclude at most %d people per group. Remove one with /unavoid first",
+		"avoid.none":                "Your exclusion list in this group is empty",
+		"avoid.list":                "Your exclusions in this group (%d of %d):",
+		"avoid.list_unknown":        "• and %d more the bot no longer knows",
+		"dashboard.title":           "📋 Your Random Coffee",
+		"dashboard.empty":           "You haven't signed up in any group yet. Once you do, your status shows up here",
+		"dashboard.signed_up":       "✅ Signed up for the next pairs",
+		"dashboard.not_signed_up":   "➖ Not signed up for the next pairs",
+		"dashboard.partner":         "☕️ This week's partner: %s",
+		"dashboard.next_quiz":       "📅 Next poll: %s (%s)",
+		"dashboard.streak":          "🔥 Weeks in a row with a meeting: %d",
+		"dashboard.pin_button":      "📌 Pin",
+		"dashboard.refresh_button":  "🔄 Refresh",
+		"dashboard.pinned":          "📌 Pinned",
+		"dashboard.pin_failed":      "❌ Failed to pin",
+		"dashboard.stale":           "This is an old summary, send /dashboard for a new one",
+		"dashboard.usage":           "Usage: /dashboard - a summary the bot keeps up to date\n/dashboard off - stop updating it",
+		"dashboard.failed":          "❌ Something went wrong, try again later",
+		"dashboard.off":             "✅ The summary is no longer updated",
+		"dashboard.none":            "There is no summary, send /dashboard to get one",
+		"access.denied":             "❌ Access denied",
+		"my_data.cooldown":          "⏳ You can request your data once every %d minutes. Try again in %d min.",
+		"my_data.failed":            "❌ Failed to collect your data, try again later",
+		"my_data.title":             "🗂 Data the bot stores about you",
+		"my_data.profile":           "👤 Profile:",
+		"my_data.name":              "• name: %s",
+		"my_data.no_profile":        "👤 No profile stored",
+		"my_data.participation":     "✅ Signed up for the current poll:",
+		"my_data.signup_item":       "• group %d (since %s)",
+		"my_data.pairs":             "☕️ Pair history (%d):",
+		"my_data.pair_item":         "• %s, group %d: %s",
+		"my_data.no_pairs":          "☕️ Pair history is empty",
+		"my_data.unknown_partner":   "unknown participant",
+	},
+}
+
+// tr returns the reply in the given language, falling back to the default language
+func tr(lang, key string) string {
+	if text, ok := catalog[lang][key]; ok {
+		return text
+	}
+	return catalog[defaultLanguage][key]
+}
+
+// resolveLanguage returns the first supported language of the chain, or the default
+func resolveLanguage(chain ...string) string {
+	for _, lang := range chain {
+		if _, ok := catalog[lang]; ok {
+			return lang
+		}
+	}
+	return defaultLanguage
+}
+
+// languageChain holds what a reply language is chosen from, by priority: the user's explicit choice,
+// the language inferred from their client or messages, the language of their group, the default
+type languageChain struct {
+	explicit string
+	inferred string
+	group    string
+}
+
+func (c languageChain) resolve() string {
+	return resolveLanguage(c.explicit, c.inferred, c.group)
+}
+
+// hasUserLanguage reports whether the chain stops before the group step
+func (c languageChain) hasUserLanguage() bool {
+	return catalog[c.explicit] != nil || catalog[c.inferred] != nil
+}
+
+// languageFromCode maps a Telegram client language (IETF tag) to a reply language.
+// Speakers of languages close to Russian get Russian, everyone else English.
+func languageFromCode(code string) string {
+	if code == "" {
+		return ""
+	}
+	base, _, _ := strings.Cut(strings.ToLower(code), "-")
+	switch base {
+	case "ru", "uk", "be", "kk":
+		return langRu
+	default:
+		return langEn
+	}
+}
+
+// languageFromText guesses the language from the letters of a message, ignoring a leading command
+func languageFromText(text string) string {
+	if strings.HasPrefix(text, "/") {
+		_, text, _ = strings.Cut(text, " ")
+	}
+	cyrillic, latin := 0, 0
+	for _, r := range text {
+		switch {
+		case unicode.Is(unicode.Cyrillic, r):
+			cyrillic++
+		case unicode.Is(unicode.Latin, r):
+			latin++
+		}
+	}
+	switch {
+	case cyrillic > latin:
+		return langRu
+	case latin > cyrillic:
+		return langEn
+	default:
+		return ""
+	}
+}
+
+// storedLanguageChain reads the user's explicit or inferred language
+func storedLanguageChain(ctx context.Context, db *sql.DB, userID int64) languageChain {
+	stored, explicit, err := database.GetUserLanguage(ctx, db, userID)
+	if err != nil {
+		botEvent(log.Warn(), EventLanguageFailed).Err(err).Int64("user_id", userID).Msg("GetUserLanguage failed")
+	}
+	if explicit {
+		return languageChain{explicit: stored}
+	}
+	return languageChain{inferred: stored}
+}
+
+// groupLanguage returns the language a group admin set with /language, empty when there is none
+func groupLanguage(ctx context.Context, db *sql.DB, groupID int64) string {
+	lang, _, err := database.GetGroupSetting(ctx, db, groupID, languageSetting)
+	if err != nil {
+		groupEvent(log.Warn(), EventLanguageFailed, groupID).Err(err).Msg("GetGroupSetting failed")
+	}
+	return lang
+}
+
+// memberGroupLanguage returns the language of the user's groups when they all agree on one, empty otherwise
+func memberGroupLanguage(ctx context.Context, db *sql.DB, userID int64) string {
+	langs, err := database.GetUserGroupSettingValues(ctx, db, userID, languageSetting)
+	if err != nil {
+		botEvent(log.Warn(), EventLanguageFailed).Err(err).Int64("user_id", userID).Msg("GetUserGroupSettingValues failed")
+		return ""
+	}
+	if len(langs) != 1 {
+		return ""
+	}
+	return langs[0]
+}
+
+// userLanguage resolves the reply language for a private message. Unless the user chose a language
+// with /language, it is inferred from their Telegram client (or the message text) and stored.
+// Without either, the user gets the language of their groups.
+func userLanguage(ctx context.Context, db *sql.DB, message *echotron.Message) string {
+	userID := message.From.ID
+	chain := storedLanguageChain(ctx, db, userID)
+	if chain.explicit == "" {
+		inferred := languageFromCode(message.From.LanguageCode)
+		if inferred == "" {
+			inferred = languageFromText(message.Text)
+		}
+		if inferred != "" && inferred != chain.inferred {
+			if err := database.SetUserLanguage(ctx, db, userID, inferred, false); err != nil {
+				botEvent(log.Warn(), EventLanguageFailed).Err(err).Int64("user_id", userID).Msg("SetUserLanguage failed")
+			}
+			chain.inferred = inferred
+		}
+	}
+	if !chain.hasUserLanguage() {
+		chain.group = memberGroupLanguage(ctx, db, userID)
+	}
+	return chain.resolve()
+}
+
+// replyLanguage is the user's language for messages sent without a message to infer it from.
+// The group step uses the given group, or the user's groups when groupID is 0.
+func replyLanguage(ctx context.Context, db *sql.DB, userID, groupID int64) string {
+	chain := storedLanguageChain(ctx, db, userID)
+	if !chain.hasUserLanguage() {
+		if groupID != 0 {
+			chain.group = groupLanguage(ctx, db, groupID)
+		} else {
+			chain.group = memberGroupLanguage(ctx, db, userID)
+		}
+	}
+	return chain.resolve()
+}
+
+// handleLanguageCommand implements /language ru|en in a private chat; the choice overrides inference
+func handleLanguageCommand(ctx context.Context, db *sql.DB, api echotron.API, message *echotron.Message, args []string, lang string) {
+	chatID := message.Chat.ID
+	if len(args) != 1 {
+		sendMessage(api, tr(lang, "language.usage"), chatID)
+		return
+	}
+	chosen := strings.ToLower(args[0])
+	if _, ok := catalog[chosen]; !ok {
+		sendMessage(api, tr(lang, "language.usage"), chatID)
+		return
+	}
+
+	if err := database.SetUserLanguage(ctx, db, message.From.ID, chosen, true); err != nil {
+		botEvent(log.Error(), EventLanguageFailed).Err(err).Int64("user_id", message.From.ID).Msg("SetUserLanguage failed")
+		sendMessage(api, tr(lang, "language.save_failed"), chatID)
+		return
+	}
+
+	botEvent(log.Info(), EventLanguageChanged).Int64("user_id", message.From.ID).Str("language", chosen).Msg("Reply language chosen")
+	sendMessage(api, tr(chosen, "language.set"), chatID)
+}
+
+// handleGroupLanguageCommand implements /language ru|en|off in a group: the language members get in private
+// chats until they choose one or the bot infers theirs
+func handleGroupLanguageCommand(ctx context.Context, db *sql.DB, api echotron.API, groupID int64, args []string) {
+	if len(args) != 1 {
+		current := groupLanguage(ctx, db, groupID)
+		if current == "" {
+			current = "не задан"
+		}
+		sendMessage(api, fmt.Sprintf("Язык группы: %s.\nИспользование: /language ru|en|off - язык личных ответов участникам, которые не выбрали свой", current), groupID)
+		return
+	}
+
+	chosen := strings.ToLower(args[0])
+	var err error
+	switch {
+	case chosen == "off":
+		err = database.DeleteGroupSetting(ctx, db, groupID, languageSetting)
+	case catalog[chosen] != nil:
+		err = database.SetGroupSetting(ctx, db, groupID, languageSetting, chosen)
+	default:
+		sendMessage(api, "Использование: /language ru|en|off", groupID)
+		return
+	}
+	if err != nil {
+		groupEvent(log.Error(), EventSettingsSaveFailed, groupID).Err(err).Str("key", languageSetting).Msg("Saving group language failed")
+		sendMessage(api, "❌ Не удалось сохранить настройку", groupID)
+		return
+	}
+
+	groupEvent(log.Info(), EventLanguageChanged, groupID).Str("language", chosen).Msg("Group language changed")
+	if chosen == "off" {
+		sendMessage(api, "✅ Язык группы сброшен", groupID)
+		return
+	}
+	sendMessage(api, fmt.Sprintf("✅ Язык группы: %s. Участники без своего языка получат личные ответы на нем", chosen), groupID)
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"example.com/random_coffee/database"
+	"github.com/NicoNex/echotron/v3"
+	"github.com/google/uuid"
+)
+
+// Languages the tests register, so every step of the chain answers differently:
+// explicit choice xx, inferred en, group yy, default ru
+const (
+	testExplicitLang = "xx"
+	testGroupLang    = "yy"
+)
+
+func withTestLanguages(t *testing.T) {
+	t.Helper()
+	catalog[testExplicitLang] = map[string]string{}
+	catalog[testGroupLang] = map[string]string{}
+	t.Cleanup(func() {
+		delete(catalog, testExplicitLang)
+		delete(catalog, testGroupLang)
+	})
+}
+
+// privateMessage is a message in the user's private chat; clientLang is their Telegram client language
+func privateMessage(userID int64, clientLang, text string) *echotron.Message {
+	return &echotron.Message{Text: text, Chat: echotron.Chat{ID: userID, Type: "private"},
+		From: &echotron.User{ID: userID, LanguageCode: clientLang}}
+}
+
+// setGroupLanguage signs the user up in a new group with the given language
+func setGroupLanguage(t *testing.T, db *sql.DB, groupID, userID int64, lang string) {
+	t.Helper()
+	seedTestGroup(t, db, groupID, fmt.Sprintf("Group %d", groupID))
+	signUp(t, db, groupID, userID)
+	if err := database.SetGroupSetting(context.Background(), db, groupID, languageSetting, lang); err != nil {
+		t.Fatalf("SetGroupSetting: %v", err)
+	}
+}
+
+func TestLanguageChain(t *testing.T) {
+	withTestLanguages(t)
+	const userID = 1
+
+	for _, tc := range []struct {
+		explicit, inferred, group bool
+		want                      string
+	}{
+		{true, true, true, testExplicitLang},
+		{true, true, false, testExplicitLang},
+		{true, false, true, testExplicitLang},
+		{true, false, false, testExplicitLang},
+		{false, true, true, langEn},
+		{false, true, false, langEn},
+		{false, false, true, testGroupLang},
+		{false, false, false, defaultLanguage},
+	} {
+		name := fmt.Sprintf("explicit=%v/inferred=%v/group=%v", tc.explicit, tc.inferred, tc.group)
+		t.Run(name, func(t *testing.T) {
+			db := openTestDB(t)
+			ctx := context.Background()
+			if tc.explicit {
+				if err := database.SetUserLanguage(ctx, db, userID, testExplicitLang, true); err != nil {
+					t.Fatalf("SetUserLanguage: %v", err)
+				}
+			}
+			if tc.group {
+				setGroupLanguage(t, db, testGroupID, userID, testGroupLang)
+			}
+			clientLang := ""
+			if tc.inferred {
+				clientLang = "en-US"
+			}
+
+			if got := userLanguage(ctx, db, privateMessage(userID, clientLang, "/start")); got != tc.want {
+				t.Fatalf("userLanguage = %q, want %q", got, tc.want)
+			}
+			// Messages sent without a message of the user's resolve the same way, from what was stored
+			if got := replyLanguage(ctx, db, userID, 0); got != tc.want {
+				t.Fatalf("replyLanguage = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestInferredLanguageIsStored(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	setGroupLanguage(t, db, testGroupID, 1, langRu)
+
+	// Inferred once from the client, then remembered; the group language no longer applies
+	if got := userLanguage(ctx, db, privateMessage(1, "en", "/start")); got != langEn {
+		t.Fatalf("userLanguage = %q, want en from the client", got)
+	}
+	if got := userLanguage(ctx, db, privateMessage(1, "", "/avoid")); got != langEn {
+		t.Fatalf("userLanguage = %q, want the stored en", got)
+	}
+	if lang, explicit, _ := database.GetUserLanguage(ctx, db, 1); lang != langEn || explicit {
+		t.Fatalf("stored %q explicit=%v, want inferred en", lang, explicit)
+	}
+
+	// Without a client language the text decides
+	if got := userLanguage(ctx, db, privateMessage(2, "", "/avoid @иван")); got != langRu {
+		t.Fatalf("userLanguage = %q, want ru from the text", got)
+	}
+
+	// An explicit choice beats whatever the client says later
+	_, api := newFakeTelegram(t)
+	handleLanguageCommand(ctx, db, api, privateMessage(1, "en", "/language ru"), []string{"ru"}, langEn)
+	if got := userLanguage(ctx, db, privateMessage(1, "en", "/start")); got != langRu {
+		t.Fatalf("userLanguage = %q after /language ru, want ru", got)
+	}
+}
+
+func TestGroupLanguageNeedsAgreement(t *testing.T) {
+	withTestLanguages(t)
+	db := openTestDB(t)
+	ctx := context.Background()
+	setGroupLanguage(t, db, -1, 1, langEn)
+	setGroupLanguage(t, db, -2, 1, testGroupLang)
+
+	if got := replyLanguage(ctx, db, 1, 0); got != defaultLanguage {
+		t.Fatalf("replyLanguage = %q, want the default for groups that disagree", got)
+	}
+	// A message about one group uses that group's language
+	if got := replyLanguage(ctx, db, 1, -2); got != testGroupLang {
+		t.Fatalf("replyLanguage for group -2 = %q, want %q", got, testGroupLang)
+	}
+
+	// Pairs of past weeks count as membership too
+	seedTestGroup(t, db, -3, "Old")
+	if err := database.SetGroupSetting(ctx, db, -3, languageSetting, langEn); err != nil {
+		t.Fatalf("SetGroupSetting: %v", err)
+	}
+	pair := database.Pair{ID: uuid.New(), GroupID: -3, User1ID: 5, User2ID: 6, WeekStart: "2026-03-02", CreatedAt: time.Now()}
+	if err := database.CreatePairs(ctx, db, []database.Pair{pair}); err != nil {
+		t.Fatalf("CreatePairs: %v", err)
+	}
+	if got := replyLanguage(ctx, db, 6, 0); got != langEn {
+		t.Fatalf("replyLanguage = %q, want the language of the group the user was paired in", got)
+	}
+}
+
+func TestGroupLanguageCommand(t *testing.T) {
+	db := openTestDB(t)
+	tg, api := newFakeTelegram(t)
+	withAdmins(t, testAdminID)
+	seedTestGroup(t, db, testGroupID, "Coffee")
+	ctx := context.Background()
+
+	if reply := groupCommand(t, db, tg, api, "/language en"); !strings.Contains(reply, "✅") {
+		t.Fatalf("reply = %q, want the language set", reply)
+	}
+	if lang := groupLanguage(ctx, db, testGroupID); lang != langEn {
+		t.Fatalf("group language = %q, want en", lang)
+	}
+	if reply := groupCommand(t, db, tg, api, "/language"); !strings.Contains(reply, "Язык группы: en") {
+		t.Fatalf("reply = %q, want the current language", reply)
+	}
+	if reply := groupCommand(t, db, tg, api, "/language de"); !strings.Contains(reply, "Использование") || groupLanguage(ctx, db, testGroupID) != langEn {
+		t.Fatalf("reply = %q, want an unsupported language rejected", reply)
+	}
+
+	groupCommand(t, db, tg, api, "/language off")
+	if lang := groupLanguage(ctx, db, testGroupID); lang != "" {
+		t.Fatalf("group language = %q after off", lang)
+	}
+}
+
+func TestPrivateRepliesInEnglish(t *testing.T) {
+	db := openTestDB(t)
+	tg, api := newFakeTelegram(t)
+	withAdmins(t, testAdminID)
+	saved := myDataLimiter
+	myDataLimiter = newRequestLimiter(myDataCooldown)
+	t.Cleanup(func() { myDataLimiter = saved })
+	ctx := context.Background()
+
+	const userID = 77
+	HandlePrivateCommand(ctx, db, api, privateMessage(userID, "en", "/my_data"))
+	HandlePrivateCommand(ctx, db, api, privateMessage(userID, "en", "/my_data"))
+	HandlePrivateCommand(ctx, db, api, privateMessage(userID, "en", "/stats"))
+
+	sent := tg.sent(userID)
+	if len(sent) != 3 {
+		t.Fatalf("user got %q, want the data, the cooldown and the denial", sent)
+	}
+	if !strings.Contains(sent[0], "Data the bot stores about you") || !strings.Contains(sent[0], "Pair history is empty") {
+		t.Fatalf("/my_data = %q, want it in English", sent[0])
+	}
+	if !strings.Contains(sent[1], "once every 10 minutes") {
+		t.Fatalf("second /my_data = %q, want the cooldown in English", sent[1])
+	}
+	if sent[2] != "❌ Access denied" {
+		t.Fatalf("/stats = %q, want access denied in English", sent[2])
+	}
+	if n := tg.count("sendDocument"); n != 1 {
+		t.Fatalf("%d exports sent, want 1", n)
+	}
+}
+
+func TestCatalogsHaveTheSameKeys(t *testing.T) {
+	for key := range catalog[langRu] {
+		if _, ok := catalog[langEn][key]; !ok {
+			t.Errorf("%q has no English text", key)
+		}
+	}
+	for key := range catalog[langEn] {
+		if _, ok := catalog[langRu][key]; !ok {
+			t.Errorf("%q has no Russian text", key)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	jobSendQuiz    = "send_quiz"
+	jobCreatePairs = "create_pairs"
+)
+
+type jobKey struct {
+	job     string
+	groupID int64
+}
+
+// RunningJob describes a job invocation currently in progress for a group
+type RunningJob struct {
+	Job       string
+	GroupID   int64
+	StartedAt time.Time
+}
+
+// jobRegistry prevents the same job from running twice for a group at the same time.
+// It is in-process only: a single bot process owns the database.
+type jobRegistry struct {
+	mu      sync.Mutex
+	running map[jobKey]time.Time
+}
+
+var runningJobs = &jobRegistry{running: make(map[jobKey]time.Time)}
+
+// tryStart marks the job as running; if it already is, it returns the start time of that invocation
+func (r *jobRegistry) tryStart(job string, groupID int64) (time.Time, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := jobKey{job: job, groupID: groupID}
+	if startedAt, ok := r.running[key]; ok {
+		return startedAt, false
+	}
+	r.running[key] = time.Now()
+	return time.Time{}, true
+}
+
+func (r *jobRegistry) finish(job string, groupID int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.running, jobKey{job: job, groupID: groupID})
+}
+
+// snapshot returns running jobs ordered by start time
+func (r *jobRegistry) snapshot() []RunningJob {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	jobs := make([]RunningJob, 0, len(r.running))
+	for key, startedAt := range r.running {
+		jobs = append(jobs, RunningJob{Job: key.job, GroupID: key.groupID, StartedAt: startedAt})
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].StartedAt.Before(jobs[j].StartedAt) })
+	return jobs
+}
+
+// runGuarded runs fn unless the job is already running for the group.
+// If it is, fn is not called and the start time of the running invocation is returned.
+// The guard is released even if fn panics.
+func runGuarded(job string, groupID int64, fn func()) (time.Time, bool) {
+	startedAt, ok := runningJobs.tryStart(job, groupID)
+	if !ok {
+		return startedAt, false
+	}
+	defer runningJobs.finish(job, groupID)
+
+	fn()
+	return time.Time{}, true
+}
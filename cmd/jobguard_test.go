@@ -0,0 +1,68 @@
+package main
+
+import (
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestRunGuardedRace(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		var runs atomic.Int32
+		start, release := make(chan struct{}), make(chan struct{})
+		entered := make(chan struct{}, 2)
+		results := make(chan bool, 2)
+
+		for range 2 {
+			go func() {
+				<-start
+				_, ok := runGuarded(jobCreatePairs, testGroupID, func() {
+					runs.Add(1)
+					entered <- struct{}{}
+					<-release
+				})
+				results <- ok
+			}()
+		}
+		close(start)
+
+		// The body that won holds the guard until released, so the other run must have been turned away
+		<-entered
+		if ok := <-results; ok {
+			t.Fatal("second run was not turned away while the first one ran")
+		}
+		close(release)
+		if ok := <-results; !ok {
+			t.Fatal("first run reported as turned away")
+		}
+		if n := runs.Load(); n != 1 {
+			t.Fatalf("body ran %d times, want once", n)
+		}
+	}
+
+	if jobs := runningJobs.snapshot(); len(jobs) != 0 {
+		t.Fatalf("jobs still marked running: %+v", jobs)
+	}
+}
+
+func TestScheduledRunDuringManualRunIsReported(t *testing.T) {
+	tg, api := newFakeTelegram(t)
+	withAdmins(t, testAdminID)
+
+	var scheduledRuns atomic.Int32
+	runManualJob(api, jobSendQuiz, testGroupID, func() {
+		runScheduledJob(api, jobSendQuiz, testGroupID, func() { scheduledRuns.Add(1) })
+	})
+	if n := scheduledRuns.Load(); n != 0 {
+		t.Fatalf("scheduled run went ahead %d times during the manual one", n)
+	}
+	if sent := tg.sent(testAdminID); len(sent) != 1 || !strings.Contains(sent[0], "пропущен") || !strings.Contains(sent[0], jobSendQuiz) {
+		t.Fatalf("admin got %q, want the skipped run reported", sent)
+	}
+
+	// Once the manual run is over, the next scheduled one runs as usual and reports nothing
+	runScheduledJob(api, jobSendQuiz, testGroupID, func() { scheduledRuns.Add(1) })
+	if n := scheduledRuns.Load(); n != 1 || len(tg.sent(testAdminID)) != 1 {
+		t.Fatalf("scheduled run went ahead %d times, admin got %d messages", n, len(tg.sent(testAdminID)))
+	}
+}
@@ -6,18 +6,22 @@ import (
 	"io"
 	"os"
 	"os/signal"
+	"strconv"
 	"sync"
 	"syscall"
 	"time"
 
+	"example.com/random_coffee/database"
 	"example.com/random_coffee/pkg/logger"
 	"github.com/NicoNex/echotron/v3"
-	"github.com/pressly/goose/v3"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 	_ "modernc.org/sqlite"
 )
 
+// processedUpdatesKeep is how many recent update IDs are remembered for deduplication
+const processedUpdatesKeep = 1000
+
 type Bot struct {
 	echotron.API
 	DB     *sql.DB
@@ -41,10 +45,11 @@ func (w *dualFormatWriter) Write(p []byte) (n int, err error) {
 
 func recoverPanic(contextFields map[string]any) {
 	if r := recover(); r != nil {
-		entry := log.Error().Interface("panic", r)
+		entry := botEvent(log.Error(), EventPanic).Interface("panic", r)
 		for k, v := range contextFields {
 			entry = entry.Interface(k, v)
 		}
+		entry.Msg("Recovered from panic")
 	}
 }
 
@@ -53,68 +58,132 @@ func (b *Bot) Update(u *echotron.Update) {
 	defer b.mu.Unlock()
 	defer recoverPanic(map[string]any{"handler": "Update"})
 
+	sessions.touch(b.ChatID)
 	ctx := context.Background()
 
+	// Telegram may redeliver the same update after network problems
+	fresh, err := database.MarkUpdateProcessed(ctx, b.DB, int64(u.ID), processedUpdatesKeep)
+	if err != nil {
+		botEvent(log.Warn(), EventUpdateDedupFailed).Err(err).Int("update_id", u.ID).Msg("MarkUpdateProcessed failed")
+	} else if !fresh {
+		botEvent(log.Debug(), EventUpdateDuplicate).Int("update_id", u.ID).Msg("Duplicate update dropped")
+		return
+	}
+
+	if maintenance.hold(ctx, b.DB, u) {
+		return
+	}
+	dispatchUpdate(ctx, b.DB, b.API, u)
+}
+
+// dispatchUpdate routes an update to its handler
+func dispatchUpdate(ctx context.Context, db *sql.DB, api echotron.API, u *echotron.Update) {
 	if u.PollAnswer != nil {
-		HandlePollAnswer(ctx, b.DB, b.API, u.PollAnswer)
+		HandlePollAnswer(ctx, db, api, u.PollAnswer)
+		return
+	}
+
+	if u.MyChatMember != nil {
+		HandleMyChatMember(ctx, db, api, u.MyChatMember)
+		return
+	}
+
+	if u.CallbackQuery != nil {
+		HandleCallbackQuery(ctx, db, api, u.CallbackQuery)
 		return
 	}
 
 	if u.Message != nil {
+		if u.Message.MigrateToChatID != 0 || u.Message.MigrateFromChatID != 0 {
+			handleChatMigrationMessage(ctx, db, api, u.Message)
+			return
+		}
 		if u.Message.Chat.Type == "private" {
-			HandlePrivateCommand(ctx, b.DB, b.API, u.Message)
+			HandlePrivateCommand(ctx, db, api, u.Message)
 			return
 		}
-		HandleGroupCommand(ctx, b.DB, b.API, u.Message)
+		HandleGroupCommand(ctx, db, api, u.Message)
 	}
-
 }
 
 func main() {
+	if runCLI(os.Args[1:]) {
+		return
+	}
+
 	logger.Init(logger.Config{
 		PrettyConsole: true,
 	})
-	log.Info().Msg("Starting bot...")
+	botEvent(log.Info(), EventBotStarting).Msg("Starting bot...")
 
 	botToken := mustEnv("TELEGRAM__TOKEN")
 	dbPath := mustEnv("DB__URL")
 
 	db, err := sql.Open("sqlite", dbPath)
 	if err != nil {
-		log.Fatal().Err(err).Msg("sql.Open failed")
+		botEvent(log.Fatal(), EventDBOpenFailed).Err(err).Msg("sql.Open failed")
 	}
 	defer func() { _ = db.Close() }()
 
 	err = runMigrations(db)
 	if err != nil {
-		log.Fatal().Err(err).Msg("runMigrations failed")
+		botEvent(log.Fatal(), EventDBMigrationsFailed).Err(err).Msg("runMigrations failed")
+	}
+
+	openReadPool(context.Background(), db, dbPath)
+	if readPool != nil {
+		defer func() { _ = readPool.Close() }()
 	}
 
 	initAdmins()
+	postProcessors = loadPostProcessors()
+	onBotRemoved = func(groupID int64) { deactivateRemovedGroup(context.Background(), db, groupID) }
+	database.OnCorruptRow = func(e *database.CorruptRowError) {
+		botEvent(log.Error(), EventDBCorruptRow).Err(e).Str("table", e.Table).Str("column", e.Column).Str("row", e.Row).
+			Msg("Corrupt row in the database")
+	}
 
 	botAPI := echotron.NewAPI(botToken)
+	importEnvGroups(context.Background(), db, botAPI)
 
+	var alertSink *WebhookSink
+	if webhookURL := os.Getenv("ALERT_WEBHOOK_URL"); webhookURL != "" {
+		alertSink = NewWebhookSink(webhookURL)
+	}
+	tokenWatcher = newTokenWatch(botAPI, alertSink, envInt("TOKEN_FAILURE_LIMIT", 3))
+
+	var notifier *AdminNotifier
 	if len(adminChatIDsMap) > 0 {
 		// Setup dual logger: console (pretty) + admin notifier (JSON)
 		consoleWriter := zerolog.ConsoleWriter{Out: os.Stderr, TimeFormat: "15:04:05"}
-		jsonWriter := NewAdminNotifier(botAPI, adminChatIDsMap, io.Discard)
+		notifier = NewAdminNotifier(botAPI, adminChatIDsMap, time.Duration(envInt("ALERT_BATCH_SECONDS", 30))*time.Second)
+		if alertSink != nil {
+			notifier.SetFallback(alertSink, envInt("ALERT_FAILOVER_AFTER", 3))
+		}
 
 		// Create a custom writer that duplicates to both console and JSON
 		multiWriter := &dualFormatWriter{
 			console: consoleWriter,
-			json:    jsonWriter,
+			json:    notifier,
 		}
 
 		log.Logger = zerolog.New(multiWriter).With().Timestamp().Logger()
-		log.Info().Msg("Admin notifier enabled")
+		botEvent(log.Info(), EventNotifierEnabled).Msg("Admin notifier enabled")
 	}
 
 	stop := make(chan struct{})
+	dashboards.start(db, botAPI)
 	startScheduler(db, botAPI, stop)
+	maintenance.resume(db, botAPI)
+	go func() {
+		defer recoverPanic(map[string]any{"handler": "duplicate_check"})
+		checkDuplicateGroups(context.Background(), db, botAPI)
+	}()
 
 	newBot := func(chatID int64) echotron.Bot { return &Bot{ChatID: chatID, DB: db, API: echotron.NewAPI(botToken)} }
 
-	dsp := echotron.NewDispatcher(botToken, newBot)
+	dsp := echotron.NewDispatcher(botToken, trackedBotFactory(newBot))
+	startSessionSweeper(dsp, time.Duration(envInt("SESSION_IDLE_HOURS", 6))*time.Hour, stop)
 
 	updateOpts := echotron.UpdateOptions{
 		// AllowedUpdates: []echotron.UpdateType{
@@ -128,10 +197,15 @@ func main() {
 	go func() {
 		defer recoverPanic(map[string]any{"handler": "polling"})
 
-		log.Info().Msg("Bot polling started")
+		botEvent(log.Info(), EventPollingStarted).Msg("Bot polling started")
 		for {
 			if err := dsp.PollOptions(false, updateOpts); err != nil {
-				log.Error().Err(err).Msg("dsp.Poll failed, retrying in 5 seconds...")
+				if isConflictError(err) {
+					botEvent(log.Error(), EventPollingConflict).Err(err).Msg("Another bot instance is polling with the same token, retrying in 5 seconds...")
+				} else {
+					botEvent(log.Error(), EventPollingFailed).Err(err).Msg("dsp.Poll failed, retrying in 5 seconds...")
+				}
+				tokenWatcher.pollFailed(err)
 				time.Sleep(5 * time.Second)
 				continue
 			}
@@ -145,73 +219,180 @@ func main() {
 
 	select {
 	case <-sigChan:
-		log.Info().Msg("Received shutdown signal")
+		botEvent(log.Info(), EventBotShutdown).Msg("Received shutdown signal")
 	case err := <-errChan:
 		if err != nil {
-			log.Error().Err(err).Msg("Bot polling failed")
+			botEvent(log.Error(), EventPollingStopped).Err(err).Msg("Bot polling failed")
 		}
 	}
 
-	log.Info().Msg("Shutting down gracefully...")
+	botEvent(log.Info(), EventBotShutdown).Msg("Shutting down gracefully...")
 	close(stop)
 	time.Sleep(1 * time.Second)
-	log.Info().Msg("Goodbye!")
+	botEvent(log.Info(), EventBotStopped).Msg("Goodbye!")
+
+	// Deliver alerts still waiting for the next batch
+	if notifier != nil {
+		notifier.Close()
+	}
+	// Counts since the last flush, including those alerts, go into the weekly totals
+	flushOpsCounters(context.Background(), db)
 }
 
 func runMigrations(db *sql.DB) error {
-	if err := goose.SetDialect("sqlite3"); err != nil {
+	if err := database.Migrate(db, "migrations"); err != nil {
 		return err
 	}
 
-	if err := goose.Up(db, "migrations"); err != nil {
-		return err
+	botEvent(log.Info(), EventDBMigrated).Msg("Migrations applied successfully")
+	return nil
+}
+
+// groupScheduler runs one timer loop per active group, each sleeping until the group's next quiz
+// or pairing time. A loop is woken to recompute its timer when the group's config changes.
+type groupScheduler struct {
+	db   *sql.DB
+	api  echotron.API
+	stop chan struct{}
+
+	mu      sync.Mutex
+	wakes   map[int64]chan struct{}
+	health  map[int64]*loopHealth
+	workers map[string]*loopHealth // background workers, by name
+	skips   map[int64]holidaySkip  // groups whose next job falls on a holiday
+}
+
+// scheduler is set by startScheduler; nil until then
+var scheduler *groupScheduler
+
+func startScheduler(db *sql.DB, api echotron.API, stopChan chan struct{}) {
+	scheduler = &groupScheduler{db: db, api: api, stop: stopChan, wakes: make(map[int64]chan struct{}), health: make(map[int64]*loopHealth),
+		workers: make(map[string]*loopHealth), skips: make(map[int64]holidaySkip)}
+	for _, groupID := range getConfiguredGroups(context.Background(), db) {
+		scheduler.reschedule(groupID)
 	}
 
-	log.Info().Msg("Migrations applied successfully")
-	return nil
+	startCountdownUpdater(db, api, stopChan)
+	startSlowStartChecker(db, api, stopChan)
+	startParkedSignupRedelivery(db, stopChan)
+	startWeeklyReporter(db, api, stopChan)
+	startSnapshotPoster(stopChan)
+	startDashboardRefresher(stopChan)
+
+	botEvent(log.Info(), EventSchedulerStarted).Msg("Scheduler started")
 }
 
-func scheduleJob(jobName string, weekday time.Weekday, hour, minute int,
-	jobFunc func(context.Context, *sql.DB, echotron.API), db *sql.DB, api echotron.API, stopChan chan struct{}, location *time.Location) {
+// rescheduleGroup makes the group's timer follow its current config and registration state
+func rescheduleGroup(groupID int64) {
+	if scheduler != nil {
+		scheduler.reschedule(groupID)
+	}
+}
 
-	go func() {
-		defer recoverPanic(map[string]any{"handler": "scheduler", "job": jobName})
+// reschedule wakes the group's loop, starting one if the group has none
+func (s *groupScheduler) reschedule(groupID int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-		for {
-			now := time.Now().In(location)
-			next := nextOccurrence(now, weekday, hour, minute, location)
-			duration := next.Sub(now)
-
-			log.Info().Str("job", jobName).Time("next_run", next).Dur("in", duration).Msg("Scheduled")
-
-			select {
-			case <-time.After(duration):
-				log.Info().Str("job", jobName).Msg("Running scheduled job")
-				ctx := context.Background()
-				jobFunc(ctx, db, api)
-			case <-stopChan:
-				log.Info().Str("job", jobName).Msg("Job stopped")
-				return
-			}
+	if wake, ok := s.wakes[groupID]; ok {
+		select {
+		case wake <- struct{}{}:
+		default: // a wake-up is already pending
 		}
-	}()
+		return
+	}
+
+	wake := make(chan struct{}, 1)
+	s.wakes[groupID] = wake
+	go s.supervise(groupID, wake)
 }
 
-func startScheduler(db *sql.DB, api echotron.API, stopChan chan struct{}) {
-	moscowTZ, err := time.LoadLocation("Europe/Moscow")
-	if err != nil {
-		log.Fatal().Err(err).Msg("Failed to load Europe/Moscow timezone")
+// active reports whether the group still needs a loop, removing the loop's registration if not.
+// It runs under the lock so a concurrent reschedule either sees the loop or starts a new one.
+func (s *groupScheduler) active(ctx context.Context, groupID int64) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if isConfiguredGroup(ctx, s.db, groupID) {
+		return true
 	}
+	delete(s.wakes, groupID)
+	return false
+}
 
-	// Friday 17:00 - send quiz
-	scheduleJob("send_quiz", time.Friday, 17, 0, SendQuizToAllGroups, db, api, stopChan, moscowTZ)
+// run is the group's timer loop. A panicking job is recovered here; a panic in the loop itself
+// ends run and is handled by supervise.
+func (s *groupScheduler) run(groupID int64, wake chan struct{}) {
+	for {
+		ctx := context.Background()
+		if !s.active(ctx, groupID) {
+			s.setUpcomingSkip(groupID, nil)
+			groupEvent(log.Info(), EventJobStopped, groupID).Msg("Group inactive, scheduler loop stopped")
+			return
+		}
 
-	scheduleJob("send_quiz", time.Wednesday, 16, 19, SendQuizToAllGroups, db, api, stopChan, moscowTZ)
+		sched := loadGroupSchedule(ctx, s.db, groupID)
+		now := time.Now()
+		job, next := nextJob(sched, now)
 
-	// Sunday 19:00 - create pairs
-	scheduleJob("create_pairs", time.Sunday, 19, 0, CreatePairsForAllGroups, db, api, stopChan, moscowTZ)
+		// Holidays changed meanwhile wake the loop, so the decision is current when the timer fires
+		skip := checkHolidaySkip(ctx, s.db, groupID, sched, job, next)
+		s.setUpcomingSkip(groupID, skip)
 
-	log.Info().Msg("Scheduler started")
+		event := groupEvent(log.Info(), EventJobScheduled, groupID).Str("job", job).Time("next_run", next).Dur("in", next.Sub(now))
+		if skip != nil {
+			event = event.Str("holiday", skip.reason)
+		}
+		event.Msg("Scheduled")
+
+		timer := time.NewTimer(next.Sub(now))
+		select {
+		case <-timer.C:
+			// A deferral is used up once its quiz is due, whether the quiz then runs or the week is skipped
+			if job == jobSendQuiz && sched.deferral != nil && next.Equal(sched.deferral.at) {
+				clearQuizDeferral(ctx, s.db, groupID)
+			}
+			if skip != nil {
+				skipHolidayCycle(ctx, s.db, s.api, groupID, job, skip)
+				continue
+			}
+			if maintenance.holding() {
+				groupEvent(log.Info(), EventJobPaused, groupID).Str("job", job).Msg("Maintenance is on, job waits for it to end")
+				if !maintenance.waitReleased(s.stop) {
+					groupEvent(log.Info(), EventJobStopped, groupID).Msg("Scheduler loop stopped")
+					return
+				}
+			}
+			groupEvent(log.Info(), EventJobStarted, groupID).Str("job", job).Msg("Running scheduled job")
+			switch job {
+			case jobSendQuiz:
+				runScheduledJob(s.api, job, groupID, func() {
+					// A group registered again under its supergroup ID would otherwise get two polls
+					if mergeIfDuplicate(ctx, s.db, s.api, groupID) {
+						return
+					}
+					SendQuiz(ctx, s.db, s.api, groupID)
+				})
+			case jobCreatePairs:
+				runScheduledJob(s.api, job, groupID, func() { CreatePairs(ctx, s.db, s.api, groupID) })
+			}
+		case <-wake:
+			timer.Stop()
+		case <-s.stop:
+			timer.Stop()
+			groupEvent(log.Info(), EventJobStopped, groupID).Msg("Scheduler loop stopped")
+			return
+		}
+	}
+}
+
+// nextJob returns the group's first job after now and when it runs
+func nextJob(sched groupSchedule, now time.Time) (string, time.Time) {
+	job, next := jobSendQuiz, sched.nextQuiz(now)
+	if pairsAt := sched.pairs.next(now, sched.location); pairsAt.Before(next) {
+		job, next = jobCreatePairs, pairsAt
+	}
+	return job, next
 }
 
 func nextOccurrence(now time.Time, weekday time.Weekday, hour, minute int, location *time.Location) time.Time {
@@ -229,10 +410,24 @@ func nextOccurrence(now time.Time, weekday time.Weekday, hour, minute int, locat
 	return target.AddDate(0, 0, daysUntil)
 }
 
+// envInt reads a positive integer from the environment, falling back to def when unset or invalid
+func envInt(key string, def int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		botEvent(log.Warn(), EventConfigInvalidValue).Str("env", key).Str("value", value).Int("default", def).Msg("Invalid integer in environment, using default")
+		return def
+	}
+	return n
+}
+
 func mustEnv(key string) string {
 	value := os.Getenv(key)
 	if value == "" {
-		log.Fatal().Str("env", key).Msg("missing required environment variable")
+		botEvent(log.Fatal(), EventConfigMissingEnv).Str("env", key).Msg("missing required environment variable")
 	}
 	return value
 }
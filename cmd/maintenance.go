@@ -0,0 +1,276 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"example.com/random_coffee/database"
+	"github.com/NicoNex/echotron/v3"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultMaintenanceMinutes = 2
+	maxMaintenanceMinutes     = 30
+)
+
+// maintenanceMode holds updates back instead of handling them, e.g. during a migration or backup.
+// Held updates go to the queued_update table (or memory, if it can't be written) and are replayed
+// in arrival order when maintenance ends, by /maintenance off or when its time runs out. Scheduled
+// quizzes and pairings due meanwhile wait for the replay, then run.
+type maintenanceMode struct {
+	mu        sync.Mutex
+	until     time.Time
+	timer     *time.Timer
+	replaying bool          // updates keep queueing until the backlog is drained, so they stay in order
+	released  chan struct{} // closed once nothing is held any more; see waitReleased
+	db        *sql.DB
+	api       echotron.API
+	inMemory  []database.QueuedUpdate
+}
+
+var maintenance = &maintenanceMode{}
+
+// holding reports whether updates are currently queued instead of handled
+func (m *maintenanceMode) holding() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return !m.until.IsZero() || m.replaying
+}
+
+// waitReleased blocks while maintenance holds updates back, until it ends and the held updates are
+// replayed, so scheduled jobs see every sign-up made meanwhile. It returns false if stop closes first.
+func (m *maintenanceMode) waitReleased(stop <-chan struct{}) bool {
+	m.mu.Lock()
+	if m.until.IsZero() && !m.replaying {
+		m.mu.Unlock()
+		return true
+	}
+	if m.released == nil {
+		m.released = make(chan struct{})
+	}
+	released := m.released
+	m.mu.Unlock()
+
+	select {
+	case <-released:
+		return true
+	case <-stop:
+		return false
+	}
+}
+
+// replayDoneLocked marks the backlog drained and lets waiting jobs run, unless maintenance was turned
+// on again meanwhile
+func (m *maintenanceMode) replayDoneLocked() {
+	m.replaying = false
+	if m.until.IsZero() && m.released != nil {
+		close(m.released)
+		m.released = nil
+	}
+}
+
+// hold queues the update if maintenance is on and reports whether it did.
+// The /maintenance command itself always gets through, so an admin can end it.
+func (m *maintenanceMode) hold(ctx context.Context, db *sql.DB, u *echotron.Update) bool {
+	if isMaintenanceCommand(u) {
+		return false
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.until.IsZero() && !m.replaying {
+		return false
+	}
+
+	payload, err := json.Marshal(u)
+	if err != nil {
+		botEvent(log.Error(), EventMaintenanceQueueFailed).Err(err).Int("update_id", u.ID).Msg("Failed to encode update, handling it now")
+		return false
+	}
+	q := database.QueuedUpdate{UpdateID: int64(u.ID), Payload: string(payload), ReceivedAt: time.Now()}
+	if err := database.QueueUpdate(ctx, db, q); err != nil {
+		botEvent(log.Error(), EventMaintenanceQueueFailed).Err(err).Int("update_id", u.ID).Msg("QueueUpdate failed, update kept in memory")
+		m.inMemory = append(m.inMemory, q)
+	}
+	botEvent(log.Debug(), EventMaintenanceQueued).Int("update_id", u.ID).Msg("Update queued during maintenance")
+	return true
+}
+
+// start turns maintenance on for d, or extends it if it is already on
+func (m *maintenanceMode) start(db *sql.DB, api echotron.API, d time.Duration) time.Time {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.db, m.api = db, api
+	m.until = time.Now().Add(d)
+	if m.timer != nil {
+		m.timer.Stop()
+	}
+	m.timer = time.AfterFunc(d, func() { m.end("expired") })
+	return m.until
+}
+
+// end turns maintenance off and replays the queued updates; it is a no-op when maintenance is off
+func (m *maintenanceMode) end(reason string) {
+	m.mu.Lock()
+	if m.until.IsZero() {
+		m.mu.Unlock()
+		return
+	}
+	m.until = time.Time{}
+	if m.timer != nil {
+		m.timer.Stop()
+		m.timer = nil
+	}
+	m.replaying = true
+	db, api := m.db, m.api
+	m.mu.Unlock()
+
+	botEvent(log.Info(), EventMaintenanceEnded).Str("reason", reason).Msg("Maintenance ended, replaying queued updates")
+	m.replay(db, api)
+}
+
+// replay handles queued updates in arrival order until none are left, including ones that arrive meanwhile
+func (m *maintenanceMode) replay(db *sql.DB, api echotron.API) {
+	ctx := context.Background()
+
+	replayed := 0
+	for {
+		m.mu.Lock()
+		queued, err := database.GetQueuedUpdates(ctx, db)
+		if err != nil {
+			// Left in the table: replayed on the next start or the next maintenance window
+			m.replayDoneLocked()
+			m.mu.Unlock()
+			botEvent(log.Error(), EventMaintenanceReplayFailed).Err(err).Msg("GetQueuedUpdates failed, queued updates not replayed")
+			return
+		}
+		queued = append(queued, m.inMemory...)
+		m.inMemory = nil
+		if len(queued) == 0 {
+			m.replayDoneLocked()
+			m.mu.Unlock()
+			break
+		}
+		m.mu.Unlock()
+
+		for _, q := range queued {
+			replayQueuedUpdate(ctx, db, api, q)
+			replayed++
+			if q.ID != 0 {
+				if err := database.DeleteQueuedUpdate(ctx, db, q.ID); err != nil {
+					botEvent(log.Error(), EventMaintenanceReplayFailed).Err(err).Int64("update_id", q.UpdateID).Msg("DeleteQueuedUpdate failed")
+				}
+			}
+		}
+	}
+
+	botEvent(log.Info(), EventMaintenanceReplayed).Int("updates", replayed).Msg("Queued updates replayed")
+}
+
+// replayQueuedUpdate handles one held-back update. Poll answers, button presses and membership changes
+// are handled as if they just arrived; a command older than MAINTENANCE_COMMAND_TTL_MINUTES is
+// not run, since its sender may no longer expect it, and the sender is told so instead.
+func replayQueuedUpdate(ctx context.Context, db *sql.DB, api echotron.API, q database.QueuedUpdate) {
+	// A panicking handler must not stop the replay: later updates would stay queued for good
+	defer recoverPanic(map[string]any{"handler": "maintenance_replay", "update_id": q.UpdateID})
+
+	var u echotron.Update
+	if err := json.Unmarshal([]byte(q.Payload), &u); err != nil {
+		botEvent(log.Error(), EventMaintenanceReplayFailed).Err(err).Int64("update_id", q.UpdateID).Msg("Failed to decode queued update, dropped")
+		return
+	}
+
+	ttl := time.Duration(envInt("MAINTENANCE_COMMAND_TTL_MINUTES", 5)) * time.Minute
+	if msg := u.Message; msg != nil && strings.HasPrefix(msg.Text, "/") && time.Since(q.ReceivedAt) > ttl {
+		command, _ := parseCommand(msg.Text)
+		botEvent(log.Info(), EventMaintenanceExpired).Int64("update_id", q.UpdateID).Str("command", command).
+			Int64("chat_id", msg.Chat.ID).Msg("Queued command expired")
+		sendMessage(api, fmt.Sprintf("⌛ Команда %s пришла, пока бот был на обслуживании, и устарела. Отправь ее еще раз", command), msg.Chat.ID)
+		return
+	}
+
+	dispatchUpdate(ctx, db, api, &u)
+}
+
+// resume replays updates left queued by a run that stopped during maintenance
+func (m *maintenanceMode) resume(db *sql.DB, api echotron.API) {
+	m.mu.Lock()
+	m.replaying = true
+	m.mu.Unlock()
+	go m.replay(db, api)
+}
+
+// isMaintenanceCommand reports whether the update is an admin's /maintenance in a private chat
+func isMaintenanceCommand(u *echotron.Update) bool {
+	msg := u.Message
+	if msg == nil || msg.From == nil || msg.Chat.Type != "private" || !isAdmin(msg.From.ID) {
+		return false
+	}
+	command, _ := parseCommand(msg.Text)
+	return command == "/maintenance"
+}
+
+// formatMaintenanceStatus describes maintenance for /status; empty when it is off
+func formatMaintenanceStatus() string {
+	maintenance.mu.Lock()
+	defer maintenance.mu.Unlock()
+	if maintenance.until.IsZero() {
+		return ""
+	}
+	return fmt.Sprintf("🛠 Режим обслуживания до %s, обновления копятся\n", maintenance.until.Format("15:04:05"))
+}
+
+// handleMaintenanceCommand implements /maintenance on [minutes] | off in a private chat
+func handleMaintenanceCommand(ctx context.Context, db *sql.DB, api echotron.API, message *echotron.Message, args []string) {
+	chatID := message.Chat.ID
+	if !isAdmin(message.From.ID) {
+		sendMessage(api, "❌ Доступ запрещен", chatID)
+		return
+	}
+
+	usage := fmt.Sprintf("Использование: /maintenance on [минуты] | off\n"+
+		"Пока включено (по умолчанию %d мин, максимум %d), бот не обрабатывает обновления, а копит их и "+
+		"обработает по порядку после выключения. Команды старше MAINTENANCE_COMMAND_TTL_MINUTES не выполняются.",
+		defaultMaintenanceMinutes, maxMaintenanceMinutes)
+	if len(args) == 0 {
+		sendMessage(api, usage, chatID)
+		return
+	}
+
+	switch args[0] {
+	case "on":
+		minutes := defaultMaintenanceMinutes
+		if len(args) > 1 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n <= 0 || n > maxMaintenanceMinutes {
+				sendMessage(api, usage, chatID)
+				return
+			}
+			minutes = n
+		}
+		until := maintenance.start(db, api, time.Duration(minutes)*time.Minute)
+
+		writeAudit(ctx, db, message.From.ID, "maintenance_on", 0, strconv.Itoa(minutes))
+		botEvent(log.Warn(), EventMaintenanceStarted).Int64("user_id", message.From.ID).Time("until", until).Msg("Maintenance started")
+		sendMessage(api, fmt.Sprintf("🛠 Режим обслуживания до %s. Обновления копятся и будут обработаны после "+
+			"/maintenance off или по истечении времени", until.Format("15:04:05")), chatID)
+	case "off":
+		if !maintenance.holding() {
+			sendMessage(api, "Режим обслуживания не включен", chatID)
+			return
+		}
+		writeAudit(ctx, db, message.From.ID, "maintenance_off", 0, "")
+		sendMessage(api, "✅ Обслуживание завершено, обрабатываю накопившиеся обновления", chatID)
+		go maintenance.end("admin")
+	default:
+		sendMessage(api, usage, chatID)
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"example.com/random_coffee/database"
+	"github.com/NicoNex/echotron/v3"
+)
+
+func TestWaitReleasedWhenMaintenanceIsOff(t *testing.T) {
+	done := make(chan bool, 1)
+	go func() { done <- maintenance.waitReleased(nil) }()
+	select {
+	case ok := <-done:
+		if !ok {
+			t.Fatal("waitReleased = false without maintenance")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("waitReleased blocked without maintenance")
+	}
+}
+
+func TestScheduledJobWaitsForReplay(t *testing.T) {
+	db := openTestDB(t)
+	_, api := newFakeTelegram(t)
+	ctx := context.Background()
+
+	maintenance.start(db, api, time.Hour)
+	t.Cleanup(func() { maintenance.end("test") })
+
+	// An update held back meanwhile, e.g. a sign-up the job must see
+	held := &echotron.Update{ID: 7, Message: &echotron.Message{Text: "hello", Chat: echotron.Chat{ID: 1, Type: "private"}, From: &echotron.User{ID: 1}}}
+	if !maintenance.hold(ctx, db, held) {
+		t.Fatal("update not held during maintenance")
+	}
+
+	released := make(chan int, 1)
+	go func() {
+		if !maintenance.waitReleased(nil) {
+			released <- -1
+			return
+		}
+		queued, _ := database.GetQueuedUpdates(ctx, db)
+		released <- len(queued)
+	}()
+
+	select {
+	case <-released:
+		t.Fatal("job released while maintenance is on")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	maintenance.end("admin")
+	select {
+	case left := <-released:
+		if left != 0 {
+			t.Fatalf("job released with %d updates still queued, want them replayed first", left)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("job still waiting after maintenance ended")
+	}
+}
+
+func TestWaitReleasedStops(t *testing.T) {
+	db := openTestDB(t)
+	_, api := newFakeTelegram(t)
+	maintenance.start(db, api, time.Hour)
+	t.Cleanup(func() { maintenance.end("test") })
+
+	stop := make(chan struct{})
+	done := make(chan bool, 1)
+	go func() { done <- maintenance.waitReleased(stop) }()
+	close(stop)
+	select {
+	case ok := <-done:
+		if ok {
+			t.Fatal("waitReleased = true after stop")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("waitReleased ignored stop")
+	}
+}
@@ -0,0 +1,425 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"example.com/random_coffee/database"
+	"github.com/NicoNex/echotron/v3"
+	"github.com/rs/zerolog/log"
+)
+
+// Callback data prefixes of the /manual_pairs preview; the group ID follows the colon
+const (
+	manualPairsConfirmCallback   = "manual_pairs_confirm"    // pairs count toward repeat exclusion
+	manualPairsNoHistoryCallback = "manual_pairs_no_history" // pairs are kept out of repeat exclusion
+	manualPairsCancelCallback    = "manual_pairs_cancel"
+
+	// manualPairsDraftTTL is how long a checked list waits for the admin's confirmation
+	manualPairsDraftTTL = 30 * time.Minute
+)
+
+// errManualPairsExist means the group got its pairs for the week while the draft waited for confirmation
+var errManualPairsExist = errors.New("group already has pairs this week")
+
+// manualPairsDraft is a checked list of meetings waiting for confirmation
+type manualPairsDraft struct {
+	groupID   int64
+	meetings  [][]database.Participant
+	createdAt time.Time
+}
+
+// manualPairsDrafts keeps each admin's latest draft. Drafts live in memory only: after a restart the
+// admin sends the list again.
+type manualPairsDrafts struct {
+	mu      sync.Mutex
+	byAdmin map[int64]manualPairsDraft
+}
+
+var manualDrafts = &manualPairsDrafts{byAdmin: make(map[int64]manualPairsDraft)}
+
+// put replaces the admin's draft
+func (d *manualPairsDrafts) put(adminID int64, draft manualPairsDraft) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.byAdmin[adminID] = draft
+}
+
+// get returns the admin's draft for the group unless it has expired
+func (d *manualPairsDrafts) get(adminID, groupID int64, now time.Time) (manualPairsDraft, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	draft, ok := d.byAdmin[adminID]
+	if !ok || draft.groupID != groupID || now.Sub(draft.createdAt) > manualPairsDraftTTL {
+		return manualPairsDraft{}, false
+	}
+	return draft, true
+}
+
+// drop forgets the admin's draft
+func (d *manualPairsDrafts) drop(adminID int64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.byAdmin, adminID)
+}
+
+// parseManualPairsLines reads one meeting per line, members separated by spaces, commas or semicolons
+func parseManualPairsLines(text string) [][]string {
+	var meetings [][]string
+	for _, line := range strings.Split(text, "\n") {
+		members := strings.FieldsFunc(line, func(r rune) bool {
+			return r == ' ' || r == '\t' || r == ',' || r == ';' || r == '\r'
+		})
+		if len(members) > 0 {
+			meetings = append(meetings, members)
+		}
+	}
+	return meetings
+}
+
+// isGroupMemberStatus reports whether a chat member with this status is in the group
+func isGroupMemberStatus(status string) bool {
+	return status == "creator" || status == "administrator" || status == "member" || status == "restricted"
+}
+
+// resolveManualMember finds who the admin meant by "@username" or a user ID and checks they are in the
+// group. It returns the problem in words when the member can't be used.
+func resolveManualMember(ctx context.Context, db *sql.DB, api echotron.API, groupID int64, token string) (database.Participant, string) {
+	p := database.Participant{GroupID: groupID}
+
+	if id, err := strconv.ParseInt(token, 10, 64); err == nil && id > 0 {
+		p.UserID = id
+		profiles, err := database.GetUserProfiles(ctx, db, []int64{id})
+		if err != nil {
+			return p, fmt.Sprintf("%s: не удалось прочитать профиль", token)
+		}
+		if u, ok := profiles[id]; ok {
+			p.Username, p.FullName = u.Username, u.FullName
+		}
+	} else {
+		username := strings.TrimPrefix(token, "@")
+		u, err := database.GetUserProfileByUsername(ctx, db, username)
+		if err != nil {
+			return p, fmt.Sprintf("%s: не удалось прочитать профиль", token)
+		}
+		if u == nil {
+			return p, fmt.Sprintf("%s: бот не знает такого пользователя, укажи его ID", token)
+		}
+		p.UserID, p.Username, p.FullName = u.UserID, u.Username, u.FullName
+	}
+
+	res, err := api.GetChatMember(groupID, p.UserID)
+	if err != nil || res.Result == nil {
+		return p, fmt.Sprintf("%s: не удалось проверить, состоит ли он в группе", token)
+	}
+	if !isGroupMemberStatus(res.Result.Status) {
+		return p, fmt.Sprintf("%s: не состоит в группе", token)
+	}
+	if user := res.Result.User; user != nil {
+		if user.IsBot {
+			return p, fmt.Sprintf("%s: это бот", token)
+		}
+		// Telegram knows the current name better than a stored profile
+		p.Username = user.Username
+		p.FullName = strings.TrimSpace(user.FirstName + " " + user.LastName)
+	}
+	if p.Username == "" && p.FullName == "" {
+		p.FullName = strconv.FormatInt(p.UserID, 10)
+	}
+	return p, ""
+}
+
+// checkManualPairs resolves and checks every member of the meetings. Problems block the run; notes, like
+// couples that have met before, are only shown.
+func checkManualPairs(ctx context.Context, db *sql.DB, api echotron.API, groupID int64, lines [][]string) (meetings [][]database.Participant, problems, notes []string) {
+	lineOf := make(map[int64]int)
+	var everyone []database.Participant
+
+	for i, line := range lines {
+		n := i + 1
+		if len(line) < 2 || len(line) > 3 {
+			problems = append(problems, fmt.Sprintf("строка %d: нужно 2 или 3 участника, указано %d", n, len(line)))
+			continue
+		}
+
+		meeting := make([]database.Participant, 0, len(line))
+		ok := true
+		for _, token := range line {
+			p, problem := resolveManualMember(ctx, db, api, groupID, token)
+			if problem != "" {
+				problems = append(problems, fmt.Sprintf("строка %d: %s", n, problem))
+				ok = false
+				continue
+			}
+			if first, seen := lineOf[p.UserID]; seen {
+				problems = append(problems, fmt.Sprintf("строка %d: %s уже есть в строке %d", n, getDisplayName(p), first))
+				ok = false
+				continue
+			}
+			// Someone who turned the bot's messages off is not signed up for anything by hand either
+			if userDMPreference(ctx, db, p.UserID) == dmPrefDisabled {
+				problems = append(problems, fmt.Sprintf("строка %d: %s отказался от сообщений бота (/notifications off)", n, getDisplayName(p)))
+				ok = false
+				continue
+			}
+			lineOf[p.UserID] = n
+			meeting = append(meeting, p)
+			everyone = append(everyone, p)
+		}
+		if ok {
+			meetings = append(meetings, meeting)
+		}
+	}
+	if len(problems) > 0 {
+		return meetings, problems, nil
+	}
+
+	// A personal exclusion is never overridden, not even by hand
+	avoided := loadAvoidedCouples(ctx, db, groupID, everyone)
+	met := make(map[[2]int64]bool)
+	if history, err := database.GetPairHistory(ctx, readerDB(db), groupID); err != nil {
+		groupEvent(log.Warn(), EventPairsQueryFailed, groupID).Err(err).Msg("GetPairHistory failed, repeats not checked")
+	} else {
+		for _, pair := range history {
+			if pair.IgnoreHistory && pair.WeekStart != getWeekStart(time.Now()) {
+				continue
+			}
+			members := pair.Members()
+			for i, a := range members {
+				for _, b := range members[i+1:] {
+					met[[2]int64{min(a, b), max(a, b)}] = true
+				}
+			}
+		}
+	}
+
+	for _, meeting := range meetings {
+		for i, a := range meeting {
+			for _, b := range meeting[i+1:] {
+				names := getDisplayName(a) + " и " + getDisplayName(b)
+				switch {
+				case avoided[[2]int64{a.UserID, b.UserID}]:
+					problems = append(problems, fmt.Sprintf("%s нельзя ставить вместе из-за личного исключения", names))
+				case met[[2]int64{min(a.UserID, b.UserID), max(a.UserID, b.UserID)}]:
+					notes = append(notes, fmt.Sprintf("%s уже встречались", names))
+				}
+			}
+		}
+	}
+
+	if exists, err := database.HasPairsSince(ctx, db, groupID, weekStartTime(time.Now())); err != nil {
+		problems = append(problems, "не удалось проверить, есть ли у группы пары на этой неделе")
+	} else if exists {
+		problems = append(problems, "у группы уже есть пары на этой неделе")
+	}
+	return meetings, problems, notes
+}
+
+// formatManualPairsPreview lists the meetings with the problems and notes found
+func formatManualPairsPreview(title string, meetings [][]database.Participant, problems, notes []string) string {
+	text := fmt.Sprintf("Пары для группы «%s»:\n\n", title)
+	for i, meeting := range meetings {
+		names := make([]string, 0, len(meeting))
+		for _, p := range meeting {
+			names = append(names, getDisplayName(p))
+		}
+		text += fmt.Sprintf("%d. %s\n", i+1, strings.Join(names, " ✖️ "))
+	}
+
+	if len(problems) > 0 {
+		text += "\n❗ Проблемы:\n"
+		for _, p := range problems {
+			text += "• " + p + "\n"
+		}
+		return text + "\nИсправь список и отправь команду заново."
+	}
+	if len(notes) > 0 {
+		text += "\nℹ️ Обрати внимание:\n"
+		for _, n := range notes {
+			text += "• " + n + "\n"
+		}
+	}
+	return text + fmt.Sprintf("\nПары будут сохранены, объявлены в группе и разосланы участникам в личку. "+
+		"Если не учитывать их в истории, эти люди смогут снова попасть в пару со следующей недели. "+
+		"Подтверждение действует %d минут.", int(manualPairsDraftTTL.Minutes()))
+}
+
+// handleManualPairsCommand implements /manual_pairs <group_id> in a private chat, followed by one pair
+// or trio per line. The list is checked and shown with buttons to announce it.
+func handleManualPairsCommand(ctx context.Context, db *sql.DB, api echotron.API, message *echotron.Message) {
+	chatID := message.Chat.ID
+	if !isAdmin(message.From.ID) {
+		sendMessage(api, "❌ Доступ запрещен", chatID)
+		return
+	}
+
+	usage := "Использование:\n/manual_pairs <group_id>\n@alice @bob\n@carol 123456789\n@dave @erin @frank\n\n" +
+		"Каждая строка - пара или тройка, участники по @username или ID"
+	header, body, _ := strings.Cut(message.Text, "\n")
+	_, args := parseCommand(header)
+	if len(args) != 1 {
+		sendMessage(api, usage, chatID)
+		return
+	}
+	groupID, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil || !isConfiguredGroup(ctx, db, groupID) {
+		sendMessage(api, "❌ Укажи ID группы из списка подключенных групп\n\n"+usage, chatID)
+		return
+	}
+	lines := parseManualPairsLines(body)
+	if len(lines) == 0 {
+		sendMessage(api, "❌ Нет ни одной пары\n\n"+usage, chatID)
+		return
+	}
+
+	meetings, problems, notes := checkManualPairs(ctx, db, api, groupID, lines)
+	preview := formatManualPairsPreview(groupTitle(ctx, db, groupID), meetings, problems, notes)
+	groupEvent(log.Info(), EventManualPairsChecked, groupID).Int64("user_id", message.From.ID).Int("meetings", len(meetings)).
+		Int("problems", len(problems)).Msg("Manual pairs checked")
+
+	if len(problems) > 0 {
+		manualDrafts.drop(message.From.ID)
+		sendLongMessage(api, preview, chatID)
+		return
+	}
+
+	manualDrafts.put(message.From.ID, manualPairsDraft{groupID: groupID, meetings: meetings, createdAt: time.Now()})
+	opts := &echotron.MessageOptions{
+		ReplyMarkup: echotron.InlineKeyboardMarkup{InlineKeyboard: [][]echotron.InlineKeyboardButton{
+			{{Text: "✅ Объявить", CallbackData: fmt.Sprintf("%s:%d", manualPairsConfirmCallback, groupID)}},
+			{{Text: "✅ Объявить, не учитывая в истории", CallbackData: fmt.Sprintf("%s:%d", manualPairsNoHistoryCallback, groupID)}},
+			{{Text: "Отмена", CallbackData: fmt.Sprintf("%s:%d", manualPairsCancelCallback, groupID)}},
+		}},
+	}
+	if _, err := api.SendMessage(preview, chatID, opts); err != nil {
+		botEvent(log.Warn(), EventManualPairsFailed).Err(err).Int64("chat_id", chatID).Msg("Failed to send manual pairs preview")
+	}
+}
+
+// handleManualPairsCallback announces or drops the admin's draft
+func handleManualPairsCallback(ctx context.Context, db *sql.DB, api echotron.API, cq *echotron.CallbackQuery, action, arg string) {
+	groupID, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil || cq.From == nil {
+		answerCallback(api, cq, "")
+		return
+	}
+	if !isAdmin(cq.From.ID) {
+		answerCallback(api, cq, "❌ Доступ запрещен")
+		return
+	}
+
+	if action == manualPairsCancelCallback {
+		manualDrafts.drop(cq.From.ID)
+		answerCallback(api, cq, "")
+		editCallbackMessage(api, cq, "Отменено, пары не объявлены.", nil)
+		return
+	}
+
+	draft, ok := manualDrafts.get(cq.From.ID, groupID, time.Now())
+	if !ok {
+		answerCallback(api, cq, "")
+		editCallbackMessage(api, cq, "⌛ Подтверждение устарело. Отправь /manual_pairs со списком заново.", nil)
+		return
+	}
+
+	ignoreHistory := action == manualPairsNoHistoryCallback
+	var runErr error
+	// Guarded like /create_pairs, so the group never gets two sets of pairs at once
+	_, started := runGuarded(jobCreatePairs, groupID, func() {
+		// The scheduler may have created pairs since the preview
+		exists, err := database.HasPairsSince(ctx, db, groupID, weekStartTime(time.Now()))
+		switch {
+		case err != nil:
+			runErr = err
+		case exists:
+			runErr = errManualPairsExist
+		default:
+			runErr = runManualPairs(ctx, db, api, draft, ignoreHistory)
+		}
+	})
+	if !started {
+		answerCallback(api, cq, "⏳ В группе сейчас создаются пары, попробуй через минуту")
+		return
+	}
+	if errors.Is(runErr, errManualPairsExist) {
+		manualDrafts.drop(cq.From.ID)
+		answerCallback(api, cq, "")
+		editCallbackMessage(api, cq, "❌ У группы уже есть пары на этой неделе, эти пары не объявлены.", nil)
+		return
+	}
+	if runErr != nil {
+		groupEvent(log.Error(), EventManualPairsFailed, groupID).Err(runErr).Msg("Manual pairs failed")
+		answerCallback(api, cq, "❌ Не удалось сохранить пары")
+		return
+	}
+
+	manualDrafts.drop(cq.From.ID)
+	details := fmt.Sprintf("%d meetings", len(draft.meetings))
+	if ignoreHistory {
+		details += ", history ignored"
+	}
+	writeAudit(ctx, db, cq.From.ID, "manual_pairs", groupID, details)
+	answerCallback(api, cq, "✅ Готово")
+	editCallbackMessage(api, cq, fmt.Sprintf("✅ Пары (%d) объявлены в группе «%s»", len(draft.meetings), groupTitle(ctx, db, groupID)), nil)
+}
+
+// runManualPairs stores the draft as this week's pairs of the group, announces them and tells every
+// member who they meet. The week's sign-up is closed as after a regular run, and the scheduled run
+// skips the group since it already has pairs.
+func runManualPairs(ctx context.Context, db *sql.DB, api echotron.API, draft manualPairsDraft, ignoreHistory bool) error {
+	groupID := draft.groupID
+	pairs := pairRows(draft.meetings, groupID)
+	for i := range pairs {
+		pairs[i].Manual = true
+		pairs[i].IgnoreHistory = ignoreHistory
+	}
+	if err := database.CreatePairs(ctx, db, pairs); err != nil {
+		return err
+	}
+	pollMapping, stoppedPoll := stopSignups(ctx, db, api, groupID)
+	signedUp, err := database.GetAllParticipants(ctx, db, groupID)
+	if err != nil {
+		groupEvent(log.Warn(), EventPairsQueryFailed, groupID).Err(err).Msg("GetAllParticipants failed")
+	} else if stoppedPoll != nil {
+		reconcileClosedPoll(ctx, db, api, pollMapping, stoppedPoll, len(signedUp))
+	}
+
+	theme := groupCycleTheme(ctx, db, groupID)
+	message := formatPairsAnnouncement(defaultAnnouncementTemplate, theme, draft.meetings)
+	message += dmCallToAction(groupDMPolicy(ctx, db, groupID))
+	sendAnnouncementMedia(ctx, db, api, groupID)
+	sendLongMessage(api, message, groupID)
+
+	title := groupTitle(ctx, db, groupID)
+	for _, meeting := range draft.meetings {
+		for _, p := range meeting {
+			partners := make([]string, 0, len(meeting)-1)
+			for _, q := range meeting {
+				if q.UserID != p.UserID {
+					partners = append(partners, getDisplayName(q))
+				}
+			}
+			sendGroupDM(ctx, db, api, groupID, p.UserID, fmt.Sprintf("☕️ Твоя встреча Random Coffee на этой неделе в группе «%s»: %s",
+				title, strings.Join(partners, ", ")))
+			dashboards.schedule(p.UserID)
+		}
+	}
+	closeSignups(ctx, db, api, groupID, pollMapping)
+	clearCycleTheme(ctx, db, groupID, theme)
+
+	// Whoever signed up through the poll sits this week out unless the admin listed them
+	for _, p := range signedUp {
+		dashboards.schedule(p.UserID)
+	}
+
+	countOps(counterRunManual)
+	cycleEvent(log.Info(), EventManualPairsCreated, groupID, getWeekStart(time.Now())).Int("pairs_count", len(pairs)).
+		Bool("ignore_history", ignoreHistory).Msg("Manual pairs created")
+	return nil
+}
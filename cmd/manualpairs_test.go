@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"example.com/random_coffee/database"
+	"github.com/NicoNex/echotron/v3"
+	"github.com/google/uuid"
+)
+
+const (
+	testGroupID = int64(-100)
+	testAdminID = int64(42)
+)
+
+// setupManualPairsGroup registers a group whose members are users 1 to 6, each known by @userN
+func setupManualPairsGroup(t *testing.T) (*sql.DB, *fakeTelegram, echotron.API) {
+	t.Helper()
+	db := openTestDB(t)
+	tg, api := newFakeTelegram(t)
+	withAdmins(t, testAdminID)
+	seedTestGroup(t, db, testGroupID, "Coffee")
+
+	ctx := context.Background()
+	for id := int64(1); id <= 7; id++ {
+		u := database.UserProfile{UserID: id, Username: fmt.Sprintf("user%d", id), FullName: "User", UpdatedAt: time.Now()}
+		if err := database.UpsertUserProfile(ctx, db, u); err != nil {
+			t.Fatalf("UpsertUserProfile: %v", err)
+		}
+		if id <= 6 {
+			tg.setMember(testGroupID, id, "member")
+		}
+	}
+	return db, tg, api
+}
+
+func signUp(t *testing.T, db *sql.DB, groupID int64, ids ...int64) {
+	t.Helper()
+	for _, id := range ids {
+		p := database.Participant{ID: uuid.New(), GroupID: groupID, UserID: id, Username: "user", CreatedAt: time.Now()}
+		if err := database.CreateOrUpdateParticipant(context.Background(), db, p); err != nil {
+			t.Fatalf("CreateOrUpdateParticipant: %v", err)
+		}
+	}
+}
+
+func TestParseManualPairsLines(t *testing.T) {
+	got := parseManualPairsLines("@a @b\n\n  \n1, 2;3\r\n@c\t@d")
+	want := [][]string{{"@a", "@b"}, {"1", "2", "3"}, {"@c", "@d"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("parseManualPairsLines = %q, want %q", got, want)
+	}
+}
+
+func TestCheckManualPairsProblems(t *testing.T) {
+	db, _, api := setupManualPairsGroup(t)
+	ctx := context.Background()
+
+	if err := database.SetDMPreference(ctx, db, 4, false); err != nil {
+		t.Fatalf("SetDMPreference: %v", err)
+	}
+	t.Setenv("AVOID_SECRET", "test")
+	secret := avoidSecret()
+	if err := database.AddAvoidance(ctx, db, testGroupID, avoidOwnerHash(secret, testGroupID, 1), avoidPairHash(secret, testGroupID, 1, 5)); err != nil {
+		t.Fatalf("AddAvoidance: %v", err)
+	}
+
+	tests := []struct {
+		name, list, problem string
+	}{
+		{"single member", "@user1", "нужно 2 или 3 участника"},
+		{"too many members", "@user1 @user2 @user3 @user6", "нужно 2 или 3 участника"},
+		{"unknown username", "@user1 @nobody", "бот не знает такого пользователя"},
+		{"not in group", "@user1 7", "не состоит в группе"},
+		{"listed twice", "@user1 @user2\n@user3 @user1", "уже есть в строке 1"},
+		{"opted out", "@user1 @user4", "отказался от сообщений бота"},
+		{"avoided couple", "@user1 @user5", "личного исключения"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, problems, _ := checkManualPairs(ctx, db, api, testGroupID, parseManualPairsLines(tt.list))
+			if !strings.Contains(strings.Join(problems, "\n"), tt.problem) {
+				t.Fatalf("problems = %q, want one mentioning %q", problems, tt.problem)
+			}
+		})
+	}
+}
+
+func TestCheckManualPairsNotesRepeats(t *testing.T) {
+	db, _, api := setupManualPairsGroup(t)
+	ctx := context.Background()
+
+	lastWeek := time.Now().AddDate(0, 0, -7)
+	past := database.Pair{ID: uuid.New(), GroupID: testGroupID, WeekStart: getWeekStart(lastWeek), User1ID: 1, User2ID: 2, CreatedAt: lastWeek}
+	if err := database.CreatePairs(ctx, db, []database.Pair{past}); err != nil {
+		t.Fatalf("CreatePairs: %v", err)
+	}
+
+	meetings, problems, notes := checkManualPairs(ctx, db, api, testGroupID, parseManualPairsLines("@user1 @user2\n3 @user6"))
+	if len(problems) > 0 {
+		t.Fatalf("problems = %q, want none", problems)
+	}
+	if len(meetings) != 2 || meetings[1][0].UserID != 3 || meetings[1][1].UserID != 6 {
+		t.Fatalf("meetings = %+v", meetings)
+	}
+	if len(notes) != 1 || !strings.Contains(notes[0], "уже встречались") {
+		t.Fatalf("notes = %q, want the repeat of users 1 and 2", notes)
+	}
+}
+
+func TestCheckManualPairsRejectsWeekWithPairs(t *testing.T) {
+	db, _, api := setupManualPairsGroup(t)
+	ctx := context.Background()
+
+	if err := savePairsToDatabase(ctx, db, [][]database.Participant{{{UserID: 1}, {UserID: 2}}}, testGroupID); err != nil {
+		t.Fatalf("savePairsToDatabase: %v", err)
+	}
+	_, problems, _ := checkManualPairs(ctx, db, api, testGroupID, parseManualPairsLines("@user3 @user6"))
+	if !strings.Contains(strings.Join(problems, "\n"), "уже есть пары на этой неделе") {
+		t.Fatalf("problems = %q, want the existing pairs reported", problems)
+	}
+}
+
+// manualPairsCallback presses a button of the preview sent to the admin
+func manualPairsCallback(ctx context.Context, db *sql.DB, api echotron.API, action string) {
+	cq := &echotron.CallbackQuery{
+		ID:      "cb",
+		From:    &echotron.User{ID: testAdminID},
+		Message: &echotron.Message{ID: 5, Chat: echotron.Chat{ID: testAdminID}},
+	}
+	handleManualPairsCallback(ctx, db, api, cq, action, "-100")
+}
+
+func TestManualPairsConfirm(t *testing.T) {
+	db, tg, api := setupManualPairsGroup(t)
+	ctx := context.Background()
+	signUp(t, db, testGroupID, 1, 2, 5)
+
+	handleManualPairsCommand(ctx, db, api, &echotron.Message{
+		Text: "/manual_pairs -100\n@user1 @user2\n@user3 @user6",
+		From: &echotron.User{ID: testAdminID},
+		Chat: echotron.Chat{ID: testAdminID},
+	})
+	if previews := tg.sent(testAdminID); len(previews) != 1 || !strings.Contains(previews[0], "Пары для группы «Coffee»") {
+		t.Fatalf("admin got %q, want the preview", previews)
+	}
+
+	manualPairsCallback(ctx, db, api, manualPairsConfirmCallback)
+
+	history, err := database.GetPairHistory(ctx, db, testGroupID)
+	if err != nil {
+		t.Fatalf("GetPairHistory: %v", err)
+	}
+	if len(history) != 2 {
+		t.Fatalf("stored %d pairs, want 2", len(history))
+	}
+	for _, p := range history {
+		if !p.Manual || p.IgnoreHistory || p.WeekStart != getWeekStart(time.Now()) {
+			t.Fatalf("stored %+v, want a manual pair of this week counted in history", p)
+		}
+	}
+	if got := tg.sent(testGroupID); len(got) != 1 {
+		t.Fatalf("group got %d messages, want the announcement", len(got))
+	}
+	for _, id := range []int64{1, 2, 3, 6} {
+		if dms := tg.sent(id); len(dms) != 1 || !strings.Contains(dms[0], "Твоя встреча") {
+			t.Fatalf("user %d got %q, want their meeting", id, dms)
+		}
+	}
+	if n, err := database.CountParticipants(ctx, db, testGroupID); err != nil || n != 0 {
+		t.Fatalf("CountParticipants = %d, %v; want the sign-up closed", n, err)
+	}
+
+	// The scheduled run of the week leaves the group alone
+	signUp(t, db, testGroupID, 4, 5)
+	CreatePairs(ctx, db, api, testGroupID)
+	if history, _ := database.GetPairHistory(ctx, db, testGroupID); len(history) != 2 {
+		t.Fatalf("stored %d pairs after the scheduled run, want 2", len(history))
+	}
+	if got := tg.sent(testGroupID); !strings.Contains(got[len(got)-1], "уже составлены") {
+		t.Fatalf("group got %q, want the run skipped", got[len(got)-1])
+	}
+}
+
+func TestManualPairsConfirmWithoutHistory(t *testing.T) {
+	db, _, api := setupManualPairsGroup(t)
+	ctx := context.Background()
+
+	meetings, problems, _ := checkManualPairs(ctx, db, api, testGroupID, parseManualPairsLines("@user1 @user2"))
+	if len(problems) > 0 {
+		t.Fatalf("problems = %q", problems)
+	}
+	manualDrafts.put(testAdminID, manualPairsDraft{groupID: testGroupID, meetings: meetings, createdAt: time.Now()})
+	manualPairsCallback(ctx, db, api, manualPairsNoHistoryCallback)
+
+	history, err := database.GetPairHistory(ctx, db, testGroupID)
+	if err != nil || len(history) != 1 || !history[0].IgnoreHistory {
+		t.Fatalf("GetPairHistory = %+v, %v; want one pair kept out of history", history, err)
+	}
+}
+
+func TestManualPairsConfirmAfterScheduledRun(t *testing.T) {
+	db, tg, api := setupManualPairsGroup(t)
+	ctx := context.Background()
+
+	meetings, problems, _ := checkManualPairs(ctx, db, api, testGroupID, parseManualPairsLines("@user1 @user2"))
+	if len(problems) > 0 {
+		t.Fatalf("problems = %q", problems)
+	}
+	manualDrafts.put(testAdminID, manualPairsDraft{groupID: testGroupID, meetings: meetings, createdAt: time.Now()})
+
+	// Pairs created by the scheduler while the preview waited
+	if err := savePairsToDatabase(ctx, db, [][]database.Participant{{{UserID: 3}, {UserID: 6}}}, testGroupID); err != nil {
+		t.Fatalf("savePairsToDatabase: %v", err)
+	}
+	manualPairsCallback(ctx, db, api, manualPairsConfirmCallback)
+
+	if history, _ := database.GetPairHistory(ctx, db, testGroupID); len(history) != 1 {
+		t.Fatalf("stored %d pairs, want only the scheduled one", len(history))
+	}
+	if got := tg.sent(testGroupID); len(got) != 0 {
+		t.Fatalf("group got %q, want no second announcement", got)
+	}
+}
+
+func TestManualPairsExpiredDraft(t *testing.T) {
+	db, _, api := setupManualPairsGroup(t)
+	ctx := context.Background()
+
+	manualDrafts.put(testAdminID, manualPairsDraft{groupID: testGroupID, meetings: [][]database.Participant{{{UserID: 1}, {UserID: 2}}},
+		createdAt: time.Now().Add(-manualPairsDraftTTL - time.Minute)})
+	manualPairsCallback(ctx, db, api, manualPairsConfirmCallback)
+
+	if history, _ := database.GetPairHistory(ctx, db, testGroupID); len(history) != 0 {
+		t.Fatalf("stored %d pairs from an expired draft", len(history))
+	}
+}
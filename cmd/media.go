@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"example.com/random_coffee/database"
+	"github.com/NicoNex/echotron/v3"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// announcementMediaSetting is the group setting with the pairs announcement media, "photo:<file_id>" or "sticker:<file_id>"
+	announcementMediaSetting = "announcement_media"
+
+	// announcementMediaPendingSetting holds "<admin ID>:<media version>": the admin whose next photo or sticker
+	// becomes the media, and the media setting's version when they asked, so a concurrent change isn't overwritten
+	announcementMediaPendingSetting = "announcement_media_pending"
+
+	mediaPhoto   = "photo"
+	mediaSticker = "sticker"
+)
+
+// isStaleFileError reports whether Telegram no longer accepts a stored file_id
+func isStaleFileError(err error) bool {
+	errStr := strings.ToLower(err.Error())
+	return strings.Contains(errStr, "wrong file identifier") ||
+		strings.Contains(errStr, "wrong remote file identifier")
+}
+
+// sendAnnouncementMedia posts the group's announcement media, if any. It is best-effort:
+// failures are logged and never block the announcement text.
+func sendAnnouncementMedia(ctx context.Context, db *sql.DB, api echotron.API, groupID int64) {
+	value, found, err := database.GetGroupSetting(ctx, db, groupID, announcementMediaSetting)
+	if err != nil {
+		groupEvent(log.Warn(), EventSettingsReadFailed, groupID).Err(err).Str("key", announcementMediaSetting).Msg("GetGroupSetting failed")
+		return
+	}
+	if !found {
+		return
+	}
+
+	kind, fileID, _ := strings.Cut(value, ":")
+	switch kind {
+	case mediaPhoto:
+		_, err = api.SendPhoto(echotron.NewInputFileID(fileID), groupID, nil)
+	case mediaSticker:
+		_, err = api.SendSticker(fileID, groupID, nil)
+	default:
+		groupEvent(log.Warn(), EventMediaFailed, groupID).Str("value", value).Msg("Unknown announcement media kind")
+		return
+	}
+	if err == nil {
+		return
+	}
+
+	groupEvent(log.Warn(), EventMediaFailed, groupID).Err(err).Str("kind", kind).Msg("Failed to send announcement media")
+	if !isStaleFileError(err) {
+		return
+	}
+
+	// File IDs can expire; drop it so every following announcement doesn't fail the same way
+	if err := database.DeleteGroupSetting(ctx, db, groupID, announcementMediaSetting); err != nil {
+		groupEvent(log.Error(), EventSettingsSaveFailed, groupID).Err(err).Str("key", announcementMediaSetting).Msg("DeleteGroupSetting failed")
+		return
+	}
+	groupEvent(log.Info(), EventMediaCleared, groupID).Msg("Stale announcement media cleared")
+	notifyAdmins(api, fmt.Sprintf("🖼 Картинка для анонса пар в группе %s больше недоступна в Telegram и была удалена. "+
+		"Задай новую командой /set_announcement_media в группе", groupTitle(ctx, db, groupID)))
+}
+
+// handleSetAnnouncementMediaCommand implements /set_announcement_media: the admin's next photo or sticker
+// in the group becomes the media posted before every pairs announcement
+func handleSetAnnouncementMediaCommand(ctx context.Context, db *sql.DB, api echotron.API, message *echotron.Message) {
+	groupID := message.Chat.ID
+
+	_, version, err := database.GetGroupSettingVersion(ctx, db, groupID, announcementMediaSetting)
+	if err != nil {
+		groupEvent(log.Error(), EventSettingsReadFailed, groupID).Err(err).Str("key", announcementMediaSetting).Msg("GetGroupSettingVersion failed")
+		sendMessage(api, "❌ Не удалось прочитать настройку", groupID)
+		return
+	}
+
+	pending := fmt.Sprintf("%d:%d", message.From.ID, version)
+	if err := database.SetGroupSetting(ctx, db, groupID, announcementMediaPendingSetting, pending); err != nil {
+		groupEvent(log.Error(), EventSettingsSaveFailed, groupID).Err(err).Str("key", announcementMediaPendingSetting).Msg("SetGroupSetting failed")
+		sendMessage(api, "❌ Не удалось сохранить настройку", groupID)
+		return
+	}
+	sendMessage(api, "🖼 Пришли следующим сообщением фото или стикер - он будет появляться перед анонсом пар", groupID)
+}
+
+// handleClearAnnouncementMediaCommand implements /clear_announcement_media
+func handleClearAnnouncementMediaCommand(ctx context.Context, db *sql.DB, api echotron.API, message *echotron.Message) {
+	groupID := message.Chat.ID
+
+	for _, key := range []string{announcementMediaSetting, announcementMediaPendingSetting} {
+		if err := database.DeleteGroupSetting(ctx, db, groupID, key); err != nil {
+			groupEvent(log.Error(), EventSettingsSaveFailed, groupID).Err(err).Str("key", key).Msg("DeleteGroupSetting failed")
+			sendMessage(api, "❌ Не удалось сохранить настройку", groupID)
+			return
+		}
+	}
+
+	writeAudit(ctx, db, message.From.ID, "clear_announcement_media", groupID, "")
+	sendMessage(api, "✅ Анонс пар снова будет без картинки", groupID)
+}
+
+// handleAnnouncementMediaUpload stores a photo or sticker sent after /set_announcement_media by the same admin.
+// It reports whether the message was taken as the announcement media.
+func handleAnnouncementMediaUpload(ctx context.Context, db *sql.DB, api echotron.API, message *echotron.Message) bool {
+	groupID := message.Chat.ID
+
+	var value string
+	switch {
+	case len(message.Photo) > 0:
+		// Sizes are sorted ascending, the last one is the original
+		value = mediaPhoto + ":" + message.Photo[len(message.Photo)-1].FileID
+	case message.Sticker != nil:
+		value = mediaSticker + ":" + message.Sticker.FileID
+	default:
+		return false
+	}
+
+	pending, found, err := database.GetGroupSetting(ctx, db, groupID, announcementMediaPendingSetting)
+	if err != nil {
+		groupEvent(log.Warn(), EventSettingsReadFailed, groupID).Err(err).Str("key", announcementMediaPendingSetting).Msg("GetGroupSetting failed")
+		return false
+	}
+	adminID, baseVersion, versioned := strings.Cut(pending, ":")
+	if !found || adminID != strconv.FormatInt(message.From.ID, 10) {
+		return false
+	}
+
+	// Requests made before versions were tracked are written unconditionally
+	if versioned {
+		version, convErr := strconv.Atoi(baseVersion)
+		if convErr != nil {
+			groupEvent(log.Warn(), EventSettingsReadFailed, groupID).Str("value", pending).Msg("Malformed pending announcement media")
+			return false
+		}
+		err = database.SetGroupSettingIfVersion(ctx, db, groupID, announcementMediaSetting, value, version)
+	} else {
+		err = database.SetGroupSetting(ctx, db, groupID, announcementMediaSetting, value)
+	}
+	if err != nil && !errors.Is(err, database.ErrSettingConflict) {
+		groupEvent(log.Error(), EventSettingsSaveFailed, groupID).Err(err).Str("key", announcementMediaSetting).Msg("SetGroupSetting failed")
+		sendMessage(api, "❌ Не удалось сохранить картинку", groupID)
+		return true
+	}
+	if err := database.DeleteGroupSetting(ctx, db, groupID, announcementMediaPendingSetting); err != nil {
+		groupEvent(log.Warn(), EventSettingsSaveFailed, groupID).Err(err).Str("key", announcementMediaPendingSetting).Msg("DeleteGroupSetting failed")
+	}
+
+	if err != nil {
+		// Someone changed the media after this admin's /set_announcement_media: show what is set now
+		groupEvent(log.Warn(), EventSettingsConflict, groupID).Str("key", announcementMediaSetting).Int64("user_id", message.From.ID).
+			Msg("Announcement media changed concurrently, upload not saved")
+		sendMessage(api, "⚠️ Настройки изменились, пока ты редактировал - вот актуальное значение. "+
+			"Чтобы все равно заменить его, вызови /set_announcement_media заново", groupID)
+		if _, found, _ := database.GetGroupSetting(ctx, db, groupID, announcementMediaSetting); found {
+			sendAnnouncementMedia(ctx, db, api, groupID)
+		} else {
+			sendMessage(api, "Сейчас анонс пар без картинки", groupID)
+		}
+		return true
+	}
+
+	kind, _, _ := strings.Cut(value, ":")
+	writeAudit(ctx, db, message.From.ID, "set_announcement_media", groupID, kind)
+	groupEvent(log.Info(), EventMediaSet, groupID).Str("kind", kind).Msg("Announcement media set")
+	sendMessage(api, "✅ Готово! Это будет появляться перед анонсом пар. Убрать - /clear_announcement_media", groupID)
+	return true
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	"example.com/random_coffee/database"
+	"github.com/NicoNex/echotron/v3"
+)
+
+// chatCalls returns the methods called for the chat, in order
+func (f *fakeTelegram) chatCalls(chatID int64) []fakeCall {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var calls []fakeCall
+	for _, c := range f.calls {
+		if c.params.Get("chat_id") == strconv.FormatInt(chatID, 10) {
+			calls = append(calls, c)
+		}
+	}
+	return calls
+}
+
+// setupAnnouncementMedia sets a photo as the test group's announcement media the way an admin does
+func setupAnnouncementMedia(t *testing.T) (*fakeTelegram, echotron.API, func() (string, bool)) {
+	t.Helper()
+	db, tg, api := setupManualPairsGroup(t)
+	ctx := context.Background()
+
+	if reply := groupCommand(t, db, tg, api, "/set_announcement_media"); !strings.Contains(reply, "Пришли следующим сообщением") {
+		t.Fatalf("/set_announcement_media: %q", reply)
+	}
+	// A photo from someone else is not taken
+	HandleGroupCommand(ctx, db, api, &echotron.Message{Chat: echotron.Chat{ID: testGroupID, Type: "supergroup"},
+		From: &echotron.User{ID: 1}, Photo: []*echotron.PhotoSize{{FileID: "other"}}})
+	HandleGroupCommand(ctx, db, api, &echotron.Message{Chat: echotron.Chat{ID: testGroupID, Type: "supergroup"},
+		From: &echotron.User{ID: testAdminID}, Photo: []*echotron.PhotoSize{{FileID: "thumb"}, {FileID: "original"}}})
+
+	media := func() (string, bool) {
+		value, found, err := database.GetGroupSetting(ctx, db, testGroupID, announcementMediaSetting)
+		if err != nil {
+			t.Fatalf("GetGroupSetting: %v", err)
+		}
+		return value, found
+	}
+	if value, _ := media(); value != "photo:original" {
+		t.Fatalf("media = %q, want the admin's photo in its original size", value)
+	}
+	if sent := tg.sent(testGroupID); !strings.Contains(sent[len(sent)-1], "✅ Готово") {
+		t.Fatalf("group got %q, want the media confirmed", sent[len(sent)-1])
+	}
+
+	signUp(t, db, testGroupID, 1, 2)
+	return tg, api, func() (string, bool) {
+		CreatePairs(ctx, db, api, testGroupID)
+		return media()
+	}
+}
+
+func TestAnnouncementMediaGoesBeforeThePairs(t *testing.T) {
+	tg, _, pair := setupAnnouncementMedia(t)
+	pair()
+
+	photo, announcement := -1, -1
+	for i, c := range tg.chatCalls(testGroupID) {
+		switch {
+		case c.method == "sendPhoto" && c.params.Get("photo") == "original":
+			photo = i
+		case c.method == "sendMessage" && strings.Contains(c.params.Get("text"), "✖️"):
+			announcement = i
+		}
+	}
+	if photo == -1 || announcement == -1 || photo > announcement {
+		t.Fatalf("photo sent at %d, announcement at %d; want the photo first", photo, announcement)
+	}
+}
+
+func TestAnnouncementMediaFailures(t *testing.T) {
+	tests := []struct {
+		name      string
+		reply     string
+		wantKept  bool
+		wantAdmin bool
+	}{
+		{"telegram down", `{"ok":false,"error_code":502,"description":"Bad Gateway"}`, true, false},
+		{"stale file id", `{"ok":false,"error_code":400,"description":"Bad Request: wrong file identifier/HTTP URL specified"}`, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tg, _, pair := setupAnnouncementMedia(t)
+			tg.reply("sendPhoto", func(url.Values) string { return tt.reply })
+
+			value, kept := pair()
+
+			// The announcement goes out whatever happened to the media
+			announced := false
+			for _, text := range tg.sent(testGroupID) {
+				announced = announced || strings.Contains(text, "✖️")
+			}
+			if !announced {
+				t.Fatal("announcement blocked by the media failure")
+			}
+			if kept != tt.wantKept || (kept && value != "photo:original") {
+				t.Fatalf("media after the failure = %q, %v; want kept %v", value, kept, tt.wantKept)
+			}
+			notices := 0
+			for _, text := range tg.sent(testAdminID) {
+				if strings.Contains(text, "больше недоступна") {
+					notices++
+				}
+			}
+			if (notices == 1) != tt.wantAdmin || notices > 1 {
+				t.Fatalf("admin got %d notices about the media, want %v", notices, tt.wantAdmin)
+			}
+		})
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"example.com/random_coffee/database"
+	"github.com/NicoNex/echotron/v3"
+	"github.com/rs/zerolog/log"
+)
+
+// myDataCooldown limits how often a user can request their data export
+const myDataCooldown = 10 * time.Minute
+
+// myDataSchemaVersion is bumped whenever the JSON export format changes
+const myDataSchemaVersion = 1
+
+// MyDataExport is everything the bot stores about one user.
+// Other users appear only by display name, never by ID.
+type MyDataExport struct {
+	SchemaVersion int                   `json:"schema_version"`
+	GeneratedAt   time.Time             `json:"generated_at"`
+	Profile       *MyDataProfile        `json:"profile"`
+	Participation []MyDataParticipation `json:"participation"`
+	Pairs         []MyDataPair          `json:"pairs"`
+}
+
+type MyDataProfile struct {
+	UserID    int64     `json:"user_id"`
+	Username  string    `json:"username"`
+	FullName  string    `json:"full_name"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// MyDataParticipation is a sign-up for the currently open cycle of a group
+type MyDataParticipation struct {
+	GroupID    int64     `json:"group_id"`
+	SignedUpAt time.Time `json:"signed_up_at"`
+}
+
+type MyDataPair struct {
+	GroupID   int64  `json:"group_id"`
+	WeekStart string `json:"week_start"`
+	Partner   string `json:"partner"` // both partners, comma-separated, for a trio
+}
+
+// requestLimiter allows one request per user within a cooldown period
+type requestLimiter struct {
+	mu       sync.Mutex
+	cooldown time.Duration
+	last     map[int64]time.Time
+}
+
+func newRequestLimiter(cooldown time.Duration) *requestLimiter {
+	return &requestLimiter{cooldown: cooldown, last: make(map[int64]time.Time)}
+}
+
+// allow records the request and reports whether it is permitted; otherwise it returns the remaining wait
+func (l *requestLimiter) allow(userID int64) (time.Duration, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if last, ok := l.last[userID]; ok && now.Sub(last) < l.cooldown {
+		return l.cooldown - now.Sub(last), false
+	}
+	l.last[userID] = now
+	return 0, true
+}
+
+var myDataLimiter = newRequestLimiter(myDataCooldown)
+
+// buildMyDataExport collects everything stored about the user; lang names partners the bot no longer knows
+func buildMyDataExport(ctx context.Context, db *sql.DB, userID int64, lang string) (*MyDataExport, error) {
+	participations, err := database.GetParticipationsByUser(ctx, db, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get participations: %w", err)
+	}
+
+	pairs, err := database.GetPairsByUser(ctx, db, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pairs: %w", err)
+	}
+
+	userIDs := []int64{userID}
+	for _, p := range pairs {
+		userIDs = append(userIDs, partnerIDs(p, userID)...)
+	}
+
+	profiles, err := database.GetUserProfiles(ctx, db, userIDs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get profiles: %w", err)
+	}
+
+	export := &MyDataExport{
+		SchemaVersion: myDataSchemaVersion,
+		GeneratedAt:   time.Now(),
+		Participation: make([]MyDataParticipation, 0, len(participations)),
+		Pairs:         make([]MyDataPair, 0, len(pairs)),
+	}
+
+	if u, ok := profiles[userID]; ok {
+		export.Profile = &MyDataProfile{UserID: u.UserID, Username: u.Username, FullName: u.FullName, UpdatedAt: u.UpdatedAt}
+	}
+
+	for _, p := range participations {
+		export.Participation = append(export.Participation, MyDataParticipation{GroupID: p.GroupID, SignedUpAt: p.CreatedAt})
+	}
+
+	for _, p := range pairs {
+		partners := make([]string, 0, 2)
+		for _, id := range partnerIDs(p, userID) {
+			if u, ok := profiles[id]; ok {
+				partners = append(partners, getProfileDisplayName(u))
+			} else {
+				partners = append(partners, tr(lang, "my_data.unknown_partner"))
+			}
+		}
+		export.Pairs = append(export.Pairs, MyDataPair{GroupID: p.GroupID, WeekStart: p.WeekStart, Partner: strings.Join(partners, ", ")})
+	}
+
+	return export, nil
+}
+
+// partnerIDs returns the other members of a pair or trio
+func partnerIDs(p database.Pair, userID int64) []int64 {
+	ids := make([]int64, 0, 2)
+	for _, id := range p.Members() {
+		if id != userID {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// buildMyDataMessage renders the export as human-readable text
+func buildMyDataMessage(export *MyDataExport, lang string) string {
+	text := tr(lang, "my_data.title") + "\n\n"
+
+	if export.Profile != nil {
+		text += tr(lang, "my_data.profile") + "\n"
+		if export.Profile.Username != "" {
+			text += fmt.Sprintf("• username: @%s\n", export.Profile.Username)
+		}
+		text += fmt.Sprintf(tr(lang, "my_data.name"), export.Profile.FullName) + "\n\n"
+	} else {
+		text += tr(lang, "my_data.no_profile") + "\n\n"
+	}
+
+	if len(export.Participation) > 0 {
+		text += tr(lang, "my_data.participation") + "\n"
+		for _, p := range export.Participation {
+			text += fmt.Sprintf(tr(lang, "my_data.signup_item"), p.GroupID, p.SignedUpAt.Format("02.01.2006")) + "\n"
+		}
+		text += "\n"
+	}
+
+	if len(export.Pairs) > 0 {
+		text += fmt.Sprintf(tr(lang, "my_data.pairs"), len(export.Pairs)) + "\n"
+		for _, p := range export.Pairs {
+			text += fmt.Sprintf(tr(lang, "my_data.pair_item"), p.WeekStart, p.GroupID, p.Partner) + "\n"
+		}
+	} else {
+		text += tr(lang, "my_data.no_pairs") + "\n"
+	}
+
+	return text
+}
+
+// handleMyData sends the requester everything stored about them: a readable summary and a full JSON file
+func handleMyData(ctx context.Context, db *sql.DB, api echotron.API, message *echotron.Message, lang string) {
+	userID := message.From.ID
+	chatID := message.Chat.ID
+
+	if wait, ok := myDataLimiter.allow(userID); !ok {
+		sendMessage(api, fmt.Sprintf(tr(lang, "my_data.cooldown"), int(myDataCooldown.Minutes()), int(wait.Minutes())+1), chatID)
+		return
+	}
+
+	export, err := buildMyDataExport(ctx, readerDB(db), userID, lang)
+	if err != nil {
+		botEvent(log.Error(), EventMyDataFailed).Int64("user_id", userID).Err(err).Msg("buildMyDataExport failed")
+		sendMessage(api, tr(lang, "my_data.failed"), chatID)
+		return
+	}
+
+	sendLongMessage(api, buildMyDataMessage(export, lang), chatID)
+
+	data, err := json.MarshalIndent(export, "", "  ")
+	if err != nil {
+		botEvent(log.Error(), EventMyDataFailed).Int64("user_id", userID).Err(err).Msg("json.Marshal failed")
+		return
+	}
+
+	file := echotron.NewInputFileBytes("my_data.json", data)
+	if _, err := api.SendDocument(file, chatID, nil); err != nil {
+		botEvent(log.Error(), EventMyDataFailed).Int64("user_id", userID).Err(err).Msg("SendDocument failed")
+		return
+	}
+
+	botEvent(log.Info(), EventMyDataExported).Int64("user_id", userID).Int("pairs_count", len(export.Pairs)).Msg("User data exported")
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"example.com/random_coffee/database"
+	"github.com/NicoNex/echotron/v3"
+	"github.com/google/uuid"
+)
+
+// jsonKeys returns the sorted keys of a JSON object
+func jsonKeys(object any) []string {
+	m, _ := object.(map[string]any)
+	ks := make([]string, 0, len(m))
+	for k := range m {
+		ks = append(ks, k)
+	}
+	slices.Sort(ks)
+	return ks
+}
+
+func TestMyDataExport(t *testing.T) {
+	// IDs long enough not to turn up by chance in dates or counts
+	const me, partner, trioPartner, stranger = 5550001, 7770002, 7770003, 7770004
+	db := openTestDB(t)
+	tg, api := newFakeTelegram(t)
+	seedTestGroup(t, db, testGroupID, "Coffee")
+	ctx := context.Background()
+	saved := myDataLimiter
+	myDataLimiter = newRequestLimiter(myDataCooldown)
+	t.Cleanup(func() { myDataLimiter = saved })
+
+	for _, u := range []database.UserProfile{
+		{UserID: me, Username: "me", FullName: "Me", UpdatedAt: time.Now()},
+		{UserID: partner, Username: "partner", FullName: "Partner", UpdatedAt: time.Now()},
+		{UserID: stranger, Username: "stranger", FullName: "Stranger", UpdatedAt: time.Now()},
+	} {
+		if err := database.UpsertUserProfile(ctx, db, u); err != nil {
+			t.Fatalf("UpsertUserProfile: %v", err)
+		}
+	}
+	// A pair, then a trio with someone whose profile is gone; the stranger's pair is not ours
+	pairs := []database.Pair{
+		{ID: uuid.New(), GroupID: testGroupID, WeekStart: "2026-04-27", User1ID: me, User2ID: partner, CreatedAt: time.Now()},
+		{ID: uuid.New(), GroupID: testGroupID, WeekStart: "2026-05-04", User1ID: partner, User2ID: me, User3ID: trioPartner, CreatedAt: time.Now()},
+		{ID: uuid.New(), GroupID: testGroupID, WeekStart: "2026-05-04", User1ID: stranger, User2ID: 7770005, CreatedAt: time.Now()},
+	}
+	if err := database.CreatePairs(ctx, db, pairs); err != nil {
+		t.Fatalf("CreatePairs: %v", err)
+	}
+	signUp(t, db, testGroupID, me, stranger)
+
+	request := func() {
+		handleMyData(ctx, db, api, &echotron.Message{Chat: echotron.Chat{ID: me, Type: "private"}, From: &echotron.User{ID: me}}, "ru")
+	}
+	request()
+
+	doc := tg.lastCall("sendDocument").Get("document")
+	var export map[string]any
+	if err := json.Unmarshal([]byte(doc), &export); err != nil {
+		t.Fatalf("my_data.json = %q: %v", doc, err)
+	}
+
+	// The schema is pinned: changing it means bumping myDataSchemaVersion and this test
+	if got, want := jsonKeys(export), []string{"generated_at", "pairs", "participation", "profile", "schema_version"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("export keys = %q, want %q", got, want)
+	}
+	if v := export["schema_version"]; v != float64(1) {
+		t.Fatalf("schema_version = %v, want 1", v)
+	}
+	if got, want := jsonKeys(export["profile"]), []string{"full_name", "updated_at", "user_id", "username"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("profile keys = %q, want %q", got, want)
+	}
+	participation, _ := export["participation"].([]any)
+	if len(participation) != 1 || !reflect.DeepEqual(jsonKeys(participation[0]), []string{"group_id", "signed_up_at"}) {
+		t.Fatalf("participation = %v, want one sign-up with group_id and signed_up_at", participation)
+	}
+	exported, _ := export["pairs"].([]any)
+	if len(exported) != 2 {
+		t.Fatalf("pairs = %v, want our two", exported)
+	}
+	for _, p := range exported {
+		if got, want := jsonKeys(p), []string{"group_id", "partner", "week_start"}; !reflect.DeepEqual(got, want) {
+			t.Fatalf("pair keys = %q, want %q", got, want)
+		}
+	}
+	var typed MyDataExport
+	dec := json.NewDecoder(bytes.NewReader([]byte(doc)))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&typed); err != nil || typed.Profile == nil || typed.Profile.UserID != me {
+		t.Fatalf("my_data.json decodes to %+v, %v", typed, err)
+	}
+	if typed.Pairs[0].Partner != "@partner" || typed.Pairs[1].Partner != "@partner, "+tr("ru", "my_data.unknown_partner") {
+		t.Fatalf("partners = %+v, want display names only", typed.Pairs)
+	}
+
+	// Partners appear by name only, and nothing about other users gets out
+	text := strings.Join(tg.sent(me), "\n")
+	for _, id := range []int64{partner, trioPartner, stranger, 7770005} {
+		for where, content := range map[string]string{"my_data.json": doc, "summary": text} {
+			if strings.Contains(content, strconv.FormatInt(id, 10)) {
+				t.Fatalf("%s mentions user %d: %q", where, id, content)
+			}
+		}
+	}
+	if strings.Contains(doc, "stranger") || strings.Contains(text, "stranger") {
+		t.Fatal("export mentions another user's pair")
+	}
+
+	// A second request right away is refused
+	request()
+	if n := tg.count("sendDocument"); n != 1 {
+		t.Fatalf("sendDocument called %d times, want the repeat rate limited", n)
+	}
+	if sent := tg.sent(me); !strings.Contains(sent[len(sent)-1], "⏳") {
+		t.Fatalf("repeat answered %q, want the cooldown", sent[len(sent)-1])
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"example.com/random_coffee/database"
+	"github.com/NicoNex/echotron/v3"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// minNoticeSetting is the group setting with the minimum hours between the quiz and manual pair creation
+	minNoticeSetting = "min_notice_hours"
+
+	defaultMinNotice = 24 * time.Hour
+)
+
+// getMinNotice returns the group's minimum notice, falling back to the default when unset
+func getMinNotice(ctx context.Context, db *sql.DB, groupID int64) time.Duration {
+	value, found, err := database.GetGroupSetting(ctx, db, groupID, minNoticeSetting)
+	if err != nil {
+		groupEvent(log.Warn(), EventSettingsReadFailed, groupID).Err(err).Str("key", minNoticeSetting).Msg("GetGroupSetting failed")
+		return defaultMinNotice
+	}
+	if !found {
+		return defaultMinNotice
+	}
+
+	hours, err := strconv.Atoi(value)
+	if err != nil || hours < 0 {
+		return defaultMinNotice
+	}
+	return time.Duration(hours) * time.Hour
+}
+
+// remainingNotice returns how long the group still has to wait before pairs can be created
+// without confirmation. It is zero when there is no open poll or its send time is unknown.
+func remainingNotice(ctx context.Context, db *sql.DB, groupID int64) time.Duration {
+	pm, err := database.GetPollMappingByGroupID(ctx, db, groupID)
+	if err != nil || pm == nil {
+		return 0
+	}
+
+	sent, err := database.GetSentPoll(ctx, db, pm.PollID)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			groupEvent(log.Warn(), EventNoticeCheckFailed, groupID).Err(err).Str("poll_id", pm.PollID).Msg("GetSentPoll failed")
+		}
+		return 0
+	}
+
+	remaining := getMinNotice(ctx, db, groupID) - time.Since(sent.SentAt)
+	if remaining < 0 {
+		return 0
+	}
+	return remaining
+}
+
+// formatHoursMinutes renders a duration like "5 часов 20 минут"
+func formatHoursMinutes(d time.Duration) string {
+	hours := int(d.Hours())
+	minutes := int(d.Minutes()) % 60
+
+	if hours == 0 {
+		if minutes == 0 {
+			minutes = 1
+		}
+		return fmt.Sprintf("%d %s", minutes, pluralRu(minutes, "минута", "минуты", "минут"))
+	}
+	text := fmt.Sprintf("%d %s", hours, pluralRu(hours, "час", "часа", "часов"))
+	if minutes > 0 {
+		text += fmt.Sprintf(" %d %s", minutes, pluralRu(minutes, "минута", "минуты", "минут"))
+	}
+	return text
+}
+
+// handleCreatePairsCommand implements manual /create_pairs [confirm], asking for confirmation
+// when the quiz was sent less than the group's minimum notice ago
+func handleCreatePairsCommand(ctx context.Context, db *sql.DB, api echotron.API, groupID int64, args []string) {
+	confirmed := len(args) > 0 && args[0] == "confirm"
+
+	if remaining := remainingNotice(ctx, db, groupID); remaining > 0 && !confirmed {
+		groupEvent(log.Info(), EventNoticeNotElapsed, groupID).Dur("remaining", remaining).Msg("Manual create_pairs before minimum notice")
+		sendMessage(api, fmt.Sprintf("⚠️ Опрос отправлен недавно, не все успели его увидеть. "+
+			"До конца минимального срока осталось %s.\n\n"+
+			"Чтобы создать пары сейчас, отправь /create_pairs confirm", formatHoursMinutes(remaining)), groupID)
+		return
+	}
+
+	runManualJob(api, jobCreatePairs, groupID, func() { CreatePairs(ctx, db, api, groupID) })
+}
+
+// handleMinNoticeCommand implements /min_notice [hours] in a group
+func handleMinNoticeCommand(ctx context.Context, db *sql.DB, api echotron.API, groupID int64, args []string) {
+	// Scheduled runs skip the check, so the notice may not exceed the time the poll is open
+	maxHours := int(loadGroupSchedule(ctx, db, groupID).quizToPairsGap().Hours())
+
+	if len(args) != 1 {
+		current := getMinNotice(ctx, db, groupID)
+		sendMessage(api, fmt.Sprintf("Минимальный срок между опросом и ручным созданием пар: %d ч.\n"+
+			"Использование: /min_notice <часы> (от 0 до %d, 0 - без проверки)", int(current.Hours()), maxHours), groupID)
+		return
+	}
+
+	hours, err := strconv.Atoi(args[0])
+	if err != nil || hours < 0 || hours > maxHours {
+		sendMessage(api, fmt.Sprintf("❌ Укажи число часов от 0 до %d: больше не позволяет расписание", maxHours), groupID)
+		return
+	}
+
+	if err := database.SetGroupSetting(ctx, db, groupID, minNoticeSetting, strconv.Itoa(hours)); err != nil {
+		groupEvent(log.Error(), EventSettingsSaveFailed, groupID).Err(err).Str("key", minNoticeSetting).Msg("SetGroupSetting failed")
+		sendMessage(api, "❌ Не удалось сохранить настройку", groupID)
+		return
+	}
+
+	sendMessage(api, fmt.Sprintf("✅ Минимальный срок: %d ч.", hours), groupID)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"example.com/random_coffee/database"
+	"github.com/NicoNex/echotron/v3"
+)
+
+func TestCreatePairsMinimumNotice(t *testing.T) {
+	tests := []struct {
+		name      string
+		sentAgo   time.Duration // 0 leaves no open poll
+		args      string
+		wantPairs bool
+		wantReply string
+	}{
+		{"early without confirmation", 2 * time.Hour, "", false, "осталось 21 час 59 минут"},
+		{"early with confirmation", 2 * time.Hour, " confirm", true, ""},
+		{"after the notice", 25 * time.Hour, "", true, ""},
+		{"no open poll", 0, "", true, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, tg, api := setupManualPairsGroup(t)
+			ctx := context.Background()
+			if tt.sentAgo > 0 {
+				if err := database.CreatePollMapping(ctx, db, database.PollMapping{PollID: "poll-1", GroupID: testGroupID, MessageID: 10, Kind: database.SignupPoll}); err != nil {
+					t.Fatalf("CreatePollMapping: %v", err)
+				}
+				sent := database.SentPoll{PollID: "poll-1", GroupID: testGroupID, MessageID: 10, SentAt: time.Now().Add(-tt.sentAgo)}
+				if err := database.RecordSentPoll(ctx, db, sent); err != nil {
+					t.Fatalf("RecordSentPoll: %v", err)
+				}
+			}
+			signUp(t, db, testGroupID, 1, 2, 3, 4)
+
+			HandleGroupCommand(ctx, db, api, &echotron.Message{Text: "/create_pairs" + tt.args,
+				Chat: echotron.Chat{ID: testGroupID, Type: "supergroup"}, From: &echotron.User{ID: testAdminID}})
+
+			pairs, err := database.GetPairHistory(ctx, db, testGroupID)
+			if err != nil {
+				t.Fatalf("GetPairHistory: %v", err)
+			}
+			if got := len(pairs) > 0; got != tt.wantPairs {
+				t.Fatalf("%d pairs created, want pairs %v", len(pairs), tt.wantPairs)
+			}
+			if tt.wantReply != "" {
+				sent := tg.sent(testGroupID)
+				if len(sent) != 1 || !strings.Contains(sent[0], tt.wantReply) || !strings.Contains(sent[0], "/create_pairs confirm") {
+					t.Fatalf("group got %q, want the remaining time and how to confirm", sent)
+				}
+			}
+		})
+	}
+}
+
+func TestScheduleRespectsMinimumNotice(t *testing.T) {
+	db, tg, api := setupDeferralGroup(t)
+	ctx := context.Background()
+
+	// Pairs an hour after the quiz would let scheduled runs skip the two-hour notice
+	if reply := groupCommand(t, db, tg, api, "/set_schedule pairs fri 18:00"); !strings.Contains(reply, "не меньше 2 часа") {
+		t.Fatalf("reply = %q, want the schedule rejected", reply)
+	}
+	if sched := loadGroupSchedule(ctx, db, testGroupID); sched.pairs.hour != 20 {
+		t.Fatalf("pairs at %s after a rejected change", sched.pairs)
+	}
+
+	// Nor may the notice outgrow the schedule
+	if reply := groupCommand(t, db, tg, api, "/min_notice 4"); !strings.Contains(reply, "от 0 до 3") {
+		t.Fatalf("reply = %q, want the notice capped by the schedule", reply)
+	}
+	if notice := getMinNotice(ctx, db, testGroupID); notice != 2*time.Hour {
+		t.Fatalf("notice = %s after a rejected change", notice)
+	}
+	if reply := groupCommand(t, db, tg, api, "/set_schedule pairs fri 19:00"); !strings.Contains(reply, "Расписание обновлено") {
+		t.Fatalf("reply = %q, want a two-hour gap accepted", reply)
+	}
+}
@@ -7,24 +7,77 @@ import (
 	"io"
 	"os"
 	"sync"
+	"time"
 
 	"github.com/NicoNex/echotron/v3"
 )
 
-// AdminNotifier is a writer that sends error logs to Telegram admins
+// criticalEvents are mirrored to the fallback sink unconditionally: they may mean Telegram itself is unusable
+var criticalEvents = map[string]bool{
+	EventPollingFailed:   true,
+	EventPollingConflict: true,
+	EventDBCorruptRow:    true,
+	EventPanic:           true,
+}
+
+// alertQueueSize bounds the alerts waiting for the next flush; more are dropped to stderr
+const alertQueueSize = 256
+
+// alert is one error log entry, or several identical ones collapsed together
+type alert struct {
+	key   string // message and error, identical alerts share it
+	html  string
+	plain string
+	event string
+	count int
+}
+
+// AdminNotifier is a writer that sends error logs to Telegram admins. Entries are queued and
+// flushed in batches at most once per interval, with identical errors collapsed into one alert.
 type AdminNotifier struct {
 	api      echotron.API
 	mu       sync.Mutex
 	adminIDs map[int64]bool
-	writer   io.Writer // Original writer to pass logs through
+
+	interval time.Duration
+	queue    chan alert
+	stopped  chan struct{}
+	stderr   io.Writer // where alerts go when they can't be queued or sent
+
+	// closeMu orders Write against Close: an alert queued before Close marks the notifier closed
+	// is always in the queue when the flusher drains it
+	closeMu sync.RWMutex
+	closed  bool
+	done    chan struct{}
+
+	// Optional secondary channel used when Telegram delivery keeps failing
+	fallback            *WebhookSink
+	failoverAfter       int
+	consecutiveFailures int
+	failedOver          bool
 }
 
-func NewAdminNotifier(api echotron.API, adminIDs map[int64]bool, writer io.Writer) *AdminNotifier {
-	return &AdminNotifier{
+// NewAdminNotifier starts the background flusher; call Close to deliver pending alerts on shutdown
+func NewAdminNotifier(api echotron.API, adminIDs map[int64]bool, interval time.Duration) *AdminNotifier {
+	n := &AdminNotifier{
 		api:      api,
 		adminIDs: adminIDs,
-		writer:   writer,
+		interval: interval,
+		queue:    make(chan alert, alertQueueSize),
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+		stderr:   os.Stderr,
 	}
+	go n.run()
+	return n
+}
+
+// SetFallback enables the secondary alert sink, used once more than failoverAfter Telegram deliveries in a row failed
+func (n *AdminNotifier) SetFallback(sink *WebhookSink, failoverAfter int) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.fallback = sink
+	n.failoverAfter = failoverAfter
 }
 
 func (n *AdminNotifier) Write(p []byte) (int, error) {
@@ -41,14 +94,20 @@ func (n *AdminNotifier) Write(p []byte) (int, error) {
 		return len(p), nil
 	}
 
-	// Extract fields
-	message, _ := logEntry["message"].(string)
-	errorMsg, _ := logEntry["error"].(string)
-	timeStr, _ := logEntry["time"].(string)
-
-	// Build notification message
-	go n.sendNotification(message, errorMsg, timeStr, logEntry)
+	a := buildAlert(logEntry)
+	n.closeMu.RLock()
+	defer n.closeMu.RUnlock()
+	if n.closed {
+		// Fallback to stderr to avoid recursion with zerolog
+		fmt.Fprintf(n.stderr, "Admin notifier closed, alert not sent: %s\n", a.plain)
+		return len(p), nil
+	}
 
+	select {
+	case n.queue <- a:
+	default:
+		fmt.Fprintf(n.stderr, "Admin alert queue full, alert not sent: %s\n", a.plain)
+	}
 	return len(p), nil
 }
 
@@ -56,23 +115,37 @@ func (n *AdminNotifier) WriteLevel(level string, p []byte) (int, error) {
 	return n.Write(p)
 }
 
-func (n *AdminNotifier) sendNotification(message, errorMsg, timeStr string, logEntry map[string]interface{}) {
-	n.mu.Lock()
-	defer n.mu.Unlock()
+// Close flushes pending alerts and stops the flusher
+func (n *AdminNotifier) Close() {
+	n.closeMu.Lock()
+	if !n.closed {
+		n.closed = true
+		close(n.done)
+	}
+	n.closeMu.Unlock()
+	<-n.stopped
+}
 
-	// Build a compact formatted message
-	notificationMsg := "🚨 <b>Ошибка</b>\n"
+// buildAlert formats a compact notification from a log entry
+func buildAlert(logEntry map[string]interface{}) alert {
+	message, _ := logEntry["message"].(string)
+	errorMsg, _ := logEntry["error"].(string)
+	event, _ := logEntry["event"].(string)
 
-	if message != "" {
-		notificationMsg += html.EscapeString(message)
-	}
+	a := alert{key: message + "\x00" + errorMsg, event: event, count: 1}
+	a.html = html.EscapeString(message)
+	a.plain = message
 
 	if errorMsg != "" {
-		notificationMsg += "\n" + html.EscapeString(errorMsg)
+		a.html += "\n" + html.EscapeString(errorMsg)
+		a.plain += "\n" + errorMsg
 	}
 
 	// Add only important contextual fields
 	var details []string
+	if event != "" {
+		details = append(details, fmt.Sprintf("событие: %v", event))
+	}
 	if groupID, ok := logEntry["group_id"]; ok {
 		details = append(details, fmt.Sprintf("группа: %v", groupID))
 	}
@@ -84,17 +157,204 @@ func (n *AdminNotifier) sendNotification(message, errorMsg, timeStr string, logE
 	}
 
 	if len(details) > 0 {
-		notificationMsg += "\n<i>" + html.EscapeString(fmt.Sprintf("(%s)", joinStrings(details, ", "))) + "</i>"
+		a.html += "\n<i>" + html.EscapeString(fmt.Sprintf("(%s)", joinStrings(details, ", "))) + "</i>"
+		a.plain += fmt.Sprintf("\n(%s)", joinStrings(details, ", "))
+	}
+	return a
+}
+
+// run collects alerts and flushes them every interval, and once more on Close
+func (n *AdminNotifier) run() {
+	defer close(n.stopped)
+
+	ticker := time.NewTicker(n.interval)
+	defer ticker.Stop()
+
+	pending := make([]*alert, 0)
+	byKey := make(map[string]*alert)
+	add := func(a alert) {
+		if existing, ok := byKey[a.key]; ok {
+			existing.count++
+			return
+		}
+		byKey[a.key] = &a
+		pending = append(pending, &a)
+	}
+
+	for {
+		select {
+		case a := <-n.queue:
+			add(a)
+		case <-ticker.C:
+			n.flush(pending)
+			pending = pending[:0]
+			clear(byKey)
+		case <-n.done:
+		drain:
+			for {
+				select {
+				case a := <-n.queue:
+					add(a)
+				default:
+					break drain
+				}
+			}
+			n.flush(pending)
+			return
+		}
 	}
+}
+
+// alertBatch is one Telegram message worth of alerts
+type alertBatch struct {
+	html     string
+	plain    string
+	critical bool
+}
 
+// batchAlerts packs alerts into as few messages as fit into the Telegram limit
+func batchAlerts(alerts []*alert) []alertBatch {
+	batches := make([]alertBatch, 0, 1)
+	var current alertBatch
+	count := 0
+
+	flush := func() {
+		if count == 0 {
+			return
+		}
+		header := "🚨 <b>Ошибка</b>\n"
+		plainHeader := "🚨 Ошибка\n"
+		if count > 1 {
+			header = fmt.Sprintf("🚨 <b>Ошибки (%d)</b>\n\n", count)
+			plainHeader = fmt.Sprintf("🚨 Ошибки (%d)\n\n", count)
+		}
+		current.html = header + current.html
+		current.plain = plainHeader + current.plain
+		batches = append(batches, current)
+		current = alertBatch{}
+		count = 0
+	}
+
+	for _, a := range alerts {
+		text, plain := a.html, a.plain
+		if a.count > 1 {
+			text += fmt.Sprintf("\n<b>×%d</b>", a.count)
+			plain += fmt.Sprintf("\n×%d", a.count)
+		}
+
+		// Leave room for the header
+		if count > 0 && len(current.html)+len(text)+len("\n\n")+100 > telegramMessageLimit {
+			flush()
+		}
+		if count > 0 {
+			current.html += "\n\n"
+			current.plain += "\n\n"
+		}
+		current.html += text
+		current.plain += plain
+		current.critical = current.critical || criticalEvents[a.event]
+		count++
+	}
+	flush()
+	return batches
+}
+
+// flush delivers the collected alerts to admins
+func (n *AdminNotifier) flush(alerts []*alert) {
+	if len(alerts) == 0 {
+		return
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	for _, a := range alerts {
+		opsCounters.add(counterAlerts, int64(a.count))
+	}
+	batches := batchAlerts(alerts)
+	opsCounters.add(counterAlertMessages, int64(len(batches)))
+
+	for _, b := range batches {
+		var delivered, failed int
+		if len(b.html) > telegramMessageLimit {
+			// A single huge alert can't be split safely inside HTML markup, send it as plain text
+			for _, chunk := range splitMessage(b.plain, telegramMessageLimit) {
+				d, f := n.sendToAdmins(chunk, "")
+				delivered, failed = delivered+d, failed+f
+			}
+		} else {
+			delivered, failed = n.sendToAdmins(b.html, echotron.HTML)
+		}
+		sentToFallback := n.trackDelivery(delivered, failed, b.plain)
+
+		if b.critical && !sentToFallback {
+			n.sendFallback(b.plain)
+		}
+	}
+}
+
+// sendToAdmins delivers a message to every admin and counts successes and transient failures.
+// Failures caused by a particular admin's chat (blocked bot, deleted account) are not counted.
+func (n *AdminNotifier) sendToAdmins(text string, parseMode echotron.ParseMode) (delivered, failed int) {
 	for adminID := range n.adminIDs {
 		opts := &echotron.MessageOptions{
-			ParseMode: echotron.HTML,
+			ParseMode: parseMode,
 		}
-		if _, err := n.api.SendMessage(notificationMsg, adminID, opts); err != nil {
+		if _, err := n.api.SendMessage(text, adminID, opts); err != nil {
 			// Fallback to stderr to avoid recursion with zerolog
-			fmt.Fprintf(os.Stderr, "Failed to send admin notification to %d: %v\n", adminID, err)
+			fmt.Fprintf(n.stderr, "Failed to send admin notification to %d: %v\n", adminID, err)
+			if !isChatUnavailableError(err) {
+				failed++
+			}
+			continue
+		}
+		delivered++
+	}
+	return delivered, failed
+}
+
+// trackDelivery switches to the fallback sink after repeated Telegram failures and announces recovery once.
+// It reports whether plainMsg was sent to the fallback sink.
+func (n *AdminNotifier) trackDelivery(delivered, failed int, plainMsg string) bool {
+	if n.fallback == nil {
+		return false
+	}
+
+	if delivered > 0 {
+		if n.failedOver {
+			n.failedOver = false
+			recovery := "✅ Доставка уведомлений в Telegram восстановлена"
+			n.sendFallback(recovery)
+			n.sendToAdmins(recovery, "")
 		}
+		n.consecutiveFailures = 0
+		return false
+	}
+
+	if failed == 0 {
+		return false
+	}
+
+	n.consecutiveFailures++
+	if n.consecutiveFailures <= n.failoverAfter {
+		return false
+	}
+
+	if !n.failedOver {
+		n.failedOver = true
+		plainMsg = fmt.Sprintf("⚠️ Telegram не доставляет уведомления админам (%d попыток подряд), переключаюсь на резервный канал\n\n%s",
+			n.consecutiveFailures, plainMsg)
+	}
+	n.sendFallback(plainMsg)
+	return true
+}
+
+func (n *AdminNotifier) sendFallback(text string) {
+	if n.fallback == nil {
+		return
+	}
+	if err := n.fallback.Send(text); err != nil {
+		fmt.Fprintf(n.stderr, "Failed to send alert to fallback webhook: %v\n", err)
 	}
 }
 
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+const testAlertAdmin = 1
+
+// lockedBuffer is a bytes.Buffer safe for the concurrent writers of the notifier's stderr
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// newTestNotifier starts a notifier flushing every interval to one admin of a fake Telegram
+func newTestNotifier(t *testing.T, interval time.Duration) (*AdminNotifier, *fakeTelegram, *lockedBuffer) {
+	t.Helper()
+	tg, api := newFakeTelegram(t)
+	n := NewAdminNotifier(api, map[int64]bool{testAlertAdmin: true}, interval)
+	stderr := &lockedBuffer{}
+	n.stderr = stderr
+	t.Cleanup(n.Close)
+	return n, tg, stderr
+}
+
+// logError writes an error log entry the way zerolog does
+func logError(t *testing.T, n *AdminNotifier, message, err string) {
+	t.Helper()
+	entry, _ := json.Marshal(map[string]string{"level": "error", "message": message, "error": err, "event": "test.failed"})
+	if _, werr := n.Write(entry); werr != nil {
+		t.Fatalf("Write: %v", werr)
+	}
+}
+
+func TestAlertsAreDeduplicated(t *testing.T) {
+	n, tg, stderr := newTestNotifier(t, time.Hour)
+	for i := 0; i < 5; i++ {
+		logError(t, n, "SendPoll failed", "chat not found")
+	}
+	// Warnings never reach admins
+	n.Write([]byte(`{"level":"warn","message":"slow"}`))
+	n.Close()
+
+	sent := tg.sent(testAlertAdmin)
+	if len(sent) != 1 || !strings.Contains(sent[0], "SendPoll failed") || !strings.Contains(sent[0], "×5") || strings.Contains(sent[0], "slow") {
+		t.Fatalf("admin got %q, want one alert with ×5", sent)
+	}
+
+	// After Close alerts go to stderr instead of being dropped silently
+	logError(t, n, "late", "boom")
+	if len(tg.sent(testAlertAdmin)) != 1 || !strings.Contains(stderr.String(), "alert not sent: late") {
+		t.Fatalf("alert after Close: stderr %q", stderr.String())
+	}
+}
+
+func TestAlertWindowExpires(t *testing.T) {
+	n, tg, _ := newTestNotifier(t, 20*time.Millisecond)
+
+	// An alert repeated within the window is counted, whichever window each copy lands in
+	logError(t, n, "SendPoll failed", "chat not found")
+	logError(t, n, "SendPoll failed", "chat not found")
+	waitFor(t, "the first alerts", func() bool {
+		total := 0
+		for _, msg := range tg.sent(testAlertAdmin) {
+			total++
+			if strings.Contains(msg, "×2") {
+				total++
+			}
+		}
+		return total == 2
+	})
+
+	// A flushed alert starts counting afresh in the next window
+	before := len(tg.sent(testAlertAdmin))
+	logError(t, n, "SendPoll failed", "chat not found")
+	waitFor(t, "the next window's alert", func() bool { return len(tg.sent(testAlertAdmin)) == before+1 })
+	if last := tg.sent(testAlertAdmin)[before]; strings.Contains(last, "×") {
+		t.Fatalf("alert of a new window = %q, want no repeat counter", last)
+	}
+}
+
+func TestMixedAlertsAreBatched(t *testing.T) {
+	n, tg, _ := newTestNotifier(t, time.Hour)
+	logError(t, n, "SendPoll failed", "chat not found")
+	logError(t, n, "CreatePairs failed", "database is locked")
+	logError(t, n, "SendPoll failed", "chat not found")
+	logError(t, n, "SendPoll failed", "too many requests")
+	n.Close()
+
+	sent := tg.sent(testAlertAdmin)
+	if len(sent) != 1 {
+		t.Fatalf("admin got %d messages, want one batch", len(sent))
+	}
+	for _, want := range []string{"Ошибки (3)", "chat not found\n", "<b>×2</b>", "database is locked", "too many requests"} {
+		if !strings.Contains(sent[0], want) {
+			t.Errorf("batch misses %q:\n%s", want, sent[0])
+		}
+	}
+}
+
+func TestLongAlertBatchesAreSplit(t *testing.T) {
+	n, tg, _ := newTestNotifier(t, time.Hour)
+	const alerts = 40
+	for i := 0; i < alerts; i++ {
+		logError(t, n, fmt.Sprintf("alert-%d failed", i), strings.Repeat("x", 300))
+	}
+	n.Close()
+
+	sent := tg.sent(testAlertAdmin)
+	if len(sent) < 2 {
+		t.Fatalf("%d alerts sent in %d message", alerts, len(sent))
+	}
+	total := 0
+	for _, msg := range sent {
+		if l := utf8.RuneCountInString(msg); l > telegramMessageLimit {
+			t.Fatalf("message of %d characters", l)
+		}
+		total += strings.Count(msg, "alert-")
+	}
+	if total != alerts {
+		t.Fatalf("%d of %d alerts delivered", total, alerts)
+	}
+}
+
+func TestAlertsRacingCloseAreNotLost(t *testing.T) {
+	n, tg, stderr := newTestNotifier(t, time.Hour)
+
+	const writers, each = 8, 50
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < each; i++ {
+				entry, _ := json.Marshal(map[string]string{"level": "error", "message": fmt.Sprintf("alert-%d-%d", w, i)})
+				n.Write(entry)
+			}
+		}()
+	}
+	time.Sleep(time.Millisecond)
+	n.Close()
+	wg.Wait()
+
+	// Every alert is either delivered or reported on stderr
+	delivered := 0
+	for _, msg := range tg.sent(testAlertAdmin) {
+		delivered += strings.Count(msg, "alert-")
+	}
+	reported := strings.Count(stderr.String(), "alert not sent: alert-")
+	if delivered+reported != writers*each {
+		t.Fatalf("%d delivered and %d reported of %d alerts", delivered, reported, writers*each)
+	}
+}
+
+// webhookRecorder is an httptest server standing in for the fallback webhook
+type webhookRecorder struct {
+	mu    sync.Mutex
+	texts []string
+}
+
+func newWebhookRecorder(t *testing.T) (*webhookRecorder, *WebhookSink) {
+	t.Helper()
+	w := &webhookRecorder{}
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		var body struct{ Text string }
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("webhook body: %v", err)
+		}
+		w.mu.Lock()
+		w.texts = append(w.texts, body.Text)
+		w.mu.Unlock()
+	}))
+	t.Cleanup(server.Close)
+	return w, NewWebhookSink(server.URL)
+}
+
+func (w *webhookRecorder) received() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return append([]string(nil), w.texts...)
+}
+
+// flushAlert delivers one alert the way the flusher does at the end of a window
+func flushAlert(n *AdminNotifier, message, event string) {
+	a := buildAlert(map[string]interface{}{"level": "error", "message": message, "event": event})
+	n.flush([]*alert{&a})
+}
+
+func TestAlertFailoverAndRecovery(t *testing.T) {
+	const failoverAfter = 3
+	n, tg, _ := newTestNotifier(t, time.Hour)
+	webhook, sink := newWebhookRecorder(t)
+	n.SetFallback(sink, failoverAfter)
+
+	tg.reply("sendMessage", func(url.Values) string {
+		return `{"ok":false,"error_code":502,"description":"Bad Gateway"}`
+	})
+
+	// N failed deliveries in a row stay with Telegram
+	for i := 1; i <= failoverAfter; i++ {
+		flushAlert(n, fmt.Sprintf("alert-%d failed", i), "test.failed")
+	}
+	if got := webhook.received(); len(got) != 0 {
+		t.Fatalf("webhook got %q after %d failures, want nothing yet", got, failoverAfter)
+	}
+
+	// The next one goes to the webhook, announcing the switch once
+	flushAlert(n, "alert-4 failed", "test.failed")
+	flushAlert(n, "alert-5 failed", "test.failed")
+	got := webhook.received()
+	if len(got) != 2 || !strings.Contains(got[0], "переключаюсь на резервный канал") || !strings.Contains(got[0], "alert-4 failed") ||
+		strings.Contains(got[1], "переключаюсь") || !strings.Contains(got[1], "alert-5 failed") {
+		t.Fatalf("webhook got %q, want the failover notice with alert-4, then alert-5", got)
+	}
+
+	// Telegram works again: the recovery is announced once, on both channels
+	tg.reply("sendMessage", func(url.Values) string { return `{"ok":true,"result":{"message_id":1}}` })
+	flushAlert(n, "alert-6 failed", "test.failed")
+	flushAlert(n, "alert-7 failed", "test.failed")
+	got = webhook.received()
+	if len(got) != 3 || !strings.Contains(got[2], "восстановлена") {
+		t.Fatalf("webhook got %q, want a single recovery notice", got[2:])
+	}
+	recoveries := 0
+	for _, text := range tg.sent(testAlertAdmin) {
+		if strings.Contains(text, "восстановлена") {
+			recoveries++
+		}
+	}
+	if recoveries != 1 {
+		t.Fatalf("admin got %d recovery notices, want one", recoveries)
+	}
+}
+
+func TestCriticalAlertsGoToBothSinks(t *testing.T) {
+	n, tg, _ := newTestNotifier(t, time.Hour)
+	webhook, sink := newWebhookRecorder(t)
+	n.SetFallback(sink, 3)
+
+	flushAlert(n, "ordinary failure", "test.failed")
+	for _, event := range []string{EventPollingFailed, EventPollingConflict, EventDBCorruptRow, EventPanic} {
+		flushAlert(n, event+" happened", event)
+	}
+
+	got := webhook.received()
+	if len(got) != 4 {
+		t.Fatalf("webhook got %q, want the four critical alerts only", got)
+	}
+	if sent := tg.sent(testAlertAdmin); len(sent) != 5 {
+		t.Fatalf("admin got %d alerts, want all five", len(sent))
+	}
+}
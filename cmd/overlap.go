@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"example.com/random_coffee/database"
+	"github.com/NicoNex/echotron/v3"
+	"github.com/rs/zerolog/log"
+)
+
+// Policies for users already matched this week in another group, set with OVERLAP_POLICY
+const (
+	// overlapAnnotate pairs them anyway and tells everyone they have several meetings this week
+	overlapAnnotate = "annotate"
+	// overlapSkip leaves them out of the later cycle and explains why in a DM
+	overlapSkip = "skip"
+)
+
+func loadOverlapPolicy() string {
+	switch policy := os.Getenv("OVERLAP_POLICY"); policy {
+	case "", overlapAnnotate:
+		return overlapAnnotate
+	case overlapSkip:
+		return overlapSkip
+	default:
+		botEvent(log.Warn(), EventConfigInvalidValue).Str("env", "OVERLAP_POLICY").Str("value", policy).
+			Str("default", overlapAnnotate).Msg("Unknown overlap policy, using default")
+		return overlapAnnotate
+	}
+}
+
+// findOverlaps returns participants already paired this week in other groups, with those groups.
+// Cycles are weekly in every group, so cycles overlap exactly when they share the week.
+func findOverlaps(ctx context.Context, db *sql.DB, groupID int64, participants []database.Participant) map[int64][]int64 {
+	userIDs := make([]int64, 0, len(participants))
+	for _, p := range participants {
+		userIDs = append(userIDs, p.UserID)
+	}
+
+	weekStart := getWeekStart(time.Now())
+	overlaps, err := database.GetOtherGroupMatches(ctx, db, weekStart, groupID, userIDs)
+	if err != nil {
+		cycleEvent(log.Error(), EventOverlapCheckFailed, groupID, weekStart).Err(err).Msg("GetOtherGroupMatches failed")
+		return map[int64][]int64{}
+	}
+	return overlaps
+}
+
+// splitOverlapping separates participants matched elsewhere this week from the rest
+func splitOverlapping(participants []database.Participant, overlaps map[int64][]int64) ([]database.Participant, []database.Participant) {
+	kept := make([]database.Participant, 0, len(participants))
+	skipped := make([]database.Participant, 0)
+	for _, p := range participants {
+		if len(overlaps[p.UserID]) > 0 {
+			skipped = append(skipped, p)
+		} else {
+			kept = append(kept, p)
+		}
+	}
+	return kept, skipped
+}
+
+// appendOverlapMessage notes in the group message who was skipped or has several meetings this week
+func appendOverlapMessage(message string, finalPairs [][]database.Participant, skipped []database.Participant,
+	overlaps map[int64][]int64) string {
+
+	if len(skipped) > 0 {
+		names := make([]string, 0, len(skipped))
+		for _, p := range skipped {
+			names = append(names, getDisplayName(p))
+		}
+		message += "\n\n📅 Уже встречаются на этой неделе в другой группе, поэтому без пары здесь: " + strings.Join(names, ", ")
+	}
+
+	names := make([]string, 0)
+	for _, pair := range finalPairs {
+		for _, p := range pair {
+			if len(overlaps[p.UserID]) > 0 {
+				names = append(names, getDisplayName(p))
+			}
+		}
+	}
+	if len(names) > 0 {
+		message += "\n\n📅 На этой неделе несколько встреч (есть пара и в другой группе): " + strings.Join(names, ", ")
+	}
+	return message
+}
+
+// notifyOverlaps tells users affected by the overlap policy what happened
+func notifyOverlaps(ctx context.Context, db *sql.DB, api echotron.API, groupID int64, finalPairs [][]database.Participant,
+	skipped []database.Participant, overlaps map[int64][]int64) {
+
+	title := groupTitle(ctx, db, groupID)
+
+	for _, p := range skipped {
+		sendGroupDM(ctx, db, api, groupID, p.UserID, fmt.Sprintf("☕️ На этой неделе у тебя уже есть встреча Random Coffee в другой группе, "+
+			"поэтому в группе «%s» мы не стали подбирать тебе вторую пару. Увидимся на следующей неделе!", title))
+	}
+
+	for _, pair := range finalPairs {
+		for _, p := range pair {
+			others := overlaps[p.UserID]
+			if len(others) == 0 {
+				continue
+			}
+			titles := make([]string, 0, len(others))
+			for _, gid := range others {
+				titles = append(titles, "«"+groupTitle(ctx, db, gid)+"»")
+			}
+			sendGroupDM(ctx, db, api, groupID, p.UserID, fmt.Sprintf("📅 На этой неделе у тебя несколько встреч Random Coffee: в группе «%s» и в %s. "+
+				"Если на все не хватит времени, предупреди собеседников заранее.", title, strings.Join(titles, ", ")))
+		}
+	}
+
+	if len(overlaps) > 0 {
+		groupEvent(log.Info(), EventOverlapHandled, groupID).Int("skipped", len(skipped)).Int("overlapping", len(overlaps)).
+			Msg("Users matched in other groups this week")
+	}
+}
+
+// groupTitle returns the stored group title, or its ID when the title is unknown
+func groupTitle(ctx context.Context, db *sql.DB, groupID int64) string {
+	g, err := database.GetGroup(ctx, db, groupID)
+	if err != nil || g.Title == "" {
+		return fmt.Sprintf("%d", groupID)
+	}
+	return g.Title
+}
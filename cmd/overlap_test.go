@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"example.com/random_coffee/database"
+	"github.com/google/uuid"
+)
+
+func TestOverlapPolicies(t *testing.T) {
+	const otherGroupID = -200
+	tests := []struct {
+		policy      string
+		wantPaired  bool
+		wantMessage string
+		wantDM      string
+	}{
+		{overlapAnnotate, true, "несколько встреч (есть пара и в другой группе): @user", "в группе «Coffee» и в «Tea»"},
+		{overlapSkip, false, "поэтому без пары здесь: @user", "не стали подбирать тебе вторую пару"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			db, tg, api := setupManualPairsGroup(t)
+			ctx := context.Background()
+			t.Setenv("OVERLAP_POLICY", tt.policy)
+
+			// User 1 already meets user 7 this week in another group
+			seedTestGroup(t, db, otherGroupID, "Tea")
+			weekStart := getWeekStart(time.Now())
+			elsewhere := database.Pair{ID: uuid.New(), GroupID: otherGroupID, WeekStart: weekStart, User1ID: 1, User2ID: 7, CreatedAt: time.Now()}
+			if err := database.CreatePairs(ctx, db, []database.Pair{elsewhere}); err != nil {
+				t.Fatalf("CreatePairs: %v", err)
+			}
+			signUp(t, db, testGroupID, 1, 2, 3, 4)
+
+			CreatePairs(ctx, db, api, testGroupID)
+
+			pairs, err := database.GetPairHistory(ctx, db, testGroupID)
+			if err != nil || len(pairs) == 0 {
+				t.Fatalf("GetPairHistory = %+v, %v; want this week's pairs", pairs, err)
+			}
+			paired := false
+			for _, p := range pairs {
+				paired = paired || slices.Contains(p.Members(), 1)
+			}
+			if paired != tt.wantPaired {
+				t.Fatalf("pairs %+v, want user 1 paired %v", pairs, tt.wantPaired)
+			}
+
+			announced := strings.Join(tg.sent(testGroupID), "\n")
+			if !strings.Contains(announced, tt.wantMessage) {
+				t.Fatalf("group got %q, want %q", announced, tt.wantMessage)
+			}
+			dms := tg.sent(1)
+			if len(dms) != 1 || !strings.Contains(dms[0], tt.wantDM) {
+				t.Fatalf("user 1 got %q, want %q", dms, tt.wantDM)
+			}
+			// Nobody else is matched elsewhere, so nobody else hears about it
+			for _, id := range []int64{2, 3, 4} {
+				for _, dm := range tg.sent(id) {
+					if strings.Contains(dm, "📅") || strings.Contains(dm, "другой группе") {
+						t.Fatalf("user %d got %q", id, dm)
+					}
+				}
+			}
+		})
+	}
+}
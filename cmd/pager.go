@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/NicoNex/echotron/v3"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// listPageSize is how many entries a paged list shows at once
+	listPageSize = 10
+
+	// listStaleAfter is how long navigation buttons stay usable; older lists may no longer match the data
+	listStaleAfter = 24 * time.Hour
+
+	listStaleText = "Список устарел, вызови команду заново"
+)
+
+// listFilter is a filter button of a paged list; key goes into the callback data
+type listFilter struct {
+	key   string
+	label string
+}
+
+// pagedList is a listing shown page by page in a single message. Navigation and filter buttons carry
+// "<callback>:<page>:<filter>", and the handler rebuilds the list and edits the message in place.
+type pagedList struct {
+	callback string
+	title    string
+	empty    string // shown instead of entries when there are none
+	lines    []string
+	filters  []listFilter
+	filter   string
+}
+
+// pageCount returns the number of pages, at least one so an empty list still renders
+func (l pagedList) pageCount() int {
+	return max(1, (len(l.lines)+listPageSize-1)/listPageSize)
+}
+
+// render returns the text and buttons of the given page, clamped to the pages the list has
+func (l pagedList) render(page int) (string, [][]echotron.InlineKeyboardButton) {
+	pages := l.pageCount()
+	page = min(max(page, 0), pages-1)
+
+	text := l.title
+	if label := l.filterLabel(); label != "" {
+		text += " · " + label
+	}
+	text += fmt.Sprintf(" (стр. %d/%d, всего %d)\n\n", page+1, pages, len(l.lines))
+
+	if len(l.lines) == 0 {
+		text += l.empty
+	} else {
+		end := min((page+1)*listPageSize, len(l.lines))
+		text += strings.Join(l.lines[page*listPageSize:end], "\n")
+	}
+
+	var keyboard [][]echotron.InlineKeyboardButton
+	if pages > 1 {
+		var nav []echotron.InlineKeyboardButton
+		if page > 0 {
+			nav = append(nav, echotron.InlineKeyboardButton{Text: "⬅️", CallbackData: l.callbackData(page-1, l.filter)})
+		}
+		if page < pages-1 {
+			nav = append(nav, echotron.InlineKeyboardButton{Text: "➡️", CallbackData: l.callbackData(page+1, l.filter)})
+		}
+		keyboard = append(keyboard, nav)
+	}
+	if len(l.filters) > 0 {
+		row := make([]echotron.InlineKeyboardButton, 0, len(l.filters))
+		for _, f := range l.filters {
+			label := f.label
+			if f.key == l.filter {
+				label = "• " + label
+			}
+			// Switching the filter starts from the first page
+			row = append(row, echotron.InlineKeyboardButton{Text: label, CallbackData: l.callbackData(0, f.key)})
+		}
+		keyboard = append(keyboard, row)
+	}
+	return text, keyboard
+}
+
+func (l pagedList) filterLabel() string {
+	for _, f := range l.filters {
+		if f.key == l.filter {
+			return f.label
+		}
+	}
+	return ""
+}
+
+func (l pagedList) callbackData(page int, filter string) string {
+	return fmt.Sprintf("%s:%d:%s", l.callback, page, filter)
+}
+
+// parseListCallback reads the page and filter from the argument of a paged list callback
+func parseListCallback(arg string) (int, string, bool) {
+	pageStr, filter, _ := strings.Cut(arg, ":")
+	page, err := strconv.Atoi(pageStr)
+	if err != nil || page < 0 {
+		return 0, "", false
+	}
+	return page, filter, true
+}
+
+// isStaleListMessage reports whether a paged list message is too old to navigate
+func isStaleListMessage(cq *echotron.CallbackQuery) bool {
+	return cq.Message == nil || time.Since(time.Unix(int64(cq.Message.Date), 0)) > listStaleAfter
+}
+
+// sendPagedList sends the first page of a list
+func sendPagedList(api echotron.API, l pagedList, chatID int64) {
+	text, keyboard := l.render(0)
+	opts := &echotron.MessageOptions{ReplyMarkup: echotron.InlineKeyboardMarkup{InlineKeyboard: keyboard}}
+	_, err := api.SendMessage(text, chatID, opts)
+	tokenWatcher.sendDone(err)
+	if err != nil {
+		botEvent(log.Error(), EventMessageSendFailed).Err(err).Int64("chat_id", chatID).Str("list", l.callback).Msg("Failed to send paged list")
+	}
+}
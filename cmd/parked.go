@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+	"sync"
+	"time"
+
+	"example.com/random_coffee/database"
+	"github.com/NicoNex/echotron/v3"
+	"github.com/rs/zerolog/log"
+)
+
+// parkedRedeliverTick is how often parked sign-ups are written again
+const parkedRedeliverTick = 30 * time.Second
+
+// parkedSignup is a sign-up answer that could not be written because the database stayed locked
+type parkedSignup struct {
+	groupID    int64
+	user       echotron.User
+	joined     bool
+	pollID     string
+	answeredAt time.Time // when the bot got the answer; a later answer wins
+	parkedAt   time.Time
+}
+
+type parkedKey struct {
+	groupID int64
+	userID  int64
+}
+
+// parkedSignupQueue holds sign-ups waiting for the database to unlock, one per user and group:
+// a later answer replaces the parked one, the way it would have overwritten it in the database.
+// It lives in memory, since the database is exactly what can't be written to, so parked answers
+// are lost if the bot restarts before the database unlocks.
+type parkedSignupQueue struct {
+	mu       sync.Mutex
+	pending  map[parkedKey]parkedSignup
+	inFlight map[parkedKey]time.Time // answers taken for redelivery, by answer time
+	alerted  bool                    // whether the current backlog was already reported
+
+	// writeMu is held while a parked answer is written, so a newer answer waits for it instead of
+	// being overwritten by it
+	writeMu sync.Mutex
+}
+
+var parkedSignups = &parkedSignupQueue{pending: make(map[parkedKey]parkedSignup), inFlight: make(map[parkedKey]time.Time)}
+
+// park keeps the answer unless a newer one for the same user and group is already parked
+func (q *parkedSignupQueue) park(s parkedSignup) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.parkLocked(s)
+	return len(q.pending)
+}
+
+func (q *parkedSignupQueue) parkLocked(s parkedSignup) {
+	key := parkedKey{s.groupID, s.user.ID}
+	if old, ok := q.pending[key]; ok {
+		if old.answeredAt.After(s.answeredAt) {
+			return
+		}
+		s.parkedAt = old.parkedAt // the age counts from the first answer that could not be written
+	}
+	q.pending[key] = s
+}
+
+// supersede is called before a new answer is written directly. A parked answer being redelivered is
+// cancelled, and if it is being written right now the new answer waits until it is done.
+func (q *parkedSignupQueue) supersede(groupID, userID int64) {
+	key := parkedKey{groupID, userID}
+	q.mu.Lock()
+	_, flying := q.inFlight[key]
+	delete(q.inFlight, key)
+	q.mu.Unlock()
+
+	if flying {
+		q.writeMu.Lock()
+		defer q.writeMu.Unlock()
+	}
+}
+
+// drop forgets a parked answer older than one that was just written, so redelivery doesn't undo it
+func (q *parkedSignupQueue) drop(groupID, userID int64, writtenAt time.Time) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	key := parkedKey{groupID, userID}
+	if s, ok := q.pending[key]; ok && !s.answeredAt.After(writtenAt) {
+		delete(q.pending, key)
+	}
+}
+
+// take returns the parked answers, of one group if groupID is non-zero, oldest first, removing them from
+// the queue. Each must be passed to deliver.
+func (q *parkedSignupQueue) take(groupID int64) []parkedSignup {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	taken := make([]parkedSignup, 0, len(q.pending))
+	for key, s := range q.pending {
+		if groupID != 0 && key.groupID != groupID {
+			continue
+		}
+		taken = append(taken, s)
+		delete(q.pending, key)
+		q.inFlight[key] = s.answeredAt
+	}
+	sort.Slice(taken, func(i, j int) bool { return taken[i].parkedAt.Before(taken[j].parkedAt) })
+	return taken
+}
+
+// deliver writes a taken answer with write, unless a newer answer superseded it meanwhile. An answer
+// the database is still locked for is parked again, unless a newer one was parked or written since.
+// It reports whether write ran and the error it returned.
+func (q *parkedSignupQueue) deliver(s parkedSignup, write func() error) (bool, error) {
+	key := parkedKey{s.groupID, s.user.ID}
+	current := func() bool {
+		at, ok := q.inFlight[key]
+		return ok && at.Equal(s.answeredAt)
+	}
+
+	q.writeMu.Lock()
+	q.mu.Lock()
+	if !current() {
+		q.mu.Unlock()
+		q.writeMu.Unlock()
+		return false, nil
+	}
+	q.mu.Unlock()
+	err := write()
+	q.writeMu.Unlock()
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if current() {
+		delete(q.inFlight, key)
+		if database.IsBusy(err) {
+			q.parkLocked(s)
+		}
+	}
+	return true, err
+}
+
+// stats returns the backlog size and the age of its oldest answer
+func (q *parkedSignupQueue) stats() (int, time.Duration) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var oldest time.Duration
+	for _, s := range q.pending {
+		if age := time.Since(s.parkedAt); age > oldest {
+			oldest = age
+		}
+	}
+	return len(q.pending), oldest
+}
+
+// parkSignup keeps an answer the database refused while locked, to be written by redeliverParkedSignups
+func parkSignup(groupID int64, user *echotron.User, joined bool, pollID string, answeredAt time.Time, err error) {
+	size := parkedSignups.park(parkedSignup{groupID: groupID, user: *user, joined: joined, pollID: pollID, answeredAt: answeredAt, parkedAt: time.Now()})
+	userEvent(log.Warn(), EventSignupParked, groupID, user.ID).Err(err).Bool("joined", joined).Int("parked", size).
+		Msg("Database locked, sign-up parked for redelivery")
+	alertParkedBacklog()
+}
+
+// redeliverParkedSignups writes parked answers again, all of them or one group's; those still locked out stay
+// parked. An answer the user changed meanwhile is not written.
+func redeliverParkedSignups(ctx context.Context, db *sql.DB, groupID int64) {
+	for _, s := range parkedSignups.take(groupID) {
+		written, err := parkedSignups.deliver(s, func() error {
+			return storeSignupAnswer(ctx, db, s.groupID, &s.user, s.joined)
+		})
+		if !written || database.IsBusy(err) {
+			continue
+		}
+		if err != nil {
+			userEvent(log.Error(), EventSignupRedeliverFailed, s.groupID, s.user.ID).Err(err).Bool("joined", s.joined).
+				Msg("Parked sign-up could not be written, dropped")
+			continue
+		}
+		dashboards.schedule(s.user.ID)
+		userEvent(log.Info(), EventSignupRedelivered, s.groupID, s.user.ID).Bool("joined", s.joined).
+			Dur("parked_for", time.Since(s.parkedAt)).Msg("Parked sign-up written")
+		if s.joined {
+			cancelSlowStartIfReached(ctx, db, s.groupID, s.pollID)
+		}
+	}
+	alertParkedBacklog()
+}
+
+// alertParkedBacklog reports a backlog that grew past PARKED_SIGNUPS_ALERT or has an answer older than
+// PARKED_SIGNUPS_MAX_AGE_MINUTES, once until the backlog clears. Logged as an error so it reaches admins.
+func alertParkedBacklog() {
+	size, oldest := parkedSignups.stats()
+	maxSize := envInt("PARKED_SIGNUPS_ALERT", 20)
+	maxAge := time.Duration(envInt("PARKED_SIGNUPS_MAX_AGE_MINUTES", 10)) * time.Minute
+
+	parkedSignups.mu.Lock()
+	defer parkedSignups.mu.Unlock()
+
+	if size == 0 {
+		parkedSignups.alerted = false
+		return
+	}
+	if parkedSignups.alerted || (size <= maxSize && oldest <= maxAge) {
+		return
+	}
+	parkedSignups.alerted = true
+	botEvent(log.Error(), EventSignupParkedBacklog).Int("parked", size).Dur("oldest", oldest).Int64("busy_events", database.BusyEvents()).
+		Msg("Sign-ups are piling up while the database is locked")
+}
+
+func startParkedSignupRedelivery(db *sql.DB, stopChan chan struct{}) {
+	scheduler.startWorker("parked_signups", func() {
+		ticker := time.NewTicker(parkedRedeliverTick)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				redeliverParkedSignups(context.Background(), db, 0)
+			case <-stopChan:
+				return
+			}
+		}
+	})
+}
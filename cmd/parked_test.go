@@ -0,0 +1,148 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/NicoNex/echotron/v3"
+)
+
+var errLocked = errors.New("database is locked (5) (SQLITE_BUSY)")
+
+func newParkedQueue() *parkedSignupQueue {
+	return &parkedSignupQueue{pending: make(map[parkedKey]parkedSignup), inFlight: make(map[parkedKey]time.Time)}
+}
+
+func parkedAnswer(joined bool, answeredAt time.Time) parkedSignup {
+	return parkedSignup{groupID: testGroupID, user: echotron.User{ID: 1}, joined: joined, answeredAt: answeredAt, parkedAt: answeredAt}
+}
+
+// pendingAnswer returns the parked answer of user 1
+func pendingAnswer(t *testing.T, q *parkedSignupQueue) parkedSignup {
+	t.Helper()
+	s, ok := q.pending[parkedKey{testGroupID, 1}]
+	if !ok {
+		t.Fatal("no answer parked")
+	}
+	return s
+}
+
+func TestParkKeepsNewerAnswer(t *testing.T) {
+	q := newParkedQueue()
+	now := time.Now()
+
+	q.park(parkedAnswer(true, now))
+	q.park(parkedAnswer(false, now.Add(-time.Second)))
+	if s := pendingAnswer(t, q); !s.joined {
+		t.Fatal("an older answer replaced the newer parked one")
+	}
+
+	q.park(parkedAnswer(false, now.Add(time.Second)))
+	if s := pendingAnswer(t, q); s.joined || !s.parkedAt.Equal(now) {
+		t.Fatalf("parked %+v, want the newest answer aged from the first", s)
+	}
+}
+
+func TestDeliverReparksBusyAnswer(t *testing.T) {
+	q := newParkedQueue()
+	q.park(parkedAnswer(true, time.Now()))
+
+	taken := q.take(0)
+	written, err := q.deliver(taken[0], func() error { return errLocked })
+	if !written || err == nil {
+		t.Fatalf("deliver = %v, %v; want the write attempted", written, err)
+	}
+	if s := pendingAnswer(t, q); !s.joined {
+		t.Fatal("busy answer not parked again")
+	}
+}
+
+func TestDeliverSkipsSupersededAnswer(t *testing.T) {
+	q := newParkedQueue()
+	q.park(parkedAnswer(true, time.Now()))
+	taken := q.take(0)
+
+	// The user changes their mind and the new answer gets parked too
+	q.supersede(testGroupID, 1)
+	q.park(parkedAnswer(false, time.Now().Add(time.Second)))
+
+	written, _ := q.deliver(taken[0], func() error {
+		t.Fatal("superseded answer written")
+		return nil
+	})
+	if written {
+		t.Fatal("deliver reported a superseded answer as written")
+	}
+	if s := pendingAnswer(t, q); s.joined {
+		t.Fatal("the newer parked answer was replaced")
+	}
+}
+
+func TestBusyRedeliveryDoesNotReplaceNewerAnswer(t *testing.T) {
+	q := newParkedQueue()
+	q.park(parkedAnswer(true, time.Now()))
+	taken := q.take(0)
+
+	written, _ := q.deliver(taken[0], func() error {
+		// A newer answer arrives while the old one is being written and gets parked
+		q.mu.Lock()
+		q.parkLocked(parkedAnswer(false, time.Now().Add(time.Second)))
+		q.mu.Unlock()
+		return errLocked
+	})
+	if !written {
+		t.Fatal("deliver skipped the write")
+	}
+	if s := pendingAnswer(t, q); s.joined {
+		t.Fatal("the busy old answer was parked over the newer one")
+	}
+}
+
+func TestSupersedeWaitsForWriteInProgress(t *testing.T) {
+	q := newParkedQueue()
+	q.park(parkedAnswer(true, time.Now()))
+	taken := q.take(0)
+
+	writing, release := make(chan struct{}), make(chan struct{})
+	var order []string
+	var mu sync.Mutex
+	record := func(s string) {
+		mu.Lock()
+		defer mu.Unlock()
+		order = append(order, s)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		q.deliver(taken[0], func() error {
+			close(writing)
+			<-release
+			record("old written")
+			return nil
+		})
+	}()
+
+	<-writing
+	superseded := make(chan struct{})
+	go func() {
+		q.supersede(testGroupID, 1)
+		record("new written")
+		close(superseded)
+	}()
+
+	select {
+	case <-superseded:
+		t.Fatal("the new answer did not wait for the old one being written")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	<-done
+	<-superseded
+
+	if len(order) != 2 || order[0] != "old written" {
+		t.Fatalf("writes happened in order %q, want the old answer first", order)
+	}
+}
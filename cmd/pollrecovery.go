@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"example.com/random_coffee/database"
+	"github.com/NicoNex/echotron/v3"
+	"github.com/rs/zerolog/log"
+)
+
+// recoverPollMapping restores the mapping of a poll the bot sent when its poll_mapping row was lost.
+// Only the group's latest poll is restored, and only while its cycle is still open (no pairs created since),
+// so votes on old polls never reopen a finished week. It returns the poll's group on success.
+func recoverPollMapping(ctx context.Context, db *sql.DB, api echotron.API, pollID string) (int64, bool) {
+	sp, err := database.GetSentPoll(ctx, db, pollID)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			botEvent(log.Warn(), EventPollRecoveryFailed).Err(err).Str("poll_id", pollID).Msg("GetSentPoll failed")
+		}
+		return 0, false
+	}
+
+	latest, err := database.GetLatestSentPoll(ctx, db, sp.GroupID)
+	if err != nil {
+		groupEvent(log.Warn(), EventPollRecoveryFailed, sp.GroupID).Err(err).Str("poll_id", pollID).Msg("GetLatestSentPoll failed")
+		return 0, false
+	}
+	if latest.PollID != pollID {
+		return 0, false
+	}
+
+	closed, err := database.HasPairsSince(ctx, db, sp.GroupID, sp.SentAt)
+	if err != nil {
+		groupEvent(log.Warn(), EventPollRecoveryFailed, sp.GroupID).Err(err).Str("poll_id", pollID).Msg("HasPairsSince failed")
+		return 0, false
+	}
+	if closed {
+		return 0, false
+	}
+
+	pm := database.PollMapping{PollID: sp.PollID, GroupID: sp.GroupID, MessageID: sp.MessageID}
+	if err := database.CreatePollMapping(ctx, db, pm); err != nil {
+		// Another vote may have restored the mapping concurrently
+		if groupID, lookupErr := database.GetGroupIDByPollID(ctx, db, pollID); lookupErr == nil {
+			return groupID, true
+		}
+		groupEvent(log.Error(), EventPollRecoveryFailed, sp.GroupID).Err(err).Str("poll_id", pollID).Msg("CreatePollMapping failed")
+		return 0, false
+	}
+
+	groupEvent(log.Warn(), EventPollMappingRecovered, sp.GroupID).Str("poll_id", pollID).Int64("message_id", sp.MessageID).
+		Msg("Poll mapping restored from sent polls log")
+	notifyAdmins(api, fmt.Sprintf("⚠️ Восстановлена потерянная привязка опроса %s к группе %d. "+
+		"Голоса снова учитываются, но стоит проверить, почему запись пропала.", pollID, sp.GroupID))
+
+	return sp.GroupID, true
+}
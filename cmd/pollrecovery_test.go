@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"testing"
+	"time"
+
+	"example.com/random_coffee/database"
+	"github.com/NicoNex/echotron/v3"
+	"github.com/google/uuid"
+)
+
+// answerPoll delivers a poll answer the way Telegram does after a restart: only the poll ID is known
+func answerPoll(ctx context.Context, db *sql.DB, api echotron.API, pollID string, userID int64) {
+	HandlePollAnswer(ctx, db, api, &echotron.PollAnswer{PollID: pollID, OptionIDs: []int{pollYesOption},
+		User: &echotron.User{ID: userID, Username: "user", FirstName: "User"}})
+}
+
+// recordSentPoll logs a poll as sent to the test group
+func recordSentPoll(t *testing.T, db *sql.DB, pollID string, messageID int64, sentAt time.Time) {
+	t.Helper()
+	sp := database.SentPoll{PollID: pollID, GroupID: testGroupID, MessageID: messageID, SentAt: sentAt}
+	if err := database.RecordSentPoll(context.Background(), db, sp); err != nil {
+		t.Fatalf("RecordSentPoll: %v", err)
+	}
+}
+
+func TestPollMappingRecoveredAfterRestart(t *testing.T) {
+	tests := []struct {
+		name  string
+		stale bool // the group still maps last week's poll
+	}{
+		{"mapping missing", false},
+		{"mapping left from last week", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, tg, api := setupManualPairsGroup(t)
+			ctx := context.Background()
+
+			recordSentPoll(t, db, "poll-old", 9, time.Now().Add(-7*24*time.Hour))
+			if tt.stale {
+				if err := database.CreatePollMapping(ctx, db, database.PollMapping{PollID: "poll-old", GroupID: testGroupID, MessageID: 9}); err != nil {
+					t.Fatalf("CreatePollMapping: %v", err)
+				}
+			}
+			// This week's poll went out, but the bot stopped before mapping it
+			recordSentPoll(t, db, "poll-1", 10, time.Now().Add(-time.Hour))
+
+			answerPoll(ctx, db, api, "poll-1", 1)
+			answerPoll(ctx, db, api, "poll-1", 2)
+
+			if n, err := database.CountParticipants(ctx, db, testGroupID); err != nil || n != 2 {
+				t.Fatalf("CountParticipants = %d, %v; want both votes counted", n, err)
+			}
+			pm, err := database.GetPollMappingByGroupID(ctx, db, testGroupID)
+			if err != nil || pm == nil || pm.PollID != "poll-1" || pm.MessageID != 10 || pm.Kind != database.SignupPoll {
+				t.Fatalf("GetPollMappingByGroupID = %+v, %v; want this week's poll mapped", pm, err)
+			}
+			// Admins hear about it once, not on every vote
+			alerts := 0
+			for _, text := range tg.sent(testAdminID) {
+				if strings.Contains(text, "Восстановлена потерянная привязка опроса poll-1") {
+					alerts++
+				}
+			}
+			if alerts != 1 {
+				t.Fatalf("admin got %d recovery alerts, want 1", alerts)
+			}
+		})
+	}
+}
+
+func TestPollMappingNotRecoveredForClosedPolls(t *testing.T) {
+	tests := []struct {
+		name   string
+		pollID string
+		setup  func(t *testing.T, db *sql.DB)
+	}{
+		{"never sent", "poll-unknown", func(*testing.T, *sql.DB) {}},
+		{"superseded by a newer poll", "poll-old", func(t *testing.T, db *sql.DB) {
+			recordSentPoll(t, db, "poll-old", 9, time.Now().Add(-7*24*time.Hour))
+			recordSentPoll(t, db, "poll-1", 10, time.Now().Add(-time.Hour))
+		}},
+		{"pairs already created", "poll-1", func(t *testing.T, db *sql.DB) {
+			recordSentPoll(t, db, "poll-1", 10, time.Now().Add(-time.Hour))
+			p := database.Pair{ID: uuid.New(), GroupID: testGroupID, WeekStart: getWeekStart(time.Now()), User1ID: 3, User2ID: 4, CreatedAt: time.Now()}
+			if err := database.CreatePairs(context.Background(), db, []database.Pair{p}); err != nil {
+				t.Fatalf("CreatePairs: %v", err)
+			}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, tg, api := setupManualPairsGroup(t)
+			ctx := context.Background()
+			tt.setup(t, db)
+
+			answerPoll(ctx, db, api, tt.pollID, 1)
+
+			if n, _ := database.CountParticipants(ctx, db, testGroupID); n != 0 {
+				t.Fatalf("%d participants, want the vote ignored", n)
+			}
+			if pm, _ := database.GetPollMappingByGroupID(ctx, db, testGroupID); pm != nil {
+				t.Fatalf("poll mapping %+v restored", pm)
+			}
+			if sent := tg.sent(testAdminID); len(sent) != 0 {
+				t.Fatalf("admin got %q", sent)
+			}
+		})
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"example.com/random_coffee/database"
+	"example.com/random_coffee/pkg/pairing"
+	"github.com/rs/zerolog/log"
+)
+
+// postProcessors adjust matched pairs before they are saved; set up in main
+var postProcessors []pairing.PostProcessor
+
+// loadPostProcessors builds the processors enabled by the environment
+func loadPostProcessors() []pairing.PostProcessor {
+	processors := make([]pairing.PostProcessor, 0)
+	if couples := parseExclusions("MATCH_EXCLUSIONS"); len(couples) > 0 {
+		processors = append(processors, pairing.NewExclusions(couples))
+	}
+	return processors
+}
+
+// parseExclusions reads couples of user IDs written as "id:id,id:id"
+func parseExclusions(envKey string) [][2]int64 {
+	value := os.Getenv(envKey)
+	if value == "" {
+		return nil
+	}
+
+	couples := make([][2]int64, 0)
+	for _, part := range strings.Split(value, ",") {
+		first, second, found := strings.Cut(strings.TrimSpace(part), ":")
+		a, errA := strconv.ParseInt(first, 10, 64)
+		b, errB := strconv.ParseInt(second, 10, 64)
+		if !found || errA != nil || errB != nil {
+			botEvent(log.Warn(), EventConfigInvalidValue).Str("env", envKey).Str("value", part).Msg("Failed to parse excluded couple, expected id:id")
+			continue
+		}
+		couples = append(couples, [2]int64{a, b})
+	}
+	return couples
+}
+
+// postProcessPairs runs the post-processors, followed by the run's own extra ones, over the matched meetings,
+// checks the result and returns the adjusted meetings with the users they include. met are the couples of
+// participants that met before and relaxed tells whether matching fell back to repeat meetings; the returned
+// relaxed also tells whether a processor repeated a meeting. On error, a *pairing.QualityError when the
+// result broke an invariant, nothing must be saved.
+func postProcessPairs(ctx context.Context, groupID int64, participants []database.Participant, meetings [][]database.Participant,
+	met [][2]int64, relaxed bool, extra ...pairing.PostProcessor) ([][]database.Participant, map[int64]bool, bool, error) {
+
+	weekStart := getWeekStart(time.Now())
+	processors := append(postProcessors[:len(postProcessors):len(postProcessors)], extra...)
+	snapshot := pairing.Snapshot{GroupID: groupID, WeekStart: weekStart, Met: met}
+	adjusted, used, notes, relaxed, err := runPostProcessors(ctx, processors, snapshot, relaxed, participants, meetings)
+	for _, note := range notes {
+		cycleEvent(log.Info(), EventPairsAdjusted, groupID, weekStart).Str("reason", note).Msg("Pairs adjusted by post-processor")
+	}
+	if err != nil {
+		return nil, nil, false, err
+	}
+	return adjusted, used, relaxed, nil
+}
+
+// runPostProcessors applies processors to the meetings, then checks the result with pairing.CheckQuality.
+// It returns the adjusted meetings, the users they include, the processors' notes and whether the history
+// ended up relaxed; the notes also come back with a quality error. The snapshot's participants and excluded
+// couples are filled in here.
+func runPostProcessors(ctx context.Context, processors []pairing.PostProcessor, snapshot pairing.Snapshot, relaxed bool,
+	participants []database.Participant, meetings [][]database.Participant) ([][]database.Participant, map[int64]bool, []string, bool, error) {
+
+	byID := make(map[int64]database.Participant, len(participants))
+	snapshot.Participants = make([]int64, 0, len(participants))
+	for _, p := range participants {
+		byID[p.UserID] = p
+		snapshot.Participants = append(snapshot.Participants, p.UserID)
+	}
+	snapshot.Excluded = excludedCouples(processors)
+
+	proposal := pairing.Proposal{Meetings: make([][]int64, 0, len(meetings)), RelaxedHistory: relaxed}
+	used := make(map[int64]bool)
+	for _, m := range meetings {
+		ids := make([]int64, 0, len(m))
+		for _, p := range m {
+			ids = append(ids, p.UserID)
+			used[p.UserID] = true
+		}
+		proposal.Meetings = append(proposal.Meetings, ids)
+	}
+	for _, p := range participants {
+		if !used[p.UserID] {
+			proposal.Unpaired = append(proposal.Unpaired, p.UserID)
+		}
+	}
+
+	proposal, err := pairing.Apply(ctx, processors, proposal, snapshot)
+	if err != nil {
+		return nil, nil, nil, false, err
+	}
+	if violations := pairing.CheckQuality(proposal, snapshot, unpairedTolerance()); len(violations) > 0 {
+		return nil, nil, proposal.Notes, false, &pairing.QualityError{Violations: violations, Proposal: proposal, Snapshot: snapshot}
+	}
+
+	adjusted := make([][]database.Participant, 0, len(proposal.Meetings))
+	used = make(map[int64]bool)
+	for _, ids := range proposal.Meetings {
+		m := make([]database.Participant, 0, len(ids))
+		for _, id := range ids {
+			m = append(m, byID[id])
+			used[id] = true
+		}
+		adjusted = append(adjusted, m)
+	}
+	return adjusted, used, proposal.Notes, proposal.RelaxedHistory, nil
+}
+
+// excludedCouples collects the couples the exclusion processors keep apart, so the quality check
+// can make sure no later processor brought one back together
+func excludedCouples(processors []pairing.PostProcessor) [][2]int64 {
+	var couples [][2]int64
+	for _, pp := range processors {
+		switch e := pp.(type) {
+		case *pairing.Exclusions:
+			couples = append(couples, e.Couples()...)
+		case privateExclusions:
+			couples = append(couples, e.exclusions.Couples()...)
+		}
+	}
+	return couples
+}
+
+// listedExclusions returns the couples the exclusion processors keep apart, in both orders, so matching can
+// leave them out of the candidates
+func listedExclusions(processors []pairing.PostProcessor) avoidedCouples {
+	listed := make(avoidedCouples)
+	for _, c := range excludedCouples(processors) {
+		listed[c] = true
+		listed[[2]int64{c[1], c[0]}] = true
+	}
+	return listed
+}
+
+// unpairedTolerance is how many people beyond the unavoidable minimum a run may leave unpaired
+// before it is considered broken
+func unpairedTolerance() int {
+	return envInt("PAIRING_UNPAIRED_TOLERANCE", 2)
+}
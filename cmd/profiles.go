@@ -0,0 +1,300 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"example.com/random_coffee/database"
+	"github.com/NicoNex/echotron/v3"
+	"github.com/rs/zerolog/log"
+)
+
+// Profile keys for the schedule; the other keys are group settings
+const (
+	profileQuizKey     = "schedule_quiz"
+	profilePairsKey    = "schedule_pairs"
+	profileTimezoneKey = "timezone"
+)
+
+// profileSettingKeys are the group settings a profile carries. A setting added later is simply
+// missing from older profiles, and applying one resets it to the default.
+var profileSettingKeys = []string{countdownSetting, minNoticeSetting, announcementMediaSetting, dmPolicySetting,
+	slowStartHoursSetting, slowStartMinVotesSetting, holidayCalendarSetting, holidayGroupNoticeSetting}
+
+var profileNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,32}$`)
+
+// profileKeys lists every key of a profile in display order
+func profileKeys() []string {
+	return append([]string{profileQuizKey, profilePairsKey, profileTimezoneKey}, profileSettingKeys...)
+}
+
+// profileDefault is the value a key has when it is not set; empty means an unset group setting
+func profileDefault(key string) string {
+	def := defaultSchedule()
+	switch key {
+	case profileQuizKey:
+		return def.quiz.String()
+	case profilePairsKey:
+		return def.pairs.String()
+	case profileTimezoneKey:
+		return def.timezone
+	default:
+		return ""
+	}
+}
+
+// profileValue returns the key's value in settings, or its default when missing
+func profileValue(settings map[string]string, key string) string {
+	if value, ok := settings[key]; ok {
+		return value
+	}
+	return profileDefault(key)
+}
+
+// readProfileSettings snapshots the group's current settings
+func readProfileSettings(ctx context.Context, db *sql.DB, groupID int64) (map[string]string, error) {
+	sched := loadGroupSchedule(ctx, db, groupID)
+	settings := map[string]string{
+		profileQuizKey:     sched.quiz.String(),
+		profilePairsKey:    sched.pairs.String(),
+		profileTimezoneKey: sched.timezone,
+	}
+
+	for _, key := range profileSettingKeys {
+		value, found, err := database.GetGroupSetting(ctx, db, groupID, key)
+		if err != nil {
+			return nil, err
+		}
+		if found {
+			settings[key] = value
+		}
+	}
+	return settings, nil
+}
+
+// diffProfile returns the keys whose value would change, with "old → new" descriptions
+func diffProfile(current, target map[string]string) ([]string, []string) {
+	keys := make([]string, 0)
+	lines := make([]string, 0)
+	for _, key := range profileKeys() {
+		from, to := profileValue(current, key), profileValue(target, key)
+		if from == to {
+			continue
+		}
+		keys = append(keys, key)
+		lines = append(lines, fmt.Sprintf("• %s: %s → %s", key, displayProfileValue(from), displayProfileValue(to)))
+	}
+	return keys, lines
+}
+
+func displayProfileValue(value string) string {
+	if value == "" {
+		return "по умолчанию"
+	}
+	return value
+}
+
+// validateProfileSettings checks the target settings the way the commands setting them one by one do,
+// and returns the schedule to store. Keys missing from target stand for their defaults; errors are
+// worded for the admin applying the profile.
+func validateProfileSettings(groupID int64, target map[string]string) (database.GroupConfig, error) {
+	quizFields := strings.Fields(profileValue(target, profileQuizKey))
+	pairsFields := strings.Fields(profileValue(target, profilePairsKey))
+	if len(quizFields) != 2 || len(pairsFields) != 2 {
+		return database.GroupConfig{}, errors.New("расписание в профиле повреждено")
+	}
+	quiz, err := parseWeeklyTime(quizFields[0], quizFields[1])
+	if err != nil {
+		return database.GroupConfig{}, fmt.Errorf("время опроса: %w", err)
+	}
+	pairs, err := parseWeeklyTime(pairsFields[0], pairsFields[1])
+	if err != nil {
+		return database.GroupConfig{}, fmt.Errorf("время создания пар: %w", err)
+	}
+	timezone := profileValue(target, profileTimezoneKey)
+	location, err := time.LoadLocation(timezone)
+	if err != nil || timezone == "" || timezone == "Local" {
+		return database.GroupConfig{}, fmt.Errorf("неизвестный часовой пояс %q", timezone)
+	}
+	if quiz == pairs {
+		return database.GroupConfig{}, errors.New("опрос и создание пар в одно время")
+	}
+
+	// Scheduled runs skip the minimum notice check, so the schedule itself must respect it, as in /set_schedule
+	minNotice := defaultMinNotice
+	if value := profileValue(target, minNoticeSetting); value != "" {
+		hours, err := strconv.Atoi(value)
+		if err != nil || hours < 0 {
+			return database.GroupConfig{}, fmt.Errorf("некорректный %s %q", minNoticeSetting, value)
+		}
+		minNotice = time.Duration(hours) * time.Hour
+	}
+	sched := groupSchedule{quiz: quiz, pairs: pairs, timezone: timezone, location: location}
+	if gap := sched.quizToPairsGap(); gap < minNotice {
+		return database.GroupConfig{}, fmt.Errorf("между опросом и созданием пар %s, а %s требует не меньше %s",
+			formatHoursMinutes(gap), minNoticeSetting, formatHoursMinutes(minNotice))
+	}
+
+	return database.GroupConfig{
+		GroupID:      groupID,
+		QuizWeekday:  int(quiz.weekday),
+		QuizHour:     quiz.hour,
+		QuizMinute:   quiz.minute,
+		PairsWeekday: int(pairs.weekday),
+		PairsHour:    pairs.hour,
+		PairsMinute:  pairs.minute,
+		Timezone:     timezone,
+	}, nil
+}
+
+// applyProfileSettings validates the target settings, then writes all of them to the group in one
+// transaction; keys missing from target get defaults. It returns a note for the admin when a pending
+// quiz deferral no longer fits the new schedule and was dropped.
+func applyProfileSettings(ctx context.Context, db *sql.DB, groupID int64, target map[string]string) (string, error) {
+	sc, err := validateProfileSettings(groupID, target)
+	if err != nil {
+		return "", err
+	}
+
+	old := loadGroupSchedule(ctx, db, groupID)
+	settings := make(map[string]string, len(profileSettingKeys))
+	for _, key := range profileSettingKeys {
+		settings[key] = profileValue(target, key)
+	}
+	if err := database.ApplyGroupSettings(ctx, db, sc, settings); err != nil {
+		return "", err
+	}
+	note := recheckQuizDeferral(ctx, db, groupID, old, loadWeeklySchedule(ctx, db, groupID))
+	rescheduleGroup(groupID)
+	return note, nil
+}
+
+// applyProfile applies a stored profile to the group and records which keys changed.
+// It returns the changed keys and applyProfileSettings' note.
+func applyProfile(ctx context.Context, db *sql.DB, actorID, groupID int64, profile *database.SettingsProfile) ([]string, string, error) {
+	current, err := readProfileSettings(ctx, db, groupID)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read current settings: %w", err)
+	}
+	changed, _ := diffProfile(current, profile.Settings)
+
+	note, err := applyProfileSettings(ctx, db, groupID, profile.Settings)
+	if err != nil {
+		return nil, "", err
+	}
+
+	writeAudit(ctx, db, actorID, "apply_profile", groupID,
+		fmt.Sprintf("profile=%s version=%d changed=%s", profile.Name, profile.Version, strings.Join(changed, ",")))
+	groupEvent(log.Info(), EventProfileApplied, groupID).Str("profile", profile.Name).Int("version", profile.Version).
+		Strs("changed", changed).Msg("Settings profile applied")
+	return changed, note, nil
+}
+
+// applyDefaultProfile applies DEFAULT_SETTINGS_PROFILE to a newly registered group, if configured
+func applyDefaultProfile(ctx context.Context, db *sql.DB, groupID int64) {
+	name := os.Getenv("DEFAULT_SETTINGS_PROFILE")
+	if name == "" {
+		return
+	}
+
+	profile, err := database.GetSettingsProfile(ctx, db, name)
+	if err != nil {
+		groupEvent(log.Error(), EventProfileFailed, groupID).Err(err).Str("profile", name).Msg("Default settings profile not loaded")
+		return
+	}
+	if _, _, err := applyProfile(ctx, db, 0, groupID, profile); err != nil {
+		groupEvent(log.Error(), EventProfileFailed, groupID).Err(err).Str("profile", name).Msg("Failed to apply default settings profile")
+	}
+}
+
+// handleSaveProfileCommand implements /save_profile <name> in a group
+func handleSaveProfileCommand(ctx context.Context, db *sql.DB, api echotron.API, message *echotron.Message, args []string) {
+	groupID := message.Chat.ID
+	if len(args) != 1 || !profileNamePattern.MatchString(args[0]) {
+		sendMessage(api, "Использование: /save_profile <имя>\nИмя: латиница, цифры, _ и -, до 32 символов", groupID)
+		return
+	}
+
+	settings, err := readProfileSettings(ctx, db, groupID)
+	if err != nil {
+		groupEvent(log.Error(), EventProfileFailed, groupID).Err(err).Msg("readProfileSettings failed")
+		sendMessage(api, "❌ Не удалось прочитать настройки группы", groupID)
+		return
+	}
+
+	profile := database.SettingsProfile{Name: args[0], Settings: settings, SavedBy: message.From.ID, UpdatedAt: time.Now()}
+	version, err := database.SaveSettingsProfile(ctx, db, profile)
+	if err != nil {
+		groupEvent(log.Error(), EventProfileFailed, groupID).Err(err).Str("profile", profile.Name).Msg("SaveSettingsProfile failed")
+		sendMessage(api, "❌ Не удалось сохранить профиль", groupID)
+		return
+	}
+
+	writeAudit(ctx, db, message.From.ID, "save_profile", groupID, fmt.Sprintf("profile=%s version=%d", profile.Name, version))
+	groupEvent(log.Info(), EventProfileSaved, groupID).Str("profile", profile.Name).Int("version", version).Msg("Settings profile saved")
+	sendMessage(api, fmt.Sprintf("✅ Профиль %s сохранен (версия %d)", profile.Name, version), groupID)
+}
+
+// handleApplyProfileCommand implements /apply_profile <name> [confirm] in a group: shows the changes, applies them on confirm
+func handleApplyProfileCommand(ctx context.Context, db *sql.DB, api echotron.API, message *echotron.Message, args []string) {
+	groupID := message.Chat.ID
+
+	confirmed := len(args) == 2 && args[1] == "confirm"
+	if len(args) == 0 || (len(args) == 2 && !confirmed) || len(args) > 2 {
+		text := "Использование: /apply_profile <имя> [confirm]"
+		if names, err := database.GetSettingsProfileNames(ctx, db); err == nil && len(names) > 0 {
+			text += "\n\nПрофили: " + strings.Join(names, ", ")
+		}
+		sendMessage(api, text, groupID)
+		return
+	}
+
+	profile, err := database.GetSettingsProfile(ctx, db, args[0])
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			sendMessage(api, fmt.Sprintf("❌ Профиль %s не найден", args[0]), groupID)
+			return
+		}
+		groupEvent(log.Error(), EventProfileFailed, groupID).Err(err).Str("profile", args[0]).Msg("GetSettingsProfile failed")
+		sendMessage(api, "❌ Не удалось загрузить профиль", groupID)
+		return
+	}
+
+	current, err := readProfileSettings(ctx, db, groupID)
+	if err != nil {
+		groupEvent(log.Error(), EventProfileFailed, groupID).Err(err).Msg("readProfileSettings failed")
+		sendMessage(api, "❌ Не удалось прочитать настройки группы", groupID)
+		return
+	}
+	changed, lines := diffProfile(current, profile.Settings)
+	if _, err := validateProfileSettings(groupID, profile.Settings); err != nil {
+		groupEvent(log.Warn(), EventProfileFailed, groupID).Err(err).Str("profile", profile.Name).Msg("Profile rejected")
+		sendMessage(api, fmt.Sprintf("❌ Профиль %s нельзя применить: %v", profile.Name, err), groupID)
+		return
+	}
+	if len(changed) == 0 {
+		sendMessage(api, fmt.Sprintf("Настройки группы уже совпадают с профилем %s", profile.Name), groupID)
+		return
+	}
+
+	if !confirmed {
+		sendMessage(api, fmt.Sprintf("Профиль %s (версия %d) изменит:\n%s\n\nДля применения повтори команду с confirm в конце.",
+			profile.Name, profile.Version, strings.Join(lines, "\n")), groupID)
+		return
+	}
+
+	_, note, err := applyProfile(ctx, db, message.From.ID, groupID, profile)
+	if err != nil {
+		groupEvent(log.Error(), EventProfileFailed, groupID).Err(err).Str("profile", profile.Name).Msg("applyProfile failed")
+		sendMessage(api, "❌ Не удалось применить профиль", groupID)
+		return
+	}
+	sendMessage(api, fmt.Sprintf("✅ Профиль %s применен:\n%s%s", profile.Name, strings.Join(lines, "\n"), note), groupID)
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"testing"
+	"time"
+
+	"example.com/random_coffee/database"
+	"github.com/NicoNex/echotron/v3"
+)
+
+// shortGapProfile runs the quiz three hours before pairs
+var shortGapProfile = map[string]string{
+	profileQuizKey:     "пт 17:00",
+	profilePairsKey:    "пт 20:00",
+	profileTimezoneKey: "Europe/Berlin",
+	countdownSetting:   "on",
+}
+
+func TestValidateProfileSettings(t *testing.T) {
+	withNotice := func(hours string) map[string]string {
+		target := map[string]string{minNoticeSetting: hours}
+		for k, v := range shortGapProfile {
+			target[k] = v
+		}
+		return target
+	}
+
+	tests := []struct {
+		name    string
+		target  map[string]string
+		problem string // empty when the profile is valid
+	}{
+		{"defaults", map[string]string{}, ""},
+		{"gap shorter than the default notice", shortGapProfile, "требует не меньше 24 часа"},
+		{"gap shorter than the profile's notice", withNotice("4"), "требует не меньше 4 часа"},
+		{"gap fits the profile's notice", withNotice("3"), ""},
+		{"bad notice", withNotice("soon"), "некорректный min_notice_hours"},
+		{"same time", map[string]string{profileQuizKey: "пт 17:00", profilePairsKey: "пт 17:00"}, "в одно время"},
+		{"bad timezone", map[string]string{profileTimezoneKey: "Mars/Olympus"}, "неизвестный часовой пояс"},
+		{"bad quiz time", map[string]string{profileQuizKey: "пт 25:00"}, "время опроса"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := validateProfileSettings(testGroupID, tt.target)
+			if tt.problem == "" {
+				if err != nil {
+					t.Fatalf("validateProfileSettings = %v, want the profile accepted", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.problem) {
+				t.Fatalf("validateProfileSettings = %v, want an error mentioning %q", err, tt.problem)
+			}
+		})
+	}
+}
+
+func TestApplyProfileRejectsShortGap(t *testing.T) {
+	db := openTestDB(t)
+	tg, api := newFakeTelegram(t)
+	ctx := context.Background()
+	seedTestGroup(t, db, testGroupID, "Coffee")
+
+	profile := database.SettingsProfile{Name: "short", Settings: shortGapProfile, UpdatedAt: time.Now()}
+	if _, err := database.SaveSettingsProfile(ctx, db, profile); err != nil {
+		t.Fatalf("SaveSettingsProfile: %v", err)
+	}
+	handleApplyProfileCommand(ctx, db, api, &echotron.Message{Chat: echotron.Chat{ID: testGroupID}, From: &echotron.User{ID: testAdminID}},
+		[]string{"short", "confirm"})
+
+	if got := tg.sent(testGroupID); len(got) != 1 || !strings.Contains(got[0], "нельзя применить") {
+		t.Fatalf("group got %q, want the profile rejected", got)
+	}
+	if _, err := database.GetGroupConfig(ctx, db, testGroupID); err != sql.ErrNoRows {
+		t.Fatalf("GetGroupConfig err = %v, want no schedule stored", err)
+	}
+	if _, found, _ := database.GetGroupSetting(ctx, db, testGroupID, countdownSetting); found {
+		t.Fatal("countdown stored from a rejected profile")
+	}
+}
+
+func TestApplyProfileSettingsIsAtomic(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	seedTestGroup(t, db, testGroupID, "Coffee")
+	if err := database.SetGroupSetting(ctx, db, testGroupID, dmPolicySetting, "all"); err != nil {
+		t.Fatalf("SetGroupSetting: %v", err)
+	}
+
+	// One of the profile's writes fails, after others went through
+	if _, err := db.Exec(`CREATE TRIGGER fail_notice BEFORE UPDATE ON group_setting WHEN NEW.key = 'holiday_group_notice'
+		BEGIN SELECT RAISE(ABORT, 'disk full'); END;
+		CREATE TRIGGER fail_notice_insert BEFORE INSERT ON group_setting WHEN NEW.key = 'holiday_group_notice'
+		BEGIN SELECT RAISE(ABORT, 'disk full'); END`); err != nil {
+		t.Fatalf("CREATE TRIGGER: %v", err)
+	}
+	target := map[string]string{profileQuizKey: "ср 10:00", countdownSetting: "on", holidayGroupNoticeSetting: "on"}
+	if _, err := applyProfileSettings(ctx, db, testGroupID, target); err == nil {
+		t.Fatal("applyProfileSettings succeeded despite the failing write")
+	}
+
+	if _, err := database.GetGroupConfig(ctx, db, testGroupID); err != sql.ErrNoRows {
+		t.Fatalf("GetGroupConfig err = %v, want the schedule rolled back", err)
+	}
+	if _, found, _ := database.GetGroupSetting(ctx, db, testGroupID, countdownSetting); found {
+		t.Fatal("countdown kept from a failed apply")
+	}
+	if value, _, _ := database.GetGroupSetting(ctx, db, testGroupID, dmPolicySetting); value != "all" {
+		t.Fatalf("dm_policy = %q, want it left as it was", value)
+	}
+}
+
+func TestRegisterAppliesDefaultProfile(t *testing.T) {
+	db := openTestDB(t)
+	tg, api := newFakeTelegram(t)
+	ctx := context.Background()
+
+	profile := database.SettingsProfile{Name: "team", UpdatedAt: time.Now(), Settings: map[string]string{
+		profileQuizKey: "ср 10:00", profilePairsKey: "пт 10:00", profileTimezoneKey: "Europe/Berlin", countdownSetting: "on",
+	}}
+	if _, err := database.SaveSettingsProfile(ctx, db, profile); err != nil {
+		t.Fatalf("SaveSettingsProfile: %v", err)
+	}
+	t.Setenv("DEFAULT_SETTINGS_PROFILE", "team")
+
+	register := func(groupID int64) {
+		handleRegisterCommand(ctx, db, api, &echotron.Message{Chat: echotron.Chat{ID: groupID, Title: "Coffee"}, From: &echotron.User{ID: testAdminID}})
+	}
+	register(testGroupID)
+	if got := tg.sent(testGroupID); len(got) != 1 || !strings.Contains(got[0], "подключена") {
+		t.Fatalf("group got %q, want it registered", got)
+	}
+	sc, err := database.GetGroupConfig(ctx, db, testGroupID)
+	if err != nil || sc.QuizWeekday != int(time.Wednesday) || sc.QuizHour != 10 || sc.Timezone != "Europe/Berlin" {
+		t.Fatalf("GetGroupConfig = %+v, %v; want the default profile's schedule", sc, err)
+	}
+	if value, _, _ := database.GetGroupSetting(ctx, db, testGroupID, countdownSetting); value != "on" {
+		t.Fatalf("countdown = %q, want the default profile's", value)
+	}
+
+	// A group registering again keeps what it changed meanwhile
+	if err := database.SetGroupSetting(ctx, db, testGroupID, countdownSetting, "off"); err != nil {
+		t.Fatalf("SetGroupSetting: %v", err)
+	}
+	if _, err := database.DeactivateGroup(ctx, db, testGroupID); err != nil {
+		t.Fatalf("DeactivateGroup: %v", err)
+	}
+	register(testGroupID)
+	if value, _, _ := database.GetGroupSetting(ctx, db, testGroupID, countdownSetting); value != "off" {
+		t.Fatalf("countdown = %q after registering again, want the group's own", value)
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"example.com/random_coffee/database"
+	"example.com/random_coffee/pkg/pairing"
+	"github.com/NicoNex/echotron/v3"
+	"github.com/rs/zerolog/log"
+)
+
+// metCouples returns the couples of participants that are not among the available pairs, i.e. met before
+func metCouples(participants []database.Participant, availablePairs [][2]database.Participant) [][2]int64 {
+	available := make(map[[2]int64]bool, len(availablePairs))
+	for _, pair := range availablePairs {
+		a, b := pair[0].UserID, pair[1].UserID
+		available[[2]int64{min(a, b), max(a, b)}] = true
+	}
+
+	var met [][2]int64
+	for i, p := range participants {
+		for _, q := range participants[i+1:] {
+			couple := [2]int64{min(p.UserID, q.UserID), max(p.UserID, q.UserID)}
+			if !available[couple] {
+				met = append(met, couple)
+			}
+		}
+	}
+	return met
+}
+
+// abortPairingRun handles a run whose pairs failed the quality check: nothing is saved or announced and
+// the participants stay signed up, so the run can be repeated with /create_pairs once the cause is found.
+// Admins get the violations and the run trace as a file.
+func abortPairingRun(ctx context.Context, db *sql.DB, api echotron.API, groupID int64, participants []database.Participant, qe *pairing.QualityError) {
+	weekStart := getWeekStart(time.Now())
+	cycleEvent(log.Error(), EventPairsQualityFailed, groupID, weekStart).Err(qe).Int("participants", len(participants)).
+		Msg("Pairs failed the quality check, nothing saved")
+
+	sendMessage(api, "⚠️ Пары не созданы: подбор не прошел автоматическую проверку. Записи сохранены, админы уже разбираются", groupID)
+
+	text := fmt.Sprintf("🚨 Подбор пар в группе %s не прошел проверку, пары не сохранены и не объявлены:\n", groupLabel(ctx, db, groupID))
+	for _, v := range qe.Violations {
+		text += "• " + v.String() + "\n"
+	}
+	text += "\nУчастники остались записаны: после исправления можно запустить /create_pairs в группе. Ход подбора - в файле"
+	notifyAdmins(api, text)
+
+	trace := []byte(formatPairingTrace(groupID, weekStart, participants, qe))
+	for adminID := range adminChatIDsMap {
+		file := echotron.NewInputFileBytes(fmt.Sprintf("pairing_trace_%d_%s.txt", groupID, weekStart), trace)
+		if _, err := api.SendDocument(file, adminID, nil); err != nil {
+			botEvent(log.Error(), EventPairsQualityFailed).Err(err).Int64("chat_id", adminID).Msg("SendDocument failed")
+		}
+	}
+}
+
+// formatPairingTrace describes what a failed run started from and what it proposed
+func formatPairingTrace(groupID int64, weekStart string, participants []database.Participant, qe *pairing.QualityError) string {
+	names := make(map[int64]string, len(participants))
+	for _, p := range participants {
+		names[p.UserID] = fmt.Sprintf("%d %s", p.UserID, getDisplayName(p))
+	}
+	name := func(id int64) string {
+		if n, ok := names[id]; ok {
+			return n
+		}
+		return fmt.Sprintf("%d (not a participant)", id)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "group %d, cycle %s\n\n", groupID, weekStart)
+	fmt.Fprintf(&b, "participants: %d\n", len(qe.Snapshot.Participants))
+	for _, id := range qe.Snapshot.Participants {
+		fmt.Fprintf(&b, "  %s\n", name(id))
+	}
+	fmt.Fprintf(&b, "couples that met before: %d\n", len(qe.Snapshot.Met))
+	fmt.Fprintf(&b, "excluded couples: %d\n", len(qe.Snapshot.Excluded))
+	fmt.Fprintf(&b, "repeat fallback engaged: %t\n", qe.Proposal.RelaxedHistory)
+	fmt.Fprintf(&b, "unavoidable unpaired: %d, tolerance %d\n", pairing.MinUnpaired(qe.Snapshot, qe.Proposal.RelaxedHistory), unpairedTolerance())
+
+	b.WriteString("\npost-processing:\n")
+	for _, note := range qe.Proposal.Notes {
+		fmt.Fprintf(&b, "  %s\n", note)
+	}
+
+	b.WriteString("\nproposed meetings:\n")
+	for _, m := range qe.Proposal.Meetings {
+		members := make([]string, 0, len(m))
+		for _, id := range m {
+			members = append(members, name(id))
+		}
+		fmt.Fprintf(&b, "  %s\n", strings.Join(members, " + "))
+	}
+	b.WriteString("unpaired:\n")
+	for _, id := range qe.Proposal.Unpaired {
+		fmt.Fprintf(&b, "  %s\n", name(id))
+	}
+
+	b.WriteString("\nviolations:\n")
+	for _, v := range qe.Violations {
+		fmt.Fprintf(&b, "  %s\n", v)
+	}
+	return b.String()
+}
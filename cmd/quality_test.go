@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"example.com/random_coffee/database"
+	"example.com/random_coffee/pkg/pairing"
+)
+
+// vetoAll is a broken processor that sends everyone home; the result is valid but fails the quality check
+type vetoAll struct{}
+
+func (vetoAll) Adjust(_ context.Context, proposal pairing.Proposal, snapshot pairing.Snapshot) (pairing.Proposal, error) {
+	proposal.Meetings = nil
+	proposal.Unpaired = append([]int64(nil), snapshot.Participants...)
+	proposal.Notes = append(proposal.Notes, "vetoed everything")
+	return proposal, nil
+}
+
+// rejoin is a broken processor that puts two users back together, whatever came before it
+type rejoin struct{ a, b int64 }
+
+func (r rejoin) Adjust(_ context.Context, proposal pairing.Proposal, _ pairing.Snapshot) (pairing.Proposal, error) {
+	var meetings [][]int64
+	var unpaired []int64
+	for _, m := range proposal.Meetings {
+		var rest []int64
+		for _, id := range m {
+			if id != r.a && id != r.b {
+				rest = append(rest, id)
+			}
+		}
+		if len(rest) >= 2 {
+			meetings = append(meetings, rest)
+		} else {
+			unpaired = append(unpaired, rest...)
+		}
+	}
+	for _, id := range proposal.Unpaired {
+		if id != r.a && id != r.b {
+			unpaired = append(unpaired, id)
+		}
+	}
+	proposal.Meetings = append(meetings, []int64{r.a, r.b})
+	proposal.Unpaired = unpaired
+	return proposal, nil
+}
+
+func withPostProcessors(t *testing.T, processors ...pairing.PostProcessor) {
+	t.Helper()
+	saved := postProcessors
+	postProcessors = processors
+	t.Cleanup(func() { postProcessors = saved })
+}
+
+func TestRunPostProcessorsCatchesExcludedCoupleBroughtBack(t *testing.T) {
+	participants := make([]database.Participant, 0, 4)
+	for id := int64(1); id <= 4; id++ {
+		participants = append(participants, database.Participant{UserID: id})
+	}
+	meetings := [][]database.Participant{{participants[0], participants[1]}, {participants[2], participants[3]}}
+	processors := []pairing.PostProcessor{pairing.NewExclusions([][2]int64{{1, 2}}), rejoin{1, 2}}
+
+	_, _, _, _, err := runPostProcessors(context.Background(), processors, pairing.Snapshot{}, false, participants, meetings)
+	var qe *pairing.QualityError
+	if !errors.As(err, &qe) {
+		t.Fatalf("runPostProcessors error = %v, want a quality error", err)
+	}
+	if len(qe.Violations) == 0 || qe.Violations[0].Kind != pairing.ViolationExcluded {
+		t.Fatalf("violations = %v, want the excluded couple", qe.Violations)
+	}
+}
+
+func TestCreatePairsAbortsOnQualityFailure(t *testing.T) {
+	db := openTestDB(t)
+	tg, api := newFakeTelegram(t)
+	withAdmins(t, testAdminID)
+	seedTestGroup(t, db, testGroupID, "Coffee")
+	withPostProcessors(t, vetoAll{})
+	ctx := context.Background()
+	signUp(t, db, testGroupID, 1, 2, 3, 4, 5, 6)
+
+	CreatePairs(ctx, db, api, testGroupID)
+
+	if history, _ := database.GetPairHistory(ctx, db, testGroupID); len(history) != 0 {
+		t.Fatalf("stored %d pairs of a rejected run", len(history))
+	}
+	if n, _ := database.CountParticipants(ctx, db, testGroupID); n != 6 {
+		t.Fatalf("%d participants left signed up, want all 6", n)
+	}
+	if got := tg.sent(testGroupID); len(got) != 1 || !strings.Contains(got[0], "Пары не созданы") {
+		t.Fatalf("group got %q, want the run reported as stopped", got)
+	}
+	alerts := strings.Join(tg.sent(testAdminID), "\n")
+	if !strings.Contains(alerts, pairing.ViolationUnpaired) {
+		t.Fatalf("admins got %q, want the violation listed", alerts)
+	}
+	if n := tg.count("sendDocument"); n != 1 {
+		t.Fatalf("sent %d trace files, want 1", n)
+	}
+}
+
+func TestCreatePairsKeepsListedExclusionsApart(t *testing.T) {
+	db := openTestDB(t)
+	_, api := newFakeTelegram(t)
+	seedTestGroup(t, db, testGroupID, "Coffee")
+	withPostProcessors(t, pairing.NewExclusions([][2]int64{{1, 2}, {3, 4}}))
+	ctx := context.Background()
+
+	for run := 0; run < 20; run++ {
+		signUp(t, db, testGroupID, 1, 2, 3, 4)
+		if _, err := db.ExecContext(ctx, `DELETE FROM pair`); err != nil {
+			t.Fatal(err)
+		}
+		CreatePairs(ctx, db, api, testGroupID)
+
+		history, err := database.GetPairHistory(ctx, db, testGroupID)
+		if err != nil || len(history) != 2 {
+			t.Fatalf("GetPairHistory = %+v, %v; want 2 pairs", history, err)
+		}
+		for _, p := range history {
+			if couple := [2]int64{min(p.User1ID, p.User2ID), max(p.User1ID, p.User2ID)}; couple == [2]int64{1, 2} || couple == [2]int64{3, 4} {
+				t.Fatalf("excluded couple %v paired", couple)
+			}
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"example.com/random_coffee/database"
+	"github.com/NicoNex/echotron/v3"
+	"github.com/rs/zerolog/log"
+)
+
+// readPool is the read-only connection pool for exports, stats and snapshots, set up by openReadPool.
+// Nil when there is none, e.g. in CLI commands or for an in-memory database.
+var readPool *sql.DB
+
+// readerDB returns the pool heavy reads should go through: the read-only one when it is open, else db itself
+func readerDB(db *sql.DB) *sql.DB {
+	if readPool != nil {
+		return readPool
+	}
+	return db
+}
+
+// openReadPool switches the database to WAL and opens the read-only pool next to the writer. Without
+// either, heavy reads keep using the writer pool: slower for votes under load, but still correct.
+func openReadPool(ctx context.Context, db *sql.DB, dbPath string) {
+	if err := database.EnableWAL(ctx, db); err != nil {
+		botEvent(log.Warn(), EventDBReaderFailed).Err(err).Msg("EnableWAL failed, readers may block vote writes")
+	}
+
+	reader, err := database.OpenReader(ctx, dbPath)
+	if err != nil {
+		botEvent(log.Warn(), EventDBReaderFailed).Err(err).Msg("OpenReader failed, heavy reads share the writer pool")
+		return
+	}
+	readPool = reader
+	botEvent(log.Info(), EventDBReaderOpened).Msg("Read-only pool opened for exports and stats")
+}
+
+// exportRegistry tracks running admin exports so their owner can abort them with /cancel_export
+type exportRegistry struct {
+	mu      sync.Mutex
+	nextID  int
+	cancels map[int64]map[int]context.CancelFunc // by admin, then by export
+}
+
+var runningExports = &exportRegistry{cancels: make(map[int64]map[int]context.CancelFunc)}
+
+// start derives the export's context, bounded by EXPORT_TIMEOUT_SECONDS, and registers it under the
+// admin; the returned func must be called when the export ends
+func (r *exportRegistry) start(ctx context.Context, userID int64) (context.Context, func()) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(envInt("EXPORT_TIMEOUT_SECONDS", 300))*time.Second)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.nextID++
+	id := r.nextID
+	if r.cancels[userID] == nil {
+		r.cancels[userID] = make(map[int]context.CancelFunc)
+	}
+	r.cancels[userID][id] = cancel
+
+	return ctx, func() {
+		cancel()
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		delete(r.cancels[userID], id)
+		if len(r.cancels[userID]) == 0 {
+			delete(r.cancels, userID)
+		}
+	}
+}
+
+// cancel aborts the admin's running exports and returns how many there were
+func (r *exportRegistry) cancel(userID int64) int {
+	r.mu.Lock()
+	cancels := r.cancels[userID]
+	delete(r.cancels, userID)
+	r.mu.Unlock()
+
+	for _, c := range cancels {
+		c()
+	}
+	return len(cancels)
+}
+
+// isExportAborted reports whether an export stopped because it was cancelled or ran out of time
+func isExportAborted(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// handleCancelExportCommand implements /cancel_export in a private chat: aborts the admin's running exports
+func handleCancelExportCommand(api echotron.API, message *echotron.Message) {
+	chatID := message.Chat.ID
+	if !isAdmin(message.From.ID) {
+		sendMessage(api, "❌ Доступ запрещен", chatID)
+		return
+	}
+
+	if n := runningExports.cancel(message.From.ID); n == 0 {
+		sendMessage(api, "Нет выполняющихся выгрузок", chatID)
+	} else {
+		sendMessage(api, fmt.Sprintf("⏹ Прервано выгрузок: %d", n), chatID)
+	}
+}
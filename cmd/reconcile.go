@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"example.com/random_coffee/database"
+	"github.com/NicoNex/echotron/v3"
+	"github.com/rs/zerolog/log"
+)
+
+// Indexes of "Да!" and "Нет" in the quiz options
+const (
+	pollYesOption = 0
+	pollNoOption  = 1
+)
+
+// reconcilePoll compares the closed poll's totals with the number of stored sign-ups
+func reconcilePoll(poll *echotron.Poll, storedYes int) database.PollReconciliation {
+	r := database.PollReconciliation{
+		PollID:      poll.ID,
+		StoredYes:   storedYes,
+		TotalVoters: poll.TotalVoterCount,
+	}
+	votes := func(option int) int {
+		if len(poll.Options) > option && poll.Options[option] != nil {
+			return poll.Options[option].VoterCount
+		}
+		return 0
+	}
+	r.PollYes = votes(pollYesOption)
+	r.PollNo = votes(pollNoOption)
+	return r
+}
+
+// formatDiff renders a discrepancy with its direction, e.g. "+2" or "-1"
+func formatDiff(diff int) string {
+	if diff > 0 {
+		return fmt.Sprintf("+%d", diff)
+	}
+	return fmt.Sprintf("%d", diff)
+}
+
+// isPersistentDiscrepancy reports whether each of the latest weeks reconciliations,
+// newest first, is off by at least threshold
+func isPersistentDiscrepancy(recent []database.PollReconciliation, weeks, threshold int) bool {
+	if len(recent) < weeks {
+		return false
+	}
+	for _, r := range recent[:weeks] {
+		diff := r.Diff()
+		if diff < 0 {
+			diff = -diff
+		}
+		if diff < threshold {
+			return false
+		}
+	}
+	return true
+}
+
+// stopSignups closes the group's poll or sign-up buttons at pairing time, before the sign-ups are read,
+// so no answer arrives after them. It returns the group's poll mapping, nil without an open poll, and the
+// final state of a stopped poll, nil for buttons or when the poll could not be stopped.
+func stopSignups(ctx context.Context, db *sql.DB, api echotron.API, groupID int64) (*database.PollMapping, *echotron.Poll) {
+	pm, err := database.GetPollMappingByGroupID(ctx, db, groupID)
+	if err != nil {
+		groupEvent(log.Warn(), EventPairsPollLookupFailed, groupID).Err(err).Msg("GetPollMappingByGroupID failed (no active poll)")
+		return nil, nil
+	}
+	if pm == nil {
+		return nil, nil
+	}
+	// Buttons keep no tally of their own to reconcile against
+	if pm.Kind == database.SignupKeyboard {
+		closeSignupKeyboard(api, pm)
+		return pm, nil
+	}
+
+	res, err := api.StopPoll(groupID, int(pm.MessageID), nil)
+	if err != nil || res.Result == nil {
+		cycleEvent(log.Warn(), EventReconcileFailed, groupID, getWeekStart(time.Now())).Err(err).Str("poll_id", pm.PollID).
+			Msg("StopPoll failed, poll not reconciled")
+		return pm, nil
+	}
+	return pm, res.Result
+}
+
+// reconcileClosedPoll compares the stopped poll's votes with the sign-ups pairs are made from.
+// Discrepancies are reported to admins; it never blocks pairing.
+func reconcileClosedPoll(ctx context.Context, db *sql.DB, api echotron.API, pm *database.PollMapping, poll *echotron.Poll, storedYes int) {
+	groupID := pm.GroupID
+	weekStart := getWeekStart(time.Now())
+
+	r := reconcilePoll(poll, storedYes)
+	r.PollID = pm.PollID
+	r.GroupID = groupID
+	r.WeekStart = weekStart
+	r.CreatedAt = time.Now()
+
+	if err := database.SavePollReconciliation(ctx, db, r); err != nil {
+		cycleEvent(log.Error(), EventReconcileFailed, groupID, weekStart).Err(err).Msg("SavePollReconciliation failed")
+	}
+
+	if r.Diff() == 0 {
+		cycleEvent(log.Info(), EventReconciled, groupID, weekStart).Int("poll_yes", r.PollYes).Msg("Poll matches stored participants")
+		return
+	}
+
+	cycleEvent(log.Warn(), EventReconcileMismatch, groupID, weekStart).Int("poll_yes", r.PollYes).Int("poll_no", r.PollNo).
+		Int("stored_yes", r.StoredYes).Int("total_voters", r.TotalVoters).Int("diff", r.Diff()).Msg("Poll and stored participants differ")
+	notifyAdmins(api, formatReconcileMismatch(groupTitle(ctx, db, groupID), r))
+
+	weeks := envInt("RECONCILE_ALERT_WEEKS", 3)
+	threshold := envInt("RECONCILE_ALERT_THRESHOLD", 2)
+	recent, err := database.GetRecentPollReconciliations(ctx, db, groupID, weeks)
+	if err != nil {
+		cycleEvent(log.Warn(), EventReconcileFailed, groupID, weekStart).Err(err).Msg("GetRecentPollReconciliations failed")
+		return
+	}
+	if isPersistentDiscrepancy(recent, weeks, threshold) {
+		// Logged as an error so it reaches admins as a separate alert
+		cycleEvent(log.Error(), EventReconcilePersistent, groupID, weekStart).Int("weeks", weeks).Int("threshold", threshold).
+			Msg("Poll and stored participants keep diverging, check for missed poll answers")
+	}
+}
+
+// formatReconcileMismatch tells admins how the poll and the stored sign-ups differ
+func formatReconcileMismatch(title string, r database.PollReconciliation) string {
+	return fmt.Sprintf("⚖️ Группа %s: в опросе «Да» - %d, в базе участников - %d (%s). «Нет» - %d, всего проголосовало: %d",
+		title, r.PollYes, r.StoredYes, formatDiff(r.Diff()), r.PollNo, r.TotalVoters)
+}
+
+// formatReconciliationTrend lists recent discrepancies, newest first, for /stats
+func formatReconciliationTrend(recent []database.PollReconciliation) string {
+	diffs := make([]string, 0, len(recent))
+	for _, r := range recent {
+		diffs = append(diffs, formatDiff(r.Diff()))
+	}
+	return strings.Join(diffs, ", ")
+}
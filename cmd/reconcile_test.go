@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"example.com/random_coffee/database"
+	"github.com/NicoNex/echotron/v3"
+	"github.com/google/uuid"
+)
+
+func testPoll(yes, no int) *echotron.Poll {
+	return &echotron.Poll{
+		ID:              "poll",
+		TotalVoterCount: yes + no,
+		Options:         []*echotron.PollOption{{Text: "Да!", VoterCount: yes}, {Text: "Нет", VoterCount: no}},
+	}
+}
+
+func TestReconcilePoll(t *testing.T) {
+	tests := []struct {
+		name       string
+		poll       *echotron.Poll
+		storedYes  int
+		wantDiff   int
+		wantReport string
+	}{
+		{"match", testPoll(5, 2), 5, 0, "«Да» - 5, в базе участников - 5 (0). «Нет» - 2, всего проголосовало: 7"},
+		{"votes missed", testPoll(6, 1), 4, -2, "«Да» - 6, в базе участников - 4 (-2). «Нет» - 1, всего проголосовало: 7"},
+		{"extra sign-ups", testPoll(3, 0), 4, 1, "«Да» - 3, в базе участников - 4 (+1). «Нет» - 0, всего проголосовало: 3"},
+		{"no options", &echotron.Poll{ID: "poll"}, 2, 2, "«Да» - 0, в базе участников - 2 (+2)"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := reconcilePoll(tt.poll, tt.storedYes)
+			if r.Diff() != tt.wantDiff {
+				t.Fatalf("Diff = %d, want %d", r.Diff(), tt.wantDiff)
+			}
+			if report := formatReconcileMismatch("Coffee", r); !strings.Contains(report, tt.wantReport) {
+				t.Fatalf("report = %q, want it to contain %q", report, tt.wantReport)
+			}
+		})
+	}
+}
+
+func TestIsPersistentDiscrepancy(t *testing.T) {
+	diffs := func(ds ...int) []database.PollReconciliation {
+		recent := make([]database.PollReconciliation, 0, len(ds))
+		for _, d := range ds {
+			recent = append(recent, database.PollReconciliation{PollYes: 10, StoredYes: 10 + d})
+		}
+		return recent
+	}
+
+	tests := []struct {
+		name   string
+		recent []database.PollReconciliation
+		want   bool
+	}{
+		{"every week off", diffs(-2, 3, -2), true},
+		{"one week fine", diffs(-2, 0, -2), false},
+		{"below threshold", diffs(-1, -1, -1), false},
+		{"too few weeks", diffs(-5, -5), false},
+		{"only the latest weeks count", diffs(-2, -2, -2, 0), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isPersistentDiscrepancy(tt.recent, 3, 2); got != tt.want {
+				t.Fatalf("isPersistentDiscrepancy = %v, want %v", got, tt.want)
+			}
+		})
+	}
+	if trend := formatReconciliationTrend(diffs(-2, 0, 3)); trend != "-2, 0, +3" {
+		t.Fatalf("trend = %q", trend)
+	}
+}
+
+func TestCreatePairsStopsPollBeforeReadingSignups(t *testing.T) {
+	db := openTestDB(t)
+	tg, api := newFakeTelegram(t)
+	withAdmins(t, testAdminID)
+	seedTestGroup(t, db, testGroupID, "Coffee")
+	ctx := context.Background()
+
+	if err := database.CreatePollMapping(ctx, db, database.PollMapping{PollID: "poll", GroupID: testGroupID, MessageID: 7}); err != nil {
+		t.Fatal(err)
+	}
+	signUp(t, db, testGroupID, 1, 2, 3)
+	// A vote lands just before Telegram closes the poll: it is in the poll's final count and in the database
+	tg.reply("stopPoll", func(url.Values) string {
+		late := database.Participant{ID: uuid.New(), GroupID: testGroupID, UserID: 4, CreatedAt: time.Now()}
+		if err := database.CreateOrUpdateParticipant(ctx, db, late); err != nil {
+			return `{"ok":false,"error_code":500,"description":"` + err.Error() + `"}`
+		}
+		return `{"ok":true,"result":{"id":"poll","total_voter_count":5,"options":[{"text":"Да!","voter_count":4},{"text":"Нет","voter_count":1}]}}`
+	})
+
+	CreatePairs(ctx, db, api, testGroupID)
+
+	recent, err := database.GetRecentPollReconciliations(ctx, db, testGroupID, 1)
+	if err != nil || len(recent) != 1 {
+		t.Fatalf("GetRecentPollReconciliations = %+v, %v", recent, err)
+	}
+	if r := recent[0]; r.Diff() != 0 || r.PollNo != 1 || r.TotalVoters != 5 {
+		t.Fatalf("reconciliation = %+v, want the late vote counted on both sides", r)
+	}
+	if history, _ := database.GetPairHistory(ctx, db, testGroupID); len(history) != 2 {
+		t.Fatalf("stored %d pairs, want the late voter paired too", len(history))
+	}
+	for _, text := range tg.sent(testAdminID) {
+		if strings.Contains(text, "⚖️") {
+			t.Fatalf("admins alerted about a mismatch: %q", text)
+		}
+	}
+	if n := tg.count("stopPoll"); n != 1 {
+		t.Fatalf("stopPoll called %d times", n)
+	}
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"time"
+)
+
+// withRetry calls fn up to attempts times, doubling the delay after every failure.
+// It returns the last error if all attempts fail.
+func withRetry(attempts int, delay time.Duration, fn func() error) error {
+	var err error
+	for i := 0; i < attempts; i++ {
+		if err = fn(); err == nil {
+			return nil
+		}
+		if i < attempts-1 {
+			time.Sleep(delay)
+			delay *= 2
+		}
+	}
+	return err
+}
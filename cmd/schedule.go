@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"example.com/random_coffee/database"
+	"github.com/NicoNex/echotron/v3"
+	"github.com/rs/zerolog/log"
+)
+
+const defaultTimezone = "Europe/Moscow"
+
+// weeklyTime is a time of the week in a schedule's timezone
+type weeklyTime struct {
+	weekday      time.Weekday
+	hour, minute int
+}
+
+// next returns the first occurrence strictly after from
+func (w weeklyTime) next(from time.Time, location *time.Location) time.Time {
+	return nextOccurrence(from.In(location), w.weekday, w.hour, w.minute, location)
+}
+
+func (w weeklyTime) String() string {
+	return fmt.Sprintf("%s %02d:%02d", weekdayNames[w.weekday], w.hour, w.minute)
+}
+
+// groupSchedule is the effective weekly schedule of a group
+type groupSchedule struct {
+	quiz     weeklyTime
+	pairs    weeklyTime
+	timezone string
+	location *time.Location
+	deferral *quizDeferral // a one-off shift of the upcoming quiz, nil without one
+}
+
+// defaultSchedule is used for groups that never ran /schedule: Friday 17:00 quiz, Sunday 19:00 pairs, Moscow time
+func defaultSchedule() groupSchedule {
+	location, err := time.LoadLocation(defaultTimezone)
+	if err != nil {
+		botEvent(log.Error(), EventSchedulerTZFailed).Err(err).Str("timezone", defaultTimezone).Msg("Failed to load default timezone, using UTC")
+		location = time.UTC
+	}
+	return groupSchedule{
+		quiz:     weeklyTime{weekday: time.Friday, hour: 17, minute: 0},
+		pairs:    weeklyTime{weekday: time.Sunday, hour: 19, minute: 0},
+		timezone: defaultTimezone,
+		location: location,
+	}
+}
+
+// loadGroupSchedule returns the group's schedule with its pending quiz deferral
+func loadGroupSchedule(ctx context.Context, db *sql.DB, groupID int64) groupSchedule {
+	sched := loadWeeklySchedule(ctx, db, groupID)
+	sched.deferral = loadQuizDeferral(ctx, db, groupID, time.Now())
+	return sched
+}
+
+// loadWeeklySchedule returns the group's weekly schedule, falling back to the default when it has none or it is unreadable
+func loadWeeklySchedule(ctx context.Context, db *sql.DB, groupID int64) groupSchedule {
+	sc, err := database.GetGroupConfig(ctx, db, groupID)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			groupEvent(log.Error(), EventScheduleReadFailed, groupID).Err(err).Msg("GetGroupConfig failed, using default schedule")
+		}
+		return defaultSchedule()
+	}
+
+	location, err := time.LoadLocation(sc.Timezone)
+	if err != nil {
+		groupEvent(log.Error(), EventSchedulerTZFailed, groupID).Err(err).Str("timezone", sc.Timezone).Msg("Unknown timezone, using default schedule")
+		return defaultSchedule()
+	}
+
+	return groupSchedule{
+		quiz:     weeklyTime{weekday: time.Weekday(sc.QuizWeekday), hour: sc.QuizHour, minute: sc.QuizMinute},
+		pairs:    weeklyTime{weekday: time.Weekday(sc.PairsWeekday), hour: sc.PairsHour, minute: sc.PairsMinute},
+		timezone: sc.Timezone,
+		location: location,
+	}
+}
+
+// nextPairsTime returns when pairs will next be created in the group after now
+func nextPairsTime(ctx context.Context, db *sql.DB, groupID int64, now time.Time) time.Time {
+	sched := loadGroupSchedule(ctx, db, groupID)
+	return sched.pairs.next(now, sched.location)
+}
+
+// nextQuiz returns when the group's next quiz runs after now: the deferred time while a deferral is
+// pending, which also holds back the occurrence it replaces, else the weekly time
+func (s groupSchedule) nextQuiz(now time.Time) time.Time {
+	if d := s.deferral; d != nil && now.Before(d.at) {
+		return d.at
+	}
+	return s.quiz.next(now, s.location)
+}
+
+// quizToPairsGap returns how long the poll stays open: from the quiz to the next pair creation
+func (s groupSchedule) quizToPairsGap() time.Duration {
+	quizAt := s.quiz.next(time.Now(), s.location)
+	return s.pairs.next(quizAt, s.location).Sub(quizAt)
+}
+
+var weekdayNames = map[time.Weekday]string{
+	time.Monday:    "пн",
+	time.Tuesday:   "вт",
+	time.Wednesday: "ср",
+	time.Thursday:  "чт",
+	time.Friday:    "пт",
+	time.Saturday:  "сб",
+	time.Sunday:    "вс",
+}
+
+var weekdayAliases = map[string]time.Weekday{
+	"mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday, "thu": time.Thursday,
+	"fri": time.Friday, "sat": time.Saturday, "sun": time.Sunday,
+	"пн": time.Monday, "вт": time.Tuesday, "ср": time.Wednesday, "чт": time.Thursday,
+	"пт": time.Friday, "сб": time.Saturday, "вс": time.Sunday,
+}
+
+// parseWeeklyTime parses a weekday and a time like "fri 17:00"
+func parseWeeklyTime(day, clock string) (weeklyTime, error) {
+	weekday, ok := weekdayAliases[strings.ToLower(day)]
+	if !ok {
+		return weeklyTime{}, fmt.Errorf("неизвестный день недели %q, используй mon..sun или пн..вс", day)
+	}
+
+	t, err := time.Parse("15:04", clock)
+	if err != nil {
+		return weeklyTime{}, fmt.Errorf("некорректное время %q, используй формат ЧЧ:ММ", clock)
+	}
+
+	return weeklyTime{weekday: weekday, hour: t.Hour(), minute: t.Minute()}, nil
+}
+
+func formatSchedule(s groupSchedule) string {
+	return fmt.Sprintf("📅 Расписание группы:\n• Опрос: %s\n• Пары: %s\n• Часовой пояс: %s", s.quiz, s.pairs, s.timezone)
+}
+
+// handleScheduleCommand implements /schedule in a group: shows the schedule or changes one of its parts.
+// /set_schedule <quiz|pairs> <day> <ЧЧ:ММ> and /set_timezone <tz> are routed here as well.
+func handleScheduleCommand(ctx context.Context, db *sql.DB, api echotron.API, message *echotron.Message, args []string) {
+	groupID := message.Chat.ID
+	sched := loadGroupSchedule(ctx, db, groupID)
+	old := sched
+
+	usage := "Использование:\n" +
+		"/set_schedule quiz fri 17:00 - время опроса\n" +
+		"/set_schedule pairs sun 19:00 - время создания пар\n" +
+		"/set_timezone Europe/Berlin - часовой пояс"
+
+	if len(args) == 0 {
+		text := formatSchedule(sched)
+		if sched.deferral != nil {
+			text += "\n⏰ Ближайший " + sched.deferral.String() + " (/defer_quiz)"
+		}
+		if skip := nextHolidaySkip(ctx, db, groupID, sched, time.Now()); skip != nil {
+			text += fmt.Sprintf("\n🏖 Неделя с опросом %s пропускается: %s", skip.quizAt.Format("02.01"), skip)
+		}
+		sendMessage(api, text+"\n\n"+usage, groupID)
+		return
+	}
+
+	switch {
+	case (args[0] == "quiz" || args[0] == "pairs") && len(args) == 3:
+		wt, err := parseWeeklyTime(args[1], args[2])
+		if err != nil {
+			sendMessage(api, "❌ "+err.Error(), groupID)
+			return
+		}
+		if args[0] == "quiz" {
+			sched.quiz = wt
+		} else {
+			sched.pairs = wt
+		}
+
+	case args[0] == "tz" && len(args) == 2:
+		location, err := time.LoadLocation(args[1])
+		if err != nil || args[1] == "" || args[1] == "Local" {
+			sendMessage(api, fmt.Sprintf("❌ Неизвестный часовой пояс %q, используй имя из базы IANA, например Europe/Berlin", args[1]), groupID)
+			return
+		}
+		sched.timezone = args[1]
+		sched.location = location
+
+	default:
+		sendMessage(api, usage, groupID)
+		return
+	}
+
+	if sched.quiz == sched.pairs {
+		sendMessage(api, "❌ Опрос и создание пар не могут быть в одно время", groupID)
+		return
+	}
+
+	// Scheduled runs skip the minimum notice check, so the schedule itself must respect it
+	if minNotice := getMinNotice(ctx, db, groupID); sched.quizToPairsGap() < minNotice {
+		sendMessage(api, fmt.Sprintf("❌ Между опросом и созданием пар должно быть не меньше %s (см. /min_notice)",
+			formatHoursMinutes(minNotice)), groupID)
+		return
+	}
+
+	sc := database.GroupConfig{
+		GroupID:      groupID,
+		QuizWeekday:  int(sched.quiz.weekday),
+		QuizHour:     sched.quiz.hour,
+		QuizMinute:   sched.quiz.minute,
+		PairsWeekday: int(sched.pairs.weekday),
+		PairsHour:    sched.pairs.hour,
+		PairsMinute:  sched.pairs.minute,
+		Timezone:     sched.timezone,
+	}
+	if err := database.UpsertGroupConfig(ctx, db, sc); err != nil {
+		groupEvent(log.Error(), EventScheduleSaveFailed, groupID).Err(err).Msg("UpsertGroupConfig failed")
+		sendMessage(api, "❌ Не удалось сохранить расписание", groupID)
+		return
+	}
+
+	note := recheckQuizDeferral(ctx, db, groupID, old, sched)
+	rescheduleGroup(groupID)
+
+	writeAudit(ctx, db, message.From.ID, "schedule", groupID, strings.Join(args, " "))
+	groupEvent(log.Info(), EventJobScheduled, groupID).Str("quiz", sched.quiz.String()).Str("pairs", sched.pairs.String()).
+		Str("timezone", sched.timezone).Msg("Schedule changed")
+	sendMessage(api, "✅ Расписание обновлено\n\n"+formatSchedule(sched)+note, groupID)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/NicoNex/echotron/v3"
+	"github.com/rs/zerolog/log"
+)
+
+// sessionSweepInterval is how often idle dispatcher sessions are looked for
+const sessionSweepInterval = 10 * time.Minute
+
+// sessionTracker keeps track of the per-chat Bot instances created by the echotron dispatcher.
+// The dispatcher never drops them on its own, so idle ones are expired here.
+// Bots hold no state of their own (everything lives in the DB), so an expired
+// session is simply recreated by the dispatcher on the chat's next update.
+type sessionTracker struct {
+	mu       sync.Mutex
+	lastSeen map[int64]time.Time
+}
+
+var sessions = &sessionTracker{lastSeen: make(map[int64]time.Time)}
+
+// touch records activity in a chat
+func (t *sessionTracker) touch(chatID int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.lastSeen[chatID] = time.Now()
+}
+
+// count returns the number of live sessions
+func (t *sessionTracker) count() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.lastSeen)
+}
+
+// expire forgets sessions idle for longer than idle and returns their chat IDs
+func (t *sessionTracker) expire(idle time.Duration) []int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	expired := make([]int64, 0)
+	for chatID, seen := range t.lastSeen {
+		if time.Since(seen) > idle {
+			expired = append(expired, chatID)
+			delete(t.lastSeen, chatID)
+		}
+	}
+	return expired
+}
+
+// trackedBotFactory wraps the dispatcher's bot factory so every created instance is tracked
+func trackedBotFactory(newBot echotron.NewBotFn) echotron.NewBotFn {
+	return func(chatID int64) echotron.Bot {
+		sessions.touch(chatID)
+		countOps(counterSessionsCreated)
+		return newBot(chatID)
+	}
+}
+
+// sweepSessions removes the dispatcher sessions idle for longer than idle
+func sweepSessions(dsp *echotron.Dispatcher, idle time.Duration) {
+	expired := sessions.expire(idle)
+	for _, chatID := range expired {
+		dsp.DelSession(chatID)
+	}
+	if len(expired) > 0 {
+		opsCounters.add(counterSessionsExpired, int64(len(expired)))
+		botEvent(log.Info(), EventSessionsExpired).Int("expired_count", len(expired)).Int("sessions_count", sessions.count()).Msg("Idle sessions expired")
+	}
+}
+
+// startSessionSweeper periodically removes dispatcher sessions idle for longer than idle
+func startSessionSweeper(dsp *echotron.Dispatcher, idle time.Duration, stopChan chan struct{}) {
+	scheduler.startWorker("session_sweeper", func() {
+		ticker := time.NewTicker(sessionSweepInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				sweepSessions(dsp, idle)
+			case <-stopChan:
+				return
+			}
+		}
+	})
+}
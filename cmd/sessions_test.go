@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"example.com/random_coffee/database"
+	"github.com/NicoNex/echotron/v3"
+)
+
+// deliverUpdate hands the update to the dispatcher the way the webhook does
+func deliverUpdate(t *testing.T, dsp *echotron.Dispatcher, u echotron.Update) {
+	t.Helper()
+	body, err := json.Marshal(u)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	dsp.HandleWebhook(httptest.NewRecorder(), httptest.NewRequest("POST", "/", bytes.NewReader(body)))
+}
+
+// pollAnswer is an update with the user's answer to the test sign-up poll
+func pollAnswer(updateID int, userID int64, joined bool) echotron.Update {
+	options := []int{}
+	if joined {
+		options = []int{pollYesOption}
+	}
+	user := &echotron.User{ID: userID, Username: "user", FirstName: "User"}
+	return echotron.Update{ID: updateID, PollAnswer: &echotron.PollAnswer{PollID: "poll-1", User: user, OptionIDs: options}}
+}
+
+func TestExpiredSessionIsRecreatedMidCycle(t *testing.T) {
+	db, _, api := setupManualPairsGroup(t)
+	ctx := context.Background()
+	savedSessions := sessions
+	sessions = &sessionTracker{lastSeen: make(map[int64]time.Time)}
+	t.Cleanup(func() { sessions = savedSessions })
+	opsCounters.take()
+
+	created := make(chan int64, 10)
+	dsp := echotron.NewDispatcher("test-token", trackedBotFactory(func(chatID int64) echotron.Bot {
+		created <- chatID
+		return &Bot{ChatID: chatID, DB: db, API: api}
+	}))
+
+	// participants lists who signed up; the check is retried while an update still writes
+	participants := func() []int64 {
+		ps, _ := database.GetAllParticipants(ctx, db, testGroupID)
+		ids := make([]int64, 0, len(ps))
+		for _, p := range ps {
+			ids = append(ids, p.UserID)
+		}
+		return ids
+	}
+
+	// The quiz is out and two users sign up, each answer opening a session
+	if err := database.CreatePollMapping(ctx, db, database.PollMapping{PollID: "poll-1", GroupID: testGroupID, MessageID: 10, Kind: database.SignupPoll}); err != nil {
+		t.Fatalf("CreatePollMapping: %v", err)
+	}
+	deliverUpdate(t, dsp, pollAnswer(1, 1, true))
+	waitFor(t, "the first sign-up", func() bool { return len(participants()) == 1 })
+	deliverUpdate(t, dsp, pollAnswer(2, 2, true))
+	waitFor(t, "the second sign-up", func() bool { return len(participants()) == 2 })
+	if n := sessions.count(); n != 2 {
+		t.Fatalf("%d sessions, want one per user", n)
+	}
+
+	// Both sessions expire before the pairing...
+	sweepSessions(dsp, 0)
+	if n := sessions.count(); n != 0 {
+		t.Fatalf("%d sessions left after the sweep", n)
+	}
+
+	// ...and the next answer recreates its session on top of the stored sign-ups
+	deliverUpdate(t, dsp, pollAnswer(3, 2, false))
+	waitFor(t, "the retracted sign-up", func() bool { return len(participants()) == 1 })
+	if ids := participants(); len(ids) != 1 || ids[0] != 1 {
+		t.Fatalf("participants %v, want user 1's sign-up kept", ids)
+	}
+	var chats []int64
+	for len(created) > 0 {
+		chats = append(chats, <-created)
+	}
+	if len(chats) != 3 || chats[2] != 2 || sessions.count() != 1 {
+		t.Fatalf("sessions created for %v, %d live; want user 2's recreated", chats, sessions.count())
+	}
+
+	week := getWeekStart(time.Now())
+	if counts := opsCounters.take()[week]; counts[counterSessionsCreated] != 3 || counts[counterSessionsExpired] != 2 {
+		t.Fatalf("ops counts %v, want 3 sessions created and 2 expired", counts)
+	}
+}
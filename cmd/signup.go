@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"example.com/random_coffee/database"
+	"github.com/NicoNex/echotron/v3"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// signupModeSetting is the group setting with the sign-up message kind; unset means a poll
+	// with an automatic switch to buttons when the group forbids polls
+	signupModeSetting = "signup_mode"
+
+	signupYesCallback = "signup_yes"
+	signupNoCallback  = "signup_no"
+
+	// quizQuestion is the poll question and the text of the sign-up buttons message
+	quizQuestion = "Участвуешь в Random Coffee на этой неделе? ☕️" + themePlaceholder
+)
+
+// isPollsForbiddenError reports whether a poll could not be sent because the group disabled polls
+func isPollsForbiddenError(err error) bool {
+	return strings.Contains(err.Error(), "POLLS_FORBIDDEN")
+}
+
+// groupSignupMode returns the group's sign-up mode: a message kind, or "" for a poll with the automatic switch
+func groupSignupMode(ctx context.Context, db *sql.DB, groupID int64) string {
+	value, _, err := database.GetGroupSetting(ctx, db, groupID, signupModeSetting)
+	if err != nil {
+		groupEvent(log.Warn(), EventSettingsReadFailed, groupID).Err(err).Str("key", signupModeSetting).Msg("GetGroupSetting failed")
+		return ""
+	}
+	return value
+}
+
+// sendSignupMessage sends the week's sign-up message of the kind the group uses, switching to buttons
+// for good if the group turns out to forbid polls. It returns the kind, poll ID and message ID.
+func sendSignupMessage(ctx context.Context, db *sql.DB, api echotron.API, groupID int64) (string, string, int, error) {
+	mode := groupSignupMode(ctx, db, groupID)
+	theme := groupCycleTheme(ctx, db, groupID)
+	if mode != database.SignupKeyboard {
+		pollID, messageID, err := sendSignupPoll(groupID, theme)
+		if err == nil || mode == database.SignupPoll || !isPollsForbiddenError(err) {
+			return database.SignupPoll, pollID, messageID, err
+		}
+		rememberKeyboardSignup(ctx, db, groupID)
+	}
+
+	pollID, messageID, err := sendSignupKeyboard(api, groupID, theme)
+	return database.SignupKeyboard, pollID, messageID, err
+}
+
+// signupPollQuestion is the quiz poll's question; a poll question is one line, so the theme goes at its end
+func signupPollQuestion(theme string) string {
+	return withTheme(quizQuestion, " Тема: %s", theme)
+}
+
+// sendSignupPoll sends the weekly quiz as a native poll and returns its poll and message IDs.
+// A cycle theme is appended to the question.
+func sendSignupPoll(groupID int64, theme string) (string, int, error) {
+	options := []echotron.InputPollOption{
+		{Text: "Да!"},
+		{Text: "Нет"},
+	}
+
+	// Workaround for echotron bug: IsAnonymous=false is ignored because bool false is zero value
+	// We need to explicitly set is_anonymous=false in the request
+	opts := &echotron.PollOptions{
+		AllowsMultipleAnswers: false,
+	}
+
+	result, err := sendPollNonAnonymous(groupID, signupPollQuestion(theme), options, opts)
+	if err != nil {
+		return "", 0, err
+	}
+	if result.Result == nil || result.Result.Poll == nil {
+		return "", 0, fmt.Errorf("poll result is nil")
+	}
+	return result.Result.Poll.ID, result.Result.ID, nil
+}
+
+// sendSignupKeyboard sends the weekly quiz as a message with sign-up buttons. The returned ID stands in
+// for a poll ID wherever the cycle is keyed by its poll. A cycle theme gets a line of its own.
+func sendSignupKeyboard(api echotron.API, groupID int64, theme string) (string, int, error) {
+	opts := &echotron.MessageOptions{
+		ReplyMarkup: echotron.InlineKeyboardMarkup{InlineKeyboard: signupKeyboard(0)},
+	}
+	res, err := api.SendMessage(withTheme(quizQuestion, "\n\n🎨 Тема недели: %s", theme), groupID, opts)
+	tokenWatcher.sendDone(err)
+	if err != nil {
+		return "", 0, err
+	}
+	if res.Result == nil {
+		return "", 0, fmt.Errorf("message result is nil")
+	}
+	return fmt.Sprintf("keyboard:%d:%d", groupID, res.Result.ID), res.Result.ID, nil
+}
+
+// signupKeyboard renders the sign-up buttons, showing how many people are in so far
+func signupKeyboard(count int) [][]echotron.InlineKeyboardButton {
+	yes := "Участвую ✅"
+	if count > 0 {
+		yes = fmt.Sprintf("Участвую ✅ (%d)", count)
+	}
+	return [][]echotron.InlineKeyboardButton{{
+		{Text: yes, CallbackData: signupYesCallback},
+		{Text: "Не участвую ❌", CallbackData: signupNoCallback},
+	}}
+}
+
+// rememberKeyboardSignup switches the group to sign-up buttons after Telegram refused a poll,
+// so later weeks don't try a poll first
+func rememberKeyboardSignup(ctx context.Context, db *sql.DB, groupID int64) {
+	if err := database.SetGroupSetting(ctx, db, groupID, signupModeSetting, database.SignupKeyboard); err != nil {
+		groupEvent(log.Error(), EventSettingsSaveFailed, groupID).Err(err).Str("key", signupModeSetting).Msg("SetGroupSetting failed")
+	}
+	groupEvent(log.Warn(), EventSignupFallback, groupID).Msg("Polls are forbidden in the group, switched to sign-up buttons")
+	countOps(counterPollsForbidden)
+}
+
+// handleSignupCallback treats a sign-up button press like a poll answer, then updates the count on the buttons.
+// Presses on an old week's message are refused.
+func handleSignupCallback(ctx context.Context, db *sql.DB, api echotron.API, cq *echotron.CallbackQuery, joined bool) {
+	if cq.From == nil || cq.Message == nil {
+		answerCallback(api, cq, "")
+		return
+	}
+	groupID := cq.Message.Chat.ID
+
+	pm, err := database.GetPollMappingByGroupID(ctx, db, groupID)
+	if err != nil {
+		groupEvent(log.Error(), EventPollUnknown, groupID).Err(err).Msg("GetPollMappingByGroupID failed")
+		answerCallback(api, cq, "❌ Не получилось, попробуй еще раз")
+		return
+	}
+	if pm == nil || pm.Kind != database.SignupKeyboard || pm.MessageID != int64(cq.Message.ID) {
+		answerCallback(api, cq, "Запись на эту неделю уже закрыта")
+		return
+	}
+
+	userEvent(log.Info(), EventSignupPress, groupID, cq.From.ID).Str("username", cq.From.Username).Bool("joined", joined).
+		Msg("Sign-up button pressed")
+
+	if !applySignupAnswer(ctx, db, groupID, cq.From, joined, pm.PollID) {
+		answerCallback(api, cq, "❌ Не получилось, попробуй еще раз")
+		return
+	}
+
+	if joined {
+		answerCallback(api, cq, "✅ Ты записан(а) на эту неделю")
+	} else {
+		answerCallback(api, cq, "Ок, в этот раз без тебя")
+	}
+	refreshSignupKeyboard(ctx, db, api, groupID, cq.Message.ID)
+}
+
+// refreshSignupKeyboard shows the current number of sign-ups on the buttons
+func refreshSignupKeyboard(ctx context.Context, db *sql.DB, api echotron.API, groupID int64, messageID int) {
+	count, err := database.CountParticipants(ctx, db, groupID)
+	if err != nil {
+		groupEvent(log.Warn(), EventSignupFailed, groupID).Err(err).Msg("CountParticipants failed")
+		return
+	}
+	setSignupKeyboard(api, groupID, messageID, signupKeyboard(count))
+}
+
+// closeSignupKeyboard removes the buttons at pairing time, so late presses don't look accepted
+func closeSignupKeyboard(api echotron.API, pm *database.PollMapping) {
+	setSignupKeyboard(api, pm.GroupID, int(pm.MessageID), [][]echotron.InlineKeyboardButton{})
+}
+
+func setSignupKeyboard(api echotron.API, groupID int64, messageID int, keyboard [][]echotron.InlineKeyboardButton) {
+	opts := &echotron.MessageReplyMarkupOptions{ReplyMarkup: echotron.InlineKeyboardMarkup{InlineKeyboard: keyboard}}
+	_, err := api.EditMessageReplyMarkup(echotron.NewMessageID(groupID, messageID), opts)
+	// Two presses that leave the count unchanged edit the buttons to what they already are
+	if err != nil && !strings.Contains(err.Error(), "message is not modified") {
+		groupEvent(log.Warn(), EventSignupFailed, groupID).Err(err).Int("message_id", messageID).Msg("EditMessageReplyMarkup failed")
+	}
+}
+
+// handleSignupModeCommand implements /signup_mode [poll|buttons|auto] in a group
+func handleSignupModeCommand(ctx context.Context, db *sql.DB, api echotron.API, message *echotron.Message, args []string) {
+	groupID := message.Chat.ID
+
+	if len(args) != 1 {
+		current := "auto"
+		switch groupSignupMode(ctx, db, groupID) {
+		case database.SignupPoll:
+			current = "poll"
+		case database.SignupKeyboard:
+			current = "buttons"
+		}
+		sendMessage(api, fmt.Sprintf("Запись на неделю сейчас: %s.\n"+
+			"Использование: /signup_mode poll|buttons|auto\n"+
+			"• poll - опрос Telegram\n"+
+			"• buttons - сообщение с кнопками, для групп, где опросы запрещены\n"+
+			"• auto - опрос, а если группа запрещает опросы - кнопки", current), groupID)
+		return
+	}
+
+	var err error
+	switch args[0] {
+	case "poll":
+		err = database.SetGroupSetting(ctx, db, groupID, signupModeSetting, database.SignupPoll)
+	case "buttons":
+		err = database.SetGroupSetting(ctx, db, groupID, signupModeSetting, database.SignupKeyboard)
+	case "auto":
+		err = database.DeleteGroupSetting(ctx, db, groupID, signupModeSetting)
+	default:
+		sendMessage(api, "Использование: /signup_mode poll|buttons|auto", groupID)
+		return
+	}
+	if err != nil {
+		groupEvent(log.Error(), EventSettingsSaveFailed, groupID).Err(err).Str("key", signupModeSetting).Msg("Failed to save signup mode")
+		sendMessage(api, "❌ Не удалось сохранить настройку", groupID)
+		return
+	}
+
+	writeAudit(ctx, db, message.From.ID, "signup_mode", groupID, args[0])
+	sendMessage(api, "✅ Сохранено, изменение подействует со следующего опроса", groupID)
+}
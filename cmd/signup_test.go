@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/url"
+	"strings"
+	"testing"
+
+	"example.com/random_coffee/database"
+	"github.com/NicoNex/echotron/v3"
+)
+
+// lastCall returns the parameters of the method's last call
+func (f *fakeTelegram) lastCall(method string) url.Values {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i := len(f.calls) - 1; i >= 0; i-- {
+		if f.calls[i].method == method {
+			return f.calls[i].params
+		}
+	}
+	return nil
+}
+
+// pressSignup presses a sign-up button on the group's message
+func pressSignup(ctx context.Context, db *sql.DB, api echotron.API, userID int64, messageID int, joined bool) {
+	data := signupNoCallback
+	if joined {
+		data = signupYesCallback
+	}
+	HandleCallbackQuery(ctx, db, api, &echotron.CallbackQuery{
+		ID:      "cb",
+		Data:    data,
+		From:    &echotron.User{ID: userID, Username: "user", FirstName: "User"},
+		Message: &echotron.Message{ID: messageID, Chat: echotron.Chat{ID: testGroupID, Type: "supergroup"}},
+	})
+}
+
+// withFakePolls sends sendPollNonAnonymous to the fake API
+func withFakePolls(t *testing.T, tg *fakeTelegram) {
+	t.Helper()
+	t.Setenv("TELEGRAM__TOKEN", "test")
+	saved := telegramBotURL
+	telegramBotURL = tg.url + "bot"
+	t.Cleanup(func() { telegramBotURL = saved })
+}
+
+func TestSignupButtonsJoinAndLeave(t *testing.T) {
+	db, tg, api := setupManualPairsGroup(t)
+	ctx := context.Background()
+	if err := database.SetGroupSetting(ctx, db, testGroupID, signupModeSetting, database.SignupKeyboard); err != nil {
+		t.Fatalf("SetGroupSetting: %v", err)
+	}
+
+	SendQuiz(ctx, db, api, testGroupID)
+	pm, err := database.GetPollMappingByGroupID(ctx, db, testGroupID)
+	if err != nil || pm == nil || pm.Kind != database.SignupKeyboard {
+		t.Fatalf("GetPollMappingByGroupID = %+v, %v; want a buttons message", pm, err)
+	}
+	if markup := tg.lastCall("sendMessage").Get("reply_markup"); !strings.Contains(markup, "Участвую ✅") || !strings.Contains(markup, "Не участвую ❌") {
+		t.Fatalf("sign-up message markup = %q, want both buttons", markup)
+	}
+	messageID := int(pm.MessageID)
+
+	press := func(userID int64, joined bool, wantAnswer string, wantCount int) {
+		t.Helper()
+		pressSignup(ctx, db, api, userID, messageID, joined)
+		if answer := tg.lastCall("answerCallbackQuery").Get("text"); !strings.Contains(answer, wantAnswer) {
+			t.Fatalf("callback answered %q, want %q", answer, wantAnswer)
+		}
+		if n, err := database.CountParticipants(ctx, db, testGroupID); err != nil || n != wantCount {
+			t.Fatalf("CountParticipants = %d, %v; want %d", n, err, wantCount)
+		}
+		if markup := tg.lastCall("editMessageReplyMarkup").Get("reply_markup"); !strings.Contains(markup, fmt.Sprintf("%q", signupKeyboard(wantCount)[0][0].Text)) {
+			t.Fatalf("buttons edited to %q, want %d sign-ups shown", markup, wantCount)
+		}
+	}
+	press(1, true, "Ты записан", 1)
+	press(2, true, "Ты записан", 2)
+	// Pressing the same button twice changes nothing
+	press(2, true, "Ты записан", 2)
+	press(1, false, "без тебя", 1)
+	press(2, false, "без тебя", 0)
+	press(3, true, "Ты записан", 1)
+
+	// A press on another message, e.g. last week's, is refused
+	edits := tg.count("editMessageReplyMarkup")
+	pressSignup(ctx, db, api, 4, messageID+1, true)
+	if answer := tg.lastCall("answerCallbackQuery").Get("text"); !strings.Contains(answer, "уже закрыта") {
+		t.Fatalf("stale press answered %q", answer)
+	}
+	if n, _ := database.CountParticipants(ctx, db, testGroupID); n != 1 || tg.count("editMessageReplyMarkup") != edits {
+		t.Fatalf("stale press changed the sign-ups: %d participants", n)
+	}
+}
+
+func TestSignupFallsBackToButtons(t *testing.T) {
+	const forbidden = `{"ok":false,"error_code":400,"description":"Bad Request: POLLS_FORBIDDEN"}`
+	tests := []struct {
+		name        string
+		mode        string // the group's signup_mode setting, "" for auto
+		reply       string
+		wantButtons bool
+	}{
+		{"polls forbidden", "", forbidden, true},
+		{"other poll error", "", `{"ok":false,"error_code":502,"description":"Bad Gateway"}`, false},
+		{"poll mode forced", database.SignupPoll, forbidden, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, tg, api := setupManualPairsGroup(t)
+			ctx := context.Background()
+			withFakePolls(t, tg)
+			tg.reply("sendPoll", func(url.Values) string { return tt.reply })
+			if tt.mode != "" {
+				if err := database.SetGroupSetting(ctx, db, testGroupID, signupModeSetting, tt.mode); err != nil {
+					t.Fatalf("SetGroupSetting: %v", err)
+				}
+			}
+
+			SendQuiz(ctx, db, api, testGroupID)
+			if n := tg.count("sendPoll"); n != 1 {
+				t.Fatalf("sendPoll called %d times, want a poll tried first", n)
+			}
+			pm, err := database.GetPollMappingByGroupID(ctx, db, testGroupID)
+			if err != nil {
+				t.Fatalf("GetPollMappingByGroupID: %v", err)
+			}
+			if got := pm != nil && pm.Kind == database.SignupKeyboard; got != tt.wantButtons {
+				t.Fatalf("poll mapping = %+v, want buttons %v", pm, tt.wantButtons)
+			}
+			if !tt.wantButtons {
+				if sent := tg.sent(testGroupID); len(sent) != 0 {
+					t.Fatalf("group got %q, want no sign-up message", sent)
+				}
+				return
+			}
+
+			// The switch is remembered: next week goes straight to buttons
+			if mode := groupSignupMode(ctx, db, testGroupID); mode != database.SignupKeyboard {
+				t.Fatalf("signup mode = %q, want buttons remembered", mode)
+			}
+			SendQuiz(ctx, db, api, testGroupID)
+			if n := tg.count("sendPoll"); n != 1 {
+				t.Fatalf("sendPoll called %d times, want no poll after the switch", n)
+			}
+			if pm, _ := database.GetPollMappingByGroupID(ctx, db, testGroupID); pm == nil || pm.Kind != database.SignupKeyboard {
+				t.Fatalf("poll mapping = %+v, want buttons", pm)
+			}
+		})
+	}
+}
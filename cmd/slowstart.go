@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"time"
+
+	"example.com/random_coffee/database"
+	"github.com/NicoNex/echotron/v3"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// slowStartHoursSetting is the group setting with the hours between the quiz and the check; unset means off
+	slowStartHoursSetting = "slow_start_hours"
+
+	// slowStartMinVotesSetting is the group setting with the sign-ups below which the group is nudged
+	slowStartMinVotesSetting = "slow_start_min_votes"
+
+	// slowStartTick is how often due checks are looked for
+	slowStartTick = 5 * time.Minute
+
+	defaultSlowStartHours    = 24
+	defaultSlowStartMinVotes = 3
+
+	// Nudges due at night in the group's timezone wait for the morning
+	slowStartQuietFrom = 22
+	slowStartQuietTo   = 9
+
+	slowStartText = "☕️ Пока в Random Coffee на этой неделе записались немногие. " +
+		"Если хочешь встретиться с кем-нибудь из группы - отметься в опросе выше 👆"
+)
+
+// slowStartConfig returns the group's check delay and vote threshold; ok is false when the check is off
+func slowStartConfig(ctx context.Context, db *sql.DB, groupID int64) (hours, minVotes int, ok bool) {
+	read := func(key string, def int) (int, bool) {
+		value, found, err := database.GetGroupSetting(ctx, db, groupID, key)
+		if err != nil {
+			groupEvent(log.Warn(), EventSettingsReadFailed, groupID).Err(err).Str("key", key).Msg("GetGroupSetting failed")
+			return def, false
+		}
+		if !found {
+			return def, false
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			return def, false
+		}
+		return n, true
+	}
+
+	hours, ok = read(slowStartHoursSetting, defaultSlowStartHours)
+	minVotes, _ = read(slowStartMinVotesSetting, defaultSlowStartMinVotes)
+	return hours, minVotes, ok
+}
+
+// scheduleSlowStartCheck persists the check for a freshly sent poll, so it survives restarts.
+// No check is scheduled if it would fall after pair creation.
+func scheduleSlowStartCheck(ctx context.Context, db *sql.DB, groupID int64, pollID string, messageID int) {
+	hours, _, ok := slowStartConfig(ctx, db, groupID)
+	if !ok {
+		return
+	}
+
+	now := time.Now()
+	dueAt := now.Add(time.Duration(hours) * time.Hour)
+	if !dueAt.Before(nextPairsTime(ctx, db, groupID, now)) {
+		groupEvent(log.Info(), EventSlowStartSkipped, groupID).Int("hours", hours).Msg("Slow start check would come after pair creation")
+		return
+	}
+
+	c := database.SlowStartCheck{PollID: pollID, GroupID: groupID, MessageID: int64(messageID), DueAt: dueAt}
+	if err := database.CreateSlowStartCheck(ctx, db, c); err != nil {
+		groupEvent(log.Error(), EventSlowStartFailed, groupID).Err(err).Str("poll_id", pollID).Msg("CreateSlowStartCheck failed")
+		return
+	}
+	groupEvent(log.Info(), EventSlowStartScheduled, groupID).Str("poll_id", pollID).Time("due_at", dueAt).Msg("Slow start check scheduled")
+}
+
+// cancelSlowStartIfReached drops the poll's check once enough people signed up, so it never fires
+func cancelSlowStartIfReached(ctx context.Context, db *sql.DB, groupID int64, pollID string) {
+	_, minVotes, ok := slowStartConfig(ctx, db, groupID)
+	if !ok {
+		return
+	}
+	count, err := database.CountParticipants(ctx, db, groupID)
+	if err != nil || count < minVotes {
+		return
+	}
+	if err := database.DeleteSlowStartCheck(ctx, db, pollID); err != nil {
+		groupEvent(log.Warn(), EventSlowStartFailed, groupID).Err(err).Str("poll_id", pollID).Msg("DeleteSlowStartCheck failed")
+	}
+}
+
+// isQuietHour reports whether t falls at night in the group's timezone
+func isQuietHour(t time.Time, loc *time.Location) bool {
+	hour := t.In(loc).Hour()
+	return hour >= slowStartQuietFrom || hour < slowStartQuietTo
+}
+
+// runSlowStartChecks fires checks due at now: a group still below its threshold gets one nudge replying to the poll.
+// Each check is deleted once handled, so a cycle is nudged at most once.
+func runSlowStartChecks(ctx context.Context, db *sql.DB, api echotron.API, now time.Time) {
+	checks, err := database.GetDueSlowStartChecks(ctx, db, now)
+	if err != nil {
+		botEvent(log.Error(), EventSlowStartFailed).Err(err).Msg("GetDueSlowStartChecks failed")
+		return
+	}
+
+	for _, c := range checks {
+		if isQuietHour(now, loadGroupSchedule(ctx, db, c.GroupID).location) {
+			continue
+		}
+		fireSlowStartCheck(ctx, db, api, c)
+
+		if err := database.DeleteSlowStartCheck(ctx, db, c.PollID); err != nil {
+			groupEvent(log.Error(), EventSlowStartFailed, c.GroupID).Err(err).Str("poll_id", c.PollID).Msg("DeleteSlowStartCheck failed")
+		}
+	}
+}
+
+func fireSlowStartCheck(ctx context.Context, db *sql.DB, api echotron.API, c database.SlowStartCheck) {
+	// The poll may have been closed since, or the check turned off; the caller deletes the check either way
+	pm, err := database.GetPollMappingByGroupID(ctx, db, c.GroupID)
+	if err != nil || pm == nil || pm.PollID != c.PollID {
+		return
+	}
+	_, minVotes, ok := slowStartConfig(ctx, db, c.GroupID)
+	if !ok {
+		return
+	}
+
+	count, err := database.CountParticipants(ctx, db, c.GroupID)
+	if err != nil {
+		groupEvent(log.Error(), EventSlowStartFailed, c.GroupID).Err(err).Msg("CountParticipants failed")
+		return
+	}
+	if count >= minVotes {
+		return
+	}
+
+	opts := &echotron.MessageOptions{
+		ReplyParameters: echotron.ReplyParameters{MessageID: int(c.MessageID)},
+	}
+	if _, err := api.SendMessage(slowStartText, c.GroupID, opts); err != nil {
+		groupEvent(log.Warn(), EventSlowStartFailed, c.GroupID).Err(err).Msg("Failed to send slow start nudge")
+		return
+	}
+	groupEvent(log.Info(), EventSlowStartNudged, c.GroupID).Int("participants", count).Int("min_votes", minVotes).Msg("Slow start nudge sent")
+}
+
+// handleSlowStartCommand implements /slow_start off|<hours> [min_votes] in a group
+func handleSlowStartCommand(ctx context.Context, db *sql.DB, api echotron.API, groupID int64, args []string) {
+	usage := fmt.Sprintf("Использование: /slow_start off|<часы> [мин. голосов]\n"+
+		"Через указанное число часов после опроса бот напомнит о нем, если записалось меньше нужного (по умолчанию %d ч., %d голоса)",
+		defaultSlowStartHours, defaultSlowStartMinVotes)
+
+	if len(args) == 1 && args[0] == "off" {
+		if err := database.DeleteGroupSetting(ctx, db, groupID, slowStartHoursSetting); err != nil {
+			groupEvent(log.Error(), EventSettingsSaveFailed, groupID).Err(err).Str("key", slowStartHoursSetting).Msg("DeleteGroupSetting failed")
+			sendMessage(api, "❌ Не удалось сохранить настройку", groupID)
+			return
+		}
+		sendMessage(api, "✅ Напоминание при слабом старте выключено", groupID)
+		return
+	}
+
+	if len(args) == 0 || len(args) > 2 {
+		state := "выключено"
+		if hours, minVotes, ok := slowStartConfig(ctx, db, groupID); ok {
+			state = fmt.Sprintf("через %d ч., если меньше %d голосов", hours, minVotes)
+		}
+		sendMessage(api, fmt.Sprintf("Напоминание при слабом старте: %s\n%s", state, usage), groupID)
+		return
+	}
+
+	maxHours := int(loadGroupSchedule(ctx, db, groupID).quizToPairsGap().Hours())
+	hours, err := strconv.Atoi(args[0])
+	if err != nil || hours <= 0 || hours >= maxHours {
+		sendMessage(api, fmt.Sprintf("❌ Укажи число часов от 1 до %d: позже уже создаются пары\n\n%s", maxHours-1, usage), groupID)
+		return
+	}
+	settings := map[string]string{slowStartHoursSetting: strconv.Itoa(hours)}
+	if len(args) == 2 {
+		minVotes, err := strconv.Atoi(args[1])
+		if err != nil || minVotes <= 0 {
+			sendMessage(api, "❌ Минимум голосов должен быть положительным числом\n\n"+usage, groupID)
+			return
+		}
+		settings[slowStartMinVotesSetting] = strconv.Itoa(minVotes)
+	}
+
+	for key, value := range settings {
+		if err := database.SetGroupSetting(ctx, db, groupID, key, value); err != nil {
+			groupEvent(log.Error(), EventSettingsSaveFailed, groupID).Err(err).Str("key", key).Msg("SetGroupSetting failed")
+			sendMessage(api, "❌ Не удалось сохранить настройку", groupID)
+			return
+		}
+	}
+
+	_, minVotes, _ := slowStartConfig(ctx, db, groupID)
+	sendMessage(api, fmt.Sprintf("✅ Через %d ч. после опроса напомню о нем, если запишется меньше %d человек. "+
+		"Начнет работать со следующего опроса", hours, minVotes), groupID)
+}
+
+// newSlowStartTicker returns the checker's clock ticks and a function stopping them; tests replace it
+// with a fake clock
+var newSlowStartTicker = func() (<-chan time.Time, func()) {
+	ticker := time.NewTicker(slowStartTick)
+	return ticker.C, ticker.Stop
+}
+
+func startSlowStartChecker(db *sql.DB, api echotron.API, stopChan chan struct{}) {
+	scheduler.startWorker("slow_start", func() {
+		ticks, stop := newSlowStartTicker()
+		defer stop()
+
+		for {
+			select {
+			case now := <-ticks:
+				// Due checks stay stored, so they run on the first tick after maintenance and see the held sign-ups
+				if !maintenance.holding() {
+					runSlowStartChecks(context.Background(), db, api, now)
+				}
+			case <-stopChan:
+				return
+			}
+		}
+	})
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"example.com/random_coffee/database"
+	"github.com/NicoNex/echotron/v3"
+)
+
+// slowStartDue is when the test group's check falls due: Friday noon, Moscow time
+var slowStartDue = time.Date(2026, 5, 1, 9, 0, 0, 0, time.UTC)
+
+// setupSlowStart opens the test group's poll with a slow-start check due at slowStartDue and one sign-up,
+// below the default threshold of three
+func setupSlowStart(t *testing.T) (*sql.DB, *fakeTelegram, echotron.API) {
+	t.Helper()
+	db, tg, api := setupManualPairsGroup(t)
+	ctx := context.Background()
+	if err := database.SetGroupSetting(ctx, db, testGroupID, slowStartHoursSetting, "24"); err != nil {
+		t.Fatalf("SetGroupSetting: %v", err)
+	}
+	if err := database.CreatePollMapping(ctx, db, database.PollMapping{PollID: "poll-1", GroupID: testGroupID, MessageID: 10, Kind: database.SignupPoll}); err != nil {
+		t.Fatalf("CreatePollMapping: %v", err)
+	}
+	c := database.SlowStartCheck{PollID: "poll-1", GroupID: testGroupID, MessageID: 10, DueAt: slowStartDue}
+	if err := database.CreateSlowStartCheck(ctx, db, c); err != nil {
+		t.Fatalf("CreateSlowStartCheck: %v", err)
+	}
+	vote(ctx, db, 1, true)
+	return db, tg, api
+}
+
+// nudges returns how many slow-start nudges the group got
+func nudges(tg *fakeTelegram) int {
+	n := 0
+	for _, text := range tg.sent(testGroupID) {
+		if text == slowStartText {
+			n++
+		}
+	}
+	return n
+}
+
+func TestSlowStartNudgeFires(t *testing.T) {
+	db, tg, api := setupSlowStart(t)
+	tick := withFakeTicker(t, &newSlowStartTicker)
+	startSlowStartChecker(db, api, withTestScheduler(t))
+
+	tick(slowStartDue.Add(-time.Minute))
+	if n := nudges(tg); n != 0 {
+		t.Fatalf("%d nudges before the check is due", n)
+	}
+
+	// Due, but at 23:00 in the group: the nudge waits for the morning
+	tick(slowStartDue.Add(11 * time.Hour))
+	if n := nudges(tg); n != 0 {
+		t.Fatalf("%d nudges at night", n)
+	}
+
+	tick(slowStartDue.Add(21 * time.Hour))
+	calls := tg.chatCalls(testGroupID)
+	last := calls[len(calls)-1]
+	if nudges(tg) != 1 || last.params.Get("text") != slowStartText || last.params.Get("reply_parameters") == "" {
+		t.Fatalf("group got %d nudges, the last call %+v; want one reply to the poll", nudges(tg), last)
+	}
+
+	// Once nudged, the cycle is never nudged again
+	for hours := 22; hours < 30; hours++ {
+		tick(slowStartDue.Add(time.Duration(hours) * time.Hour))
+	}
+	if n := nudges(tg); n != 1 {
+		t.Fatalf("group nudged %d times", n)
+	}
+}
+
+func TestSlowStartNudgeCancelledEarly(t *testing.T) {
+	tests := []struct {
+		name   string
+		cancel func(ctx context.Context, db *sql.DB, api echotron.API)
+	}{
+		{"enough sign-ups", func(ctx context.Context, db *sql.DB, api echotron.API) {
+			vote(ctx, db, 2, true)
+			vote(ctx, db, 3, true)
+		}},
+		{"pairs created", func(ctx context.Context, db *sql.DB, api echotron.API) {
+			vote(ctx, db, 2, true)
+			CreatePairs(ctx, db, api, testGroupID)
+		}},
+		{"turned off", func(ctx context.Context, db *sql.DB, api echotron.API) {
+			handleSlowStartCommand(ctx, db, api, testGroupID, []string{"off"})
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, tg, api := setupSlowStart(t)
+			ctx := context.Background()
+			tick := withFakeTicker(t, &newSlowStartTicker)
+			startSlowStartChecker(db, api, withTestScheduler(t))
+
+			tt.cancel(ctx, db, api)
+			tick(slowStartDue.Add(time.Hour))
+			if n := nudges(tg); n != 0 {
+				t.Fatalf("group nudged %d times", n)
+			}
+			if checks, err := database.GetDueSlowStartChecks(ctx, db, slowStartDue.Add(time.Hour)); err != nil || len(checks) != 0 {
+				t.Fatalf("GetDueSlowStartChecks = %+v, %v; want the check gone", checks, err)
+			}
+		})
+	}
+}
+
+func TestSlowStartCheckSurvivesRestart(t *testing.T) {
+	db, tg, api := setupSlowStart(t)
+	tick := withFakeTicker(t, &newSlowStartTicker)
+	stop := withTestScheduler(t)
+
+	// The bot runs until shortly before the check is due, then stops
+	before := make(chan struct{})
+	startSlowStartChecker(db, api, before)
+	tick(slowStartDue.Add(-time.Hour))
+	close(before)
+	waitFor(t, "the checker to stop", func() bool { _, ok := workerHealth("slow_start"); return !ok })
+
+	// The check is read back from the database by the next process
+	startSlowStartChecker(db, api, stop)
+	tick(slowStartDue.Add(time.Hour))
+	if n := nudges(tg); n != 1 {
+		t.Fatalf("group nudged %d times after the restart, want once", n)
+	}
+}
@@ -0,0 +1,362 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"example.com/random_coffee/database"
+	"github.com/NicoNex/echotron/v3"
+	"github.com/rs/zerolog/log"
+)
+
+// snapshotSchemaVersion is bumped whenever the snapshot format changes incompatibly
+const snapshotSchemaVersion = 1
+
+// PairingSnapshot is the outcome of one pairing run, exported for external analytics
+type PairingSnapshot struct {
+	SchemaVersion int                   `json:"schema_version"`
+	ID            string                `json:"id"`
+	GroupID       int64                 `json:"group_id"`
+	WeekStart     string                `json:"week_start"`
+	CreatedAt     time.Time             `json:"created_at"`
+	Anonymized    bool                  `json:"anonymized"`
+	Participants  []SnapshotParticipant `json:"participants"`
+	Pairs         []SnapshotPair        `json:"pairs"`
+	Unpaired      []string              `json:"unpaired"`
+	Funnel        *SnapshotFunnel       `json:"funnel,omitempty"`
+	Theme         string                `json:"theme,omitempty"` // the cycle theme set with /set_cycle_theme
+}
+
+// SnapshotFunnel counts the cycle's stages; members is zero when the chat size is unknown
+type SnapshotFunnel struct {
+	Members  int `json:"members"`
+	SignedUp int `json:"signed_up"`
+	Matched  int `json:"matched"`
+	Pairs    int `json:"pairs"`
+}
+
+// SnapshotParticipant identifies a participant; names are omitted when the snapshot is anonymized
+type SnapshotParticipant struct {
+	ID         string    `json:"id"`
+	Username   string    `json:"username,omitempty"`
+	FullName   string    `json:"full_name,omitempty"`
+	SignedUpAt time.Time `json:"signed_up_at"`
+}
+
+// SnapshotPair lists participant IDs matched together, three for a trio
+type SnapshotPair struct {
+	Members []string `json:"members"`
+}
+
+// snapshotConfig is read from the environment; an empty Dir and URL disable snapshots
+type snapshotConfig struct {
+	Dir          string
+	URL          string
+	Secret       string // signs the POST body
+	Anonymize    bool
+	AnonymizeKey string // keys the user ID hashes; kept apart from Secret, which the endpoint knows
+}
+
+func loadSnapshotConfig() snapshotConfig {
+	anonymize, _ := strconv.ParseBool(os.Getenv("SNAPSHOT_ANONYMIZE"))
+	return snapshotConfig{
+		Dir:          os.Getenv("SNAPSHOT_DIR"),
+		URL:          os.Getenv("SNAPSHOT_URL"),
+		Secret:       os.Getenv("SNAPSHOT_SECRET"),
+		Anonymize:    anonymize,
+		AnonymizeKey: os.Getenv("ANONYMIZE_SECRET"),
+	}
+}
+
+func (c snapshotConfig) enabled() bool {
+	return c.Dir != "" || c.URL != ""
+}
+
+// validate refuses anonymization without a key of its own: an unkeyed hash of a Telegram ID is
+// reversed by hashing every ID, and with the signing key anyone who can verify a POST could do the same
+func (c snapshotConfig) validate() error {
+	if !c.Anonymize {
+		return nil
+	}
+	if c.AnonymizeKey == "" {
+		return errors.New("ANONYMIZE_SECRET is not set")
+	}
+	if c.AnonymizeKey == c.Secret {
+		return errors.New("ANONYMIZE_SECRET must differ from SNAPSHOT_SECRET")
+	}
+	return nil
+}
+
+// snapshotUserID renders a user ID, replacing it with a stable keyed hash when anonymizing
+func (c snapshotConfig) snapshotUserID(userID int64) string {
+	id := strconv.FormatInt(userID, 10)
+	if !c.Anonymize {
+		return id
+	}
+	mac := hmac.New(sha256.New, []byte(c.AnonymizeKey))
+	mac.Write([]byte(id))
+	return "anon-" + hex.EncodeToString(mac.Sum(nil))[:16]
+}
+
+// buildPairingSnapshot assembles the snapshot of a run from its pairs and all participants of the cycle
+func buildPairingSnapshot(cfg snapshotConfig, groupID int64, weekStart string, participants []database.Participant,
+	finalPairs [][]database.Participant, funnel *database.CycleFunnel) *PairingSnapshot {
+
+	now := time.Now()
+	snap := &PairingSnapshot{
+		SchemaVersion: snapshotSchemaVersion,
+		ID:            fmt.Sprintf("%d_%s_%d", groupID, weekStart, now.Unix()),
+		GroupID:       groupID,
+		WeekStart:     weekStart,
+		CreatedAt:     now,
+		Anonymized:    cfg.Anonymize,
+		Participants:  make([]SnapshotParticipant, 0, len(participants)),
+		Pairs:         make([]SnapshotPair, 0, len(finalPairs)),
+		Unpaired:      make([]string, 0),
+	}
+	if funnel != nil {
+		snap.Funnel = &SnapshotFunnel{Members: funnel.Members, SignedUp: funnel.SignedUp, Matched: funnel.Matched, Pairs: funnel.Pairs}
+	}
+
+	paired := make(map[int64]bool)
+	for _, pair := range finalPairs {
+		members := make([]string, 0, len(pair))
+		for _, p := range pair {
+			members = append(members, cfg.snapshotUserID(p.UserID))
+			paired[p.UserID] = true
+		}
+		snap.Pairs = append(snap.Pairs, SnapshotPair{Members: members})
+	}
+
+	for _, p := range participants {
+		sp := SnapshotParticipant{ID: cfg.snapshotUserID(p.UserID), SignedUpAt: p.CreatedAt}
+		if !cfg.Anonymize {
+			sp.Username = p.Username
+			sp.FullName = p.FullName
+		}
+		snap.Participants = append(snap.Participants, sp)
+
+		if !paired[p.UserID] {
+			snap.Unpaired = append(snap.Unpaired, sp.ID)
+		}
+	}
+
+	return snap
+}
+
+// snapshotQueueSize bounds the snapshots waiting to be posted; more are dropped and can be resent from SNAPSHOT_DIR
+const snapshotQueueSize = 64
+
+// queuedSnapshot is a snapshot waiting for the background poster
+type queuedSnapshot struct {
+	id        string
+	groupID   int64
+	weekStart string
+	data      []byte
+}
+
+// snapshotQueue feeds startSnapshotPoster, so the endpoint's retries never hold up a pairing run
+var snapshotQueue = make(chan queuedSnapshot, snapshotQueueSize)
+
+// publishPairingSnapshot writes the run snapshot to the configured directory and queues it for the
+// endpoint. participants are everyone signed up for the cycle, as the run read them before the
+// sign-ups were cleared. Failures are logged and never affect the pairing run itself.
+func publishPairingSnapshot(groupID int64, participants []database.Participant, finalPairs [][]database.Participant, funnel *database.CycleFunnel, theme string) {
+	cfg := loadSnapshotConfig()
+	if !cfg.enabled() {
+		return
+	}
+
+	weekStart := getWeekStart(time.Now())
+	if err := cfg.validate(); err != nil {
+		cycleEvent(log.Error(), EventSnapshotFailed, groupID, weekStart).Err(err).Msg("Snapshot anonymization is misconfigured, snapshot skipped")
+		return
+	}
+
+	snap := buildPairingSnapshot(cfg, groupID, weekStart, participants, finalPairs, funnel)
+	snap.Theme = theme
+	data, err := json.MarshalIndent(snap, "", "  ")
+	if err != nil {
+		cycleEvent(log.Error(), EventSnapshotFailed, groupID, weekStart).Err(err).Msg("json.Marshal failed")
+		return
+	}
+
+	if cfg.Dir != "" {
+		if err := os.MkdirAll(cfg.Dir, 0o755); err != nil {
+			cycleEvent(log.Error(), EventSnapshotFailed, groupID, weekStart).Err(err).Msg("Failed to create snapshot directory")
+		} else if err := os.WriteFile(filepath.Join(cfg.Dir, snap.ID+".json"), data, 0o644); err != nil {
+			cycleEvent(log.Error(), EventSnapshotFailed, groupID, weekStart).Err(err).Msg("Failed to write snapshot")
+		}
+	}
+
+	if cfg.URL == "" {
+		cycleEvent(log.Info(), EventSnapshotPublished, groupID, weekStart).Str("snapshot_id", snap.ID).Msg("Pairing snapshot published")
+		return
+	}
+	select {
+	case snapshotQueue <- queuedSnapshot{id: snap.ID, groupID: groupID, weekStart: weekStart, data: data}:
+	default:
+		cycleEvent(log.Error(), EventSnapshotFailed, groupID, weekStart).Str("snapshot_id", snap.ID).Msg("Snapshot queue full, snapshot not posted")
+	}
+}
+
+// startSnapshotPoster posts queued snapshots to SNAPSHOT_URL one at a time, retrying each
+func startSnapshotPoster(stopChan chan struct{}) {
+	scheduler.startWorker("snapshot_poster", func() {
+		for {
+			select {
+			case s := <-snapshotQueue:
+				postQueuedSnapshot(s)
+			case <-stopChan:
+				return
+			}
+		}
+	})
+}
+
+func postQueuedSnapshot(s queuedSnapshot) {
+	if err := postSnapshot(loadSnapshotConfig(), s.data); err != nil {
+		cycleEvent(log.Error(), EventSnapshotFailed, s.groupID, s.weekStart).Err(err).Str("snapshot_id", s.id).Msg("Failed to post snapshot")
+		return
+	}
+	cycleEvent(log.Info(), EventSnapshotPublished, s.groupID, s.weekStart).Str("snapshot_id", s.id).Msg("Pairing snapshot published")
+}
+
+// snapshotRetryDelay is the first pause between attempts to post a snapshot; it doubles after every failure
+var snapshotRetryDelay = 2 * time.Second
+
+// postSnapshot sends a snapshot to the analytics endpoint, signed with HMAC-SHA256 of the body
+func postSnapshot(cfg snapshotConfig, data []byte) error {
+	client := &http.Client{Timeout: 30 * time.Second}
+
+	return withRetry(3, snapshotRetryDelay, func() error {
+		req, err := http.NewRequest(http.MethodPost, cfg.URL, bytes.NewReader(data))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if cfg.Secret != "" {
+			mac := hmac.New(sha256.New, []byte(cfg.Secret))
+			mac.Write(data)
+			req.Header.Set("X-Signature-256", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode >= 300 {
+			return fmt.Errorf("snapshot endpoint returned %s", resp.Status)
+		}
+		return nil
+	})
+}
+
+// listSnapshots returns stored snapshot IDs, newest first
+func listSnapshots(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	type snapshotFile struct {
+		id      string
+		modTime time.Time
+	}
+	files := make([]snapshotFile, 0, len(entries))
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".json") {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, snapshotFile{id: strings.TrimSuffix(e.Name(), ".json"), modTime: info.ModTime()})
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].modTime.After(files[j].modTime) })
+
+	ids := make([]string, 0, len(files))
+	for _, f := range files {
+		ids = append(ids, f.id)
+	}
+	return ids, nil
+}
+
+// handleSnapshotsCommand implements /snapshots list and /snapshots resend <id>
+func handleSnapshotsCommand(api echotron.API, message *echotron.Message, args []string) {
+	chatID := message.Chat.ID
+	if !isAdmin(message.From.ID) {
+		sendMessage(api, "❌ Доступ запрещен", chatID)
+		return
+	}
+
+	cfg := loadSnapshotConfig()
+	if cfg.Dir == "" {
+		sendMessage(api, "Снапшоты не сохраняются: SNAPSHOT_DIR не задан", chatID)
+		return
+	}
+
+	if len(args) == 0 {
+		sendMessage(api, "Использование: /snapshots list | /snapshots resend <id>", chatID)
+		return
+	}
+
+	switch args[0] {
+	case "list":
+		ids, err := listSnapshots(cfg.Dir)
+		if err != nil {
+			botEvent(log.Error(), EventSnapshotFailed).Err(err).Msg("listSnapshots failed")
+			sendMessage(api, "❌ Не удалось прочитать каталог снапшотов", chatID)
+			return
+		}
+		if len(ids) == 0 {
+			sendMessage(api, "Снапшотов пока нет", chatID)
+			return
+		}
+		if len(ids) > 20 {
+			ids = ids[:20]
+		}
+		sendMessage(api, "Последние снапшоты:\n"+strings.Join(ids, "\n"), chatID)
+
+	case "resend":
+		if len(args) < 2 || filepath.Base(args[1]) != args[1] {
+			sendMessage(api, "Использование: /snapshots resend <id>", chatID)
+			return
+		}
+		if cfg.URL == "" {
+			sendMessage(api, "SNAPSHOT_URL не задан, отправлять некуда", chatID)
+			return
+		}
+
+		data, err := os.ReadFile(filepath.Join(cfg.Dir, args[1]+".json"))
+		if err != nil {
+			sendMessage(api, fmt.Sprintf("❌ Снапшот %s не найден", args[1]), chatID)
+			return
+		}
+		if err := postSnapshot(cfg, data); err != nil {
+			botEvent(log.Error(), EventSnapshotFailed).Err(err).Str("snapshot_id", args[1]).Msg("Failed to resend snapshot")
+			sendMessage(api, "❌ Не удалось отправить снапшот", chatID)
+			return
+		}
+		botEvent(log.Info(), EventSnapshotPublished).Str("snapshot_id", args[1]).Msg("Snapshot resent")
+		sendMessage(api, "✅ Снапшот отправлен повторно", chatID)
+
+	default:
+		sendMessage(api, "Использование: /snapshots list | /snapshots resend <id>", chatID)
+	}
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"example.com/random_coffee/database"
+	"github.com/google/uuid"
+)
+
+// validateSchema checks a decoded JSON value against the subset of JSON Schema snapshotSchema uses,
+// returning every violation with its path
+func validateSchema(schema map[string]any, v any, path string) []string {
+	var problems []string
+	if want, ok := schema["const"]; ok && fmt.Sprint(want) != fmt.Sprint(v) {
+		problems = append(problems, fmt.Sprintf("%s: %v, want %v", path, v, want))
+	}
+
+	switch schema["type"] {
+	case "object":
+		obj, ok := v.(map[string]any)
+		if !ok {
+			return append(problems, path+": not an object")
+		}
+		properties, _ := schema["properties"].(map[string]any)
+		required, _ := schema["required"].([]any)
+		for _, name := range required {
+			if _, ok := obj[name.(string)]; !ok {
+				problems = append(problems, fmt.Sprintf("%s.%s: missing", path, name))
+			}
+		}
+		for name, value := range obj {
+			sub, ok := properties[name].(map[string]any)
+			if !ok {
+				if schema["additionalProperties"] == false {
+					problems = append(problems, fmt.Sprintf("%s.%s: not in the schema", path, name))
+				}
+				continue
+			}
+			problems = append(problems, validateSchema(sub, value, path+"."+name)...)
+		}
+	case "array":
+		items, ok := v.([]any)
+		if !ok {
+			return append(problems, path+": not an array")
+		}
+		for i, item := range items {
+			problems = append(problems, validateSchema(schema["items"].(map[string]any), item, fmt.Sprintf("%s[%d]", path, i))...)
+		}
+	case "string":
+		s, ok := v.(string)
+		if !ok {
+			return append(problems, path+": not a string")
+		}
+		if schema["format"] == "date-time" {
+			if _, err := time.Parse(time.RFC3339Nano, s); err != nil {
+				problems = append(problems, fmt.Sprintf("%s: %q is not a date-time", path, s))
+			}
+		}
+	case "integer":
+		if n, ok := v.(float64); !ok || n != float64(int64(n)) {
+			problems = append(problems, path+": not an integer")
+		}
+	case "number":
+		if _, ok := v.(float64); !ok {
+			problems = append(problems, path+": not a number")
+		}
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			problems = append(problems, path+": not a boolean")
+		}
+	}
+	return problems
+}
+
+// publishedSchema reads docs/snapshot.schema.json
+func publishedSchema(t *testing.T) map[string]any {
+	t.Helper()
+	data, err := os.ReadFile("../docs/snapshot.schema.json")
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	var schema map[string]any
+	if err := json.Unmarshal(data, &schema); err != nil {
+		t.Fatalf("schema is not JSON: %v", err)
+	}
+	return schema
+}
+
+func TestSnapshotSchemaIsCurrent(t *testing.T) {
+	var out bytes.Buffer
+	if err := runSnapshotSchemaCommand(&out); err != nil {
+		t.Fatalf("runSnapshotSchemaCommand: %v", err)
+	}
+	published, err := os.ReadFile("../docs/snapshot.schema.json")
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	if !bytes.Equal(out.Bytes(), published) {
+		t.Fatal("docs/snapshot.schema.json is out of date: regenerate it with `random_coffee snapshot-schema` " +
+			"and bump snapshotSchemaVersion if the change breaks readers")
+	}
+}
+
+func testSnapshot(cfg snapshotConfig) *PairingSnapshot {
+	signedUp := time.Date(2026, 1, 5, 10, 0, 0, 0, time.UTC)
+	participants := []database.Participant{
+		{UserID: 1, Username: "ann", FullName: "Ann", CreatedAt: signedUp},
+		{UserID: 2, Username: "bob", FullName: "Bob", CreatedAt: signedUp},
+		{UserID: 3, FullName: "Cid", CreatedAt: signedUp},
+	}
+	funnel := &database.CycleFunnel{Members: 10, SignedUp: 3, Matched: 2, Pairs: 1}
+	snap := buildPairingSnapshot(cfg, testGroupID, "2026-01-05", participants, [][]database.Participant{participants[:2]}, funnel)
+	snap.Theme = "books"
+	return snap
+}
+
+func TestSnapshotMatchesSchema(t *testing.T) {
+	schema := publishedSchema(t)
+	tests := []struct {
+		name string
+		snap *PairingSnapshot
+	}{
+		{"plain", testSnapshot(snapshotConfig{})},
+		{"anonymized", testSnapshot(snapshotConfig{Anonymize: true, AnonymizeKey: "anon"})},
+		{"no pairs", buildPairingSnapshot(snapshotConfig{}, testGroupID, "2026-01-05", nil, nil, nil)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := json.Marshal(tt.snap)
+			if err != nil {
+				t.Fatalf("json.Marshal: %v", err)
+			}
+			var v any
+			if err := json.Unmarshal(data, &v); err != nil {
+				t.Fatalf("json.Unmarshal: %v", err)
+			}
+			if problems := validateSchema(schema, v, "$"); len(problems) > 0 {
+				t.Fatalf("snapshot does not match the schema:\n%s", strings.Join(problems, "\n"))
+			}
+		})
+	}
+}
+
+func TestSnapshotSchemaCatchesDrift(t *testing.T) {
+	schema := publishedSchema(t)
+	data, _ := json.Marshal(testSnapshot(snapshotConfig{}))
+
+	mutations := map[string]func(map[string]any){
+		"renamed field":    func(m map[string]any) { m["week"] = m["week_start"]; delete(m, "week_start") },
+		"wrong type":       func(m map[string]any) { m["group_id"] = "-100" },
+		"other version":    func(m map[string]any) { m["schema_version"] = snapshotSchemaVersion + 1 },
+		"nested extra":     func(m map[string]any) { m["pairs"].([]any)[0].(map[string]any)["score"] = 1 },
+		"bad created time": func(m map[string]any) { m["created_at"] = "yesterday" },
+	}
+	for name, mutate := range mutations {
+		t.Run(name, func(t *testing.T) {
+			var m map[string]any
+			_ = json.Unmarshal(data, &m)
+			mutate(m)
+			if problems := validateSchema(schema, m, "$"); len(problems) == 0 {
+				t.Fatal("the schema accepted a changed snapshot")
+			}
+		})
+	}
+}
+
+func TestSnapshotAnonymizationKey(t *testing.T) {
+	if err := (snapshotConfig{Anonymize: true, Secret: "sign"}).validate(); err == nil {
+		t.Fatal("validate accepted anonymization without ANONYMIZE_SECRET")
+	}
+	if err := (snapshotConfig{Anonymize: true, Secret: "same", AnonymizeKey: "same"}).validate(); err == nil {
+		t.Fatal("validate accepted the signing key as the anonymization key")
+	}
+	if err := (snapshotConfig{Secret: "sign"}).validate(); err != nil {
+		t.Fatalf("validate without anonymization = %v", err)
+	}
+
+	a := snapshotConfig{Anonymize: true, Secret: "sign", AnonymizeKey: "anon"}
+	b := snapshotConfig{Anonymize: true, Secret: "other", AnonymizeKey: "anon"}
+	c := snapshotConfig{Anonymize: true, Secret: "sign", AnonymizeKey: "anon2"}
+	if a.snapshotUserID(1) != b.snapshotUserID(1) {
+		t.Fatal("user hashes depend on the signing key")
+	}
+	if a.snapshotUserID(1) == c.snapshotUserID(1) {
+		t.Fatal("user hashes ignore the anonymization key")
+	}
+
+	snap := testSnapshot(a)
+	for _, p := range snap.Participants {
+		if p.Username != "" || p.FullName != "" || !strings.HasPrefix(p.ID, "anon-") {
+			t.Fatalf("participant %+v, want an anonymous one", p)
+		}
+	}
+}
+
+func TestPublishPairingSnapshotPostsInBackground(t *testing.T) {
+	participants := []database.Participant{{UserID: 1}, {UserID: 2}}
+
+	release := make(chan struct{})
+	var attempts atomic.Int32
+	received := make(chan *http.Request, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		if attempts.Add(1) == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		received <- r
+	}))
+	defer server.Close()
+
+	t.Setenv("SNAPSHOT_URL", server.URL)
+	t.Setenv("SNAPSHOT_SECRET", "sign")
+	saved := snapshotRetryDelay
+	snapshotRetryDelay = time.Millisecond
+	t.Cleanup(func() { snapshotRetryDelay = saved })
+
+	// The endpoint hangs until released, yet the run is not held up
+	done := make(chan struct{})
+	go func() {
+		publishPairingSnapshot(testGroupID, participants, [][]database.Participant{participants}, nil, "")
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("publishPairingSnapshot waited for the endpoint")
+	}
+
+	var queued queuedSnapshot
+	select {
+	case queued = <-snapshotQueue:
+	default:
+		t.Fatal("nothing queued for the endpoint")
+	}
+	close(release)
+	postQueuedSnapshot(queued)
+
+	r := <-received
+	body, _ := io.ReadAll(r.Body)
+	if !bytes.Equal(body, queued.data) || !strings.HasPrefix(r.Header.Get("X-Signature-256"), "sha256=") {
+		t.Fatalf("endpoint got %q with signature %q", body, r.Header.Get("X-Signature-256"))
+	}
+	if n := attempts.Load(); n != 2 {
+		t.Fatalf("endpoint called %d times, want a retry after the failure", n)
+	}
+}
+
+func TestPublishPairingSnapshotRefusesUnkeyedAnonymization(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("SNAPSHOT_DIR", dir)
+	t.Setenv("SNAPSHOT_ANONYMIZE", "true")
+
+	publishPairingSnapshot(testGroupID, nil, nil, nil, "")
+	if ids, _ := listSnapshots(dir); len(ids) != 0 {
+		t.Fatalf("wrote %v without an anonymization key", ids)
+	}
+
+	t.Setenv("ANONYMIZE_SECRET", "anon")
+	publishPairingSnapshot(testGroupID, nil, nil, nil, "")
+	if ids, _ := listSnapshots(dir); !slices.ContainsFunc(ids, func(id string) bool { return strings.HasPrefix(id, "-100_") }) {
+		t.Fatalf("snapshots = %v, want the group's snapshot", ids)
+	}
+}
+
+func TestCreatePairsSnapshotListsSignUps(t *testing.T) {
+	db, _, api := setupManualPairsGroup(t)
+	ctx := context.Background()
+	dir := t.TempDir()
+	t.Setenv("SNAPSHOT_DIR", dir)
+	t.Setenv("OVERLAP_POLICY", overlapSkip)
+
+	// User 5 already has a meeting in another group this week, so the run leaves them out
+	seedTestGroup(t, db, -200, "Tea")
+	other := database.Pair{ID: uuid.New(), GroupID: -200, WeekStart: getWeekStart(time.Now()), User1ID: 5, User2ID: 7, CreatedAt: time.Now()}
+	if err := database.CreatePairs(ctx, db, []database.Pair{other}); err != nil {
+		t.Fatalf("CreatePairs: %v", err)
+	}
+	signUp(t, db, testGroupID, 1, 2, 3, 4, 5)
+	CreatePairs(ctx, db, api, testGroupID)
+
+	if n, err := database.CountParticipants(ctx, db, testGroupID); err != nil || n != 0 {
+		t.Fatalf("CountParticipants = %d, %v; want the sign-up closed", n, err)
+	}
+	ids, err := listSnapshots(dir)
+	if err != nil || len(ids) != 1 {
+		t.Fatalf("snapshots = %v, %v; want one", ids, err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, ids[0]+".json"))
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	var snap PairingSnapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		t.Fatalf("snapshot: %v", err)
+	}
+
+	// The snapshot is taken from the run's sign-ups, not from the table the run cleared
+	if len(snap.Participants) != 5 || len(snap.Pairs) != 2 {
+		t.Fatalf("snapshot has %d participants and %d pairs, want 5 and 2:\n%s", len(snap.Participants), len(snap.Pairs), data)
+	}
+	if !reflect.DeepEqual(snap.Unpaired, []string{"5"}) {
+		t.Fatalf("unpaired = %v, want the skipped user", snap.Unpaired)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// The JSON Schema of PairingSnapshot is generated from the Go structs, so it can't drift from what
+// the bot writes. docs/snapshot.schema.json holds the published copy; regenerate it with
+//
+//	random_coffee snapshot-schema > docs/snapshot.schema.json
+
+// snapshotSchemaID names the schema document; it changes with snapshotSchemaVersion
+var snapshotSchemaID = fmt.Sprintf("urn:random-coffee:snapshot:v%d", snapshotSchemaVersion)
+
+// snapshotSchema returns the JSON Schema (draft 2020-12) of PairingSnapshot
+func snapshotSchema() map[string]any {
+	schema := schemaFor(reflect.TypeOf(PairingSnapshot{}))
+	schema["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	schema["$id"] = snapshotSchemaID
+	schema["title"] = "PairingSnapshot"
+	// Consumers can rely on the version matching the shape of the document
+	schema["properties"].(map[string]any)["schema_version"] = map[string]any{"const": snapshotSchemaVersion}
+	return schema
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// schemaFor describes a Go type as encoding/json renders it. Struct fields without omitempty are
+// required, unknown properties are rejected, and nil slices are not expected: the snapshot always
+// makes its slices.
+func schemaFor(t reflect.Type) map[string]any {
+	if t == timeType {
+		return map[string]any{"type": "string", "format": "date-time"}
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		return schemaFor(t.Elem())
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": schemaFor(t.Elem())}
+	case reflect.Struct:
+		properties := map[string]any{}
+		required := []string{}
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			name, opts, _ := strings.Cut(f.Tag.Get("json"), ",")
+			if !f.IsExported() || name == "-" {
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+			properties[name] = schemaFor(f.Type)
+			if !strings.Contains(opts, "omitempty") {
+				required = append(required, name)
+			}
+		}
+		return map[string]any{"type": "object", "properties": properties, "required": required, "additionalProperties": false}
+	default:
+		panic(fmt.Sprintf("snapshot schema: unsupported type %s", t))
+	}
+}
+
+// runSnapshotSchemaCommand prints the snapshot JSON Schema
+func runSnapshotSchemaCommand(out io.Writer) error {
+	data, err := json.MarshalIndent(snapshotSchema(), "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = out.Write(append(data, '\n'))
+	return err
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"example.com/random_coffee/database"
+	"github.com/NicoNex/echotron/v3"
+	"github.com/rs/zerolog/log"
+)
+
+// statsReconciliationWeeks is how many recent poll reconciliations /stats shows
+const statsReconciliationWeeks = 4
+
+// statsFunnelWeeks is how many recent cycles the funnel total in /stats covers
+const statsFunnelWeeks = 4
+
+// buildGroupStats describes one group's participation
+func buildGroupStats(ctx context.Context, db *sql.DB, groupID int64) (string, error) {
+	participants, err := database.CountParticipants(ctx, db, groupID)
+	if err != nil {
+		return "", fmt.Errorf("failed to count participants: %w", err)
+	}
+
+	stats, err := database.GetPairStats(ctx, db, groupID)
+	if err != nil {
+		return "", fmt.Errorf("failed to get pair stats: %w", err)
+	}
+
+	text := fmt.Sprintf("\n%s (%d):\n", groupTitle(ctx, db, groupID), groupID)
+	text += fmt.Sprintf("• Записались в текущем опросе: %d\n", participants)
+
+	// Only the number: who avoids whom is not known to admins
+	avoidances, err := database.CountAvoidances(ctx, db, groupID)
+	if err != nil {
+		return "", fmt.Errorf("failed to count avoidances: %w", err)
+	}
+	if avoidances > 0 {
+		text += fmt.Sprintf("• Личных исключений: %d\n", avoidances)
+	}
+	if stats.Weeks == 0 {
+		return text + "• Пары еще не создавались\n", nil
+	}
+	text += fmt.Sprintf("• Пар в последний раз (%s): %d\n", stats.LastWeekStart, stats.LastWeekPairs)
+	text += fmt.Sprintf("• Всего участников: %d\n", stats.DistinctUsers)
+	text += fmt.Sprintf("• Недель с парами: %d\n", stats.Weeks)
+
+	funnels, err := database.GetRecentCycleFunnels(ctx, db, groupID, statsFunnelWeeks)
+	if err != nil {
+		return "", fmt.Errorf("failed to get cycle funnels: %w", err)
+	}
+	if len(funnels) > 0 {
+		text += fmt.Sprintf("• Воронка (%s): %s\n", funnels[0].WeekStart, formatFunnel(funnels[0]))
+		text += formatFunnelTotal(funnels)
+	}
+
+	recent, err := database.GetRecentPollReconciliations(ctx, db, groupID, statsReconciliationWeeks)
+	if err != nil {
+		return "", fmt.Errorf("failed to get poll reconciliations: %w", err)
+	}
+	if len(recent) > 0 {
+		text += fmt.Sprintf("• Расхождение базы с опросом (новые первыми): %s\n", formatReconciliationTrend(recent))
+	}
+	return text, nil
+}
+
+// handleStatsCommand implements /stats in a private chat: participation of every configured group.
+// A group whose stats can't be read is skipped so the others are still reported.
+func handleStatsCommand(ctx context.Context, db *sql.DB, api echotron.API, message *echotron.Message) {
+	chatID := message.Chat.ID
+	if !isAdmin(message.From.ID) {
+		sendMessage(api, "❌ Доступ запрещен", chatID)
+		return
+	}
+
+	groupIDs := getConfiguredGroups(ctx, db)
+	if len(groupIDs) == 0 {
+		sendMessage(api, "Нет подключенных групп", chatID)
+		return
+	}
+
+	ctx, done := runningExports.start(ctx, message.From.ID)
+	defer done()
+	reader := readerDB(db)
+
+	text := "📊 Статистика:\n"
+	for _, gid := range groupIDs {
+		if ctx.Err() != nil {
+			sendMessage(api, "⏹ Сбор статистики прерван", chatID)
+			return
+		}
+		groupText, err := buildGroupStats(ctx, reader, gid)
+		if err != nil {
+			groupEvent(log.Error(), EventStatsQueryFailed, gid).Err(err).Msg("Failed to build group stats")
+			text += fmt.Sprintf("\nГруппа %d: ошибка загрузки\n", gid)
+			continue
+		}
+		text += groupText
+	}
+	sendLongMessage(api, text, chatID)
+}
+
+// lastWeeks keeps pairs of the most recent weeks; history must be sorted by week
+func lastWeeks(history []database.Pair, weeks int) []database.Pair {
+	seen := 0
+	for i := len(history) - 1; i >= 0; i-- {
+		if i == len(history)-1 || history[i].WeekStart != history[i+1].WeekStart {
+			seen++
+			if seen > weeks {
+				return history[i+1:]
+			}
+		}
+	}
+	return history
+}
+
+// csvFormulaPrefixes start a cell that spreadsheets would run as a formula
+const csvFormulaPrefixes = "=+-@\t\r"
+
+// csvSafe keeps a cell from being run as a formula when the file is opened in a spreadsheet: names
+// come from Telegram profiles, which anyone can set to e.g. "=HYPERLINK(...)"
+func csvSafe(cell string) string {
+	if cell != "" && strings.ContainsRune(csvFormulaPrefixes, rune(cell[0])) {
+		return "'" + cell
+	}
+	return cell
+}
+
+// buildHistoryCSV renders pairs as CSV, naming users by their latest known profile or by ID
+func buildHistoryCSV(pairs []database.Pair, profiles map[int64]database.UserProfile) ([]byte, error) {
+	name := func(id int64) string {
+		if id == 0 {
+			return ""
+		}
+		if u, ok := profiles[id]; ok {
+			if display := getProfileDisplayName(u); display != "" {
+				return display
+			}
+		}
+		return strconv.FormatInt(id, 10)
+	}
+
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	if err := w.Write([]string{"week_start", "user1", "user2", "user3"}); err != nil {
+		return nil, err
+	}
+	for _, p := range pairs {
+		row := []string{p.WeekStart, name(p.User1ID), name(p.User2ID), name(p.User3ID)}
+		for i := range row {
+			row[i] = csvSafe(row[i])
+		}
+		if err := w.Write(row); err != nil {
+			return nil, err
+		}
+	}
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
+
+// handleHistoryCommand implements /history <group_id> [weeks] in a private chat: sends pair history as a CSV file
+func handleHistoryCommand(ctx context.Context, db *sql.DB, api echotron.API, message *echotron.Message, args []string) {
+	chatID := message.Chat.ID
+	if !isAdmin(message.From.ID) {
+		sendMessage(api, "❌ Доступ запрещен", chatID)
+		return
+	}
+
+	usage := "Использование: /history <group_id> [недель]"
+	if len(args) == 0 || len(args) > 2 {
+		sendMessage(api, usage, chatID)
+		return
+	}
+
+	groupID, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		sendMessage(api, fmt.Sprintf("❌ Некорректный ID: %s\n\n%s", args[0], usage), chatID)
+		return
+	}
+
+	weeks := 0
+	if len(args) == 2 {
+		weeks, err = strconv.Atoi(args[1])
+		if err != nil || weeks <= 0 {
+			sendMessage(api, fmt.Sprintf("❌ Количество недель должно быть положительным числом\n\n%s", usage), chatID)
+			return
+		}
+	}
+
+	// A long export runs on the read-only pool and can be aborted with /cancel_export
+	ctx, done := runningExports.start(ctx, message.From.ID)
+	defer done()
+	reader := readerDB(db)
+
+	var history []database.Pair
+	err = database.ForEachPairChunk(ctx, reader, groupID, func(chunk []database.Pair) error {
+		history = append(history, chunk...)
+		return nil
+	})
+	if isExportAborted(err) {
+		userEvent(log.Warn(), EventHistoryAborted, groupID, message.From.ID).Err(err).Int("read", len(history)).Msg("Pair history export aborted")
+		sendMessage(api, "⏹ Выгрузка истории прервана", chatID)
+		return
+	}
+	if err != nil {
+		groupEvent(log.Error(), EventHistoryFailed, groupID).Err(err).Msg("ForEachPairChunk failed")
+		sendMessage(api, "❌ Ошибка при чтении истории пар", chatID)
+		return
+	}
+	if weeks > 0 {
+		history = lastWeeks(history, weeks)
+	}
+	if len(history) == 0 {
+		sendMessage(api, fmt.Sprintf("В группе %d нет истории пар", groupID), chatID)
+		return
+	}
+
+	userIDs := make([]int64, 0, len(history)*2)
+	for _, p := range history {
+		userIDs = append(userIDs, p.Members()...)
+	}
+	profiles, err := database.GetUserProfiles(ctx, reader, userIDs)
+	if err != nil {
+		// IDs are still a usable export
+		groupEvent(log.Warn(), EventHistoryFailed, groupID).Err(err).Msg("GetUserProfiles failed")
+	}
+
+	data, err := buildHistoryCSV(history, profiles)
+	if err != nil {
+		groupEvent(log.Error(), EventHistoryFailed, groupID).Err(err).Msg("Failed to build CSV")
+		sendMessage(api, "❌ Не удалось сформировать файл", chatID)
+		return
+	}
+
+	file := echotron.NewInputFileBytes(fmt.Sprintf("pairs_%d.csv", groupID), data)
+	if _, err := api.SendDocument(file, chatID, nil); err != nil {
+		groupEvent(log.Error(), EventHistoryFailed, groupID).Err(err).Msg("SendDocument failed")
+		sendMessage(api, "❌ Не удалось отправить файл", chatID)
+		return
+	}
+
+	userEvent(log.Info(), EventHistoryExported, groupID, message.From.ID).Int("pairs_count", len(history)).Msg("Pair history exported")
+}
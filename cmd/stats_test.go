@@ -0,0 +1,41 @@
+package main
+
+import (
+	"encoding/csv"
+	"reflect"
+	"strings"
+	"testing"
+
+	"example.com/random_coffee/database"
+)
+
+func TestBuildHistoryCSVEscapesFormulas(t *testing.T) {
+	profiles := map[int64]database.UserProfile{
+		1: {UserID: 1, FullName: `=HYPERLINK("http://evil","x")`},
+		2: {UserID: 2, FullName: "+79990001122"},
+		3: {UserID: 3, FullName: "-Bob"},
+		4: {UserID: 4, FullName: "@cmd"},
+		5: {UserID: 5, FullName: "Ann = Bob"},
+	}
+	pairs := []database.Pair{
+		{WeekStart: "2026-01-05", User1ID: 1, User2ID: 2},
+		{WeekStart: "2026-01-12", User1ID: 3, User2ID: 4, User3ID: 5},
+	}
+
+	data, err := buildHistoryCSV(pairs, profiles)
+	if err != nil {
+		t.Fatalf("buildHistoryCSV: %v", err)
+	}
+	rows, err := csv.NewReader(strings.NewReader(string(data))).ReadAll()
+	if err != nil {
+		t.Fatalf("the CSV does not parse: %v", err)
+	}
+	want := [][]string{
+		{"week_start", "user1", "user2", "user3"},
+		{"2026-01-05", `'=HYPERLINK("http://evil","x")`, "'+79990001122", ""},
+		{"2026-01-12", "'-Bob", "'@cmd", "Ann = Bob"},
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Fatalf("rows = %q\nwant %q", rows, want)
+	}
+}
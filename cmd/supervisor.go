@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// schedulerRestartDelay is how long a crashed group loop or background worker waits before it is started again
+var schedulerRestartDelay = time.Minute
+
+// loopHealth is the liveness of one group's scheduler loop, or of a background worker when name is set
+type loopHealth struct {
+	groupID     int64
+	name        string
+	startedAt   time.Time
+	restarts    int
+	lastPanic   string
+	lastPanicAt time.Time
+}
+
+// recoverJobPanic keeps a panicking job from taking its scheduler loop down: the loop goes on to the
+// job's next occurrence. Logged as an error so the job and panic value reach admins as an alert.
+func recoverJobPanic(job string, groupID int64) {
+	if r := recover(); r != nil {
+		groupEvent(log.Error(), EventJobPanic, groupID).Str("job", job).Interface("panic", r).
+			Msg("Scheduled job panicked, it runs again at its next occurrence")
+	}
+}
+
+// supervise runs the group's loop and starts it again after a panic, until the loop returns on its own
+func (s *groupScheduler) supervise(groupID int64, wake chan struct{}) {
+	health := &loopHealth{groupID: groupID}
+	s.mu.Lock()
+	s.health[groupID] = health
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		// A new loop for the group may have registered already
+		if s.health[groupID] == health {
+			delete(s.health, groupID)
+		}
+	}()
+
+	s.keepRunning(health, func() { s.run(groupID, wake) }, func(panicValue any, restarts int) {
+		groupEvent(log.Error(), EventSchedulerLoopPanic, groupID).Interface("panic", panicValue).Int("restarts", restarts).
+			Dur("restart_in", schedulerRestartDelay).Msg("Scheduler loop panicked, restarting")
+	})
+}
+
+// startWorker runs a background worker under the same supervision as the group loops: a panic is
+// reported and the worker started again, until it returns on its own, normally once stop is closed
+func (s *groupScheduler) startWorker(name string, worker func()) {
+	health := &loopHealth{name: name}
+	s.mu.Lock()
+	s.workers[name] = health
+	s.mu.Unlock()
+
+	go func() {
+		defer func() {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			if s.workers[name] == health {
+				delete(s.workers, name)
+			}
+		}()
+
+		s.keepRunning(health, worker, func(panicValue any, restarts int) {
+			botEvent(log.Error(), EventWorkerPanic).Str("worker", name).Interface("panic", panicValue).Int("restarts", restarts).
+				Dur("restart_in", schedulerRestartDelay).Msg("Background worker panicked, restarting")
+		})
+	}()
+}
+
+// keepRunning calls run until it returns without panicking or the scheduler stops, recording restarts in health
+func (s *groupScheduler) keepRunning(health *loopHealth, run func(), logPanic func(panicValue any, restarts int)) {
+	for {
+		s.mu.Lock()
+		health.startedAt = time.Now()
+		s.mu.Unlock()
+
+		panicValue, crashed := runRecovered(run)
+		if !crashed {
+			return
+		}
+
+		s.mu.Lock()
+		health.restarts++
+		health.lastPanic = fmt.Sprint(panicValue)
+		health.lastPanicAt = time.Now()
+		restarts := health.restarts
+		s.mu.Unlock()
+
+		logPanic(panicValue, restarts)
+
+		select {
+		case <-time.After(schedulerRestartDelay):
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+func runRecovered(run func()) (panicValue any, crashed bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			panicValue, crashed = r, true
+		}
+	}()
+	run()
+	return nil, false
+}
+
+// loopHealth returns the liveness of every running group loop, by group ID, then of the workers by name
+func (s *groupScheduler) loopHealth() []loopHealth {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	loops := make([]loopHealth, 0, len(s.health))
+	for _, h := range s.health {
+		loops = append(loops, *h)
+	}
+	sort.Slice(loops, func(i, j int) bool { return loops[i].groupID < loops[j].groupID })
+
+	workers := make([]loopHealth, 0, len(s.workers))
+	for _, h := range s.workers {
+		workers = append(workers, *h)
+	}
+	sort.Slice(workers, func(i, j int) bool { return workers[i].name < workers[j].name })
+	return append(loops, workers...)
+}
+
+// formatSchedulerHealth describes the group loops and background workers for /status; restarted
+// ones are listed individually
+func formatSchedulerHealth() string {
+	if scheduler == nil {
+		return "Планировщик не запущен\n"
+	}
+
+	loops, workers := 0, 0
+	var restarted string
+	for _, h := range scheduler.loopHealth() {
+		who := fmt.Sprintf("группа %d", h.groupID)
+		if h.name != "" {
+			workers++
+			who = "задача " + h.name
+		} else {
+			loops++
+		}
+		if h.restarts == 0 {
+			continue
+		}
+		restarted += fmt.Sprintf("• %s: перезапусков %d, последний сбой %s: %s\n",
+			who, h.restarts, h.lastPanicAt.Format("02.01 15:04"), h.lastPanic)
+	}
+	return fmt.Sprintf("Циклов расписания запущено: %d, фоновых задач: %d\n", loops, workers) + restarted
+}
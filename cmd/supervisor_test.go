@@ -0,0 +1,97 @@
+package main
+
+import (
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// withTestScheduler installs a scheduler with no group loops, stopped when the test ends
+func withTestScheduler(t *testing.T) chan struct{} {
+	t.Helper()
+	stop := make(chan struct{})
+	saved, savedDelay := scheduler, schedulerRestartDelay
+	scheduler = &groupScheduler{stop: stop, wakes: make(map[int64]chan struct{}), health: make(map[int64]*loopHealth),
+		workers: make(map[string]*loopHealth), skips: make(map[int64]holidaySkip)}
+	schedulerRestartDelay = time.Millisecond
+	t.Cleanup(func() {
+		close(stop)
+		scheduler, schedulerRestartDelay = saved, savedDelay
+	})
+	return stop
+}
+
+// waitFor polls cond until it holds or a second passes
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func workerHealth(name string) (loopHealth, bool) {
+	for _, h := range scheduler.loopHealth() {
+		if h.name == name {
+			return h, true
+		}
+	}
+	return loopHealth{}, false
+}
+
+func TestWorkerRestartsAfterPanic(t *testing.T) {
+	stop := withTestScheduler(t)
+
+	var runs atomic.Int32
+	scheduler.startWorker("flaky", func() {
+		if runs.Add(1) <= 2 {
+			panic("boom")
+		}
+		<-stop
+	})
+
+	waitFor(t, "the third run", func() bool { return runs.Load() == 3 })
+	h, ok := workerHealth("flaky")
+	if !ok || h.restarts != 2 || h.lastPanic != "boom" {
+		t.Fatalf("health = %+v, %v; want two restarts after \"boom\"", h, ok)
+	}
+	if got := formatSchedulerHealth(); !strings.Contains(got, "фоновых задач: 1") || !strings.Contains(got, "задача flaky: перезапусков 2") {
+		t.Fatalf("formatSchedulerHealth = %q", got)
+	}
+}
+
+func TestWorkerReturningIsNotRestarted(t *testing.T) {
+	withTestScheduler(t)
+
+	var runs atomic.Int32
+	scheduler.startWorker("once", func() { runs.Add(1) })
+
+	waitFor(t, "the worker to unregister", func() bool { _, ok := workerHealth("once"); return !ok })
+	time.Sleep(10 * time.Millisecond)
+	if n := runs.Load(); n != 1 {
+		t.Fatalf("worker ran %d times, want once", n)
+	}
+}
+
+func TestBackgroundWorkersAreSupervised(t *testing.T) {
+	stop := withTestScheduler(t)
+	db := openTestDB(t)
+	_, api := newFakeTelegram(t)
+
+	startCountdownUpdater(db, api, stop)
+	startSlowStartChecker(db, api, stop)
+	startParkedSignupRedelivery(db, stop)
+	startWeeklyReporter(db, api, stop)
+	startSnapshotPoster(stop)
+	startDashboardRefresher(stop)
+
+	for _, name := range []string{"countdown", "slow_start", "parked_signups", "weekly_report", "snapshot_poster", "dashboard"} {
+		if _, ok := workerHealth(name); !ok {
+			t.Errorf("worker %s is not supervised", name)
+		}
+	}
+}
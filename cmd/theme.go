@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"example.com/random_coffee/database"
+	"github.com/NicoNex/echotron/v3"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// cycleThemeSetting is the group setting with the theme of the current or next cycle; cleared once its pairs are out
+	cycleThemeSetting = "cycle_theme"
+
+	// themePlaceholder marks where a message template shows the cycle theme
+	themePlaceholder = "{{theme}}"
+
+	// maxThemeLength keeps the themed poll question well under Telegram's 300 characters
+	maxThemeLength = 100
+)
+
+// groupCycleTheme returns the group's cycle theme, empty when there is none
+func groupCycleTheme(ctx context.Context, db *sql.DB, groupID int64) string {
+	theme, _, err := database.GetGroupSetting(ctx, db, groupID, cycleThemeSetting)
+	if err != nil {
+		groupEvent(log.Warn(), EventSettingsReadFailed, groupID).Err(err).Str("key", cycleThemeSetting).Msg("GetGroupSetting failed")
+		return ""
+	}
+	return theme
+}
+
+// withTheme fills the template's theme placeholder with the theme in the given format, or removes the
+// placeholder when there is no theme. Other placeholders are substituted in the same pass, so text
+// inside the theme or a substitution is never expanded again.
+func withTheme(template, format, theme string, replacements ...string) string {
+	fragment := ""
+	if theme != "" {
+		fragment = fmt.Sprintf(format, theme)
+	}
+	return strings.NewReplacer(append([]string{themePlaceholder, fragment}, replacements...)...).Replace(template)
+}
+
+// validateCycleTheme checks a theme before it is stored; messages go out as plain text, so nothing needs escaping
+func validateCycleTheme(theme string) error {
+	switch {
+	case theme == "":
+		return fmt.Errorf("тема не может быть пустой")
+	case strings.ContainsAny(theme, "\r\n"):
+		return fmt.Errorf("тема должна быть в одну строку")
+	case utf8.RuneCountInString(theme) > maxThemeLength:
+		return fmt.Errorf("тема длиннее %d символов", maxThemeLength)
+	case strings.Contains(theme, themePlaceholder) || strings.Contains(theme, pairsPlaceholder):
+		return fmt.Errorf("тема не может содержать %s или %s", themePlaceholder, pairsPlaceholder)
+	}
+	return nil
+}
+
+// clearCycleTheme drops the theme once the cycle it was set for has its pairs. A theme an admin set
+// while the pairs were being announced is for the next cycle and stays.
+func clearCycleTheme(ctx context.Context, db *sql.DB, groupID int64, theme string) {
+	if theme == "" {
+		return
+	}
+	cleared, err := database.DeleteGroupSettingIfValue(ctx, db, groupID, cycleThemeSetting, theme)
+	if err != nil {
+		groupEvent(log.Error(), EventSettingsSaveFailed, groupID).Err(err).Str("key", cycleThemeSetting).Msg("Failed to clear cycle theme")
+		return
+	}
+	if !cleared {
+		groupEvent(log.Info(), EventThemeCleared, groupID).Bool("kept_new_theme", true).Msg("Cycle theme changed during the run, the new one is kept")
+		return
+	}
+	groupEvent(log.Info(), EventThemeCleared, groupID).Msg("Cycle theme cleared after pairs were created")
+}
+
+// handleSetCycleThemeCommand implements /set_cycle_theme <text> | off in a group. The theme goes into
+// every message of the cycle not sent yet and is cleared after the pairs announcement.
+func handleSetCycleThemeCommand(ctx context.Context, db *sql.DB, api echotron.API, message *echotron.Message) {
+	groupID := message.Chat.ID
+	usage := fmt.Sprintf("Использование: /set_cycle_theme <тема> | off\n"+
+		"Тема (до %d символов) попадет в опрос, анонс пар и личные сообщения цикла и сбросится после создания пар", maxThemeLength)
+
+	// The theme is the rest of the line as typed, spaces included
+	_, theme, _ := strings.Cut(strings.TrimSpace(message.Text), " ")
+	theme = strings.TrimSpace(theme)
+
+	if theme == "" {
+		text := usage
+		if current := groupCycleTheme(ctx, db, groupID); current != "" {
+			text = fmt.Sprintf("🎨 Тема цикла: %s\n\n%s", current, usage)
+		}
+		sendMessage(api, text, groupID)
+		return
+	}
+
+	if theme == "off" {
+		if err := database.DeleteGroupSetting(ctx, db, groupID, cycleThemeSetting); err != nil {
+			groupEvent(log.Error(), EventSettingsSaveFailed, groupID).Err(err).Str("key", cycleThemeSetting).Msg("Failed to clear cycle theme")
+			sendMessage(api, "❌ Не удалось сохранить настройку", groupID)
+			return
+		}
+		writeAudit(ctx, db, message.From.ID, "cycle_theme", groupID, "off")
+		sendMessage(api, "✅ Тема цикла убрана", groupID)
+		return
+	}
+
+	if err := validateCycleTheme(theme); err != nil {
+		sendMessage(api, "❌ "+err.Error()+"\n\n"+usage, groupID)
+		return
+	}
+	if err := database.SetGroupSetting(ctx, db, groupID, cycleThemeSetting, theme); err != nil {
+		groupEvent(log.Error(), EventSettingsSaveFailed, groupID).Err(err).Str("key", cycleThemeSetting).Msg("Failed to save cycle theme")
+		sendMessage(api, "❌ Не удалось сохранить настройку", groupID)
+		return
+	}
+
+	writeAudit(ctx, db, message.From.ID, "cycle_theme", groupID, theme)
+	groupEvent(log.Info(), EventThemeSet, groupID).Int64("user_id", message.From.ID).Msg("Cycle theme set")
+
+	// An open poll keeps its question: only what is not sent yet gets the theme
+	reply := "✅ Тема появится в следующем опросе, анонсе пар и личных сообщениях"
+	if pm, err := database.GetPollMappingByGroupID(ctx, db, groupID); err == nil && pm != nil {
+		reply = "✅ Опрос уже отправлен, поэтому тема появится в анонсе пар и личных сообщениях этого цикла"
+	}
+	sendMessage(api, reply, groupID)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"testing"
+
+	"example.com/random_coffee/database"
+	"github.com/NicoNex/echotron/v3"
+)
+
+const testTheme = "новогодний кофе: обсуди планы на год"
+
+// setCycleTheme sends /set_cycle_theme to the test group and returns the bot's reply
+func setCycleTheme(t *testing.T, db *sql.DB, tg *fakeTelegram, api echotron.API, theme string) string {
+	t.Helper()
+	before := len(tg.sent(testGroupID))
+	handleSetCycleThemeCommand(context.Background(), db, api, &echotron.Message{Text: "/set_cycle_theme " + theme,
+		Chat: echotron.Chat{ID: testGroupID}, From: &echotron.User{ID: testAdminID}})
+	sent := tg.sent(testGroupID)
+	if len(sent) != before+1 {
+		t.Fatalf("group got %q, want one reply", sent[before:])
+	}
+	return sent[before]
+}
+
+func TestCycleThemePropagatesAndClears(t *testing.T) {
+	db, tg, api := setupManualPairsGroup(t)
+	ctx := context.Background()
+	if err := database.SetGroupSetting(ctx, db, testGroupID, signupModeSetting, database.SignupKeyboard); err != nil {
+		t.Fatalf("SetGroupSetting: %v", err)
+	}
+
+	if reply := setCycleTheme(t, db, tg, api, testTheme); !strings.Contains(reply, "следующем опросе") {
+		t.Fatalf("reply = %q, want the theme announced for the next quiz", reply)
+	}
+	if q := signupPollQuestion(testTheme); !strings.HasSuffix(q, " Тема: "+testTheme) || strings.Contains(q, "\n") {
+		t.Fatalf("poll question = %q, want the theme at the end of one line", q)
+	}
+	if q := signupPollQuestion(""); strings.Contains(q, themePlaceholder) || strings.Contains(q, "Тема") {
+		t.Fatalf("poll question without a theme = %q", q)
+	}
+
+	SendQuiz(ctx, db, api, testGroupID)
+	sent := tg.sent(testGroupID)
+	if quiz := sent[len(sent)-1]; !strings.Contains(quiz, "🎨 Тема недели: "+testTheme) {
+		t.Fatalf("quiz = %q, want the theme", quiz)
+	}
+
+	// The quiz is out: the new theme only reaches what is still to be sent
+	const later = "кофе с пожеланиями"
+	if reply := setCycleTheme(t, db, tg, api, later); !strings.Contains(reply, "Опрос уже отправлен") {
+		t.Fatalf("reply = %q, want a note that the quiz keeps its question", reply)
+	}
+
+	signUp(t, db, testGroupID, 1, 2, 3, 4)
+	before := len(tg.sent(testGroupID))
+	CreatePairs(ctx, db, api, testGroupID)
+	announcement := strings.Join(tg.sent(testGroupID)[before:], "\n")
+	if !strings.Contains(announcement, "🎨 Тема недели: "+later) {
+		t.Fatalf("announcement = %q, want the current theme", announcement)
+	}
+	if theme := groupCycleTheme(ctx, db, testGroupID); theme != "" {
+		t.Fatalf("theme = %q after the pairs, want it cleared", theme)
+	}
+}
+
+func TestBuddyDMCarriesTheme(t *testing.T) {
+	db := openTestDB(t)
+	tg, api := newFakeTelegram(t)
+	seedTestGroup(t, db, testGroupID, "Coffee")
+
+	pair := []database.Participant{{UserID: 1, Username: "vol"}, {UserID: 2, Username: "new"}}
+	cohort := buddyCohort{firstTimers: map[int64]bool{2: true}, volunteers: map[int64]bool{1: true}}
+	notifyBuddies(context.Background(), db, api, testGroupID, [][]database.Participant{pair}, cohort, testTheme)
+
+	dm := tg.sent(1)
+	if len(dm) != 1 || !strings.Contains(dm[0], "@new") || !strings.Contains(dm[0], "🎨 Тема недели: "+testTheme) {
+		t.Fatalf("volunteer got %q, want the newcomer and the theme", dm)
+	}
+}
+
+func TestClearCycleThemeKeepsNewerTheme(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	seedTestGroup(t, db, testGroupID, "Coffee")
+
+	// An admin sets the next cycle's theme while this cycle's pairs are announced
+	if err := database.SetGroupSetting(ctx, db, testGroupID, cycleThemeSetting, "next"); err != nil {
+		t.Fatalf("SetGroupSetting: %v", err)
+	}
+	clearCycleTheme(ctx, db, testGroupID, testTheme)
+	if theme := groupCycleTheme(ctx, db, testGroupID); theme != "next" {
+		t.Fatalf("theme = %q, want the newer theme kept", theme)
+	}
+
+	clearCycleTheme(ctx, db, testGroupID, "next")
+	if theme := groupCycleTheme(ctx, db, testGroupID); theme != "" {
+		t.Fatalf("theme = %q, want it cleared", theme)
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/NicoNex/echotron/v3"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// exitCodeTokenRevoked lets the supervisor tell a revoked token apart from a crash
+const exitCodeTokenRevoked = 3
+
+// isUnauthorizedError reports whether Telegram rejected the bot token. Only the API's own answer
+// counts: a 401 or "401" elsewhere in an error text, e.g. a network error, says nothing about the token.
+func isUnauthorizedError(err error) bool {
+	var apiErr *echotron.APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	return apiErr.ErrorCode() == 401 || apiErr.Description() == "Unauthorized"
+}
+
+// isConflictError reports whether Telegram turned a getUpdates call away because another instance of the
+// bot is polling with the same token
+func isConflictError(err error) bool {
+	var apiErr *echotron.APIError
+	return errors.As(err, &apiErr) && apiErr.ErrorCode() == 409
+}
+
+// tokenWatch stops the bot once Telegram keeps rejecting its token, e.g. after it was regenerated
+// in BotFather. Telegram can't deliver the alert then, so it goes to the fallback webhook.
+type tokenWatch struct {
+	mu    sync.Mutex
+	api   echotron.API
+	sink  *WebhookSink // may be nil
+	limit int
+	exit  func(code int)
+
+	pollFailures int
+	sendFailures int
+	sendAlerted  bool
+}
+
+// tokenWatcher is nil until main sets it up; its methods are no-ops then
+var tokenWatcher *tokenWatch
+
+func newTokenWatch(api echotron.API, sink *WebhookSink, limit int) *tokenWatch {
+	return &tokenWatch{api: api, sink: sink, limit: limit, exit: os.Exit}
+}
+
+// pollFailed counts rejected polling attempts and stops the bot after limit of them in a row
+func (w *tokenWatch) pollFailed(err error) {
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if !isUnauthorizedError(err) {
+		w.pollFailures = 0
+		return
+	}
+	w.pollFailures++
+	if w.pollFailures >= w.limit {
+		w.revoked(err, "getUpdates")
+	}
+}
+
+// sendDone tracks sends: if they keep failing as unauthorized, getMe tells whether the token
+// is gone (the bot stops) or only sending is rejected (admins are warned once)
+func (w *tokenWatch) sendDone(err error) {
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if err == nil || !isUnauthorizedError(err) {
+		w.sendFailures = 0
+		w.sendAlerted = false
+		return
+	}
+	w.sendFailures++
+	if w.sendFailures < w.limit || w.sendAlerted {
+		return
+	}
+
+	if _, meErr := w.api.GetMe(); meErr != nil && isUnauthorizedError(meErr) {
+		w.revoked(err, "sendMessage")
+		return
+	}
+
+	w.sendAlerted = true
+	botEvent(log.Error(), EventTokenSendRejected).Err(err).Int("failures", w.sendFailures).
+		Msg("Telegram rejects sends as unauthorized although getMe works")
+	w.sendFallback(fmt.Sprintf("⚠️ Random Coffee: Telegram отклоняет отправку сообщений (%d раз подряд, 401), "+
+		"хотя токен бота еще действует. Бот продолжает работать, но сообщения не доходят.", w.sendFailures))
+}
+
+// revoked sends the last alert and exits with exitCodeTokenRevoked
+func (w *tokenWatch) revoked(err error, source string) {
+	botEvent(log.WithLevel(zerolog.FatalLevel), EventTokenRevoked).Err(err).Str("source", source).
+		Int("exit_code", exitCodeTokenRevoked).Msg("Telegram token rejected, it was probably revoked in BotFather; stopping")
+	w.sendFallback("🛑 Random Coffee остановлен: Telegram отклоняет токен бота (401). " +
+		"Вероятно, токен перевыпустили в BotFather - обновите TELEGRAM__TOKEN и перезапустите бота.")
+	w.exit(exitCodeTokenRevoked)
+}
+
+func (w *tokenWatch) sendFallback(text string) {
+	if w.sink == nil {
+		fmt.Fprintln(os.Stderr, "No ALERT_WEBHOOK_URL configured, token alert not delivered:", text)
+		return
+	}
+	if err := w.sink.Send(text); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to send token alert to fallback webhook: %v\n", err)
+	}
+}
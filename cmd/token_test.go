@@ -0,0 +1,96 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"testing"
+
+	"github.com/NicoNex/echotron/v3"
+)
+
+// unauthorized makes the fake API reject the method the way Telegram rejects a revoked token
+func unauthorized(tg *fakeTelegram, method string) {
+	tg.reply(method, func(url.Values) string {
+		return `{"ok":false,"error_code":401,"description":"Unauthorized"}`
+	})
+}
+
+func TestIsUnauthorizedError(t *testing.T) {
+	tg, api := newFakeTelegram(t)
+	unauthorized(tg, "getMe")
+	tg.reply("getChat", func(url.Values) string {
+		return `{"ok":false,"error_code":400,"description":"Bad Request: chat 401 not found"}`
+	})
+
+	_, rejected := api.GetMe()
+	_, badRequest := api.GetChat(401)
+	_, network := echotron.NewLocalAPI("http://127.0.0.1:1/", "401").GetMe()
+
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"token rejected", rejected, true},
+		{"wrapped", fmt.Errorf("send: %w", rejected), true},
+		{"401 in a description", badRequest, false},
+		{"network error", network, false},
+		{"plain text", errors.New("HTTP 401 Unauthorized"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.err == nil {
+				t.Fatal("the call did not fail")
+			}
+			if got := isUnauthorizedError(tt.err); got != tt.want {
+				t.Fatalf("isUnauthorizedError(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTokenWatchStopsAfterRejectedPolls(t *testing.T) {
+	tg, api := newFakeTelegram(t)
+	unauthorized(tg, "getUpdates")
+	_, rejected := api.GetUpdates(nil)
+	_, other := api.GetChat(401)
+
+	exitCode := -1
+	w := &tokenWatch{api: api, limit: 3, exit: func(code int) { exitCode = code }}
+	w.pollFailed(rejected)
+	w.pollFailed(rejected)
+	// Any other failure starts the count over
+	w.pollFailed(other)
+	w.pollFailed(rejected)
+	w.pollFailed(rejected)
+	if exitCode != -1 {
+		t.Fatalf("bot stopped with %d before %d rejections in a row", exitCode, w.limit)
+	}
+	w.pollFailed(rejected)
+	if exitCode != exitCodeTokenRevoked {
+		t.Fatalf("exit code = %d, want %d", exitCode, exitCodeTokenRevoked)
+	}
+}
+
+func TestTokenWatchChecksGetMeBeforeStopping(t *testing.T) {
+	tg, api := newFakeTelegram(t)
+	unauthorized(tg, "sendMessage")
+	_, rejected := api.SendMessage("hi", 1, nil)
+
+	exitCode := -1
+	w := &tokenWatch{api: api, limit: 2, exit: func(code int) { exitCode = code }}
+	w.sendDone(rejected)
+	w.sendDone(rejected)
+	if exitCode != -1 || !w.sendAlerted {
+		t.Fatalf("exit code = %d, alerted = %v; want a warning while getMe works", exitCode, w.sendAlerted)
+	}
+
+	unauthorized(tg, "getMe")
+	w.sendDone(nil)
+	w.sendDone(rejected)
+	w.sendDone(rejected)
+	if exitCode != exitCodeTokenRevoked {
+		t.Fatalf("exit code = %d, want the bot stopped once getMe is rejected too", exitCode)
+	}
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"example.com/random_coffee/database"
+	"github.com/NicoNex/echotron/v3"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+)
+
+// Callback data prefixes of the "unpin all" alert; the group ID follows the colon
+const (
+	unpinAllCallback        = "unpin_all"
+	unpinAllConfirmCallback = "unpin_all_confirm"
+	unpinAllCancelCallback  = "unpin_all_cancel"
+
+	// unpinAllConfirmTTL is how long the "unpin all" confirmation buttons work
+	unpinAllConfirmTTL = 30 * time.Minute
+)
+
+// confirmTokens are single-use tokens of confirmation buttons, so a press redelivered by Telegram or
+// pressed twice runs the action once. They live in memory only: after a restart the admin asks again.
+type confirmTokens struct {
+	mu      sync.Mutex
+	issued  map[string]int64 // token → group ID
+	created map[string]time.Time
+}
+
+var unpinAllConfirmations = &confirmTokens{issued: make(map[string]int64), created: make(map[string]time.Time)}
+
+// issue returns a new token for the group, forgetting the expired ones
+func (c *confirmTokens) issue(groupID int64, now time.Time) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for token, created := range c.created {
+		if now.Sub(created) > unpinAllConfirmTTL {
+			delete(c.issued, token)
+			delete(c.created, token)
+		}
+	}
+	token := uuid.NewString()[:8]
+	c.issued[token] = groupID
+	c.created[token] = now
+	return token
+}
+
+// restore makes a taken token usable again when the action failed and may be retried
+func (c *confirmTokens) restore(token string, groupID int64, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.issued[token] = groupID
+	c.created[token] = now
+}
+
+// take uses up the token and reports whether it was issued for the group and has not expired
+func (c *confirmTokens) take(token string, groupID int64, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	issuedFor, ok := c.issued[token]
+	created := c.created[token]
+	delete(c.issued, token)
+	delete(c.created, token)
+	return ok && issuedFor == groupID && now.Sub(created) <= unpinAllConfirmTTL
+}
+
+// isUnpinGoneError reports whether the message is no longer pinned or no longer exists,
+// so there is nothing left to unpin
+func isUnpinGoneError(err error) bool {
+	errStr := strings.ToLower(err.Error())
+	return strings.Contains(errStr, "message to unpin not found") ||
+		strings.Contains(errStr, "message not found")
+}
+
+// unpinPoll unpins a poll message; a failed unpin goes to the group's backlog for the next cycle
+func unpinPoll(ctx context.Context, db *sql.DB, api echotron.API, groupID, messageID int64) {
+	_, err := api.UnpinChatMessage(groupID, &echotron.UnpinMessageOptions{MessageID: int(messageID)})
+	if err == nil {
+		groupEvent(log.Info(), EventPairsUnpinned, groupID).Int64("message_id", messageID).Msg("Poll message unpinned")
+		return
+	}
+
+	groupEvent(log.Warn(), EventPairsUnpinFailed, groupID).Err(err).Int64("message_id", messageID).Msg("UnpinChatMessage failed (check bot permissions)")
+	countOps(counterUnpinFailed)
+	f := database.UnpinFailure{GroupID: groupID, MessageID: messageID, WeekStart: getWeekStart(time.Now()), FailedAt: time.Now()}
+	if err := database.AddUnpinFailure(ctx, db, f); err != nil {
+		groupEvent(log.Error(), EventUnpinBacklogFailed, groupID).Err(err).Int64("message_id", messageID).Msg("AddUnpinFailure failed")
+	}
+}
+
+// retryUnpinBacklog unpins the group's polls left pinned by earlier failures. It runs before a new
+// poll is pinned; messages that are unpinned or gone leave the backlog. If too many stay pinned,
+// the group owner is offered to unpin everything.
+func retryUnpinBacklog(ctx context.Context, db *sql.DB, api echotron.API, groupID int64) {
+	backlog, err := database.GetUnpinBacklog(ctx, db, groupID)
+	if err != nil {
+		groupEvent(log.Error(), EventUnpinBacklogFailed, groupID).Err(err).Msg("GetUnpinBacklog failed")
+		return
+	}
+	if len(backlog) == 0 {
+		return
+	}
+
+	remaining := 0
+	for _, f := range backlog {
+		_, err := api.UnpinChatMessage(groupID, &echotron.UnpinMessageOptions{MessageID: int(f.MessageID)})
+		if err != nil && !isUnpinGoneError(err) {
+			groupEvent(log.Warn(), EventPairsUnpinFailed, groupID).Err(err).Int64("message_id", f.MessageID).Msg("Retried unpin failed")
+			if err := database.AddUnpinFailure(ctx, db, f); err != nil {
+				groupEvent(log.Error(), EventUnpinBacklogFailed, groupID).Err(err).Int64("message_id", f.MessageID).Msg("AddUnpinFailure failed")
+			}
+			remaining++
+			continue
+		}
+
+		if err := database.DeleteUnpinFailure(ctx, db, groupID, f.MessageID); err != nil {
+			groupEvent(log.Error(), EventUnpinBacklogFailed, groupID).Err(err).Int64("message_id", f.MessageID).Msg("DeleteUnpinFailure failed")
+		}
+	}
+
+	groupEvent(log.Info(), EventUnpinBacklogRetried, groupID).Int("backlog", len(backlog)).Int("remaining", remaining).Msg("Unpin backlog retried")
+	if remaining > envInt("UNPIN_BACKLOG_ALERT", 3) {
+		alertUnpinBacklog(ctx, db, api, groupID, remaining)
+	}
+}
+
+// groupOwnerID returns the ID of the group's creator, or 0 if it can't be found
+func groupOwnerID(api echotron.API, groupID int64) int64 {
+	res, err := api.GetChatAdministrators(groupID)
+	if err != nil {
+		groupEvent(log.Warn(), EventUnpinBacklogFailed, groupID).Err(err).Msg("GetChatAdministrators failed")
+		return 0
+	}
+	for _, m := range res.Result {
+		if m != nil && m.Status == "creator" && m.User != nil {
+			return m.User.ID
+		}
+	}
+	return 0
+}
+
+// alertUnpinBacklog offers the group owner to unpin all messages. If the owner can't be messaged
+// (anonymous, or never started the bot) bot admins get the same offer.
+func alertUnpinBacklog(ctx context.Context, db *sql.DB, api echotron.API, groupID int64, count int) {
+	text := fmt.Sprintf("📌 В группе %s остались закрепленными %d старых опросов Random Coffee: боту не удалось их открепить. "+
+		"Проверь, что у бота есть право закреплять сообщения.", groupTitle(ctx, db, groupID), count)
+	opts := &echotron.MessageOptions{
+		ReplyMarkup: echotron.InlineKeyboardMarkup{InlineKeyboard: [][]echotron.InlineKeyboardButton{{
+			{Text: "Открепить все", CallbackData: fmt.Sprintf("%s:%d", unpinAllCallback, groupID)},
+		}}},
+	}
+
+	if ownerID := groupOwnerID(api, groupID); ownerID != 0 {
+		if _, err := api.SendMessage(text, ownerID, opts); err == nil {
+			groupEvent(log.Warn(), EventUnpinBacklogAlert, groupID).Int("count", count).Int64("owner_id", ownerID).Msg("Group owner alerted about stale pins")
+			return
+		}
+	}
+
+	for adminID := range adminChatIDsMap {
+		if _, err := api.SendMessage(text, adminID, opts); err != nil {
+			botEvent(log.Warn(), EventMessageBlocked).Err(err).Int64("chat_id", adminID).Msg("Failed to send unpin backlog alert")
+		}
+	}
+	groupEvent(log.Warn(), EventUnpinBacklogAlert, groupID).Int("count", count).Msg("Admins alerted about stale pins")
+}
+
+// canUnpinAll reports whether the user may unpin every message of the group: its owner or a bot admin
+func canUnpinAll(api echotron.API, groupID, userID int64) bool {
+	return isAdmin(userID) || groupOwnerID(api, groupID) == userID
+}
+
+// HandleCallbackQuery processes inline button presses
+func HandleCallbackQuery(ctx context.Context, db *sql.DB, api echotron.API, cq *echotron.CallbackQuery) {
+	action, arg, _ := strings.Cut(cq.Data, ":")
+	switch action {
+	case unpinAllCallback, unpinAllConfirmCallback, unpinAllCancelCallback:
+		handleUnpinAllCallback(ctx, db, api, cq, action, arg)
+	case groupsPageCallback:
+		handleGroupsPageCallback(ctx, db, api, cq, arg)
+	case signupYesCallback, signupNoCallback:
+		handleSignupCallback(ctx, db, api, cq, action == signupYesCallback)
+	case dashboardPinCallback, dashboardRefreshCallback:
+		handleDashboardCallback(ctx, db, api, cq, action)
+	case manualPairsConfirmCallback, manualPairsNoHistoryCallback, manualPairsCancelCallback:
+		handleManualPairsCallback(ctx, db, api, cq, action, arg)
+	case envGroupImportCallback, envGroupIgnoreCallback:
+		handleEnvGroupCallback(ctx, db, api, cq, action, arg)
+	default:
+		botEvent(log.Debug(), EventCallbackUnknown).Str("data", cq.Data).Msg("Unknown callback data")
+		answerCallback(api, cq, "")
+	}
+}
+
+func answerCallback(api echotron.API, cq *echotron.CallbackQuery, text string) {
+	if _, err := api.AnswerCallbackQuery(cq.ID, &echotron.CallbackQueryOptions{Text: text}); err != nil {
+		botEvent(log.Warn(), EventCallbackFailed).Err(err).Msg("AnswerCallbackQuery failed")
+	}
+}
+
+// editCallbackMessage replaces the text and buttons of the message the button was pressed on
+func editCallbackMessage(api echotron.API, cq *echotron.CallbackQuery, text string, keyboard [][]echotron.InlineKeyboardButton) {
+	if cq.Message == nil {
+		return
+	}
+	if keyboard == nil {
+		// An empty keyboard removes the buttons
+		keyboard = [][]echotron.InlineKeyboardButton{}
+	}
+	opts := &echotron.MessageTextOptions{ReplyMarkup: echotron.InlineKeyboardMarkup{InlineKeyboard: keyboard}}
+	if _, err := api.EditMessageText(text, echotron.NewMessageID(cq.Message.Chat.ID, cq.Message.ID), opts); err != nil {
+		botEvent(log.Warn(), EventCallbackFailed).Err(err).Msg("EditMessageText failed")
+	}
+}
+
+// handleUnpinAllCallback asks for confirmation, since unpinning all also removes pins made by people,
+// then unpins every message of the group and empties its backlog
+func handleUnpinAllCallback(ctx context.Context, db *sql.DB, api echotron.API, cq *echotron.CallbackQuery, action, arg string) {
+	// The confirmation buttons carry a single-use token after the group ID
+	groupArg, token, _ := strings.Cut(arg, ":")
+	groupID, err := strconv.ParseInt(groupArg, 10, 64)
+	if err != nil || cq.From == nil {
+		answerCallback(api, cq, "")
+		return
+	}
+	if !canUnpinAll(api, groupID, cq.From.ID) {
+		answerCallback(api, cq, "❌ Доступ запрещен")
+		return
+	}
+
+	switch action {
+	case unpinAllCallback:
+		token := unpinAllConfirmations.issue(groupID, time.Now())
+		answerCallback(api, cq, "")
+		editCallbackMessage(api, cq, fmt.Sprintf("Открепить все закрепленные сообщения в группе %s? "+
+			"Это уберет и закрепы, сделанные участниками, а не только опросы бота.", groupTitle(ctx, db, groupID)),
+			[][]echotron.InlineKeyboardButton{{
+				{Text: "Да, открепить все", CallbackData: fmt.Sprintf("%s:%d:%s", unpinAllConfirmCallback, groupID, token)},
+				{Text: "Отмена", CallbackData: fmt.Sprintf("%s:%d:%s", unpinAllCancelCallback, groupID, token)},
+			}})
+	case unpinAllCancelCallback:
+		unpinAllConfirmations.take(token, groupID, time.Now())
+		answerCallback(api, cq, "")
+		editCallbackMessage(api, cq, "Отменено. Старые опросы остались закрепленными.", nil)
+	case unpinAllConfirmCallback:
+		if !unpinAllConfirmations.take(token, groupID, time.Now()) {
+			answerCallback(api, cq, "")
+			editCallbackMessage(api, cq, "⌛ Подтверждение устарело или уже использовано. Нажми «Открепить все» в алерте заново.", nil)
+			return
+		}
+		if _, err := api.UnpinAllChatMessages(groupID); err != nil {
+			groupEvent(log.Error(), EventUnpinAllFailed, groupID).Err(err).Msg("UnpinAllChatMessages failed")
+			unpinAllConfirmations.restore(token, groupID, time.Now())
+			answerCallback(api, cq, "❌ Не удалось открепить, проверь права бота")
+			return
+		}
+		if err := database.ClearUnpinBacklog(ctx, db, groupID); err != nil {
+			groupEvent(log.Error(), EventUnpinBacklogFailed, groupID).Err(err).Msg("ClearUnpinBacklog failed")
+		}
+
+		writeAudit(ctx, db, cq.From.ID, "unpin_all", groupID, "")
+		groupEvent(log.Info(), EventUnpinAll, groupID).Int64("user_id", cq.From.ID).Msg("All messages unpinned")
+		answerCallback(api, cq, "✅ Готово")
+		editCallbackMessage(api, cq, fmt.Sprintf("✅ Все сообщения в группе %s откреплены", groupTitle(ctx, db, groupID)), nil)
+	}
+}
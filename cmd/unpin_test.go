@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"example.com/random_coffee/database"
+)
+
+const unpinRefused = `{"ok":false,"error_code":400,"description":"Bad Request: not enough rights to manage pinned messages in the chat"}`
+
+func TestUnpinBacklogRetriedUntilItSucceeds(t *testing.T) {
+	db, tg, api := setupManualPairsGroup(t)
+	ctx := context.Background()
+
+	// Unpinning fails twice, then the bot gets its rights back
+	var unpins atomic.Int32
+	tg.reply("unpinChatMessage", func(url.Values) string {
+		if unpins.Add(1) <= 2 {
+			return unpinRefused
+		}
+		return `{"ok":true,"result":true}`
+	})
+	backlog := func() []database.UnpinFailure {
+		t.Helper()
+		b, err := database.GetUnpinBacklog(ctx, db, testGroupID)
+		if err != nil {
+			t.Fatalf("GetUnpinBacklog: %v", err)
+		}
+		return b
+	}
+
+	unpinPoll(ctx, db, api, testGroupID, 10)
+	if b := backlog(); len(b) != 1 || b[0].MessageID != 10 || b[0].Attempts != 1 {
+		t.Fatalf("backlog after the failed unpin = %+v", b)
+	}
+
+	// The next cycle retries it and fails again, counting the attempt
+	retryUnpinBacklog(ctx, db, api, testGroupID)
+	if b := backlog(); len(b) != 1 || b[0].Attempts != 2 {
+		t.Fatalf("backlog after the failed retry = %+v", b)
+	}
+
+	// The one after succeeds and the message leaves the backlog
+	retryUnpinBacklog(ctx, db, api, testGroupID)
+	if b := backlog(); len(b) != 0 {
+		t.Fatalf("backlog after the successful retry = %+v", b)
+	}
+	if n := tg.count("unpinChatMessage"); n != 3 {
+		t.Fatalf("unpinChatMessage called %d times, want 3", n)
+	}
+
+	// Nothing left: no more calls
+	retryUnpinBacklog(ctx, db, api, testGroupID)
+	if n := tg.count("unpinChatMessage"); n != 3 {
+		t.Fatalf("unpinChatMessage called %d times for an empty backlog", n-3)
+	}
+}
+
+func TestUnpinBacklogDropsGoneMessagesAndAlerts(t *testing.T) {
+	db, tg, api := setupManualPairsGroup(t)
+	ctx := context.Background()
+	t.Setenv("UNPIN_BACKLOG_ALERT", "1")
+
+	for _, id := range []int64{10, 11, 12} {
+		f := database.UnpinFailure{GroupID: testGroupID, MessageID: id, WeekStart: "2026-04-27"}
+		if err := database.AddUnpinFailure(ctx, db, f); err != nil {
+			t.Fatalf("AddUnpinFailure: %v", err)
+		}
+	}
+	// Message 11 was deleted meanwhile, the other two still can't be unpinned
+	tg.reply("unpinChatMessage", func(params url.Values) string {
+		if params.Get("message_id") == "11" {
+			return `{"ok":false,"error_code":400,"description":"Bad Request: message to unpin not found"}`
+		}
+		return unpinRefused
+	})
+
+	retryUnpinBacklog(ctx, db, api, testGroupID)
+	b, err := database.GetUnpinBacklog(ctx, db, testGroupID)
+	if err != nil || len(b) != 2 || b[0].MessageID != 10 || b[1].MessageID != 12 {
+		t.Fatalf("GetUnpinBacklog = %+v, %v; want the deleted message dropped", b, err)
+	}
+
+	// Two stale pins are over the limit of one: without a reachable owner, bot admins are offered to unpin all
+	if sent := tg.sent(testAdminID); len(sent) != 1 || !strings.Contains(sent[0], "остались закрепленными 2") {
+		t.Fatalf("admin got %q, want the stale pins alert", sent)
+	}
+}
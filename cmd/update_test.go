@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"example.com/random_coffee/database"
+	"github.com/NicoNex/echotron/v3"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// captureLogs records debug and higher log entries for the rest of the test
+func captureLogs(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	saved, savedLevel := log.Logger, zerolog.GlobalLevel()
+	log.Logger = zerolog.New(&buf)
+	zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	t.Cleanup(func() {
+		log.Logger = saved
+		zerolog.SetGlobalLevel(savedLevel)
+	})
+	return &buf
+}
+
+// logEntries decodes the captured log lines
+func logEntries(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	t.Helper()
+	var entries []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var entry map[string]any
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("log line %q: %v", line, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func TestRedeliveredCommandRunsOnce(t *testing.T) {
+	db, tg, api := setupManualPairsGroup(t)
+	signUp(t, db, testGroupID, 1, 2, 3, 4)
+	bot := &Bot{API: api, DB: db, ChatID: testGroupID}
+
+	update := &echotron.Update{ID: 501, Message: &echotron.Message{
+		Text: "/create_pairs confirm",
+		Chat: echotron.Chat{ID: testGroupID, Type: "supergroup"},
+		From: &echotron.User{ID: testAdminID},
+	}}
+	bot.Update(update)
+	sent := tg.count("sendMessage")
+	if sent == 0 {
+		t.Fatal("the command did nothing")
+	}
+
+	bot.Update(update)
+	if got := tg.count("sendMessage"); got != sent {
+		t.Fatalf("redelivered command sent %d more messages", got-sent)
+	}
+	history, err := database.GetPairHistory(context.Background(), db, testGroupID)
+	if err != nil || len(history) != 2 {
+		t.Fatalf("GetPairHistory = %d pairs, %v; want one run's 2 pairs", len(history), err)
+	}
+}
+
+func TestRedeliveredPollAnswerIsQuiet(t *testing.T) {
+	db := openTestDB(t)
+	_, api := newFakeTelegram(t)
+	ctx := context.Background()
+	seedTestGroup(t, db, testGroupID, "Coffee")
+	if err := database.CreatePollMapping(ctx, db, database.PollMapping{PollID: "poll", GroupID: testGroupID, MessageID: 7}); err != nil {
+		t.Fatalf("CreatePollMapping: %v", err)
+	}
+	bot := &Bot{API: api, DB: db, ChatID: 1}
+	logs := captureLogs(t)
+
+	update := &echotron.Update{ID: 502, PollAnswer: &echotron.PollAnswer{PollID: "poll", User: &echotron.User{ID: 1, Username: "ann"}, OptionIDs: []int{pollYesOption}}}
+	bot.Update(update)
+	bot.Update(update)
+
+	duplicates := 0
+	for _, entry := range logEntries(t, logs) {
+		if level := entry["level"]; level != "debug" && level != "info" {
+			t.Errorf("%v log on a redelivered poll answer: %v", level, entry)
+		}
+		if entry["event"] == EventUpdateDuplicate {
+			duplicates++
+		}
+	}
+	if duplicates != 1 {
+		t.Fatalf("%d duplicates logged, want the second delivery dropped", duplicates)
+	}
+	participants, err := database.GetAllParticipants(ctx, db, testGroupID)
+	if err != nil || len(participants) != 1 {
+		t.Fatalf("participants = %d, %v; want the vote stored once", len(participants), err)
+	}
+}
+
+func TestUnpinAllConfirmationIsSingleUse(t *testing.T) {
+	db := openTestDB(t)
+	tg, api := newFakeTelegram(t)
+	withAdmins(t, testAdminID)
+	seedTestGroup(t, db, testGroupID, "Coffee")
+	ctx := context.Background()
+
+	press := func(data string) {
+		cq := &echotron.CallbackQuery{ID: "cq", Data: data, From: &echotron.User{ID: testAdminID},
+			Message: &echotron.Message{ID: 3, Chat: echotron.Chat{ID: testAdminID}}}
+		HandleCallbackQuery(ctx, db, api, cq)
+	}
+	press(unpinAllCallback + ":-100")
+	var confirm string
+	for _, c := range tg.calls {
+		if c.method == "editMessageText" {
+			markup := c.params.Get("reply_markup")
+			start := strings.Index(markup, unpinAllConfirmCallback)
+			confirm = markup[start : start+strings.IndexByte(markup[start:], '"')]
+		}
+	}
+	if !strings.HasPrefix(confirm, unpinAllConfirmCallback+":-100:") {
+		t.Fatalf("confirm button data = %q, want a token after the group ID", confirm)
+	}
+
+	press(confirm)
+	press(confirm)
+	if n := tg.count("unpinAllChatMessages"); n != 1 {
+		t.Fatalf("unpinAllChatMessages called %d times, want once", n)
+	}
+
+	// A token is only good for the group it was issued for
+	token := unpinAllConfirmations.issue(-200, time.Now())
+	press(unpinAllConfirmCallback + ":-100:" + token)
+	if n := tg.count("unpinAllChatMessages"); n != 1 {
+		t.Fatal("another group's token unpinned the group")
+	}
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"example.com/random_coffee/database"
+	"github.com/NicoNex/echotron/v3"
+	"github.com/rs/zerolog/log"
+)
+
+// Names of the operational counters the weekly report is built from. Quizzes sent and pairing runs
+// that produced pairs are read from sent_poll and cycle_funnel instead.
+const (
+	counterQuizFailed       = "quiz.failed"
+	counterRunTooFew        = "run.too_few"        // fewer than two sign-ups, nothing to pair
+	counterRunRejected      = "run.quality_failed" // the quality check stopped the run
+	counterRunFailed        = "run.failed"         // a database or matching error stopped the run
+	counterRunManual        = "run.manual"         // pairs composed by an admin with /manual_pairs
+	counterHolidaySkipped   = "holiday.skipped"
+	counterDMBlocked        = "dm.blocked" // the user blocked the bot or deleted the chat
+	counterDMFailed         = "dm.failed"  // any other delivery error of a private message
+	counterBotRemoved       = "permission.bot_removed"
+	counterPinFailed        = "permission.pin_failed"
+	counterUnpinFailed      = "permission.unpin_failed"
+	counterPollsForbidden   = "permission.polls_forbidden"
+	counterGroupDeactivated = "group.deactivated"
+	counterAlerts           = "notifier.alerts"   // error log entries, identical ones counted each time
+	counterAlertMessages    = "notifier.messages" // Telegram messages the alerts went out in
+	counterReportSent       = "weekly_report.sent"
+	counterSessionsCreated  = "sessions.created" // dispatcher sessions, recreated ones after expiry included
+	counterSessionsExpired  = "sessions.expired"
+
+	// opsCounterFlushInterval is how often counts are written to the database and the report is checked for
+	opsCounterFlushInterval = 5 * time.Minute
+)
+
+// eventCounter counts operational events in memory, by the week they happened in, until the next flush
+type eventCounter struct {
+	mu     sync.Mutex
+	counts map[string]map[string]int64
+}
+
+var opsCounters = &eventCounter{counts: make(map[string]map[string]int64)}
+
+// add counts n more events called name this week
+func (c *eventCounter) add(name string, n int64) {
+	week := getWeekStart(time.Now())
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.counts[week] == nil {
+		c.counts[week] = make(map[string]int64)
+	}
+	c.counts[week][name] += n
+}
+
+// take returns the counts gathered since the last take and starts over
+func (c *eventCounter) take() map[string]map[string]int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	counts := c.counts
+	c.counts = make(map[string]map[string]int64)
+	return counts
+}
+
+// restore puts back counts that could not be written, so the next flush retries them
+func (c *eventCounter) restore(counts map[string]map[string]int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for week, names := range counts {
+		if c.counts[week] == nil {
+			c.counts[week] = make(map[string]int64)
+		}
+		for name, n := range names {
+			c.counts[week][name] += n
+		}
+	}
+}
+
+// countOps counts one operational event for the weekly report
+func countOps(name string) {
+	opsCounters.add(name, 1)
+}
+
+// flushOpsCounters adds the counts gathered in memory to the weekly totals in the database
+func flushOpsCounters(ctx context.Context, db *sql.DB) {
+	counts := opsCounters.take()
+	if len(counts) == 0 {
+		return
+	}
+	if err := database.AddOpsCounts(ctx, db, counts); err != nil {
+		opsCounters.restore(counts)
+		botEvent(log.Warn(), EventOpsCountersFailed).Err(err).Msg("AddOpsCounts failed, will retry")
+	}
+}
+
+// weekStartTime returns midnight of the Monday of t's week, in t's location
+func weekStartTime(t time.Time) time.Time {
+	monday, _ := time.ParseInLocation("2006-01-02", getWeekStart(t), t.Location())
+	return monday
+}
+
+// weeklyReportHour is the hour on Monday, server time, after which the report on the past week is sent
+func weeklyReportHour() int {
+	if hour := envInt("WEEKLY_REPORT_HOUR", 9); hour < 24 {
+		return hour
+	}
+	return 9
+}
+
+// weeklyReport is what happened across all groups in one week
+type weeklyReport struct {
+	from, to        time.Time
+	counts          map[string]int64
+	quizzesSent     int
+	funnels         []database.CycleFunnel // pairing runs that produced pairs
+	reconciliations []database.PollReconciliation
+	liveSessions    int // dispatcher sessions in memory when the report is made
+}
+
+// collectWeeklyReport reads the week starting at from out of the counters and the per-run records
+func collectWeeklyReport(ctx context.Context, db *sql.DB, from time.Time) (weeklyReport, error) {
+	r := weeklyReport{from: from, to: from.AddDate(0, 0, 7), liveSessions: sessions.count()}
+	reader := readerDB(db)
+
+	var err error
+	if r.counts, err = database.GetOpsCounts(ctx, reader, getWeekStart(from)); err != nil {
+		return r, fmt.Errorf("ops counts: %w", err)
+	}
+	if r.quizzesSent, err = database.CountSentPolls(ctx, reader, r.from, r.to); err != nil {
+		return r, fmt.Errorf("sent polls: %w", err)
+	}
+	if r.funnels, err = database.GetCycleFunnelsBetween(ctx, reader, r.from, r.to); err != nil {
+		return r, fmt.Errorf("cycle funnels: %w", err)
+	}
+	if r.reconciliations, err = database.GetPollReconciliationsBetween(ctx, reader, r.from, r.to); err != nil {
+		return r, fmt.Errorf("poll reconciliations: %w", err)
+	}
+	return r, nil
+}
+
+// formatWeeklyReport renders the report for admins
+func formatWeeklyReport(r weeklyReport) string {
+	c := r.counts
+	text := fmt.Sprintf("📊 Неделя %s - %s, все группы\n\n", r.from.Format("02.01"), r.to.AddDate(0, 0, -1).Format("02.01.2006"))
+
+	text += fmt.Sprintf("📨 Опросы: отправлено %d, не отправлено %d\n", r.quizzesSent, c[counterQuizFailed])
+
+	runs := int64(len(r.funnels)) + c[counterRunTooFew] + c[counterRunRejected] + c[counterRunFailed]
+	text += fmt.Sprintf("🎲 Запуски создания пар: %d. Пары созданы: %d, мало участников: %d, остановлено проверкой качества: %d, ошибки: %d\n",
+		runs, len(r.funnels), c[counterRunTooFew], c[counterRunRejected], c[counterRunFailed])
+	if c[counterRunManual] > 0 {
+		text += fmt.Sprintf("✍️ Пары, составленные вручную: %d\n", c[counterRunManual])
+	}
+	if c[counterHolidaySkipped] > 0 {
+		text += fmt.Sprintf("🎉 Пропущено из-за праздников: %d\n", c[counterHolidaySkipped])
+	}
+
+	groups := make(map[int64]bool)
+	pairs, matched, signedUp := 0, 0, 0
+	for _, f := range r.funnels {
+		groups[f.GroupID] = true
+		pairs += f.Pairs
+		matched += f.Matched
+		signedUp += f.SignedUp
+	}
+	text += fmt.Sprintf("☕ Пары: %d, участников в них: %d из %d записавшихся, групп: %d\n", pairs, matched, signedUp, len(groups))
+
+	text += fmt.Sprintf("✉️ Личные сообщения не доставлены: %d, из них бот заблокирован или чат удален: %d\n",
+		c[counterDMBlocked]+c[counterDMFailed], c[counterDMBlocked])
+	text += fmt.Sprintf("🔒 Права бота: удален из группы или без прав: %d, не закрепил опрос: %d, не открепил опрос: %d, опросы запрещены: %d\n",
+		c[counterBotRemoved], c[counterPinFailed], c[counterUnpinFailed], c[counterPollsForbidden])
+	text += fmt.Sprintf("💤 Отключено групп: %d\n", c[counterGroupDeactivated])
+
+	var mismatches []database.PollReconciliation
+	for _, rec := range r.reconciliations {
+		if rec.Diff() != 0 {
+			mismatches = append(mismatches, rec)
+		}
+	}
+	text += fmt.Sprintf("🔍 Сверка опросов: расхождений %d из %d\n", len(mismatches), len(r.reconciliations))
+	sort.Slice(mismatches, func(i, j int) bool { return mismatches[i].GroupID < mismatches[j].GroupID })
+	for _, rec := range mismatches {
+		text += fmt.Sprintf("• группа %d, неделя %s: в опросе %d, в базе %d\n", rec.GroupID, rec.WeekStart, rec.PollYes, rec.StoredYes)
+	}
+
+	text += fmt.Sprintf("⚠️ Ошибки для админов: %d в %d сообщениях\n", c[counterAlerts], c[counterAlertMessages])
+	text += fmt.Sprintf("🧠 Сессии чатов: создано %d, истекло %d, сейчас в памяти %d\n",
+		c[counterSessionsCreated], c[counterSessionsExpired], r.liveSessions)
+	return text
+}
+
+// sendWeeklyReport sends the report on the week starting at from to every admin
+func sendWeeklyReport(ctx context.Context, db *sql.DB, api echotron.API, from time.Time) error {
+	r, err := collectWeeklyReport(ctx, db, from)
+	if err != nil {
+		return err
+	}
+	text := formatWeeklyReport(r)
+	for adminID := range adminChatIDsMap {
+		sendLongMessage(api, text, adminID)
+	}
+	return nil
+}
+
+// sendDueWeeklyReport sends the report on the past week once, from Monday's report hour on. A report
+// missed because the bot was down goes out as soon as it is back during the week.
+func sendDueWeeklyReport(ctx context.Context, db *sql.DB, api echotron.API, now time.Time) {
+	thisWeek := weekStartTime(now)
+	if now.Before(thisWeek.Add(time.Duration(weeklyReportHour()) * time.Hour)) {
+		return
+	}
+	lastWeek := thisWeek.AddDate(0, 0, -7)
+	week := getWeekStart(lastWeek)
+
+	counts, err := database.GetOpsCounts(ctx, db, week)
+	if err != nil {
+		botEvent(log.Error(), EventWeeklyReportFailed).Err(err).Str("week", week).Msg("GetOpsCounts failed")
+		return
+	}
+	if counts[counterReportSent] > 0 {
+		return
+	}
+
+	if err := sendWeeklyReport(ctx, db, api, lastWeek); err != nil {
+		botEvent(log.Error(), EventWeeklyReportFailed).Err(err).Str("week", week).Msg("Weekly report failed")
+		return
+	}
+	// The mark goes into the reported week's totals, which nothing else adds to any more
+	if err := database.AddOpsCounts(ctx, db, map[string]map[string]int64{week: {counterReportSent: 1}}); err != nil {
+		botEvent(log.Error(), EventWeeklyReportFailed).Err(err).Str("week", week).Msg("Failed to mark weekly report as sent")
+	}
+	botEvent(log.Info(), EventWeeklyReportSent).Str("week", week).Msg("Weekly report sent")
+}
+
+// startWeeklyReporter writes the counters to the database every few minutes and sends the Monday report
+func startWeeklyReporter(db *sql.DB, api echotron.API, stopChan chan struct{}) {
+	scheduler.startWorker("weekly_report", func() {
+		ticker := time.NewTicker(opsCounterFlushInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				ctx := context.Background()
+				flushOpsCounters(ctx, db)
+				sendDueWeeklyReport(ctx, db, api, time.Now())
+			case <-stopChan:
+				return
+			}
+		}
+	})
+}
+
+// handleWeeklyReportCommand implements /weekly_report: the report on the past week, right away
+func handleWeeklyReportCommand(ctx context.Context, db *sql.DB, api echotron.API, message *echotron.Message) {
+	chatID := message.Chat.ID
+	if !isAdmin(message.From.ID) {
+		sendMessage(api, "❌ Доступ запрещен", chatID)
+		return
+	}
+
+	flushOpsCounters(ctx, db)
+	r, err := collectWeeklyReport(ctx, db, weekStartTime(time.Now()).AddDate(0, 0, -7))
+	if err != nil {
+		botEvent(log.Error(), EventWeeklyReportFailed).Err(err).Msg("collectWeeklyReport failed")
+		sendMessage(api, "❌ Не удалось собрать отчет", chatID)
+		return
+	}
+	sendLongMessage(api, formatWeeklyReport(r), chatID)
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"example.com/random_coffee/database"
+)
+
+// reportWeek is the fixture week: Monday 2 March 2026 to Sunday 8 March
+var reportWeek = time.Date(2026, 3, 2, 0, 0, 0, 0, time.Local)
+
+func TestFormatWeeklyReport(t *testing.T) {
+	r := weeklyReport{
+		from: reportWeek,
+		to:   reportWeek.AddDate(0, 0, 7),
+		counts: map[string]int64{
+			counterQuizFailed:       1,
+			counterRunTooFew:        2,
+			counterRunRejected:      1,
+			counterRunFailed:        1,
+			counterRunManual:        1,
+			counterHolidaySkipped:   3,
+			counterDMBlocked:        4,
+			counterDMFailed:         2,
+			counterBotRemoved:       1,
+			counterPinFailed:        2,
+			counterUnpinFailed:      3,
+			counterPollsForbidden:   1,
+			counterGroupDeactivated: 2,
+			counterAlerts:           17,
+			counterAlertMessages:    5,
+			counterSessionsCreated:  40,
+			counterSessionsExpired:  31,
+		},
+		quizzesSent: 9,
+		funnels: []database.CycleFunnel{
+			{GroupID: -1, SignedUp: 10, Matched: 9, Pairs: 4},
+			{GroupID: -2, SignedUp: 7, Matched: 6, Pairs: 3},
+			{GroupID: -1, SignedUp: 5, Matched: 4, Pairs: 2}, // the same group's manual pairs
+		},
+		reconciliations: []database.PollReconciliation{
+			{GroupID: -2, WeekStart: "2026-03-02", PollYes: 8, StoredYes: 7},
+			{GroupID: -1, WeekStart: "2026-03-02", PollYes: 10, StoredYes: 10},
+			{GroupID: -3, WeekStart: "2026-03-02", PollYes: 3, StoredYes: 4},
+		},
+		liveSessions: 9,
+	}
+
+	want := "📊 Неделя 02.03 - 08.03.2026, все группы\n\n" +
+		"📨 Опросы: отправлено 9, не отправлено 1\n" +
+		"🎲 Запуски создания пар: 7. Пары созданы: 3, мало участников: 2, остановлено проверкой качества: 1, ошибки: 1\n" +
+		"✍️ Пары, составленные вручную: 1\n" +
+		"🎉 Пропущено из-за праздников: 3\n" +
+		"☕ Пары: 9, участников в них: 19 из 22 записавшихся, групп: 2\n" +
+		"✉️ Личные сообщения не доставлены: 6, из них бот заблокирован или чат удален: 4\n" +
+		"🔒 Права бота: удален из группы или без прав: 1, не закрепил опрос: 2, не открепил опрос: 3, опросы запрещены: 1\n" +
+		"💤 Отключено групп: 2\n" +
+		"🔍 Сверка опросов: расхождений 2 из 3\n" +
+		"• группа -3, неделя 2026-03-02: в опросе 3, в базе 4\n" +
+		"• группа -2, неделя 2026-03-02: в опросе 8, в базе 7\n" +
+		"⚠️ Ошибки для админов: 17 в 5 сообщениях\n" +
+		"🧠 Сессии чатов: создано 40, истекло 31, сейчас в памяти 9\n"
+	if got := formatWeeklyReport(r); got != want {
+		t.Fatalf("formatWeeklyReport =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestFormatWeeklyReportQuietWeek(t *testing.T) {
+	r := weeklyReport{from: reportWeek, to: reportWeek.AddDate(0, 0, 7), counts: map[string]int64{}}
+	got := formatWeeklyReport(r)
+	for _, line := range []string{"отправлено 0, не отправлено 0", "Запуски создания пар: 0.", "Пары: 0, участников в них: 0 из 0 записавшихся, групп: 0",
+		"расхождений 0 из 0", "Ошибки для админов: 0 в 0 сообщениях"} {
+		if !strings.Contains(got, line) {
+			t.Errorf("quiet week report misses %q:\n%s", line, got)
+		}
+	}
+	// Lines about rare events are left out when nothing happened
+	if strings.Contains(got, "вручную") || strings.Contains(got, "праздников") {
+		t.Errorf("quiet week report mentions manual pairs or holidays:\n%s", got)
+	}
+}
+
+// seedReportWeek stores a fixture week of run results, with records of the weeks around it that the report must leave out
+func seedReportWeek(t *testing.T, db *sql.DB) {
+	t.Helper()
+	ctx := context.Background()
+	inWeek := reportWeek.Add(3*24*time.Hour + 10*time.Hour) // Thursday
+	before, after := reportWeek.Add(-time.Hour), reportWeek.AddDate(0, 0, 7).Add(time.Hour)
+
+	for i, at := range []time.Time{inWeek, inWeek, before, after} {
+		if err := database.RecordSentPoll(ctx, db, database.SentPoll{PollID: fmt.Sprintf("poll%d", i), GroupID: -1, SentAt: at}); err != nil {
+			t.Fatalf("RecordSentPoll: %v", err)
+		}
+	}
+	funnels := []database.CycleFunnel{
+		{GroupID: -1, WeekStart: "2026-03-02", PollID: "poll0", SignedUp: 6, Matched: 6, Pairs: 3, CreatedAt: inWeek},
+		{GroupID: -2, WeekStart: "2026-03-02", PollID: "poll1", SignedUp: 5, Matched: 4, Pairs: 2, CreatedAt: inWeek},
+		{GroupID: -1, WeekStart: "2026-02-23", PollID: "poll2", SignedUp: 50, Matched: 50, Pairs: 25, CreatedAt: before},
+	}
+	for _, f := range funnels {
+		if err := database.SaveCycleFunnel(ctx, db, f); err != nil {
+			t.Fatalf("SaveCycleFunnel: %v", err)
+		}
+	}
+	recs := []database.PollReconciliation{
+		{PollID: "poll0", GroupID: -1, WeekStart: "2026-03-02", PollYes: 6, StoredYes: 6, CreatedAt: inWeek},
+		{PollID: "poll1", GroupID: -2, WeekStart: "2026-03-02", PollYes: 6, StoredYes: 5, CreatedAt: inWeek},
+		{PollID: "poll3", GroupID: -2, WeekStart: "2026-03-09", PollYes: 1, StoredYes: 9, CreatedAt: after},
+	}
+	for _, rec := range recs {
+		if err := database.SavePollReconciliation(ctx, db, rec); err != nil {
+			t.Fatalf("SavePollReconciliation: %v", err)
+		}
+	}
+	counts := map[string]map[string]int64{
+		"2026-03-02": {counterQuizFailed: 1, counterRunTooFew: 1, counterDMBlocked: 2, counterAlerts: 4, counterAlertMessages: 2},
+		"2026-03-09": {counterQuizFailed: 30, counterAlerts: 100},
+	}
+	if err := database.AddOpsCounts(ctx, db, counts); err != nil {
+		t.Fatalf("AddOpsCounts: %v", err)
+	}
+}
+
+func TestCollectWeeklyReportFixtureWeek(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	seedReportWeek(t, db)
+
+	r, err := collectWeeklyReport(ctx, db, reportWeek)
+	if err != nil {
+		t.Fatalf("collectWeeklyReport: %v", err)
+	}
+	got := formatWeeklyReport(r)
+	for _, line := range []string{
+		"📨 Опросы: отправлено 2, не отправлено 1\n",
+		"🎲 Запуски создания пар: 3. Пары созданы: 2, мало участников: 1,",
+		"☕ Пары: 5, участников в них: 10 из 11 записавшихся, групп: 2\n",
+		"✉️ Личные сообщения не доставлены: 2, из них бот заблокирован или чат удален: 2\n",
+		"🔍 Сверка опросов: расхождений 1 из 2\n• группа -2, неделя 2026-03-02: в опросе 6, в базе 5\n",
+		"⚠️ Ошибки для админов: 4 в 2 сообщениях\n",
+	} {
+		if !strings.Contains(got, line) {
+			t.Errorf("report misses %q:\n%s", line, got)
+		}
+	}
+}
+
+func TestSendDueWeeklyReport(t *testing.T) {
+	db := openTestDB(t)
+	tg, api := newFakeTelegram(t)
+	withAdmins(t, 1, 2)
+	ctx := context.Background()
+	seedReportWeek(t, db)
+	t.Setenv("WEEKLY_REPORT_HOUR", "9")
+
+	nextMonday := reportWeek.AddDate(0, 0, 7)
+	sendDueWeeklyReport(ctx, db, api, nextMonday.Add(8*time.Hour))
+	if n := tg.count("sendMessage"); n != 0 {
+		t.Fatalf("%d messages before the report hour", n)
+	}
+
+	sendDueWeeklyReport(ctx, db, api, nextMonday.Add(9*time.Hour))
+	sendDueWeeklyReport(ctx, db, api, nextMonday.Add(30*time.Hour))
+	for _, admin := range []int64{1, 2} {
+		if got := tg.sent(admin); len(got) != 1 || !strings.Contains(got[0], "Неделя 02.03 - 08.03.2026") {
+			t.Fatalf("admin %d got %q, want the report once", admin, got)
+		}
+	}
+}
+
+func TestWeeklyReportIsChunked(t *testing.T) {
+	db := openTestDB(t)
+	tg, api := newFakeTelegram(t)
+	withAdmins(t, 1)
+	ctx := context.Background()
+
+	// Enough mismatched polls to exceed one Telegram message
+	const polls = 150
+	for i := 0; i < polls; i++ {
+		rec := database.PollReconciliation{PollID: fmt.Sprintf("poll%d", i), GroupID: int64(-1000 - i), WeekStart: "2026-03-02",
+			PollYes: 10, StoredYes: 9, CreatedAt: reportWeek.Add(time.Hour)}
+		if err := database.SavePollReconciliation(ctx, db, rec); err != nil {
+			t.Fatalf("SavePollReconciliation: %v", err)
+		}
+	}
+	if err := sendWeeklyReport(ctx, db, api, reportWeek); err != nil {
+		t.Fatalf("sendWeeklyReport: %v", err)
+	}
+
+	chunks := tg.sent(1)
+	if len(chunks) < 2 {
+		t.Fatalf("report sent in %d message, want it split", len(chunks))
+	}
+	for _, chunk := range chunks {
+		if n := utf8.RuneCountInString(chunk); n > telegramMessageLimit {
+			t.Fatalf("chunk of %d characters", n)
+		}
+	}
+	all := strings.Join(chunks, "\n")
+	if !strings.Contains(all, fmt.Sprintf("расхождений %d из %d", polls, polls)) || strings.Count(all, "• группа") != polls ||
+		!strings.Contains(all, "⚠️ Ошибки для админов") {
+		t.Fatalf("chunks lost lines of the report")
+	}
+}
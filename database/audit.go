@@ -0,0 +1,26 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type AuditEntry struct {
+	ID        uuid.UUID
+	ActorID   int64
+	Action    string
+	GroupID   int64
+	Details   string
+	CreatedAt time.Time
+}
+
+func CreateAuditEntry(ctx context.Context, db *sql.DB, e AuditEntry) error {
+	query := `INSERT INTO audit_log (id, actor_id, action, group_id, details, created_at)
+	VALUES (?, ?, ?, ?, ?, ?)`
+
+	_, err := db.ExecContext(ctx, query, e.ID.String(), e.ActorID, e.Action, e.GroupID, e.Details, formatTime(e.CreatedAt))
+	return err
+}
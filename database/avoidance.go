@@ -0,0 +1,48 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// Avoidance operations. Rows hold keyed hashes only: who declared the avoidance (owner) and
+// the unordered couple it covers (pair), so the table alone doesn't tell who avoids whom.
+
+func AddAvoidance(ctx context.Context, db *sql.DB, groupID int64, ownerHash, pairHash string) error {
+	query := `INSERT OR IGNORE INTO avoidance (group_id, owner_hash, pair_hash, created_at) VALUES (?, ?, ?, ?)`
+	_, err := db.ExecContext(ctx, query, groupID, ownerHash, pairHash, formatTime(time.Now()))
+	return err
+}
+
+// DeleteAvoidance removes one of the owner's avoidances and reports whether it existed
+func DeleteAvoidance(ctx context.Context, db *sql.DB, groupID int64, ownerHash, pairHash string) (bool, error) {
+	query := `DELETE FROM avoidance WHERE group_id = ? AND owner_hash = ? AND pair_hash = ?`
+	res, err := db.ExecContext(ctx, query, groupID, ownerHash, pairHash)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// GetOwnerAvoidances returns the pair hashes the owner declared in the group
+func GetOwnerAvoidances(ctx context.Context, db *sql.DB, groupID int64, ownerHash string) ([]string, error) {
+	query := `SELECT pair_hash FROM avoidance WHERE group_id = ? AND owner_hash = ? ORDER BY created_at`
+	return queryRows(ctx, db, query, scanString, groupID, ownerHash)
+}
+
+// GetAvoidedPairHashes returns every couple avoided in the group, each once however many people declared it
+func GetAvoidedPairHashes(ctx context.Context, db *sql.DB, groupID int64) ([]string, error) {
+	query := `SELECT DISTINCT pair_hash FROM avoidance WHERE group_id = ?`
+	return queryRows(ctx, db, query, scanString, groupID)
+}
+
+// CountAvoidances returns how many avoidances were declared in the group
+func CountAvoidances(ctx context.Context, db *sql.DB, groupID int64) (int, error) {
+	query := `SELECT COUNT(*) FROM avoidance WHERE group_id = ?`
+
+	var count int
+	err := db.QueryRowContext(ctx, query, groupID).Scan(&count)
+	return count, err
+}
@@ -0,0 +1,62 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"math/rand"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// busyAttempts bounds how often a write is tried while SQLite reports the database locked
+	busyAttempts = 4
+
+	// busyBaseDelay is the wait before the first retry; it doubles after each attempt, plus jitter
+	busyBaseDelay = 50 * time.Millisecond
+)
+
+// busyEvents counts writes that found the database locked, retried or not
+var busyEvents atomic.Int64
+
+// BusyEvents returns how many writes found the database locked since start
+func BusyEvents() int64 {
+	return busyEvents.Load()
+}
+
+// IsBusy reports whether err means another connection holds a lock on the database,
+// i.e. the same write may succeed a moment later
+func IsBusy(err error) bool {
+	if err == nil {
+		return false
+	}
+	errStr := err.Error()
+	return strings.Contains(errStr, "SQLITE_BUSY") ||
+		strings.Contains(errStr, "SQLITE_LOCKED") ||
+		strings.Contains(errStr, "database is locked") ||
+		strings.Contains(errStr, "database table is locked")
+}
+
+// execRetryingBusy runs a write, retrying with jittered backoff only while the database is locked.
+// Other errors and the last busy error are returned as is.
+func execRetryingBusy(ctx context.Context, db *sql.DB, query string, args ...any) (sql.Result, error) {
+	delay := busyBaseDelay
+	for attempt := 1; ; attempt++ {
+		res, err := db.ExecContext(ctx, query, args...)
+		if !IsBusy(err) {
+			return res, err
+		}
+		busyEvents.Add(1)
+		if attempt == busyAttempts {
+			return nil, err
+		}
+
+		select {
+		case <-time.After(delay + time.Duration(rand.Int63n(int64(delay)))):
+		case <-ctx.Done():
+			return nil, err
+		}
+		delay *= 2
+	}
+}
@@ -0,0 +1,53 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Dashboard is a user's /dashboard message in their private chat. MessageID is zero when the message
+// is gone and has to be sent again on the next refresh.
+type Dashboard struct {
+	UserID    int64
+	MessageID int64
+	UpdatedAt time.Time
+}
+
+// Dashboard operations
+
+// SaveDashboard stores the user's dashboard message, replacing the previous one
+func SaveDashboard(ctx context.Context, db *sql.DB, d Dashboard) error {
+	query := `INSERT INTO dashboard (user_id, message_id, updated_at) VALUES (?, ?, ?)
+	ON CONFLICT (user_id) DO UPDATE SET message_id = EXCLUDED.message_id, updated_at = EXCLUDED.updated_at`
+	_, err := db.ExecContext(ctx, query, d.UserID, d.MessageID, formatTime(d.UpdatedAt))
+	return err
+}
+
+// GetDashboard returns the user's dashboard, or nil if they have none
+func GetDashboard(ctx context.Context, db *sql.DB, userID int64) (*Dashboard, error) {
+	query := `SELECT user_id, message_id, updated_at FROM dashboard WHERE user_id = ?`
+
+	var d Dashboard
+	var updatedAtStr string
+	err := db.QueryRowContext(ctx, query, userID).Scan(&d.UserID, &d.MessageID, &updatedAtStr)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get dashboard: %w", err)
+	}
+	d.UpdatedAt = parseTime(updatedAtStr)
+	return &d, nil
+}
+
+// DeleteDashboard stops keeping the user's dashboard up to date and reports whether there was one
+func DeleteDashboard(ctx context.Context, db *sql.DB, userID int64) (bool, error) {
+	res, err := db.ExecContext(ctx, `DELETE FROM dashboard WHERE user_id = ?`, userID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
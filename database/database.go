@@ -1,204 +1,132 @@
+// Package database holds the bot's SQLite queries, one file per aggregate, and the helpers they share.
 package database
 
 import (
 	"context"
 	"database/sql"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
 )
 
-type Participant struct {
-	ID        uuid.UUID
-	GroupID   int64
-	UserID    int64
-	Username  string
-	FullName  string
-	CreatedAt time.Time
-}
-
-type Pair struct {
-	ID        uuid.UUID
-	GroupID   int64
-	WeekStart string
-	User1ID   int64
-	User2ID   int64
-	CreatedAt time.Time
-}
-
-type PollMapping struct {
-	PollID    string
-	GroupID   int64
-	MessageID int64
-}
+// timeLayout is how timestamps are stored: UTC RFC 3339 also sorts correctly as text
+const timeLayout = time.RFC3339
 
-// Participant operations
-
-func CreateOrUpdateParticipant(ctx context.Context, db *sql.DB, p Participant) error {
-	query := `INSERT INTO participant (id, group_id, user_id, username, full_name, created_at)
-	VALUES (?, ?, ?, ?, ?, ?)
-	ON CONFLICT (group_id, user_id) DO UPDATE
-	SET username = EXCLUDED.username, full_name = EXCLUDED.full_name`
-
-	_, err := db.ExecContext(ctx, query, p.ID.String(), p.GroupID, p.UserID, p.Username, p.FullName, p.CreatedAt)
-	return err
+func formatTime(t time.Time) string {
+	return t.UTC().Format(timeLayout)
 }
 
-func GetAllParticipants(ctx context.Context, db *sql.DB, groupID int64) ([]Participant, error) {
-	query := `SELECT id, group_id, user_id, username, full_name, created_at
-	FROM participant WHERE group_id = ?`
-
-	rows, err := db.QueryContext(ctx, query, groupID)
-	if err != nil {
-		return nil, err
+// parseTime parses a stored timestamp. Rows written before timestamps were
+// formatted explicitly hold time.Time.String() output, so that is accepted too.
+func parseTime(s string) time.Time {
+	if t, err := time.Parse(timeLayout, s); err == nil {
+		return t
 	}
-	defer rows.Close()
 
-	participants := make([]Participant, 0)
-	for rows.Next() {
-		var p Participant
-		var idStr, createdAtStr string
-		if err := rows.Scan(&idStr, &p.GroupID, &p.UserID, &p.Username, &p.FullName, &createdAtStr); err != nil {
-			return nil, err
+	// Drop the monotonic clock reading ("m=+0.001") that time.Time.String() appends
+	if i := strings.Index(s, " m="); i >= 0 {
+		s = s[:i]
+	}
+	for _, layout := range []string{"2006-01-02 15:04:05.999999999 -0700 MST", "2006-01-02 15:04:05"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t
 		}
+	}
+	return time.Time{}
+}
 
-		// Parse time string - SQLite returns timestamps as strings
-		p.CreatedAt, _ = time.Parse(time.RFC3339, createdAtStr)
-		if p.CreatedAt.IsZero() {
-			p.CreatedAt, _ = time.Parse("2006-01-02 15:04:05", createdAtStr)
-		}
+// nullableID stores zero as NULL
+func nullableID(id int64) sql.NullInt64 {
+	return sql.NullInt64{Int64: id, Valid: id != 0}
+}
 
-		p.ID, _ = uuid.Parse(idStr)
-		participants = append(participants, p)
-	}
-	return participants, nil
+// CorruptRowError reports a stored value that can't be decoded; Row identifies the offending row
+type CorruptRowError struct {
+	Table  string
+	Column string
+	Row    string
+	Value  string
+	Err    error
 }
 
-func DeleteParticipant(ctx context.Context, db *sql.DB, groupID, userID int64) error {
-	query := `DELETE FROM participant WHERE group_id = ? AND user_id = ?`
-	_, err := db.ExecContext(ctx, query, groupID, userID)
-	return err
+func (e *CorruptRowError) Error() string {
+	return fmt.Sprintf("corrupt %s.%s in row %s: %q: %v", e.Table, e.Column, e.Row, e.Value, e.Err)
 }
 
-func ClearAllParticipants(ctx context.Context, db *sql.DB, groupID int64) error {
-	query := `DELETE FROM participant WHERE group_id = ?`
-	_, err := db.ExecContext(ctx, query, groupID)
-	return err
+func (e *CorruptRowError) Unwrap() error {
+	return e.Err
 }
 
-// Pair operations
+// OnCorruptRow, when set, is told about every stored value a read fails to decode, on top of the
+// error returned to the caller. The bot raises a critical alert with it.
+var OnCorruptRow func(*CorruptRowError)
 
-func CreatePairs(ctx context.Context, db *sql.DB, pairs []Pair) error {
-	if len(pairs) == 0 {
-		return nil
+// corruptRow builds the error for a stored value that can't be decoded and reports it to OnCorruptRow
+func corruptRow(table, column, row, value string, err error) *CorruptRowError {
+	e := &CorruptRowError{Table: table, Column: column, Row: row, Value: value, Err: err}
+	if OnCorruptRow != nil {
+		OnCorruptRow(e)
 	}
+	return e
+}
 
-	query := `INSERT INTO pair (id, group_id, week_start, user1_id, user2_id, created_at)
-	VALUES (?, ?, ?, ?, ?, ?)`
-
-	for _, p := range pairs {
-		if _, err := db.ExecContext(ctx, query, p.ID.String(), p.GroupID, p.WeekStart, p.User1ID, p.User2ID, p.CreatedAt); err != nil {
-			return err
-		}
+// parseStoredUUID decodes a UUID column, failing with a CorruptRowError rather than returning a zero UUID
+func parseStoredUUID(table, column, row, value string) (uuid.UUID, error) {
+	id, err := uuid.Parse(value)
+	if err != nil {
+		return uuid.Nil, corruptRow(table, column, row, value, err)
 	}
-	return nil
+	return id, nil
+}
+
+// execer runs a statement on *sql.DB or inside a *sql.Tx
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
 }
 
-func GetAvailablePairs(ctx context.Context, db *sql.DB, groupID int64) ([][2]Participant, error) {
-	query := `
-	WITH available_users AS (
-		SELECT
-			p1.id as p1_id, p1.user_id as p1_user_id, p1.username as p1_username,
-			p1.full_name as p1_full_name, p1.created_at as p1_created_at,
-			p2.id as p2_id, p2.user_id as p2_user_id, p2.username as p2_username,
-			p2.full_name as p2_full_name, p2.created_at as p2_created_at
-		FROM participant p1
-		CROSS JOIN participant p2
-		WHERE p1.group_id = ? AND p2.group_id = ? AND p1.user_id < p2.user_id
-	)
-	SELECT p1_id, p1_user_id, p1_username, p1_full_name, p1_created_at,
-	       p2_id, p2_user_id, p2_username, p2_full_name, p2_created_at
-	FROM available_users au
-	WHERE NOT EXISTS (
-		SELECT 1 FROM pair pr
-		WHERE pr.group_id = ?
-		  AND ((pr.user1_id = au.p1_user_id AND pr.user2_id = au.p2_user_id)
-			OR (pr.user1_id = au.p2_user_id AND pr.user2_id = au.p1_user_id))
-	)
-	ORDER BY RANDOM()`
-
-	rows, err := db.QueryContext(ctx, query, groupID, groupID, groupID)
+// rowScanner is a single row: *sql.Row or the current row of *sql.Rows
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// queryRows runs the query and decodes every row with scan
+func queryRows[T any](ctx context.Context, db *sql.DB, query string, scan func(rowScanner) (T, error), args ...any) ([]T, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	pairs := make([][2]Participant, 0)
+	result := make([]T, 0)
 	for rows.Next() {
-		var p1, p2 Participant
-		var p1IDStr, p2IDStr string
-		var p1CreatedAtStr, p2CreatedAtStr string
-		p1.GroupID = groupID
-		p2.GroupID = groupID
-
-		if err := rows.Scan(&p1IDStr, &p1.UserID, &p1.Username, &p1.FullName, &p1CreatedAtStr,
-			&p2IDStr, &p2.UserID, &p2.Username, &p2.FullName, &p2CreatedAtStr); err != nil {
+		v, err := scan(rows)
+		if err != nil {
 			return nil, err
 		}
-
-		// Parse time strings - SQLite returns timestamps as strings
-		p1.CreatedAt, _ = time.Parse(time.RFC3339, p1CreatedAtStr)
-		if p1.CreatedAt.IsZero() {
-			p1.CreatedAt, _ = time.Parse("2006-01-02 15:04:05", p1CreatedAtStr)
-		}
-		p2.CreatedAt, _ = time.Parse(time.RFC3339, p2CreatedAtStr)
-		if p2.CreatedAt.IsZero() {
-			p2.CreatedAt, _ = time.Parse("2006-01-02 15:04:05", p2CreatedAtStr)
-		}
-
-		p1.ID, _ = uuid.Parse(p1IDStr)
-		p2.ID, _ = uuid.Parse(p2IDStr)
-		pairs = append(pairs, [2]Participant{p1, p2})
+		result = append(result, v)
 	}
-	return pairs, nil
+	return result, rows.Err()
 }
 
-// Poll mapping operations
-
-func CreatePollMapping(ctx context.Context, db *sql.DB, pm PollMapping) error {
-	query := `INSERT INTO poll_mapping (poll_id, group_id, message_id) VALUES (?, ?, ?)`
-	_, err := db.ExecContext(ctx, query, pm.PollID, pm.GroupID, pm.MessageID)
-	return err
+func scanInt64(r rowScanner) (int64, error) {
+	var v int64
+	err := r.Scan(&v)
+	return v, err
 }
 
-func GetGroupIDByPollID(ctx context.Context, db *sql.DB, pollID string) (int64, error) {
-	query := `SELECT group_id FROM poll_mapping WHERE poll_id = ?`
-
-	var groupID int64
-	err := db.QueryRowContext(ctx, query, pollID).Scan(&groupID)
-	if err != nil {
-		return 0, fmt.Errorf("poll not found: %w", err)
-	}
-	return groupID, nil
+func scanString(r rowScanner) (string, error) {
+	var v string
+	err := r.Scan(&v)
+	return v, err
 }
 
-func GetPollMappingByGroupID(ctx context.Context, db *sql.DB, groupID int64) (*PollMapping, error) {
-	query := `SELECT poll_id, group_id, message_id FROM poll_mapping WHERE group_id = ? ORDER BY rowid DESC LIMIT 1`
-
-	var pm PollMapping
-	err := db.QueryRowContext(ctx, query, groupID).Scan(&pm.PollID, &pm.GroupID, &pm.MessageID)
-	if err == sql.ErrNoRows {
-		return nil, nil
-	}
-	if err != nil {
-		return nil, fmt.Errorf("failed to get poll mapping: %w", err)
+// inPlaceholders returns the "?, ?, ?" list of an IN clause and the IDs as query arguments
+func inPlaceholders(ids []int64) (string, []any) {
+	args := make([]any, 0, len(ids))
+	for _, id := range ids {
+		args = append(args, id)
 	}
-	return &pm, nil
-}
-
-func DeletePollMapping(ctx context.Context, db *sql.DB, groupID int64) error {
-	query := `DELETE FROM poll_mapping WHERE group_id = ?`
-	_, err := db.ExecContext(ctx, query, groupID)
-	return err
+	return strings.TrimSuffix(strings.Repeat("?, ", len(ids)), ", "), args
 }
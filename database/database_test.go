@@ -0,0 +1,61 @@
+package database
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pressly/goose/v3"
+	_ "modernc.org/sqlite"
+)
+
+func TestMain(m *testing.M) {
+	goose.SetLogger(goose.NopLogger())
+	os.Exit(m.Run())
+}
+
+// openTestDB returns a migrated database in a temporary file
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "bot.db"))
+	if err != nil {
+		t.Fatalf("sql.Open: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if err := Migrate(db, "../migrations"); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+	return db
+}
+
+func TestStoredTimes(t *testing.T) {
+	moscow := time.FixedZone("MSK", 3*60*60)
+	at := time.Date(2026, 5, 1, 19, 30, 15, 0, moscow)
+
+	tests := []struct {
+		name   string
+		stored string
+		want   time.Time
+	}{
+		{"rfc3339 utc", formatTime(at), at},
+		{"legacy time.String", at.String(), at},
+		{"legacy with monotonic reading", time.Date(2026, 5, 1, 19, 30, 15, 500, moscow).String() + " m=+0.001", at.Add(500)},
+		{"legacy sqlite datetime", "2026-05-01 16:30:15", at},
+		{"garbage", "yesterday", time.Time{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseTime(tt.stored); !got.Equal(tt.want) {
+				t.Fatalf("parseTime(%q) = %s, want %s", tt.stored, got, tt.want)
+			}
+		})
+	}
+
+	// Stored as UTC, so text order is time order whatever the zone it was written in
+	if formatTime(at) != "2026-05-01T16:30:15Z" || formatTime(at.Add(time.Second).UTC()) <= formatTime(at) {
+		t.Fatalf("formatTime(%s) = %q", at, formatTime(at))
+	}
+}
@@ -0,0 +1,98 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EnvSnapshot is the GROUP_CHAT_IDS list as last imported. Groups added to the env later join it
+// one by one, as admins import or ignore them.
+type EnvSnapshot struct {
+	GroupIDs  []int64
+	Version   int
+	UpdatedAt time.Time
+}
+
+// Env import operations
+
+// GetEnvSnapshot returns the recorded snapshot, or nil if the env was never imported
+func GetEnvSnapshot(ctx context.Context, db *sql.DB) (*EnvSnapshot, error) {
+	return scanEnvSnapshot(db.QueryRowContext(ctx, envSnapshotQuery))
+}
+
+const envSnapshotQuery = `SELECT group_ids, version, updated_at FROM env_import WHERE id = 1`
+
+func scanEnvSnapshot(r rowScanner) (*EnvSnapshot, error) {
+	var s EnvSnapshot
+	var groupIDs, updatedAtStr string
+	err := r.Scan(&groupIDs, &s.Version, &updatedAtStr)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, part := range strings.Split(groupIDs, ",") {
+		if part == "" {
+			continue
+		}
+		id, err := strconv.ParseInt(part, 10, 64)
+		if err != nil {
+			return nil, corruptRow("env_import", "group_ids", "id=1", groupIDs, err)
+		}
+		s.GroupIDs = append(s.GroupIDs, id)
+	}
+	s.UpdatedAt = parseTime(updatedAtStr)
+	return &s, nil
+}
+
+// SaveEnvSnapshot replaces the recorded group list and bumps the snapshot version
+func SaveEnvSnapshot(ctx context.Context, db *sql.DB, groupIDs []int64) error {
+	return saveEnvSnapshot(ctx, db, groupIDs)
+}
+
+func saveEnvSnapshot(ctx context.Context, ex execer, groupIDs []int64) error {
+	ids := slices.Clone(groupIDs)
+	slices.Sort(ids)
+	ids = slices.Compact(ids)
+	parts := make([]string, 0, len(ids))
+	for _, id := range ids {
+		parts = append(parts, strconv.FormatInt(id, 10))
+	}
+
+	query := `INSERT INTO env_import (id, group_ids, version, updated_at) VALUES (1, ?, 1, ?)
+		ON CONFLICT(id) DO UPDATE SET group_ids = excluded.group_ids, version = env_import.version + 1,
+		updated_at = excluded.updated_at`
+	_, err := ex.ExecContext(ctx, query, strings.Join(parts, ","), formatTime(time.Now()))
+	return err
+}
+
+// AddToEnvSnapshot records an env group an admin has imported or ignored. It reports false
+// if the group was already in the snapshot, e.g. another admin resolved it first.
+func AddToEnvSnapshot(ctx context.Context, db *sql.DB, groupID int64) (bool, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	s, err := scanEnvSnapshot(tx.QueryRowContext(ctx, envSnapshotQuery))
+	if err != nil {
+		return false, err
+	}
+	var ids []int64
+	if s != nil {
+		ids = s.GroupIDs
+	}
+	if slices.Contains(ids, groupID) {
+		return false, nil
+	}
+	if err := saveEnvSnapshot(ctx, tx, append(ids, groupID)); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
@@ -0,0 +1,102 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrExperimentActive means another experiment on the same setting is still running
+var ErrExperimentActive = errors.New("an experiment on this setting is already running")
+
+// Experiment splits groups between two variants of a setting for a number of weekly cycles
+type Experiment struct {
+	ID         int64
+	Name       string
+	SettingKey string
+	VariantA   string
+	VariantB   string
+	Excluded   []int64 // groups that keep their configured value
+	StartWeek  string  // week_start of the first cycle
+	Cycles     int
+	StoppedAt  time.Time // zero while running
+	CreatedBy  int64
+	CreatedAt  time.Time
+}
+
+// experimentColumns is the column list read by scanExperiment
+const experimentColumns = `id, name, setting_key, variant_a, variant_b, excluded_groups, start_week, cycles, stopped_at, created_by, created_at`
+
+func scanExperiment(r rowScanner) (Experiment, error) {
+	var e Experiment
+	var excluded, stoppedAtStr, createdAtStr string
+	if err := r.Scan(&e.ID, &e.Name, &e.SettingKey, &e.VariantA, &e.VariantB, &excluded, &e.StartWeek, &e.Cycles,
+		&stoppedAtStr, &e.CreatedBy, &createdAtStr); err != nil {
+		return e, err
+	}
+	for _, part := range strings.Split(excluded, ",") {
+		if part == "" {
+			continue
+		}
+		id, err := strconv.ParseInt(part, 10, 64)
+		if err != nil {
+			return e, corruptRow("experiment", "excluded_groups", fmt.Sprintf("id=%d", e.ID), excluded, err)
+		}
+		e.Excluded = append(e.Excluded, id)
+	}
+	if stoppedAtStr != "" {
+		e.StoppedAt = parseTime(stoppedAtStr)
+	}
+	e.CreatedAt = parseTime(createdAtStr)
+	return e, nil
+}
+
+// CreateExperiment starts an experiment and returns its ID; it fails with ErrExperimentActive
+// if one is already running on the same setting
+func CreateExperiment(ctx context.Context, db *sql.DB, e Experiment) (int64, error) {
+	excluded := make([]string, 0, len(e.Excluded))
+	for _, id := range e.Excluded {
+		excluded = append(excluded, strconv.FormatInt(id, 10))
+	}
+
+	query := `INSERT INTO experiment (name, setting_key, variant_a, variant_b, excluded_groups, start_week, cycles, created_by, created_at)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	res, err := db.ExecContext(ctx, query, e.Name, e.SettingKey, e.VariantA, e.VariantB, strings.Join(excluded, ","),
+		e.StartWeek, e.Cycles, e.CreatedBy, formatTime(e.CreatedAt))
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE") {
+			return 0, ErrExperimentActive
+		}
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// GetRunningExperiment returns the experiment running on the setting, nil if there is none
+func GetRunningExperiment(ctx context.Context, db *sql.DB, settingKey string) (*Experiment, error) {
+	query := `SELECT ` + experimentColumns + ` FROM experiment WHERE setting_key = ? AND stopped_at = ''`
+
+	e, err := scanExperiment(db.QueryRowContext(ctx, query, settingKey))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get experiment: %w", err)
+	}
+	return &e, nil
+}
+
+// StopExperiment ends a running experiment and reports whether it was still running
+func StopExperiment(ctx context.Context, db *sql.DB, id int64, at time.Time) (bool, error) {
+	query := `UPDATE experiment SET stopped_at = ? WHERE id = ? AND stopped_at = ''`
+	res, err := db.ExecContext(ctx, query, formatTime(at), id)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
@@ -0,0 +1,75 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// CycleFunnel counts how far a group's members got in one weekly cycle
+type CycleFunnel struct {
+	GroupID   int64
+	WeekStart string
+	PollID    string
+	Members   int // chat members when the quiz was sent, zero if unknown
+	SignedUp  int
+	Matched   int // participants placed in a pair or trio
+	Pairs     int
+	CreatedAt time.Time
+
+	// The experiment variant of the pairs announcement this cycle used; zero and empty outside experiments
+	ExperimentID int64
+	Variant      string
+}
+
+// cycleFunnelColumns is the column list read by scanCycleFunnel
+const cycleFunnelColumns = `group_id, week_start, poll_id, members, signed_up, matched, pairs, created_at, experiment_id, variant`
+
+func scanCycleFunnel(r rowScanner) (CycleFunnel, error) {
+	var f CycleFunnel
+	var createdAtStr string
+	err := r.Scan(&f.GroupID, &f.WeekStart, &f.PollID, &f.Members, &f.SignedUp, &f.Matched, &f.Pairs, &createdAtStr,
+		&f.ExperimentID, &f.Variant)
+	f.CreatedAt = parseTime(createdAtStr)
+	return f, err
+}
+
+// SaveCycleFunnel stores the cycle's funnel, replacing an earlier one for the same week
+func SaveCycleFunnel(ctx context.Context, db *sql.DB, f CycleFunnel) error {
+	query := `INSERT OR REPLACE INTO cycle_funnel (` + cycleFunnelColumns + `)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	_, err := db.ExecContext(ctx, query, f.GroupID, f.WeekStart, f.PollID, f.Members, f.SignedUp, f.Matched, f.Pairs,
+		formatTime(f.CreatedAt), f.ExperimentID, f.Variant)
+	return err
+}
+
+// GetRecentCycleFunnels returns the group's latest funnels, newest first
+func GetRecentCycleFunnels(ctx context.Context, db *sql.DB, groupID int64, limit int) ([]CycleFunnel, error) {
+	query := `SELECT ` + cycleFunnelColumns + `
+	FROM cycle_funnel WHERE group_id = ? ORDER BY week_start DESC LIMIT ?`
+	return queryRows(ctx, db, query, scanCycleFunnel, groupID, limit)
+}
+
+// GetExperimentFunnels returns the funnels of every cycle run under the experiment
+func GetExperimentFunnels(ctx context.Context, db *sql.DB, experimentID int64) ([]CycleFunnel, error) {
+	query := `SELECT ` + cycleFunnelColumns + `
+	FROM cycle_funnel WHERE experiment_id = ? ORDER BY week_start, group_id`
+	return queryRows(ctx, db, query, scanCycleFunnel, experimentID)
+}
+
+// GetCycleFunnelsBetween returns the funnels of every group's pairing runs finished in [from, to)
+func GetCycleFunnelsBetween(ctx context.Context, db *sql.DB, from, to time.Time) ([]CycleFunnel, error) {
+	query := `SELECT ` + cycleFunnelColumns + `
+	FROM cycle_funnel WHERE created_at >= ? AND created_at < ? ORDER BY group_id, created_at`
+	return queryRows(ctx, db, query, scanCycleFunnel, formatTime(from), formatTime(to))
+}
+
+// GetFollowingCycleFunnels returns, for every cycle run under the experiment, the same group's funnel
+// of the week after, when there is one
+func GetFollowingCycleFunnels(ctx context.Context, db *sql.DB, experimentID int64) ([]CycleFunnel, error) {
+	query := `SELECT ` + cycleFunnelColumns + `
+	FROM cycle_funnel WHERE (group_id, week_start) IN (
+		SELECT group_id, date(week_start, '+7 days') FROM cycle_funnel WHERE experiment_id = ?
+	) ORDER BY week_start, group_id`
+	return queryRows(ctx, db, query, scanCycleFunnel, experimentID)
+}
@@ -0,0 +1,161 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// Group is a chat registered for Random Coffee; inactive groups get no quizzes or pairs
+type Group struct {
+	GroupID      int64
+	Title        string
+	Active       bool
+	RegisteredAt time.Time
+}
+
+// GroupConfig is a group's weekly quiz and pairing time; weekdays follow time.Weekday (0 = Sunday)
+type GroupConfig struct {
+	GroupID      int64
+	QuizWeekday  int
+	QuizHour     int
+	QuizMinute   int
+	PairsWeekday int
+	PairsHour    int
+	PairsMinute  int
+	Timezone     string
+}
+
+// Group operations
+
+// groupColumns is the column list read by scanGroup
+const groupColumns = `group_id, title, active, registered_at`
+
+func scanGroup(r rowScanner) (Group, error) {
+	var g Group
+	var registeredAtStr string
+	if err := r.Scan(&g.GroupID, &g.Title, &g.Active, &registeredAtStr); err != nil {
+		return g, err
+	}
+	g.RegisteredAt = parseTime(registeredAtStr)
+	return g, nil
+}
+
+// CreateGroup registers the group or, if it is already known, reactivates it and refreshes its title
+func CreateGroup(ctx context.Context, db *sql.DB, g Group) error {
+	query := `INSERT INTO chat_group (group_id, title, active, registered_at, updated_at)
+	VALUES (?, ?, 1, ?, ?)
+	ON CONFLICT (group_id) DO UPDATE
+	SET title = EXCLUDED.title, active = 1, updated_at = EXCLUDED.updated_at`
+
+	now := formatTime(time.Now())
+	_, err := db.ExecContext(ctx, query, g.GroupID, g.Title, now, now)
+	return err
+}
+
+// SeedGroup registers the group unless it is already known, keeping a deactivated group inactive
+func SeedGroup(ctx context.Context, db *sql.DB, groupID int64) error {
+	query := `INSERT OR IGNORE INTO chat_group (group_id, title, active, registered_at, updated_at)
+	VALUES (?, '', 1, ?, ?)`
+
+	now := formatTime(time.Now())
+	_, err := db.ExecContext(ctx, query, groupID, now, now)
+	return err
+}
+
+func GetGroup(ctx context.Context, db *sql.DB, groupID int64) (*Group, error) {
+	query := `SELECT ` + groupColumns + ` FROM chat_group WHERE group_id = ?`
+
+	g, err := scanGroup(db.QueryRowContext(ctx, query, groupID))
+	if err != nil {
+		return nil, err
+	}
+	return &g, nil
+}
+
+func GetActiveGroups(ctx context.Context, db *sql.DB) ([]Group, error) {
+	return queryRows(ctx, db, `SELECT `+groupColumns+` FROM chat_group
+	WHERE active = 1 ORDER BY registered_at, group_id`, scanGroup)
+}
+
+func GetAllGroups(ctx context.Context, db *sql.DB) ([]Group, error) {
+	return queryRows(ctx, db, `SELECT `+groupColumns+` FROM chat_group
+	ORDER BY active DESC, registered_at, group_id`, scanGroup)
+}
+
+// DeactivateGroup stops quizzes and pairs for the group; it reports whether the group was active
+func DeactivateGroup(ctx context.Context, db *sql.DB, groupID int64) (bool, error) {
+	query := `UPDATE chat_group SET active = 0, updated_at = ? WHERE group_id = ? AND active = 1`
+
+	res, err := db.ExecContext(ctx, query, formatTime(time.Now()), groupID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// Group config operations
+
+// GetGroupConfig returns the group's configuration, or sql.ErrNoRows if it has none
+func GetGroupConfig(ctx context.Context, db *sql.DB, groupID int64) (*GroupConfig, error) {
+	query := `SELECT group_id, quiz_weekday, quiz_hour, quiz_minute, pairs_weekday, pairs_hour, pairs_minute, timezone
+	FROM group_config WHERE group_id = ?`
+
+	var sc GroupConfig
+	err := db.QueryRowContext(ctx, query, groupID).Scan(&sc.GroupID, &sc.QuizWeekday, &sc.QuizHour, &sc.QuizMinute,
+		&sc.PairsWeekday, &sc.PairsHour, &sc.PairsMinute, &sc.Timezone)
+	if err != nil {
+		return nil, err
+	}
+	return &sc, nil
+}
+
+func UpsertGroupConfig(ctx context.Context, db *sql.DB, sc GroupConfig) error {
+	return upsertGroupConfig(ctx, db, sc)
+}
+
+func upsertGroupConfig(ctx context.Context, ex execer, sc GroupConfig) error {
+	query := `INSERT INTO group_config (group_id, quiz_weekday, quiz_hour, quiz_minute,
+		pairs_weekday, pairs_hour, pairs_minute, timezone, updated_at)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	ON CONFLICT (group_id) DO UPDATE
+	SET quiz_weekday = EXCLUDED.quiz_weekday, quiz_hour = EXCLUDED.quiz_hour, quiz_minute = EXCLUDED.quiz_minute,
+		pairs_weekday = EXCLUDED.pairs_weekday, pairs_hour = EXCLUDED.pairs_hour, pairs_minute = EXCLUDED.pairs_minute,
+		timezone = EXCLUDED.timezone, updated_at = EXCLUDED.updated_at`
+
+	_, err := ex.ExecContext(ctx, query, sc.GroupID, sc.QuizWeekday, sc.QuizHour, sc.QuizMinute,
+		sc.PairsWeekday, sc.PairsHour, sc.PairsMinute, sc.Timezone, formatTime(time.Now()))
+	return err
+}
+
+// Chat migration operations
+
+// RecordChatMigration remembers that Telegram replaced oldChatID with newChatID (group → supergroup)
+func RecordChatMigration(ctx context.Context, db *sql.DB, oldChatID, newChatID int64) error {
+	query := `INSERT INTO chat_migration (old_chat_id, new_chat_id, recorded_at) VALUES (?, ?, ?)
+	ON CONFLICT (old_chat_id) DO UPDATE SET new_chat_id = EXCLUDED.new_chat_id, recorded_at = EXCLUDED.recorded_at`
+	_, err := db.ExecContext(ctx, query, oldChatID, newChatID, formatTime(time.Now()))
+	return err
+}
+
+// GetChatMigrations returns every recorded migration as old chat ID → new chat ID
+func GetChatMigrations(ctx context.Context, db *sql.DB) (map[int64]int64, error) {
+	rows, err := queryRows(ctx, db, `SELECT old_chat_id, new_chat_id FROM chat_migration`, func(r rowScanner) ([2]int64, error) {
+		var row [2]int64
+		err := r.Scan(&row[0], &row[1])
+		return row, err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	migrations := make(map[int64]int64, len(rows))
+	for _, row := range rows {
+		migrations[row[0]] = row[1]
+	}
+	return migrations, nil
+}
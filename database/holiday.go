@@ -0,0 +1,50 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// Holiday is a custom range of days off of a group, both ends included, as YYYY-MM-DD
+type Holiday struct {
+	GroupID   int64
+	StartDate string
+	EndDate   string
+	CreatedBy int64
+	CreatedAt time.Time
+}
+
+// Holiday operations
+
+// AddHoliday stores a range; adding the same range again is a no-op. Overlapping ranges are kept as they are.
+func AddHoliday(ctx context.Context, db *sql.DB, h Holiday) error {
+	query := `INSERT OR IGNORE INTO holiday (group_id, start_date, end_date, created_by, created_at) VALUES (?, ?, ?, ?, ?)`
+	_, err := db.ExecContext(ctx, query, h.GroupID, h.StartDate, h.EndDate, h.CreatedBy, formatTime(h.CreatedAt))
+	return err
+}
+
+// DeleteHoliday removes a range and reports whether it existed
+func DeleteHoliday(ctx context.Context, db *sql.DB, groupID int64, startDate, endDate string) (bool, error) {
+	query := `DELETE FROM holiday WHERE group_id = ? AND start_date = ? AND end_date = ?`
+	res, err := db.ExecContext(ctx, query, groupID, startDate, endDate)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// GetHolidays returns the group's ranges ending on or after the given date, earliest first
+func GetHolidays(ctx context.Context, db *sql.DB, groupID int64, from string) ([]Holiday, error) {
+	query := `SELECT group_id, start_date, end_date, created_by, created_at
+	FROM holiday WHERE group_id = ? AND end_date >= ? ORDER BY start_date, end_date`
+
+	return queryRows(ctx, db, query, func(r rowScanner) (Holiday, error) {
+		var h Holiday
+		var createdAtStr string
+		err := r.Scan(&h.GroupID, &h.StartDate, &h.EndDate, &h.CreatedBy, &createdAtStr)
+		h.CreatedAt = parseTime(createdAtStr)
+		return h, err
+	}, groupID, from)
+}
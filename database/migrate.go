@@ -0,0 +1,65 @@
+package database
+
+import (
+	"bytes"
+	"database/sql"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+
+	"github.com/pressly/goose/v3"
+)
+
+// legacyMigrations were ported from Alembic without the "-- +goose Up" annotation that goose needs
+// to parse them. They are already applied on deployed databases, so the files stay as shipped and
+// the annotation is added when they are read.
+var legacyMigrations = map[string]bool{
+	"00001_init_schema.sql":                    true,
+	"00002_add_message_id_to_poll_mapping.sql": true,
+}
+
+// migrationsFS serves the migration files, annotating the legacy ones
+type migrationsFS struct {
+	fs.FS
+}
+
+func (m migrationsFS) Open(name string) (fs.File, error) {
+	f, err := m.FS.Open(name)
+	if err != nil || !legacyMigrations[path.Base(name)] {
+		return f, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Contains(body, []byte("-- +goose Up")) {
+		return &annotatedFile{Reader: bytes.NewReader(body), info: info}, nil
+	}
+	return &annotatedFile{Reader: bytes.NewReader(append([]byte("-- +goose Up\n"), body...)), info: info}, nil
+}
+
+// annotatedFile is a legacy migration read into memory with its annotation
+type annotatedFile struct {
+	*bytes.Reader
+	info fs.FileInfo
+}
+
+func (f *annotatedFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+func (f *annotatedFile) Close() error               { return nil }
+
+// Migrate applies the migrations in dir that the database doesn't have yet
+func Migrate(db *sql.DB, dir string) error {
+	if err := goose.SetDialect("sqlite3"); err != nil {
+		return err
+	}
+	goose.SetBaseFS(migrationsFS{FS: os.DirFS(dir)})
+	defer goose.SetBaseFS(nil)
+	return goose.Up(db, ".")
+}
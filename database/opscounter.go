@@ -0,0 +1,60 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// Ops counter operations
+
+// AddOpsCounts adds counts of operational events to the totals of their weeks in one transaction.
+// counts maps a week start to event names and how often each happened.
+func AddOpsCounts(ctx context.Context, db *sql.DB, counts map[string]map[string]int64) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	query := `INSERT INTO ops_counter (week_start, name, count) VALUES (?, ?, ?)
+	ON CONFLICT (week_start, name) DO UPDATE SET count = count + EXCLUDED.count`
+	for weekStart, names := range counts {
+		for name, n := range names {
+			if _, err := tx.ExecContext(ctx, query, weekStart, name, n); err != nil {
+				return err
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+// GetOpsCounts returns the week's totals by event name
+func GetOpsCounts(ctx context.Context, db *sql.DB, weekStart string) (map[string]int64, error) {
+	type row struct {
+		name  string
+		count int64
+	}
+	rows, err := queryRows(ctx, db, `SELECT name, count FROM ops_counter WHERE week_start = ?`, func(r rowScanner) (row, error) {
+		var c row
+		err := r.Scan(&c.name, &c.count)
+		return c, err
+	}, weekStart)
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make(map[string]int64, len(rows))
+	for _, c := range rows {
+		counts[c.name] = c.count
+	}
+	return counts, nil
+}
+
+// CountSentPolls returns how many quizzes were sent to any group in [from, to)
+func CountSentPolls(ctx context.Context, db *sql.DB, from, to time.Time) (int, error) {
+	var n int
+	query := `SELECT COUNT(*) FROM sent_poll WHERE sent_at >= ? AND sent_at < ?`
+	err := db.QueryRowContext(ctx, query, formatTime(from), formatTime(to)).Scan(&n)
+	return n, err
+}
@@ -0,0 +1,294 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type Pair struct {
+	ID        uuid.UUID
+	GroupID   int64
+	WeekStart string
+	User1ID   int64
+	User2ID   int64
+	User3ID   int64 // third member of a trio, zero for a regular pair
+	CreatedAt time.Time
+
+	Manual        bool // composed by an admin with /manual_pairs
+	IgnoreHistory bool // the meeting does not rule out the same couple in later weeks
+}
+
+// Members returns the IDs of everyone in the pair or trio
+func (p Pair) Members() []int64 {
+	if p.User3ID != 0 {
+		return []int64{p.User1ID, p.User2ID, p.User3ID}
+	}
+	return []int64{p.User1ID, p.User2ID}
+}
+
+// PairStats summarizes a group's pair history
+type PairStats struct {
+	Weeks         int    // weeks with at least one pair
+	DistinctUsers int    // everyone ever paired in the group
+	LastWeekStart string // most recent week with pairs, empty without history
+	LastWeekPairs int
+}
+
+// pairColumns is the column list read by scanPair
+const pairColumns = `id, group_id, week_start, user1_id, user2_id, user3_id, created_at, manual, ignore_history`
+
+func scanPair(r rowScanner) (Pair, error) {
+	var p Pair
+	var idStr, createdAtStr string
+	var user3ID sql.NullInt64
+	if err := r.Scan(&idStr, &p.GroupID, &p.WeekStart, &p.User1ID, &p.User2ID, &user3ID, &createdAtStr,
+		&p.Manual, &p.IgnoreHistory); err != nil {
+		return p, err
+	}
+	p.User3ID = user3ID.Int64
+	p.CreatedAt = parseTime(createdAtStr)
+
+	var err error
+	p.ID, err = parseStoredUUID("pair", "id",
+		fmt.Sprintf("group_id=%d week_start=%s user1_id=%d user2_id=%d", p.GroupID, p.WeekStart, p.User1ID, p.User2ID), idStr)
+	return p, err
+}
+
+// participantPairColumns are the two participants' columns selected by the candidate pair queries
+const participantPairColumns = `p1_id, p1_user_id, p1_username, p1_full_name, p1_created_at,
+	       p2_id, p2_user_id, p2_username, p2_full_name, p2_created_at`
+
+// participantPairScanner reads two participants of the group from one candidate pair row
+func participantPairScanner(groupID int64) func(rowScanner) ([2]Participant, error) {
+	return func(r rowScanner) ([2]Participant, error) {
+		var p1, p2 Participant
+		var p1IDStr, p2IDStr string
+		var p1CreatedAtStr, p2CreatedAtStr string
+		p1.GroupID = groupID
+		p2.GroupID = groupID
+
+		if err := r.Scan(&p1IDStr, &p1.UserID, &p1.Username, &p1.FullName, &p1CreatedAtStr,
+			&p2IDStr, &p2.UserID, &p2.Username, &p2.FullName, &p2CreatedAtStr); err != nil {
+			return [2]Participant{}, err
+		}
+
+		p1.CreatedAt = parseTime(p1CreatedAtStr)
+		p2.CreatedAt = parseTime(p2CreatedAtStr)
+
+		var err error
+		if p1.ID, err = parseStoredUUID("participant", "id", fmt.Sprintf("group_id=%d user_id=%d", groupID, p1.UserID), p1IDStr); err != nil {
+			return [2]Participant{}, err
+		}
+		if p2.ID, err = parseStoredUUID("participant", "id", fmt.Sprintf("group_id=%d user_id=%d", groupID, p2.UserID), p2IDStr); err != nil {
+			return [2]Participant{}, err
+		}
+		return [2]Participant{p1, p2}, nil
+	}
+}
+
+func CreatePairs(ctx context.Context, db *sql.DB, pairs []Pair) error {
+	if len(pairs) == 0 {
+		return nil
+	}
+
+	query := `INSERT INTO pair (` + pairColumns + `)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+	for _, p := range pairs {
+		if _, err := db.ExecContext(ctx, query, p.ID.String(), p.GroupID, p.WeekStart, p.User1ID, p.User2ID,
+			nullableID(p.User3ID), formatTime(p.CreatedAt), p.Manual, p.IgnoreHistory); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetAvailablePairs returns every two participants of the group who have never met there, in random order.
+// Meetings stored with IgnoreHistory count only in their own week, weekStart being the current one.
+func GetAvailablePairs(ctx context.Context, db *sql.DB, groupID int64, weekStart string) ([][2]Participant, error) {
+	query := `
+	WITH available_users AS (
+		SELECT
+			p1.id as p1_id, p1.user_id as p1_user_id, p1.username as p1_username,
+			p1.full_name as p1_full_name, p1.created_at as p1_created_at,
+			p2.id as p2_id, p2.user_id as p2_user_id, p2.username as p2_username,
+			p2.full_name as p2_full_name, p2.created_at as p2_created_at
+		FROM participant p1
+		CROSS JOIN participant p2
+		WHERE p1.group_id = ? AND p2.group_id = ? AND p1.user_id < p2.user_id
+	)
+	SELECT ` + participantPairColumns + `
+	FROM available_users au
+	WHERE NOT EXISTS (
+		SELECT 1 FROM pair pr
+		WHERE pr.group_id = ? AND (pr.ignore_history = 0 OR pr.week_start = ?)
+		  AND au.p1_user_id IN (pr.user1_id, pr.user2_id, pr.user3_id)
+		  AND au.p2_user_id IN (pr.user1_id, pr.user2_id, pr.user3_id)
+	)
+	ORDER BY RANDOM()`
+
+	return queryRows(ctx, db, query, participantPairScanner(groupID), groupID, groupID, groupID, weekStart)
+}
+
+// GetAvailablePairsAllowingRepeats returns every two participants of the group, including those who
+// have already met: pairs that never met first, then by their latest meeting, oldest first. Meetings stored
+// with IgnoreHistory count only in their own week, as in GetAvailablePairs.
+func GetAvailablePairsAllowingRepeats(ctx context.Context, db *sql.DB, groupID int64, weekStart string) ([][2]Participant, error) {
+	query := `
+	WITH candidates AS (
+		SELECT
+			p1.id as p1_id, p1.user_id as p1_user_id, p1.username as p1_username,
+			p1.full_name as p1_full_name, p1.created_at as p1_created_at,
+			p2.id as p2_id, p2.user_id as p2_user_id, p2.username as p2_username,
+			p2.full_name as p2_full_name, p2.created_at as p2_created_at,
+			(SELECT MAX(pr.created_at) FROM pair pr
+			 WHERE pr.group_id = p1.group_id AND (pr.ignore_history = 0 OR pr.week_start = ?)
+			   AND p1.user_id IN (pr.user1_id, pr.user2_id, pr.user3_id)
+			   AND p2.user_id IN (pr.user1_id, pr.user2_id, pr.user3_id)) as last_met
+		FROM participant p1
+		CROSS JOIN participant p2
+		WHERE p1.group_id = ? AND p2.group_id = ? AND p1.user_id < p2.user_id
+	)
+	SELECT ` + participantPairColumns + `
+	FROM candidates
+	ORDER BY last_met IS NOT NULL, last_met, RANDOM()`
+
+	return queryRows(ctx, db, query, participantPairScanner(groupID), weekStart, groupID, groupID)
+}
+
+func GetPairHistory(ctx context.Context, db *sql.DB, groupID int64) ([]Pair, error) {
+	query := `SELECT ` + pairColumns + `
+	FROM pair WHERE group_id = ? ORDER BY week_start, created_at`
+	return queryRows(ctx, db, query, scanPair, groupID)
+}
+
+func GetPairsByUser(ctx context.Context, db *sql.DB, userID int64) ([]Pair, error) {
+	query := `SELECT ` + pairColumns + `
+	FROM pair WHERE ? IN (user1_id, user2_id, user3_id) ORDER BY week_start, group_id`
+	return queryRows(ctx, db, query, scanPair, userID)
+}
+
+// GetPairedUserIDs returns every user who has ever been paired in the group
+func GetPairedUserIDs(ctx context.Context, db *sql.DB, groupID int64) (map[int64]bool, error) {
+	query := `SELECT user1_id FROM pair WHERE group_id = ?
+	UNION SELECT user2_id FROM pair WHERE group_id = ?
+	UNION SELECT user3_id FROM pair WHERE group_id = ? AND user3_id IS NOT NULL`
+
+	userIDs, err := queryRows(ctx, db, query, scanInt64, groupID, groupID, groupID)
+	if err != nil {
+		return nil, err
+	}
+
+	paired := make(map[int64]bool, len(userIDs))
+	for _, id := range userIDs {
+		paired[id] = true
+	}
+	return paired, nil
+}
+
+// GetOtherGroupMatches returns, for each of the given users paired in the week in any group other than
+// excludeGroupID, the IDs of those groups
+func GetOtherGroupMatches(ctx context.Context, db *sql.DB, weekStart string, excludeGroupID int64, userIDs []int64) (map[int64][]int64, error) {
+	matches := make(map[int64][]int64)
+	if len(userIDs) == 0 {
+		return matches, nil
+	}
+
+	placeholders, idArgs := inPlaceholders(userIDs)
+	query := `SELECT DISTINCT user_id, group_id FROM (
+		SELECT user1_id AS user_id, group_id FROM pair WHERE week_start = ? AND group_id != ?
+		UNION ALL
+		SELECT user2_id AS user_id, group_id FROM pair WHERE week_start = ? AND group_id != ?
+		UNION ALL
+		SELECT user3_id AS user_id, group_id FROM pair WHERE week_start = ? AND group_id != ? AND user3_id IS NOT NULL
+	) WHERE user_id IN (` + placeholders + `) ORDER BY user_id, group_id`
+
+	args := append([]any{weekStart, excludeGroupID, weekStart, excludeGroupID, weekStart, excludeGroupID}, idArgs...)
+	rows, err := queryRows(ctx, db, query, func(r rowScanner) ([2]int64, error) {
+		var userID, groupID int64
+		err := r.Scan(&userID, &groupID)
+		return [2]int64{userID, groupID}, err
+	}, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, row := range rows {
+		matches[row[0]] = append(matches[row[0]], row[1])
+	}
+	return matches, nil
+}
+
+// CopyPairs inserts pairs into the target group in one transaction, skipping
+// pairs the target already has, and returns the number of inserted rows
+func CopyPairs(ctx context.Context, db *sql.DB, targetGroupID int64, pairs []Pair) (int64, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	query := `INSERT OR IGNORE INTO pair (` + pairColumns + `)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+	var copied int64
+	for _, p := range pairs {
+		res, err := tx.ExecContext(ctx, query, uuid.New().String(), targetGroupID, p.WeekStart, p.User1ID, p.User2ID,
+			nullableID(p.User3ID), formatTime(p.CreatedAt), p.Manual, p.IgnoreHistory)
+		if err != nil {
+			return 0, err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return 0, err
+		}
+		copied += n
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return copied, nil
+}
+
+// GetPairStats aggregates the group's pair history
+func GetPairStats(ctx context.Context, db *sql.DB, groupID int64) (PairStats, error) {
+	var stats PairStats
+
+	query := `SELECT COUNT(DISTINCT week_start), COALESCE(MAX(week_start), '') FROM pair WHERE group_id = ?`
+	if err := db.QueryRowContext(ctx, query, groupID).Scan(&stats.Weeks, &stats.LastWeekStart); err != nil {
+		return stats, err
+	}
+	if stats.Weeks == 0 {
+		return stats, nil
+	}
+
+	query = `SELECT COUNT(*) FROM pair WHERE group_id = ? AND week_start = ?`
+	if err := db.QueryRowContext(ctx, query, groupID, stats.LastWeekStart).Scan(&stats.LastWeekPairs); err != nil {
+		return stats, err
+	}
+
+	query = `SELECT COUNT(*) FROM (
+		SELECT user1_id FROM pair WHERE group_id = ?
+		UNION SELECT user2_id FROM pair WHERE group_id = ?
+		UNION SELECT user3_id FROM pair WHERE group_id = ? AND user3_id IS NOT NULL
+	)`
+	if err := db.QueryRowContext(ctx, query, groupID, groupID, groupID).Scan(&stats.DistinctUsers); err != nil {
+		return stats, err
+	}
+
+	return stats, nil
+}
+
+// HasPairsSince reports whether pairs were created in the group after the given time
+func HasPairsSince(ctx context.Context, db *sql.DB, groupID int64, since time.Time) (bool, error) {
+	query := `SELECT EXISTS (SELECT 1 FROM pair WHERE group_id = ? AND created_at > ?)`
+
+	var exists bool
+	err := db.QueryRowContext(ctx, query, groupID, formatTime(since)).Scan(&exists)
+	return exists, err
+}
@@ -0,0 +1,74 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type Participant struct {
+	ID        uuid.UUID
+	GroupID   int64
+	UserID    int64
+	Username  string
+	FullName  string
+	CreatedAt time.Time
+}
+
+// participantColumns is the column list read by scanParticipant
+const participantColumns = `id, group_id, user_id, username, full_name, created_at`
+
+func scanParticipant(r rowScanner) (Participant, error) {
+	var p Participant
+	var idStr, createdAtStr string
+	if err := r.Scan(&idStr, &p.GroupID, &p.UserID, &p.Username, &p.FullName, &createdAtStr); err != nil {
+		return p, err
+	}
+	p.CreatedAt = parseTime(createdAtStr)
+
+	var err error
+	p.ID, err = parseStoredUUID("participant", "id", fmt.Sprintf("group_id=%d user_id=%d", p.GroupID, p.UserID), idStr)
+	return p, err
+}
+
+func CreateOrUpdateParticipant(ctx context.Context, db *sql.DB, p Participant) error {
+	query := `INSERT INTO participant (id, group_id, user_id, username, full_name, created_at)
+	VALUES (?, ?, ?, ?, ?, ?)
+	ON CONFLICT (group_id, user_id) DO UPDATE
+	SET username = EXCLUDED.username, full_name = EXCLUDED.full_name`
+
+	_, err := execRetryingBusy(ctx, db, query, p.ID.String(), p.GroupID, p.UserID, p.Username, p.FullName, formatTime(p.CreatedAt))
+	return err
+}
+
+func GetAllParticipants(ctx context.Context, db *sql.DB, groupID int64) ([]Participant, error) {
+	query := `SELECT ` + participantColumns + ` FROM participant WHERE group_id = ?`
+	return queryRows(ctx, db, query, scanParticipant, groupID)
+}
+
+func GetParticipationsByUser(ctx context.Context, db *sql.DB, userID int64) ([]Participant, error) {
+	query := `SELECT ` + participantColumns + ` FROM participant WHERE user_id = ? ORDER BY group_id`
+	return queryRows(ctx, db, query, scanParticipant, userID)
+}
+
+// CountParticipants returns how many users signed up for the group's open cycle
+func CountParticipants(ctx context.Context, db *sql.DB, groupID int64) (int, error) {
+	var n int
+	err := db.QueryRowContext(ctx, `SELECT COUNT(*) FROM participant WHERE group_id = ?`, groupID).Scan(&n)
+	return n, err
+}
+
+func DeleteParticipant(ctx context.Context, db *sql.DB, groupID, userID int64) error {
+	query := `DELETE FROM participant WHERE group_id = ? AND user_id = ?`
+	_, err := execRetryingBusy(ctx, db, query, groupID, userID)
+	return err
+}
+
+func ClearAllParticipants(ctx context.Context, db *sql.DB, groupID int64) error {
+	query := `DELETE FROM participant WHERE group_id = ?`
+	_, err := db.ExecContext(ctx, query, groupID)
+	return err
+}
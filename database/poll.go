@@ -0,0 +1,306 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Kinds of sign-up message a poll mapping can point to
+const (
+	SignupPoll     = "poll"
+	SignupKeyboard = "keyboard" // inline buttons, for groups where polls are forbidden
+)
+
+// PollMapping is the group's current sign-up message. For a keyboard message PollID is
+// a bot-made ID, since there is no Telegram poll behind it.
+type PollMapping struct {
+	PollID    string
+	GroupID   int64
+	MessageID int64
+	Kind      string
+
+	// Countdown companion message, zero when the group has no countdown
+	CountdownMessageID int64
+	CountdownClosesAt  time.Time
+	CountdownEditedAt  time.Time
+}
+
+// SentPoll records a poll the bot sent, used to restore a lost poll mapping
+type SentPoll struct {
+	PollID    string
+	GroupID   int64
+	MessageID int64
+	SentAt    time.Time
+
+	// MemberCount is the chat's size when the poll was sent, zero if unknown
+	MemberCount int
+}
+
+// PollReconciliation compares a closed poll's "yes" votes with the sign-ups stored for it
+type PollReconciliation struct {
+	PollID      string
+	GroupID     int64
+	WeekStart   string
+	PollYes     int
+	PollNo      int
+	StoredYes   int
+	TotalVoters int
+	CreatedAt   time.Time
+}
+
+// Diff is positive when more sign-ups are stored than the poll shows, negative when votes were missed
+func (r PollReconciliation) Diff() int {
+	return r.StoredYes - r.PollYes
+}
+
+// SlowStartCheck is a pending check of how many people signed up some hours after a quiz
+type SlowStartCheck struct {
+	PollID    string
+	GroupID   int64
+	MessageID int64
+	DueAt     time.Time
+}
+
+// UnpinFailure is a poll message that is still pinned because unpinning it failed
+type UnpinFailure struct {
+	GroupID   int64
+	MessageID int64
+	WeekStart string
+	Attempts  int
+	FailedAt  time.Time
+}
+
+// Poll mapping operations
+
+// pollMappingColumns is the column list read by scanPollMapping
+const pollMappingColumns = `poll_id, group_id, message_id, kind, countdown_message_id, countdown_closes_at, countdown_edited_at`
+
+func scanPollMapping(r rowScanner) (PollMapping, error) {
+	var pm PollMapping
+	var closesAtStr, editedAtStr string
+	if err := r.Scan(&pm.PollID, &pm.GroupID, &pm.MessageID, &pm.Kind, &pm.CountdownMessageID, &closesAtStr, &editedAtStr); err != nil {
+		return pm, err
+	}
+	pm.CountdownClosesAt = parseTime(closesAtStr)
+	pm.CountdownEditedAt = parseTime(editedAtStr)
+	return pm, nil
+}
+
+// CreatePollMapping stores the group's sign-up message; an empty Kind means a poll
+func CreatePollMapping(ctx context.Context, db *sql.DB, pm PollMapping) error {
+	if pm.Kind == "" {
+		pm.Kind = SignupPoll
+	}
+	query := `INSERT INTO poll_mapping (poll_id, group_id, message_id, kind) VALUES (?, ?, ?, ?)`
+	_, err := db.ExecContext(ctx, query, pm.PollID, pm.GroupID, pm.MessageID, pm.Kind)
+	return err
+}
+
+func GetGroupIDByPollID(ctx context.Context, db *sql.DB, pollID string) (int64, error) {
+	query := `SELECT group_id FROM poll_mapping WHERE poll_id = ?`
+
+	var groupID int64
+	err := db.QueryRowContext(ctx, query, pollID).Scan(&groupID)
+	if err != nil {
+		return 0, fmt.Errorf("poll not found: %w", err)
+	}
+	return groupID, nil
+}
+
+func GetPollMappingByGroupID(ctx context.Context, db *sql.DB, groupID int64) (*PollMapping, error) {
+	query := `SELECT ` + pollMappingColumns + `
+	FROM poll_mapping WHERE group_id = ? ORDER BY rowid DESC LIMIT 1`
+
+	pm, err := scanPollMapping(db.QueryRowContext(ctx, query, groupID))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get poll mapping: %w", err)
+	}
+	return &pm, nil
+}
+
+// GetActiveCountdowns returns poll mappings whose countdown message is still running
+func GetActiveCountdowns(ctx context.Context, db *sql.DB) ([]PollMapping, error) {
+	query := `SELECT ` + pollMappingColumns + `
+	FROM poll_mapping WHERE countdown_message_id != 0`
+	return queryRows(ctx, db, query, scanPollMapping)
+}
+
+// SetPollCountdown attaches a countdown message to a poll; closesAt is when pairs are due
+func SetPollCountdown(ctx context.Context, db *sql.DB, pollID string, messageID int64, closesAt, editedAt time.Time) error {
+	query := `UPDATE poll_mapping SET countdown_message_id = ?, countdown_closes_at = ?, countdown_edited_at = ? WHERE poll_id = ?`
+	_, err := db.ExecContext(ctx, query, messageID, formatTime(closesAt), formatTime(editedAt), pollID)
+	return err
+}
+
+func TouchPollCountdown(ctx context.Context, db *sql.DB, pollID string, editedAt time.Time) error {
+	query := `UPDATE poll_mapping SET countdown_edited_at = ? WHERE poll_id = ?`
+	_, err := db.ExecContext(ctx, query, formatTime(editedAt), pollID)
+	return err
+}
+
+// ClearPollCountdown stops the countdown of a poll
+func ClearPollCountdown(ctx context.Context, db *sql.DB, pollID string) error {
+	query := `UPDATE poll_mapping SET countdown_message_id = 0 WHERE poll_id = ?`
+	_, err := db.ExecContext(ctx, query, pollID)
+	return err
+}
+
+func DeletePollMapping(ctx context.Context, db *sql.DB, groupID int64) error {
+	query := `DELETE FROM poll_mapping WHERE group_id = ?`
+	_, err := db.ExecContext(ctx, query, groupID)
+	return err
+}
+
+// Sent poll operations
+
+// sentPollColumns is the column list read by scanSentPoll
+const sentPollColumns = `poll_id, group_id, message_id, sent_at, member_count`
+
+func scanSentPoll(r rowScanner) (SentPoll, error) {
+	var sp SentPoll
+	var sentAtStr string
+	if err := r.Scan(&sp.PollID, &sp.GroupID, &sp.MessageID, &sentAtStr, &sp.MemberCount); err != nil {
+		return sp, err
+	}
+	sp.SentAt = parseTime(sentAtStr)
+	return sp, nil
+}
+
+func RecordSentPoll(ctx context.Context, db *sql.DB, sp SentPoll) error {
+	query := `INSERT OR IGNORE INTO sent_poll (poll_id, group_id, message_id, sent_at, member_count) VALUES (?, ?, ?, ?, ?)`
+	_, err := db.ExecContext(ctx, query, sp.PollID, sp.GroupID, sp.MessageID, formatTime(sp.SentAt), sp.MemberCount)
+	return err
+}
+
+func GetSentPoll(ctx context.Context, db *sql.DB, pollID string) (*SentPoll, error) {
+	query := `SELECT ` + sentPollColumns + ` FROM sent_poll WHERE poll_id = ?`
+
+	sp, err := scanSentPoll(db.QueryRowContext(ctx, query, pollID))
+	if err != nil {
+		return nil, err
+	}
+	return &sp, nil
+}
+
+// GetLatestSentPoll returns the most recent poll sent to the group
+func GetLatestSentPoll(ctx context.Context, db *sql.DB, groupID int64) (*SentPoll, error) {
+	query := `SELECT ` + sentPollColumns + ` FROM sent_poll
+	WHERE group_id = ? ORDER BY sent_at DESC LIMIT 1`
+
+	sp, err := scanSentPoll(db.QueryRowContext(ctx, query, groupID))
+	if err != nil {
+		return nil, err
+	}
+	return &sp, nil
+}
+
+// Poll reconciliation operations
+
+// pollReconciliationColumns is the column list read by scanPollReconciliation
+const pollReconciliationColumns = `poll_id, group_id, week_start, poll_yes, poll_no, stored_yes, total_voters, created_at`
+
+func scanPollReconciliation(row rowScanner) (PollReconciliation, error) {
+	var r PollReconciliation
+	var createdAtStr string
+	err := row.Scan(&r.PollID, &r.GroupID, &r.WeekStart, &r.PollYes, &r.PollNo, &r.StoredYes, &r.TotalVoters, &createdAtStr)
+	r.CreatedAt = parseTime(createdAtStr)
+	return r, err
+}
+
+func SavePollReconciliation(ctx context.Context, db *sql.DB, r PollReconciliation) error {
+	query := `INSERT OR REPLACE INTO poll_reconciliation
+	(poll_id, group_id, week_start, poll_yes, poll_no, stored_yes, total_voters, created_at)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
+	_, err := db.ExecContext(ctx, query, r.PollID, r.GroupID, r.WeekStart, r.PollYes, r.PollNo, r.StoredYes, r.TotalVoters, formatTime(r.CreatedAt))
+	return err
+}
+
+// GetRecentPollReconciliations returns the group's latest reconciliations, newest first
+func GetRecentPollReconciliations(ctx context.Context, db *sql.DB, groupID int64, limit int) ([]PollReconciliation, error) {
+	query := `SELECT ` + pollReconciliationColumns + `
+	FROM poll_reconciliation WHERE group_id = ? ORDER BY created_at DESC LIMIT ?`
+	return queryRows(ctx, db, query, scanPollReconciliation, groupID, limit)
+}
+
+// GetPollReconciliationsBetween returns every group's reconciliations made in [from, to), oldest first
+func GetPollReconciliationsBetween(ctx context.Context, db *sql.DB, from, to time.Time) ([]PollReconciliation, error) {
+	query := `SELECT ` + pollReconciliationColumns + `
+	FROM poll_reconciliation WHERE created_at >= ? AND created_at < ? ORDER BY created_at`
+	return queryRows(ctx, db, query, scanPollReconciliation, formatTime(from), formatTime(to))
+}
+
+// Slow start check operations
+
+func CreateSlowStartCheck(ctx context.Context, db *sql.DB, c SlowStartCheck) error {
+	query := `INSERT OR REPLACE INTO slow_start_check (poll_id, group_id, message_id, due_at) VALUES (?, ?, ?, ?)`
+	_, err := db.ExecContext(ctx, query, c.PollID, c.GroupID, c.MessageID, formatTime(c.DueAt))
+	return err
+}
+
+// GetDueSlowStartChecks returns checks due at or before now
+func GetDueSlowStartChecks(ctx context.Context, db *sql.DB, now time.Time) ([]SlowStartCheck, error) {
+	query := `SELECT poll_id, group_id, message_id, due_at FROM slow_start_check WHERE due_at <= ? ORDER BY due_at`
+
+	return queryRows(ctx, db, query, func(r rowScanner) (SlowStartCheck, error) {
+		var c SlowStartCheck
+		var dueAtStr string
+		err := r.Scan(&c.PollID, &c.GroupID, &c.MessageID, &dueAtStr)
+		c.DueAt = parseTime(dueAtStr)
+		return c, err
+	}, formatTime(now))
+}
+
+// DeleteSlowStartCheck cancels the poll's check; it is a no-op when there is none
+func DeleteSlowStartCheck(ctx context.Context, db *sql.DB, pollID string) error {
+	query := `DELETE FROM slow_start_check WHERE poll_id = ?`
+	_, err := db.ExecContext(ctx, query, pollID)
+	return err
+}
+
+// DeleteGroupSlowStartChecks cancels every pending check of the group
+func DeleteGroupSlowStartChecks(ctx context.Context, db *sql.DB, groupID int64) error {
+	_, err := db.ExecContext(ctx, `DELETE FROM slow_start_check WHERE group_id = ?`, groupID)
+	return err
+}
+
+// Unpin backlog operations
+
+// AddUnpinFailure records a failed unpin, counting repeated failures of the same message
+func AddUnpinFailure(ctx context.Context, db *sql.DB, f UnpinFailure) error {
+	query := `INSERT INTO unpin_backlog (group_id, message_id, week_start, attempts, failed_at)
+	VALUES (?, ?, ?, 1, ?)
+	ON CONFLICT(group_id, message_id) DO UPDATE SET attempts = attempts + 1, failed_at = excluded.failed_at`
+	_, err := db.ExecContext(ctx, query, f.GroupID, f.MessageID, f.WeekStart, formatTime(f.FailedAt))
+	return err
+}
+
+// GetUnpinBacklog returns the group's messages still waiting to be unpinned, oldest first
+func GetUnpinBacklog(ctx context.Context, db *sql.DB, groupID int64) ([]UnpinFailure, error) {
+	query := `SELECT group_id, message_id, week_start, attempts, failed_at
+	FROM unpin_backlog WHERE group_id = ? ORDER BY week_start, message_id`
+
+	return queryRows(ctx, db, query, func(r rowScanner) (UnpinFailure, error) {
+		var f UnpinFailure
+		var failedAtStr string
+		err := r.Scan(&f.GroupID, &f.MessageID, &f.WeekStart, &f.Attempts, &failedAtStr)
+		f.FailedAt = parseTime(failedAtStr)
+		return f, err
+	}, groupID)
+}
+
+func DeleteUnpinFailure(ctx context.Context, db *sql.DB, groupID, messageID int64) error {
+	query := `DELETE FROM unpin_backlog WHERE group_id = ? AND message_id = ?`
+	_, err := db.ExecContext(ctx, query, groupID, messageID)
+	return err
+}
+
+func ClearUnpinBacklog(ctx context.Context, db *sql.DB, groupID int64) error {
+	query := `DELETE FROM unpin_backlog WHERE group_id = ?`
+	_, err := db.ExecContext(ctx, query, groupID)
+	return err
+}
@@ -0,0 +1,112 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+const (
+	// readerConns bounds the read-only pool; exports are rare, a couple of them at once is plenty
+	readerConns = 2
+
+	// PairChunkSize is how many pairs a chunked history read fetches per query
+	PairChunkSize = 500
+)
+
+// EnableWAL switches the database to write-ahead logging, so readers on other connections
+// don't block the writer and the writer doesn't block them. The mode persists in the file.
+func EnableWAL(ctx context.Context, db *sql.DB) error {
+	var mode string
+	if err := db.QueryRowContext(ctx, `PRAGMA journal_mode=WAL`).Scan(&mode); err != nil {
+		return err
+	}
+	if !strings.EqualFold(mode, "wal") {
+		return fmt.Errorf("journal mode is %q, WAL not available", mode)
+	}
+	return nil
+}
+
+// OpenReader opens a separate read-only pool on the database file for heavy reads: exports, stats
+// and snapshots. Every connection runs with query_only, so a write through it fails instead of
+// contending with vote writes. An in-memory database has no file to share and can't get a reader.
+func OpenReader(ctx context.Context, path string) (*sql.DB, error) {
+	if strings.Contains(path, ":memory:") {
+		return nil, fmt.Errorf("in-memory database %q can't be shared with a reader pool", path)
+	}
+
+	sep := "?"
+	if strings.Contains(path, "?") {
+		sep = "&"
+	}
+	db, err := sql.Open("sqlite", path+sep+"_pragma=query_only(1)&_pragma=busy_timeout(5000)")
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(readerConns)
+
+	if err := db.PingContext(ctx); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// ForEachPairChunk walks the group's pair history in week order, PairChunkSize rows per query.
+// Each chunk is a short read of its own, so a long export never holds one statement open, and
+// the walk stops between chunks once ctx is cancelled.
+func ForEachPairChunk(ctx context.Context, db *sql.DB, groupID int64, fn func([]Pair) error) error {
+	// Within a week rows follow insertion order, which is the order they were created in
+	query := `SELECT rowid, ` + pairColumns + `
+	FROM pair WHERE group_id = ? AND (week_start, rowid) > (?, ?)
+	ORDER BY week_start, rowid LIMIT ?`
+
+	type keyedPair struct {
+		rowID int64
+		pair  Pair
+	}
+	scan := func(r rowScanner) (keyedPair, error) {
+		var kp keyedPair
+		var err error
+		kp.pair, err = scanPair(keyScanner{r, &kp.rowID})
+		return kp, err
+	}
+
+	lastWeek, lastRowID := "", int64(0)
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		rows, err := queryRows(ctx, db, query, scan, groupID, lastWeek, lastRowID, PairChunkSize)
+		if err != nil {
+			return err
+		}
+		if len(rows) == 0 {
+			return nil
+		}
+
+		chunk := make([]Pair, 0, len(rows))
+		for _, kp := range rows {
+			chunk = append(chunk, kp.pair)
+		}
+		if err := fn(chunk); err != nil {
+			return err
+		}
+		if len(rows) < PairChunkSize {
+			return nil
+		}
+		last := rows[len(rows)-1]
+		lastWeek, lastRowID = last.pair.WeekStart, last.rowID
+	}
+}
+
+// keyScanner reads a leading key column into key and passes the rest of the row on
+type keyScanner struct {
+	r   rowScanner
+	key *int64
+}
+
+func (k keyScanner) Scan(dest ...any) error {
+	return k.r.Scan(append([]any{k.key}, dest...)...)
+}
@@ -0,0 +1,132 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// openWALPair opens a migrated database in WAL mode and a reader pool on the same file, the way the bot does
+func openWALPair(t *testing.T) (*sql.DB, *sql.DB) {
+	t.Helper()
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "bot.db")
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatalf("sql.Open: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := Migrate(db, "../migrations"); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+	if err := EnableWAL(ctx, db); err != nil {
+		t.Fatalf("EnableWAL: %v", err)
+	}
+	reader, err := OpenReader(ctx, path)
+	if err != nil {
+		t.Fatalf("OpenReader: %v", err)
+	}
+	t.Cleanup(func() { reader.Close() })
+	return db, reader
+}
+
+func TestReaderIsReadOnly(t *testing.T) {
+	_, reader := openWALPair(t)
+	err := CreateOrUpdateParticipant(context.Background(), reader, Participant{ID: uuid.New(), GroupID: testGroupID, UserID: 1, CreatedAt: time.Now()})
+	if err == nil {
+		t.Fatal("write through the reader pool succeeded")
+	}
+	if _, err := OpenReader(context.Background(), ":memory:"); err == nil {
+		t.Fatal("OpenReader accepted an in-memory database")
+	}
+}
+
+func TestReaderUnderWriteLoad(t *testing.T) {
+	if testing.Short() {
+		t.Skip("stress test")
+	}
+	db, reader := openWALPair(t)
+	ctx := context.Background()
+
+	// A history long enough for a chunked export to take several queries
+	const historyPairs = 3*PairChunkSize + 17
+	pairs := make([]Pair, 0, historyPairs)
+	for i := range historyPairs {
+		week := time.Date(2020, 1, 6, 0, 0, 0, 0, time.UTC).AddDate(0, 0, 7*(i/20)).Format("2006-01-02")
+		pairs = append(pairs, Pair{ID: uuid.New(), GroupID: testGroupID, WeekStart: week, User1ID: int64(1000 + i), User2ID: int64(5000 + i), CreatedAt: time.Now()})
+	}
+	if err := CreatePairs(ctx, db, pairs); err != nil {
+		t.Fatalf("CreatePairs: %v", err)
+	}
+
+	const votes = 300
+	done := make(chan struct{})
+	errs := make(chan error, 16)
+	var readers sync.WaitGroup
+	exports := make([]int, 2)
+	for r := range exports {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				n := 0
+				err := ForEachPairChunk(ctx, reader, testGroupID, func(chunk []Pair) error {
+					n += len(chunk)
+					return nil
+				})
+				if err != nil {
+					errs <- fmt.Errorf("export: %w", err)
+					return
+				}
+				if n != historyPairs {
+					errs <- fmt.Errorf("export read %d pairs, want %d", n, historyPairs)
+					return
+				}
+				exports[r]++
+			}
+		}()
+	}
+
+	// The vote storm: each sign-up is visible to the reader pool as soon as it is written
+	var slowest time.Duration
+	for i := range votes {
+		start := time.Now()
+		p := Participant{ID: uuid.New(), GroupID: testGroupID, UserID: int64(i + 1), Username: "user", CreatedAt: time.Now()}
+		if err := CreateOrUpdateParticipant(ctx, db, p); err != nil {
+			close(done)
+			t.Fatalf("vote %d: %v (busy: %v)", i, err, IsBusy(err))
+		}
+		slowest = max(slowest, time.Since(start))
+
+		n, err := CountParticipants(ctx, reader, testGroupID)
+		if err != nil || n != i+1 {
+			close(done)
+			t.Fatalf("reader counts %d participants (%v) after vote %d, want the latest write", n, err, i+1)
+		}
+	}
+	close(done)
+	readers.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatalf("%v (busy: %v)", err, IsBusy(err))
+	}
+
+	if exports[0] == 0 || exports[1] == 0 {
+		t.Fatalf("exports finished %v times, want both to complete during the votes", exports)
+	}
+	// Readers never take the write lock, so a vote only waits for the disk
+	if slowest > time.Second {
+		t.Fatalf("slowest vote took %s while exports ran", slowest)
+	}
+}
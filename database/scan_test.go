@@ -0,0 +1,307 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	otherGroupID  = int64(-200)
+	testWeek      = "2026-03-02"
+	testSetting   = "poll_day"
+	testProfile   = "weekly"
+	notAUUID      = "not-a-uuid"
+	testCreatedBy = int64(42)
+)
+
+// scanFixture is what seedScanFixture stored, to compare the readers' results with
+type scanFixture struct {
+	participants map[int64]uuid.UUID // user ID to participant ID in testGroupID
+	pair         Pair                // 1 and 2 in testGroupID
+	trio         Pair                // 1, 3 and 2 in otherGroupID
+	experimentID int64
+}
+
+// seedScanFixture signs up users 1-3 in testGroupID and user 1 in otherGroupID, pairs 1 with 2 in
+// testGroupID and 1, 3 and 2 in otherGroupID in the same week, and starts an experiment and a settings profile
+func seedScanFixture(t *testing.T, db *sql.DB) scanFixture {
+	t.Helper()
+	ctx := context.Background()
+	created := time.Date(2026, 3, 2, 10, 0, 0, 0, time.UTC)
+
+	f := scanFixture{participants: make(map[int64]uuid.UUID)}
+	for _, userID := range []int64{1, 2, 3} {
+		p := Participant{ID: uuid.New(), GroupID: testGroupID, UserID: userID, Username: "user" + strconv.FormatInt(userID, 10), CreatedAt: created}
+		if err := CreateOrUpdateParticipant(ctx, db, p); err != nil {
+			t.Fatalf("CreateOrUpdateParticipant: %v", err)
+		}
+		f.participants[userID] = p.ID
+	}
+	if err := CreateOrUpdateParticipant(ctx, db, Participant{ID: uuid.New(), GroupID: otherGroupID, UserID: 1, CreatedAt: created}); err != nil {
+		t.Fatalf("CreateOrUpdateParticipant: %v", err)
+	}
+
+	f.pair = Pair{ID: uuid.New(), GroupID: testGroupID, WeekStart: testWeek, User1ID: 1, User2ID: 2, CreatedAt: created}
+	f.trio = Pair{ID: uuid.New(), GroupID: otherGroupID, WeekStart: testWeek, User1ID: 1, User2ID: 3, User3ID: 2, CreatedAt: created, Manual: true}
+	if err := CreatePairs(ctx, db, []Pair{f.pair, f.trio}); err != nil {
+		t.Fatalf("CreatePairs: %v", err)
+	}
+
+	var err error
+	f.experimentID, err = CreateExperiment(ctx, db, Experiment{Name: "day", SettingKey: testSetting, VariantA: "mon", VariantB: "thu",
+		Excluded: []int64{-300}, StartWeek: testWeek, Cycles: 4, CreatedBy: testCreatedBy, CreatedAt: created})
+	if err != nil {
+		t.Fatalf("CreateExperiment: %v", err)
+	}
+	if _, err := SaveSettingsProfile(ctx, db, SettingsProfile{Name: testProfile, Settings: map[string]string{testSetting: "mon"},
+		SavedBy: testCreatedBy, UpdatedAt: created}); err != nil {
+		t.Fatalf("SaveSettingsProfile: %v", err)
+	}
+	return f
+}
+
+// allPairChunks collects the group's pair history through ForEachPairChunk
+func allPairChunks(ctx context.Context, db *sql.DB, groupID int64) ([]Pair, error) {
+	var pairs []Pair
+	err := ForEachPairChunk(ctx, db, groupID, func(chunk []Pair) error {
+		pairs = append(pairs, chunk...)
+		return nil
+	})
+	return pairs, err
+}
+
+// candidateUsers lists the user IDs of candidate pairs, checking every participant ID on the way
+func candidateUsers(t *testing.T, f scanFixture, candidates [][2]Participant) [][2]int64 {
+	t.Helper()
+	users := make([][2]int64, 0, len(candidates))
+	for _, c := range candidates {
+		for _, p := range c {
+			if p.ID != f.participants[p.UserID] || p.GroupID != testGroupID {
+				t.Fatalf("candidate %+v, want participant ID %s", p, f.participants[p.UserID])
+			}
+		}
+		users = append(users, [2]int64{c[0].UserID, c[1].UserID})
+	}
+	return users
+}
+
+func TestScannersReadStoredRows(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	f := seedScanFixture(t, db)
+
+	participants, err := GetAllParticipants(ctx, db, testGroupID)
+	if err != nil || len(participants) != 3 {
+		t.Fatalf("GetAllParticipants = %v, %v; want three", participants, err)
+	}
+	for _, p := range participants {
+		if p.ID != f.participants[p.UserID] || p.Username == "" || p.CreatedAt.IsZero() {
+			t.Fatalf("participant %+v, want ID %s with a name and a sign-up time", p, f.participants[p.UserID])
+		}
+	}
+	participations, err := GetParticipationsByUser(ctx, db, 1)
+	if err != nil || len(participations) != 2 || participations[0].GroupID != otherGroupID || participations[1].ID != f.participants[1] {
+		t.Fatalf("GetParticipationsByUser = %+v, %v; want both groups", participations, err)
+	}
+
+	history, err := GetPairHistory(ctx, db, testGroupID)
+	if err != nil || len(history) != 1 || !history[0].CreatedAt.Equal(f.pair.CreatedAt) {
+		t.Fatalf("GetPairHistory = %+v, %v; want %+v", history, err, f.pair)
+	}
+	history[0].CreatedAt = f.pair.CreatedAt
+	if !reflect.DeepEqual(history[0], f.pair) {
+		t.Fatalf("GetPairHistory = %+v, want %+v", history[0], f.pair)
+	}
+	byUser, err := GetPairsByUser(ctx, db, 2)
+	if err != nil || len(byUser) != 2 || byUser[0].ID != f.trio.ID || byUser[0].User3ID != 2 || !byUser[0].Manual || byUser[1].ID != f.pair.ID {
+		t.Fatalf("GetPairsByUser = %+v, %v; want the trio then the pair", byUser, err)
+	}
+	chunked, err := allPairChunks(ctx, db, otherGroupID)
+	if err != nil || len(chunked) != 1 || chunked[0].ID != f.trio.ID {
+		t.Fatalf("ForEachPairChunk = %+v, %v; want the trio", chunked, err)
+	}
+
+	// 1 and 2 have met, so only the other two couples are candidates; with repeats the met couple comes last
+	available, err := GetAvailablePairs(ctx, db, testGroupID, testWeek)
+	if err != nil {
+		t.Fatalf("GetAvailablePairs: %v", err)
+	}
+	if users := candidateUsers(t, f, available); len(users) != 2 || reflect.DeepEqual(users[0], [2]int64{1, 2}) || reflect.DeepEqual(users[1], [2]int64{1, 2}) {
+		t.Fatalf("GetAvailablePairs = %v, want 1-3 and 2-3", users)
+	}
+	repeats, err := GetAvailablePairsAllowingRepeats(ctx, db, testGroupID, testWeek)
+	if err != nil {
+		t.Fatalf("GetAvailablePairsAllowingRepeats: %v", err)
+	}
+	if users := candidateUsers(t, f, repeats); len(users) != 3 || users[2] != [2]int64{1, 2} {
+		t.Fatalf("GetAvailablePairsAllowingRepeats = %v, want 1-2 last", users)
+	}
+
+	matches, err := GetOtherGroupMatches(ctx, db, testWeek, testGroupID, []int64{1, 2, 4})
+	if err != nil || !reflect.DeepEqual(matches, map[int64][]int64{1: {otherGroupID}, 2: {otherGroupID}}) {
+		t.Fatalf("GetOtherGroupMatches = %v, %v; want 1 and 2 in the other group", matches, err)
+	}
+
+	e, err := GetRunningExperiment(ctx, db, testSetting)
+	if err != nil || e == nil || e.ID != f.experimentID || !reflect.DeepEqual(e.Excluded, []int64{-300}) || !e.StoppedAt.IsZero() {
+		t.Fatalf("GetRunningExperiment = %+v, %v", e, err)
+	}
+	profile, err := GetSettingsProfile(ctx, db, testProfile)
+	if err != nil || profile.Version != 1 || profile.Settings[testSetting] != "mon" || profile.SavedBy != testCreatedBy {
+		t.Fatalf("GetSettingsProfile = %+v, %v", profile, err)
+	}
+}
+
+func TestInPlaceholders(t *testing.T) {
+	placeholders, args := inPlaceholders([]int64{7, 8, 9})
+	if placeholders != "?, ?, ?" || !reflect.DeepEqual(args, []any{int64(7), int64(8), int64(9)}) {
+		t.Fatalf("inPlaceholders = %q, %v", placeholders, args)
+	}
+	if placeholders, args := inPlaceholders(nil); placeholders != "" || len(args) != 0 {
+		t.Fatalf("inPlaceholders(nil) = %q, %v", placeholders, args)
+	}
+}
+
+func TestCorruptRowsAreReported(t *testing.T) {
+	participantRow := "group_id=-100 user_id=2"
+	pairRow := "group_id=-100 week_start=" + testWeek + " user1_id=1 user2_id=2"
+
+	tests := []struct {
+		name    string
+		corrupt string // statement that damages one stored row
+		read    func(context.Context, *sql.DB) error
+		want    CorruptRowError // Err is not compared
+	}{
+		{
+			name:    "GetAllParticipants",
+			corrupt: `UPDATE participant SET id = '` + notAUUID + `' WHERE group_id = -100 AND user_id = 2`,
+			read: func(ctx context.Context, db *sql.DB) error {
+				_, err := GetAllParticipants(ctx, db, testGroupID)
+				return err
+			},
+			want: CorruptRowError{Table: "participant", Column: "id", Row: participantRow, Value: notAUUID},
+		},
+		{
+			name:    "GetParticipationsByUser",
+			corrupt: `UPDATE participant SET id = '` + notAUUID + `' WHERE group_id = -100 AND user_id = 2`,
+			read: func(ctx context.Context, db *sql.DB) error {
+				_, err := GetParticipationsByUser(ctx, db, 2)
+				return err
+			},
+			want: CorruptRowError{Table: "participant", Column: "id", Row: participantRow, Value: notAUUID},
+		},
+		{
+			name:    "GetAvailablePairs",
+			corrupt: `UPDATE participant SET id = '` + notAUUID + `' WHERE group_id = -100 AND user_id = 2`,
+			read: func(ctx context.Context, db *sql.DB) error {
+				_, err := GetAvailablePairs(ctx, db, testGroupID, testWeek)
+				return err
+			},
+			want: CorruptRowError{Table: "participant", Column: "id", Row: participantRow, Value: notAUUID},
+		},
+		{
+			name:    "GetAvailablePairsAllowingRepeats",
+			corrupt: `UPDATE participant SET id = '' WHERE group_id = -100 AND user_id = 2`,
+			read: func(ctx context.Context, db *sql.DB) error {
+				_, err := GetAvailablePairsAllowingRepeats(ctx, db, testGroupID, testWeek)
+				return err
+			},
+			want: CorruptRowError{Table: "participant", Column: "id", Row: participantRow, Value: ""},
+		},
+		{
+			name:    "GetPairHistory",
+			corrupt: `UPDATE pair SET id = '` + notAUUID + `' WHERE group_id = -100`,
+			read: func(ctx context.Context, db *sql.DB) error {
+				_, err := GetPairHistory(ctx, db, testGroupID)
+				return err
+			},
+			want: CorruptRowError{Table: "pair", Column: "id", Row: pairRow, Value: notAUUID},
+		},
+		{
+			name:    "GetPairsByUser",
+			corrupt: `UPDATE pair SET id = '` + notAUUID + `' WHERE group_id = -100`,
+			read: func(ctx context.Context, db *sql.DB) error {
+				_, err := GetPairsByUser(ctx, db, 2)
+				return err
+			},
+			want: CorruptRowError{Table: "pair", Column: "id", Row: pairRow, Value: notAUUID},
+		},
+		{
+			name:    "ForEachPairChunk",
+			corrupt: `UPDATE pair SET id = '` + notAUUID + `' WHERE group_id = -100`,
+			read: func(ctx context.Context, db *sql.DB) error {
+				_, err := allPairChunks(ctx, db, testGroupID)
+				return err
+			},
+			want: CorruptRowError{Table: "pair", Column: "id", Row: pairRow, Value: notAUUID},
+		},
+		{
+			name:    "GetRunningExperiment",
+			corrupt: `UPDATE experiment SET excluded_groups = '-300,x'`,
+			read: func(ctx context.Context, db *sql.DB) error {
+				_, err := GetRunningExperiment(ctx, db, testSetting)
+				return err
+			},
+			want: CorruptRowError{Table: "experiment", Column: "excluded_groups", Row: "id=1", Value: "-300,x"},
+		},
+		{
+			name:    "GetSettingsProfile",
+			corrupt: `UPDATE settings_profile SET settings = '{'`,
+			read: func(ctx context.Context, db *sql.DB) error {
+				_, err := GetSettingsProfile(ctx, db, testProfile)
+				return err
+			},
+			want: CorruptRowError{Table: "settings_profile", Column: "settings", Row: "name=" + testProfile, Value: "{"},
+		},
+	}
+
+	var reported []*CorruptRowError
+	OnCorruptRow = func(e *CorruptRowError) { reported = append(reported, e) }
+	t.Cleanup(func() { OnCorruptRow = nil })
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := openTestDB(t)
+			ctx := context.Background()
+			seedScanFixture(t, db)
+			reported = nil
+
+			// The intact rows read fine, so the error below comes from the damaged one
+			if err := tt.read(ctx, db); err != nil {
+				t.Fatalf("before corruption: %v", err)
+			}
+			if _, err := db.ExecContext(ctx, tt.corrupt); err != nil {
+				t.Fatalf("corrupt: %v", err)
+			}
+
+			err := tt.read(ctx, db)
+			var corrupt *CorruptRowError
+			if !errors.As(err, &corrupt) {
+				t.Fatalf("err = %v, want a CorruptRowError", err)
+			}
+			if corrupt.Err == nil || errors.Unwrap(corrupt) != corrupt.Err {
+				t.Fatalf("CorruptRowError without its cause: %+v", corrupt)
+			}
+			got := *corrupt
+			got.Err = nil
+			if got != tt.want {
+				t.Fatalf("CorruptRowError = %+v, want %+v", got, tt.want)
+			}
+			if !strings.Contains(err.Error(), tt.want.Row) {
+				t.Fatalf("error %q does not name the row", err)
+			}
+			// ...and reported on the side, for the critical alert
+			if len(reported) == 0 || reported[len(reported)-1] != corrupt {
+				t.Fatalf("OnCorruptRow got %+v, want the returned error", reported)
+			}
+		})
+	}
+}
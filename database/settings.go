@@ -0,0 +1,197 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrSettingConflict means the setting changed after the version a conditional write was based on
+var ErrSettingConflict = errors.New("group setting changed since it was read")
+
+// SettingsProfile is a named set of group settings; keys missing from it mean the default value
+type SettingsProfile struct {
+	Name      string
+	Version   int
+	Settings  map[string]string
+	SavedBy   int64
+	UpdatedAt time.Time
+}
+
+// Group setting operations
+
+// GetGroupSetting returns a group's setting and whether it is set
+func GetGroupSetting(ctx context.Context, db *sql.DB, groupID int64, key string) (string, bool, error) {
+	query := `SELECT value FROM group_setting WHERE group_id = ? AND key = ? AND deleted = 0`
+
+	var value string
+	err := db.QueryRowContext(ctx, query, groupID, key).Scan(&value)
+	if err == sql.ErrNoRows {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, fmt.Errorf("failed to get group setting: %w", err)
+	}
+	return value, true, nil
+}
+
+// GetGroupSettingVersion returns a group's setting with its version. The version is zero when the setting
+// was never set; a cleared setting has an empty value and keeps the version it was cleared at.
+func GetGroupSettingVersion(ctx context.Context, db *sql.DB, groupID int64, key string) (string, int, error) {
+	query := `SELECT value, version FROM group_setting WHERE group_id = ? AND key = ?`
+
+	var value string
+	var version int
+	err := db.QueryRowContext(ctx, query, groupID, key).Scan(&value, &version)
+	if err == sql.ErrNoRows {
+		return "", 0, nil
+	}
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to get group setting: %w", err)
+	}
+	return value, version, nil
+}
+
+// SetGroupSetting writes the setting unconditionally, bumping its version
+func SetGroupSetting(ctx context.Context, db *sql.DB, groupID int64, key, value string) error {
+	return setGroupSetting(ctx, db, groupID, key, value)
+}
+
+func setGroupSetting(ctx context.Context, ex execer, groupID int64, key, value string) error {
+	query := `INSERT INTO group_setting (group_id, key, value, version, updated_at)
+	VALUES (?, ?, ?, 1, ?)
+	ON CONFLICT (group_id, key) DO UPDATE
+	SET value = EXCLUDED.value, version = group_setting.version + 1, deleted = 0, updated_at = EXCLUDED.updated_at`
+
+	_, err := ex.ExecContext(ctx, query, groupID, key, value, formatTime(time.Now()))
+	return err
+}
+
+// SetGroupSettingIfVersion writes the setting only if it is still at baseVersion (zero: never set),
+// and fails with ErrSettingConflict otherwise
+func SetGroupSettingIfVersion(ctx context.Context, db *sql.DB, groupID int64, key, value string, baseVersion int) error {
+	now := formatTime(time.Now())
+
+	var res sql.Result
+	var err error
+	if baseVersion == 0 {
+		query := `INSERT INTO group_setting (group_id, key, value, version, updated_at)
+		VALUES (?, ?, ?, 1, ?)
+		ON CONFLICT (group_id, key) DO NOTHING`
+		res, err = db.ExecContext(ctx, query, groupID, key, value, now)
+	} else {
+		query := `UPDATE group_setting SET value = ?, version = version + 1, deleted = 0, updated_at = ?
+		WHERE group_id = ? AND key = ? AND version = ?`
+		res, err = db.ExecContext(ctx, query, value, now, groupID, key, baseVersion)
+	}
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrSettingConflict
+	}
+	return nil
+}
+
+// DeleteGroupSetting clears the setting. The row stays behind as a tombstone with a bumped version, so a
+// conditional write based on the value before the clear still fails after the setting is set again.
+func DeleteGroupSetting(ctx context.Context, db *sql.DB, groupID int64, key string) error {
+	return deleteGroupSetting(ctx, db, groupID, key)
+}
+
+func deleteGroupSetting(ctx context.Context, ex execer, groupID int64, key string) error {
+	query := `UPDATE group_setting SET value = '', version = version + 1, deleted = 1, updated_at = ?
+	WHERE group_id = ? AND key = ? AND deleted = 0`
+	_, err := ex.ExecContext(ctx, query, formatTime(time.Now()), groupID, key)
+	return err
+}
+
+// DeleteGroupSettingIfValue clears the setting only while it still has the given value and reports
+// whether it did, so a value written meanwhile is kept
+func DeleteGroupSettingIfValue(ctx context.Context, db *sql.DB, groupID int64, key, value string) (bool, error) {
+	query := `UPDATE group_setting SET value = '', version = version + 1, deleted = 1, updated_at = ?
+	WHERE group_id = ? AND key = ? AND value = ? AND deleted = 0`
+	res, err := db.ExecContext(ctx, query, formatTime(time.Now()), groupID, key, value)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// ApplyGroupSettings writes the group's schedule and settings in one transaction, so a failure leaves
+// the group as it was. Settings with an empty value are cleared.
+func ApplyGroupSettings(ctx context.Context, db *sql.DB, sc GroupConfig, settings map[string]string) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if err := upsertGroupConfig(ctx, tx, sc); err != nil {
+		return fmt.Errorf("failed to save schedule: %w", err)
+	}
+	for key, value := range settings {
+		if value != "" {
+			err = setGroupSetting(ctx, tx, sc.GroupID, key, value)
+		} else {
+			err = deleteGroupSetting(ctx, tx, sc.GroupID, key)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to save %s: %w", key, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// Settings profile operations
+
+// SaveSettingsProfile creates the profile or replaces its settings, bumping the version; it returns the new version
+func SaveSettingsProfile(ctx context.Context, db *sql.DB, p SettingsProfile) (int, error) {
+	settings, err := json.Marshal(p.Settings)
+	if err != nil {
+		return 0, err
+	}
+
+	query := `INSERT INTO settings_profile (name, version, settings, saved_by, updated_at)
+	VALUES (?, 1, ?, ?, ?)
+	ON CONFLICT (name) DO UPDATE
+	SET version = settings_profile.version + 1, settings = EXCLUDED.settings,
+		saved_by = EXCLUDED.saved_by, updated_at = EXCLUDED.updated_at
+	RETURNING version`
+
+	var version int
+	err = db.QueryRowContext(ctx, query, p.Name, string(settings), p.SavedBy, formatTime(p.UpdatedAt)).Scan(&version)
+	return version, err
+}
+
+func GetSettingsProfile(ctx context.Context, db *sql.DB, name string) (*SettingsProfile, error) {
+	query := `SELECT name, version, settings, saved_by, updated_at FROM settings_profile WHERE name = ?`
+
+	var p SettingsProfile
+	var settings, updatedAtStr string
+	if err := db.QueryRowContext(ctx, query, name).Scan(&p.Name, &p.Version, &settings, &p.SavedBy, &updatedAtStr); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(settings), &p.Settings); err != nil {
+		return nil, corruptRow("settings_profile", "settings", "name="+name, settings, err)
+	}
+	p.UpdatedAt = parseTime(updatedAtStr)
+	return &p, nil
+}
+
+// GetSettingsProfileNames returns the names of all profiles, sorted
+func GetSettingsProfileNames(ctx context.Context, db *sql.DB) ([]string, error) {
+	return queryRows(ctx, db, `SELECT name FROM settings_profile ORDER BY name`, scanString)
+}
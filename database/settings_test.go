@@ -0,0 +1,93 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+const (
+	testGroupID = int64(-100)
+	testKey     = "announcement_media"
+)
+
+func TestSetGroupSettingIfVersion(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+
+	if err := SetGroupSettingIfVersion(ctx, db, testGroupID, testKey, "photo:a", 0); err != nil {
+		t.Fatalf("first write: %v", err)
+	}
+	if err := SetGroupSettingIfVersion(ctx, db, testGroupID, testKey, "photo:b", 0); !errors.Is(err, ErrSettingConflict) {
+		t.Fatalf("second write from unset = %v, want a conflict", err)
+	}
+
+	_, version, err := GetGroupSettingVersion(ctx, db, testGroupID, testKey)
+	if err != nil || version != 1 {
+		t.Fatalf("GetGroupSettingVersion = %d, %v; want 1", version, err)
+	}
+	if err := SetGroupSettingIfVersion(ctx, db, testGroupID, testKey, "photo:b", version); err != nil {
+		t.Fatalf("write at the current version: %v", err)
+	}
+	if err := SetGroupSettingIfVersion(ctx, db, testGroupID, testKey, "photo:c", version); !errors.Is(err, ErrSettingConflict) {
+		t.Fatalf("write at a stale version = %v, want a conflict", err)
+	}
+}
+
+func TestStaleWriteFailsAfterClearAndSet(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+
+	if err := SetGroupSetting(ctx, db, testGroupID, testKey, "photo:a"); err != nil {
+		t.Fatal(err)
+	}
+	_, stale, err := GetGroupSettingVersion(ctx, db, testGroupID, testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Another admin clears the media and sets a new one while the first still edits
+	if err := DeleteGroupSetting(ctx, db, testGroupID, testKey); err != nil {
+		t.Fatal(err)
+	}
+	if value, found, err := GetGroupSetting(ctx, db, testGroupID, testKey); err != nil || found {
+		t.Fatalf("GetGroupSetting after clear = %q, %v, %v; want unset", value, found, err)
+	}
+	if err := SetGroupSetting(ctx, db, testGroupID, testKey, "photo:b"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := SetGroupSettingIfVersion(ctx, db, testGroupID, testKey, "photo:stale", stale); !errors.Is(err, ErrSettingConflict) {
+		t.Fatalf("stale write = %v, want a conflict", err)
+	}
+	if value, _, _ := GetGroupSetting(ctx, db, testGroupID, testKey); value != "photo:b" {
+		t.Fatalf("setting = %q, want the newer value kept", value)
+	}
+}
+
+func TestClearedSettingVersion(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+
+	if err := SetGroupSetting(ctx, db, testGroupID, testKey, "photo:a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := DeleteGroupSetting(ctx, db, testGroupID, testKey); err != nil {
+		t.Fatal(err)
+	}
+	// Clearing what is already cleared changes nothing
+	if err := DeleteGroupSetting(ctx, db, testGroupID, testKey); err != nil {
+		t.Fatal(err)
+	}
+
+	value, version, err := GetGroupSettingVersion(ctx, db, testGroupID, testKey)
+	if err != nil || value != "" || version != 2 {
+		t.Fatalf("GetGroupSettingVersion = %q, %d, %v; want empty at version 2", value, version, err)
+	}
+	if err := SetGroupSettingIfVersion(ctx, db, testGroupID, testKey, "photo:b", version); err != nil {
+		t.Fatalf("write based on the cleared version: %v", err)
+	}
+	if value, found, _ := GetGroupSetting(ctx, db, testGroupID, testKey); !found || value != "photo:b" {
+		t.Fatalf("GetGroupSetting = %q, %v; want the new value", value, found)
+	}
+}
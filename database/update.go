@@ -0,0 +1,113 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// updateIDsRestartAfter is how long Telegram keeps update IDs sequential: after a week without
+// updates the next ID is chosen at random, so older IDs say nothing about new ones
+const updateIDsRestartAfter = 7 * 24 * time.Hour
+
+// MarkUpdateProcessed records a Telegram update ID and reports whether it is seen for the first time.
+// Only the most recent `keep` IDs are retained; the highest ID pruned from them becomes the cursor,
+// and IDs at or below it count as seen too.
+func MarkUpdateProcessed(ctx context.Context, db *sql.DB, updateID int64, keep int) (bool, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	now := time.Now()
+	var cursor int64
+	var seenAtStr string
+	err = tx.QueryRowContext(ctx, `SELECT update_id, seen_at FROM update_cursor WHERE id = 1`).Scan(&cursor, &seenAtStr)
+	switch {
+	case err == sql.ErrNoRows:
+	case err != nil:
+		return false, err
+	case now.Sub(parseTime(seenAtStr)) > updateIDsRestartAfter:
+		// IDs may have restarted, so what was seen before can't tell a duplicate
+		if _, err := tx.ExecContext(ctx, `DELETE FROM processed_update`); err != nil {
+			return false, err
+		}
+		cursor = 0
+	case updateID <= cursor:
+		return false, nil
+	}
+
+	query := `INSERT OR IGNORE INTO processed_update (update_id, processed_at) VALUES (?, ?)`
+	res, err := tx.ExecContext(ctx, query, updateID, formatTime(now))
+	if err != nil {
+		return false, err
+	}
+
+	inserted, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if inserted == 0 {
+		return false, nil
+	}
+
+	// Prune by insertion order, not by update_id: updates of different chats are handled
+	// concurrently, so a lower ID may be recorded after a higher one
+	var pruned sql.NullInt64
+	query = `SELECT MAX(update_id) FROM processed_update WHERE id NOT IN (
+		SELECT id FROM processed_update ORDER BY id DESC LIMIT ?)`
+	if err := tx.QueryRowContext(ctx, query, keep).Scan(&pruned); err != nil {
+		return false, fmt.Errorf("failed to prune processed updates: %w", err)
+	}
+	query = `DELETE FROM processed_update WHERE id NOT IN (
+		SELECT id FROM processed_update ORDER BY id DESC LIMIT ?)`
+	if _, err := tx.ExecContext(ctx, query, keep); err != nil {
+		return false, fmt.Errorf("failed to prune processed updates: %w", err)
+	}
+
+	cursor = max(cursor, pruned.Int64)
+	query = `INSERT INTO update_cursor (id, update_id, seen_at) VALUES (1, ?, ?)
+		ON CONFLICT(id) DO UPDATE SET update_id = excluded.update_id, seen_at = excluded.seen_at`
+	if _, err := tx.ExecContext(ctx, query, cursor, formatTime(now)); err != nil {
+		return false, err
+	}
+	if err := tx.Commit(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// QueuedUpdate is a raw Telegram update held back during maintenance
+type QueuedUpdate struct {
+	ID         int64
+	UpdateID   int64
+	Payload    string // the update as JSON
+	ReceivedAt time.Time
+}
+
+func QueueUpdate(ctx context.Context, db *sql.DB, q QueuedUpdate) error {
+	query := `INSERT INTO queued_update (update_id, payload, received_at) VALUES (?, ?, ?)`
+	_, err := db.ExecContext(ctx, query, q.UpdateID, q.Payload, formatTime(q.ReceivedAt))
+	return err
+}
+
+// GetQueuedUpdates returns held-back updates in the order they arrived
+func GetQueuedUpdates(ctx context.Context, db *sql.DB) ([]QueuedUpdate, error) {
+	query := `SELECT id, update_id, payload, received_at FROM queued_update ORDER BY id`
+
+	return queryRows(ctx, db, query, func(r rowScanner) (QueuedUpdate, error) {
+		var q QueuedUpdate
+		var receivedAtStr string
+		err := r.Scan(&q.ID, &q.UpdateID, &q.Payload, &receivedAtStr)
+		q.ReceivedAt = parseTime(receivedAtStr)
+		return q, err
+	})
+}
+
+func DeleteQueuedUpdate(ctx context.Context, db *sql.DB, id int64) error {
+	query := `DELETE FROM queued_update WHERE id = ?`
+	_, err := db.ExecContext(ctx, query, id)
+	return err
+}
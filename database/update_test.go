@@ -0,0 +1,120 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMarkUpdateProcessed(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+
+	mark := func(id int64) bool {
+		t.Helper()
+		fresh, err := MarkUpdateProcessed(ctx, db, id, 3)
+		if err != nil {
+			t.Fatalf("MarkUpdateProcessed(%d): %v", id, err)
+		}
+		return fresh
+	}
+
+	// Updates of different chats may be handled out of order
+	for _, id := range []int64{11, 10, 12} {
+		if !mark(id) {
+			t.Fatalf("update %d dropped the first time", id)
+		}
+	}
+	if mark(10) {
+		t.Fatal("update 10 handled twice")
+	}
+
+	// 10 and 11 fall out of the ring; the cursor still remembers them
+	mark(13)
+	mark(14)
+	var ring int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM processed_update`).Scan(&ring); err != nil || ring != 3 {
+		t.Fatalf("ring holds %d IDs (%v), want 3", ring, err)
+	}
+	for _, id := range []int64{10, 11} {
+		if mark(id) {
+			t.Fatalf("update %d handled again after leaving the ring", id)
+		}
+	}
+	if !mark(15) {
+		t.Fatal("update 15 dropped")
+	}
+}
+
+func TestMarkUpdateProcessedAfterIDsRestart(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+
+	for id := int64(100); id < 110; id++ {
+		if _, err := MarkUpdateProcessed(ctx, db, id, 3); err != nil {
+			t.Fatalf("MarkUpdateProcessed: %v", err)
+		}
+	}
+
+	// A week without updates: Telegram starts over from a random ID
+	quiet := formatTime(time.Now().Add(-updateIDsRestartAfter - time.Hour))
+	if _, err := db.Exec(`UPDATE update_cursor SET seen_at = ?`, quiet); err != nil {
+		t.Fatalf("UPDATE: %v", err)
+	}
+	for _, id := range []int64{5, 108} {
+		fresh, err := MarkUpdateProcessed(ctx, db, id, 3)
+		if err != nil || !fresh {
+			t.Fatalf("MarkUpdateProcessed(%d) = %v, %v; want a new update", id, fresh, err)
+		}
+	}
+	if fresh, _ := MarkUpdateProcessed(ctx, db, 5, 3); fresh {
+		t.Fatal("update 5 handled twice after the restart")
+	}
+}
+
+func TestLegacyMigrationsAreAnnotated(t *testing.T) {
+	for name := range legacyMigrations {
+		shipped, err := os.ReadFile(filepath.Join("../migrations", name))
+		if err != nil {
+			t.Fatalf("ReadFile: %v", err)
+		}
+		if strings.Contains(string(shipped), "+goose") {
+			t.Fatalf("%s was edited; applied migrations must stay as shipped", name)
+		}
+
+		f, err := migrationsFS{FS: os.DirFS("../migrations")}.Open(name)
+		if err != nil {
+			t.Fatalf("Open(%s): %v", name, err)
+		}
+		served, _ := io.ReadAll(f)
+		f.Close()
+		if !strings.HasPrefix(string(served), "-- +goose Up\n") || !strings.HasSuffix(string(served), string(shipped)) {
+			t.Fatalf("%s served as %q", name, served)
+		}
+	}
+}
+
+func TestMigrateFreshDatabase(t *testing.T) {
+	db := openTestDB(t)
+	var version int64
+	if err := db.QueryRow(`SELECT MAX(version_id) FROM goose_db_version`).Scan(&version); err != nil {
+		t.Fatalf("reading goose version: %v", err)
+	}
+	entries, _ := filepath.Glob("../migrations/*.sql")
+	if version != int64(len(entries)) {
+		t.Fatalf("migrated to version %d, want all %d migrations", version, len(entries))
+	}
+
+	// Running again is a no-op
+	if err := Migrate(db, "../migrations"); err != nil {
+		t.Fatalf("Migrate again: %v", err)
+	}
+	if err := db.QueryRow(`SELECT update_id FROM update_cursor`).Scan(new(int64)); err != sql.ErrNoRows {
+		t.Fatalf("update_cursor: %v, want an empty table", err)
+	}
+}
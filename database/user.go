@@ -0,0 +1,176 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+type UserProfile struct {
+	UserID    int64
+	Username  string
+	FullName  string
+	UpdatedAt time.Time
+}
+
+// User profile operations
+
+func scanUserProfile(r rowScanner) (UserProfile, error) {
+	var u UserProfile
+	var updatedAtStr string
+	err := r.Scan(&u.UserID, &u.Username, &u.FullName, &updatedAtStr)
+	u.UpdatedAt = parseTime(updatedAtStr)
+	return u, err
+}
+
+func UpsertUserProfile(ctx context.Context, db *sql.DB, u UserProfile) error {
+	query := `INSERT INTO user_profile (user_id, username, full_name, updated_at)
+	VALUES (?, ?, ?, ?)
+	ON CONFLICT (user_id) DO UPDATE
+	SET username = EXCLUDED.username, full_name = EXCLUDED.full_name, updated_at = EXCLUDED.updated_at`
+
+	_, err := db.ExecContext(ctx, query, u.UserID, u.Username, u.FullName, formatTime(u.UpdatedAt))
+	return err
+}
+
+// profileQueryChunk is how many user IDs GetUserProfiles binds per query
+const profileQueryChunk = 500
+
+// GetUserProfiles returns known profiles keyed by user ID; unknown users are simply absent
+func GetUserProfiles(ctx context.Context, db *sql.DB, userIDs []int64) (map[int64]UserProfile, error) {
+	profiles := make(map[int64]UserProfile, len(userIDs))
+
+	// Callers pass every member of every pair, so IDs repeat; the rest is queried in chunks to stay
+	// under SQLite's limit on bound parameters
+	seen := make(map[int64]bool, len(userIDs))
+	unique := make([]int64, 0, len(userIDs))
+	for _, id := range userIDs {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+
+	for start := 0; start < len(unique); start += profileQueryChunk {
+		chunk := unique[start:min(start+profileQueryChunk, len(unique))]
+		placeholders, args := inPlaceholders(chunk)
+		query := `SELECT user_id, username, full_name, updated_at FROM user_profile WHERE user_id IN (` + placeholders + `)`
+
+		list, err := queryRows(ctx, db, query, scanUserProfile, args...)
+		if err != nil {
+			return nil, err
+		}
+		for _, u := range list {
+			profiles[u.UserID] = u
+		}
+	}
+	return profiles, nil
+}
+
+// GetUserProfileByUsername finds a user by their Telegram username, ignoring case; nil if unknown
+func GetUserProfileByUsername(ctx context.Context, db *sql.DB, username string) (*UserProfile, error) {
+	query := `SELECT user_id, username, full_name, updated_at FROM user_profile
+	WHERE username = ? COLLATE NOCASE ORDER BY updated_at DESC LIMIT 1`
+
+	u, err := scanUserProfile(db.QueryRowContext(ctx, query, username))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user profile: %w", err)
+	}
+	return &u, nil
+}
+
+// GetProfileUserIDs returns the IDs of every user with a stored profile
+func GetProfileUserIDs(ctx context.Context, db *sql.DB) ([]int64, error) {
+	return queryRows(ctx, db, `SELECT user_id FROM user_profile`, scanInt64)
+}
+
+// User language operations
+
+// GetUserLanguage returns the user's stored reply language and whether they chose it explicitly;
+// the language is empty when none is stored
+func GetUserLanguage(ctx context.Context, db *sql.DB, userID int64) (string, bool, error) {
+	query := `SELECT language, explicit FROM user_language WHERE user_id = ?`
+
+	var language string
+	var explicit bool
+	err := db.QueryRowContext(ctx, query, userID).Scan(&language, &explicit)
+	if err == sql.ErrNoRows {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, fmt.Errorf("failed to get user language: %w", err)
+	}
+	return language, explicit, nil
+}
+
+// SetUserLanguage stores the user's reply language. An inferred language never replaces an explicit choice.
+func SetUserLanguage(ctx context.Context, db *sql.DB, userID int64, language string, explicit bool) error {
+	query := `INSERT INTO user_language (user_id, language, explicit, updated_at)
+	VALUES (?, ?, ?, ?)
+	ON CONFLICT (user_id) DO UPDATE
+	SET language = EXCLUDED.language, explicit = EXCLUDED.explicit, updated_at = EXCLUDED.updated_at
+	WHERE user_language.explicit = 0 OR EXCLUDED.explicit = 1`
+
+	_, err := db.ExecContext(ctx, query, userID, language, explicit, formatTime(time.Now()))
+	return err
+}
+
+// GetUserGroupSettingValues returns the distinct values a group setting has in the groups where the user
+// is signed up or was ever paired, sorted
+func GetUserGroupSettingValues(ctx context.Context, db *sql.DB, userID int64, key string) ([]string, error) {
+	query := `SELECT DISTINCT value FROM group_setting
+	WHERE key = ? AND deleted = 0 AND group_id IN (
+		SELECT group_id FROM participant WHERE user_id = ?
+		UNION SELECT group_id FROM pair WHERE ? IN (user1_id, user2_id, user3_id))
+	ORDER BY value`
+	return queryRows(ctx, db, query, scanString, key, userID, userID)
+}
+
+// DM preference operations
+
+// GetDMPreference returns whether the user enabled private messages; found is false when they never chose
+func GetDMPreference(ctx context.Context, db *sql.DB, userID int64) (enabled bool, found bool, err error) {
+	query := `SELECT enabled FROM dm_preference WHERE user_id = ?`
+
+	err = db.QueryRowContext(ctx, query, userID).Scan(&enabled)
+	if err == sql.ErrNoRows {
+		return false, false, nil
+	}
+	if err != nil {
+		return false, false, fmt.Errorf("failed to get dm preference: %w", err)
+	}
+	return enabled, true, nil
+}
+
+func SetDMPreference(ctx context.Context, db *sql.DB, userID int64, enabled bool) error {
+	query := `INSERT INTO dm_preference (user_id, enabled, updated_at)
+	VALUES (?, ?, ?)
+	ON CONFLICT (user_id) DO UPDATE SET enabled = EXCLUDED.enabled, updated_at = EXCLUDED.updated_at`
+
+	_, err := db.ExecContext(ctx, query, userID, enabled, formatTime(time.Now()))
+	return err
+}
+
+// Volunteer operations
+
+func SetVolunteer(ctx context.Context, db *sql.DB, groupID, userID int64) error {
+	query := `INSERT OR IGNORE INTO volunteer (group_id, user_id, created_at) VALUES (?, ?, ?)`
+	_, err := db.ExecContext(ctx, query, groupID, userID, formatTime(time.Now()))
+	return err
+}
+
+func DeleteVolunteer(ctx context.Context, db *sql.DB, groupID, userID int64) error {
+	query := `DELETE FROM volunteer WHERE group_id = ? AND user_id = ?`
+	_, err := db.ExecContext(ctx, query, groupID, userID)
+	return err
+}
+
+// GetVolunteers returns the IDs of the group's volunteers in the order they signed up
+func GetVolunteers(ctx context.Context, db *sql.DB, groupID int64) ([]int64, error) {
+	query := `SELECT user_id FROM volunteer WHERE group_id = ? ORDER BY created_at`
+	return queryRows(ctx, db, query, scanInt64, groupID)
+}
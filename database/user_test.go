@@ -0,0 +1,41 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestGetUserProfilesManyIDs(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+
+	const users = 3*profileQueryChunk + 7
+	for id := int64(1); id <= users; id++ {
+		u := UserProfile{UserID: id, Username: fmt.Sprintf("user%d", id), UpdatedAt: time.Now()}
+		if err := UpsertUserProfile(ctx, db, u); err != nil {
+			t.Fatalf("UpsertUserProfile: %v", err)
+		}
+	}
+
+	// Every user in many pairs: far more IDs than SQLite binds in one query
+	var ids []int64
+	for round := 0; round < 40; round++ {
+		for id := int64(1); id <= users; id++ {
+			ids = append(ids, id)
+		}
+	}
+	ids = append(ids, users+1) // unknown users are left out
+
+	profiles, err := GetUserProfiles(ctx, db, ids)
+	if err != nil {
+		t.Fatalf("GetUserProfiles(%d IDs): %v", len(ids), err)
+	}
+	if len(profiles) != users {
+		t.Fatalf("got %d profiles, want %d", len(profiles), users)
+	}
+	if u := profiles[users]; u.Username != fmt.Sprintf("user%d", users) {
+		t.Fatalf("profile %d = %+v", users, u)
+	}
+}
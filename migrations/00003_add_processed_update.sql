@@ -0,0 +1,8 @@
+-- Ring of recently handled Telegram update IDs, used to drop redelivered updates
+-- +goose Up
+
+CREATE TABLE IF NOT EXISTS processed_update (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  update_id INTEGER NOT NULL UNIQUE,
+  processed_at TEXT NOT NULL
+);
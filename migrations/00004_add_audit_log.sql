@@ -0,0 +1,13 @@
+-- Audit trail of administrative operations
+-- +goose Up
+
+CREATE TABLE IF NOT EXISTS audit_log (
+  id TEXT PRIMARY KEY,
+  actor_id INTEGER NOT NULL,
+  action TEXT NOT NULL,
+  group_id INTEGER NOT NULL,
+  details TEXT NOT NULL,
+  created_at TEXT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_audit_log_group ON audit_log (group_id, created_at);
@@ -0,0 +1,15 @@
+-- Latest known Telegram name of every user who voted; participant rows are cleared each cycle
+-- +goose Up
+
+CREATE TABLE IF NOT EXISTS user_profile (
+  user_id INTEGER PRIMARY KEY,
+  username TEXT NOT NULL,
+  full_name TEXT NOT NULL,
+  updated_at TEXT NOT NULL
+);
+
+INSERT OR IGNORE INTO user_profile (user_id, username, full_name, updated_at)
+SELECT user_id, username, full_name, MAX(created_at) FROM participant GROUP BY user_id;
+
+CREATE INDEX IF NOT EXISTS idx_pair_user1 ON pair (user1_id);
+CREATE INDEX IF NOT EXISTS idx_pair_user2 ON pair (user2_id);
@@ -0,0 +1,10 @@
+-- Per-group key/value settings changed by admins at runtime
+-- +goose Up
+
+CREATE TABLE IF NOT EXISTS group_setting (
+  group_id INTEGER NOT NULL,
+  key TEXT NOT NULL,
+  value TEXT NOT NULL,
+  updated_at TEXT NOT NULL,
+  PRIMARY KEY (group_id, key)
+);
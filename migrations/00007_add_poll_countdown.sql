@@ -0,0 +1,6 @@
+-- Companion message under the poll that counts down to pair creation
+-- +goose Up
+
+ALTER TABLE poll_mapping ADD COLUMN countdown_message_id INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE poll_mapping ADD COLUMN countdown_closes_at TEXT NOT NULL DEFAULT '';
+ALTER TABLE poll_mapping ADD COLUMN countdown_edited_at TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,9 @@
+-- Members who volunteer as buddies for first-time participants of a group
+-- +goose Up
+
+CREATE TABLE IF NOT EXISTS volunteer (
+  group_id INTEGER NOT NULL,
+  user_id INTEGER NOT NULL,
+  created_at TEXT NOT NULL,
+  PRIMARY KEY (group_id, user_id)
+);
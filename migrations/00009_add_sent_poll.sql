@@ -0,0 +1,11 @@
+-- Append-only log of every poll the bot has sent, kept independently of poll_mapping
+-- +goose Up
+
+CREATE TABLE IF NOT EXISTS sent_poll (
+  poll_id TEXT PRIMARY KEY,
+  group_id INTEGER NOT NULL,
+  message_id INTEGER NOT NULL,
+  sent_at TEXT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_sent_poll_group ON sent_poll(group_id, sent_at);
@@ -0,0 +1,10 @@
+-- Groups the bot runs Random Coffee in, registered with /register (formerly GROUP_CHAT_IDS only)
+-- +goose Up
+
+CREATE TABLE IF NOT EXISTS chat_group (
+  group_id INTEGER PRIMARY KEY,
+  title TEXT NOT NULL DEFAULT '',
+  active INTEGER NOT NULL DEFAULT 1,
+  registered_at TEXT NOT NULL,
+  updated_at TEXT NOT NULL
+);
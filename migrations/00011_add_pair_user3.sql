@@ -0,0 +1,6 @@
+-- Third member of a trio, formed when a group has an odd number of participants
+-- +goose Up
+
+ALTER TABLE pair ADD COLUMN user3_id INTEGER;
+
+CREATE INDEX IF NOT EXISTS idx_pair_user3 ON pair(user3_id);
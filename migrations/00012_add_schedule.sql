@@ -0,0 +1,14 @@
+-- Per-group weekly schedule; groups without a row use the defaults (Friday 17:00 / Sunday 19:00, Moscow)
+-- +goose Up
+
+CREATE TABLE IF NOT EXISTS schedule (
+  group_id INTEGER PRIMARY KEY,
+  quiz_weekday INTEGER NOT NULL DEFAULT 5,
+  quiz_hour INTEGER NOT NULL DEFAULT 17,
+  quiz_minute INTEGER NOT NULL DEFAULT 0,
+  pairs_weekday INTEGER NOT NULL DEFAULT 0,
+  pairs_hour INTEGER NOT NULL DEFAULT 19,
+  pairs_minute INTEGER NOT NULL DEFAULT 0,
+  timezone TEXT NOT NULL DEFAULT 'Europe/Moscow',
+  updated_at TEXT NOT NULL
+);
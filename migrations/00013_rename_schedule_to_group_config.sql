@@ -0,0 +1,4 @@
+-- The schedule table holds per-group configuration, named accordingly
+-- +goose Up
+
+ALTER TABLE schedule RENAME TO group_config;
@@ -0,0 +1,14 @@
+-- Poll vote totals reported by Telegram when a poll is closed, next to the sign-ups the bot stored
+-- +goose Up
+
+CREATE TABLE IF NOT EXISTS poll_reconciliation (
+  poll_id TEXT PRIMARY KEY,
+  group_id INTEGER NOT NULL,
+  week_start TEXT NOT NULL,
+  poll_yes INTEGER NOT NULL,
+  stored_yes INTEGER NOT NULL,
+  total_voters INTEGER NOT NULL,
+  created_at TEXT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_poll_reconciliation_group ON poll_reconciliation(group_id, created_at);
@@ -0,0 +1,9 @@
+-- Reply language of private chats: chosen with /language (explicit) or inferred from the user's Telegram client
+-- +goose Up
+
+CREATE TABLE IF NOT EXISTS user_language (
+  user_id INTEGER PRIMARY KEY,
+  language TEXT NOT NULL,
+  explicit INTEGER NOT NULL DEFAULT 0,
+  updated_at TEXT NOT NULL
+);
@@ -0,0 +1,10 @@
+-- Named snapshots of group settings that can be applied to other groups; version grows on every save
+-- +goose Up
+
+CREATE TABLE IF NOT EXISTS settings_profile (
+  name TEXT PRIMARY KEY,
+  version INTEGER NOT NULL,
+  settings TEXT NOT NULL,
+  saved_by INTEGER NOT NULL,
+  updated_at TEXT NOT NULL
+);
@@ -0,0 +1,11 @@
+-- Poll messages the bot failed to unpin, retried at the start of the next cycle
+-- +goose Up
+
+CREATE TABLE IF NOT EXISTS unpin_backlog (
+  group_id INTEGER NOT NULL,
+  message_id INTEGER NOT NULL,
+  week_start TEXT NOT NULL,
+  attempts INTEGER NOT NULL DEFAULT 1,
+  failed_at TEXT NOT NULL,
+  PRIMARY KEY (group_id, message_id)
+);
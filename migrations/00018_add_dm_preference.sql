@@ -0,0 +1,8 @@
+-- Whether a user wants private messages triggered by group events, set with /notifications
+-- +goose Up
+
+CREATE TABLE IF NOT EXISTS dm_preference (
+  user_id INTEGER PRIMARY KEY,
+  enabled INTEGER NOT NULL,
+  updated_at TEXT NOT NULL
+);
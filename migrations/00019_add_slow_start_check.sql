@@ -0,0 +1,11 @@
+-- One-off "slow start" checks: N hours after a quiz, nudge the group if few people signed up
+-- +goose Up
+
+CREATE TABLE IF NOT EXISTS slow_start_check (
+  poll_id TEXT PRIMARY KEY,
+  group_id INTEGER NOT NULL,
+  message_id INTEGER NOT NULL,
+  due_at TEXT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_slow_start_check_due ON slow_start_check(due_at);
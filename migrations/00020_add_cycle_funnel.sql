@@ -0,0 +1,17 @@
+-- Per-cycle funnel: chat members at quiz time, sign-ups and matched participants
+-- +goose Up
+
+ALTER TABLE sent_poll
+ADD COLUMN member_count INTEGER NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS cycle_funnel (
+  group_id INTEGER NOT NULL,
+  week_start TEXT NOT NULL,
+  poll_id TEXT NOT NULL DEFAULT '',
+  members INTEGER NOT NULL DEFAULT 0,
+  signed_up INTEGER NOT NULL,
+  matched INTEGER NOT NULL,
+  pairs INTEGER NOT NULL,
+  created_at TEXT NOT NULL,
+  PRIMARY KEY (group_id, week_start)
+);
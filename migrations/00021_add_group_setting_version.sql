@@ -0,0 +1,5 @@
+-- Version of each group setting, so a write based on a stale read can be detected
+-- +goose Up
+
+ALTER TABLE group_setting
+ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
@@ -0,0 +1,5 @@
+-- A sign-up message is either a native poll or an inline-keyboard message for groups with polls disabled
+-- +goose Up
+
+ALTER TABLE poll_mapping
+ADD COLUMN kind TEXT NOT NULL DEFAULT 'poll';
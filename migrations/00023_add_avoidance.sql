@@ -0,0 +1,10 @@
+-- Couples a user privately asked not to be paired in, stored only as keyed hashes of the user IDs
+-- +goose Up
+
+CREATE TABLE IF NOT EXISTS avoidance (
+  group_id INTEGER NOT NULL,
+  owner_hash TEXT NOT NULL,
+  pair_hash TEXT NOT NULL,
+  created_at TEXT NOT NULL,
+  PRIMARY KEY (group_id, owner_hash, pair_hash)
+);
@@ -0,0 +1,9 @@
+-- Updates received during maintenance, replayed in arrival order once it ends
+-- +goose Up
+
+CREATE TABLE IF NOT EXISTS queued_update (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  update_id INTEGER NOT NULL,
+  payload TEXT NOT NULL,
+  received_at TEXT NOT NULL
+);
@@ -0,0 +1,25 @@
+-- A/B experiments on group-facing copy; each funnel records the variant its cycle used
+-- +goose Up
+
+CREATE TABLE IF NOT EXISTS experiment (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  name TEXT NOT NULL,
+  setting_key TEXT NOT NULL,
+  variant_a TEXT NOT NULL,
+  variant_b TEXT NOT NULL,
+  excluded_groups TEXT NOT NULL DEFAULT '',
+  start_week TEXT NOT NULL,
+  cycles INTEGER NOT NULL,
+  stopped_at TEXT NOT NULL DEFAULT '',
+  created_by INTEGER NOT NULL,
+  created_at TEXT NOT NULL
+);
+
+-- At most one running experiment per setting
+CREATE UNIQUE INDEX IF NOT EXISTS idx_experiment_active ON experiment(setting_key) WHERE stopped_at = '';
+
+ALTER TABLE cycle_funnel
+ADD COLUMN experiment_id INTEGER NOT NULL DEFAULT 0;
+
+ALTER TABLE cycle_funnel
+ADD COLUMN variant TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,11 @@
+-- Custom holiday ranges of a group; a cycle whose quiz or pairing date falls inside one is skipped
+-- +goose Up
+
+CREATE TABLE IF NOT EXISTS holiday (
+  group_id INTEGER NOT NULL,
+  start_date TEXT NOT NULL,
+  end_date TEXT NOT NULL,
+  created_by INTEGER NOT NULL,
+  created_at TEXT NOT NULL,
+  PRIMARY KEY (group_id, start_date, end_date)
+);
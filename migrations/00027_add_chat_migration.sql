@@ -0,0 +1,8 @@
+-- Groups Telegram upgraded to supergroups: the old chat ID and the one that replaced it
+-- +goose Up
+
+CREATE TABLE IF NOT EXISTS chat_migration (
+  old_chat_id INTEGER PRIMARY KEY,
+  new_chat_id INTEGER NOT NULL,
+  recorded_at TEXT NOT NULL
+);
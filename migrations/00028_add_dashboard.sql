@@ -0,0 +1,8 @@
+-- Personal /dashboard messages the bot keeps edited in users' private chats; message_id 0 means resend on the next refresh
+-- +goose Up
+
+CREATE TABLE IF NOT EXISTS dashboard (
+  user_id INTEGER PRIMARY KEY,
+  message_id INTEGER NOT NULL DEFAULT 0,
+  updated_at TEXT NOT NULL
+);
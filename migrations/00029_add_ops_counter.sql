@@ -0,0 +1,9 @@
+-- Weekly totals of operational events (sends, failures, alerts) for the admins' weekly report
+-- +goose Up
+
+CREATE TABLE IF NOT EXISTS ops_counter (
+  week_start TEXT NOT NULL,
+  name TEXT NOT NULL,
+  count INTEGER NOT NULL,
+  PRIMARY KEY (week_start, name)
+);
@@ -0,0 +1,8 @@
+-- Pairs composed by an admin with /manual_pairs; ignore_history keeps them out of repeat exclusion
+-- +goose Up
+
+ALTER TABLE pair
+ADD COLUMN manual INTEGER NOT NULL DEFAULT 0;
+
+ALTER TABLE pair
+ADD COLUMN ignore_history INTEGER NOT NULL DEFAULT 0;
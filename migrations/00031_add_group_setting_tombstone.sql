@@ -0,0 +1,5 @@
+-- A cleared group setting stays as a tombstone, so its version keeps growing across a clear and a new value
+-- +goose Up
+
+ALTER TABLE group_setting
+ADD COLUMN deleted INTEGER NOT NULL DEFAULT 0;
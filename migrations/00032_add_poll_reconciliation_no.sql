@@ -0,0 +1,5 @@
+-- "No" votes of the closed poll, so every option of the poll is kept with its reconciliation
+-- +goose Up
+
+ALTER TABLE poll_reconciliation
+ADD COLUMN poll_no INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,8 @@
+-- Highest update ID that fell out of the processed_update ring, so older redeliveries are still dropped
+-- +goose Up
+
+CREATE TABLE IF NOT EXISTS update_cursor (
+  id INTEGER PRIMARY KEY CHECK (id = 1),
+  update_id INTEGER NOT NULL,
+  seen_at TEXT NOT NULL
+);
@@ -0,0 +1,9 @@
+-- GROUP_CHAT_IDS as last imported, so later edits of the env are offered to admins instead of applied on boot
+-- +goose Up
+
+CREATE TABLE IF NOT EXISTS env_import (
+  id INTEGER PRIMARY KEY CHECK (id = 1),
+  group_ids TEXT NOT NULL,
+  version INTEGER NOT NULL,
+  updated_at TEXT NOT NULL
+);
@@ -0,0 +1,152 @@
+// Package matching finds maximum cardinality matchings in general graphs.
+package matching
+
+// Maximum returns a maximum cardinality matching of an undirected graph with n vertices,
+// using Edmonds' blossom algorithm. mate[v] is the vertex matched with v, or -1.
+//
+// The matching is seeded greedily in edge order before augmenting, so among maximum
+// matchings those using earlier edges are preferred. The result depends only on the
+// order of edges, which makes it reproducible for a given input.
+func Maximum(n int, edges [][2]int) []int {
+	mate := make([]int, n)
+	for i := range mate {
+		mate[i] = -1
+	}
+	for _, e := range edges {
+		a, b := e[0], e[1]
+		if a != b && mate[a] == -1 && mate[b] == -1 {
+			mate[a] = b
+			mate[b] = a
+		}
+	}
+	return Augment(n, edges, mate)
+}
+
+// Augment grows the given matching to a maximum one over edges, leaving matched vertices matched.
+// Every edge of the initial matching must be in edges. The input slice is not modified.
+func Augment(n int, edges [][2]int, initial []int) []int {
+	m := &matcher{
+		n:       n,
+		adj:     make([][]int, n),
+		mate:    make([]int, n),
+		parent:  make([]int, n),
+		base:    make([]int, n),
+		used:    make([]bool, n),
+		blossom: make([]bool, n),
+	}
+	copy(m.mate, initial)
+
+	for _, e := range edges {
+		a, b := e[0], e[1]
+		if a == b {
+			continue
+		}
+		m.adj[a] = append(m.adj[a], b)
+		m.adj[b] = append(m.adj[b], a)
+	}
+
+	for root := 0; root < n; root++ {
+		if m.mate[root] != -1 {
+			continue
+		}
+		// Flip the augmenting path ending in v
+		for v := m.findPath(root); v != -1; {
+			pv := m.parent[v]
+			next := m.mate[pv]
+			m.mate[v] = pv
+			m.mate[pv] = v
+			v = next
+		}
+	}
+
+	return m.mate
+}
+
+type matcher struct {
+	n       int
+	adj     [][]int
+	mate    []int
+	parent  []int
+	base    []int
+	used    []bool
+	blossom []bool
+}
+
+// findPath searches for an augmenting path from root and returns its free end, or -1
+func (m *matcher) findPath(root int) int {
+	for i := 0; i < m.n; i++ {
+		m.used[i] = false
+		m.parent[i] = -1
+		m.base[i] = i
+	}
+
+	m.used[root] = true
+	queue := []int{root}
+	for len(queue) > 0 {
+		v := queue[0]
+		queue = queue[1:]
+
+		for _, to := range m.adj[v] {
+			if m.base[v] == m.base[to] || m.mate[v] == to {
+				continue
+			}
+
+			if to == root || (m.mate[to] != -1 && m.parent[m.mate[to]] != -1) {
+				// Odd cycle: contract the blossom into its base
+				curBase := m.lca(v, to)
+				for i := range m.blossom {
+					m.blossom[i] = false
+				}
+				m.markPath(v, curBase, to)
+				m.markPath(to, curBase, v)
+				for i := 0; i < m.n; i++ {
+					if m.blossom[m.base[i]] {
+						m.base[i] = curBase
+						if !m.used[i] {
+							m.used[i] = true
+							queue = append(queue, i)
+						}
+					}
+				}
+			} else if m.parent[to] == -1 {
+				m.parent[to] = v
+				if m.mate[to] == -1 {
+					return to
+				}
+				m.used[m.mate[to]] = true
+				queue = append(queue, m.mate[to])
+			}
+		}
+	}
+	return -1
+}
+
+// lca finds the base of the blossom formed by the alternating paths from a and b
+func (m *matcher) lca(a, b int) int {
+	seen := make([]bool, m.n)
+	for {
+		a = m.base[a]
+		seen[a] = true
+		if m.mate[a] == -1 {
+			break
+		}
+		a = m.parent[m.mate[a]]
+	}
+	for {
+		b = m.base[b]
+		if seen[b] {
+			return b
+		}
+		b = m.parent[m.mate[b]]
+	}
+}
+
+func (m *matcher) markPath(v, b, child int) {
+	for m.base[v] != b {
+		m.blossom[m.base[v]] = true
+		m.blossom[m.base[m.mate[v]]] = true
+		m.parent[v] = child
+		child = m.mate[v]
+		v = m.parent[m.mate[v]]
+	}
+}
@@ -0,0 +1,128 @@
+package pairing
+
+import (
+	"context"
+	"fmt"
+)
+
+// Exclusions keeps listed couples of users out of the same meeting.
+//
+// An excluded pair is first swapped with another pair when neither new pair is excluded.
+// Swaps prefer partners who haven't met; one that repeats a previous meeting is made only when
+// there is no other, and marks the proposal's history as relaxed.
+// Without a possible swap the pair is vetoed and both members stay unpaired.
+// In a trio, one member of an excluded couple is moved out to the unpaired, until no excluded
+// couple is left in it.
+type Exclusions struct {
+	excluded map[[2]int64]bool
+}
+
+// NewExclusions builds the processor from couples of user IDs
+func NewExclusions(couples [][2]int64) *Exclusions {
+	e := &Exclusions{excluded: make(map[[2]int64]bool, len(couples)*2)}
+	for _, c := range couples {
+		e.excluded[c] = true
+		e.excluded[[2]int64{c[1], c[0]}] = true
+	}
+	return e
+}
+
+// Couples returns the excluded couples, each once
+func (e *Exclusions) Couples() [][2]int64 {
+	couples := make([][2]int64, 0, len(e.excluded)/2)
+	for c := range e.excluded {
+		if c[0] < c[1] {
+			couples = append(couples, c)
+		}
+	}
+	return couples
+}
+
+// conflict returns an excluded couple within the meeting
+func (e *Exclusions) conflict(meeting []int64) (int64, int64, bool) {
+	for i, a := range meeting {
+		for _, b := range meeting[i+1:] {
+			if e.excluded[[2]int64{a, b}] {
+				return a, b, true
+			}
+		}
+	}
+	return 0, 0, false
+}
+
+func (e *Exclusions) Adjust(_ context.Context, proposal Proposal, snapshot Snapshot) (Proposal, error) {
+	met := coupleSet(snapshot.Met)
+	meetings := proposal.Meetings
+	for i := range meetings {
+		// A trio may hold two excluded couples, so the meeting is fixed until none is left
+		for {
+			a, b, found := e.conflict(meetings[i])
+			if !found {
+				break
+			}
+
+			if m := meetings[i]; len(m) == 3 {
+				rest := make([]int64, 0, 2)
+				for _, id := range m {
+					if id != b {
+						rest = append(rest, id)
+					}
+				}
+				meetings[i] = rest
+				proposal.Unpaired = append(proposal.Unpaired, b)
+				proposal.Notes = append(proposal.Notes, fmt.Sprintf("exclusion: %d moved out of a trio with %d", b, a))
+				continue
+			}
+
+			if j, c, d, repeat, ok := e.findSwap(meetings, i, met); ok {
+				meetings[i] = []int64{a, c}
+				meetings[j] = []int64{b, d}
+				note := fmt.Sprintf("exclusion: %d and %d swapped partners with %d and %d", a, b, c, d)
+				if repeat {
+					proposal.RelaxedHistory = true
+					note += ", repeating a previous meeting"
+				}
+				proposal.Notes = append(proposal.Notes, note)
+				continue
+			}
+
+			meetings[i] = nil
+			proposal.Unpaired = append(proposal.Unpaired, a, b)
+			proposal.Notes = append(proposal.Notes, fmt.Sprintf("exclusion: pair of %d and %d vetoed, no swap possible", a, b))
+		}
+	}
+
+	kept := make([][]int64, 0, len(meetings))
+	for _, m := range meetings {
+		if m != nil {
+			kept = append(kept, m)
+		}
+	}
+	proposal.Meetings = kept
+	return proposal, nil
+}
+
+// findSwap looks for another pair j whose members can trade partners with the excluded pair at i,
+// so that the first member of pair i meets c and the second meets d. A swap between people who
+// haven't met is preferred; repeat tells that the only swap found repeats a previous meeting.
+func (e *Exclusions) findSwap(meetings [][]int64, i int, met map[[2]int64]bool) (j int, c, d int64, repeat, ok bool) {
+	a, b := meetings[i][0], meetings[i][1]
+	for k, other := range meetings {
+		if k == i || len(other) != 2 {
+			continue
+		}
+		for _, cd := range [][2]int64{{other[0], other[1]}, {other[1], other[0]}} {
+			if e.excluded[[2]int64{a, cd[0]}] || e.excluded[[2]int64{b, cd[1]}] {
+				continue
+			}
+			repeats := met[[2]int64{min(a, cd[0]), max(a, cd[0])}] || met[[2]int64{min(b, cd[1]), max(b, cd[1])}]
+			if !repeats {
+				return k, cd[0], cd[1], false, true
+			}
+			if !ok {
+				j, c, d, repeat, ok = k, cd[0], cd[1], true, true
+			}
+		}
+	}
+	return j, c, d, repeat, ok
+}
@@ -0,0 +1,96 @@
+package pairing
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func adjustExclusions(t *testing.T, couples [][2]int64, proposal Proposal, snapshot Snapshot) Proposal {
+	t.Helper()
+	got, err := Apply(context.Background(), []PostProcessor{NewExclusions(couples)}, proposal, snapshot)
+	if err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	return got
+}
+
+func TestExclusionsSwapsPartners(t *testing.T) {
+	snapshot := Snapshot{Participants: []int64{1, 2, 3, 4}}
+	got := adjustExclusions(t, [][2]int64{{1, 2}}, Proposal{Meetings: [][]int64{{1, 2}, {3, 4}}}, snapshot)
+
+	want := [][]int64{{1, 3}, {2, 4}}
+	if !reflect.DeepEqual(got.Meetings, want) {
+		t.Fatalf("meetings = %v, want %v", got.Meetings, want)
+	}
+	if got.RelaxedHistory {
+		t.Fatal("a swap between people who never met relaxed the history")
+	}
+	if len(got.Notes) != 1 {
+		t.Fatalf("notes = %q, want the swap explained", got.Notes)
+	}
+}
+
+func TestExclusionsSwapAvoidsRepeats(t *testing.T) {
+	// 1 and 3 met before, so 1 should get 4 rather than 3
+	snapshot := Snapshot{Participants: []int64{1, 2, 3, 4}, Met: [][2]int64{{3, 1}}}
+	got := adjustExclusions(t, [][2]int64{{1, 2}}, Proposal{Meetings: [][]int64{{1, 2}, {3, 4}}}, snapshot)
+
+	want := [][]int64{{1, 4}, {2, 3}}
+	if !reflect.DeepEqual(got.Meetings, want) {
+		t.Fatalf("meetings = %v, want %v", got.Meetings, want)
+	}
+	if got.RelaxedHistory {
+		t.Fatal("history relaxed although a fresh swap existed")
+	}
+}
+
+func TestExclusionsRepeatSwapRelaxesHistory(t *testing.T) {
+	snapshot := Snapshot{Participants: []int64{1, 2, 3, 4}, Met: [][2]int64{{1, 3}, {1, 4}}}
+	got := adjustExclusions(t, [][2]int64{{1, 2}}, Proposal{Meetings: [][]int64{{1, 2}, {3, 4}}}, snapshot)
+
+	if len(got.Meetings) != 2 || len(got.Unpaired) != 0 {
+		t.Fatalf("proposal = %+v, want the pair swapped", got)
+	}
+	if !got.RelaxedHistory {
+		t.Fatal("a swap repeating a meeting left the history strict")
+	}
+	if violations := CheckQuality(got, snapshot, 0); len(violations) > 0 {
+		t.Fatalf("CheckQuality = %v", violations)
+	}
+}
+
+func TestExclusionsVetoesWithoutSwap(t *testing.T) {
+	snapshot := Snapshot{Participants: []int64{1, 2, 3, 4}}
+	got := adjustExclusions(t, [][2]int64{{1, 2}, {1, 3}, {1, 4}}, Proposal{Meetings: [][]int64{{1, 2}, {3, 4}}}, snapshot)
+
+	if !reflect.DeepEqual(got.Meetings, [][]int64{{3, 4}}) || !reflect.DeepEqual(got.Unpaired, []int64{1, 2}) {
+		t.Fatalf("proposal = %+v, want 1 and 2 vetoed", got)
+	}
+}
+
+func TestExclusionsTrioWithTwoExcludedCouples(t *testing.T) {
+	snapshot := Snapshot{Participants: []int64{1, 2, 3, 4, 5}}
+	couples := [][2]int64{{1, 2}, {1, 3}}
+	got := adjustExclusions(t, couples, Proposal{Meetings: [][]int64{{1, 2, 3}, {4, 5}}}, snapshot)
+
+	snapshot.Excluded = couples
+	if violations := CheckQuality(got, snapshot, 5); len(violations) > 0 {
+		t.Fatalf("CheckQuality = %v for %+v", violations, got)
+	}
+	for _, m := range got.Meetings {
+		if _, _, found := NewExclusions(couples).conflict(m); found {
+			t.Fatalf("meeting %v still holds an excluded couple", m)
+		}
+	}
+}
+
+func TestExclusionsLeaveCleanProposal(t *testing.T) {
+	snapshot := Snapshot{Participants: []int64{1, 2, 3, 4, 5}}
+	proposal := Proposal{Meetings: [][]int64{{1, 3}, {2, 4, 5}}}
+	got := adjustExclusions(t, [][2]int64{{1, 2}}, proposal, snapshot)
+
+	if !reflect.DeepEqual(got.Meetings, proposal.Meetings) || len(got.Notes) != 0 {
+		t.Fatalf("proposal = %+v, want it unchanged", got)
+	}
+}
@@ -0,0 +1,125 @@
+package pairing
+
+import (
+	"math/rand"
+	"sort"
+
+	"example.com/random_coffee/pkg/matching"
+)
+
+// Match builds the largest possible set of meetings from the still-allowed couples.
+// Candidate couples are shuffled with rng, couples for which preferred returns true are tried first,
+// and a maximum matching is taken so nobody is left out by an unlucky early choice.
+//
+// If history rules out a full matching, repeats (ordered by previous meeting, oldest first)
+// are used to pair the rest instead of leaving two or more people out. Anyone still unmatched
+// joins a pair, preferably one whose members they have both never met, forming a trio.
+// It also reports whether any meeting repeats a previous one. Couples naming someone who is not
+// among the participants are ignored; preferred may be nil.
+func Match(participants []int64, available, repeats [][2]int64, preferred func(a, b int64) bool, rng *rand.Rand) ([][]int64, bool) {
+	index := make(map[int64]int, len(participants))
+	for i, id := range participants {
+		index[id] = i
+	}
+	toEdges := func(couples [][2]int64) [][2]int {
+		edges := make([][2]int, 0, len(couples))
+		for _, c := range couples {
+			a, ok1 := index[c[0]]
+			b, ok2 := index[c[1]]
+			if ok1 && ok2 && a != b {
+				edges = append(edges, [2]int{a, b})
+			}
+		}
+		return edges
+	}
+	isPreferred := func(c [2]int64) bool {
+		return preferred != nil && preferred(c[0], c[1])
+	}
+
+	candidates := make([][2]int64, len(available))
+	copy(candidates, available)
+	rng.Shuffle(len(candidates), func(i, j int) { candidates[i], candidates[j] = candidates[j], candidates[i] })
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return isPreferred(candidates[i]) && !isPreferred(candidates[j])
+	})
+
+	fresh := make(map[[2]int]bool)
+	edges := toEdges(candidates)
+	for _, e := range edges {
+		fresh[e] = true
+		fresh[[2]int{e[1], e[0]}] = true
+	}
+
+	mate := matching.Maximum(len(participants), edges)
+	if countUnmatched(mate) >= 2 {
+		// Fresh couples are kept; repeats only extend the matching
+		for _, e := range toEdges(repeats) {
+			if !fresh[e] {
+				edges = append(edges, e)
+			}
+		}
+		mate = matching.Augment(len(participants), edges, mate)
+	}
+
+	meetings := make([][]int, 0, len(participants)/2)
+	unmatched := make([]int, 0)
+	for i, j := range mate {
+		switch {
+		case j == -1:
+			unmatched = append(unmatched, i)
+		case i < j:
+			meetings = append(meetings, []int{i, j})
+		}
+	}
+	rng.Shuffle(len(meetings), func(i, j int) { meetings[i], meetings[j] = meetings[j], meetings[i] })
+
+	// Fold leftovers into pairs, at most one extra person per pair. Without repeats
+	// the leftover must not have met either member; with them, fewer repeats win.
+	repeatsAllowed := len(repeats) > 0
+	for _, u := range unmatched {
+		best, bestFresh := -1, -1
+		for k, m := range meetings {
+			if len(m) != 2 {
+				continue
+			}
+			freshCount := 0
+			for _, p := range m {
+				if fresh[[2]int{u, p}] {
+					freshCount++
+				}
+			}
+			if freshCount > bestFresh && (freshCount == 2 || repeatsAllowed) {
+				best, bestFresh = k, freshCount
+			}
+		}
+		if best >= 0 {
+			meetings[best] = append(meetings[best], u)
+		}
+	}
+
+	result := make([][]int64, 0, len(meetings))
+	repeated := false
+	for _, m := range meetings {
+		ids := make([]int64, 0, len(m))
+		for i, p := range m {
+			ids = append(ids, participants[p])
+			for _, q := range m[i+1:] {
+				if !fresh[[2]int{p, q}] {
+					repeated = true
+				}
+			}
+		}
+		result = append(result, ids)
+	}
+	return result, repeated
+}
+
+func countUnmatched(mate []int) int {
+	n := 0
+	for _, m := range mate {
+		if m == -1 {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,72 @@
+package pairing
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+// allCouples lists every couple of the given IDs
+func allCouples(ids []int64) [][2]int64 {
+	var couples [][2]int64
+	for i, a := range ids {
+		for _, b := range ids[i+1:] {
+			couples = append(couples, [2]int64{a, b})
+		}
+	}
+	return couples
+}
+
+func TestMatchPairsEveryone(t *testing.T) {
+	ids := []int64{1, 2, 3, 4, 5}
+	meetings, repeated := Match(ids, allCouples(ids), nil, nil, rand.New(rand.NewSource(1)))
+	if repeated {
+		t.Fatal("fresh couples reported as repeats")
+	}
+	snapshot := Snapshot{Participants: ids}
+	if err := Validate(Proposal{Meetings: meetings}, snapshot); err != nil {
+		t.Fatalf("meetings %v: %v", meetings, err)
+	}
+	if len(meetings) != 2 {
+		t.Fatalf("meetings = %v, want a pair and a trio", meetings)
+	}
+}
+
+func TestMatchFallsBackToRepeats(t *testing.T) {
+	// 1-2 and 3-4 have met; only 1-3 is fresh, so 2 and 4 must repeat or stay out
+	ids := []int64{1, 2, 3, 4}
+	available := [][2]int64{{1, 3}}
+	meetings, repeated := Match(ids, available, allCouples(ids), nil, rand.New(rand.NewSource(1)))
+	if !repeated || len(meetings) != 2 {
+		t.Fatalf("meetings = %v, repeated = %v; want two meetings with a repeat", meetings, repeated)
+	}
+
+	meetings, repeated = Match(ids, available, nil, nil, rand.New(rand.NewSource(1)))
+	if repeated || !reflect.DeepEqual(meetings, [][]int64{{1, 3}}) {
+		t.Fatalf("meetings = %v without repeats, want only the fresh couple", meetings)
+	}
+}
+
+func TestMatchTriesPreferredCouplesFirst(t *testing.T) {
+	ids := []int64{1, 2, 3, 4}
+	preferred := func(a, b int64) bool { return a+b == 5 && (a == 1 || b == 1) } // 1 with 4
+	for seed := int64(0); seed < 20; seed++ {
+		meetings, _ := Match(ids, allCouples(ids), nil, preferred, rand.New(rand.NewSource(seed)))
+		found := false
+		for _, m := range meetings {
+			if reflect.DeepEqual(m, []int64{1, 4}) || reflect.DeepEqual(m, []int64{4, 1}) {
+				found = true
+			}
+		}
+		if !found {
+			t.Fatalf("seed %d: meetings = %v, want 1 with 4", seed, meetings)
+		}
+	}
+}
+
+func TestMatchIgnoresUnknownUsers(t *testing.T) {
+	meetings, _ := Match([]int64{1, 2}, [][2]int64{{1, 9}, {1, 2}}, nil, nil, rand.New(rand.NewSource(1)))
+	if !reflect.DeepEqual(meetings, [][]int64{{1, 2}}) {
+		t.Fatalf("meetings = %v", meetings)
+	}
+}
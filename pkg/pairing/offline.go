@@ -0,0 +1,232 @@
+package pairing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"time"
+)
+
+// PastMeeting is a meeting from the exported history of an offline run. Users are named by the IDs
+// of the export, which may be anonymized.
+type PastMeeting struct {
+	WeekStart string
+	Members   []string
+}
+
+// Policy is what an offline run matches under; the zero value behaves like production
+type Policy struct {
+	WeekStart         string // cycle being matched, in 2006-01-02 form
+	RepeatWindowWeeks int    // only meetings this recent count as met; 0 counts all history
+	NoRepeats         bool   // never fall back to repeat meetings
+	Volunteers        []string
+	Exclusions        [][2]string // couples an admin listed to keep apart
+	Avoided           [][2]string // couples a participant privately asked to keep apart
+	UnpairedTolerance int         // passed to CheckQuality
+}
+
+// OfflineResult is the outcome of an offline run, in the IDs of the export
+type OfflineResult struct {
+	Meetings       [][]string
+	Unpaired       []string
+	RepeatMeetings int // meetings with at least one couple that met before, per the full history
+	RepeatCouples  int
+	Trace          []string // the decisions of the run, in order
+}
+
+// RunOffline reproduces a pairing run from exported data, for what-if analysis of a policy: candidate
+// couples are derived from the history the way the database queries do, then matched, post-processed
+// and checked as in production. The same input and seed give the same result. A result that fails
+// the quality check is returned as a *QualityError.
+func RunOffline(ctx context.Context, participants []string, history []PastMeeting, policy Policy, seed int64) (*OfflineResult, error) {
+	result := &OfflineResult{Meetings: make([][]string, 0), Unpaired: make([]string, 0), Trace: make([]string, 0)}
+	trace := func(format string, args ...any) {
+		result.Trace = append(result.Trace, fmt.Sprintf(format, args...))
+	}
+
+	// The matcher works on user IDs; exported IDs may be anonymized, so they get positional ones
+	userIDs := make(map[string]int64, len(participants))
+	ids := make([]int64, 0, len(participants))
+	for i, p := range participants {
+		if _, dup := userIDs[p]; dup {
+			return nil, fmt.Errorf("participant %s is listed twice", p)
+		}
+		userIDs[p] = int64(i + 1)
+		ids = append(ids, int64(i+1))
+	}
+	if len(ids) < 2 {
+		return nil, errors.New("at least two participants are needed")
+	}
+	couple := func(a, b int64) [2]int64 { return [2]int64{min(a, b), max(a, b)} }
+	toCouples := func(named [][2]string) [][2]int64 {
+		couples := make([][2]int64, 0, len(named))
+		for _, c := range named {
+			a, okA := userIDs[c[0]]
+			b, okB := userIDs[c[1]]
+			if okA && okB {
+				couples = append(couples, couple(a, b))
+			}
+		}
+		return couples
+	}
+
+	cutoff := ""
+	if policy.RepeatWindowWeeks > 0 {
+		start, err := time.Parse("2006-01-02", policy.WeekStart)
+		if err != nil {
+			return nil, fmt.Errorf("bad week_start %q: %w", policy.WeekStart, err)
+		}
+		cutoff = start.AddDate(0, 0, -7*policy.RepeatWindowWeeks).Format("2006-01-02")
+		trace("only meetings since %s count as met", cutoff)
+	}
+
+	// Latest meeting of every couple of participants, and whether it is recent enough to rule them out
+	lastMet := make(map[[2]int64]string)
+	metRecently := make(map[[2]int64]bool)
+	everPaired := make(map[int64]bool)
+	for _, h := range history {
+		for i, a := range h.Members {
+			for _, b := range h.Members[i+1:] {
+				ua, okA := userIDs[a]
+				ub, okB := userIDs[b]
+				if !okA || !okB {
+					continue
+				}
+				key := couple(ua, ub)
+				if h.WeekStart > lastMet[key] {
+					lastMet[key] = h.WeekStart
+				}
+				if h.WeekStart >= cutoff {
+					metRecently[key] = true
+				}
+			}
+		}
+		for _, m := range h.Members {
+			if id, ok := userIDs[m]; ok {
+				everPaired[id] = true
+			}
+		}
+	}
+
+	// Listed and avoided couples are never candidates, not even as repeats
+	excluded := append(toCouples(policy.Exclusions), toCouples(policy.Avoided)...)
+	kept := coupleSet(excluded)
+
+	available := make([][2]int64, 0)
+	all := make([][2]int64, 0)
+	for i, a := range ids {
+		for _, b := range ids[i+1:] {
+			c := [2]int64{a, b}
+			if kept[c] {
+				continue
+			}
+			all = append(all, c)
+			if !metRecently[c] {
+				available = append(available, c)
+			}
+		}
+	}
+	trace("%d participants, %d of %d couples have not met, %d couples kept apart", len(ids), len(available), len(all), len(excluded))
+
+	rng := rand.New(rand.NewSource(seed))
+
+	// Same order as the repeat query: never met first, then oldest meeting first, ties random
+	var repeats [][2]int64
+	if !policy.NoRepeats {
+		repeats = all
+		rng.Shuffle(len(repeats), func(i, j int) { repeats[i], repeats[j] = repeats[j], repeats[i] })
+		sort.SliceStable(repeats, func(i, j int) bool {
+			a, b := lastMet[repeats[i]], lastMet[repeats[j]]
+			if (a == "") != (b == "") {
+				return a == ""
+			}
+			return a < b
+		})
+	}
+
+	firstTimers, volunteers := make(map[int64]bool), make(map[int64]bool)
+	for _, id := range ids {
+		if !everPaired[id] {
+			firstTimers[id] = true
+		}
+	}
+	for _, v := range policy.Volunteers {
+		if id, ok := userIDs[v]; ok {
+			volunteers[id] = true
+		}
+	}
+	trace("%d first-timers, %d volunteers among participants", len(firstTimers), len(volunteers))
+	buddy := func(a, b int64) bool {
+		return (firstTimers[a] && volunteers[b] && !firstTimers[b]) || (firstTimers[b] && volunteers[a] && !firstTimers[a])
+	}
+
+	meetings, repeated := Match(ids, available, repeats, buddy, rng)
+	if repeated {
+		trace("not enough new couples, some meetings repeat")
+	}
+
+	proposal := Proposal{Meetings: meetings, RelaxedHistory: repeated}
+	used := make(map[int64]bool)
+	for _, m := range meetings {
+		for _, id := range m {
+			used[id] = true
+		}
+	}
+	for _, id := range ids {
+		if !used[id] {
+			proposal.Unpaired = append(proposal.Unpaired, id)
+		}
+	}
+
+	// Only couples inside the repeat window count as met, as they did for matching
+	met := make([][2]int64, 0, len(metRecently))
+	for c := range metRecently {
+		met = append(met, c)
+	}
+	sort.Slice(met, func(i, j int) bool { return met[i][0] < met[j][0] || (met[i][0] == met[j][0] && met[i][1] < met[j][1]) })
+	snapshot := Snapshot{WeekStart: policy.WeekStart, Participants: ids, Excluded: excluded, Met: met}
+
+	processors := make([]PostProcessor, 0, 2)
+	for _, named := range [][][2]string{policy.Exclusions, policy.Avoided} {
+		if couples := toCouples(named); len(couples) > 0 {
+			processors = append(processors, NewExclusions(couples))
+		}
+	}
+	proposal, err := Apply(ctx, processors, proposal, snapshot)
+	if err != nil {
+		return nil, fmt.Errorf("post-processing failed: %w", err)
+	}
+	for _, note := range proposal.Notes {
+		// Notes name users by the positional IDs
+		trace("%s (IDs are positions in the input, starting at 1)", note)
+	}
+	if violations := CheckQuality(proposal, snapshot, policy.UnpairedTolerance); len(violations) > 0 {
+		return nil, &QualityError{Violations: violations, Proposal: proposal, Snapshot: snapshot}
+	}
+
+	for _, m := range proposal.Meetings {
+		named := make([]string, 0, len(m))
+		repeatedCouples := 0
+		for i, a := range m {
+			named = append(named, participants[a-1])
+			for _, b := range m[i+1:] {
+				if lastMet[couple(a, b)] != "" {
+					repeatedCouples++
+				}
+			}
+		}
+		if repeatedCouples > 0 {
+			result.RepeatMeetings++
+			result.RepeatCouples += repeatedCouples
+		}
+		result.Meetings = append(result.Meetings, named)
+	}
+	unpaired := append([]int64(nil), proposal.Unpaired...)
+	sort.Slice(unpaired, func(i, j int) bool { return unpaired[i] < unpaired[j] })
+	for _, id := range unpaired {
+		result.Unpaired = append(result.Unpaired, participants[id-1])
+	}
+	return result, nil
+}
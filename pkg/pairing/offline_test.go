@@ -0,0 +1,96 @@
+package pairing
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func offlineParticipants() []string {
+	return []string{"a", "b", "c", "d", "e", "f", "g"}
+}
+
+// together reports whether the two users share a meeting
+func together(meetings [][]string, x, y string) bool {
+	for _, m := range meetings {
+		hasX, hasY := false, false
+		for _, id := range m {
+			hasX = hasX || id == x
+			hasY = hasY || id == y
+		}
+		if hasX && hasY {
+			return true
+		}
+	}
+	return false
+}
+
+func TestRunOfflineIsDeterministic(t *testing.T) {
+	history := []PastMeeting{{WeekStart: "2026-02-23", Members: []string{"a", "b"}}}
+	policy := Policy{WeekStart: "2026-03-02", Volunteers: []string{"a"}}
+
+	first, err := RunOffline(context.Background(), offlineParticipants(), history, policy, 42)
+	if err != nil {
+		t.Fatalf("RunOffline: %v", err)
+	}
+	for i := 0; i < 5; i++ {
+		again, err := RunOffline(context.Background(), offlineParticipants(), history, policy, 42)
+		if err != nil {
+			t.Fatalf("RunOffline: %v", err)
+		}
+		if !reflect.DeepEqual(first, again) {
+			t.Fatalf("the same seed gave\n%+v\nand\n%+v", first, again)
+		}
+	}
+	if together(first.Meetings, "a", "b") || first.RepeatMeetings != 0 {
+		t.Fatalf("meetings = %v, want a and b kept apart", first.Meetings)
+	}
+}
+
+func TestRunOfflineKeepsCouplesApart(t *testing.T) {
+	policy := Policy{WeekStart: "2026-03-02", Exclusions: [][2]string{{"a", "b"}}, Avoided: [][2]string{{"c", "d"}, {"e", "zz"}}}
+	for seed := int64(0); seed < 30; seed++ {
+		result, err := RunOffline(context.Background(), offlineParticipants(), nil, policy, seed)
+		if err != nil {
+			t.Fatalf("seed %d: %v", seed, err)
+		}
+		if together(result.Meetings, "a", "b") || together(result.Meetings, "c", "d") {
+			t.Fatalf("seed %d: meetings = %v, want a-b and c-d apart", seed, result.Meetings)
+		}
+		if len(result.Unpaired) != 0 {
+			t.Fatalf("seed %d: unpaired %v", seed, result.Unpaired)
+		}
+	}
+}
+
+func TestRunOfflineRepeatWindow(t *testing.T) {
+	participants := []string{"a", "b"}
+	history := []PastMeeting{{WeekStart: "2025-12-01", Members: []string{"a", "b"}}}
+
+	// The meeting is thirteen weeks old: outside a 12-week window it no longer counts as met
+	result, err := RunOffline(context.Background(), participants, history, Policy{WeekStart: "2026-03-02", RepeatWindowWeeks: 12, NoRepeats: true}, 1)
+	if err != nil {
+		t.Fatalf("RunOffline: %v", err)
+	}
+	if len(result.Meetings) != 1 || result.RepeatMeetings != 1 || result.RepeatCouples != 1 {
+		t.Fatalf("result = %+v, want the old couple matched and counted as a repeat", result)
+	}
+
+	// With all history counting and no repeats allowed they stay apart, which the quality check accepts
+	result, err = RunOffline(context.Background(), participants, history, Policy{WeekStart: "2026-03-02", NoRepeats: true}, 1)
+	if err != nil {
+		t.Fatalf("RunOffline: %v", err)
+	}
+	if len(result.Meetings) != 0 || !reflect.DeepEqual(result.Unpaired, participants) {
+		t.Fatalf("result = %+v, want both unpaired", result)
+	}
+}
+
+func TestRunOfflineRejectsBadInput(t *testing.T) {
+	if _, err := RunOffline(context.Background(), []string{"a", "a"}, nil, Policy{}, 1); err == nil {
+		t.Fatal("duplicate participant accepted")
+	}
+	if _, err := RunOffline(context.Background(), []string{"a", "b"}, nil, Policy{RepeatWindowWeeks: 4, WeekStart: "soon"}, 1); err == nil {
+		t.Fatal("bad week_start accepted")
+	}
+}
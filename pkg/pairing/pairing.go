@@ -0,0 +1,107 @@
+// Package pairing lets a deployment adjust the proposed meetings of a pairing run before they are saved.
+package pairing
+
+import (
+	"context"
+	"fmt"
+)
+
+// Proposal is the outcome of matching, as user IDs.
+type Proposal struct {
+	Meetings [][]int64 // pairs and trios
+	Unpaired []int64   // participants left without a meeting
+	Notes    []string  // why processors changed the proposal, for the run log
+
+	// RelaxedHistory is set when the run fell back to repeat meetings or a processor rearranged
+	// meetings without consulting the history; only then may couples that met before meet again
+	RelaxedHistory bool
+}
+
+// Snapshot is what a pairing run started from.
+type Snapshot struct {
+	GroupID      int64
+	WeekStart    string
+	Participants []int64
+	Excluded     [][2]int64 // couples that must never meet
+	Met          [][2]int64 // couples of participants that met before
+}
+
+// PostProcessor adjusts a proposal after matching and before it is saved. It may veto meetings
+// (moving their members to Unpaired) or swap members between meetings, and should add a note
+// for every change. The returned proposal must keep the invariants checked by Validate.
+// An error fails the run: nothing is saved.
+type PostProcessor interface {
+	Adjust(ctx context.Context, proposal Proposal, snapshot Snapshot) (Proposal, error)
+}
+
+// Validate checks the invariants of a proposal: every meeting has 2 or 3 members,
+// and every participant appears exactly once, in a meeting or among the unpaired.
+func Validate(proposal Proposal, snapshot Snapshot) error {
+	participants := make(map[int64]bool, len(snapshot.Participants))
+	for _, id := range snapshot.Participants {
+		participants[id] = true
+	}
+
+	seen := make(map[int64]bool, len(snapshot.Participants))
+	place := func(id int64) error {
+		if !participants[id] {
+			return fmt.Errorf("user %d is not a participant", id)
+		}
+		if seen[id] {
+			return fmt.Errorf("user %d appears more than once", id)
+		}
+		seen[id] = true
+		return nil
+	}
+
+	for _, m := range proposal.Meetings {
+		if len(m) < 2 || len(m) > 3 {
+			return fmt.Errorf("meeting %v has %d members, want 2 or 3", m, len(m))
+		}
+		for _, id := range m {
+			if err := place(id); err != nil {
+				return err
+			}
+		}
+	}
+	for _, id := range proposal.Unpaired {
+		if err := place(id); err != nil {
+			return err
+		}
+	}
+
+	if len(seen) != len(participants) {
+		return fmt.Errorf("%d of %d participants are missing", len(participants)-len(seen), len(participants))
+	}
+	return nil
+}
+
+// Apply runs the processors in order, validating the proposal after each of them
+func Apply(ctx context.Context, processors []PostProcessor, proposal Proposal, snapshot Snapshot) (Proposal, error) {
+	for _, pp := range processors {
+		adjusted, err := pp.Adjust(ctx, proposal.clone(), snapshot)
+		if err != nil {
+			return proposal, fmt.Errorf("%T: %w", pp, err)
+		}
+		if err := Validate(adjusted, snapshot); err != nil {
+			return proposal, fmt.Errorf("%T broke the proposal: %w", pp, err)
+		}
+		proposal = adjusted
+	}
+	return proposal, nil
+}
+
+// clone copies the proposal so a failing processor can't leave it half-changed
+func (p Proposal) clone() Proposal {
+	c := Proposal{
+		Meetings: make([][]int64, len(p.Meetings)),
+		Unpaired: append([]int64(nil), p.Unpaired...),
+		Notes:    append([]string(nil), p.Notes...),
+
+		RelaxedHistory: p.RelaxedHistory,
+	}
+	for i, m := range p.Meetings {
+		c.Meetings[i] = append([]int64(nil), m...)
+	}
+	return c
+}
@@ -0,0 +1,99 @@
+package pairing
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// processorFunc turns a function into a PostProcessor
+type processorFunc func(Proposal) (Proposal, error)
+
+func (f processorFunc) Adjust(_ context.Context, proposal Proposal, _ Snapshot) (Proposal, error) {
+	return f(proposal)
+}
+
+func testSnapshot() Snapshot {
+	return Snapshot{GroupID: -100, WeekStart: "2026-10-12", Participants: []int64{1, 2, 3, 4, 5}}
+}
+
+func testProposal() Proposal {
+	return Proposal{Meetings: [][]int64{{1, 2}, {3, 4}}, Unpaired: []int64{5}}
+}
+
+func TestApplyRejectsMisbehavingProcessors(t *testing.T) {
+	tests := []struct {
+		name   string
+		adjust func(Proposal) (Proposal, error)
+		want   string
+	}{
+		{"meeting of one", func(p Proposal) (Proposal, error) {
+			p.Meetings[0] = p.Meetings[0][:1]
+			return p, nil
+		}, "has 1 members"},
+		{"meeting of four", func(p Proposal) (Proposal, error) {
+			p.Meetings = [][]int64{{1, 2, 3, 4}}
+			return p, nil
+		}, "has 4 members"},
+		{"user placed twice", func(p Proposal) (Proposal, error) {
+			p.Unpaired = append(p.Unpaired, 1)
+			return p, nil
+		}, "appears more than once"},
+		{"stranger added", func(p Proposal) (Proposal, error) {
+			p.Unpaired = append(p.Unpaired, 99)
+			return p, nil
+		}, "not a participant"},
+		{"participant dropped", func(p Proposal) (Proposal, error) {
+			p.Unpaired = nil
+			return p, nil
+		}, "missing"},
+		{"processor error", func(p Proposal) (Proposal, error) {
+			p.Meetings = nil
+			return p, errors.New("rule engine down")
+		}, "rule engine down"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			in := testProposal()
+			got, err := Apply(context.Background(), []PostProcessor{processorFunc(tt.adjust)}, in, testSnapshot())
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("Apply error = %v, want one mentioning %q", err, tt.want)
+			}
+			// The proposal passed in comes back untouched, however the processor mangled its copy
+			if len(got.Meetings) != 2 || len(got.Meetings[0]) != 2 || len(got.Unpaired) != 1 {
+				t.Fatalf("Apply returned %+v, want the proposal it was given", got)
+			}
+			if len(in.Meetings[0]) != 2 || len(in.Unpaired) != 1 {
+				t.Fatalf("Apply changed the caller's proposal: %+v", in)
+			}
+		})
+	}
+}
+
+func TestApplyRunsProcessorsInOrder(t *testing.T) {
+	veto := processorFunc(func(p Proposal) (Proposal, error) {
+		p.Meetings = p.Meetings[1:]
+		p.Unpaired = append(p.Unpaired, 1, 2)
+		p.Notes = append(p.Notes, "veto 1 and 2")
+		return p, nil
+	})
+	join := processorFunc(func(p Proposal) (Proposal, error) {
+		if len(p.Meetings) != 1 {
+			t.Fatalf("second processor got %+v, want the first one's result", p)
+		}
+		p.Meetings = [][]int64{{3, 4, 5}}
+		p.Unpaired = []int64{1, 2}
+		p.Notes = append(p.Notes, "5 joins 3 and 4")
+		return p, nil
+	})
+
+	got, err := Apply(context.Background(), []PostProcessor{veto, join}, testProposal(), testSnapshot())
+	if err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	if len(got.Meetings) != 1 || len(got.Meetings[0]) != 3 || len(got.Notes) != 2 {
+		t.Fatalf("Apply = %+v", got)
+	}
+}
@@ -0,0 +1,153 @@
+package pairing
+
+import (
+	"fmt"
+	"strings"
+
+	"example.com/random_coffee/pkg/matching"
+)
+
+// Kinds of quality violations
+const (
+	ViolationSelfPair  = "self_pair" // a user meets themselves
+	ViolationDuplicate = "duplicate" // a user is placed twice
+	ViolationStructure = "structure" // a meeting of the wrong size, a stranger or a missing participant
+	ViolationExcluded  = "excluded"  // an excluded couple meets
+	ViolationRepeat    = "repeat"    // a couple meets again without the repeat fallback
+	ViolationUnpaired  = "unpaired"  // more people left out than the constraints force
+)
+
+// Violation is a broken invariant of a finished proposal
+type Violation struct {
+	Kind   string
+	Detail string
+}
+
+func (v Violation) String() string {
+	return v.Kind + ": " + v.Detail
+}
+
+// QualityError fails a run whose finished proposal breaks invariants. It carries the proposal, with
+// the processors' notes, and the snapshot it was checked against, so whoever is alerted can see how
+// the run got there.
+type QualityError struct {
+	Violations []Violation
+	Proposal   Proposal
+	Snapshot   Snapshot
+}
+
+func (e *QualityError) Error() string {
+	parts := make([]string, 0, len(e.Violations))
+	for _, v := range e.Violations {
+		parts = append(parts, v.String())
+	}
+	return fmt.Sprintf("%d quality violations: %s", len(e.Violations), strings.Join(parts, "; "))
+}
+
+// CheckQuality checks a finished proposal against the invariants every run must keep, whatever
+// matched and post-processed it: nobody meets themselves or is placed twice, meetings have 2 or 3
+// members drawn from the participants, excluded couples never meet, couples meet again only when
+// the proposal says the run relaxed the history, and no more than tolerance people beyond the
+// theoretical minimum are left unpaired. It returns every violation found, none for a good proposal.
+func CheckQuality(proposal Proposal, snapshot Snapshot, tolerance int) []Violation {
+	var violations []Violation
+	report := func(kind, format string, args ...any) {
+		violations = append(violations, Violation{Kind: kind, Detail: fmt.Sprintf(format, args...)})
+	}
+
+	participants := make(map[int64]bool, len(snapshot.Participants))
+	for _, id := range snapshot.Participants {
+		participants[id] = true
+	}
+	excluded := coupleSet(snapshot.Excluded)
+	met := coupleSet(snapshot.Met)
+
+	seen := make(map[int64]bool, len(snapshot.Participants))
+	place := func(id int64) {
+		switch {
+		case !participants[id]:
+			report(ViolationStructure, "user %d is not a participant", id)
+		case seen[id]:
+			report(ViolationDuplicate, "user %d is placed more than once", id)
+		}
+		seen[id] = true
+	}
+
+	for _, m := range proposal.Meetings {
+		if len(m) < 2 || len(m) > 3 {
+			report(ViolationStructure, "meeting %v has %d members, want 2 or 3", m, len(m))
+		}
+		inMeeting := make(map[int64]bool, len(m))
+		for i, a := range m {
+			if inMeeting[a] {
+				report(ViolationSelfPair, "user %d meets themselves in %v", a, m)
+				continue
+			}
+			inMeeting[a] = true
+			place(a)
+
+			for _, b := range m[i+1:] {
+				couple := [2]int64{min(a, b), max(a, b)}
+				switch {
+				case a == b:
+				case excluded[couple]:
+					report(ViolationExcluded, "%d and %d are excluded but meet in %v", a, b, m)
+				case met[couple] && !proposal.RelaxedHistory:
+					report(ViolationRepeat, "%d and %d met before and the repeat fallback was not engaged", a, b)
+				}
+			}
+		}
+	}
+	for _, id := range proposal.Unpaired {
+		place(id)
+	}
+	for _, id := range snapshot.Participants {
+		if !seen[id] {
+			report(ViolationStructure, "participant %d is neither in a meeting nor unpaired", id)
+		}
+	}
+
+	if least := MinUnpaired(snapshot, proposal.RelaxedHistory); len(proposal.Unpaired) > least+tolerance {
+		report(ViolationUnpaired, "%d unpaired, at least %d are unavoidable (tolerance %d)", len(proposal.Unpaired), least, tolerance)
+	}
+	return violations
+}
+
+// MinUnpaired returns a lower bound on how many participants any proposal must leave unpaired,
+// given that excluded couples never meet and, without relaxed history, couples that met never meet
+// again. Every meeting holds at least one couple of a matching, so with a maximum matching of size
+// m at most 3m people can be placed.
+func MinUnpaired(snapshot Snapshot, relaxedHistory bool) int {
+	excluded := coupleSet(snapshot.Excluded)
+	met := coupleSet(snapshot.Met)
+
+	n := len(snapshot.Participants)
+	var edges [][2]int
+	for i, a := range snapshot.Participants {
+		for j := i + 1; j < n; j++ {
+			b := snapshot.Participants[j]
+			couple := [2]int64{min(a, b), max(a, b)}
+			if excluded[couple] || (met[couple] && !relaxedHistory) {
+				continue
+			}
+			edges = append(edges, [2]int{i, j})
+		}
+	}
+
+	matched := 0
+	for i, j := range matching.Maximum(n, edges) {
+		if j != -1 && i < j {
+			matched++
+		}
+	}
+	return max(0, n-3*matched)
+}
+
+// coupleSet indexes couples by their smaller ID first
+func coupleSet(couples [][2]int64) map[[2]int64]bool {
+	set := make(map[[2]int64]bool, len(couples))
+	for _, c := range couples {
+		set[[2]int64{min(c[0], c[1]), max(c[0], c[1])}] = true
+	}
+	return set
+}
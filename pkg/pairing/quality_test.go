@@ -0,0 +1,95 @@
+package pairing
+
+import (
+	"testing"
+)
+
+func TestCheckQualityDetectsEachViolation(t *testing.T) {
+	base := Snapshot{Participants: []int64{1, 2, 3, 4, 5}}
+
+	tests := []struct {
+		name     string
+		proposal Proposal
+		snapshot Snapshot
+		want     string
+	}{
+		{"self pair", Proposal{Meetings: [][]int64{{1, 1}, {2, 3}, {4, 5}}}, base, ViolationSelfPair},
+		{"user in two meetings", Proposal{Meetings: [][]int64{{1, 2}, {1, 3}, {4, 5}}}, base, ViolationDuplicate},
+		{"meeting and unpaired", Proposal{Meetings: [][]int64{{1, 2}, {3, 4, 5}}, Unpaired: []int64{2}}, base, ViolationDuplicate},
+		{"meeting of one", Proposal{Meetings: [][]int64{{1}, {2, 3}, {4, 5}}}, base, ViolationStructure},
+		{"meeting of four", Proposal{Meetings: [][]int64{{1, 2, 3, 4}}, Unpaired: []int64{5}}, base, ViolationStructure},
+		{"stranger", Proposal{Meetings: [][]int64{{1, 2}, {3, 4, 5}}, Unpaired: []int64{9}}, base, ViolationStructure},
+		{"participant missing", Proposal{Meetings: [][]int64{{1, 2}, {3, 4}}}, base, ViolationStructure},
+		{"excluded couple", Proposal{Meetings: [][]int64{{1, 2}, {3, 4, 5}}},
+			Snapshot{Participants: base.Participants, Excluded: [][2]int64{{2, 1}}}, ViolationExcluded},
+		{"excluded couple in a trio", Proposal{Meetings: [][]int64{{1, 2}, {3, 4, 5}}},
+			Snapshot{Participants: base.Participants, Excluded: [][2]int64{{3, 5}}}, ViolationExcluded},
+		{"repeat without fallback", Proposal{Meetings: [][]int64{{1, 2}, {3, 4, 5}}},
+			Snapshot{Participants: base.Participants, Met: [][2]int64{{1, 2}}}, ViolationRepeat},
+		{"too many unpaired", Proposal{Meetings: [][]int64{{1, 2}}, Unpaired: []int64{3, 4, 5}}, base, ViolationUnpaired},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			violations := CheckQuality(tt.proposal, tt.snapshot, 0)
+			for _, v := range violations {
+				if v.Kind == tt.want {
+					return
+				}
+			}
+			t.Fatalf("CheckQuality = %v, want a %s violation", violations, tt.want)
+		})
+	}
+}
+
+func TestCheckQualityAcceptsGoodProposals(t *testing.T) {
+	tests := []struct {
+		name      string
+		proposal  Proposal
+		snapshot  Snapshot
+		tolerance int
+	}{
+		{"pairs and a trio", Proposal{Meetings: [][]int64{{1, 2}, {3, 4, 5}}},
+			Snapshot{Participants: []int64{1, 2, 3, 4, 5}}, 0},
+		{"repeat with fallback", Proposal{Meetings: [][]int64{{1, 2}}, RelaxedHistory: true},
+			Snapshot{Participants: []int64{1, 2}, Met: [][2]int64{{1, 2}}}, 0},
+		{"unpaired within tolerance", Proposal{Meetings: [][]int64{{1, 2}}, Unpaired: []int64{3, 4}},
+			Snapshot{Participants: []int64{1, 2, 3, 4}}, 2},
+		{"unavoidable unpaired", Proposal{Unpaired: []int64{1, 2, 3}},
+			Snapshot{Participants: []int64{1, 2, 3}, Excluded: [][2]int64{{1, 2}, {1, 3}, {2, 3}}}, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if violations := CheckQuality(tt.proposal, tt.snapshot, tt.tolerance); len(violations) > 0 {
+				t.Fatalf("CheckQuality = %v, want none", violations)
+			}
+		})
+	}
+}
+
+func TestMinUnpaired(t *testing.T) {
+	tests := []struct {
+		name     string
+		snapshot Snapshot
+		relaxed  bool
+		want     int
+	}{
+		{"nobody", Snapshot{}, false, 0},
+		{"alone", Snapshot{Participants: []int64{1}}, false, 1},
+		{"odd number fits a trio", Snapshot{Participants: []int64{1, 2, 3, 4, 5}}, false, 0},
+		{"everyone met", Snapshot{Participants: []int64{1, 2, 3}, Met: [][2]int64{{1, 2}, {1, 3}, {2, 3}}}, false, 3},
+		{"everyone met, fallback engaged", Snapshot{Participants: []int64{1, 2, 3}, Met: [][2]int64{{1, 2}, {1, 3}, {2, 3}}}, true, 0},
+		{"excluded stay excluded with fallback", Snapshot{Participants: []int64{1, 2}, Excluded: [][2]int64{{1, 2}}}, true, 2},
+		// Everyone may only meet 1: one couple, so at most a trio
+		{"star", Snapshot{Participants: []int64{1, 2, 3, 4}, Excluded: [][2]int64{{3, 4}, {2, 3}, {2, 4}}}, false, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := MinUnpaired(tt.snapshot, tt.relaxed); got != tt.want {
+				t.Fatalf("MinUnpaired = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}